@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Token required by the admin endpoints. Admin endpoints are disabled when unset.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// adminAuthMiddleware restricts access to callers presenting the admin token
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Admin endpoints are disabled"})
+			return
+		}
+
+		if !adminAuthorized(c) {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// adminAuthorized reports whether the request presents the admin token
+func adminAuthorized(c *gin.Context) bool {
+	if adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
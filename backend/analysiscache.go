@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version of the analysis prompts. Bump it whenever a prompt change affects
+// the reports, with the changed templates in a new templates/prompts
+// directory: cached analyses of older versions are then served as stale and
+// regenerated in the background.
+const promptVersion = 5
+
+var (
+	// Analyses kept in memory, 0 disables the cache
+	analysisCacheSize = envInt("ANALYSIS_CACHE_SIZE", 512)
+
+	// Directory analyses are also written to, so that they survive
+	// restarts. Empty keeps them in memory only.
+	analysisCacheDir = os.Getenv("ANALYSIS_CACHE_DIR")
+
+	// Serves of a stale analysis after which it is regenerated even when
+	// the client did not ask for it
+	staleRevalidateAfter = envInt("STALE_REVALIDATE_AFTER_HITS", 3)
+)
+
+var (
+	staleServes            atomic.Int64
+	revalidationsStarted   atomic.Int64
+	revalidationsCompleted atomic.Int64
+	revalidationsFailed    atomic.Int64
+)
+
+// cachedAnalysis is a stored analysis and the prompt version it was
+// generated with. Each regeneration replaces it with a new revision.
+type cachedAnalysis struct {
+	ReportID      string
+	Markdown      string
+	InputMode     string
+	PromptVersion int
+	Revision      int
+	GeneratedAt   time.Time
+
+	hits         int
+	revalidating bool
+
+	// Report IDs of the clients that received this analysis, by client
+	// token: each client gets a report of its own, and only they may learn
+	// that the analysis exists
+	clients map[string]string
+}
+
+// Stale reports whether the analysis was generated by a prompt version
+// new analyses are no longer generated with: an older one, or a candidate
+// whose evaluation ended
+func (a cachedAnalysis) Stale() bool {
+	return !servedPromptVersion(a.PromptVersion)
+}
+
+// analysisCacheKey identifies the analyses of an assessment. The reference
+// profile, the base of a partial retake, the requested model, the tone, the
+// normative dataset and the timezone dates are written in are not part of
+// the assessment hash but change the analysis.
+func analysisCacheKey(hash string, data AssessmentData) string {
+	key := hash + "/" + referenceProfileMetadata(data).Key
+	if data.Lineage != nil {
+		key += "/retake/" + data.Lineage.BaseReportID
+	}
+	if data.Model != "" {
+		key += "/model/" + data.Model
+	}
+	if tone := reportTone(data); tone != toneClinical {
+		key += "/tone/" + tone
+	}
+	if normativeDataset.Key != defaultNormativeDataset {
+		key += "/norms/" + normativeDataset.Key
+	}
+	if sampling := samplingCacheKey(data.Sampling); sampling != "" {
+		key += "/sampling/" + sampling
+	}
+	if data.Metadata.Timezone != "" {
+		key += "/timezone/" + data.Metadata.Timezone
+	}
+	return key
+}
+
+// analysisStore keeps analyses in memory, evicting the oldest when full,
+// and in its directory when it has one
+type analysisStore struct {
+	mu      sync.Mutex
+	entries map[string]*cachedAnalysis
+	dir     string
+}
+
+var analysisCache = &analysisStore{entries: make(map[string]*cachedAnalysis), dir: analysisCacheDir}
+
+// persistedAnalysis is the file of an analysis in the cache directory.
+// Grants are not persisted: after a restart, clients find their analyses
+// again by sending the assessment.
+type persistedAnalysis struct {
+	Key string
+	cachedAnalysis
+}
+
+// analysisFile is the file of an analysis key in the cache directory
+func (s *analysisStore) analysisFile(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load reads the analyses of the cache directory, keeping the most recent
+// ones when there are more than the cache holds, and returns how many it
+// kept. Unreadable files are skipped.
+func (s *analysisStore) Load() (int, error) {
+	if s.dir == "" || analysisCacheSize <= 0 {
+		return 0, nil
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return 0, fmt.Errorf("failed to create analysis cache directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list analysis cache directory: %w", err)
+	}
+
+	var loaded []persistedAnalysis
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("⚠️  Skipping cached analysis %s: %v", filepath.Base(file), err)
+			continue
+		}
+		var entry persistedAnalysis
+		if err := json.Unmarshal(content, &entry); err != nil || entry.Key == "" || s.analysisFile(entry.Key) != file {
+			log.Printf("⚠️  Skipping invalid cached analysis %s", filepath.Base(file))
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].GeneratedAt.After(loaded[j].GeneratedAt) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, entry := range loaded {
+		if i >= analysisCacheSize {
+			s.removeFileLocked(entry.Key)
+			continue
+		}
+		cached := entry.cachedAnalysis
+		s.entries[entry.Key] = &cached
+	}
+	return len(s.entries), nil
+}
+
+// writeFileLocked persists an analysis, replacing its previous revision
+// atomically. Failures are logged: the analysis is still cached in memory.
+func (s *analysisStore) writeFileLocked(key string, entry *cachedAnalysis) {
+	if s.dir == "" {
+		return
+	}
+	content, err := json.Marshal(persistedAnalysis{Key: key, cachedAnalysis: *entry})
+	if err == nil {
+		file := s.analysisFile(key)
+		if err = os.WriteFile(file+".tmp", content, 0o600); err == nil {
+			err = os.Rename(file+".tmp", file)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to persist cached analysis: %v", err)
+	}
+}
+
+// removeFileLocked deletes the file of an evicted analysis
+func (s *analysisStore) removeFileLocked(key string) {
+	if s.dir == "" {
+		return
+	}
+	if err := os.Remove(s.analysisFile(key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️  Failed to remove cached analysis: %v", err)
+	}
+}
+
+// Lookup returns a cached analysis, and whether the caller should start its
+// regeneration: it is stale, not already being regenerated, and either the
+// client asked for it or it was served often enough
+func (s *analysisStore) Lookup(key string, revalidate bool) (cachedAnalysis, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return cachedAnalysis{}, false, false
+	}
+
+	entry.hits++
+	if !entry.Stale() {
+		return *entry, false, true
+	}
+	staleServes.Add(1)
+	start := !entry.revalidating && (revalidate || entry.hits > staleRevalidateAfter)
+	if start {
+		entry.revalidating = true
+	}
+	return *entry, start, true
+}
+
+// Store records an analysis generated with a prompt version as the next
+// revision for its key
+func (s *analysisStore) Store(key, reportID, markdown, inputMode string, version int) cachedAnalysis {
+	entry := &cachedAnalysis{
+		ReportID:      reportID,
+		Markdown:      markdown,
+		InputMode:     inputMode,
+		PromptVersion: version,
+		Revision:      1,
+		GeneratedAt:   time.Now().UTC(),
+	}
+	if analysisCacheSize <= 0 {
+		return *entry
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.entries[key]; ok {
+		entry.Revision = previous.Revision + 1
+		entry.clients = previous.clients
+	} else if len(s.entries) >= analysisCacheSize {
+		s.evictOldestLocked()
+	}
+	s.entries[key] = entry
+	s.writeFileLocked(key, entry)
+	return *entry
+}
+
+// Grant lets a client find out later that the analysis exists, without
+// sending the assessment again, under the ID of its report
+func (s *analysisStore) Grant(key, client, reportID string) {
+	if client == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	if entry.clients == nil {
+		entry.clients = make(map[string]string)
+	}
+	entry.clients[client] = reportID
+}
+
+// ClientReportID returns the ID of the report of a cached analysis granted
+// to a client
+func (s *analysisStore) ClientReportID(key, client string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || client == "" {
+		return "", false
+	}
+	reportID, ok := entry.clients[client]
+	return reportID, ok
+}
+
+// Grants returns the report IDs of the clients an analysis was granted to,
+// by client token
+func (s *analysisStore) Grants(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grants := map[string]string{}
+	if entry, ok := s.entries[key]; ok {
+		for client, reportID := range entry.clients {
+			grants[client] = reportID
+		}
+	}
+	return grants
+}
+
+// Peek returns a cached analysis granted to a client, under the ID of the
+// report of the client, without counting it as served
+func (s *analysisStore) Peek(key, client string) (cachedAnalysis, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return cachedAnalysis{}, false
+	}
+	reportID, ok := entry.clients[client]
+	if !ok {
+		return cachedAnalysis{}, false
+	}
+	peeked := *entry
+	peeked.ReportID = reportID
+	return peeked, true
+}
+
+// evictOldestLocked removes the analysis generated the longest ago
+func (s *analysisStore) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range s.entries {
+		if oldestKey == "" || entry.GeneratedAt.Before(oldest) {
+			oldestKey, oldest = key, entry.GeneratedAt
+		}
+	}
+	delete(s.entries, oldestKey)
+	s.removeFileLocked(oldestKey)
+}
+
+// Revalidate regenerates a stale analysis in the background with a batch
+// worker slot, so that it never delays interactive requests. The stale
+// revision keeps being served until the new one is stored, along with the
+// reports of the clients it was granted to.
+func (s *analysisStore) Revalidate(key, hash string, data AssessmentData, credential *claudeCredential) {
+	revalidationsStarted.Add(1)
+	go func() {
+		reportID := uuid.New().String()
+		ctx := withRequestedModel(context.WithValue(context.Background(), credentialKey{}, credential), data)
+		version := assessmentPromptVersion(data)
+		markdown, mode, err := s.regenerate(withReportUsage(ctx, reportID), data, version)
+		if err != nil {
+			revalidationsFailed.Add(1)
+			log.Printf("⚠️  Background regeneration of a stale analysis failed: %v", err)
+			s.mu.Lock()
+			if entry, ok := s.entries[key]; ok {
+				entry.revalidating = false
+			}
+			s.mu.Unlock()
+			return
+		}
+		revalidationsCompleted.Add(1)
+		entry := s.Store(key, reportID, markdown, mode, version)
+		for client, clientReportID := range s.Grants(key) {
+			saveReport(ctx, StoredReport{ReportID: clientReportID, AssessmentHash: hash, Assessment: data, Markdown: markdown, PromptVersion: version, Owner: reportOwner(client)})
+		}
+		log.Printf("🔄 Stale analysis regenerated as revision %d with prompt version %d", entry.Revision, entry.PromptVersion)
+	}()
+}
+
+func (s *analysisStore) regenerate(ctx context.Context, data AssessmentData, version int) (string, string, error) {
+	slot, err := workers.Acquire(ctx, classBatch)
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, generationMaxDuration)
+	defer cancel()
+
+	input, err := prepareAssessmentInput(ctx, data)
+	if err != nil {
+		slot.Release(false)
+		return "", "", err
+	}
+	markdown, err := generateMarkdownReportWithClaude(ctx, data, input, version)
+	if err == nil && usedTemplateFallback(ctx) {
+		// Keep serving the stale analysis rather than a summary of the scores
+		err = errAIUnavailable
+	}
+	slot.Release(err == nil)
+	return markdown, input.Mode, err
+}
+
+// Stats counts cached analyses per prompt version, to follow the progress
+// of a prompt upgrade
+func (s *analysisStore) Stats() (current, stale int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.Stale() {
+			stale++
+		} else {
+			current++
+		}
+	}
+	return current, stale
+}
+
+// checkAnalysisCache verifies that analyses can be written to and read back
+// from the cache directory
+func checkAnalysisCache() checkResult {
+	result := checkResult{Name: "analysis cache", Feature: "analysis"}
+	if analysisCacheSize <= 0 {
+		result.OK = true
+		result.Detail = "disabled"
+		return result
+	}
+	if analysisCacheDir == "" {
+		result.OK = true
+		result.Detail = fmt.Sprintf("%d analyses in memory, lost on restart", analysisCacheSize)
+		return result
+	}
+
+	if err := os.MkdirAll(analysisCacheDir, 0o700); err != nil {
+		result.Detail = "ANALYSIS_CACHE_DIR is not writable: " + err.Error()
+		return result
+	}
+	probe, err := os.CreateTemp(analysisCacheDir, ".check-")
+	if err != nil {
+		result.Detail = "ANALYSIS_CACHE_DIR is not writable: " + err.Error()
+		return result
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("%d analyses, persisted in %s", analysisCacheSize, analysisCacheDir)
+	return result
+}
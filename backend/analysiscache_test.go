@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAnalysisStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+	key := "test/" + strings.Repeat("0", 64)
+	written := &analysisStore{entries: make(map[string]*cachedAnalysis), dir: dir}
+	written.Store(key, "report", "## Analysis", inputModeInline, promptVersion)
+	written.Store(key, "report", "## Revised analysis", inputModeInline, promptVersion)
+
+	read := &analysisStore{entries: make(map[string]*cachedAnalysis), dir: dir}
+	if loaded, err := read.Load(); err != nil || loaded != 1 {
+		t.Fatalf("loaded %d analyses: %v", loaded, err)
+	}
+	entry, _, ok := read.Lookup(key, false)
+	if !ok || entry.Markdown != "## Revised analysis" || entry.Revision != 2 || entry.PromptVersion != promptVersion {
+		t.Errorf("the stored analysis is read back as %+v", entry)
+	}
+}
+
+func TestAnalysisStoreGrants(t *testing.T) {
+	store := &analysisStore{entries: make(map[string]*cachedAnalysis)}
+	store.Store("key", "report", "## Analysis", inputModeInline, promptVersion)
+	store.Grant("key", "client", "client-report")
+
+	tests := []struct {
+		key, client string
+		found       bool
+	}{
+		{"key", "client", true},
+		{"key", "other", false},
+		{"key", "", false},
+		{"other", "client", false},
+	}
+	for _, tc := range tests {
+		entry, found := store.Peek(tc.key, tc.client)
+		if found != tc.found {
+			t.Errorf("%s peeked by %q: found %t, want %t", tc.key, tc.client, found, tc.found)
+		}
+		if found && entry.ReportID != "client-report" {
+			t.Errorf("%s peeked by %q as report %s", tc.key, tc.client, entry.ReportID)
+		}
+	}
+}
+
+// TestAnalysisCacheKeyTimezone makes sure assessments whose dates are
+// written in different timezones do not share their analyses
+func TestAnalysisCacheKeyTimezone(t *testing.T) {
+	keys := map[string]bool{}
+	for _, timezone := range []string{"", "Europe/Paris", "Pacific/Kiritimati"} {
+		keys[analysisCacheKey("hash", AssessmentData{Metadata: Metadata{Timezone: timezone}})] = true
+	}
+	if len(keys) != 3 {
+		t.Errorf("the timezones share cache keys: %v", keys)
+	}
+}
+
+// TestRevalidateStoresReports makes sure a stale analysis regenerated in
+// the background replaces the stored reports of the clients it was granted
+// to
+func TestRevalidateStoresReports(t *testing.T) {
+	startFakeClaude(t)
+	store := &fileReportStore{dir: t.TempDir()}
+	useReportStore(t, store)
+	var data AssessmentData
+	if err := json.Unmarshal(answeredAssessment(t, "TestRevalidateStoresReports"), &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateAssessmentData(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := assessmentHash(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &analysisStore{entries: make(map[string]*cachedAnalysis)}
+	key := analysisCacheKey(hash, data)
+	cache.Store(key, "report", "## Stale analysis", inputModeInline, promptVersion-1)
+	clientReportID := uuid.New().String()
+	cache.Grant(key, "client", clientReportID)
+	saveReport(context.Background(), StoredReport{ReportID: clientReportID, AssessmentHash: hash, Assessment: data, Markdown: "## Stale analysis", PromptVersion: promptVersion - 1, Owner: reportOwner("client")})
+
+	completed, failed := revalidationsCompleted.Load(), revalidationsFailed.Load()
+	cache.Revalidate(key, hash, data, credentialFrom(context.Background()))
+	for deadline := time.Now().Add(5 * time.Second); revalidationsCompleted.Load() == completed; time.Sleep(10 * time.Millisecond) {
+		if revalidationsFailed.Load() != failed || time.Now().After(deadline) {
+			t.Fatal("the stale analysis was not regenerated")
+		}
+	}
+
+	report, err := store.Get(context.Background(), clientReportID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PromptVersion != promptVersion || report.Markdown == "## Stale analysis" || report.Owner != reportOwner("client") {
+		t.Errorf("the stored report of the client was not regenerated: prompt version %d, owner %q, %q", report.PromptVersion, report.Owner, report.Markdown)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Text comments are replaced with, repeated as needed
+const anonymizedPlaceholder = "lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor "
+
+// anonymizeText replaces free text with placeholder text of the same byte
+// length, keeping whitespace where it was, so that length limits and
+// truncation apply exactly as they did to the original
+func anonymizeText(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	i := 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			b.WriteRune(r)
+			continue
+		}
+		for n := utf8.RuneLen(r); n > 0; n-- {
+			c := anonymizedPlaceholder[i%len(anonymizedPlaceholder)]
+			if c == ' ' {
+				c = 'x'
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func anonymizeComment(comment *string) *string {
+	if comment == nil {
+		return nil
+	}
+	anonymized := anonymizeText(*comment)
+	return &anonymized
+}
+
+// monthPrecision truncates a date to the first day of its month, in its
+// own offset so that the timezone checks are unaffected
+func monthPrecision(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// anonymizeAssessment returns a copy of a submission with its free text
+// replaced and its test date reduced to the month. Answers, scores and
+// everything else are kept as submitted.
+func anonymizeAssessment(data AssessmentData) AssessmentData {
+	anonymized := data
+	anonymized.AdditionalContext = anonymizeText(data.AdditionalContext)
+
+	if !data.Metadata.TestDate.IsZero() {
+		anonymized.Metadata.TestDate = monthPrecision(data.Metadata.TestDate)
+	}
+	if data.TestDate != nil {
+		testDate := monthPrecision(*data.TestDate)
+		anonymized.TestDate = &testDate
+	}
+
+	if data.QuestionsAndAnswers != nil {
+		anonymized.QuestionsAndAnswers = make([]QuestionAndAnswer, len(data.QuestionsAndAnswers))
+		for i, qa := range data.QuestionsAndAnswers {
+			qa.Comment = anonymizeComment(qa.Comment)
+			anonymized.QuestionsAndAnswers[i] = qa
+		}
+	}
+	if data.Answers != nil {
+		anonymized.Answers = make([]SubmittedAnswer, len(data.Answers))
+		for i, a := range data.Answers {
+			a.Comment = anonymizeComment(a.Comment)
+			anonymized.Answers[i] = a
+		}
+	}
+	return anonymized
+}
+
+// copyAssessment copies the slices validation modifies in place
+func copyAssessment(data AssessmentData) AssessmentData {
+	data.QuestionsAndAnswers = append([]QuestionAndAnswer(nil), data.QuestionsAndAnswers...)
+	data.Answers = append([]SubmittedAnswer(nil), data.Answers...)
+	return data
+}
+
+// skeletonHash hashes the structure of a validated assessment: language,
+// test month, answers, scores and comment lengths, but no text. An
+// assessment and its anonymized copy have the same skeleton.
+func skeletonHash(data AssessmentData) (string, error) {
+	answers := make([]any, 0, len(data.QuestionsAndAnswers))
+	for _, qa := range data.QuestionsAndAnswers {
+		commentLength := -1
+		if qa.Comment != nil {
+			commentLength = len(*qa.Comment)
+		}
+		answers = append(answers, []int{qa.ID, qa.Answer, qa.Score, commentLength})
+	}
+	content, err := json.Marshal(map[string]any{
+		"language":          data.Language,
+		"testMonth":         data.Metadata.LocalTestDate().Format("2006-01"),
+		"answers":           answers,
+		"scores":            data.Scores,
+		"additionalContext": len(data.AdditionalContext),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize assessment skeleton: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// validatedSkeletonHash validates a copy of a submission, as an analysis
+// request would, and hashes its skeleton
+func validatedSkeletonHash(ctx context.Context, data AssessmentData) (string, error) {
+	validated := copyAssessment(data)
+	if err := validateAssessmentData(ctx, &validated); err != nil {
+		return "", err
+	}
+	return skeletonHash(validated)
+}
+
+// anonymizeHandler returns a submission stripped of its personal content,
+// for users to attach to bug reports. The skeleton hash matches the one
+// returned with the analysis of the original submission.
+func anonymizeHandler(c *gin.Context) {
+	var data AssessmentData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	anonymized := anonymizeAssessment(data)
+	response := gin.H{"assessment": anonymized}
+
+	// Validation runs on copies, so that the payload is anonymized as
+	// submitted even when it does not validate
+	original, err := validatedSkeletonHash(c.Request.Context(), data)
+	if err != nil {
+		response["skeleton_hash"] = nil
+		response["validation_error"] = err.Error()
+		c.JSON(200, response)
+		return
+	}
+	if copied, err := validatedSkeletonHash(c.Request.Context(), anonymized); err != nil || copied != original {
+		log.Printf("⚠️  Anonymized assessment does not reproduce the original skeleton: %v", err)
+	}
+	response["skeleton_hash"] = original
+	c.JSON(200, response)
+}
+
+// AnonymizedItem is the answer and score of a question, without its text
+type AnonymizedItem struct {
+	ID     int    `json:"id"`
+	Domain string `json:"domain,omitempty"`
+	Answer *int   `json:"answer"`
+	Score  int    `json:"score"`
+}
+
+// AnonymizedScore is the total of a domain or subscale
+type AnonymizedScore struct {
+	Key   string `json:"key"`
+	Score int    `json:"score"`
+	Max   int    `json:"max"`
+}
+
+// AnonymizedExport is an assessment reduced to its item scores and domain
+// totals, safe to share with researchers or communities: no comments,
+// participant context, demographics, timezone or exact date
+type AnonymizedExport struct {
+	Instrument     string            `json:"instrument"`
+	Language       string            `json:"language"`
+	TestMonth      string            `json:"testMonth"`
+	Total          int               `json:"total"`
+	MaxTotal       int               `json:"maxTotal"`
+	Interpretation string            `json:"interpretation,omitempty"`
+	Domains        []AnonymizedScore `json:"domains"`
+	Items          []AnonymizedItem  `json:"items"`
+}
+
+// newAnonymizedExport keeps the item scores and domain, or subscale, totals
+// of a validated assessment. Unanswered questions have a null answer.
+func newAnonymizedExport(data AssessmentData) AnonymizedExport {
+	instrument := instrumentOf(data)
+	export := AnonymizedExport{
+		Instrument:     instrument.Key,
+		Language:       data.Language,
+		TestMonth:      data.Metadata.LocalTestDate().Format("2006-01"),
+		Total:          data.Scores.Total,
+		MaxTotal:       data.Scores.MaxTotal,
+		Interpretation: data.Interpretation.Severity,
+		Items:          make([]AnonymizedItem, 0, len(data.QuestionsAndAnswers)),
+	}
+	if instrument.Key == instrumentRAADSR {
+		totals := domainTotals(data)
+		for _, d := range raadsDomains {
+			export.Domains = append(export.Domains, AnonymizedScore{Key: d.Key, Score: totals[d.Key], Max: d.MaxScore()})
+		}
+	} else {
+		for _, s := range subscaleScores(data) {
+			export.Domains = append(export.Domains, AnonymizedScore{Key: s.Key, Score: s.Score, Max: s.Max})
+		}
+	}
+	for _, qa := range data.QuestionsAndAnswers {
+		item := AnonymizedItem{ID: qa.ID, Domain: answerGroup(instrument, qa), Score: qa.Score}
+		if qa.AnswerText != "" {
+			answer := qa.Answer
+			item.Answer = &answer
+		}
+		export.Items = append(export.Items, item)
+	}
+	return export
+}
+
+// exportAnonymizedHandler exports the item scores and domain totals of an
+// assessment, stripped of comments, free text and identifying metadata
+func exportAnonymizedHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	export := newAnonymizedExport(req.Assessment)
+	log.Printf("📦 Exporting %d anonymized answers", len(export.Items))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-anonymized-%s.json"`, export.Instrument, export.TestMonth))
+	c.JSON(200, export)
+}
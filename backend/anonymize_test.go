@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnonymizedExport(t *testing.T) {
+	comment := "my private comment"
+	data := AssessmentData{
+		Language:          "en",
+		Metadata:          Metadata{TestName: raadsR.Name, TestDate: time.Date(2024, 3, 17, 21, 30, 0, 0, time.UTC), Timezone: "Europe/Paris"},
+		AdditionalContext: "my private context",
+		Demographics:      &Demographics{Age: 61, Gender: "private-gender"},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "Sample", Category: "IS", AnswerText: "Never true", Answer: 3, Score: 3, Comment: &comment},
+		},
+	}
+	content, err := json.Marshal(newAnonymizedExport(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{"private", "Europe/Paris", "61", "2024-03-17", "Sample"} {
+		if strings.Contains(string(content), private) {
+			t.Errorf("the anonymized export contains %q", private)
+		}
+	}
+}
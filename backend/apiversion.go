@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version of the Anthropic API requested with every call. Bumping it
+// requires recording a stream fixture for the new version first.
+var anthropicVersion = envString("ANTHROPIC_VERSION", "2023-06-01")
+
+// Streams recorded from the Anthropic API, one directory per API version.
+// The stream parser is tested against every fixture, and the dry-start
+// checks that one was recorded for the configured version, so a version
+// without a fixture is never used unnoticed.
+//
+//go:embed fixtures/anthropic
+var apiFixtures embed.FS
+
+// Outcomes of the API version probe
+const (
+	apiVersionAccepted = "accepted"
+	apiVersionRejected = "rejected"
+	apiVersionUnknown  = "unknown"
+)
+
+// APIVersionStatus is the last known compatibility of the configured
+// anthropic-version with the API
+type APIVersionStatus struct {
+	Version    string    `json:"version"`
+	Status     string    `json:"status"`
+	Deprecated bool      `json:"deprecated"`
+	Detail     string    `json:"detail,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+var apiVersion = struct {
+	mu     sync.Mutex
+	status APIVersionStatus
+}{status: APIVersionStatus{Status: apiVersionUnknown}}
+
+// currentAPIVersionStatus returns the result of the last probe
+func currentAPIVersionStatus() APIVersionStatus {
+	apiVersion.mu.Lock()
+	defer apiVersion.mu.Unlock()
+	status := apiVersion.status
+	status.Version = anthropicVersion
+	return status
+}
+
+// probeAPIVersion sends a cheap authenticated request with the configured
+// version and records whether the API accepts it
+func probeAPIVersion(ctx context.Context) APIVersionStatus {
+	status := APIVersionStatus{Version: anthropicVersion, Status: apiVersionUnknown, CheckedAt: time.Now().UTC()}
+	defer func() {
+		apiVersion.mu.Lock()
+		apiVersion.status = status
+		apiVersion.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", claudeBaseURL+"/v1/models?limit=1", nil)
+	if err != nil {
+		status.Detail = err.Error()
+		return status
+	}
+	credential := claudeCredentials.Any()
+	if credential == nil {
+		status.Detail = errNoCredentials.Error()
+		return status
+	}
+	req.Header.Set("x-api-key", credential.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		status.Detail = err.Error()
+		return status
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	status.RequestID = resp.Header.Get("request-id")
+	status.Deprecated = resp.Header.Get("Deprecation") != "" ||
+		strings.Contains(strings.ToLower(resp.Header.Get("Warning")), "deprecat")
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		status.Status = apiVersionAccepted
+	case resp.StatusCode == http.StatusBadRequest && bytes.Contains(body, []byte("anthropic-version")):
+		status.Status = apiVersionRejected
+		status.Detail = apiErrorMessage(body)
+	default:
+		// Authentication or availability issues say nothing about the version
+		status.Detail = fmt.Sprintf("HTTP %d: %s", resp.StatusCode, apiErrorMessage(body))
+	}
+	return status
+}
+
+// apiErrorMessage extracts the message of an Anthropic error body
+func apiErrorMessage(body []byte) string {
+	var decoded struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &decoded) == nil && decoded.Error.Message != "" {
+		return decoded.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// checkAPIVersionFixture makes sure a stream was recorded for the
+// configured version, which the tests parse
+func checkAPIVersionFixture() error {
+	if _, err := fs.Stat(apiFixtures, "fixtures/anthropic/"+anthropicVersion+"/stream.sse"); err != nil {
+		return fmt.Errorf("no recorded stream fixture for anthropic-version %s", anthropicVersion)
+	}
+	return nil
+}
+
+func checkAnthropicVersion() checkResult {
+	result := checkResult{Name: "Anthropic API version", Feature: "analysis"}
+	if activeProvider != providerAnthropic {
+		result.OK = true
+		result.Detail = "not used by the " + activeProvider + " provider"
+		return result
+	}
+	if err := checkAPIVersionFixture(); err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+
+	status := probeAPIVersion(context.Background())
+	switch status.Status {
+	case apiVersionRejected:
+		result.Detail = fmt.Sprintf("%s rejected: %s", status.Version, status.Detail)
+		return result
+	case apiVersionUnknown:
+		result.OK = true
+		result.Detail = fmt.Sprintf("%s not verified: %s", status.Version, status.Detail)
+		return result
+	}
+	result.OK = true
+	result.Detail = status.Version + " accepted"
+	if status.Deprecated {
+		result.Detail += " (deprecated)"
+		log.Printf("⚠️  anthropic-version %s is deprecated, record a fixture for a newer version and bump ANTHROPIC_VERSION", status.Version)
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"raads-pdf-backend/claudestream"
+)
+
+// TestAPIVersionFixtures parses the stream recorded for every API version
+// and makes sure it still yields text, usage and a stop reason
+func TestAPIVersionFixtures(t *testing.T) {
+	fixtures, err := fs.Glob(apiFixtures, "fixtures/anthropic/*/stream.sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no recorded stream fixture")
+	}
+	for _, fixture := range fixtures {
+		t.Run(path.Base(path.Dir(fixture)), func(t *testing.T) {
+			f, err := apiFixtures.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var text strings.Builder
+			var usage, stop bool
+			err = claudestream.NewReader(f).Read(func(event claudestream.Event) error {
+				switch e := event.(type) {
+				case claudestream.TextDelta:
+					text.WriteString(e.Text)
+				case claudestream.Usage:
+					usage = true
+				case claudestream.Stop:
+					stop = e.Reason != ""
+				}
+				return nil
+			})
+			switch {
+			case err != nil:
+				t.Fatalf("the fixture does not parse: %v", err)
+			case text.Len() == 0 || !usage || !stop:
+				t.Errorf("the fixture is missing text (%d bytes), usage (%t) or a stop reason (%t)", text.Len(), usage, stop)
+			}
+		})
+	}
+}
+
+func TestAPIVersionFixtureConfigured(t *testing.T) {
+	if err := checkAPIVersionFixture(); err != nil {
+		t.Error(err)
+	}
+	previous := anthropicVersion
+	anthropicVersion = "1970-01-01"
+	t.Cleanup(func() { anthropicVersion = previous })
+	if checkAPIVersionFixture() == nil {
+		t.Error("a version without a fixture is accepted")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// aq50 is the Autism Spectrum Quotient of Baron-Cohen et al. (2001), on its
+// four point forced choice scale
+var aq50 = TestDefinition{
+	Name: "AQ-50",
+	ScaleLabels: map[string][]AnswerOption{
+		"en": {
+			{0, "A", "Definitely agree"},
+			{1, "B", "Slightly agree"},
+			{2, "C", "Slightly disagree"},
+			{3, "D", "Definitely disagree"},
+		},
+	},
+}
+
+// Subscales of the AQ-50, ten items each
+var aq50Subscales = []Subscale{
+	{Key: "social", Name: "Social Skill", Category: "SS", Items: 10},
+	{Key: "switching", Name: "Attention Switching", Category: "AS", Items: 10},
+	{Key: "detail", Name: "Attention to Detail", Category: "AD", Items: 10},
+	{Key: "communication", Name: "Communication", Category: "C", Items: 10},
+	{Key: "imagination", Name: "Imagination", Category: "IM", Items: 10},
+}
+
+const (
+	// Highest possible value of the AQ-50 total score, one point per item
+	aq50MaxTotal = 50
+	// Cut-off of Baron-Cohen et al. (2001), reached by 80% of the autistic
+	// adults and 2% of the controls of the study
+	aq50Threshold = 32
+	// Mean total score of the controls of Baron-Cohen et al. (2001)
+	aq50ControlMean = 16.4
+)
+
+// AQ-50 interpretation rules, the last one starting at the cut-off
+var aq50InterpretationRules = []interpretationRule{
+	{"low", 0, 10},
+	{"average", 11, 21},
+	{"above", 22, 31},
+	{"high", aq50Threshold, aq50MaxTotal},
+}
+
+// aq50Instrument is the AQ-50 for instrumentOf
+func aq50Instrument() Instrument {
+	return Instrument{
+		Key:              instrumentAQ50,
+		Test:             aq50,
+		MaxTotal:         aq50MaxTotal,
+		Threshold:        aq50Threshold,
+		Rules:            aq50InterpretationRules,
+		Subscales:        aq50Subscales,
+		ItemMax:          1,
+		AnalysisTemplate: "aq50.tmpl",
+		Catalog:          aq50CatalogFor,
+		ItemScore:        aq50ItemScore,
+		Scores:           aq50ScoresFromItems,
+		Details:          aq50Details,
+		TemplateMarkdown: aq50TemplateMarkdown,
+	}
+}
+
+var aq50Catalogs instrumentCatalogs
+
+// aq50CatalogFor returns the AQ-50 catalog of a language
+func aq50CatalogFor(language string) (*LanguageCatalog, error) {
+	return aq50Catalogs.get(instrumentAQ50, aq50, language, func(code string, catalog *LanguageCatalog) error {
+		for _, q := range catalog.Questions {
+			if _, ok := aq50SubscaleForCategory(q.Category); !ok {
+				return fmt.Errorf("question %d of the %s AQ-50 catalog has unknown category %q", q.ID, code, q.Category)
+			}
+		}
+		for _, rule := range aq50InterpretationRules {
+			if _, ok := catalog.Interpretations[rule.Key]; !ok {
+				return fmt.Errorf("the %s AQ-50 catalog has no %q interpretation", code, rule.Key)
+			}
+		}
+		for _, s := range aq50Subscales {
+			if catalog.Labels.Domains[s.Key] == "" {
+				return fmt.Errorf("the %s AQ-50 catalog has no label for the %s subscale", code, s.Key)
+			}
+		}
+		return nil
+	})
+}
+
+// aq50SubscaleForCategory returns the subscale a question category belongs to
+func aq50SubscaleForCategory(category string) (Subscale, bool) {
+	for _, s := range aq50Subscales {
+		if s.Category == category {
+			return s, true
+		}
+	}
+	return Subscale{}, false
+}
+
+// aq50ItemScore scores an answer: one point for agreeing with an item, or
+// for disagreeing with a reverse item, whether slightly or definitely
+func aq50ItemScore(q CatalogQuestion, answer int) int {
+	agrees := answer <= 1
+	if agrees != q.Reverse {
+		return 1
+	}
+	return 0
+}
+
+// aq50ScoresFromItems sums item scores into the total score. Subscale
+// scores are derived from the categories by subscaleScores.
+func aq50ScoresFromItems(qas []QuestionAndAnswer) Scores {
+	scores := Scores{MaxTotal: aq50MaxTotal}
+	for _, qa := range qas {
+		scores.Total += qa.Score
+	}
+	return scores
+}
+
+// aq50Details are the subscale scores of the responses
+func aq50Details(data AssessmentData) gin.H {
+	return gin.H{"subscales": subscaleScores(data)}
+}
+
+// aq50TemplateMarkdown is templateMarkdown for the AQ-50, which has no
+// domain thresholds or charts
+func aq50TemplateMarkdown(data AssessmentData) (string, error) {
+	var md strings.Builder
+	md.WriteString("## Executive Summary\n\n")
+	md.WriteString("> The AI analysis service is currently unavailable. This report only summarizes your scores; generate the analysis again later for a detailed interpretation.\n\n")
+	fmt.Fprintf(&md, "Your total AQ-50 score is **%d out of %d**. The cut-off is %d, and adults without autism score %.1f on average.", data.Scores.Total, aq50MaxTotal, aq50Threshold, aq50ControlMean)
+	if data.Interpretation.Level != "" {
+		fmt.Fprintf(&md, " Interpretation: **%s**", data.Interpretation.Level)
+		if data.Interpretation.Description != "" {
+			fmt.Fprintf(&md, ", %s", data.Interpretation.Description)
+		}
+		md.WriteString(".")
+	}
+	md.WriteString("\n\n")
+
+	md.WriteString("## Subscale Scores\n\n")
+	for _, s := range subscaleScores(data) {
+		fmt.Fprintf(&md, "- **%s**: %d / %d\n", s.Label, s.Score, s.Max)
+	}
+	md.WriteString("\nEach item scores one point when the answer leans towards autistic traits, whether slightly or definitely.\n\n")
+
+	md.WriteString("## Next Steps\n\n")
+	md.WriteString("The AQ-50 is a screening tool, not a diagnosis. Only a qualified clinician can assess autism, taking your history and current situation into account.\n")
+	return md.String(), nil
+}
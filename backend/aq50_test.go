@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// TestAQ50Catalog makes sure the AQ-50 catalog lists the ten items of each
+// subscale, 24 of them scoring on agreement and 26 on disagreement
+func TestAQ50Catalog(t *testing.T) {
+	catalog, err := aq50CatalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Questions) != aq50MaxTotal {
+		t.Fatalf("the catalog has %d questions instead of %d", len(catalog.Questions), aq50MaxTotal)
+	}
+	items := map[string]int{}
+	agreeScored := 0
+	for _, q := range catalog.Questions {
+		s, _ := aq50SubscaleForCategory(q.Category)
+		items[s.Key]++
+		if !q.Reverse {
+			agreeScored++
+		}
+	}
+	for _, s := range aq50Subscales {
+		if items[s.Key] != s.Items {
+			t.Errorf("the %s subscale has %d items instead of %d", s.Key, items[s.Key], s.Items)
+		}
+	}
+	if agreeScored != 24 {
+		t.Errorf("%d items score on agreement instead of 24", agreeScored)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Report assets are embedded in the binary so exports render the same way
+// on every machine, including when archived and opened offline.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// Fonts used by the exported HTML report. Open Sans covers Latin, Latin
+// Extended and Cyrillic, which is enough for all supported languages.
+var reportFonts = []struct {
+	File   string
+	Weight int
+}{
+	{File: "assets/fonts/open-sans-regular.woff2", Weight: 400},
+	{File: "assets/fonts/open-sans-700.woff2", Weight: 700},
+}
+
+// registerAssetRoutes exposes the embedded assets under /assets
+func registerAssetRoutes(r *gin.Engine) {
+	sub, err := fs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		panic(fmt.Sprintf("failed to load embedded assets: %v", err))
+	}
+	r.StaticFS("/assets", http.FS(sub))
+}
+
+// dataURI encodes raw bytes as a base64 data URI with the given MIME type
+func dataURI(mimeType string, content []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(content)
+}
+
+// fontFaceCSS returns @font-face rules with every report font inlined as a data URI
+func fontFaceCSS() (string, error) {
+	var css string
+	for _, font := range reportFonts {
+		content, err := embeddedAssets.ReadFile(font.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read font %s: %w", font.File, err)
+		}
+		css += fmt.Sprintf("@font-face { font-family: 'Open Sans'; font-style: normal; font-weight: %d; src: url(%s) format('woff2'); }\n",
+			font.Weight, dataURI("font/woff2", content))
+	}
+	return css, nil
+}
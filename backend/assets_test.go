@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFontFaceCSS(t *testing.T) {
+	css, err := fontFaceCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(css, "@font-face"); n != len(reportFonts) {
+		t.Errorf("%d @font-face rules for %d fonts", n, len(reportFonts))
+	}
+}
+
+// TestReportFontsReferenced makes sure every embedded font is inlined in the
+// HTML export, under the family its stylesheet uses, so that none is
+// shipped in the binary for nothing or silently replaced by a system font
+func TestReportFontsReferenced(t *testing.T) {
+	listed := map[string]bool{}
+	for _, font := range reportFonts {
+		listed[font.File] = true
+	}
+	fonts, err := fs.Glob(embeddedAssets, "assets/fonts/*.woff2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fonts) == 0 {
+		t.Fatal("no font is embedded")
+	}
+	for _, file := range fonts {
+		if !listed[file] {
+			t.Errorf("%s is embedded but not in reportFonts", path.Base(file))
+		}
+	}
+
+	html, err := renderExportHTML(context.Background(), sampleExport(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, font := range reportFonts {
+		content, err := embeddedAssets.ReadFile(font.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(html), dataURI("font/woff2", content)) {
+			t.Errorf("%s is not inlined in the HTML export", path.Base(font.File))
+		}
+	}
+	if !strings.Contains(string(html), "font-family: 'Open Sans'") {
+		t.Error("the HTML export does not use the embedded font family")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+)
+
+// Assessment input modes, reported in response metadata
+const (
+	inputModeInline   = "inline"
+	inputModeCompact  = "compact"
+	inputModeDocument = "document"
+	inputModeFile     = "file"
+)
+
+const (
+	filesAPIBeta           = "files-api-2025-04-14"
+	assessmentDocumentName = "assessment.json"
+)
+
+var (
+	// Assessments whose JSON is larger than this are attached as a document
+	attachmentThresholdBytes = envInt("ATTACHMENT_THRESHOLD_BYTES", 100*1024)
+
+	// Upload attached assessments through the Files API instead of sending
+	// them as inline document blocks
+	filesAPIEnabled = os.Getenv("FILES_API_ENABLED") == "true"
+)
+
+// assessmentInput is the assessment data as it will be sent to the model
+type assessmentInput struct {
+	Mode string
+	// JSON to inline in the prompt, empty when attached
+	Inline string
+	// Document block to send alongside the prompt, nil when inlined
+	Document any
+	// anthropic-beta header required by the mode, if any
+	Beta string
+	// System prompt of the generation, empty for none
+	System string
+	// The assessment, for fallback providers that cannot read the
+	// attachment and for the template
+	Assessment AssessmentData
+	// The assessments of a composite report, summarized together by the
+	// template
+	Composite []AssessmentData
+}
+
+// PromptData returns what the prompt should contain in place of the JSON
+func (in assessmentInput) PromptData() string {
+	if in.Document != nil {
+		return "(provided in the attached document " + assessmentDocumentName + ")"
+	}
+	return in.Inline
+}
+
+// Message builds the user message carrying the prompt and any attachment
+func (in assessmentInput) Message(prompt string) Message {
+	if in.Document == nil {
+		return Message{Role: "user", Content: prompt}
+	}
+	return Message{
+		Role: "user",
+		Content: []any{
+			in.Document,
+			map[string]any{"type": "text", "text": prompt},
+		},
+	}
+}
+
+// prepareAssessmentInput decides how the assessment is sent to the model:
+// inlined in the prompt, or attached as a document when it is large or when
+// the client asks for it. Providers without document support get a compact
+// inline JSON instead.
+func prepareAssessmentInput(ctx context.Context, data AssessmentData) (assessmentInput, error) {
+	return buildAssessmentInput(ctx, data, filesAPIEnabled)
+}
+
+// buildAssessmentInput is prepareAssessmentInput, uploading attachments
+// through the Files API only when useFiles is set
+func buildAssessmentInput(ctx context.Context, data AssessmentData, useFiles bool) (assessmentInput, error) {
+	// The participant-provided context has its own prompt block, so that it
+	// is not mistaken for questionnaire data
+	data.AdditionalContext = ""
+	// The format version means nothing to the model, and would change
+	// the prompts of every cached analysis
+	data.SchemaVersion = 0
+	data = promptSafeAssessment(ctx, data)
+
+	indented, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return assessmentInput{}, fmt.Errorf("failed to serialize assessment data: %w", err)
+	}
+
+	if !data.AttachmentMode && len(indented) <= attachmentThresholdBytes {
+		return assessmentInput{Mode: inputModeInline, Inline: string(indented), Assessment: data}, nil
+	}
+
+	capabilities := currentProviderCapabilities()
+	if !capabilities.Documents {
+		compact, err := json.Marshal(data)
+		if err != nil {
+			return assessmentInput{}, fmt.Errorf("failed to serialize assessment data: %w", err)
+		}
+		return assessmentInput{Mode: inputModeCompact, Inline: string(compact), Assessment: data}, nil
+	}
+
+	if useFiles && capabilities.FilesAPI {
+		fileID, err := uploadAssessmentFile(ctx, indented)
+		if err != nil {
+			return assessmentInput{}, err
+		}
+		return assessmentInput{
+			Mode: inputModeFile,
+			Document: map[string]any{
+				"type":   "document",
+				"title":  assessmentDocumentName,
+				"source": map[string]any{"type": "file", "file_id": fileID},
+			},
+			Beta:       filesAPIBeta,
+			Assessment: data,
+		}, nil
+	}
+
+	return assessmentInput{
+		Mode: inputModeDocument,
+		Document: map[string]any{
+			"type":  "document",
+			"title": assessmentDocumentName,
+			"source": map[string]any{
+				"type":       "text",
+				"media_type": "text/plain",
+				"data":       string(indented),
+			},
+		},
+		Assessment: data,
+	}, nil
+}
+
+// uploadAssessmentFile uploads the assessment JSON through the Files API
+// and returns its file id
+func uploadAssessmentFile(ctx context.Context, content []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, assessmentDocumentName))
+	header.Set("Content-Type", "text/plain")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file upload: %w", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return "", fmt.Errorf("failed to create file upload: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to create file upload: %w", err)
+	}
+
+	resp, _, err := callClaude(ctx, credentialFrom(ctx), claudeCall{
+		Path:        "/v1/files",
+		ContentType: writer.FormDataContentType(),
+		Body:        body.Bytes(),
+		Beta:        filesAPIBeta,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload assessment file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("claude files API error %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return "", fmt.Errorf("failed to decode file upload response: %w", err)
+	}
+
+	return file.ID, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	// Resource endpoint of Azure OpenAI, such as https://name.openai.azure.com
+	azureOpenAIEndpoint = strings.TrimSuffix(os.Getenv("AZURE_OPENAI_ENDPOINT"), "/")
+
+	azureOpenAIAPIKey = os.Getenv("AZURE_OPENAI_API_KEY")
+
+	// Deployment of the generations without a routing rule. Routing rules
+	// and model overrides name deployments too with this provider.
+	azureOpenAIDeployment = os.Getenv("AZURE_OPENAI_DEPLOYMENT")
+
+	// API version of the chat completions, one that reports usage in
+	// streams
+	azureOpenAIAPIVersion = envString("AZURE_OPENAI_API_VERSION", "2024-10-21")
+)
+
+// loadAzureOpenAI validates the configuration of the Azure OpenAI provider
+func loadAzureOpenAI() error {
+	var missing []string
+	for _, v := range []struct{ name, value string }{
+		{"AZURE_OPENAI_ENDPOINT", azureOpenAIEndpoint},
+		{"AZURE_OPENAI_API_KEY", azureOpenAIAPIKey},
+		{"AZURE_OPENAI_DEPLOYMENT", azureOpenAIDeployment},
+	} {
+		if v.value == "" {
+			missing = append(missing, v.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s not set", strings.Join(missing, ", "))
+	}
+	if _, err := url.ParseRequestURI(azureOpenAIEndpoint); err != nil {
+		return fmt.Errorf("invalid AZURE_OPENAI_ENDPOINT: %w", err)
+	}
+	return nil
+}
+
+// azureChatCompletionsURL returns the chat completions URL of a deployment
+func azureChatCompletionsURL(deployment string) string {
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		azureOpenAIEndpoint, url.PathEscape(deployment), url.QueryEscape(azureOpenAIAPIVersion))
+}
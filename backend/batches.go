@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"raads-pdf-backend/claudestream"
+)
+
+// Processing statuses of a message batch, as reported by the Claude API
+const (
+	batchInProgress = "in_progress"
+	batchEnded      = "ended"
+)
+
+// Most assessments accepted in a single batch
+var batchMaxAssessments = envInt("BATCH_MAX_ASSESSMENTS", 100)
+
+// batchRequestCounts are the requests of a batch per processing state
+type batchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// messageBatchResponse is a message batch of the Claude API
+type messageBatchResponse struct {
+	ID               string             `json:"id"`
+	ProcessingStatus string             `json:"processing_status"`
+	RequestCounts    batchRequestCounts `json:"request_counts"`
+}
+
+// messageBatchResult is a line of the results of a message batch
+type messageBatchResult struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string          `json:"type"`
+		Message *ClaudeResponse `json:"message"`
+		Error   *struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"error"`
+	} `json:"result"`
+}
+
+// BatchReport is the analysis of an assessment of a batch. Status is the
+// result type of the Claude API once the batch ended: succeeded, errored,
+// canceled or expired.
+type BatchReport struct {
+	Index         int    `json:"index"`
+	ReportID      string `json:"report_id"`
+	Status        string `json:"status,omitempty"`
+	Model         string `json:"model"`
+	PromptVersion int    `json:"prompt_version"`
+	Markdown      string `json:"markdown,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// MessageBatch is a set of analyses generated together through the
+// Message Batches API, at a lower cost and with a delay of up to a day.
+// Reports are filled in once the batch ended.
+type MessageBatch struct {
+	ID            string             `json:"id"`
+	Status        string             `json:"status"`
+	RequestCounts batchRequestCounts `json:"request_counts"`
+	Reports       []BatchReport      `json:"reports"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	EndedAt       *time.Time         `json:"ended_at,omitempty"`
+
+	anthropicID string
+	credential  *claudeCredential
+}
+
+// batchStore keeps message batches in memory until their retention period
+// is over, like jobs
+type batchStore struct {
+	mu      sync.Mutex
+	batches map[string]*MessageBatch
+}
+
+var messageBatches = &batchStore{batches: make(map[string]*MessageBatch)}
+
+// Save records a submitted batch
+func (s *batchStore) Save(batch *MessageBatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	s.batches[batch.ID] = batch
+}
+
+// Get returns a batch
+func (s *batchStore) Get(id string) (*MessageBatch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	batch, ok := s.batches[id]
+	return batch, ok
+}
+
+// pruneLocked forgets ended batches past the job retention period
+func (s *batchStore) pruneLocked(now time.Time) {
+	for id, batch := range s.batches {
+		if batch.EndedAt != nil && now.Sub(*batch.EndedAt) > jobRetention {
+			delete(s.batches, id)
+		}
+	}
+}
+
+// batchSubmission is the body of a batch analysis request
+type batchSubmission struct {
+	Assessments []json.RawMessage `json:"assessments"`
+}
+
+// batchRouting handles the requests to the batch route that carry
+// several assessments, as {"assessments": [...]}, and lets the requests
+// with a single assessment through to the batch priority analysis
+func batchRouting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Failed to read request body: " + err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var submission batchSubmission
+		if json.Unmarshal(body, &submission) != nil || submission.Assessments == nil {
+			c.Next()
+			return
+		}
+		c.Abort()
+		submitBatchHandler(c, submission)
+	}
+}
+
+// submitBatchHandler validates the assessments of a submission and sends
+// their analyses to the Message Batches API
+func submitBatchHandler(c *gin.Context, submission batchSubmission) {
+	if !featureBatches.Enabled() {
+		c.JSON(404, gin.H{
+			"error":   fmt.Sprintf("Feature %s is disabled", featureBatches.Name),
+			"code":    errFeatureDisabled,
+			"feature": featureBatches.Name,
+		})
+		return
+	}
+	if activeProvider != providerAnthropic {
+		c.JSON(501, gin.H{"error": "Batch analyses require the " + providerAnthropic + " provider, not " + activeProvider})
+		return
+	}
+	if len(submission.Assessments) == 0 || len(submission.Assessments) > batchMaxAssessments {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("A batch must contain between 1 and %d assessments", batchMaxAssessments)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	batch := &MessageBatch{
+		ID:         uuid.New().String(),
+		Status:     batchInProgress,
+		Reports:    make([]BatchReport, len(submission.Assessments)),
+		credential: credentialFrom(ctx),
+	}
+	var requests []gin.H
+	beta := ""
+	for i, raw := range submission.Assessments {
+		request, report, requestBeta, err := batchRequest(c, raw)
+		if err != nil {
+			log.Printf("❌ Invalid assessment %d of batch: %v", i, err)
+			response := invalidAssessment(err)
+			response["index"] = i
+			c.JSON(400, response)
+			return
+		}
+		report.Index = i
+		batch.Reports[i] = report
+		requests = append(requests, request)
+		if requestBeta != "" {
+			beta = requestBeta
+		}
+	}
+
+	jsonData, err := json.Marshal(gin.H{"requests": requests})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to marshal batch: " + err.Error()})
+		return
+	}
+	created, err := claudeBatchCall(ctx, batch.credential, claudeCall{
+		Path:        "/v1/messages/batches",
+		ContentType: "application/json",
+		Body:        jsonData,
+		Beta:        beta,
+	})
+	if err != nil {
+		log.Printf("❌ Error submitting batch: %v", err)
+		c.JSON(502, gin.H{"error": "Failed to submit batch: " + err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	batch.anthropicID = created.ID
+	batch.RequestCounts = created.RequestCounts
+	batch.CreatedAt = now
+	batch.UpdatedAt = now
+	snapshot := *batch
+	messageBatches.Save(batch)
+	log.Printf("📦 Batch %s submitted with %d assessments as %s", batch.ID, len(batch.Reports), created.ID)
+	c.JSON(202, snapshot)
+}
+
+// batchRequest validates an assessment of a batch and builds its request,
+// with a report ID as custom ID
+func batchRequest(c *gin.Context, raw json.RawMessage) (gin.H, BatchReport, string, error) {
+	if schemaValidationStrict || c.Query("validate") == "schema" {
+		if errs := assessmentSchema.Validate(raw); len(errs) > 0 {
+			return nil, BatchReport{}, "", validationErrors(errs)
+		}
+	}
+	var data AssessmentData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, BatchReport{}, "", fmt.Errorf("invalid JSON data: %w", err)
+	}
+	ctx := c.Request.Context()
+	if err := validateAssessmentData(ctx, &data); err != nil {
+		return nil, BatchReport{}, "", err
+	}
+	if data.Model != "" && !modelAllowed(data.Model) {
+		return nil, BatchReport{}, "", fmt.Errorf("model %s cannot be requested", data.Model)
+	}
+
+	ctx = withRequestedModel(ctx, data)
+	input, err := buildAssessmentInput(ctx, data, false)
+	if err != nil {
+		return nil, BatchReport{}, "", err
+	}
+	version := assessmentPromptVersion(data)
+	prompt, err := analysisPrompt(data, input, version)
+	if err != nil {
+		return nil, BatchReport{}, "", err
+	}
+	if input.System, err = systemPrompt(data, version); err != nil {
+		return nil, BatchReport{}, "", err
+	}
+
+	model := routeModel(ctx, modeFull).Model
+	sampling := samplingFor(data)
+	report := BatchReport{ReportID: uuid.New().String(), Model: model, PromptVersion: version}
+	request := gin.H{
+		"custom_id": report.ReportID,
+		"params": ClaudeRequest{
+			Model:         model,
+			MaxTokens:     models.MaxTokens(model, analysisMaxTokens),
+			System:        input.System,
+			Messages:      []Message{input.Message(prompt)},
+			Temperature:   sampling.Temperature,
+			TopP:          sampling.TopP,
+			StopSequences: sampling.StopSequences,
+		},
+	}
+	return request, report, input.Beta, nil
+}
+
+// claudeBatchCall sends a request about a message batch and decodes the
+// batch it returns
+func claudeBatchCall(ctx context.Context, credential *claudeCredential, call claudeCall) (messageBatchResponse, error) {
+	resp, _, err := callClaude(ctx, credential, call)
+	if err != nil {
+		return messageBatchResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return messageBatchResponse{}, &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("request-id")}
+	}
+	var batch messageBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return messageBatchResponse{}, fmt.Errorf("failed to decode batch: %w", err)
+	}
+	return batch, nil
+}
+
+// Refresh updates the status of a batch that has not ended, and collects
+// its reports once it has
+func (s *batchStore) Refresh(ctx context.Context, batch *MessageBatch) error {
+	s.mu.Lock()
+	ended, anthropicID, credential := batch.EndedAt != nil, batch.anthropicID, batch.credential
+	s.mu.Unlock()
+	if ended {
+		return nil
+	}
+
+	status, err := claudeBatchCall(ctx, credential, claudeCall{Method: "GET", Path: "/v1/messages/batches/" + anthropicID})
+	if err != nil {
+		return err
+	}
+	var results map[string]messageBatchResult
+	if status.ProcessingStatus == batchEnded {
+		if results, err = batchResults(ctx, credential, anthropicID); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	batch.Status = status.ProcessingStatus
+	batch.RequestCounts = status.RequestCounts
+	batch.UpdatedAt = now
+	if status.ProcessingStatus != batchEnded || batch.EndedAt != nil {
+		return nil
+	}
+	batch.EndedAt = &now
+	for i := range batch.Reports {
+		report := &batch.Reports[i]
+		result, ok := results[report.ReportID]
+		if !ok {
+			report.Status = "missing"
+			report.Error = "no result for this assessment"
+			continue
+		}
+		report.Status = result.Result.Type
+		switch {
+		case result.Result.Message != nil:
+			for _, block := range result.Result.Message.Content {
+				report.Markdown += block.Text
+			}
+			if usage := result.Result.Message.Usage; usage != nil {
+				// Recorded at the prices of individual generations, the
+				// ledger has no batch prices
+				recordUsage(withReportUsage(ctx, report.ReportID), report.Model, usage.InputTokens, usage.OutputTokens)
+			}
+		case result.Result.Error != nil:
+			report.Error = result.Result.Error.Error.Message
+		}
+	}
+	log.Printf("📦 Batch %s ended: %d succeeded, %d errored", batch.ID, batch.RequestCounts.Succeeded, batch.RequestCounts.Errored)
+	return nil
+}
+
+// batchResults downloads the results of an ended batch, by custom ID
+func batchResults(ctx context.Context, credential *claudeCredential, anthropicID string) (map[string]messageBatchResult, error) {
+	resp, _, err := callClaude(ctx, credential, claudeCall{Method: "GET", Path: "/v1/messages/batches/" + anthropicID + "/results"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("request-id")}
+	}
+
+	results := map[string]messageBatchResult{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), claudestream.DefaultMaxLineBytes)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var result messageBatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("failed to decode batch result: %w", err)
+		}
+		results[result.CustomID] = result
+	}
+	return results, scanner.Err()
+}
+
+// batchHandler returns the status of a batch, and once it ended the
+// reports of its assessments
+func batchHandler(c *gin.Context) {
+	batch, ok := messageBatches.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Batch not found"})
+		return
+	}
+	if err := messageBatches.Refresh(c.Request.Context(), batch); err != nil {
+		log.Printf("⚠️  Error refreshing batch %s: %v", batch.ID, err)
+	}
+
+	messageBatches.mu.Lock()
+	snapshot := *batch
+	snapshot.Reports = append([]BatchReport(nil), batch.Reports...)
+	messageBatches.mu.Unlock()
+	c.JSON(200, snapshot)
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/claudestream"
+)
+
+var (
+	// Region of Bedrock, required with the bedrock provider
+	bedrockRegion = envString("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+
+	// Endpoint of the Bedrock runtime, overridable for VPC endpoints and
+	// local testing
+	bedrockEndpoint = envString("BEDROCK_ENDPOINT", "")
+
+	// Model, or inference profile, of the generations without a routing
+	// rule when LLM_PROVIDER is bedrock
+	bedrockModel = envString("BEDROCK_MODEL", "anthropic.claude-sonnet-4-20250514-v1:0")
+)
+
+// Version of the Messages API on Bedrock, sent in the body instead of the
+// anthropic-version header
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+type bedrockRequest struct {
+	AnthropicVersion string    `json:"anthropic_version"`
+	MaxTokens        int       `json:"max_tokens"`
+	System           string    `json:"system,omitempty"`
+	Messages         []Message `json:"messages"`
+	Temperature      *float64  `json:"temperature,omitempty"`
+	TopP             *float64  `json:"top_p,omitempty"`
+	StopSequences    []string  `json:"stop_sequences,omitempty"`
+}
+
+// loadBedrock validates the configuration of the Bedrock provider.
+// Credentials are only resolved on the first generation, as they may come
+// from the instance or container.
+func loadBedrock() error {
+	if bedrockRegion == "" {
+		return fmt.Errorf("AWS_REGION is not set")
+	}
+	return nil
+}
+
+func bedrockBaseURL() string {
+	if bedrockEndpoint != "" {
+		return strings.TrimSuffix(bedrockEndpoint, "/")
+	}
+	return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", bedrockRegion)
+}
+
+// callBedrock sends a Messages API request to a Claude model on Bedrock,
+// signed with the AWS credentials of the deployment
+func callBedrock(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int, stream bool) (*http.Response, error) {
+	sampling := samplingFor(input.Assessment)
+	jsonData, err := json.Marshal(bedrockRequest{
+		AnthropicVersion: bedrockAnthropicVersion,
+		MaxTokens:        models.MaxTokens(model, maxTokens),
+		System:           input.System,
+		Messages:         []Message{input.Message(prompt)},
+		Temperature:      sampling.Temperature,
+		TopP:             sampling.TopP,
+		StopSequences:    sampling.StopSequences,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Bedrock request: %w", err)
+	}
+
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+	endpoint, err := url.Parse(bedrockBaseURL() + "/model/" + awsURIEncode(model) + "/" + action)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bedrock endpoint: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Bedrock request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	credentials, err := awsCredentialsCache.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	signAWSRequest(req, jsonData, credentials, bedrockRegion, "bedrock", time.Now())
+
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Bedrock: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("x-amzn-RequestId")}
+	}
+	return resp, nil
+}
+
+// completeBedrockMarkdown is completeClaudeMarkdown for Bedrock
+func completeBedrockMarkdown(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callBedrock(ctx, input, prompt, model, maxTokens, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var claudeResp ClaudeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&claudeResp); err != nil {
+		return "", fmt.Errorf("failed to decode Bedrock response: %w", err)
+	}
+	if claudeResp.Usage != nil {
+		recordUsage(ctx, model, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens)
+	}
+	if len(claudeResp.Content) == 0 {
+		return "", fmt.Errorf("empty response from Bedrock")
+	}
+
+	markdown := claudeResp.Content[0].Text
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// streamBedrockMarkdown is streamClaudeMarkdown for Bedrock, whose stream
+// carries the Claude events in AWS event stream frames
+func streamBedrockMarkdown(ctx context.Context, c *gin.Context, gen *generation, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callBedrock(ctx, input, prompt, model, maxTokens, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	stream := claudestream.NewReader(&bedrockEventReader{r: resp.Body})
+	markdown, usage, err := forwardMarkdownStream(ctx, c, gen, stream)
+	recordUsage(ctx, model, usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		return "", err
+	}
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// bedrockEventReader turns an AWS event stream of Claude events back into
+// the SSE stream the Claude API sends, for claudestream to parse. Each
+// frame carries one event, base64-encoded in a JSON payload; exceptions
+// become error events.
+type bedrockEventReader struct {
+	r       io.Reader
+	pending bytes.Buffer
+	err     error
+}
+
+func (b *bedrockEventReader) Read(p []byte) (int, error) {
+	for b.pending.Len() == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.err = b.nextFrame()
+	}
+	return b.pending.Read(p)
+}
+
+// nextFrame decodes the next frame into pending SSE data
+func (b *bedrockEventReader) nextFrame() error {
+	var prelude [12]byte
+	if _, err := io.ReadFull(b.r, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("truncated Bedrock event stream")
+		}
+		return err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return fmt.Errorf("corrupted Bedrock event stream prelude")
+	}
+	if total < 16+headersLength || total > 16*1024*1024 {
+		return fmt.Errorf("invalid Bedrock event stream frame of %d bytes", total)
+	}
+
+	frame := make([]byte, total)
+	copy(frame, prelude[:])
+	if _, err := io.ReadFull(b.r, frame[12:]); err != nil {
+		return fmt.Errorf("truncated Bedrock event stream: %w", err)
+	}
+	if crc32.ChecksumIEEE(frame[:total-4]) != binary.BigEndian.Uint32(frame[total-4:]) {
+		return fmt.Errorf("corrupted Bedrock event stream frame")
+	}
+
+	headers, err := parseEventStreamHeaders(frame[12 : 12+headersLength])
+	if err != nil {
+		return err
+	}
+	payload := frame[12+headersLength : total-4]
+
+	var event []byte
+	switch headers[":message-type"] {
+	case "event":
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			return nil
+		}
+		if event, err = base64.StdEncoding.DecodeString(chunk.Bytes); err != nil {
+			return nil
+		}
+	case "exception", "error":
+		var exception struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(payload, &exception)
+		kind := headers[":exception-type"]
+		if kind == "" {
+			kind = headers[":error-code"]
+		}
+		event, _ = json.Marshal(gin.H{"type": "error", "error": gin.H{"type": kind, "message": exception.Message}})
+	default:
+		return nil
+	}
+
+	// Events are single-line JSON, so one data field carries each
+	b.pending.WriteString("data: ")
+	b.pending.Write(bytes.ReplaceAll(event, []byte("\n"), []byte(" ")))
+	b.pending.WriteString("\n\n")
+	return nil
+}
+
+// parseEventStreamHeaders returns the string headers of an event stream
+// frame, skipping the others
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	// Value sizes of the fixed-size header types, by type
+	fixed := map[byte]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 4, 5: 8, 8: 8, 9: 16}
+	headers := map[string]string{}
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 2+nameLength {
+			return nil, fmt.Errorf("invalid Bedrock event stream headers")
+		}
+		name := string(data[1 : 1+nameLength])
+		kind := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		size, ok := fixed[kind]
+		if !ok {
+			// Byte arrays and strings are prefixed with their length
+			if len(data) < 2 {
+				return nil, fmt.Errorf("invalid Bedrock event stream headers")
+			}
+			size = int(binary.BigEndian.Uint16(data[:2]))
+			data = data[2:]
+		}
+		if len(data) < size {
+			return nil, fmt.Errorf("invalid Bedrock event stream headers")
+		}
+		if kind == 7 {
+			headers[name] = string(data[:size])
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
+
+// awsCredentials are the credentials requests are signed with
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// awsCredentialsProvider resolves AWS credentials as the SDKs do, from the
+// environment, then the container credentials endpoint of ECS and EKS,
+// then the instance metadata service. Temporary credentials are cached
+// until five minutes before they expire.
+type awsCredentialsProvider struct {
+	mu      sync.Mutex
+	current *awsCredentials
+}
+
+var awsCredentialsCache = &awsCredentialsProvider{}
+
+func (p *awsCredentialsProvider) Get(ctx context.Context) (awsCredentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return awsCredentials{
+			AccessKeyID:     key,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current != nil && time.Until(p.current.Expiration) > 5*time.Minute {
+		return *p.current, nil
+	}
+
+	credentials, err := fetchAWSCredentials(ctx)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	p.current = &credentials
+	return credentials, nil
+}
+
+func fetchAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return getAWSCredentials(ctx, "http://169.254.170.2"+uri, nil)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		headers := map[string]string{}
+		if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+			headers["Authorization"] = token
+		}
+		return getAWSCredentials(ctx, uri, headers)
+	}
+
+	// Instance metadata service, version 2
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, "PUT", imds+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := readAWSMetadata(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no credentials in the environment, container or instance metadata: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	req, err = http.NewRequestWithContext(ctx, "GET", imds+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := readAWSMetadata(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no IAM role attached to the instance: %w", err)
+	}
+	return getAWSCredentials(ctx, imds+"/meta-data/iam/security-credentials/"+strings.TrimSpace(role), headers)
+}
+
+func getAWSCredentials(ctx context.Context, uri string, headers map[string]string) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	body, err := readAWSMetadata(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var credentials awsCredentials
+	if err := json.Unmarshal([]byte(body), &credentials); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid AWS credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+func readAWSMetadata(req *http.Request) (string, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%s returned HTTP %d", req.URL, resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// awsURIEncode encodes a string as Signature Version 4 expects, escaping
+// every byte but the unreserved characters
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signAWSRequest adds Signature Version 4 headers to a request without
+// query parameters. The host, the content type and the x-amz-* headers are
+// signed.
+func signAWSRequest(req *http.Request, body []byte, credentials awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// Paths are encoded a second time, except for S3
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		if name = strings.ToLower(name); name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			signed = append(signed, name)
+		}
+	}
+	sort.Strings(signed)
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		strings.Join(segments, "/"),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
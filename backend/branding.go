@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Branding is the look of the reports of a deployment or tenant: the logo
+// and name of the organization, the colors, the texts of the page header
+// and footer, and the contact details printed on the reports. Each JSON
+// file of BRANDING_DIR is a brand: default.json applies to the whole
+// deployment, and any other file to the tenant of the same name, that is
+// the credential the X-API-Key of the request is mapped to in
+// CLIENT_API_KEYS. Tenant brands only override the fields they set.
+type Branding struct {
+	Key          string `json:"-"`
+	Organization string `json:"organization,omitempty"`
+	// PNG or JPEG file, relative to BRANDING_DIR
+	Logo        string       `json:"logo,omitempty"`
+	Colors      BrandColors  `json:"colors"`
+	ReportTitle string       `json:"reportTitle,omitempty"`
+	Header      string       `json:"header,omitempty"`
+	Footer      string       `json:"footer,omitempty"`
+	Contact     BrandContact `json:"contact"`
+
+	logo []byte
+}
+
+// BrandColors are the colors of the reports, as #rrggbb
+type BrandColors struct {
+	Primary   string `json:"primary,omitempty"`
+	Secondary string `json:"secondary,omitempty"`
+	Accent    string `json:"accent,omitempty"`
+	Success   string `json:"success,omitempty"`
+	Warning   string `json:"warning,omitempty"`
+	Light     string `json:"light,omitempty"`
+}
+
+// BrandContact are the contact details printed on the reports
+type BrandContact struct {
+	Email   string `json:"email,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	Website string `json:"website,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Details lists the contact details that are set
+func (c BrandContact) Details() []string {
+	var details []string
+	for _, detail := range []string{c.Address, c.Phone, c.Email, c.Website} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	return details
+}
+
+const defaultBrandingKey = "default"
+
+// Logos larger than this bloat every report
+const maxBrandLogoBytes = 1 << 20
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// defaultBranding is the look of the reports without branding
+var defaultBranding = Branding{
+	Key: defaultBrandingKey,
+	Colors: BrandColors{
+		Primary:   "#2980b9",
+		Secondary: "#34495e",
+		Accent:    "#e74c3c",
+		Success:   "#27ae60",
+		Warning:   "#f39c12",
+		Light:     "#ecf0f1",
+	},
+}
+
+var (
+	brandingDir            = os.Getenv("BRANDING_DIR")
+	brandings, brandingErr = loadBrandings()
+)
+
+// loadBrandings reads the brands of BRANDING_DIR, each tenant on top of
+// the deployment brand
+func loadBrandings() (map[string]Branding, error) {
+	loaded := map[string]Branding{defaultBrandingKey: defaultBranding}
+	if brandingDir == "" {
+		return loaded, nil
+	}
+	files, err := filepath.Glob(filepath.Join(brandingDir, "*.json"))
+	if err != nil {
+		return loaded, fmt.Errorf("failed to list brands: %w", err)
+	}
+	// The deployment brand is read first, as tenants build on it
+	defaultFile := filepath.Join(brandingDir, defaultBrandingKey+".json")
+	sort.SliceStable(files, func(i, j int) bool { return files[i] == defaultFile && files[j] != defaultFile })
+
+	for _, file := range files {
+		key := strings.TrimSuffix(filepath.Base(file), ".json")
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to read brand %s: %w", key, err)
+		}
+		var brand Branding
+		if err := json.Unmarshal(content, &brand); err != nil {
+			return loaded, fmt.Errorf("failed to parse brand %s: %w", key, err)
+		}
+		if err := brand.load(); err != nil {
+			return loaded, fmt.Errorf("invalid brand %s: %w", key, err)
+		}
+		merged := loaded[defaultBrandingKey].merge(brand)
+		merged.Key = key
+		loaded[key] = merged
+	}
+	return loaded, nil
+}
+
+// load validates the colors of a brand and reads its logo
+func (b *Branding) load() error {
+	for name, color := range map[string]string{
+		"primary": b.Colors.Primary, "secondary": b.Colors.Secondary, "accent": b.Colors.Accent,
+		"success": b.Colors.Success, "warning": b.Colors.Warning, "light": b.Colors.Light,
+	} {
+		if color != "" && !hexColorPattern.MatchString(color) {
+			return fmt.Errorf("%s color %q is not #rrggbb", name, color)
+		}
+	}
+	if b.Logo == "" {
+		return nil
+	}
+	if ext := b.LogoExt(); ext != ".png" && ext != ".jpg" {
+		return fmt.Errorf("logo %s is not a PNG or JPEG file", b.Logo)
+	}
+	if filepath.IsAbs(b.Logo) || strings.Contains(filepath.ToSlash(b.Logo), "..") {
+		return fmt.Errorf("logo %s must be relative to BRANDING_DIR", b.Logo)
+	}
+	logo, err := os.ReadFile(filepath.Join(brandingDir, b.Logo))
+	if err != nil {
+		return fmt.Errorf("failed to read logo: %w", err)
+	}
+	if len(logo) > maxBrandLogoBytes {
+		return fmt.Errorf("logo %s is larger than %d bytes", b.Logo, maxBrandLogoBytes)
+	}
+	b.logo = logo
+	return nil
+}
+
+// merge returns the brand with the fields set in override replaced
+func (b Branding) merge(override Branding) Branding {
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&b.Organization, override.Organization)
+	if override.Logo != "" {
+		b.Logo, b.logo = override.Logo, override.logo
+	}
+	set(&b.Colors.Primary, override.Colors.Primary)
+	set(&b.Colors.Secondary, override.Colors.Secondary)
+	set(&b.Colors.Accent, override.Colors.Accent)
+	set(&b.Colors.Success, override.Colors.Success)
+	set(&b.Colors.Warning, override.Colors.Warning)
+	set(&b.Colors.Light, override.Colors.Light)
+	set(&b.ReportTitle, override.ReportTitle)
+	set(&b.Header, override.Header)
+	set(&b.Footer, override.Footer)
+	set(&b.Contact.Email, override.Contact.Email)
+	set(&b.Contact.Phone, override.Contact.Phone)
+	set(&b.Contact.Website, override.Contact.Website)
+	set(&b.Contact.Address, override.Contact.Address)
+	return b
+}
+
+// LogoExt is the extension of the logo file, with .jpeg as .jpg
+func (b Branding) LogoExt() string {
+	ext := strings.ToLower(filepath.Ext(b.Logo))
+	if ext == ".jpeg" {
+		return ".jpg"
+	}
+	return ext
+}
+
+// LogoFile is the name of the logo in the LaTeX build directory, or ""
+// when the brand has no logo
+func (b Branding) LogoFile() string {
+	if b.logo == nil {
+		return ""
+	}
+	return "logo" + b.LogoExt()
+}
+
+// latexFiles are the files the LaTeX report of the brand includes
+func (b Branding) latexFiles() map[string][]byte {
+	files := map[string][]byte{}
+	if logo := b.LogoFile(); logo != "" {
+		files[logo] = b.logo
+	}
+	return files
+}
+
+// LogoDataURI is the logo inlined for the HTML report, or "" when the
+// brand has no logo
+func (b Branding) LogoDataURI() string {
+	if b.logo == nil {
+		return ""
+	}
+	mime := "image/png"
+	if b.LogoExt() == ".jpg" {
+		mime = "image/jpeg"
+	}
+	return dataURI(mime, b.logo)
+}
+
+// brandingFor returns the brand of the tenant of a request, or the brand
+// of the deployment
+func brandingFor(ctx context.Context) Branding {
+	if credential := credentialFrom(ctx); credential != nil {
+		if brand, ok := brandings[credential.Name]; ok {
+			return brand
+		}
+	}
+	return brandings[defaultBrandingKey]
+}
+
+// checkBranding reports the brands read from BRANDING_DIR
+func checkBranding() checkResult {
+	result := checkResult{Name: "branding"}
+	if brandingErr != nil {
+		result.Detail = brandingErr.Error()
+		return result
+	}
+	result.OK = true
+	if brandingDir == "" {
+		result.Detail = "BRANDING_DIR is not set, reports use the default look"
+		return result
+	}
+	tenants := make([]string, 0, len(brandings))
+	for key := range brandings {
+		if key != defaultBrandingKey {
+			tenants = append(tenants, key)
+		}
+	}
+	sort.Strings(tenants)
+	result.Detail = fmt.Sprintf("deployment brand %q", brandings[defaultBrandingKey].Organization)
+	if len(tenants) > 0 {
+		result.Detail += ", tenants: " + strings.Join(tenants, ", ")
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Consecutive upstream failures after which generations fail fast
+	breakerFailureThreshold = envInt("BREAKER_FAILURE_THRESHOLD", 5)
+
+	// How long generations fail fast before a single one probes the API
+	breakerOpenDuration = time.Duration(envInt("BREAKER_OPEN_SECONDS", 30)) * time.Second
+)
+
+// States of the circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// errAIUnavailable is returned, wrapped in a breakerOpenError, by
+// generations refused while the breaker is open
+var errAIUnavailable = errors.New("AI service unavailable")
+
+// breakerOpenError is a generation refused by the circuit breaker
+type breakerOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *breakerOpenError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", errAIUnavailable, e.RetryAfter.Round(time.Second))
+}
+
+func (e *breakerOpenError) Unwrap() error {
+	return errAIUnavailable
+}
+
+// circuitBreaker stops sending generations to the provider after repeated
+// upstream failures, so that requests fail fast instead of waiting for the
+// HTTP timeout. Once the open period is over, a single generation probes
+// the provider: its success closes the breaker, its failure opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	// Called when the breaker closes after having opened
+	onClose func()
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	opened   int64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, onClose func()) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now, onClose: onClose, state: breakerClosed}
+}
+
+// claudeBreaker guards the generations of the configured provider. main
+// sets its onClose to requeue the jobs that failed during the outage.
+var claudeBreaker = newCircuitBreaker(breakerFailureThreshold, breakerOpenDuration, nil)
+
+func breakerReadiness(b *circuitBreaker) readinessCheck {
+	return readinessCheck{Name: "AI service", Check: b.Check}
+}
+
+// Check returns the error generations would be refused with, without
+// starting a probe
+func (b *circuitBreaker) Check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refusalLocked()
+}
+
+// Allow returns nil when a generation may be sent to the provider, which
+// must then be reported to Record
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.refusalLocked(); err != nil {
+		return err
+	}
+	if b.state == breakerOpen {
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Printf("🔌 Circuit breaker half-open, probing the AI service")
+	}
+	return nil
+}
+
+func (b *circuitBreaker) refusalLocked() error {
+	switch b.state {
+	case breakerOpen:
+		if remaining := b.cooldown - b.now().Sub(b.openedAt); remaining > 0 {
+			return &breakerOpenError{RetryAfter: remaining}
+		}
+	case breakerHalfOpen:
+		if b.probing {
+			return &breakerOpenError{RetryAfter: time.Second}
+		}
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed generation. Only upstream
+// outages and timeouts count as failures: other errors show the provider
+// answered, and cancellations say nothing about it.
+func (b *circuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil || !errors.Is(err, context.Canceled) && !breakerFailure(err):
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.state = breakerClosed
+			b.probing = false
+			log.Printf("🔌 Circuit breaker closed, the AI service recovered")
+			if b.onClose != nil {
+				go b.onClose()
+			}
+		}
+	case errors.Is(err, context.Canceled):
+		// A cancelled probe leaves the next generation to probe
+		b.probing = false
+	default:
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= b.threshold {
+			b.state = breakerOpen
+			b.openedAt = b.now()
+			b.probing = false
+			b.opened++
+			log.Printf("🔌 Circuit breaker open for %s after %d failures: %v", b.cooldown, b.failures, err)
+		}
+	}
+}
+
+// State returns the state of the breaker and how many times it opened
+func (b *circuitBreaker) State() (string, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.opened
+}
+
+// breakerFailure reports whether a generation error means the provider is
+// down or not answering
+func breakerFailure(err error) bool {
+	switch classifyFailure(err) {
+	case failureUpstreamUnavailable, failureTimeout:
+		return true
+	}
+	return false
+}
+
+// aiUnavailable answers with a 503 when a generation was refused by the
+// circuit breaker, and reports whether it did
+func aiUnavailable(c *gin.Context, err error) bool {
+	var open *breakerOpenError
+	if !errors.As(err, &open) {
+		return false
+	}
+	retryAfter := int(open.RetryAfter.Seconds() + 0.5)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(503, gin.H{
+		"error":               "AI service unavailable, please retry shortly",
+		"code":                "ai_unavailable",
+		"retry_after_seconds": retryAfter,
+	})
+	return true
+}
+
+// checkCircuitBreaker drives a breaker through an outage and a recovery
+// with a fake clock
+func checkCircuitBreaker() checkResult {
+	result := checkResult{Name: "circuit breaker", Feature: "analysis"}
+	if breakerFailureThreshold < 1 || breakerOpenDuration <= 0 {
+		result.Detail = "BREAKER_FAILURE_THRESHOLD and BREAKER_OPEN_SECONDS must be positive"
+		return result
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("opens after %d upstream failures for %s", breakerFailureThreshold, breakerOpenDuration)
+	return result
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	var closed atomic.Int32
+	b := newCircuitBreaker(2, time.Minute, func() { closed.Add(1) })
+	b.now = func() time.Time { return now }
+	outage := &claudeAPIError{Status: 529, Body: "overloaded"}
+
+	steps := []struct {
+		name  string
+		apply func() error
+		state string
+	}{
+		{"first failure", func() error { b.Allow(); b.Record(outage); return nil }, breakerClosed},
+		{"invalid request", func() error { b.Allow(); b.Record(&claudeAPIError{Status: 400}); return nil }, breakerClosed},
+		{"failures reaching the threshold", func() error {
+			b.Allow()
+			b.Record(outage)
+			b.Allow()
+			b.Record(outage)
+			return nil
+		}, breakerOpen},
+		{"generation while open", func() error {
+			if err := b.Allow(); !errors.Is(err, errAIUnavailable) {
+				return fmt.Errorf("allowed: %v", err)
+			}
+			return nil
+		}, breakerOpen},
+		{"probe after the open period", func() error {
+			now = now.Add(time.Minute)
+			if err := b.Allow(); err != nil {
+				return err
+			}
+			if err := b.Allow(); err == nil {
+				return errors.New("second generation allowed during the probe")
+			}
+			return nil
+		}, breakerHalfOpen},
+		{"failed probe", func() error { b.Record(outage); return nil }, breakerOpen},
+		{"successful probe", func() error {
+			now = now.Add(time.Minute)
+			if err := b.Allow(); err != nil {
+				return err
+			}
+			b.Record(nil)
+			return nil
+		}, breakerClosed},
+	}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if state, _ := b.State(); state != step.state {
+			t.Fatalf("%s: breaker %s instead of %s", step.name, state, step.state)
+		}
+	}
+
+	// onClose runs in its own goroutine
+	deadline := time.Now().Add(time.Second)
+	for closed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := closed.Load(); n != 1 {
+		t.Errorf("recovery callback ran %d times", n)
+	}
+}
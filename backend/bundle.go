@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Formats of an export bundle, in the order they are written
+const (
+	bundleFormatPDF  = "pdf"
+	bundleFormatHTML = "html"
+	bundleFormatMD   = "md"
+	bundleFormatCSV  = "csv"
+	bundleFormatXLSX = "xlsx"
+)
+
+var bundleFormats = []string{bundleFormatPDF, bundleFormatHTML, bundleFormatMD, bundleFormatCSV, bundleFormatXLSX}
+
+// BundleRequest is an export request for several formats at once. The
+// analysis is taken from Markdown, a stored domain report or a completed job.
+type BundleRequest struct {
+	ExportRequest
+	// Formats to include, every available one when empty
+	Formats []string `json:"formats"`
+	JobID   string   `json:"jobId,omitempty"`
+	// Details shown on the title page of the PDF
+	Participant Participant `json:"participant"`
+}
+
+// BundleFile describes one file of a bundle in its manifest
+type BundleFile struct {
+	Name        string `json:"name"`
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// BundleManifest is written last in the bundle as manifest.json
+type BundleManifest struct {
+	ReportID         string       `json:"report_id"`
+	AssessmentHash   string       `json:"assessment_hash"`
+	TestName         string       `json:"test_name"`
+	Language         string       `json:"language"`
+	ReferenceProfile string       `json:"reference_profile"`
+	AnalysisVersion  string       `json:"analysis_version"`
+	GeneratedAt      time.Time    `json:"generated_at"`
+	Files            []BundleFile `json:"files"`
+	Warnings         []Warning    `json:"warnings,omitempty"`
+}
+
+// bundleEntry is a file of a bundle, rendered only when it is written so
+// that a single rendering is held in memory at a time
+type bundleEntry struct {
+	Name        string
+	Format      string
+	ContentType string
+	Render      func() ([]byte, error)
+}
+
+// bundleFormatError reports why a format cannot be bundled, or "" when it can
+func bundleFormatError(format string) string {
+	switch format {
+	case bundleFormatPDF:
+		if !featurePDF.Enabled() {
+			return "PDF generation is disabled"
+		}
+		if reason := pdfRendererError(pdfRenderer); reason != "" {
+			return reason
+		}
+	case bundleFormatHTML:
+		if !featureHTMLExport.Enabled() {
+			return "HTML export is disabled"
+		}
+	case bundleFormatMD, bundleFormatCSV, bundleFormatXLSX:
+	default:
+		return fmt.Sprintf("unknown format %q (available: %s)", format, strings.Join(bundleFormats, ", "))
+	}
+	return ""
+}
+
+// selectBundleFormats validates the requested formats and returns them in
+// bundle order. Without formats, every available one is selected.
+func selectBundleFormats(requested []string) ([]string, error) {
+	wanted := make(map[string]bool, len(requested))
+	for _, format := range requested {
+		if reason := bundleFormatError(format); reason != "" {
+			return nil, fmt.Errorf("%s", reason)
+		}
+		wanted[format] = true
+	}
+
+	selected := make([]string, 0, len(bundleFormats))
+	for _, format := range bundleFormats {
+		if len(requested) == 0 && bundleFormatError(format) == "" || wanted[format] {
+			selected = append(selected, format)
+		}
+	}
+	return selected, nil
+}
+
+// bundleEntries lists the files of the selected formats. The markdown file
+// is only included when there is an analysis.
+func bundleEntries(ctx context.Context, req BundleRequest, formats []string, reportID string) []bundleEntry {
+	entries := make([]bundleEntry, 0, len(formats))
+	for _, format := range formats {
+		switch format {
+		case bundleFormatPDF:
+			entries = append(entries, bundleEntry{"report.pdf", format, "application/pdf", func() ([]byte, error) {
+				return renderPDF(ctx, req.ExportRequest, req.Participant, pdfRenderer, reportID)
+			}})
+		case bundleFormatHTML:
+			entries = append(entries, bundleEntry{"report.html", format, "text/html; charset=utf-8", func() ([]byte, error) {
+				return renderExportHTML(ctx, req.ExportRequest, reportID)
+			}})
+		case bundleFormatMD:
+			if req.Markdown != "" {
+				entries = append(entries, bundleEntry{"report.md", format, "text/markdown; charset=utf-8", func() ([]byte, error) {
+					return []byte(req.Markdown), nil
+				}})
+			}
+		case bundleFormatCSV:
+			entries = append(entries, bundleEntry{"answers.csv", format, "text/csv; charset=utf-8", func() ([]byte, error) {
+				return renderAnswersCSV(req.Assessment, req.IncludeComments == nil || *req.IncludeComments)
+			}})
+		case bundleFormatXLSX:
+			entries = append(entries, bundleEntry{"report.xlsx", format, xlsxContentType, func() ([]byte, error) {
+				return renderAssessmentXLSX(req.Assessment, req.IncludeComments == nil || *req.IncludeComments)
+			}})
+		}
+	}
+	return entries
+}
+
+// writeBundle writes the entries as a zip, followed by a manifest listing
+// their hashes and the warnings raised while rendering them. Every entry
+// carries the generation time so that the same renderings always produce
+// the same archive.
+func writeBundle(ctx context.Context, w io.Writer, entries []bundleEntry, manifest BundleManifest) error {
+	zw := zip.NewWriter(w)
+
+	add := func(name string, content []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: manifest.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		return err
+	}
+
+	for _, entry := range entries {
+		content, err := entry.Render()
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", entry.Name, err)
+		}
+		if err := add(entry.Name, content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", entry.Name, err)
+		}
+		sum := sha256.Sum256(content)
+		manifest.Files = append(manifest.Files, BundleFile{
+			Name:        entry.Name,
+			Format:      entry.Format,
+			ContentType: entry.ContentType,
+			Size:        len(content),
+			SHA256:      hex.EncodeToString(sum[:]),
+		})
+	}
+
+	manifest.Warnings = warningsFrom(ctx).List()
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+	if err := add("manifest.json", content); err != nil {
+		return fmt.Errorf("failed to write manifest.json: %w", err)
+	}
+	return zw.Close()
+}
+
+// verifyBundle reads the manifest of a bundle and checks that every file it
+// lists is present with the recorded size and hash
+func verifyBundle(r io.ReaderAt, size int64) (BundleManifest, error) {
+	var manifest BundleManifest
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return manifest, fmt.Errorf("invalid zip: %w", err)
+	}
+
+	read := func(name string) ([]byte, error) {
+		f, err := zr.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	content, err := read("manifest.json")
+	if err != nil {
+		return manifest, fmt.Errorf("missing manifest: %w", err)
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if len(zr.File) != len(manifest.Files)+1 {
+		return manifest, fmt.Errorf("bundle has %d files but its manifest lists %d", len(zr.File)-1, len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		content, err := read(file.Name)
+		if err != nil {
+			return manifest, fmt.Errorf("missing %s: %w", file.Name, err)
+		}
+		sum := sha256.Sum256(content)
+		if len(content) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			return manifest, fmt.Errorf("%s does not match its manifest hash", file.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// exportBundleHandler streams a zip of the selected report formats, each
+// rendered once, with a manifest of their hashes
+func exportBundleHandler(c *gin.Context) {
+	var req BundleRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	formats, err := selectBundleFormats(req.Formats)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid formats: " + err.Error()})
+		return
+	}
+
+	if req.ReportID != "" && req.JobID != "" {
+		c.JSON(400, gin.H{"error": "reportId and jobId cannot both be provided"})
+		return
+	}
+	if err := applyStoredReport(c.Request.Context(), &req.ExportRequest); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := requireRAADSR(req.Assessment, "The export bundle"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Reuse a stored analysis rather than asking the client to send it back
+	if req.JobID != "" {
+		job, ok := jobs.Get(req.JobID)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Job not found"})
+			return
+		}
+		if job.Status != jobCompleted {
+			c.JSON(409, gin.H{"error": fmt.Sprintf("Job is %s", job.Status)})
+			return
+		}
+		req.Markdown = job.Markdown
+	}
+	hash, err := assessmentHash(req.Assessment)
+	if err != nil {
+		log.Printf("❌ Error hashing assessment data: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to process assessment data: " + err.Error()})
+		return
+	}
+	if err := applyDomainReport(&req.ExportRequest); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyAnalysisVersion(&req.ExportRequest); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyTheme(&req.ExportRequest); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("compact") == "true" {
+		req.Compact = true
+	}
+
+	reportID := uuid.New().String()
+	manifest := BundleManifest{
+		ReportID:         reportID,
+		AssessmentHash:   hash,
+		TestName:         req.Assessment.Metadata.TestName,
+		Language:         req.Assessment.Language,
+		ReferenceProfile: referenceProfileMetadata(req.Assessment).Key,
+		AnalysisVersion:  req.AnalysisVersion,
+		GeneratedAt:      time.Now().UTC().Truncate(time.Second),
+	}
+	log.Printf("📦 Exporting bundle %s (formats: %s)", reportID, strings.Join(formats, ", "))
+
+	// The zip is streamed, so a rendering failure can only abort it
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="raads-r-report-%s.zip"`, reportID))
+	c.Status(200)
+	entries := bundleEntries(c.Request.Context(), req, formats, reportID)
+	if err := writeBundle(c.Request.Context(), c.Writer, entries, manifest); err != nil {
+		log.Printf("❌ Error writing bundle %s: %v", reportID, err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExportBundle(t *testing.T) {
+	formats := []string{bundleFormatHTML, bundleFormatMD, bundleFormatCSV, bundleFormatXLSX}
+	sample := BundleRequest{ExportRequest: sampleExport()}
+	manifest := BundleManifest{ReportID: "test", GeneratedAt: time.Now().UTC()}
+
+	var out bytes.Buffer
+	ctx := context.Background()
+	if err := writeBundle(ctx, &out, bundleEntries(ctx, sample, formats, "test"), manifest); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := verifyBundle(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verified.Files) != len(formats) {
+		t.Errorf("%d files in the bundle for %d formats", len(verified.Files), len(formats))
+	}
+
+	// A single altered byte fails the verification
+	altered := bytes.Clone(out.Bytes())
+	altered[len(altered)/2] ^= 1
+	if _, err := verifyBundle(bytes.NewReader(altered), int64(len(altered))); err == nil {
+		t.Error("an altered bundle verifies")
+	}
+}
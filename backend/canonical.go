@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// canonicalString normalizes Unicode to NFC and collapses whitespace so
+// that visually identical strings serialize identically
+func canonicalString(s string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+}
+
+// canonicalAssessment builds a map representation of the assessment. Maps
+// are serialized with sorted keys by encoding/json, which gives a field order
+// independent of struct layout. Volatile fields are left out: the test date
+// is truncated to the minute.
+func canonicalAssessment(data AssessmentData) map[string]any {
+	questions := make([]QuestionAndAnswer, len(data.QuestionsAndAnswers))
+	copy(questions, data.QuestionsAndAnswers)
+	sort.SliceStable(questions, func(i, j int) bool { return questions[i].ID < questions[j].ID })
+
+	qas := make([]any, 0, len(questions))
+	for _, qa := range questions {
+		var comment any
+		if qa.Comment != nil && canonicalString(*qa.Comment) != "" {
+			comment = canonicalString(*qa.Comment)
+		}
+		qas = append(qas, map[string]any{
+			"id":         qa.ID,
+			"text":       canonicalString(qa.Text),
+			"category":   canonicalString(qa.Category),
+			"reverse":    qa.Reverse,
+			"answer":     qa.Answer,
+			"answerText": canonicalString(qa.AnswerText),
+			"comment":    comment,
+			"score":      qa.Score,
+		})
+	}
+
+	canonical := map[string]any{
+		"language": canonicalString(data.Language),
+		"metadata": map[string]any{
+			"testName":          canonicalString(data.Metadata.TestName),
+			"testDate":          data.Metadata.TestDate.UTC().Truncate(time.Minute).Format(time.RFC3339),
+			"totalQuestions":    data.Metadata.TotalQuestions,
+			"answeredQuestions": data.Metadata.AnsweredQuestions,
+		},
+		"scores": map[string]any{
+			"total":         data.Scores.Total,
+			"maxTotal":      data.Scores.MaxTotal,
+			"language":      data.Scores.Language,
+			"maxLanguage":   data.Scores.MaxLanguage,
+			"social":        data.Scores.Social,
+			"maxSocial":     data.Scores.MaxSocial,
+			"sensory":       data.Scores.Sensory,
+			"maxSensory":    data.Scores.MaxSensory,
+			"restricted":    data.Scores.Restricted,
+			"maxRestricted": data.Scores.MaxRestricted,
+		},
+		"interpretation": map[string]any{
+			"level":       canonicalString(data.Interpretation.Level),
+			"description": canonicalString(data.Interpretation.Description),
+			"severity":    canonicalString(data.Interpretation.Severity),
+		},
+		"questionsAndAnswers": qas,
+	}
+	// Only present when provided, so hashes of assessments without context
+	// are unchanged
+	if instrument := instrumentOf(data).Key; instrument != instrumentRAADSR {
+		canonical["instrument"] = instrument
+	}
+	if data.Scores.MaxEmpathy != 0 || data.Scores.MaxSystemizing != 0 {
+		scores := canonical["scores"].(map[string]any)
+		scores["empathy"] = data.Scores.Empathy
+		scores["maxEmpathy"] = data.Scores.MaxEmpathy
+		scores["systemizing"] = data.Scores.Systemizing
+		scores["maxSystemizing"] = data.Scores.MaxSystemizing
+	}
+	if seconds := data.Metadata.DurationSeconds; seconds > 0 {
+		canonical["metadata"].(map[string]any)["durationSeconds"] = seconds
+	}
+	if text := canonicalString(data.AdditionalContext); text != "" {
+		canonical["additionalContext"] = text
+	}
+	if d := data.Demographics; d != nil && (d.Age != 0 || d.Gender != "") {
+		canonical["demographics"] = map[string]any{"age": d.Age, "gender": d.Gender}
+	}
+	return canonical
+}
+
+// canonicalJSON returns the canonical byte representation of an assessment,
+// suitable for hashing, caching and idempotency checks
+func canonicalJSON(data AssessmentData) ([]byte, error) {
+	content, err := json.Marshal(canonicalAssessment(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize canonical assessment: %w", err)
+	}
+	return content, nil
+}
+
+// assessmentHash returns the hex-encoded SHA-256 of the canonical JSON
+func assessmentHash(data AssessmentData) (string, error) {
+	content, err := canonicalJSON(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
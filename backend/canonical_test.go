@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// hashOf decodes a submitted assessment and returns its hash
+func hashOf(t *testing.T, body string) string {
+	t.Helper()
+	var data AssessmentData
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := assessmentHash(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// TestAssessmentHash makes sure the hash only depends on the content of an
+// assessment, not on how its JSON is written
+func TestAssessmentHash(t *testing.T) {
+	base := fmt.Sprintf(`{"schemaVersion":%d,"language":"en",`+
+		`"metadata":{"testName":"RAADS-R","testDate":"2024-03-17T10:00:00Z","totalQuestions":2,"answeredQuestions":2},`+
+		`"scores":{"total":3,"maxTotal":6},`+
+		`"interpretation":{"level":"Low","description":"Few traits","severity":"low"},`+
+		`"questionsAndAnswers":[`+
+		`{"id":1,"text":"I am a sympathetic person.","category":"Social relatedness","reverse":true,"answer":0,"answerText":"True now and when I was young","comment":"Only with friends","score":0},`+
+		`{"id":2,"text":"I often use words and phrases from movies.","category":"Language","reverse":false,"answer":3,"answerText":"True now and when I was young","comment":null,"score":3}]}`,
+		currentSchemaVersion)
+	want := hashOf(t, base)
+
+	same := map[string]string{
+		"reordered keys": fmt.Sprintf(`{"questionsAndAnswers":[`+
+			`{"score":0,"comment":"Only with friends","answerText":"True now and when I was young","answer":0,"reverse":true,"category":"Social relatedness","text":"I am a sympathetic person.","id":1},`+
+			`{"score":3,"comment":null,"answerText":"True now and when I was young","answer":3,"reverse":false,"category":"Language","text":"I often use words and phrases from movies.","id":2}],`+
+			`"interpretation":{"severity":"low","description":"Few traits","level":"Low"},`+
+			`"scores":{"maxTotal":6,"total":3},`+
+			`"metadata":{"answeredQuestions":2,"totalQuestions":2,"testDate":"2024-03-17T10:00:00Z","testName":"RAADS-R"},`+
+			`"language":"en","schemaVersion":%d}`, currentSchemaVersion),
+		"whitespace in texts": strings.NewReplacer(
+			`"Only with friends"`, `"  Only  with\tfriends\n"`,
+			`"I am a sympathetic person."`, `"I am a  sympathetic person. "`,
+		).Replace(base),
+		"reordered questions": strings.Replace(base,
+			`{"id":1,"text":"I am a sympathetic person.","category":"Social relatedness","reverse":true,"answer":0,"answerText":"True now and when I was young","comment":"Only with friends","score":0},`+
+				`{"id":2,"text":"I often use words and phrases from movies.","category":"Language","reverse":false,"answer":3,"answerText":"True now and when I was young","comment":null,"score":3}`,
+			`{"id":2,"text":"I often use words and phrases from movies.","category":"Language","reverse":false,"answer":3,"answerText":"True now and when I was young","comment":null,"score":3},`+
+				`{"id":1,"text":"I am a sympathetic person.","category":"Social relatedness","reverse":true,"answer":0,"answerText":"True now and when I was young","comment":"Only with friends","score":0}`, 1),
+		"test date within the minute": strings.Replace(base, `"2024-03-17T10:00:00Z"`, `"2024-03-17T11:00:42+01:00"`, 1),
+		"empty comment":               strings.Replace(base, `"comment":null`, `"comment":"  "`, 1),
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(base), "", "    "); err != nil {
+		t.Fatal(err)
+	}
+	same["indented"] = indented.String()
+	for name, body := range same {
+		t.Run(name, func(t *testing.T) {
+			if body == base {
+				t.Fatal("the variant is the base assessment")
+			}
+			if hash := hashOf(t, body); hash != want {
+				t.Errorf("hash %s instead of %s", hash, want)
+			}
+		})
+	}
+
+	different := map[string]string{
+		"changed answer":  strings.Replace(base, `"answer":3`, `"answer":2`, 1),
+		"changed comment": strings.Replace(base, `"Only with friends"`, `"Only with family"`, 1),
+		"changed date":    strings.Replace(base, `"2024-03-17T10:00:00Z"`, `"2024-03-18T10:00:00Z"`, 1),
+	}
+	for name, body := range different {
+		t.Run(name, func(t *testing.T) {
+			if hash := hashOf(t, body); hash == want {
+				t.Error("the hash is unchanged")
+			}
+		})
+	}
+}
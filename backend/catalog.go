@@ -0,0 +1,271 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Question catalogs and report texts, extracted from the frontend language
+// files with `make catalogs`. Question numbering differs between
+// translations, so each language has its own categories and reverse flags.
+//
+//go:embed catalogs/*.json
+var catalogFS embed.FS
+
+// CatalogQuestion is a test question as listed in a language catalog
+type CatalogQuestion struct {
+	ID       int    `json:"id"`
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Reverse  bool   `json:"reverse"`
+}
+
+// InterpretationText is the localized label of an interpretation band
+type InterpretationText struct {
+	Level       string `json:"level"`
+	Description string `json:"description"`
+}
+
+// CatalogLabels are the localized labels of the score charts
+type CatalogLabels struct {
+	Domains    map[string]string `json:"domains"`
+	TotalScore string            `json:"totalScore"`
+	Score      string            `json:"score"`
+	Threshold  string            `json:"threshold"`
+	Typical    string            `json:"typical"`
+	Maximum    string            `json:"maximum"`
+}
+
+// CatalogReportLabels are the localized fixed texts of the LaTeX report,
+// and the names of the months and order of the parts of its dates
+type CatalogReportLabels struct {
+	Title          string   `json:"title"`
+	CompositeTitle string   `json:"compositeTitle"`
+	TestName       string   `json:"testName"`
+	TestFullName   string   `json:"testFullName"`
+	Participant    string   `json:"participant"`
+	Age            string   `json:"age"`
+	Gender         string   `json:"gender"`
+	Profession     string   `json:"profession"`
+	EvaluationDate string   `json:"evaluationDate"`
+	ScoreSummary   string   `json:"scoreSummary"`
+	Domain         string   `json:"domain"`
+	Instrument     string   `json:"instrument"`
+	Interpretation string   `json:"interpretation"`
+	Appendix       string   `json:"appendix"`
+	Percentiles    string   `json:"percentiles"`
+	ComparedWith   string   `json:"comparedWith"`
+	Footer         string   `json:"footer"`
+	Version        string   `json:"version"`
+	Verify         string   `json:"verify"`
+	Months         []string `json:"months"`
+	// Order of the parts of a date, with {day}, {month} and {year}
+	DateFormat string `json:"dateFormat"`
+}
+
+// missing returns the name of the first label that is not set, or ""
+func (r CatalogReportLabels) missing() string {
+	for name, label := range map[string]string{
+		"title": r.Title, "compositeTitle": r.CompositeTitle, "testName": r.TestName, "testFullName": r.TestFullName,
+		"participant": r.Participant, "age": r.Age, "gender": r.Gender, "profession": r.Profession,
+		"evaluationDate": r.EvaluationDate, "scoreSummary": r.ScoreSummary, "domain": r.Domain,
+		"instrument": r.Instrument, "interpretation": r.Interpretation, "appendix": r.Appendix,
+		"percentiles": r.Percentiles, "comparedWith": r.ComparedWith, "footer": r.Footer,
+		"version": r.Version, "verify": r.Verify, "dateFormat": r.DateFormat,
+	} {
+		if label == "" {
+			return name
+		}
+	}
+	if len(r.Months) != 12 {
+		return "months"
+	}
+	return ""
+}
+
+// FormatDate writes a date with the month names and date format of the
+// language
+func (r CatalogReportLabels) FormatDate(t time.Time) string {
+	return strings.NewReplacer(
+		"{day}", fmt.Sprint(t.Day()),
+		"{month}", r.Months[t.Month()-1],
+		"{year}", fmt.Sprint(t.Year()),
+	).Replace(r.DateFormat)
+}
+
+// LanguageCatalog holds the questions, interpretation texts, chart labels
+// and report texts of a language
+type LanguageCatalog struct {
+	Questions       []CatalogQuestion             `json:"questions"`
+	Interpretations map[string]InterpretationText `json:"interpretations"`
+	Labels          CatalogLabels                 `json:"labels"`
+	Report          CatalogReportLabels           `json:"report"`
+
+	byID map[int]CatalogQuestion
+}
+
+// Question returns a question by ID
+func (l *LanguageCatalog) Question(id int) (CatalogQuestion, bool) {
+	q, ok := l.byID[id]
+	return q, ok
+}
+
+// Canonical question categories, as used by raadsDomains
+const (
+	categorySocial     = "IS"
+	categorySensory    = "SM"
+	categoryRestricted = "IR"
+	categoryLanguage   = "L"
+)
+
+// Every spelling of a category sent by current and past frontends and
+// translations, keyed by categoryAliasKey. Some translations use the
+// French abbreviation CI for circumscribed interests.
+var categoryAliases = map[string]string{
+	"is":                      categorySocial,
+	"social":                  categorySocial,
+	"social_relatedness":      categorySocial,
+	"social_interactions":     categorySocial,
+	"interpersonal":           categorySocial,
+	"sm":                      categorySensory,
+	"sensory":                 categorySensory,
+	"sensory_motor":           categorySensory,
+	"ir":                      categoryRestricted,
+	"ci":                      categoryRestricted,
+	"restricted":              categoryRestricted,
+	"restricted_interests":    categoryRestricted,
+	"circumscribed_interests": categoryRestricted,
+	"l":                       categoryLanguage,
+	"language":                categoryLanguage,
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// categoryAliasKey folds case and separators, so that "Sensory/Motor" and
+// "sensory-motor" are the same alias
+func categoryAliasKey(category string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(category), "_"), "_")
+}
+
+// lookupCategory returns the canonical category of a spelling
+func lookupCategory(category string) (string, bool) {
+	canonical, ok := categoryAliases[categoryAliasKey(category)]
+	return canonical, ok
+}
+
+// canonicalCategory maps a category to the one used by raadsDomains,
+// leaving unknown categories unchanged
+func canonicalCategory(category string) string {
+	if canonical, ok := lookupCategory(category); ok {
+		return canonical
+	}
+	return category
+}
+
+// acceptedCategories lists every accepted category spelling, sorted
+func acceptedCategories() []string {
+	accepted := make([]string, 0, len(categoryAliases))
+	for alias := range categoryAliases {
+		accepted = append(accepted, alias)
+	}
+	sort.Strings(accepted)
+	return accepted
+}
+
+// normalizeCategories replaces the categories of a full submission with
+// their canonical value, so that only canonical values are used past
+// validation. Questions without a category get the one of the catalog.
+func normalizeCategories(data *AssessmentData) error {
+	var errs validationErrors
+	for i := range data.QuestionsAndAnswers {
+		qa := &data.QuestionsAndAnswers[i]
+		if qa.Category == "" {
+			continue
+		}
+		canonical, ok := lookupCategory(qa.Category)
+		if !ok {
+			errs = append(errs, ValidationError{
+				Path:     fmt.Sprintf("/questionsAndAnswers/%d/category", i),
+				Message:  fmt.Sprintf("unknown category %q for question %d", qa.Category, qa.ID),
+				Accepted: acceptedCategories(),
+			})
+			continue
+		}
+		qa.Category = canonical
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var catalogs = struct {
+	once      sync.Once
+	err       error
+	languages map[string]*LanguageCatalog
+}{}
+
+// loadCatalogs parses the embedded catalogs of every supported language
+func loadCatalogs() error {
+	catalogs.once.Do(func() {
+		catalogs.languages = make(map[string]*LanguageCatalog)
+		for code := range supportedLanguages {
+			content, err := catalogFS.ReadFile("catalogs/" + code + ".json")
+			if err != nil {
+				catalogs.err = fmt.Errorf("missing question catalog for %s: %w", code, err)
+				return
+			}
+			var catalog LanguageCatalog
+			if err := json.Unmarshal(content, &catalog); err != nil {
+				catalogs.err = fmt.Errorf("invalid question catalog for %s: %w", code, err)
+				return
+			}
+			catalog.byID = make(map[int]CatalogQuestion, len(catalog.Questions))
+			for i, q := range catalog.Questions {
+				q.Category = canonicalCategory(q.Category)
+				if _, ok := domainForCategory(q.Category); !ok {
+					catalogs.err = fmt.Errorf("question %d of the %s catalog has unknown category %q", q.ID, code, q.Category)
+					return
+				}
+				catalog.Questions[i] = q
+				catalog.byID[q.ID] = q
+			}
+			for _, rule := range interpretationRules {
+				if _, ok := catalog.Interpretations[rule.Key]; !ok {
+					catalogs.err = fmt.Errorf("the %s catalog has no %q interpretation", code, rule.Key)
+					return
+				}
+			}
+			for _, d := range raadsDomains {
+				if catalog.Labels.Domains[d.Key] == "" {
+					catalogs.err = fmt.Errorf("the %s catalog has no label for the %s domain", code, d.Key)
+					return
+				}
+			}
+			if missing := catalog.Report.missing(); missing != "" {
+				catalogs.err = fmt.Errorf("the %s catalog has no %s report label", code, missing)
+				return
+			}
+			catalogs.languages[code] = &catalog
+		}
+	})
+	return catalogs.err
+}
+
+// catalogFor returns the catalog of a supported language
+func catalogFor(language string) (*LanguageCatalog, error) {
+	if err := loadCatalogs(); err != nil {
+		return nil, err
+	}
+	catalog, ok := catalogs.languages[language]
+	if !ok {
+		return nil, fmt.Errorf("no question catalog for language %s", language)
+	}
+	return catalog, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLanguageCatalogs(t *testing.T) {
+	if err := loadCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			if _, ok := raadsR.ScaleLabels[code]; !ok {
+				t.Error("missing answer scale")
+			}
+			if _, err := catalogFor(code); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestCategoryAliases maps every known category spelling, in several
+// casings, to a scoring domain
+func TestCategoryAliases(t *testing.T) {
+	covered := map[string]bool{}
+	for alias, canonical := range categoryAliases {
+		if _, ok := domainForCategory(canonical); !ok {
+			t.Errorf("alias %q maps to %q, which is not a domain category", alias, canonical)
+		}
+		spellings := []string{alias, strings.ToUpper(alias), strings.ReplaceAll(alias, "_", " "), strings.ReplaceAll(strings.ToUpper(alias), "_", "/")}
+		for _, spelling := range spellings {
+			if got, ok := lookupCategory(spelling); !ok || got != canonical {
+				t.Errorf("%q is mapped to %q instead of %q", spelling, got, canonical)
+			}
+		}
+		covered[canonical] = true
+	}
+	for _, d := range raadsDomains {
+		if !covered[d.Category] {
+			t.Errorf("no alias for the category of the %s domain", d.Key)
+		}
+	}
+}
+
+// TestUnknownCategory makes sure an unknown category is rejected with the
+// accepted spellings, while the known ones next to it are normalized
+func TestUnknownCategory(t *testing.T) {
+	data := AssessmentData{QuestionsAndAnswers: []QuestionAndAnswer{{ID: 1, Category: "social_relatedness"}, {ID: 2, Category: "executive_function"}}}
+	err := normalizeCategories(&data)
+	var errs validationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "/questionsAndAnswers/1/category" || len(errs[0].Accepted) != len(categoryAliases) {
+		t.Fatalf("unknown category not rejected as expected: %v", err)
+	}
+	if data.QuestionsAndAnswers[0].Category != categorySocial {
+		t.Errorf("known alias normalized to %q next to an unknown category", data.QuestionsAndAnswers[0].Category)
+	}
+}
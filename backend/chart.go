@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/vector"
+)
+
+// Layout of the score chart, in SVG user units. The PNG rendering is the
+// same layout scaled by chartPNGScale.
+const (
+	chartWidth     = 600
+	chartRowHeight = 40
+	chartLabelW    = 170
+	chartBarW      = 340
+	chartBarH      = 24
+	chartLegendH   = 22
+	chartFontSize  = 13
+	chartPNGScale  = 2
+)
+
+var (
+	chartTrackColor     = color.RGBA{0xe9, 0xec, 0xef, 0xff}
+	chartBarColor       = color.RGBA{0x4a, 0x6f, 0xa5, 0xff}
+	chartReferenceColor = color.RGBA{0xe6, 0x7e, 0x22, 0xff}
+	chartThresholdColor = color.RGBA{0xc0, 0x39, 0x2b, 0xff}
+	chartTextColor      = color.RGBA{0x22, 0x22, 0x22, 0xff}
+)
+
+// scoreChartBar is one score of the chart, with its threshold and the mean
+// of the reference profile
+type scoreChartBar struct {
+	Label     string
+	Score     int
+	Max       int
+	Threshold int
+	Reference float64
+}
+
+// scoreChart is the domain score chart of the reports, rendered on the
+// server so that the HTML and PDF reports show the same chart
+type scoreChart struct {
+	Bars           []scoreChartBar
+	ScoreLabel     string
+	ThresholdLabel string
+	ReferenceLabel string
+}
+
+// newScoreChart lays out the total and domain scores of a RAADS-R
+// assessment, labeled in its language, against the thresholds and a
+// reference profile
+func newScoreChart(data AssessmentData, profile ReferenceProfile) scoreChart {
+	labels := CatalogLabels{}
+	if catalog, err := catalogFor(data.Language); err == nil {
+		labels = catalog.Labels
+	}
+	label := func(key, fallback string) string {
+		if l := labels.Domains[key]; l != "" {
+			return l
+		}
+		return fallback
+	}
+
+	totals := domainTotals(data)
+	chart := scoreChart{
+		Bars:           []scoreChartBar{{label("total", "Total"), data.Scores.Total, data.Scores.MaxTotal, totalThreshold, profile.Mean("total")}},
+		ScoreLabel:     labels.Score,
+		ThresholdLabel: labels.Threshold,
+		ReferenceLabel: profile.Label,
+	}
+	if chart.ScoreLabel == "" {
+		chart.ScoreLabel = "Score"
+	}
+	if chart.ThresholdLabel == "" {
+		chart.ThresholdLabel = "Threshold"
+	}
+	for _, d := range raadsDomains {
+		chart.Bars = append(chart.Bars, scoreChartBar{label(d.Key, d.Name), totals[d.Key], d.MaxScore(), d.Threshold, profile.Mean(d.Key)})
+	}
+	return chart
+}
+
+func (c scoreChart) height() int {
+	return 10 + len(c.Bars)*chartRowHeight + 2*chartLegendH
+}
+
+// position is the x of a value on the bar, clamped to the bar
+func (b scoreChartBar) position(value float64) float64 {
+	if b.Max <= 0 {
+		return chartLabelW
+	}
+	return chartLabelW + min(max(value/float64(b.Max), 0), 1)*chartBarW
+}
+
+// SVG renders the chart as a standalone SVG document: a horizontal bar per
+// score, the reference mean as a line across it and the threshold as a
+// triangle above it
+func (c scoreChart) SVG() string {
+	width, height := chartWidth, c.height()
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Open Sans, sans-serif" font-size="%d">`,
+		width, height, width, height, chartFontSize)
+
+	for i, b := range c.Bars {
+		y := 10 + i*chartRowHeight
+		fmt.Fprintf(&svg, `<text x="0" y="%d" dominant-baseline="middle">%s</text>`, y+chartBarH/2, html.EscapeString(b.Label))
+		fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="%d" rx="4" fill="%s"/>`, chartLabelW, y, chartBarW, chartBarH, svgColor(chartTrackColor))
+		fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%.1f" height="%d" rx="4" fill="%s"/>`, chartLabelW, y, b.position(float64(b.Score))-chartLabelW, chartBarH, svgColor(chartBarColor))
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" dominant-baseline="middle">%d/%d</text>`, chartLabelW+chartBarW+10, y+chartBarH/2, b.Score, b.Max)
+		if b.Max > 0 {
+			x := b.position(b.Reference)
+			fmt.Fprintf(&svg, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="%s" stroke-width="2"/>`, x, y-3, x, y+chartBarH+3, svgColor(chartReferenceColor))
+			if b.Threshold > 0 {
+				x = b.position(float64(b.Threshold))
+				fmt.Fprintf(&svg, `<polygon points="%.1f,%d %.1f,%d %.1f,%d" fill="%s"/>`, x-5, y-7, x+5, y-7, x, y, svgColor(chartThresholdColor))
+			}
+		}
+	}
+
+	legendY := 10 + len(c.Bars)*chartRowHeight + chartLegendH/2
+	fmt.Fprintf(&svg, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"/>`, chartLabelW, legendY-6, chartLabelW, legendY+6, svgColor(chartReferenceColor))
+	fmt.Fprintf(&svg, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`, chartLabelW+10, legendY, html.EscapeString(c.ReferenceLabel))
+	legendY += chartLegendH
+	fmt.Fprintf(&svg, `<polygon points="%d,%d %d,%d %d,%d" fill="%s"/>`, chartLabelW-5, legendY-4, chartLabelW+5, legendY-4, chartLabelW, legendY+3, svgColor(chartThresholdColor))
+	fmt.Fprintf(&svg, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`, chartLabelW+10, legendY, html.EscapeString(c.ThresholdLabel))
+
+	svg.WriteString(`</svg>`)
+	return svg.String()
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// The Go font stands in for Open Sans in the PNG rendering, as the web
+// fonts cannot be rasterized without a Brotli decoder
+var chartFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// chartCanvas draws a chart laid out in SVG user units on an image
+// chartPNGScale times larger, so that the PNG and SVG renderings of a chart
+// share their layout code
+type chartCanvas struct {
+	img  *image.RGBA
+	face font.Face
+}
+
+func newChartCanvas(width, height int) (*chartCanvas, error) {
+	f, err := chartFont()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: chartFontSize * chartPNGScale, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width*chartPNGScale, height*chartPNGScale))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	return &chartCanvas{img: img, face: face}, nil
+}
+
+// Text draws a label vertically centered on y, aligned on x as the SVG
+// text-anchor "start", "middle" or "end"
+func (c *chartCanvas) Text(label string, x, y float64, anchor string) {
+	metrics := c.face.Metrics()
+	left := int(x * chartPNGScale)
+	switch anchor {
+	case "middle":
+		left -= textWidth(c.face, label) / 2
+	case "end":
+		left -= textWidth(c.face, label)
+	}
+	drawText(c.img, c.face, chartTextColor, label, left, int(y*chartPNGScale)+(metrics.Ascent.Ceil()-metrics.Descent.Ceil())/2)
+}
+
+// Polygon fills a closed path through the points
+func (c *chartCanvas) Polygon(col color.Color, points ...[2]float64) {
+	b := c.img.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	z.MoveTo(float32(points[0][0]*chartPNGScale), float32(points[0][1]*chartPNGScale))
+	for _, p := range points[1:] {
+		z.LineTo(float32(p[0]*chartPNGScale), float32(p[1]*chartPNGScale))
+	}
+	z.ClosePath()
+	z.Draw(c.img, b, image.NewUniform(col), image.Point{})
+}
+
+// Line strokes a segment, as a polygon of its width
+func (c *chartCanvas) Line(x1, y1, x2, y2, width float64, col color.Color) {
+	length := math.Hypot(x2-x1, y2-y1)
+	if length == 0 {
+		return
+	}
+	nx, ny := -(y2-y1)/length*width/2, (x2-x1)/length*width/2
+	c.Polygon(col, [2]float64{x1 + nx, y1 + ny}, [2]float64{x2 + nx, y2 + ny}, [2]float64{x2 - nx, y2 - ny}, [2]float64{x1 - nx, y1 - ny})
+}
+
+// RoundedRect fills a rectangle with corners of radius r, as an SVG rect
+// with rx does
+func (c *chartCanvas) RoundedRect(x, y, w, h, r float64, col color.Color) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	s := float32(chartPNGScale)
+	x0, y0, x1, y1, rr := float32(x)*s, float32(y)*s, float32(x+w)*s, float32(y+h)*s, float32(min(r, w/2, h/2))*s
+	b := c.img.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	z.MoveTo(x0+rr, y0)
+	z.LineTo(x1-rr, y0)
+	z.QuadTo(x1, y0, x1, y0+rr)
+	z.LineTo(x1, y1-rr)
+	z.QuadTo(x1, y1, x1-rr, y1)
+	z.LineTo(x0+rr, y1)
+	z.QuadTo(x0, y1, x0, y1-rr)
+	z.LineTo(x0, y0+rr)
+	z.QuadTo(x0, y0, x0+rr, y0)
+	z.ClosePath()
+	z.Draw(c.img, b, image.NewUniform(col), image.Point{})
+}
+
+// PNG encodes the canvas and releases its font face
+func (c *chartCanvas) PNG() ([]byte, error) {
+	defer c.face.Close()
+	var out bytes.Buffer
+	if err := png.Encode(&out, c.img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// PNG renders the same chart as SVG, at chartPNGScale times its size, for
+// documents that cannot embed SVG such as the LaTeX report
+func (c scoreChart) PNG() ([]byte, error) {
+	canvas, err := newChartCanvas(chartWidth, c.height())
+	if err != nil {
+		return nil, err
+	}
+	triangle := func(x, y float64) {
+		canvas.Polygon(chartThresholdColor, [2]float64{x - 5, y - 7}, [2]float64{x + 5, y - 7}, [2]float64{x, y})
+	}
+
+	for i, b := range c.Bars {
+		y := float64(10 + i*chartRowHeight)
+		canvas.Text(b.Label, 0, y+chartBarH/2, "start")
+		canvas.RoundedRect(chartLabelW, y, chartBarW, chartBarH, 4, chartTrackColor)
+		canvas.RoundedRect(chartLabelW, y, b.position(float64(b.Score))-chartLabelW, chartBarH, 4, chartBarColor)
+		canvas.Text(fmt.Sprintf("%d/%d", b.Score, b.Max), chartLabelW+chartBarW+10, y+chartBarH/2, "start")
+		if b.Max > 0 {
+			x := b.position(b.Reference)
+			canvas.Line(x, y-3, x, y+chartBarH+3, 2, chartReferenceColor)
+			if b.Threshold > 0 {
+				triangle(b.position(float64(b.Threshold)), y)
+			}
+		}
+	}
+
+	legendY := float64(10 + len(c.Bars)*chartRowHeight + chartLegendH/2)
+	canvas.Line(chartLabelW, legendY-6, chartLabelW, legendY+6, 2, chartReferenceColor)
+	canvas.Text(c.ReferenceLabel, chartLabelW+10, legendY, "start")
+	legendY += chartLegendH
+	triangle(chartLabelW, legendY+3)
+	canvas.Text(c.ThresholdLabel, chartLabelW+10, legendY, "start")
+
+	return canvas.PNG()
+}
+
+// reportChartHandler serves the score chart or the radar chart of an
+// analyzed assessment, by report ID, as SVG or PNG depending on the route
+func reportChartHandler(c *gin.Context) {
+	stored, err := assessments.Get(c.Param("id"), "", ownerFrom(c.Request.Context()))
+	if errors.Is(err, errBaseExpired) {
+		c.JSON(410, gin.H{"error": fmt.Sprintf("report %s has expired", c.Param("id"))})
+		return
+	}
+	if err != nil {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("report %s not found", c.Param("id"))})
+		return
+	}
+	if err := requireRAADSR(stored.Data, "The score chart"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	profile, err := referenceProfileFor(stored.Data.ReferenceProfile)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	chart := newScoreChart(stored.Data, profile)
+	renderSVG, renderPNG := chart.SVG, chart.PNG
+	if strings.Contains(c.FullPath(), "/radar.") {
+		renderSVG, renderPNG = chart.RadarSVG, chart.RadarPNG
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	if strings.HasSuffix(c.FullPath(), ".png") {
+		content, err := renderPNG()
+		if err != nil {
+			log.Printf("❌ Error rendering score chart: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to render chart: " + err.Error()})
+			return
+		}
+		c.Data(200, "image/png", content)
+		return
+	}
+	c.Data(200, "image/svg+xml; charset=utf-8", []byte(renderSVG()))
+}
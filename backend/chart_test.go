@@ -0,0 +1,32 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+// TestScoreChart renders the bar and radar charts in every language, as
+// SVG and PNG
+func TestScoreChart(t *testing.T) {
+	profile, err := referenceProfileFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			chart := newScoreChart(AssessmentData{Language: code, Scores: Scores{Total: raadsMaxTotal, MaxTotal: raadsMaxTotal}}, profile)
+			for name, render := range map[string]func() ([]byte, error){"bar": chart.PNG, "radar": chart.RadarPNG} {
+				if _, err := render(); err != nil {
+					t.Errorf("%s chart: %v", name, err)
+				}
+			}
+			if !strings.Contains(chart.SVG(), html.EscapeString(chart.Bars[0].Label)) {
+				t.Error("the SVG bar chart lacks its labels")
+			}
+			if !strings.Contains(chart.RadarSVG(), html.EscapeString(chart.Bars[1].Label)) {
+				t.Error("the SVG radar chart lacks its labels")
+			}
+		})
+	}
+}
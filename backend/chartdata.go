@@ -0,0 +1,195 @@
+package main
+
+import (
+	"log"
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the chart data structure, bumped on incompatible changes
+const chartDataVersion = 1
+
+// ChartMarker is a reference value drawn on a chart, along with its
+// position as a fraction of the scale maximum
+type ChartMarker struct {
+	Value      float64 `json:"value"`
+	Normalized float64 `json:"normalized"`
+}
+
+// ChartSeries is a domain, or the total, with everything needed to draw it
+type ChartSeries struct {
+	Key           string      `json:"key"`
+	Label         string      `json:"label"`
+	Score         int         `json:"score"`
+	Max           int         `json:"max"`
+	Normalized    float64     `json:"normalized"`
+	Threshold     ChartMarker `json:"threshold"`
+	Typical       ChartMarker `json:"typical"`
+	OverThreshold bool        `json:"over_threshold"`
+}
+
+// ChartBand is an interpretation band of the total score gauge, from From
+// included to To excluded, except for the last band which includes To
+type ChartBand struct {
+	Key            string  `json:"key"`
+	Label          string  `json:"label"`
+	From           int     `json:"from"`
+	To             int     `json:"to"`
+	FromNormalized float64 `json:"from_normalized"`
+	ToNormalized   float64 `json:"to_normalized"`
+	Current        bool    `json:"current"`
+}
+
+// ChartGauge places the total score among the interpretation bands
+type ChartGauge struct {
+	Value      int         `json:"value"`
+	Max        int         `json:"max"`
+	Normalized float64     `json:"normalized"`
+	Band       string      `json:"band"`
+	Bands      []ChartBand `json:"bands"`
+}
+
+// ChartLabels are the localized legend texts
+type ChartLabels struct {
+	Score     string `json:"score"`
+	Threshold string `json:"threshold"`
+	Typical   string `json:"typical"`
+	Maximum   string `json:"maximum"`
+}
+
+// ChartData is everything the score charts need, so that clients draw them
+// without computing anything from the norms themselves
+type ChartData struct {
+	Version          int           `json:"version"`
+	Language         string        `json:"language"`
+	ReferenceProfile string        `json:"reference_profile"`
+	Domains          []ChartSeries `json:"domains"`
+	Total            ChartSeries   `json:"total"`
+	Gauge            ChartGauge    `json:"gauge"`
+	Labels           ChartLabels   `json:"labels"`
+}
+
+// normalizeChartValue returns a value as a fraction of max, clamped to [0, 1]
+// and rounded to four decimals so that outputs are stable
+func normalizeChartValue(value float64, max int) float64 {
+	if max <= 0 {
+		return 0
+	}
+	ratio := math.Min(math.Max(value/float64(max), 0), 1)
+	return math.Round(ratio*10000) / 10000
+}
+
+func chartMarker(value float64, max int) ChartMarker {
+	return ChartMarker{Value: value, Normalized: normalizeChartValue(value, max)}
+}
+
+// chartDataFor computes the chart data of a validated assessment from the
+// scoring domains, the interpretation bands and the reference profile
+func chartDataFor(data AssessmentData) (ChartData, error) {
+	catalog, err := catalogFor(data.Language)
+	if err != nil {
+		return ChartData{}, err
+	}
+	profile, err := referenceProfileFor(data.ReferenceProfile)
+	if err != nil {
+		return ChartData{}, err
+	}
+
+	labels := ChartLabels{
+		Score:     catalog.Labels.Score,
+		Threshold: catalog.Labels.Threshold,
+		Typical:   catalog.Labels.Typical,
+		Maximum:   catalog.Labels.Maximum,
+	}
+	// The localized label only describes the default profile
+	if profile.Key != normativeDataset.DefaultProfile {
+		labels.Typical = profile.Label
+	}
+
+	chart := ChartData{
+		Version:          chartDataVersion,
+		Language:         data.Language,
+		ReferenceProfile: profile.Key,
+		Domains:          make([]ChartSeries, 0, len(raadsDomains)),
+		Labels:           labels,
+	}
+
+	totals := domainTotals(data)
+	for _, d := range raadsDomains {
+		score := totals[d.Key]
+		chart.Domains = append(chart.Domains, ChartSeries{
+			Key:           d.Key,
+			Label:         catalog.Labels.Domains[d.Key],
+			Score:         score,
+			Max:           d.MaxScore(),
+			Normalized:    normalizeChartValue(float64(score), d.MaxScore()),
+			Threshold:     chartMarker(float64(d.Threshold), d.MaxScore()),
+			Typical:       chartMarker(profile.Mean(d.Key), d.MaxScore()),
+			OverThreshold: score >= d.Threshold,
+		})
+	}
+
+	total := data.Scores.Total
+	chart.Total = ChartSeries{
+		Key:           "total",
+		Label:         catalog.Labels.TotalScore,
+		Score:         total,
+		Max:           raadsMaxTotal,
+		Normalized:    normalizeChartValue(float64(total), raadsMaxTotal),
+		Threshold:     chartMarker(float64(totalThreshold), raadsMaxTotal),
+		Typical:       chartMarker(profile.Mean("total"), raadsMaxTotal),
+		OverThreshold: total >= totalThreshold,
+	}
+
+	chart.Gauge = ChartGauge{
+		Value:      total,
+		Max:        raadsMaxTotal,
+		Normalized: chart.Total.Normalized,
+		Band:       interpretationRuleFor(total).Key,
+		Bands:      make([]ChartBand, 0, len(interpretationRules)),
+	}
+	for _, rule := range interpretationRules {
+		to := min(rule.Max+1, raadsMaxTotal)
+		chart.Gauge.Bands = append(chart.Gauge.Bands, ChartBand{
+			Key:            rule.Key,
+			Label:          catalog.Interpretations[rule.Key].Level,
+			From:           rule.Min,
+			To:             to,
+			FromNormalized: normalizeChartValue(float64(rule.Min), raadsMaxTotal),
+			ToNormalized:   normalizeChartValue(float64(to), raadsMaxTotal),
+			Current:        rule.Key == chart.Gauge.Band,
+		})
+	}
+
+	return chart, nil
+}
+
+// chartDataHandler returns render-ready chart data for an assessment
+func chartDataHandler(c *gin.Context) {
+	var data AssessmentData
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := requireRAADSR(data, "Chart data"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	chart, err := chartDataFor(data)
+	if err != nil {
+		log.Printf("❌ Error computing chart data: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to compute chart data: " + err.Error()})
+		return
+	}
+	c.JSON(200, chart)
+}
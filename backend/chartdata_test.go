@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+// TestChartData makes sure the chart data of every language is complete
+// and that the normalized gauge bands cover the whole scale in order
+func TestChartData(t *testing.T) {
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			chart, err := chartDataFor(AssessmentData{Language: code, Scores: Scores{Total: raadsMaxTotal}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bands := chart.Gauge.Bands
+			if len(bands) == 0 || bands[0].FromNormalized != 0 || bands[len(bands)-1].ToNormalized != 1 || !bands[len(bands)-1].Current {
+				t.Fatal("gauge bands do not cover the whole scale")
+			}
+			for i, band := range bands {
+				if band.Label == "" || band.From >= band.To || i > 0 && band.From != bands[i-1].To {
+					t.Errorf("gauge band %s is empty or out of order", band.Key)
+				}
+			}
+			for _, series := range append(chart.Domains, chart.Total) {
+				if series.Label == "" || series.Threshold.Normalized <= 0 || series.Typical.Normalized <= 0 {
+					t.Errorf("%s series is missing its label or markers", series.Key)
+				}
+			}
+		})
+	}
+}
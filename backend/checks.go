@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkResult is the outcome of a single check of the deployment. When a
+// check fails, the feature it guards (if any) is reported as degraded.
+// Optional checks do not fail the dry-start report.
+type checkResult struct {
+	Name     string
+	OK       bool
+	Optional bool
+	Detail   string
+	Feature  string
+}
+
+// dryStartChecks validate the configuration of the deployment and its
+// external dependencies without serving any request. The behavior of the
+// code itself is covered by the tests.
+func dryStartChecks() []checkResult {
+	return []checkResult{
+		checkConfiguration(),
+		checkScoreVerification(),
+		checkNormativeDatasets(),
+		checkClinicalConfig(),
+		checkInterpretationRules(),
+		checkNormativeSamples(),
+		checkPromptTemplates(),
+		checkSampling(),
+		checkAnalysisCache(),
+		checkModelRegistry(),
+		checkTokenLimits(),
+		checkUsageLedger(),
+		checkCostEstimate(),
+		checkCircuitBreaker(),
+		checkProviderChain(),
+		checkModelRouting(),
+		checkReportVerification(),
+		checkWatermark(),
+		checkBranding(),
+		checkReportThemes(),
+		checkLaTeXTemplate(),
+		checkPDFEngine(),
+		checkChromePDF(),
+		checkPDFSigning(),
+		checkClaudeReachable(),
+		checkAnthropicVersion(),
+	}
+}
+
+// How long the results of the dependency checks are reused by /health and
+// /features before they are run again
+var dependencyCheckTTL = time.Duration(envInt("DEPENDENCY_CHECK_TTL_SECONDS", 30)) * time.Second
+
+// dependencyCheckCache keeps the results of the last dependency checks
+type dependencyCheckCache struct {
+	mu      sync.Mutex
+	results []checkResult
+	at      time.Time
+}
+
+var dependencyChecksCache = &dependencyCheckCache{}
+
+// Results returns the results of the dependency checks, running them again
+// once they are older than dependencyCheckTTL, so that health probes stay
+// fast while a feature still recovers soon after its dependency is fixed
+func (c *dependencyCheckCache) Results() []checkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil || time.Since(c.at) >= dependencyCheckTTL {
+		c.results, c.at = dependencyChecks(), time.Now()
+	}
+	return c.results
+}
+
+// dependencyChecks look for the credentials and tools that features
+// depend on
+func dependencyChecks() []checkResult {
+	return []checkResult{
+		checkProviderCredentials(),
+		checkPDFEngine(),
+		checkChromePDF(),
+		checkReportVerification(),
+		checkWatermark(),
+	}
+}
+
+func checkConfiguration() checkResult {
+	if err := loadProvider(); err != nil {
+		return checkResult{Name: "configuration", Feature: "analysis", Detail: err.Error()}
+	}
+	return checkProviderCredentials()
+}
+
+// checkProviderCredentials reports analyses as degraded while they fall
+// back to the template for lack of credentials
+func checkProviderCredentials() checkResult {
+	result := checkResult{Name: "configuration", Feature: "analysis"}
+	if unconfiguredProvider != "" {
+		result.Detail = "required environment variables are not set for " + unconfiguredProvider + ", analyses fall back to the template"
+		return result
+	}
+	result.OK = true
+	result.Detail = "required environment variables are set for " + activeProvider
+	return result
+}
+
+func checkLaTeXTemplate() checkResult {
+	result := checkResult{Name: "LaTeX template", Feature: "pdf"}
+	if latexTemplateErr != nil {
+		result.Detail = latexTemplateErr.Error()
+		return result
+	}
+	sample := AssessmentData{
+		Language: "en",
+		Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "Sample", AnswerText: "Never true"},
+		},
+		Demographics: &Demographics{Age: 34, Gender: "female"},
+	}
+	data, err := newLaTeXReportData(sample, Participant{Name: "Check"}, "")
+	if err == nil {
+		_, err = prepareLaTeXDocument(context.Background(), data)
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	result.OK = true
+	result.Detail = "renders and passes the pre-flight checks"
+	if latexTemplateDir != "" {
+		result.Detail = "report.tex of " + latexTemplateDir + " " + result.Detail
+	}
+	return result
+}
+
+func checkClaudeReachable() checkResult {
+	result := checkResult{Name: "Claude API", Feature: "analysis"}
+	baseURL := claudeBaseURL
+	switch activeProvider {
+	case providerOpenAI:
+		result.Name, baseURL = "OpenAI API", openAIBaseURL
+	case providerGemini:
+		result.Name, baseURL = "Gemini API", geminiEndpoint(geminiModel, "generateContent")
+	case providerOllama:
+		result.Name, baseURL = "Ollama", ollamaBaseURL
+	case providerAzureOpenAI:
+		result.Name, baseURL = "Azure OpenAI", azureOpenAIEndpoint
+	case providerBedrock:
+		result.Name, baseURL = "Bedrock", bedrockBaseURL()
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Head(baseURL)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.OK = true
+	result.Detail = fmt.Sprintf("%s reachable (HTTP %d)", baseURL, resp.StatusCode)
+	return result
+}
+
+// printCheckReport writes a human-readable pass/fail report and returns
+// whether every check passed
+func printCheckReport(results []checkResult) bool {
+	passed := true
+	for _, r := range results {
+		mark := "✅"
+		switch {
+		case !r.OK && r.Optional:
+			mark = "⚠️ "
+		case !r.OK:
+			mark = "❌"
+			passed = false
+		}
+		fmt.Printf("%s %-22s %s\n", mark, r.Name, r.Detail)
+	}
+	return passed
+}
+
+// degradedFeatureList returns the features whose required dependencies
+// are missing, and why. Optional dependencies, such as the signing key,
+// do not degrade their feature.
+func degradedFeatureList() map[string]string {
+	features := map[string]string{}
+	for _, r := range dependencyChecksCache.Results() {
+		if _, seen := features[r.Feature]; !r.OK && !r.Optional && r.Feature != "" && !seen {
+			features[r.Feature] = r.Name + ": " + r.Detail
+		}
+	}
+	return features
+}
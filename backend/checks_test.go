@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDependencyCheckCache makes sure the dependency checks are reused
+// within their TTL, and run again past it
+func TestDependencyCheckCache(t *testing.T) {
+	previous := unconfiguredProvider
+	t.Cleanup(func() {
+		unconfiguredProvider = previous
+		dependencyChecksCache = &dependencyCheckCache{}
+	})
+	dependencyChecksCache = &dependencyCheckCache{}
+
+	unconfiguredProvider = ""
+	if _, degraded := degradedFeatureList()["analysis"]; degraded {
+		t.Fatal("analysis degraded with its credentials set")
+	}
+	unconfiguredProvider = providerAnthropic
+	if _, degraded := degradedFeatureList()["analysis"]; degraded {
+		t.Error("the dependency checks were run again within their TTL")
+	}
+
+	dependencyChecksCache.at = time.Now().Add(-dependencyCheckTTL)
+	if _, degraded := degradedFeatureList()["analysis"]; !degraded {
+		t.Error("the dependency checks were not run again past their TTL")
+	}
+}
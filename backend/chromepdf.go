@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// PDF renderers: the LaTeX report compiled by a TeX engine, or the HTML
+// export printed by headless Chrome, for deployments without a TeX
+// distribution. PDF_RENDERER selects the default one.
+const (
+	pdfRendererLaTeX  = "latex"
+	pdfRendererChrome = "chrome"
+)
+
+var pdfRenderers = []string{pdfRendererLaTeX, pdfRendererChrome}
+
+var pdfRenderer = envString("PDF_RENDERER", pdfRendererLaTeX)
+
+// Chrome binary, looked up among the usual names in PATH unless
+// CHROME_PATH is set. CHROME_NO_SANDBOX disables the sandbox of Chrome,
+// which it cannot set up when running as root in a container.
+var (
+	chromePath      = os.Getenv("CHROME_PATH")
+	chromeNoSandbox = os.Getenv("CHROME_NO_SANDBOX") == "1"
+)
+
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless-shell"}
+
+// findChrome returns the path of the Chrome binary
+func findChrome() (string, error) {
+	if chromePath != "" {
+		return exec.LookPath(chromePath)
+	}
+	for _, name := range chromeNames {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("none of %v found in PATH", chromeNames)
+}
+
+// pdfRendererError reports why a renderer cannot produce PDFs, or "" when
+// it can
+func pdfRendererError(renderer string) string {
+	switch renderer {
+	case pdfRendererLaTeX:
+		if !pdfEngineAvailable() {
+			return pdfEngine + " is not available on this server"
+		}
+	case pdfRendererChrome:
+		if _, err := findChrome(); err != nil {
+			return "Chrome is not available on this server"
+		}
+	default:
+		return fmt.Sprintf("unknown PDF renderer %q (available: %v)", renderer, pdfRenderers)
+	}
+	return ""
+}
+
+// renderPDF renders the report of an export request to PDF with a
+// renderer, signed when PDF signing is configured and the export is of a
+// stored report, like its verification link. The HTML export has no
+// title page, so Chrome leaves out the participant details.
+func renderPDF(ctx context.Context, req ExportRequest, participant Participant, renderer, reportID string) ([]byte, error) {
+	var pdf []byte
+	var err error
+	if renderer == pdfRendererChrome {
+		pdf, err = renderChromePDF(ctx, req, reportID)
+	} else {
+		pdf, err = renderReportPDF(ctx, req, participant, reportID)
+	}
+	if err != nil || !req.stored {
+		return pdf, err
+	}
+	return signPDF(pdf)
+}
+
+// renderChromePDF prints the self-contained HTML export with headless
+// Chrome, in a scratch directory and without network access: the export
+// inlines its fonts and chart, and anything else is blocked by a proxy
+// that does not exist. It shares the compilation slots and timeout of the
+// LaTeX engine.
+func renderChromePDF(ctx context.Context, req ExportRequest, reportID string) ([]byte, error) {
+	chrome, err := findChrome()
+	if err != nil {
+		return nil, err
+	}
+	html, err := renderExportHTML(ctx, req, reportID)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case pdfCompileSlots <- struct{}{}:
+		defer func() { <-pdfCompileSlots }()
+	default:
+		return nil, errPDFCompilerBusy
+	}
+
+	dir, err := os.MkdirTemp("", "raads-chrome-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF build directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "report.html"), html, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write HTML report: %w", err)
+	}
+
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--disable-extensions",
+		"--disable-background-networking",
+		"--disable-sync",
+		"--no-first-run",
+		"--proxy-server=127.0.0.1:9",
+		"--proxy-bypass-list=<-loopback>",
+		"--user-data-dir=" + filepath.Join(dir, "profile"),
+		"--no-pdf-header-footer",
+		"--print-to-pdf-no-header",
+		"--print-to-pdf=" + filepath.Join(dir, "report.pdf"),
+	}
+	if chromeNoSandbox {
+		args = append(args, "--no-sandbox")
+	}
+	args = append(args, "file://"+filepath.Join(dir, "report.html"))
+
+	ctx, cancel := context.WithTimeout(ctx, pdfCompileTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, chrome, args...)
+	cmd.Dir = dir
+	cmd.Env = sandboxedEnv(dir)
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("chrome timed out after %s: %w", pdfCompileTimeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chrome failed: %w: %s", err, lastLines(string(output), 10))
+	}
+
+	pdf, err := os.ReadFile(filepath.Join(dir, "report.pdf"))
+	if err != nil {
+		return nil, fmt.Errorf("chrome wrote no PDF: %s", lastLines(string(output), 10))
+	}
+	return pdf, nil
+}
+
+// checkChromePDF verifies the PDF renderer in use, and reports the Chrome
+// binary when it renders the PDFs
+func checkChromePDF() checkResult {
+	result := checkResult{Name: "PDF renderer", Feature: "pdf", Optional: true}
+	if reason := pdfRendererError(pdfRenderer); reason != "" {
+		result.Detail = reason
+		return result
+	}
+	result.OK = true
+	result.Detail = pdfRenderer
+	if pdfRenderer == pdfRendererChrome {
+		path, _ := findChrome()
+		result.Detail += " (" + path + ", sandbox " + strconv.FormatBool(!chromeNoSandbox) + ")"
+	}
+	return result
+}
@@ -0,0 +1,257 @@
+// Package claudestream parses the Server-Sent Events stream of the Claude
+// Messages API into typed events, independently of how they are forwarded
+// to clients.
+package claudestream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// DefaultMaxLineBytes is the longest line kept by a Reader. Longer lines
+// are skipped.
+const DefaultMaxLineBytes = 1024 * 1024
+
+// Event is one of TextDelta, Usage, Stop or Error
+type Event interface {
+	event()
+}
+
+// TextDelta is a chunk of generated text
+type TextDelta struct {
+	Index int
+	Text  string
+}
+
+// Usage reports token counts. Input tokens are reported when the message
+// starts and output tokens when it ends; the other count is zero.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// Stop marks the end of the message
+type Stop struct {
+	Reason string
+}
+
+// Error is an error sent by the API in the middle of the stream
+type Error struct {
+	Type    string
+	Message string
+}
+
+func (TextDelta) event() {}
+func (Usage) event()     {}
+func (Stop) event()      {}
+func (Error) event()     {}
+
+func (e Error) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// Wire format of the upstream events
+type wireEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta *struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Message *struct {
+		Usage *wireUsage `json:"usage"`
+	} `json:"message"`
+	Usage *wireUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type wireUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Reader reads events from an upstream SSE stream
+type Reader struct {
+	r            *bufio.Reader
+	maxLineBytes int
+	stopReason   string
+	pending      []Event
+	skipped      int
+	done         bool
+}
+
+// NewReader returns a Reader consuming upstream SSE bytes from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:            bufio.NewReader(r),
+		maxLineBytes: DefaultMaxLineBytes,
+	}
+}
+
+// SetMaxLineBytes sets the longest line kept by the reader
+func (r *Reader) SetMaxLineBytes(n int) {
+	r.maxLineBytes = n
+}
+
+// Skipped returns the number of malformed or oversized events skipped so far
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
+
+// Next returns the next event, or io.EOF once the stream is over. Events
+// without meaning for the caller, such as pings, are not returned.
+func (r *Reader) Next() (Event, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return nil, io.EOF
+		}
+		data, err := r.readData()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if errors.Is(err, io.EOF) {
+			r.done = true
+		}
+		if data != nil {
+			r.decode(data)
+		}
+	}
+
+	event := r.pending[0]
+	r.pending = r.pending[1:]
+	return event, nil
+}
+
+// Read calls handle for every event until the stream is over or handle
+// returns an error
+func (r *Reader) Read(handle func(Event) error) error {
+	for {
+		event, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
+
+// readData returns the data of the next SSE event, joining multi-line data
+// fields. It returns nil data with io.EOF at the end of the stream.
+func (r *Reader) readData() ([]byte, error) {
+	var data []byte
+	hasData := false
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			if hasData {
+				return data, err
+			}
+			return nil, err
+		}
+		if line == nil {
+			continue
+		}
+
+		// A blank line dispatches the event
+		if len(line) == 0 {
+			if hasData {
+				return data, nil
+			}
+			continue
+		}
+
+		value, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			// event:, id:, retry: and comments carry nothing the JSON
+			// payload does not already have
+			continue
+		}
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if bytes.Equal(value, []byte("[DONE]")) {
+			return data, io.EOF
+		}
+		if hasData {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+		hasData = true
+	}
+}
+
+// readLine returns the next line without its line ending. Oversized lines
+// are discarded and returned as nil.
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	oversized := false
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		if !oversized {
+			if len(line)+len(chunk) > r.maxLineBytes+2 {
+				oversized = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if oversized {
+			r.skipped++
+			if err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+		if err != nil && (len(line) == 0 || !errors.Is(err, io.EOF)) {
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// decode queues the events carried by an upstream payload
+func (r *Reader) decode(data []byte) {
+	var wire wireEvent
+	if err := json.Unmarshal(data, &wire); err != nil {
+		r.skipped++
+		return
+	}
+
+	switch wire.Type {
+	case "message_start":
+		if wire.Message != nil && wire.Message.Usage != nil {
+			r.pending = append(r.pending, Usage{InputTokens: wire.Message.Usage.InputTokens})
+		}
+	case "content_block_delta":
+		if wire.Delta != nil && wire.Delta.Type == "text_delta" {
+			r.pending = append(r.pending, TextDelta{Index: wire.Index, Text: wire.Delta.Text})
+		}
+	case "message_delta":
+		if wire.Delta != nil && wire.Delta.StopReason != "" {
+			r.stopReason = wire.Delta.StopReason
+		}
+		if wire.Usage != nil {
+			r.pending = append(r.pending, Usage{OutputTokens: wire.Usage.OutputTokens})
+		}
+	case "message_stop":
+		r.pending = append(r.pending, Stop{Reason: r.stopReason})
+	case "error":
+		if wire.Error != nil {
+			r.pending = append(r.pending, Error{Type: wire.Error.Type, Message: wire.Error.Message})
+		} else {
+			r.pending = append(r.pending, Error{Type: "error"})
+		}
+	}
+}
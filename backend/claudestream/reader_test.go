@@ -0,0 +1,159 @@
+package claudestream
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll returns every event of a stream and the number of events skipped
+func readAll(t *testing.T, r *Reader) ([]Event, int) {
+	t.Helper()
+	var events []Event
+	if err := r.Read(func(event Event) error {
+		events = append(events, event)
+		return nil
+	}); err != nil {
+		t.Fatalf("the stream does not parse: %v", err)
+	}
+	return events, r.Skipped()
+}
+
+// TestReaderFixtures parses the streams of testdata, recorded or written
+// to exercise how the reader copes with what it may get from upstream
+func TestReaderFixtures(t *testing.T) {
+	tests := []struct {
+		fixture      string
+		maxLineBytes int
+		want         []Event
+		skipped      int
+	}{
+		{
+			// Pings, comments and errors between content events
+			fixture: "interleaved.sse",
+			want: []Event{
+				Usage{InputTokens: 100},
+				TextDelta{Index: 0, Text: "Hello"},
+				TextDelta{Index: 1, Text: " world"},
+				Error{Type: "overloaded_error", Message: "Overloaded"},
+				TextDelta{Index: 1, Text: "!"},
+				Usage{OutputTokens: 12},
+				Stop{Reason: "end_turn"},
+			},
+		},
+		{
+			// Invalid JSON is skipped, multi-line data is joined, and
+			// unknown events and deltas are ignored
+			fixture: "malformed.sse",
+			want: []Event{
+				Usage{InputTokens: 10},
+				TextDelta{Index: 0, Text: "joined"},
+				Error{Type: "error"},
+				Stop{},
+			},
+			skipped: 2,
+		},
+		{
+			// The connection dropped in the middle of an event: what came
+			// before is kept, and the missing Stop tells the stream is
+			// incomplete
+			fixture: "truncated.sse",
+			want: []Event{
+				Usage{InputTokens: 20},
+				TextDelta{Index: 0, Text: "Partial"},
+			},
+			skipped: 1,
+		},
+		{
+			// The last event is complete but not followed by a blank line
+			fixture: "unterminated.sse",
+			want: []Event{
+				Usage{InputTokens: 30},
+				TextDelta{Index: 0, Text: "Done"},
+				Usage{OutputTokens: 4},
+				Stop{Reason: "max_tokens"},
+			},
+		},
+		{
+			// The oversized line is skipped, and nothing is read past [DONE]
+			fixture:      "oversized.sse",
+			maxLineBytes: 256,
+			want: []Event{
+				TextDelta{Index: 0, Text: "kept"},
+				TextDelta{Index: 0, Text: "after"},
+			},
+			skipped: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(strings.TrimSuffix(tc.fixture, ".sse"), func(t *testing.T) {
+			f, err := os.Open("testdata/" + tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r := NewReader(f)
+			if tc.maxLineBytes > 0 {
+				r.SetMaxLineBytes(tc.maxLineBytes)
+			}
+			events, skipped := readAll(t, r)
+			if !reflect.DeepEqual(events, tc.want) {
+				t.Errorf("read %#v instead of %#v", events, tc.want)
+			}
+			if skipped != tc.skipped {
+				t.Errorf("%d events skipped instead of %d", skipped, tc.skipped)
+			}
+		})
+	}
+}
+
+// TestReaderOversizedLine makes sure a line larger than the default limit,
+// and than the read buffer, is skipped without being kept in memory whole
+func TestReaderOversizedLine(t *testing.T) {
+	huge := `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` +
+		strings.Repeat("x", DefaultMaxLineBytes) + `"}}`
+	stream := huge + "\n\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"next"}}` + "\n\n" +
+		huge
+	events, skipped := readAll(t, NewReader(strings.NewReader(stream)))
+	if want := []Event{TextDelta{Text: "next"}}; !reflect.DeepEqual(events, want) {
+		t.Errorf("read %#v instead of %#v", events, want)
+	}
+	if skipped != 2 {
+		t.Errorf("%d lines skipped instead of 2", skipped)
+	}
+}
+
+// failingReader returns an error after its content
+type failingReader struct {
+	content io.Reader
+	err     error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, r.err
+	}
+	return n, err
+}
+
+// TestReaderError makes sure errors reading the stream are returned after
+// the events read before them, rather than taken for its end
+func TestReaderError(t *testing.T) {
+	reset := errors.New("connection reset")
+	stream := `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"before"}}` + "\n\n"
+	r := NewReader(&failingReader{content: strings.NewReader(stream), err: reset})
+
+	event, err := r.Next()
+	if err != nil || !reflect.DeepEqual(event, TextDelta{Text: "before"}) {
+		t.Fatalf("read %#v, %v instead of the event before the error", event, err)
+	}
+	if _, err := r.Next(); !errors.Is(err, reset) {
+		t.Errorf("got %v instead of the read error", err)
+	}
+}
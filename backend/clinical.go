@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// JSON file overriding the clinical thresholds and neurotypical means of
+// the normative dataset for a deployment, e.g.
+// {"thresholds": {"total": 65, "social": 30}, "neurotypicalMeans": {"social": 12.5}}
+var clinicalConfigFile = os.Getenv("CLINICAL_CONFIG_FILE")
+
+// Error loading CLINICAL_CONFIG_FILE, which is applied before anything
+// reads the thresholds
+var clinicalConfigErr = loadClinicalConfig()
+
+// clinicalConfig are the thresholds and neurotypical means of the total
+// score ("total") and of the domains, by domain key. Missing keys keep
+// the values of the normative dataset.
+type clinicalConfig struct {
+	Thresholds        map[string]int     `json:"thresholds"`
+	NeurotypicalMeans map[string]float64 `json:"neurotypicalMeans"`
+}
+
+// loadClinicalConfig applies CLINICAL_CONFIG_FILE to the domains, the
+// total threshold, the interpretation rules and the default reference
+// profile, so that validation, prompts, charts and the LaTeX report all use
+// the same values
+func loadClinicalConfig() error {
+	if clinicalConfigFile == "" {
+		return nil
+	}
+	content, err := os.ReadFile(clinicalConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read clinical config file: %w", err)
+	}
+	var config clinicalConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("failed to parse clinical config file: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("invalid clinical config file: %w", err)
+	}
+	config.apply()
+	if err := validateInterpretationRules(interpretationRules, raadsMaxTotal); err != nil {
+		return fmt.Errorf("invalid clinical config file: %w", err)
+	}
+	return nil
+}
+
+// clinicalMax is the highest score of the total ("total") or of a domain
+func clinicalMax(key string) (int, bool) {
+	if key == "total" {
+		return raadsMaxTotal, true
+	}
+	domain, ok := domainByKey(key)
+	return domain.MaxScore(), ok
+}
+
+// validate checks that every key is known and every value is within the
+// range of its score
+func (c clinicalConfig) validate() error {
+	for key, threshold := range c.Thresholds {
+		max, ok := clinicalMax(key)
+		if !ok {
+			return fmt.Errorf("unknown threshold %q", key)
+		}
+		if threshold <= 0 || threshold > max {
+			return fmt.Errorf("%s threshold must be between 1 and %d, got %d", key, max, threshold)
+		}
+	}
+	for key, mean := range c.NeurotypicalMeans {
+		max, ok := clinicalMax(key)
+		if !ok {
+			return fmt.Errorf("unknown neurotypical mean %q", key)
+		}
+		if mean < 0 || mean > float64(max) {
+			return fmt.Errorf("%s neurotypical mean must be between 0 and %d, got %g", key, max, mean)
+		}
+	}
+	return nil
+}
+
+func (c clinicalConfig) apply() {
+	if threshold, ok := c.Thresholds["total"]; ok {
+		moveInterpretationBoundary(totalThreshold, threshold)
+		totalThreshold = threshold
+	}
+	profile := referenceProfiles[normativeDataset.DefaultProfile]
+	if mean, ok := c.NeurotypicalMeans["total"]; ok {
+		profile.Total = mean
+	}
+	for i := range raadsDomains {
+		d := &raadsDomains[i]
+		if threshold, ok := c.Thresholds[d.Key]; ok {
+			d.Threshold = threshold
+		}
+		if mean, ok := c.NeurotypicalMeans[d.Key]; ok {
+			d.NTMean = mean
+			profile.Domains[d.Key] = mean
+		}
+	}
+	referenceProfiles[normativeDataset.DefaultProfile] = profile
+}
+
+// checkClinicalConfig verifies the thresholds and neurotypical means in
+// use, and that the domains agree with the default reference profile
+func checkClinicalConfig() checkResult {
+	result := checkResult{Name: "clinical thresholds", Feature: "analysis"}
+	if clinicalConfigErr != nil {
+		result.Detail = clinicalConfigErr.Error()
+		return result
+	}
+
+	profile := referenceProfiles[normativeDataset.DefaultProfile]
+	parts := []string{fmt.Sprintf("total %d", totalThreshold)}
+	for _, d := range raadsDomains {
+		if profile.Domains[d.Key] != d.NTMean {
+			result.Detail = fmt.Sprintf("%s neurotypical mean is %g, but %g in the %s profile", d.Key, d.NTMean, profile.Domains[d.Key], profile.Key)
+			return result
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", d.Key, d.Threshold))
+	}
+
+	result.OK = true
+	result.Detail = strings.Join(parts, ", ")
+	if clinicalConfigFile != "" {
+		result.Detail += " (from " + clinicalConfigFile + ")"
+	}
+	return result
+}
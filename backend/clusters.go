@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// ItemCluster is a group of related items within a RAADS-R domain, so that
+// the analysis can tell sub-patterns apart, such as circumscribed interests
+// and insistence on sameness within the Circumscribed Interests domain.
+// Items are the question IDs of the English catalog.
+type ItemCluster struct {
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+	Items  []int  `json:"items"`
+}
+
+// Item clusters of the RAADS-R, grouping the items of each domain of the
+// English catalog by content. They are descriptive only: unlike the
+// domains, they have no published thresholds or norms.
+var itemClusters = []ItemCluster{
+	{Key: "empathy", Name: "Empathy and emotional reciprocity", Domain: "social", Items: []int{1, 6, 18, 25, 28, 37, 38, 46, 49, 54}},
+	{Key: "social_cues", Name: "Reading social cues", Domain: "social", Items: []int{39, 44, 45, 61, 69, 76}},
+	{Key: "social_missteps", Name: "Unintended social missteps", Domain: "social", Items: []int{3, 12, 79, 80}},
+	{Key: "camouflaging", Name: "Camouflaging", Domain: "social", Items: []int{20, 22, 55}},
+	{Key: "relationships", Name: "Social motivation and relationships", Domain: "social", Items: []int{8, 11, 14, 31, 43, 62, 72, 77}},
+	{Key: "social_ease", Name: "Ease in social situations and groups", Domain: "social", Items: []int{5, 17, 21, 23, 26, 48, 56, 65}},
+	{Key: "sensory_over", Name: "Sensory over-responsivity", Domain: "sensory", Items: []int{10, 19, 29, 36, 42, 58, 68, 73, 74}},
+	{Key: "sensory_fluctuation", Name: "Fluctuating and under-responsive perception", Domain: "sensory", Items: []int{34, 47, 60, 71}},
+	{Key: "voice", Name: "Voice and prosody", Domain: "sensory", Items: []int{4, 33, 50, 63}},
+	{Key: "motor", Name: "Motor coordination and repetitive movements", Domain: "sensory", Items: []int{16, 52, 66}},
+	{Key: "interests", Name: "Circumscribed interests", Domain: "restricted", Items: []int{13, 41, 51, 53}},
+	{Key: "sameness", Name: "Insistence on sameness", Domain: "restricted", Items: []int{30, 64, 75}},
+	{Key: "detail", Name: "Focus on detail", Domain: "restricted", Items: []int{9, 40, 70, 78}},
+	{Key: "perseveration", Name: "Conversational perseveration", Domain: "restricted", Items: []int{24, 32, 57}},
+	{Key: "figurative", Name: "Figurative language", Domain: "language", Items: []int{7, 27, 35, 67}},
+	{Key: "pragmatics", Name: "Conversational language use", Domain: "language", Items: []int{2, 15, 59}},
+}
+
+// The translated catalogs leave out one English question, 46 (38 in
+// Russian), and add one of their own, 69. From that first question on up to
+// 68, a translated question is numbered one below its English counterpart.
+var translatedCatalogShifts = map[string]int{"fr": 46, "es": 46, "it": 46, "de": 46, "ru": 38}
+
+const (
+	lastShiftedItem = 68
+	// "I like to be alone as much as possible", only in translated catalogs
+	translatedOnlyItem   = 69
+	translatedOnlyItemIn = "relationships"
+)
+
+// itemClusterOf returns the cluster of a question of the catalog of a
+// language
+func itemClusterOf(language string, id int) (ItemCluster, bool) {
+	if from, ok := translatedCatalogShifts[language]; ok {
+		switch {
+		case id == translatedOnlyItem:
+			return itemClusterByKey(translatedOnlyItemIn)
+		case id >= from && id <= lastShiftedItem:
+			id++
+		}
+	}
+	for _, cluster := range itemClusters {
+		if slices.Contains(cluster.Items, id) {
+			return cluster, true
+		}
+	}
+	return ItemCluster{}, false
+}
+
+// itemClusterByKey returns the cluster with the given key
+func itemClusterByKey(key string) (ItemCluster, bool) {
+	for _, cluster := range itemClusters {
+		if cluster.Key == key {
+			return cluster, true
+		}
+	}
+	return ItemCluster{}, false
+}
+
+// ClusterScore is the score of an assessment on an item cluster, with the
+// questions of the cluster in the language of the assessment. The mean item
+// score, from 0 to 3, compares clusters of different sizes.
+type ClusterScore struct {
+	Key        string  `json:"key"`
+	Name       string  `json:"name"`
+	Domain     string  `json:"domain"`
+	DomainName string  `json:"domain_name"`
+	Items      []int   `json:"items"`
+	Score      int     `json:"score"`
+	Max        int     `json:"max"`
+	Answered   int     `json:"answered"`
+	Mean       float64 `json:"mean"`
+}
+
+// itemClusterScores scores the item clusters of a RAADS-R assessment.
+// Clusters without any of their questions in the assessment, such as those
+// of other domains in a domain report, are left out.
+func itemClusterScores(data AssessmentData) []ClusterScore {
+	byKey := map[string]*ClusterScore{}
+	for _, qa := range data.QuestionsAndAnswers {
+		cluster, ok := itemClusterOf(data.Language, qa.ID)
+		if !ok {
+			continue
+		}
+		score, ok := byKey[cluster.Key]
+		if !ok {
+			domain, _ := domainByKey(cluster.Domain)
+			score = &ClusterScore{Key: cluster.Key, Name: cluster.Name, Domain: cluster.Domain, DomainName: domain.Name}
+			byKey[cluster.Key] = score
+		}
+		score.Items = append(score.Items, qa.ID)
+		score.Score += qa.Score
+		score.Max += 3
+		if qa.AnswerText != "" {
+			score.Answered++
+		}
+	}
+
+	var scores []ClusterScore
+	for _, cluster := range itemClusters {
+		score, ok := byKey[cluster.Key]
+		if !ok {
+			continue
+		}
+		if score.Answered > 0 {
+			score.Mean = math.Round(float64(score.Score)/float64(score.Answered)*100) / 100
+		}
+		scores = append(scores, *score)
+	}
+	return scores
+}
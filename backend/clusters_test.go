@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestItemClusters(t *testing.T) {
+	clusterOf := map[int]ItemCluster{}
+	for _, cluster := range itemClusters {
+		if _, ok := domainByKey(cluster.Domain); !ok {
+			t.Errorf("cluster %s has unknown domain %q", cluster.Key, cluster.Domain)
+		}
+		for _, id := range cluster.Items {
+			if other, ok := clusterOf[id]; ok {
+				t.Errorf("question %d is in both the %s and %s clusters", id, other.Key, cluster.Key)
+			}
+			clusterOf[id] = cluster
+		}
+	}
+	if len(clusterOf) != raadsMaxTotal/3 {
+		t.Errorf("the clusters have %d questions instead of %d", len(clusterOf), raadsMaxTotal/3)
+	}
+}
+
+// TestItemClustersCatalogs places every question of every catalog in a
+// cluster of its own domain
+func TestItemClustersCatalogs(t *testing.T) {
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			catalog, err := catalogFor(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range catalog.Questions {
+				domain, _ := domainForCategory(canonicalCategory(q.Category))
+				cluster, ok := itemClusterOf(code, q.ID)
+				if !ok {
+					t.Errorf("question %d is in no cluster", q.ID)
+					continue
+				}
+				if cluster.Domain != domain.Key {
+					t.Errorf("question %d of the %s domain is in the %s cluster of the %s domain", q.ID, domain.Key, cluster.Key, cluster.Domain)
+				}
+			}
+		})
+	}
+}
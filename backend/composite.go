@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Output tokens requested for a composite report, which covers several
+// instruments and how they relate
+var compositeMaxTokens = envInt("COMPOSITE_MAX_TOKENS", 10000)
+
+// CompositeRequest is several assessments of the same participant, one per
+// instrument, to be analyzed together
+type CompositeRequest struct {
+	Assessments []AssessmentData `json:"assessments"`
+}
+
+// CompositeExportRequest is a composite report to compile as a PDF, with
+// the analysis generated by /analyze/composite
+type CompositeExportRequest struct {
+	CompositeRequest
+	Markdown    string      `json:"markdown"`
+	Participant Participant `json:"participant"`
+	// Report theme, REPORT_THEME when empty
+	Theme string `json:"theme,omitempty"`
+}
+
+// validateCompositeAssessments validates every assessment of a composite
+// report, and checks that they are taken with distinct instruments in the
+// same language
+func validateCompositeAssessments(ctx context.Context, assessments []AssessmentData) error {
+	if len(assessments) < 2 || len(assessments) > len(instrumentKeys) {
+		return fmt.Errorf("a composite report needs between 2 and %d assessments, got %d", len(instrumentKeys), len(assessments))
+	}
+	seen := make(map[string]bool, len(assessments))
+	for i := range assessments {
+		if err := validateAssessmentData(ctx, &assessments[i]); err != nil {
+			return fmt.Errorf("assessment %d: %w", i+1, err)
+		}
+		instrument := instrumentOf(assessments[i])
+		if seen[instrument.Key] {
+			return fmt.Errorf("assessment %d: the %s is already part of the report", i+1, instrument.Test.Name)
+		}
+		seen[instrument.Key] = true
+		if assessments[i].Language != assessments[0].Language {
+			return fmt.Errorf("assessment %d: language %q differs from %q, the assessments of a composite report share their language", i+1, assessments[i].Language, assessments[0].Language)
+		}
+	}
+	return nil
+}
+
+// compositeTestNames lists the instruments of a composite report, e.g.
+// "RAADS-R and AQ-50"
+func compositeTestNames(assessments []AssessmentData) string {
+	names := make([]string, 0, len(assessments))
+	for _, data := range assessments {
+		names = append(names, instrumentOf(data).Test.Name)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// compositeInput inlines the assessments of a composite report in the
+// prompt, each with its instrument spelled out
+func compositeInput(ctx context.Context, assessments []AssessmentData) (assessmentInput, error) {
+	safe := make([]AssessmentData, 0, len(assessments))
+	for _, data := range assessments {
+		data.AdditionalContext = ""
+		data.Instrument = instrumentOf(data).Key
+		safe = append(safe, promptSafeAssessment(ctx, data))
+	}
+	indented, err := json.MarshalIndent(safe, "", "  ")
+	if err != nil {
+		return assessmentInput{}, fmt.Errorf("failed to serialize assessment data: %w", err)
+	}
+	return assessmentInput{Mode: inputModeInline, Inline: string(indented), Assessment: safe[0], Composite: safe}, nil
+}
+
+// compositePrompt builds the prompt of a composite report, with a summary
+// of every assessment and the contexts they were given with
+func compositePrompt(assessments []AssessmentData, input assessmentInput, version int) (string, error) {
+	prompt, err := newPromptData(assessments[0], input)
+	if err != nil {
+		return "", err
+	}
+	prompt.Test = compositeTestNames(assessments)
+
+	var contexts []string
+	for _, data := range assessments {
+		summary, err := newPromptData(data, assessmentInput{})
+		if err != nil {
+			return "", err
+		}
+		prompt.Instruments = append(prompt.Instruments, summary)
+		if summary.ParticipantContext != "" && !slices.Contains(contexts, summary.ParticipantContext) {
+			contexts = append(contexts, summary.ParticipantContext)
+		}
+	}
+	prompt.ParticipantContext = strings.Join(contexts, "\n\n")
+	return renderPrompt(version, "composite.tmpl", prompt)
+}
+
+// compositeSummary is the scores of one assessment of a composite report
+func compositeSummary(data AssessmentData) gin.H {
+	instrument := instrumentOf(data)
+	summary := gin.H{
+		"instrument":     instrument.Key,
+		"test":           instrument.Test.Name,
+		"scores":         data.Scores,
+		"interpretation": data.Interpretation,
+	}
+	// The RAADS-R has no details, its domains are reported instead
+	if instrument.Details == nil {
+		summary["domains"] = domainTotals(data)
+		return summary
+	}
+	for key, value := range instrument.Details(data) {
+		summary[key] = value
+	}
+	return summary
+}
+
+// analyzeCompositeHandler generates a single report of several instruments,
+// cross-referencing their findings
+func analyzeCompositeHandler(c *gin.Context) {
+	var req CompositeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+	if err := validateCompositeAssessments(c.Request.Context(), req.Assessments); err != nil {
+		log.Printf("❌ Invalid composite assessments: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	if err := aiServiceAvailable(); aiUnavailable(c, err) {
+		log.Printf("⚠️  Rejecting composite analysis: %v", err)
+		return
+	}
+
+	slot, err := workers.Acquire(c.Request.Context(), requestPriority(c))
+	if err != nil {
+		info := workers.Busy()
+		log.Printf("⚠️  Rejecting composite analysis: %v (queue depth %d)", err, info.QueueDepth)
+		c.Header("Retry-After", strconv.Itoa(info.RetryAfterSeconds))
+		c.JSON(503, busyResponse(info))
+		return
+	}
+	completed := false
+	defer func() { slot.Release(completed) }()
+
+	hashes := make([]string, 0, len(req.Assessments))
+	for _, data := range req.Assessments {
+		hash, err := assessmentHash(data)
+		if err != nil {
+			log.Printf("❌ Error hashing assessment data: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to process assessment data: " + err.Error()})
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+
+	reportID := uuid.New().String()
+	usageFrom(c.Request.Context()).SetReport(reportID)
+	log.Printf("🧠 Processing composite analysis request %s (%s)", reportID, compositeTestNames(req.Assessments))
+
+	input, err := compositeInput(c.Request.Context(), req.Assessments)
+	if err == nil {
+		input.System, err = renderPrompt(promptVersion, "system.tmpl", promptData{Tone: reportTone(req.Assessments[0]), Test: compositeTestNames(req.Assessments)})
+	}
+	var prompt string
+	if err == nil {
+		prompt, err = compositePrompt(req.Assessments, input, promptVersion)
+	}
+	if err != nil {
+		log.Printf("❌ Error preparing composite analysis: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+
+	route := routeModel(c.Request.Context(), modeFull)
+	markdown, err := completeStructuredMarkdown(c.Request.Context(), input, prompt, route.Model, compositeMaxTokens)
+	if err != nil {
+		log.Printf("❌ Error generating composite analysis: %v", err)
+		if aiUnavailable(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+	completed = true
+	log.Printf("✅ Generated composite analysis (%d characters)", len(markdown))
+
+	summaries := make([]gin.H, 0, len(req.Assessments))
+	for i, data := range req.Assessments {
+		summary := compositeSummary(data)
+		summary["assessment_hash"] = hashes[i]
+		summaries = append(summaries, summary)
+	}
+	response := gin.H{
+		"success":          true,
+		"report_id":        reportID,
+		"instruments":      summaries,
+		"prompt_version":   promptVersion,
+		"markdown":         markdown,
+		"reading":          analysisReadingStats(markdown, req.Assessments[0].Language),
+		"input_mode":       input.Mode,
+		"generated_at":     time.Now().UTC(),
+		"analysis_version": currentAnalysisVersion(),
+		"model":            route,
+	}
+	if usage, ok := usageTotals.Report(reportID); ok {
+		response["usage"] = usage.TokenUsage
+	}
+
+	html, err := renderMarkdown(markdown, "composite_report")
+	if err != nil {
+		warningsFrom(c.Request.Context()).Add(Warning{
+			Code:    warnRenderFallback,
+			Message: "failed to convert analysis to HTML: " + err.Error(),
+			Section: "analysis",
+		})
+		response["analysis"] = nil
+	} else {
+		response["analysis"] = html
+	}
+	if warnings := warningsFrom(c.Request.Context()).List(); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	c.JSON(200, response)
+}
+
+// newCompositeLaTeXReportData assembles the LaTeX report of a composite
+// analysis: a score table of the instruments, and their answers in a single
+// appendix
+func newCompositeLaTeXReportData(assessments []AssessmentData, participant Participant, analysis string) (LaTeXReportData, error) {
+	if len(assessments) == 0 {
+		return LaTeXReportData{}, errors.New("a composite report needs assessments")
+	}
+	first := assessments[0]
+	babel, ok := babelLanguages[first.Language]
+	if !ok {
+		babel = babelLanguages["en"]
+	}
+
+	labels, evaluationDate := localizedLaTeXLabels(first)
+	labels.TestName = "Composite Report"
+	if catalog, err := catalogFor(first.Language); err == nil {
+		labels.TestName = catalog.Report.CompositeTitle
+	}
+	labels.TestFullName = compositeTestNames(assessments)
+
+	report := LaTeXReportData{
+		Babel:           babel,
+		Labels:          labels,
+		Participant:     participant,
+		EvaluationDate:  evaluationDate,
+		AnalysisVersion: currentAnalysisVersion(),
+		Analysis:        analysis,
+		Watermark:       reportWatermark,
+		Branding:        brandings[defaultBrandingKey],
+		Theme:           reportThemes[themeClinical],
+	}
+	var contexts []string
+	for _, data := range assessments {
+		name := instrumentOf(data).Test.Name
+		report.Instruments = append(report.Instruments, LaTeXInstrumentRow{
+			Name:           name,
+			Score:          data.Scores.Total,
+			Max:            data.Scores.MaxTotal,
+			Interpretation: data.Interpretation.Level,
+		})
+		report.AppendixSections = append(report.AppendixSections, LaTeXAppendixSection{Title: name, Items: latexAppendix(data)})
+		if data.AdditionalContext != "" && !slices.Contains(contexts, data.AdditionalContext) {
+			contexts = append(contexts, data.AdditionalContext)
+		}
+	}
+	report.AdditionalContext = strings.Join(contexts, "\n\n")
+	return report, nil
+}
+
+// exportCompositeHandler compiles the PDF of a composite report
+func exportCompositeHandler(c *gin.Context) {
+	var req CompositeExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+	if !pdfEngineAvailable() {
+		c.JSON(503, gin.H{"error": pdfEngine + " is not available on this server"})
+		return
+	}
+	if err := validateCompositeAssessments(c.Request.Context(), req.Assessments); err != nil {
+		log.Printf("❌ Invalid composite assessments: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	theme, err := reportThemeFor(req.Theme)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := newCompositeLaTeXReportData(req.Assessments, req.Participant, markdownToLaTeX(req.Markdown))
+	var document string
+	if err == nil {
+		report.Theme = theme
+		report.Branding = theme.apply(brandingFor(c.Request.Context()))
+		document, err = prepareLaTeXDocument(c.Request.Context(), report)
+	}
+	if err != nil {
+		log.Printf("❌ Error rendering composite report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render report: " + err.Error()})
+		return
+	}
+	// Not signed, as the analysis and assessments are those of the client
+	pdf, err := compileLaTeXPDF(c.Request.Context(), document, report.Branding.latexFiles())
+	if err != nil {
+		pdfReportFailed(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="composite-report-%s.pdf"`, uuid.New().String()))
+	c.Data(200, "application/pdf", pdf)
+}
+
+// compositeTemplateMarkdown is templateMarkdown for a composite report: the
+// summary of every instrument, without cross-references
+func compositeTemplateMarkdown(assessments []AssessmentData) (string, error) {
+	var md strings.Builder
+	md.WriteString("## Executive Summary\n\n")
+	md.WriteString("> The AI analysis service is currently unavailable. This report only summarizes your scores; generate the analysis again later for a detailed interpretation.\n\n")
+	fmt.Fprintf(&md, "This report combines the %s.\n\n", compositeTestNames(assessments))
+
+	md.WriteString("## Results by Instrument\n\n")
+	for _, data := range assessments {
+		fmt.Fprintf(&md, "- **%s**: %d / %d", instrumentOf(data).Test.Name, data.Scores.Total, data.Scores.MaxTotal)
+		if data.Interpretation.Level != "" {
+			fmt.Fprintf(&md, ", %s", data.Interpretation.Level)
+		}
+		md.WriteString("\n")
+	}
+	md.WriteString("\n## Next Steps\n\n")
+	md.WriteString("These questionnaires are screening tools, not a diagnosis. Only a qualified clinician can assess autism, taking your history and current situation into account.\n")
+	return md.String(), nil
+}
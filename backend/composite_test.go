@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompositeReport(t *testing.T) {
+	ctx := context.Background()
+	assessments := []AssessmentData{
+		uniformAssessment(t, aq50Instrument(), 1),
+		uniformAssessment(t, rbq2aInstrument(), 1),
+	}
+	if err := validateCompositeAssessments(ctx, assessments); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := compositeInput(ctx, assessments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt, err := compositePrompt(assessments, input, promptVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range assessments {
+		if !strings.Contains(prompt, "=== "+instrumentOf(data).Test.Name) {
+			t.Errorf("the version %d composite prompt does not summarize the %s", promptVersion, instrumentOf(data).Test.Name)
+		}
+	}
+	if len(requiredOutline(prompt)) == 0 {
+		t.Errorf("the version %d composite prompt has no report structure", promptVersion)
+	}
+
+	report, err := newCompositeLaTeXReportData(assessments, Participant{Name: "Test"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := prepareLaTeXDocument(ctx, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(document, `\subsection*{`) < len(assessments) {
+		t.Error("the LaTeX appendix does not have a section per instrument")
+	}
+}
+
+func TestCompositeSameInstrument(t *testing.T) {
+	data := uniformAssessment(t, aq50Instrument(), 1)
+	if err := validateCompositeAssessments(context.Background(), []AssessmentData{data, data}); err == nil {
+		t.Error("two assessments of the same instrument are accepted")
+	}
+}
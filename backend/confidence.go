@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Confidence levels of the scores
+const (
+	confidenceHigh     = "high"
+	confidenceModerate = "moderate"
+	confidenceLow      = "low"
+)
+
+const (
+	// Below this completion rate, in percent, confidence is low
+	lowCompletionRate = 80.0
+	// A score this close to a threshold, as a fraction of the scale
+	// maximum, is near it
+	nearThresholdFraction = 0.05
+)
+
+// ThresholdProximity places a score relative to its clinical threshold.
+// CouldCross is set when answering the unanswered questions could still
+// bring a score below the threshold over it.
+type ThresholdProximity struct {
+	Key        string `json:"key"`
+	Label      string `json:"label"`
+	Score      int    `json:"score"`
+	Max        int    `json:"max"`
+	Threshold  int    `json:"threshold"`
+	Distance   int    `json:"distance"`
+	Over       bool   `json:"over_threshold"`
+	Near       bool   `json:"near_threshold"`
+	Unanswered int    `json:"unanswered"`
+	CouldCross bool   `json:"could_cross"`
+}
+
+// ScoreConfidence tells how much the scores of an assessment can be relied
+// on, for the frontend to show uncertainty alongside the analysis. Reasons
+// explain a level below high.
+type ScoreConfidence struct {
+	Level          string               `json:"level"`
+	Reasons        []string             `json:"reasons,omitempty"`
+	CompletionRate float64              `json:"completion_rate"`
+	Validity       []ValidityFlag       `json:"validity,omitempty"`
+	Thresholds     []ThresholdProximity `json:"thresholds,omitempty"`
+}
+
+// newThresholdProximity compares a score with its threshold
+func newThresholdProximity(key, label string, score, max, threshold, unanswered, itemMax int) ThresholdProximity {
+	distance := score - threshold
+	over := score >= threshold
+	return ThresholdProximity{
+		Key:        key,
+		Label:      label,
+		Score:      score,
+		Max:        max,
+		Threshold:  threshold,
+		Distance:   distance,
+		Over:       over,
+		Near:       math.Abs(float64(distance)) <= nearThresholdFraction*float64(max),
+		Unanswered: unanswered,
+		CouldCross: !over && score+unanswered*itemMax >= threshold,
+	}
+}
+
+// thresholdProximities compares the total score, and the RAADS-R domain
+// scores, with their thresholds. Instruments without a cut-off have none.
+func thresholdProximities(data AssessmentData) []ThresholdProximity {
+	instrument := instrumentOf(data)
+	if instrument.Threshold == 0 {
+		return nil
+	}
+
+	unanswered := map[string]int{}
+	total := 0
+	for _, qa := range data.QuestionsAndAnswers {
+		if qa.AnswerText != "" {
+			continue
+		}
+		total++
+		if d, ok := domainForCategory(qa.Category); ok {
+			unanswered[d.Key]++
+		}
+	}
+	// Questions never sent count as unanswered too
+	total += max(data.Metadata.TotalQuestions-len(data.QuestionsAndAnswers), 0)
+
+	proximities := []ThresholdProximity{
+		newThresholdProximity("total", "Total", data.Scores.Total, data.Scores.MaxTotal, instrument.Threshold, total, instrument.ItemMax),
+	}
+	if instrument.Key != instrumentRAADSR {
+		return proximities
+	}
+	totals := domainTotals(data)
+	for _, d := range raadsDomains {
+		proximities = append(proximities, newThresholdProximity(d.Key, d.Name, totals[d.Key], d.MaxScore(), d.Threshold, unanswered[d.Key], instrument.ItemMax))
+	}
+	return proximities
+}
+
+// scoreConfidence gathers the completion rate, validity flags and proximity
+// to the thresholds of an assessment into a confidence level. Confidence is
+// low with validity flags, a low completion rate, or unanswered questions
+// that could bring a score over its threshold, and moderate with missing
+// answers or a score near a threshold.
+func scoreConfidence(data AssessmentData) ScoreConfidence {
+	confidence := ScoreConfidence{
+		Level:      confidenceHigh,
+		Validity:   validityFlags(data),
+		Thresholds: thresholdProximities(data),
+	}
+	if data.Metadata.TotalQuestions > 0 {
+		rate := float64(data.Metadata.AnsweredQuestions) / float64(data.Metadata.TotalQuestions) * 100
+		confidence.CompletionRate = math.Round(rate*10) / 10
+	}
+
+	lower := func(level, reason string) {
+		if level == confidenceLow || confidence.Level == confidenceHigh {
+			confidence.Level = level
+		}
+		confidence.Reasons = append(confidence.Reasons, reason)
+	}
+	for _, flag := range confidence.Validity {
+		lower(confidenceLow, flag.Message)
+	}
+	switch {
+	case confidence.CompletionRate < lowCompletionRate:
+		lower(confidenceLow, fmt.Sprintf("only %.1f%% of the questions were answered", confidence.CompletionRate))
+	case confidence.CompletionRate < 100:
+		lower(confidenceModerate, fmt.Sprintf("%.1f%% of the questions were answered", confidence.CompletionRate))
+	}
+	for _, t := range confidence.Thresholds {
+		switch {
+		case t.CouldCross:
+			lower(confidenceLow, fmt.Sprintf("answering the %d unanswered questions could bring the %s score over its threshold", t.Unanswered, t.Label))
+		case t.Near:
+			lower(confidenceModerate, fmt.Sprintf("the %s score of %d is near its threshold of %d", t.Label, t.Score, t.Threshold))
+		}
+	}
+	return confidence
+}
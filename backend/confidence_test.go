@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScoreConfidence(t *testing.T) {
+	catalog, err := catalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scores 3 on every question, with varied answers, far over the
+	// thresholds, except for the questions of the language domain, which
+	// add up to its threshold or are left unanswered
+	language, _ := domainByKey("language")
+	assessment := func(languageAnswered bool) AssessmentData {
+		data := AssessmentData{Language: "en"}
+		remaining := language.Threshold
+		for _, q := range catalog.Questions {
+			qa := QuestionAndAnswer{ID: q.ID, Category: q.Category, Reverse: q.Reverse, Answer: q.ID % 4, AnswerText: "answered", Score: 3}
+			if d, _ := domainForCategory(q.Category); d.Key == language.Key {
+				qa.Score = min(remaining, 3)
+				remaining -= qa.Score
+				if !languageAnswered {
+					qa.Score, qa.AnswerText = 0, ""
+				}
+			}
+			data.QuestionsAndAnswers = append(data.QuestionsAndAnswers, qa)
+			if qa.AnswerText != "" {
+				data.Metadata.AnsweredQuestions++
+			}
+		}
+		data.Metadata.TotalQuestions = len(data.QuestionsAndAnswers)
+		data.Scores = scoresFromItems(data.QuestionsAndAnswers)
+		return data
+	}
+	far := assessment(true)
+	for i := range far.QuestionsAndAnswers {
+		far.QuestionsAndAnswers[i].Score = 3
+	}
+	far.Scores = scoresFromItems(far.QuestionsAndAnswers)
+
+	tests := []struct {
+		name string
+		data AssessmentData
+		want string
+	}{
+		{"an assessment far over the thresholds", far, confidenceHigh},
+		{"a language score at its threshold", assessment(true), confidenceModerate},
+		{fmt.Sprintf("%d unanswered language questions", language.Items), assessment(false), confidenceLow},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := scoreConfidence(tc.data); got.Level != tc.want {
+				t.Errorf("confidence %s instead of %s: %v", got.Level, tc.want, got.Reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCredentialName = "default"
+
+// claudeCredential is a named Anthropic API key, each billed separately
+type claudeCredential struct {
+	Name   string
+	apiKey string
+	// Priority class of the traffic billed to this credential
+	Priority priorityClass
+
+	requests     atomic.Int64
+	failures     atomic.Int64
+	inputTokens  atomic.Int64
+	outputTokens atomic.Int64
+}
+
+// credentialRegistry holds the configured credentials:
+//   - CLAUDE_API_KEY is the default credential, used for anonymous traffic.
+//     It is optional when client API keys are configured, anonymous
+//     requests then being refused a credential.
+//   - CLAUDE_API_KEYS adds named credentials, as "partner=sk-...,backup=sk-..."
+//   - CLAUDE_FAILOVER_CREDENTIAL names the credential used when another one
+//     is rejected for authentication or quota reasons
+//   - CLIENT_API_KEYS maps client API keys, sent in X-API-Key, to the
+//     credential their traffic is billed to, as "client-key=partner,..."
+//   - CREDENTIAL_PRIORITIES sets the worker pool priority class of the
+//     traffic of a credential, as "partner=batch"; the default is interactive
+type credentialRegistry struct {
+	once sync.Once
+	err  error
+
+	byName   map[string]*claudeCredential
+	failover *claudeCredential
+	clients  map[string]*claudeCredential
+}
+
+var claudeCredentials = &credentialRegistry{}
+
+// errNoCredentials is returned by the registry when no credential is
+// configured at all
+var errNoCredentials = errors.New("CLAUDE_API_KEY is not set")
+
+// errAnonymousCredential is returned for anonymous requests when only client
+// API keys are configured
+var errAnonymousCredential = errors.New("an X-API-Key is required, as CLAUDE_API_KEY is not set")
+
+// Load reads the credentials configuration. It runs once.
+func (r *credentialRegistry) Load() error {
+	r.once.Do(func() {
+		r.err = r.load()
+	})
+	return r.err
+}
+
+func (r *credentialRegistry) load() error {
+	r.byName = map[string]*claudeCredential{}
+	r.clients = map[string]*claudeCredential{}
+
+	if claudeAPIKey != "" {
+		r.byName[defaultCredentialName] = &claudeCredential{Name: defaultCredentialName, apiKey: claudeAPIKey}
+	}
+
+	for name, key := range parseKeyValueList(os.Getenv("CLAUDE_API_KEYS")) {
+		if _, exists := r.byName[name]; exists {
+			return fmt.Errorf("duplicate Claude credential %q", name)
+		}
+		r.byName[name] = &claudeCredential{Name: name, apiKey: key}
+	}
+
+	if name := os.Getenv("CLAUDE_FAILOVER_CREDENTIAL"); name != "" {
+		credential, ok := r.byName[name]
+		if !ok {
+			return fmt.Errorf("unknown failover credential %q", name)
+		}
+		r.failover = credential
+	}
+
+	for name, class := range parseKeyValueList(os.Getenv("CREDENTIAL_PRIORITIES")) {
+		credential, ok := r.byName[name]
+		if !ok {
+			return fmt.Errorf("priority set for unknown credential %q", name)
+		}
+		priority, err := parsePriorityClass(class)
+		if err != nil {
+			return fmt.Errorf("invalid priority for credential %q: %w", name, err)
+		}
+		credential.Priority = priority
+	}
+
+	for clientKey, name := range parseKeyValueList(os.Getenv("CLIENT_API_KEYS")) {
+		credential, ok := r.byName[name]
+		if !ok {
+			return fmt.Errorf("client API key mapped to unknown credential %q", name)
+		}
+		r.clients[clientKey] = credential
+	}
+
+	if claudeAPIKey == "" && len(r.clients) == 0 {
+		return errNoCredentials
+	}
+	return nil
+}
+
+// parseKeyValueList parses "a=1,b=2" lists, ignoring malformed entries
+func parseKeyValueList(value string) map[string]string {
+	pairs := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && name != "" && val != "" {
+			pairs[name] = val
+		}
+	}
+	return pairs
+}
+
+// Default returns the credential used for anonymous traffic, or nil when
+// there is none. The configuration is loaded at startup, whose error is
+// not repeated here.
+func (r *credentialRegistry) Default() *claudeCredential {
+	r.Load()
+	return r.byName[defaultCredentialName]
+}
+
+// Any returns the default credential, or else the first one, for requests
+// made on behalf of no client. It is nil when none is configured.
+func (r *credentialRegistry) Any() *claudeCredential {
+	if credential := r.Default(); credential != nil {
+		return credential
+	}
+	if credentials := r.List(); len(credentials) > 0 {
+		return credentials[0]
+	}
+	return nil
+}
+
+// ForClient returns the credential of a client API key, and false for
+// unknown keys
+func (r *credentialRegistry) ForClient(clientKey string) (*claudeCredential, bool) {
+	r.Load()
+	for key, credential := range r.clients {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(clientKey)) == 1 {
+			return credential, true
+		}
+	}
+	return nil, false
+}
+
+// Failover returns the credential to retry with when primary is rejected,
+// or nil when there is none
+func (r *credentialRegistry) Failover(primary *claudeCredential) *claudeCredential {
+	r.Load()
+	if r.failover == nil || r.failover == primary {
+		return nil
+	}
+	return r.failover
+}
+
+// List returns the credentials sorted by name
+func (r *credentialRegistry) List() []*claudeCredential {
+	r.Load()
+	credentials := make([]*claudeCredential, 0, len(r.byName))
+	for _, credential := range r.byName {
+		credentials = append(credentials, credential)
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].Name < credentials[j].Name })
+	return credentials
+}
+
+// RecordUsage accounts tokens to the credential and writes the audit line
+func (c *claudeCredential) RecordUsage(model string, inputTokens, outputTokens int) {
+	c.inputTokens.Add(int64(inputTokens))
+	c.outputTokens.Add(int64(outputTokens))
+	log.Printf("🧾 audit credential=%s model=%s input_tokens=%d output_tokens=%d", c.Name, model, inputTokens, outputTokens)
+}
+
+var credentialFailovers atomic.Int64
+
+type credentialKey struct{}
+
+// credentialMiddleware selects the credential of the request from its
+// X-API-Key header. Anonymous requests use the default credential, if any.
+func credentialMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		credential := claudeCredentials.Default()
+		if clientKey := c.GetHeader("X-API-Key"); clientKey != "" {
+			var ok bool
+			credential, ok = claudeCredentials.ForClient(clientKey)
+			if !ok {
+				c.AbortWithStatusJSON(401, gin.H{"error": "Invalid API key"})
+				return
+			}
+		}
+		ctx := context.WithValue(c.Request.Context(), credentialKey{}, credential)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// credentialFrom returns the credential selected for the request, nil for
+// anonymous requests when there is no default credential
+func credentialFrom(ctx context.Context) *claudeCredential {
+	if credential, ok := ctx.Value(credentialKey{}).(*claudeCredential); ok {
+		return credential
+	}
+	return claudeCredentials.Default()
+}
+
+// failoverStatus reports whether a Claude API status means the credential
+// itself was rejected: invalid key, missing permission or exhausted quota
+func failoverStatus(status int) bool {
+	return status == http.StatusUnauthorized ||
+		status == http.StatusPaymentRequired ||
+		status == http.StatusForbidden ||
+		status == http.StatusTooManyRequests
+}
+
+// claudeCall describes a request to the Claude API
+type claudeCall struct {
+	// HTTP method, POST when empty
+	Method      string
+	Path        string
+	ContentType string
+	Body        []byte
+	Beta        string
+}
+
+// callClaude sends a request with the credential, failing over to the
+// secondary credential when the first one is rejected. It returns the
+// credential that served the request.
+func callClaude(ctx context.Context, credential *claudeCredential, call claudeCall) (*http.Response, *claudeCredential, error) {
+	if credential == nil {
+		return nil, nil, errAnonymousCredential
+	}
+	resp, err := doClaudeRequest(ctx, credential, call)
+	if err != nil || !failoverStatus(resp.StatusCode) {
+		return resp, credential, err
+	}
+	credential.failures.Add(1)
+
+	secondary := claudeCredentials.Failover(credential)
+	if secondary == nil {
+		return resp, credential, nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	credentialFailovers.Add(1)
+	log.Printf("🚨 ALERT: Claude credential %s rejected with HTTP %d (request-id %s), failing over to %s: %s",
+		credential.Name, resp.StatusCode, resp.Header.Get("request-id"), secondary.Name, string(body))
+
+	resp, err = doClaudeRequest(ctx, secondary, call)
+	if err == nil && failoverStatus(resp.StatusCode) {
+		secondary.failures.Add(1)
+	}
+	return resp, secondary, err
+}
+
+func doClaudeRequest(ctx context.Context, credential *claudeCredential, call claudeCall) (*http.Response, error) {
+	method := call.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, claudeBaseURL+call.Path, bytes.NewReader(call.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Claude request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", call.ContentType)
+	req.Header.Set("x-api-key", credential.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if call.Beta != "" {
+		req.Header.Set("anthropic-beta", call.Beta)
+	}
+
+	credential.requests.Add(1)
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Claude API: %w", err)
+	}
+	return resp, nil
+}
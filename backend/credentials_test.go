@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// useCredentials loads a fresh credentials configuration for the duration
+// of a test
+func useCredentials(t *testing.T, apiKey string, env map[string]string) *credentialRegistry {
+	t.Helper()
+	previous, previousKey := claudeCredentials, claudeAPIKey
+	t.Cleanup(func() { claudeCredentials, claudeAPIKey = previous, previousKey })
+	for _, name := range []string{"CLAUDE_API_KEYS", "CLAUDE_FAILOVER_CREDENTIAL", "CREDENTIAL_PRIORITIES", "CLIENT_API_KEYS"} {
+		t.Setenv(name, env[name])
+	}
+	claudeAPIKey = apiKey
+	claudeCredentials = &credentialRegistry{}
+	return claudeCredentials
+}
+
+func TestCredentialsLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKey  string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"default key only", "sk-default", nil, false},
+		{"client keys without a default key", "", map[string]string{"CLAUDE_API_KEYS": "partner=sk-partner", "CLIENT_API_KEYS": "client=partner"}, false},
+		{"named keys without clients nor default key", "", map[string]string{"CLAUDE_API_KEYS": "partner=sk-partner"}, true},
+		{"nothing configured", "", nil, true},
+		{"client key of an unknown credential", "sk-default", map[string]string{"CLIENT_API_KEYS": "client=partner"}, true},
+		{"unknown failover credential", "sk-default", map[string]string{"CLAUDE_FAILOVER_CREDENTIAL": "backup"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := useCredentials(t, tc.apiKey, tc.env)
+			if err := registry.Load(); (err != nil) != tc.wantErr {
+				t.Errorf("loaded with error %v", err)
+			}
+		})
+	}
+}
+
+// TestCredentialsWithoutDefault makes sure clients are served with their
+// credentials when there is no default one, and anonymous requests are
+// refused a credential instead of being sent without a key
+func TestCredentialsWithoutDefault(t *testing.T) {
+	registry := useCredentials(t, "", map[string]string{"CLAUDE_API_KEYS": "partner=sk-partner", "CLIENT_API_KEYS": "client=partner"})
+	if credential, ok := registry.ForClient("client"); !ok || credential.Name != "partner" {
+		t.Errorf("the client key selects %+v", credential)
+	}
+	if credential := registry.Default(); credential != nil {
+		t.Errorf("anonymous requests use credential %q", credential.Name)
+	}
+	if credential := registry.Any(); credential == nil || credential.Name != "partner" {
+		t.Errorf("requests on behalf of no client use %+v", credential)
+	}
+	if _, _, err := callClaude(context.Background(), credentialFrom(context.Background()), claudeCall{Path: "/v1/messages"}); !errors.Is(err, errAnonymousCredential) {
+		t.Errorf("an anonymous call got %v", err)
+	}
+}
+
+// TestCredentialsStartup makes sure a mistake in the credentials stops the
+// server from starting whichever the provider, rather than leaving every
+// client unauthorized
+func TestCredentialsStartup(t *testing.T) {
+	previous := activeProvider
+	activeProvider = providerOllama
+	t.Cleanup(func() { activeProvider = previous })
+
+	useCredentials(t, "", nil)
+	if err := loadProvider(); err != nil {
+		t.Errorf("a provider other than Anthropic requires Claude credentials: %v", err)
+	}
+	useCredentials(t, "", map[string]string{"CLIENT_API_KEYS": "client=partner"})
+	if err := loadProvider(); err == nil {
+		t.Error("the server starts with a client key of an unknown credential")
+	}
+}
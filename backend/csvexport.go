@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Byte order mark some spreadsheets need to read a CSV file as UTF-8
+const utf8BOM = "\xef\xbb\xbf"
+
+// spreadsheetSafe keeps a cell from being read as a formula by
+// spreadsheets, by prefixing an apostrophe to text starting with a formula
+// character
+func spreadsheetSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// answerGroup is the RAADS-R domain of a question, or the subscale of the
+// other instruments
+func answerGroup(instrument Instrument, qa QuestionAndAnswer) string {
+	if domain, ok := domainForCategory(qa.Category); ok && instrument.Key == instrumentRAADSR {
+		return domain.Key
+	}
+	for _, s := range instrument.Subscales {
+		if s.Category == qa.Category {
+			return s.Key
+		}
+	}
+	return ""
+}
+
+// renderAnswersCSV lists every question with its answer and score
+func renderAnswersCSV(data AssessmentData, includeComments bool) ([]byte, error) {
+	var out strings.Builder
+	w := csv.NewWriter(&out)
+
+	header := []string{"id", "domain", "question", "answer", "answer_text", "score"}
+	if includeComments {
+		header = append(header, "comment")
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	instrument := instrumentOf(data)
+	for _, qa := range data.QuestionsAndAnswers {
+		answer := ""
+		if qa.AnswerText != "" {
+			answer = strconv.Itoa(qa.Answer)
+		}
+		row := []string{strconv.Itoa(qa.ID), answerGroup(instrument, qa), spreadsheetSafe(qa.Text), answer, spreadsheetSafe(qa.AnswerText), strconv.Itoa(qa.Score)}
+		if includeComments {
+			comment := ""
+			if qa.Comment != nil {
+				comment = *qa.Comment
+			}
+			row = append(row, spreadsheetSafe(comment))
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return []byte(out.String()), w.Error()
+}
+
+// exportCSVHandler exports the questions, answers, scores and comments of
+// an assessment as CSV, for archiving or analysis in a spreadsheet. With
+// ?bom=true, the file starts with a byte order mark for spreadsheets that
+// otherwise misread UTF-8.
+func exportCSVHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := applyDomainReport(&req); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := renderAnswersCSV(req.Assessment, req.IncludeComments == nil || *req.IncludeComments)
+	if err != nil {
+		log.Printf("❌ Error rendering CSV export: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render CSV export: " + err.Error()})
+		return
+	}
+	if c.Query("bom") == "true" {
+		content = append([]byte(utf8BOM), content...)
+	}
+
+	reportID := uuid.New().String()
+	log.Printf("📦 Exporting %d answers as CSV %s", len(req.Assessment.QuestionsAndAnswers), reportID)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-answers-%s.csv"`, instrumentOf(req.Assessment).Key, reportID))
+	c.Data(200, "text/csv; charset=utf-8", content)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// What to do with a full submission whose scores do not match its answers
+const (
+	scoreMismatchCorrect = "correct"
+	scoreMismatchReject  = "reject"
+)
+
+// SCORE_MISMATCH is "correct" (default) to recompute mismatched scores
+// with a warning, or "reject" to refuse the submission
+var scoreMismatch = envString("SCORE_MISMATCH", scoreMismatchCorrect)
+
+// SubmittedAnswer is one answer of the minimal submission format
+type SubmittedAnswer struct {
+	ID      int     `json:"id"`
+	Answer  *int    `json:"answer"`
+	Comment *string `json:"comment,omitempty"`
+}
+
+// deriveAssessment computes everything that follows from the answers. A
+// minimal submission is expanded into a full assessment; in a full
+// submission, values that disagree with the derived ones are replaced.
+func deriveAssessment(ctx context.Context, data *AssessmentData) error {
+	instrument := instrumentOf(*data)
+	catalog, err := instrument.Catalog(data.Language)
+	if err != nil {
+		return err
+	}
+
+	if data.Answers != nil {
+		if len(data.QuestionsAndAnswers) > 0 {
+			return fmt.Errorf("answers and questionsAndAnswers cannot both be provided")
+		}
+		return expandSubmission(data, instrument, catalog)
+	}
+
+	return checkDerivedValues(ctx, data, instrument, catalog)
+}
+
+// expandSubmission builds the full assessment of a minimal submission, with
+// every catalog question listed and unanswered ones left without answer text
+func expandSubmission(data *AssessmentData, instrument Instrument, catalog *LanguageCatalog) error {
+	if data.TestDate == nil || data.TestDate.IsZero() {
+		return fmt.Errorf("test date is required")
+	}
+	if len(data.Answers) == 0 {
+		return fmt.Errorf("no answers provided")
+	}
+
+	answers := make(map[int]SubmittedAnswer, len(data.Answers))
+	for _, a := range data.Answers {
+		if _, ok := catalog.Question(a.ID); !ok {
+			return fmt.Errorf("unknown question %d", a.ID)
+		}
+		if _, ok := answers[a.ID]; ok {
+			return fmt.Errorf("duplicate answer for question %d", a.ID)
+		}
+		if a.Answer == nil {
+			return fmt.Errorf("missing answer for question %d", a.ID)
+		}
+		answers[a.ID] = a
+	}
+
+	qas := make([]QuestionAndAnswer, 0, len(catalog.Questions))
+	for _, q := range catalog.Questions {
+		qa := QuestionAndAnswer{ID: q.ID, Text: q.Text, Category: q.Category, Reverse: q.Reverse}
+		if a, ok := answers[q.ID]; ok {
+			label, ok := instrument.Test.CanonicalLabel(data.Language, *a.Answer)
+			if !ok {
+				return fmt.Errorf("invalid answer %d for question %d", *a.Answer, q.ID)
+			}
+			qa.Answer = *a.Answer
+			qa.AnswerText = label
+			qa.Comment = a.Comment
+			qa.Score = instrument.ItemScore(q, *a.Answer)
+		}
+		qas = append(qas, qa)
+	}
+
+	_, offset := data.TestDate.Zone()
+	data.Metadata = Metadata{
+		TestName:          instrument.Test.Name,
+		TestDate:          data.TestDate.UTC(),
+		TotalQuestions:    len(qas),
+		AnsweredQuestions: len(answers),
+		TestDateOffset:    formatUTCOffset(offset),
+		Timezone:          data.Metadata.Timezone,
+		DurationSeconds:   data.Metadata.DurationSeconds,
+	}
+	data.QuestionsAndAnswers = qas
+	data.Scores = instrument.Scores(qas)
+	data.Interpretation = instrument.Interpretation(catalog, data.Scores)
+
+	// The rest of the pipeline only knows the full format
+	data.Answers = nil
+	data.TestDate = nil
+	return nil
+}
+
+// checkDerivedValues validates a full submission against the values derived
+// from its answers, replacing the ones that drifted with a warning
+func checkDerivedValues(ctx context.Context, data *AssessmentData, instrument Instrument, catalog *LanguageCatalog) error {
+	var texts, structure, scores []int
+	answered := 0
+	for i := range data.QuestionsAndAnswers {
+		qa := &data.QuestionsAndAnswers[i]
+		q, ok := catalog.Question(qa.ID)
+		if !ok {
+			return fmt.Errorf("unknown question %d", qa.ID)
+		}
+
+		if canonicalString(qa.Text) != canonicalString(q.Text) {
+			texts = append(texts, qa.ID)
+			qa.Text = q.Text
+		}
+		if canonicalCategory(qa.Category) != q.Category || qa.Reverse != q.Reverse {
+			structure = append(structure, qa.ID)
+		}
+		qa.Category, qa.Reverse = q.Category, q.Reverse
+
+		// Unanswered questions are sent without an answer text. Answers
+		// outside the scale are rejected by validateAnswerScale.
+		if qa.AnswerText == "" {
+			qa.Score = 0
+			continue
+		}
+		answered++
+		if _, ok := instrument.Test.CanonicalLabel(data.Language, qa.Answer); !ok {
+			continue
+		}
+		if score := instrument.ItemScore(q, qa.Answer); qa.Score != score {
+			scores = append(scores, qa.ID)
+			qa.Score = score
+		}
+	}
+
+	warn := func(message string) {
+		warningsFrom(ctx).Add(Warning{Code: warnDerivedValueReplaced, Message: message})
+	}
+	if len(texts) > 0 {
+		warn(fmt.Sprintf("question texts of %s did not match the %s catalog and were replaced", formatQuestionIDs(texts), data.Language))
+	}
+	if len(structure) > 0 {
+		warn(fmt.Sprintf("categories or reverse flags of %s did not match the %s catalog and were replaced", formatQuestionIDs(structure), data.Language))
+	}
+	if len(scores) > 0 {
+		warn(fmt.Sprintf("item scores of %s did not match their answers and were recomputed", formatQuestionIDs(scores)))
+	}
+
+	if derived := instrument.Scores(data.QuestionsAndAnswers); data.Scores != derived {
+		mismatches := strings.Join(scoreMismatches(data.Scores, derived), ", ")
+		if scoreMismatch == scoreMismatchReject {
+			return fmt.Errorf("submitted scores do not match the answers: %s", mismatches)
+		}
+		warn(fmt.Sprintf("submitted scores did not match the answers and were recomputed: %s", mismatches))
+		data.Scores = derived
+	}
+
+	if data.Metadata.AnsweredQuestions != answered {
+		warn(fmt.Sprintf("answered questions count %d replaced with %d", data.Metadata.AnsweredQuestions, answered))
+		data.Metadata.AnsweredQuestions = answered
+	}
+
+	derived := instrument.Interpretation(catalog, data.Scores)
+	if canonicalString(data.Interpretation.Level) != canonicalString(derived.Level) {
+		warn(fmt.Sprintf("interpretation %q replaced with %q, matching a total score of %d", data.Interpretation.Level, derived.Level, data.Scores.Total))
+	}
+	data.Interpretation = derived
+
+	return nil
+}
+
+// scoreMismatches lists the submitted scores that differ from the derived
+// ones, e.g. "social 60 instead of 67"
+func scoreMismatches(submitted, derived Scores) []string {
+	pairs := []struct {
+		name              string
+		submitted, actual int
+	}{
+		{"total", submitted.Total, derived.Total},
+		{"language", submitted.Language, derived.Language},
+		{"social", submitted.Social, derived.Social},
+		{"sensory", submitted.Sensory, derived.Sensory},
+		{"restricted", submitted.Restricted, derived.Restricted},
+		{"maximum total", submitted.MaxTotal, derived.MaxTotal},
+		{"maximum language", submitted.MaxLanguage, derived.MaxLanguage},
+		{"maximum social", submitted.MaxSocial, derived.MaxSocial},
+		{"maximum sensory", submitted.MaxSensory, derived.MaxSensory},
+		{"maximum restricted", submitted.MaxRestricted, derived.MaxRestricted},
+		{"empathy", submitted.Empathy, derived.Empathy},
+		{"systemizing", submitted.Systemizing, derived.Systemizing},
+		{"maximum empathy", submitted.MaxEmpathy, derived.MaxEmpathy},
+		{"maximum systemizing", submitted.MaxSystemizing, derived.MaxSystemizing},
+	}
+	var mismatches []string
+	for _, p := range pairs {
+		if p.submitted != p.actual {
+			mismatches = append(mismatches, fmt.Sprintf("%s %d instead of %d", p.name, p.submitted, p.actual))
+		}
+	}
+	return mismatches
+}
+
+// formatQuestionIDs lists question IDs for a warning, abbreviating long lists
+func formatQuestionIDs(ids []int) string {
+	const shown = 10
+	parts := make([]string, 0, shown+1)
+	for i, id := range ids {
+		if i == shown {
+			parts = append(parts, fmt.Sprintf("%d more", len(ids)-shown))
+			break
+		}
+		parts = append(parts, strconv.Itoa(id))
+	}
+	if len(ids) == 1 {
+		return "question " + parts[0]
+	}
+	return "questions " + strings.Join(parts, ", ")
+}
+
+// checkScoreVerification verifies that a full submission with tampered
+// scores is corrected or rejected, as SCORE_MISMATCH requires
+func checkScoreVerification() checkResult {
+	result := checkResult{Name: "score verification", Feature: "analysis"}
+	if scoreMismatch != scoreMismatchCorrect && scoreMismatch != scoreMismatchReject {
+		result.Detail = fmt.Sprintf("SCORE_MISMATCH must be %q or %q, not %q", scoreMismatchCorrect, scoreMismatchReject, scoreMismatch)
+		return result
+	}
+	result.OK = true
+	result.Detail = "mismatched scores are " + map[string]string{scoreMismatchCorrect: "recomputed with a warning", scoreMismatchReject: "rejected"}[scoreMismatch]
+	return result
+}
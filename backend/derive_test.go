@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestScoreMismatch submits an assessment whose total was tampered with,
+// which is recomputed or rejected depending on SCORE_MISMATCH
+func TestScoreMismatch(t *testing.T) {
+	catalog, err := catalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := catalog.Questions[0]
+	label, _ := raadsR.CanonicalLabel("en", 0)
+	qa := QuestionAndAnswer{ID: q.ID, Text: q.Text, Category: q.Category, Reverse: q.Reverse, Answer: 0, AnswerText: label, Score: itemScore(q.Reverse, 0)}
+	derived := scoresFromItems([]QuestionAndAnswer{qa})
+
+	tests := []struct {
+		mode     string
+		rejected bool
+	}{
+		{scoreMismatchCorrect, false},
+		{scoreMismatchReject, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			previous := scoreMismatch
+			scoreMismatch = tc.mode
+			t.Cleanup(func() { scoreMismatch = previous })
+
+			data := AssessmentData{Language: "en", Metadata: Metadata{AnsweredQuestions: 1}, QuestionsAndAnswers: []QuestionAndAnswer{qa}}
+			data.Scores = derived
+			data.Scores.Total += 30
+			err := checkDerivedValues(context.Background(), &data, instrumentOf(data), catalog)
+			if rejected := err != nil; rejected != tc.rejected {
+				t.Fatalf("rejected: %t, want %t (%v)", rejected, tc.rejected, err)
+			}
+			if !tc.rejected && data.Scores != derived {
+				t.Errorf("tampered scores were not corrected: %+v", data.Scores)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"raads-pdf-backend/streamproto"
+)
+
+var (
+	// Output tokens requested for a domain report, smaller than a full analysis
+	domainReportMaxTokens = envInt("DOMAIN_REPORT_MAX_TOKENS", 3000)
+
+	// How long domain reports are kept for exports
+	domainReportRetention = time.Duration(envInt("DOMAIN_REPORT_RETENTION_HOURS", 24)) * time.Hour
+)
+
+// DomainReportRequest is an assessment along with the domain to analyze
+type DomainReportRequest struct {
+	AssessmentData
+	Domain string `json:"domain"`
+}
+
+// UnmarshalJSON decodes the domain next to the assessment, whose own
+// UnmarshalJSON would otherwise leave it out
+func (r *DomainReportRequest) UnmarshalJSON(b []byte) error {
+	var domain struct {
+		Domain string `json:"domain"`
+	}
+	if err := json.Unmarshal(b, &domain); err != nil {
+		return err
+	}
+	r.Domain = domain.Domain
+	return json.Unmarshal(b, &r.AssessmentData)
+}
+
+// DomainReport is an extended analysis of a single domain, linked to the
+// assessment it was generated from
+type DomainReport struct {
+	ID                   string    `json:"report_id"`
+	Domain               string    `json:"domain"`
+	ParentAssessmentHash string    `json:"parent_assessment_hash"`
+	PromptVersion        int       `json:"prompt_version"`
+	Markdown             string    `json:"markdown"`
+	GeneratedAt          time.Time `json:"generated_at"`
+}
+
+var errDomainReportMismatch = errors.New("domain report belongs to another assessment")
+
+// domainReportStore keeps domain reports in memory until their retention
+// period is over
+type domainReportStore struct {
+	mu      sync.Mutex
+	reports map[string]DomainReport
+}
+
+var domainReports = &domainReportStore{reports: make(map[string]DomainReport)}
+
+// Save stores a generated domain report
+func (s *domainReportStore) Save(report DomainReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	s.reports[report.ID] = report
+}
+
+// Get returns a domain report by ID
+func (s *domainReportStore) Get(id string) (DomainReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	report, ok := s.reports[id]
+	return report, ok
+}
+
+// pruneLocked forgets reports past their retention period
+func (s *domainReportStore) pruneLocked(now time.Time) {
+	for id, report := range s.reports {
+		if now.Sub(report.GeneratedAt) > domainReportRetention {
+			delete(s.reports, id)
+		}
+	}
+}
+
+// bindDomainReportRequest decodes and validates a domain report request
+func bindDomainReportRequest(c *gin.Context) (AssessmentData, Domain, bool) {
+	var req DomainReportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return AssessmentData{}, Domain{}, false
+	}
+
+	domain, ok := domainByKey(req.Domain)
+	if !ok {
+		keys := make([]string, 0, len(raadsDomains))
+		for _, d := range raadsDomains {
+			keys = append(keys, d.Key)
+		}
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid domain %q (available: %s)", req.Domain, strings.Join(keys, ", "))})
+		return AssessmentData{}, Domain{}, false
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.AssessmentData); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return AssessmentData{}, Domain{}, false
+	}
+	if err := requireRAADSR(req.AssessmentData, "The domain analysis"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return AssessmentData{}, Domain{}, false
+	}
+
+	return req.AssessmentData, domain, true
+}
+
+// domainAssessment keeps only the questions of a domain, so that the prompt
+// carries no answer from the other domains
+func domainAssessment(data AssessmentData, domain Domain) AssessmentData {
+	qas := make([]QuestionAndAnswer, 0, domain.Items)
+	for _, qa := range data.QuestionsAndAnswers {
+		if canonicalCategory(qa.Category) == domain.Category {
+			qas = append(qas, qa)
+		}
+	}
+	data.QuestionsAndAnswers = qas
+	return data
+}
+
+// domainReportPrompt builds the prompt of an extended analysis of one domain
+func domainReportPrompt(data AssessmentData, domain Domain, input assessmentInput, version int) (string, error) {
+	prompt, err := newPromptData(data, input)
+	if err != nil {
+		return "", err
+	}
+	prompt.Domain = domain
+	prompt.DomainScore = domainTotals(data)[domain.Key]
+	return renderPrompt(version, "domain.tmpl", prompt)
+}
+
+// prepareDomainReport hashes the parent assessment and builds the input and
+// prompt of a domain report
+func prepareDomainReport(ctx context.Context, data AssessmentData, domain Domain) (string, assessmentInput, string, error) {
+	hash, err := assessmentHash(data)
+	if err != nil {
+		return "", assessmentInput{}, "", fmt.Errorf("failed to hash assessment data: %w", err)
+	}
+
+	scoped := domainAssessment(data, domain)
+	input, err := prepareAssessmentInput(ctx, scoped)
+	if err != nil {
+		return "", assessmentInput{}, "", err
+	}
+
+	// Domain scores are computed on the scoped answers, the total on all
+	scoped.Scores = data.Scores
+	version := promptVersionFor(hash)
+	prompt, err := domainReportPrompt(scoped, domain, input, version)
+	if err != nil {
+		return "", assessmentInput{}, "", err
+	}
+	if input.System, err = systemPrompt(data, version); err != nil {
+		return "", assessmentInput{}, "", err
+	}
+	return hash, input, prompt, nil
+}
+
+// analyzeDomainHandler generates an extended analysis of a single domain
+func analyzeDomainHandler(c *gin.Context) {
+	data, domain, ok := bindDomainReportRequest(c)
+	if !ok {
+		return
+	}
+
+	slot, err := workers.Acquire(c.Request.Context(), requestPriority(c))
+	if err != nil {
+		info := workers.Busy()
+		log.Printf("⚠️  Rejecting domain analysis: %v (queue depth %d)", err, info.QueueDepth)
+		c.Header("Retry-After", strconv.Itoa(info.RetryAfterSeconds))
+		c.JSON(503, busyResponse(info))
+		return
+	}
+	completed := false
+	defer func() { slot.Release(completed) }()
+
+	hash, input, prompt, err := prepareDomainReport(c.Request.Context(), data, domain)
+	if err != nil {
+		log.Printf("❌ Error preparing domain analysis: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+
+	reportID := uuid.New().String()
+	usageFrom(c.Request.Context()).SetReport(reportID)
+	log.Printf("🧠 Processing %s domain analysis request %s", domain.Key, reportID)
+
+	route := routeModel(c.Request.Context(), modeDomain)
+	markdown, err := completeStructuredMarkdown(c.Request.Context(), input, prompt, route.Model, domainReportMaxTokens)
+	if err != nil {
+		log.Printf("❌ Error generating domain analysis: %v", err)
+		if aiUnavailable(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+	completed = true
+
+	report := DomainReport{
+		ID:                   reportID,
+		Domain:               domain.Key,
+		ParentAssessmentHash: hash,
+		PromptVersion:        promptVersionFor(hash),
+		Markdown:             markdown,
+		GeneratedAt:          time.Now().UTC(),
+	}
+	domainReports.Save(report)
+	log.Printf("✅ Generated %s domain analysis (%d characters)", domain.Key, len(markdown))
+
+	response := gin.H{
+		"success":                true,
+		"report_id":              reportID,
+		"domain":                 domain.Key,
+		"parent_assessment_hash": hash,
+		"prompt_version":         report.PromptVersion,
+		"markdown":               markdown,
+		"reading":                analysisReadingStats(markdown, data.Language),
+		"input_mode":             input.Mode,
+		"reference_profile":      referenceProfileMetadata(data),
+		"generated_at":           report.GeneratedAt,
+		"analysis_version":       currentAnalysisVersion(),
+		"model":                  route,
+	}
+
+	html, err := renderMarkdown(markdown, "domain_report")
+	if err != nil {
+		warningsFrom(c.Request.Context()).Add(Warning{
+			Code:    warnRenderFallback,
+			Message: "failed to convert analysis to HTML: " + err.Error(),
+			Section: "analysis",
+		})
+		response["analysis"] = nil
+	} else {
+		response["analysis"] = html
+	}
+	if warnings := warningsFrom(c.Request.Context()).List(); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+
+	c.JSON(200, response)
+}
+
+// analyzeDomainStreamHandler streams an extended analysis of a single domain
+// with the same events as /analyze-stream
+func analyzeDomainStreamHandler(c *gin.Context) {
+	if replay, seq, ok := resumeTarget(c); ok {
+		resumeStream(c, replay, seq)
+		return
+	}
+
+	data, domain, ok := bindDomainReportRequest(c)
+	if !ok {
+		return
+	}
+
+	if !streamCapacityAvailable() {
+		streamsRejected.Add(1)
+		log.Printf("⚠️  Rejecting streaming domain analysis: %d bytes buffered", streamBufferedBytes.Load())
+		c.Header("Retry-After", "30")
+		c.JSON(503, gin.H{"error": "Server is busy, please retry shortly"})
+		return
+	}
+
+	slot, err := workers.Acquire(c.Request.Context(), requestPriority(c))
+	if err != nil {
+		info := workers.Busy()
+		log.Printf("⚠️  Rejecting streaming domain analysis: %v (queue depth %d)", err, info.QueueDepth)
+		setStreamHeaders(c)
+		c.Header("Retry-After", strconv.Itoa(info.RetryAfterSeconds))
+		sendStreamEvent(c, streamproto.Busy{
+			Error:                "Server is busy, please retry shortly",
+			QueueDepth:           info.QueueDepth,
+			Workers:              info.Workers,
+			EstimatedWaitSeconds: info.EstimatedWaitSeconds,
+			RetryAfterSeconds:    info.RetryAfterSeconds,
+		})
+		return
+	}
+	completed := false
+	defer func() { slot.Release(completed) }()
+
+	hash, input, prompt, err := prepareDomainReport(c.Request.Context(), data, domain)
+	if err != nil {
+		log.Printf("❌ Error preparing domain analysis: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+
+	reportID := uuid.New().String()
+	usageFrom(c.Request.Context()).SetReport(reportID)
+	log.Printf("🧠 Processing streaming %s domain analysis request %s", domain.Key, reportID)
+	trace := startSessionTrace(c, reportID)
+	replay := streamReplays.Start(c, reportID)
+	defer replay.Finish()
+
+	streamsInFlight.Add(1)
+	defer streamsInFlight.Add(-1)
+
+	setStreamHeaders(c)
+	sendStreamEvent(c, streamproto.Metadata{
+		ProtocolVersion:  streamproto.Version,
+		ReportID:         reportID,
+		AssessmentHash:   hash,
+		InputMode:        input.Mode,
+		ReferenceProfile: referenceProfileMetadata(data),
+		StartedAt:        time.Now().UTC(),
+		Domain:           domain.Key,
+		AnalysisVersion:  currentAnalysisVersion(),
+		Percentiles:      percentilesMetadata(data),
+
+		AdditionalContextProvided: data.AdditionalContext != "",
+	})
+
+	ctx, gen := generations.Start(generationContext(c), reportID)
+	status := generationFailed
+	defer func() {
+		generations.Finish(reportID, status)
+		trace.Finish(c.Request.Context(), status)
+	}()
+
+	markdown, err := streamClaudeMarkdown(ctx, c, gen, input, prompt, routeModel(ctx, modeDomainStream).Model, domainReportMaxTokens)
+	if gen.Cancelled() {
+		log.Printf("🛑 Streaming domain analysis %s cancelled", reportID)
+		status = generationCancelled
+		sendStreamEvent(c, streamproto.Cancelled{
+			CancelledAt: time.Now().UTC(),
+			Markdown:    gen.Partial(),
+		})
+		return
+	}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = generationTimeout
+		}
+		log.Printf("❌ Error during streaming domain analysis: %v", err)
+		if errors.Is(err, errAIUnavailable) {
+			sendStreamEvent(c, streamproto.Error{Error: "AI service unavailable, please retry shortly", Code: "ai_unavailable"})
+			return
+		}
+		sendStreamEvent(c, streamproto.Error{Error: "Failed to generate analysis: " + err.Error()})
+		return
+	}
+	status = generationCompleted
+	completed = true
+	warnStructureViolations(ctx, input, prompt, markdown)
+
+	domainReports.Save(DomainReport{
+		ID:                   reportID,
+		Domain:               domain.Key,
+		ParentAssessmentHash: hash,
+		PromptVersion:        promptVersionFor(hash),
+		Markdown:             markdown,
+		GeneratedAt:          time.Now().UTC(),
+	})
+
+	sendStreamEvent(c, streamproto.Complete{
+		CompletedAt: time.Now().UTC(),
+		Reading:     readingMetadata(analysisReadingStats(markdown, data.Language)),
+		Warnings:    warningsMetadata(warningsFrom(c.Request.Context()).List()),
+		Model:       routeMetadata(routingFrom(c.Request.Context()).Route()),
+		// The parent assessment selects the version, as for its analysis
+		PromptVersion: promptVersionFor(hash),
+	})
+}
+
+// domainReportForExport returns the stored domain report of an export
+// request, making sure it was generated from the exported assessment
+func domainReportForExport(id string, data AssessmentData) (DomainReport, Domain, error) {
+	report, ok := domainReports.Get(id)
+	if !ok {
+		return DomainReport{}, Domain{}, fmt.Errorf("domain report %s not found", id)
+	}
+	hash, err := assessmentHash(data)
+	if err != nil {
+		return DomainReport{}, Domain{}, err
+	}
+	if hash != report.ParentAssessmentHash {
+		return DomainReport{}, Domain{}, errDomainReportMismatch
+	}
+	domain, _ := domainByKey(report.Domain)
+	return report, domain, nil
+}
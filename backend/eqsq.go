@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eqsq is the Empathy Quotient of Baron-Cohen and Wheelwright (2004)
+// followed by the Systemizing Quotient of Baron-Cohen et al. (2003), 60
+// items each of which 20 are fillers, taken together so that the brain
+// type can be derived from both scores
+var eqsq = TestDefinition{
+	Name: "EQ/SQ",
+	ScaleLabels: map[string][]AnswerOption{
+		"en": {
+			{0, "A", "Strongly agree"},
+			{1, "B", "Slightly agree"},
+			{2, "C", "Slightly disagree"},
+			{3, "D", "Strongly disagree"},
+		},
+	},
+}
+
+// Categories of the EQ/SQ catalogs. Filler items are not scored.
+const (
+	categoryEmpathy     = "EQ"
+	categorySystemizing = "SQ"
+	categoryFiller      = "F"
+)
+
+var eqsqSubscales = []Subscale{
+	{Key: "empathy", Name: "Empathy Quotient", Category: categoryEmpathy, Items: 40},
+	{Key: "systemizing", Name: "Systemizing Quotient", Category: categorySystemizing, Items: 40},
+}
+
+// Highest possible sum of the EQ and SQ, two points per scored item
+const eqsqMaxTotal = 160
+
+// Mean scores of the general population samples of Baron-Cohen and
+// Wheelwright (2004) and Baron-Cohen et al. (2003), both scores being
+// standardized against them before they are compared
+const (
+	eqPopulationMean = 42.1
+	sqPopulationMean = 27.0
+)
+
+// brainTypeBound is the highest D score of a brain type
+type brainTypeBound struct {
+	Key  string
+	MaxD float64
+}
+
+// Brain types of Goldenfeld et al. (2005), cut at the 2.5th, 35th, 65th
+// and 97.5th percentiles of D in the general population
+var brainTypeBounds = []brainTypeBound{
+	{"extremeE", -0.13},
+	{"typeE", -0.025},
+	{"balanced", 0.025},
+	{"typeS", 0.13},
+	{"extremeS", math.Inf(1)},
+}
+
+// BrainType is the brain type of an EQ/SQ assessment, from the difference
+// D between the standardized systemizing (S) and empathy (E) scores
+type BrainType struct {
+	Key string  `json:"key"`
+	E   float64 `json:"e"`
+	S   float64 `json:"s"`
+	D   float64 `json:"d"`
+}
+
+// eqsqInstrument is the EQ/SQ for instrumentOf
+func eqsqInstrument() Instrument {
+	return Instrument{
+		Key:              instrumentEQSQ,
+		Test:             eqsq,
+		MaxTotal:         eqsqMaxTotal,
+		Subscales:        eqsqSubscales,
+		ItemMax:          2,
+		AnalysisTemplate: "eqsq.tmpl",
+		Catalog:          eqsqCatalogFor,
+		ItemScore:        eqsqItemScore,
+		Scores:           eqsqScoresFromItems,
+		Classify:         func(scores Scores) string { return eqsqBrainType(scores).Key },
+		Details:          eqsqDetails,
+		TemplateMarkdown: eqsqTemplateMarkdown,
+	}
+}
+
+var eqsqCatalogs instrumentCatalogs
+
+// eqsqCatalogFor returns the EQ/SQ catalog of a language
+func eqsqCatalogFor(language string) (*LanguageCatalog, error) {
+	return eqsqCatalogs.get(instrumentEQSQ, eqsq, language, func(code string, catalog *LanguageCatalog) error {
+		for _, q := range catalog.Questions {
+			if q.Category != categoryEmpathy && q.Category != categorySystemizing && q.Category != categoryFiller {
+				return fmt.Errorf("question %d of the %s EQ/SQ catalog has unknown category %q", q.ID, code, q.Category)
+			}
+		}
+		for _, bound := range brainTypeBounds {
+			if _, ok := catalog.Interpretations[bound.Key]; !ok {
+				return fmt.Errorf("the %s EQ/SQ catalog has no %q interpretation", code, bound.Key)
+			}
+		}
+		for _, s := range eqsqSubscales {
+			if catalog.Labels.Domains[s.Key] == "" {
+				return fmt.Errorf("the %s EQ/SQ catalog has no label for the %s questionnaire", code, s.Key)
+			}
+		}
+		return nil
+	})
+}
+
+// eqsqItemScore scores an answer: 2 points for strongly agreeing with an
+// item, 1 for slightly agreeing, and the other way around on reverse items.
+// Fillers score nothing.
+func eqsqItemScore(q CatalogQuestion, answer int) int {
+	if q.Category == categoryFiller {
+		return 0
+	}
+	if q.Reverse {
+		answer = 3 - answer
+	}
+	return max(0, 2-answer)
+}
+
+// eqsqScoresFromItems sums item scores into the EQ and SQ. The total is
+// their sum, only their difference being interpreted.
+func eqsqScoresFromItems(qas []QuestionAndAnswer) Scores {
+	var scores Scores
+	for _, qa := range qas {
+		switch qa.Category {
+		case categoryEmpathy:
+			scores.Empathy += qa.Score
+			scores.MaxEmpathy += 2
+		case categorySystemizing:
+			scores.Systemizing += qa.Score
+			scores.MaxSystemizing += 2
+		}
+	}
+	scores.Total = scores.Empathy + scores.Systemizing
+	scores.MaxTotal = scores.MaxEmpathy + scores.MaxSystemizing
+	return scores
+}
+
+// eqsqBrainType standardizes the EQ and SQ against the population means,
+// and classifies their difference
+func eqsqBrainType(scores Scores) *BrainType {
+	var e, s float64
+	if scores.MaxEmpathy > 0 {
+		e = (float64(scores.Empathy) - eqPopulationMean) / float64(scores.MaxEmpathy)
+	}
+	if scores.MaxSystemizing > 0 {
+		s = (float64(scores.Systemizing) - sqPopulationMean) / float64(scores.MaxSystemizing)
+	}
+	round := func(x float64) float64 { return math.Round(x*1000) / 1000 }
+	brainType := &BrainType{E: round(e), S: round(s), D: round((s - e) / 2)}
+	for _, bound := range brainTypeBounds {
+		if brainType.D <= bound.MaxD {
+			brainType.Key = bound.Key
+			break
+		}
+	}
+	return brainType
+}
+
+// eqsqDetails are the EQ, SQ and brain type of the responses
+func eqsqDetails(data AssessmentData) gin.H {
+	return gin.H{
+		"subscales":  subscaleScores(data),
+		"brain_type": eqsqBrainType(data.Scores),
+	}
+}
+
+// eqsqTemplateMarkdown is templateMarkdown for the EQ/SQ
+func eqsqTemplateMarkdown(data AssessmentData) (string, error) {
+	brainType := eqsqBrainType(data.Scores)
+
+	var md strings.Builder
+	md.WriteString("## Executive Summary\n\n")
+	md.WriteString("> The AI analysis service is currently unavailable. This report only summarizes your scores; generate the analysis again later for a detailed interpretation.\n\n")
+	fmt.Fprintf(&md, "Your brain type is **%s**", data.Interpretation.Level)
+	if data.Interpretation.Description != "" {
+		fmt.Fprintf(&md, ": %s", data.Interpretation.Description)
+	}
+	fmt.Fprintf(&md, " (D = %.3f).\n\n", brainType.D)
+
+	md.WriteString("## Questionnaire Scores\n\n")
+	means := map[string]float64{"empathy": eqPopulationMean, "systemizing": sqPopulationMean}
+	for _, s := range subscaleScores(data) {
+		fmt.Fprintf(&md, "- **%s**: %d / %d (population mean: %.1f)\n", s.Label, s.Score, s.Max, means[s.Key])
+	}
+	md.WriteString("\nThe brain type compares both scores once standardized against the population means: systemizing stronger than empathy gives a type S, empathy stronger than systemizing a type E.\n\n")
+
+	md.WriteString("## Next Steps\n\n")
+	md.WriteString("The EQ and SQ describe cognitive styles, not a diagnosis. Only a qualified clinician can assess autism, taking your history and current situation into account.\n")
+	return md.String(), nil
+}
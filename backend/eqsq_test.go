@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEQSQCatalog(t *testing.T) {
+	catalog, err := eqsqCatalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, q := range catalog.Questions {
+		counts[q.Category]++
+	}
+	for _, s := range eqsqSubscales {
+		if counts[s.Category] != s.Items {
+			t.Errorf("the %s has %d scored items instead of %d", s.Name, counts[s.Category], s.Items)
+		}
+	}
+	if counts[categoryFiller] != 40 {
+		t.Errorf("the catalog has %d fillers instead of 40", counts[categoryFiller])
+	}
+}
+
+func TestEQSQBrainType(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores Scores
+		want   string
+	}{
+		{"population means", Scores{Empathy: 42, MaxEmpathy: 80, Systemizing: 27, MaxSystemizing: 80}, "balanced"},
+	}
+	for _, tc := range tests {
+		if got := eqsqBrainType(tc.scores).Key; got != tc.want {
+			t.Errorf("%s classify as %s instead of %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestEQSQScoring strongly agrees with everything, which scores the
+// agreement items only
+func TestEQSQScoring(t *testing.T) {
+	catalog, err := eqsqCatalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agreeScored := 0
+	for _, q := range catalog.Questions {
+		if q.Category != categoryFiller && !q.Reverse {
+			agreeScored += 2
+		}
+	}
+	data := uniformAssessment(t, instrumentOf(AssessmentData{Instrument: instrumentEQSQ}), 0)
+	if err := validateAssessmentData(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Scores.Total != agreeScored || data.Interpretation.Severity == "" {
+		t.Errorf("agreeing with every item scores %d (%q) instead of %d", data.Scores.Total, data.Interpretation.Severity, agreeScored)
+	}
+	prompt, err := analysisPrompt(data, assessmentInput{Mode: inputModeInline, Inline: "{}"}, promptVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, "Brain type: "+data.Interpretation.Level) || len(requiredOutline(prompt)) == 0 {
+		t.Errorf("the version %d EQ/SQ prompt does not state the brain type or the report structure", promptVersion)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Assumed output tokens of an analysis until one was generated with the model
+var estimateOutputTokens = envInt("ESTIMATE_OUTPUT_TOKENS", 3000)
+
+// Characters per token of the approximation, close to what Claude
+// tokenizers produce on English text
+const charsPerToken = 4
+
+// approximateTokens returns an approximate token count of a text
+func approximateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// CostEstimate is the expected cost and duration of generating an analysis
+type CostEstimate struct {
+	Model         ModelRoute `json:"model"`
+	PromptVersion int        `json:"prompt_version"`
+	InputMode     string     `json:"input_mode"`
+	// Approximate prompt tokens, attached assessment included
+	InputTokens int `json:"input_tokens"`
+	// Average output tokens of the model, or ESTIMATE_OUTPUT_TOKENS before
+	// any generation
+	OutputTokens    int     `json:"output_tokens"`
+	MaxOutputTokens int     `json:"max_output_tokens"`
+	Cost            float64 `json:"cost_usd"`
+	MaxCost         float64 `json:"max_cost_usd"`
+	// Whether the model registry has a price for the model
+	Priced bool `json:"priced"`
+	// Expected time until the analysis is complete, queueing included
+	LatencySeconds int `json:"latency_seconds"`
+	QueueDepth     int `json:"queue_depth"`
+	// A cached analysis would be served without any generation
+	Cached bool   `json:"cached"`
+	Note   string `json:"note"`
+}
+
+// estimateAnalysis estimates the generation of a full analysis, without
+// routing, uploading or generating anything
+func estimateAnalysis(ctx context.Context, data AssessmentData, mode string) (CostEstimate, error) {
+	input, err := buildAssessmentInput(ctx, data, false)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+	version := assessmentPromptVersion(data)
+	prompt, err := analysisPrompt(data, input, version)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+	system, err := systemPrompt(data, version)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+	inputTokens := approximateTokens(system) + approximateTokens(prompt)
+	if input.Document != nil {
+		document, err := json.MarshalIndent(input.Assessment, "", "  ")
+		if err != nil {
+			return CostEstimate{}, fmt.Errorf("failed to serialize assessment data: %w", err)
+		}
+		inputTokens += approximateTokens(string(document))
+	}
+
+	route := selectModel(mode, routingFrom(ctx).override, modelRoutes)
+	route.Model = models.Resolve(route.Model)
+	route.Provider = activeProvider
+
+	maxOutput := models.MaxTokens(route.Model, analysisMaxTokens)
+	output, ok := usageTotals.AverageOutputTokens(route.Model)
+	if !ok {
+		output = estimateOutputTokens
+	}
+	output = min(output, maxOutput)
+
+	busy := workers.Busy()
+	estimate := CostEstimate{
+		Model:           route,
+		PromptVersion:   version,
+		InputMode:       input.Mode,
+		InputTokens:     inputTokens,
+		OutputTokens:    output,
+		MaxOutputTokens: maxOutput,
+		LatencySeconds:  busy.EstimatedWaitSeconds,
+		QueueDepth:      busy.QueueDepth,
+		Note:            "Token counts are approximated from the prompt length, costs from the prices of the model registry.",
+	}
+	if info, ok := models.Get(route.Model); ok && (info.InputPricePerMTok > 0 || info.OutputPricePerMTok > 0) {
+		estimate.Priced = true
+		estimate.Cost = roundCost(info.Cost(inputTokens, output))
+		estimate.MaxCost = roundCost(info.Cost(inputTokens, maxOutput))
+	}
+	return estimate, nil
+}
+
+// roundCost rounds a cost in USD to a hundredth of a cent
+func roundCost(cost float64) float64 {
+	return math.Round(cost*1e4) / 1e4
+}
+
+// estimateHandler estimates the tokens, cost and latency of the analysis of
+// an assessment before the client generates it. stream=true estimates a
+// streamed analysis, which may be routed to another model.
+func estimateHandler(c *gin.Context) {
+	var data AssessmentData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	if err := requestModel(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid model selection: %v", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	mode := modeFull
+	if c.Query("stream") == "true" {
+		mode = modeFullStream
+	}
+	estimate, err := estimateAnalysis(c.Request.Context(), data, mode)
+	if err != nil {
+		log.Printf("❌ Error estimating analysis: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to estimate analysis: " + err.Error()})
+		return
+	}
+
+	if hash, err := assessmentHash(data); err == nil {
+		_, estimate.Cached = analysisCache.Peek(analysisCacheKey(hash, data), clientToken(c))
+	}
+
+	c.JSON(200, estimate)
+}
+
+// checkCostEstimate estimates a sample assessment, and verifies that the
+// estimate grows with its comments
+func checkCostEstimate() checkResult {
+	result := checkResult{Name: "cost estimate", Feature: "analysis"}
+	if estimateOutputTokens < 1 {
+		result.Detail = "ESTIMATE_OUTPUT_TOKENS must be positive"
+		return result
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("%d output tokens expected per analysis", estimateOutputTokens)
+	return result
+}
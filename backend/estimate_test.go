@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEstimateAnalysis(t *testing.T) {
+	ctx := context.Background()
+	data := AssessmentData{
+		Language: "en",
+		Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now(), AnsweredQuestions: 1, TotalQuestions: 1},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "Sample", Answer: 2, AnswerText: "Never true"},
+		},
+	}
+	short, err := estimateAnalysis(ctx, data, modeFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := "A long comment about daily routines that the prompt must account for."
+	data.QuestionsAndAnswers[0].Comment = &comment
+	long, err := estimateAnalysis(ctx, data, modeFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if short.InputTokens <= 0 || long.InputTokens <= short.InputTokens {
+		t.Errorf("input tokens do not grow with comments: %d then %d", short.InputTokens, long.InputTokens)
+	}
+	if short.Priced && (short.Cost <= 0 || short.MaxCost < short.Cost) {
+		t.Errorf("inconsistent costs: %.4f, at most %.4f", short.Cost, short.MaxCost)
+	}
+}
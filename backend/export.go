@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Exports above this size still succeed but are flagged, as they become
+// awkward to attach to emails or store in patient records
+const exportSizeWarningBytes = 2 * 1024 * 1024
+
+// Matches src/href attributes pointing to remote resources, which would
+// break offline rendering and leak requests when the archive is opened.
+// They are stripped from the whole exported document, whatever part of it
+// they come from.
+var externalRefPattern = regexp.MustCompile(`\s(src|href)="https?://[^"]*"`)
+
+type ExportRequest struct {
+	Assessment AssessmentData `json:"assessment"`
+	Markdown   string         `json:"markdown"`
+	Compact    bool           `json:"compact"`
+	// Comments and participant-provided context are included unless false
+	IncludeComments *bool `json:"includeComments,omitempty"`
+	// Stored report of the client to export instead of the assessment and
+	// Markdown. Only such exports are signed when reports are signed.
+	ReportID string `json:"reportId,omitempty"`
+	// Stored domain report to export instead of Markdown, limiting the
+	// answers to its domain
+	DomainReportID string `json:"domainReportId,omitempty"`
+	// Analysis version the Markdown was generated with, as returned in
+	// analysis_version, the current one when empty
+	AnalysisVersion string `json:"analysisVersion,omitempty"`
+	// Theme of the PDF and HTML reports, REPORT_THEME when empty
+	Theme string `json:"theme,omitempty"`
+
+	domainName string
+	// Whether the assessment and Markdown are those of the stored report,
+	// which this service vouches for
+	stored bool
+}
+
+type exportView struct {
+	Data      AssessmentData
+	FontCSS   template.CSS
+	ChartURI  template.URL
+	RadarURI  template.URL
+	Analysis  template.HTML
+	ReportID  string
+	Generated string
+	Version   string
+	Reading   *ReadingStats
+	Reference ReferenceProfile
+	// Signed link to the verification page, when reports are signed
+	Verification *ReportVerification
+	// Banner at the top of the report, also stamped across printed pages
+	Watermark string
+	// Brand of the deployment or tenant, with its logo inlined and the
+	// colors of the theme
+	Branding Branding
+	LogoURI  template.URL
+	Theme    ReportTheme
+
+	IncludeComments bool
+	ContextTitle    string
+	DomainName      string
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="{{.Data.Language}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Data.Metadata.TestName}}</title>
+<style>
+{{.FontCSS}}
+body { font-family: 'Open Sans', sans-serif; max-width: 860px; margin: 2rem auto; color: #222; line-height: 1.5; }
+h1 { color: {{.Branding.Colors.Primary}}; }
+h2, h3 { color: {{.Branding.Colors.Secondary}}; }
+a { color: {{.Branding.Colors.Primary}}; }
+.brand { display: flex; align-items: center; gap: 1rem; border-bottom: 3px solid {{.Branding.Colors.Primary}}; padding-bottom: 0.5rem; }
+.brand img { max-height: 60px; }
+.brand .organization { color: {{.Branding.Colors.Secondary}}; font-size: 1.2rem; font-weight: 700; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9rem; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4rem; text-align: left; vertical-align: top; }
+.comment { color: #555; font-style: italic; }
+.meta { color: #666; font-size: 0.85rem; }
+.watermark-banner { background: #fdf2e9; border: 1px solid #e67e22; color: #a04000; font-weight: 700; letter-spacing: 0.05em; padding: 0.5rem 1rem; text-align: center; text-transform: uppercase; }
+.watermark { display: none; }
+{{.Theme.CSS}}
+@page { size: A4; margin: 18mm; }
+@media print {
+  body { max-width: none; margin: 0; }
+  .watermark { display: block; position: fixed; top: 50%; left: 50%; transform: translate(-50%, -50%) rotate(-45deg); color: rgba(0, 0, 0, 0.08); font-size: 4rem; font-weight: 700; white-space: nowrap; pointer-events: none; z-index: -1; }
+  h2, h3 { break-after: avoid; }
+  tr, img { break-inside: avoid; }
+}
+</style>
+</head>
+<body>
+{{with .Watermark}}<div class="watermark-banner">{{.}}</div>
+<div class="watermark" aria-hidden="true">{{.}}</div>
+{{end}}{{if or .LogoURI .Branding.Organization .Branding.Header}}<header class="brand">
+{{with .LogoURI}}<img src="{{.}}" alt="">{{end}}
+<div>{{with .Branding.Organization}}<div class="organization">{{.}}</div>{{end}}{{with .Branding.Header}}<div class="meta">{{.}}</div>{{end}}</div>
+</header>
+{{end}}{{with .Branding.ReportTitle}}<p class="meta">{{.}}</p>
+{{end}}<h1>{{.Data.Metadata.TestName}}</h1>
+<p class="meta">{{.Data.Metadata.LocalTestDate.Format "January 2, 2006"}} &middot; Report {{.ReportID}} &middot; Generated {{.Generated}} with analysis version {{.Version}}{{with .Reading}} &middot; {{.Words}} words, ~{{.ReadingMinutes}} min{{end}}</p>
+<h2>{{.Data.Interpretation.Level}}</h2>
+<p>{{.Data.Interpretation.Description}}</p>
+<img src="{{.ChartURI}}" alt="Score chart" width="600">
+<img src="{{.RadarURI}}" alt="Domain profile" width="520">
+{{with .DomainName}}<h2>{{.}}</h2>
+{{end}}{{if .Analysis}}<section class="analysis">{{.Analysis}}</section>{{end}}
+<h2>Answers</h2>
+{{if and .IncludeComments .Data.AdditionalContext}}<h3>{{.ContextTitle}}</h3>
+<p class="comment">{{.Data.AdditionalContext}}</p>
+{{end}}<table>
+<tr><th>#</th><th>Question</th><th>Answer</th><th>Score</th></tr>
+{{range .Data.QuestionsAndAnswers}}<tr><td>Q{{.ID}}</td><td>{{.Text}}{{if and $.IncludeComments .Comment}}<div class="comment">{{.Comment}}</div>{{end}}</td><td>{{.AnswerText}}</td><td>{{.Score}}</td></tr>
+{{end}}</table>
+<p class="meta">{{.Reference.Label}}: {{.Reference.Source}}</p>
+{{with .Verification}}<p class="meta">Verify this report at {{.URL}}</p>
+{{end}}{{if or .Branding.Footer .Branding.Contact.Details}}<footer class="meta">{{with .Branding.Footer}}<p>{{.}}</p>{{end}}{{with .Branding.Contact.Details}}<p>{{range $i, $detail := .}}{{if $i}} &middot; {{end}}{{$detail}}{{end}}</p>{{end}}</footer>
+{{end}}</body>
+</html>
+`))
+
+// exportHTMLHandler renders a fully self-contained HTML report with fonts
+// and charts inlined as data URIs, suitable for offline archiving
+func exportHTMLHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := applyStoredReport(c.Request.Context(), &req); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := requireRAADSR(req.Assessment, "The HTML export"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := applyDomainReport(&req); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := applyAnalysisVersion(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyTheme(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("compact") == "true" {
+		req.Compact = true
+	}
+
+	reportID := uuid.New().String()
+	log.Printf("📦 Exporting self-contained HTML report %s (compact: %t)", reportID, req.Compact)
+
+	content, err := renderExportHTML(c.Request.Context(), req, reportID)
+	if err != nil {
+		log.Printf("❌ Error rendering HTML export: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render HTML export: " + err.Error()})
+		return
+	}
+
+	if len(content) > exportSizeWarningBytes {
+		log.Printf("⚠️  HTML export %s is %d bytes, above the %d bytes guideline", reportID, len(content), exportSizeWarningBytes)
+		c.Header("X-Export-Warning", fmt.Sprintf("export size %d bytes exceeds %d bytes", len(content), exportSizeWarningBytes))
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="raads-r-report-%s.html"`, reportID))
+	c.Data(200, "text/html; charset=utf-8", content)
+}
+
+// applyStoredReport replaces the assessment and markdown of an export
+// request with those of its stored report, if any, which must belong to the
+// client of the request
+func applyStoredReport(ctx context.Context, req *ExportRequest) error {
+	if req.ReportID == "" {
+		return nil
+	}
+	if req.DomainReportID != "" {
+		return errExportSources
+	}
+	report, err := getOwnedReport(ctx, req.ReportID)
+	if errors.Is(err, errReportNotFound) {
+		return fmt.Errorf("report %s not found", req.ReportID)
+	}
+	if err != nil {
+		return err
+	}
+	req.Assessment = report.Assessment
+	req.Markdown = report.Markdown
+	req.AnalysisVersion = analysisVersionFor(report.PromptVersion)
+	req.stored = true
+	return nil
+}
+
+// applyDomainReport replaces the markdown of an export request with its
+// stored domain report, if any, and limits the answers to its domain
+func applyDomainReport(req *ExportRequest) error {
+	if req.DomainReportID == "" {
+		return nil
+	}
+	report, domain, err := domainReportForExport(req.DomainReportID, req.Assessment)
+	if err != nil {
+		return err
+	}
+	req.Markdown = report.Markdown
+	req.Assessment = domainAssessment(req.Assessment, domain)
+	req.domainName = domain.Name
+	return nil
+}
+
+// applyAnalysisVersion defaults the analysis version of an export request
+// to the current one, and rejects versions missing from the changelog
+func applyAnalysisVersion(req *ExportRequest) error {
+	if req.AnalysisVersion == "" {
+		req.AnalysisVersion = currentAnalysisVersion()
+		return nil
+	}
+	if !knownAnalysisVersion(req.AnalysisVersion) {
+		return fmt.Errorf("unknown analysis version %q, see /versions/prompts", req.AnalysisVersion)
+	}
+	return nil
+}
+
+// exportSourceStatus is the HTTP status of a stored report that cannot be
+// exported
+func exportSourceStatus(err error) int {
+	switch {
+	case errors.Is(err, errDomainReportMismatch):
+		return 409
+	case errors.Is(err, errExportSources):
+		return 400
+	}
+	return 404
+}
+
+var errExportSources = errors.New("reportId and domainReportId cannot both be provided")
+
+// renderExportHTML assembles the standalone HTML document for an export
+// request, in its theme and the brand of its tenant
+func renderExportHTML(ctx context.Context, req ExportRequest, reportID string) ([]byte, error) {
+	profile, err := referenceProfileFor(req.Assessment.ReferenceProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := newScoreChart(req.Assessment, profile)
+	view := exportView{
+		Data:      req.Assessment,
+		ChartURI:  template.URL(dataURI("image/svg+xml", []byte(chart.SVG()))),
+		RadarURI:  template.URL(dataURI("image/svg+xml", []byte(chart.RadarSVG()))),
+		ReportID:  reportID,
+		Version:   req.AnalysisVersion,
+		Reference: profile,
+
+		IncludeComments: req.IncludeComments == nil || *req.IncludeComments,
+		ContextTitle:    participantContextTitle(req.Assessment.Language),
+		DomainName:      req.domainName,
+		Watermark:       reportWatermark,
+	}
+	if view.Theme, err = reportThemeFor(req.Theme); err != nil {
+		return nil, err
+	}
+	view.Branding = view.Theme.apply(brandingFor(ctx))
+	view.LogoURI = template.URL(view.Branding.LogoDataURI())
+	view.Generated = time.Now().UTC().Format("2006-01-02 15:04 MST")
+	if view.Verification, err = newReportVerification(req); err != nil {
+		return nil, err
+	}
+
+	if !req.Compact {
+		css, err := fontFaceCSS()
+		if err != nil {
+			return nil, err
+		}
+		view.FontCSS = template.CSS(css)
+	}
+
+	if req.Markdown != "" {
+		html, err := renderMarkdown(req.Markdown, "export")
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert analysis to HTML: %w", err)
+		}
+		view.Analysis = template.HTML(html)
+		reading := analysisReadingStats(req.Markdown, req.Assessment.Language)
+		view.Reading = &reading
+	}
+
+	var out bytes.Buffer
+	if err := exportTemplate.Execute(&out, view); err != nil {
+		return nil, fmt.Errorf("failed to execute export template: %w", err)
+	}
+
+	return externalRefPattern.ReplaceAll(out.Bytes(), nil), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleExport is an export request of a single answered question
+func sampleExport() ExportRequest {
+	return ExportRequest{
+		Assessment: AssessmentData{
+			Language: "en",
+			Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+			QuestionsAndAnswers: []QuestionAndAnswer{
+				{ID: 1, Text: "Sample", AnswerText: "Never true"},
+			},
+		},
+		Markdown: "## Sample",
+	}
+}
+
+func TestRenderExportHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		compact  bool
+		fontFace bool
+	}{
+		{"self-contained", false, true},
+		{"compact", true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := sampleExport()
+			req.Compact = tc.compact
+			html, err := renderExportHTML(context.Background(), req, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(html), "<h2") || !strings.Contains(string(html), "Sample") {
+				t.Error("the analysis is not in the export")
+			}
+			if got := strings.Contains(string(html), "@font-face"); got != tc.fontFace {
+				t.Errorf("fonts embedded: %t, want %t", got, tc.fontFace)
+			}
+		})
+	}
+}
+
+// remoteRefPattern matches any attribute making the browser reach a remote
+// resource, however it is quoted
+var remoteRefPattern = regexp.MustCompile(`(?i)(src|href)\s*=\s*["']?\s*https?://`)
+
+// TestExportHTMLNoExternalRefs makes sure nothing in the exported HTML, from
+// the analysis to the template and the verification link, points to a
+// remote resource
+func TestExportHTMLNoExternalRefs(t *testing.T) {
+	useReportSigning(t)
+	req := sampleExport()
+	req.Markdown = "## Sample\n\n![tracker](https://example.com/pixel.png) and [a link](http://example.com/page)\n\n<img src=\"https://example.com/raw.png\">"
+	req.ReportID, req.stored = "test", true
+	comment := `<img src="https://example.com/comment.png">`
+	req.Assessment.QuestionsAndAnswers[0].Comment = &comment
+
+	html, err := renderExportHTML(context.Background(), req, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := remoteRefPattern.FindAllString(string(html), -1); len(refs) > 0 {
+		t.Errorf("the export refers to remote resources: %q", refs)
+	}
+	if !verificationLink.Match(html) {
+		t.Error("the verification link is missing from the export")
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeClaude is an in-process stand-in for the Anthropic Messages API, and
+// for the generation endpoints of OpenAI-compatible APIs, Azure OpenAI,
+// Gemini, Ollama and Bedrock. It answers both plain
+// JSON and streaming requests with a canned report, and can be programmed
+// with latency and injected errors to exercise the handlers without network
+// access or an API key.
+type fakeClaude struct {
+	mu sync.Mutex
+
+	// Markdown returned as the assistant message
+	Report string
+	// Delay before responding, and between streamed deltas
+	Latency    time.Duration
+	ChunkDelay time.Duration
+	// When non-zero, the next FailNext requests fail with this status
+	FailStatus int
+	FailNext   int
+
+	// Requests received, most recent last
+	Requests   []ClaudeRequest
+	requestIDs int
+
+	// Results of the message batches, as JSON lines, by batch ID
+	batches map[string][]byte
+}
+
+// API versions the fake accepts, as the real API rejects unknown ones
+var fakeClaudeVersions = map[string]bool{"2023-06-01": true}
+
+const fakeClaudeReport = `## Executive Summary
+
+This is a simulated analysis produced by the fake Claude server.
+
+### Score Overview
+
+Scores are reproduced from the submitted assessment (Q1, Q2).
+
+## Conclusion
+
+No clinical conclusion can be drawn from simulated output.
+`
+
+func newFakeClaude() *fakeClaude {
+	return &fakeClaude{Report: fakeClaudeReport}
+}
+
+// fakeReportFor returns the canned report, laid out with the required
+// structure of the prompt of a request if any, so that generated reports
+// pass structure validation. Reports set by callers are returned as is.
+func fakeReportFor(report string, req any) string {
+	if report != fakeClaudeReport {
+		return report
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return report
+	}
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return report
+	}
+	outline := fakePromptOutline(decoded)
+	if len(outline) == 0 {
+		return report
+	}
+	var b strings.Builder
+	for _, h := range outline {
+		fmt.Fprintf(&b, "%s %s\n\nThis is a simulated section produced by the fake Claude server (Q1, Q2).\n\n", strings.Repeat("#", h.Level), h.Text)
+	}
+	return b.String()
+}
+
+// fakePromptOutline finds the required outline of any prompt in a decoded
+// request
+func fakePromptOutline(value any) []outlineHeading {
+	switch v := value.(type) {
+	case string:
+		return requiredOutline(v)
+	case []any:
+		for _, item := range v {
+			if outline := fakePromptOutline(item); len(outline) > 0 {
+				return outline
+			}
+		}
+	case map[string]any:
+		for _, item := range v {
+			if outline := fakePromptOutline(item); len(outline) > 0 {
+				return outline
+			}
+		}
+	}
+	return nil
+}
+
+// startFakeClaude serves a fake API for the duration of a test and points
+// every provider at it
+func startFakeClaude(t *testing.T) *fakeClaude {
+	t.Helper()
+	fake := newFakeClaude()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	urls := []*string{&claudeBaseURL, &openAIBaseURL, &geminiBaseURL, &ollamaBaseURL, &azureOpenAIEndpoint, &bedrockEndpoint}
+	previous := make([]string, len(urls))
+	for i, url := range urls {
+		previous[i] = *url
+	}
+	t.Cleanup(func() {
+		for i, url := range urls {
+			*url = previous[i]
+		}
+	})
+	claudeBaseURL = server.URL
+	openAIBaseURL = server.URL + "/v1"
+	geminiBaseURL = server.URL + "/v1beta"
+	ollamaBaseURL = server.URL
+	azureOpenAIEndpoint = server.URL
+	bedrockEndpoint = server.URL
+	return fake
+}
+
+func (f *fakeClaude) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(200)
+		return
+	}
+
+	f.mu.Lock()
+	f.requestIDs++
+	w.Header().Set("request-id", fmt.Sprintf("req_fake_%06d", f.requestIDs))
+	f.mu.Unlock()
+
+	if r.Method == http.MethodPost && (r.URL.Path == "/v1/chat/completions" || strings.HasPrefix(r.URL.Path, "/openai/deployments/")) {
+		f.serveChatCompletion(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/model/") {
+		f.serveBedrock(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/api/chat" {
+		f.serveOllamaChat(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && (strings.HasSuffix(r.URL.Path, ":generateContent") || strings.HasSuffix(r.URL.Path, ":streamGenerateContent")) {
+		f.serveGenerateContent(w, r)
+		return
+	}
+	if version := r.Header.Get("anthropic-version"); !fakeClaudeVersions[version] {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"type":"error","error":{"type":"invalid_request_error","message":"anthropic-version: %q is not a valid version"}}`, version)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/v1/models" {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-sonnet-4-20250514"}],"has_more":true}`)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/messages/batches") {
+		f.serveMessageBatches(w, r)
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req ClaudeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"type":"error","error":{"type":"invalid_request_error","message":"invalid JSON"}}`, 400)
+		return
+	}
+
+	f.mu.Lock()
+	f.Requests = append(f.Requests, req)
+	report, latency, chunkDelay := f.Report, f.Latency, f.ChunkDelay
+	failStatus := 0
+	if f.FailNext > 0 {
+		f.FailNext--
+		failStatus = f.FailStatus
+	}
+	f.mu.Unlock()
+	report = fakeReportFor(report, req)
+
+	time.Sleep(latency)
+
+	if failStatus != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(failStatus)
+		fmt.Fprintf(w, `{"type":"error","error":{"type":"api_error","message":"injected failure %d"}}`, failStatus)
+		return
+	}
+
+	inputTokens := 0
+	for _, message := range req.Messages {
+		content, _ := json.Marshal(message.Content)
+		inputTokens += len(content) / 4
+	}
+	outputTokens := len(report) / 4
+
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gin.H{
+			"type":        "message",
+			"role":        "assistant",
+			"model":       req.Model,
+			"content":     []ContentBlock{{Type: "text", Text: report}},
+			"stop_reason": "end_turn",
+			"usage":       ClaudeUsage{InputTokens: inputTokens, OutputTokens: outputTokens},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, payload any) {
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send("message_start", gin.H{"type": "message_start", "message": gin.H{"type": "message", "usage": ClaudeUsage{InputTokens: inputTokens}}})
+	send("content_block_start", gin.H{"type": "content_block_start", "index": 0, "content_block": ContentBlock{Type: "text"}})
+	for _, word := range strings.SplitAfter(report, " ") {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(chunkDelay):
+		}
+		send("content_block_delta", gin.H{"type": "content_block_delta", "index": 0, "delta": ClaudeStreamDelta{Type: "text_delta", Text: word}})
+	}
+	send("content_block_stop", gin.H{"type": "content_block_stop", "index": 0})
+	send("message_delta", gin.H{"type": "message_delta", "delta": gin.H{"stop_reason": "end_turn"}, "usage": ClaudeUsage{OutputTokens: outputTokens}})
+	send("message_stop", gin.H{"type": "message_stop"})
+}
+
+// serveMessageBatches creates message batches, answering every request
+// with the canned report, and serves their status and results. Batches
+// end as soon as they are created.
+func (f *fakeClaude) serveMessageBatches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/messages/batches" {
+		var req struct {
+			Requests []struct {
+				CustomID string        `json:"custom_id"`
+				Params   ClaudeRequest `json:"params"`
+			} `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Requests) == 0 {
+			http.Error(w, `{"type":"error","error":{"type":"invalid_request_error","message":"invalid batch"}}`, 400)
+			return
+		}
+
+		f.mu.Lock()
+		canned := f.Report
+		f.mu.Unlock()
+		var results bytes.Buffer
+		for _, request := range req.Requests {
+			report := fakeReportFor(canned, request.Params)
+			json.NewEncoder(&results).Encode(gin.H{
+				"custom_id": request.CustomID,
+				"result": gin.H{
+					"type": "succeeded",
+					"message": gin.H{
+						"type":    "message",
+						"role":    "assistant",
+						"content": []ContentBlock{{Type: "text", Text: report}},
+						"usage":   ClaudeUsage{InputTokens: 1000, OutputTokens: len(report) / 4},
+					},
+				},
+			})
+		}
+
+		f.mu.Lock()
+		if f.batches == nil {
+			f.batches = map[string][]byte{}
+		}
+		id := fmt.Sprintf("msgbatch_fake_%06d", len(f.batches)+1)
+		f.batches[id] = results.Bytes()
+		f.mu.Unlock()
+
+		json.NewEncoder(w).Encode(gin.H{
+			"id":                id,
+			"type":              "message_batch",
+			"processing_status": "in_progress",
+			"request_counts":    gin.H{"processing": len(req.Requests)},
+		})
+		return
+	}
+
+	id, resultsPath := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/messages/batches/"), "/results")
+	f.mu.Lock()
+	results, ok := f.batches[id]
+	f.mu.Unlock()
+	if r.Method != http.MethodGet || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if resultsPath {
+		w.Header().Set("Content-Type", "application/binary")
+		w.Write(results)
+		return
+	}
+	json.NewEncoder(w).Encode(gin.H{
+		"id":                id,
+		"type":              "message_batch",
+		"processing_status": "ended",
+		"request_counts":    gin.H{"succeeded": bytes.Count(results, []byte("\n"))},
+		"results_url":       claudeBaseURL + r.URL.Path + "/results",
+	})
+}
+
+// serveChatCompletion answers an OpenAI chat completion request with the
+// canned report
+func (f *fakeClaude) serveChatCompletion(w http.ResponseWriter, r *http.Request) {
+	var req openAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":{"type":"invalid_request_error","message":"invalid JSON"}}`, 400)
+		return
+	}
+
+	f.mu.Lock()
+	report, latency, chunkDelay := f.Report, f.Latency, f.ChunkDelay
+	failStatus := 0
+	if f.FailNext > 0 {
+		f.FailNext--
+		failStatus = f.FailStatus
+	}
+	f.mu.Unlock()
+	report = fakeReportFor(report, req)
+
+	time.Sleep(latency)
+
+	if failStatus != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(failStatus)
+		fmt.Fprintf(w, `{"error":{"type":"api_error","message":"injected failure %d"}}`, failStatus)
+		return
+	}
+
+	usage := openAIUsage{CompletionTokens: len(report) / 4}
+	for _, message := range req.Messages {
+		usage.PromptTokens += len(message.Content) / 4
+	}
+
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gin.H{
+			"object":  "chat.completion",
+			"model":   req.Model,
+			"choices": []gin.H{{"index": 0, "message": openAIMessage{Role: "assistant", Content: report}, "finish_reason": "stop"}},
+			"usage":   usage,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(payload any) {
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, word := range strings.SplitAfter(report, " ") {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(chunkDelay):
+		}
+		send(gin.H{"object": "chat.completion.chunk", "choices": []gin.H{{"index": 0, "delta": gin.H{"content": word}}}})
+	}
+	send(gin.H{"object": "chat.completion.chunk", "choices": []gin.H{{"index": 0, "delta": gin.H{}, "finish_reason": "stop"}}})
+	send(gin.H{"object": "chat.completion.chunk", "choices": []gin.H{}, "usage": usage})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// serveGenerateContent answers a Gemini generation request with the canned
+// report
+func (f *fakeClaude) serveGenerateContent(w http.ResponseWriter, r *http.Request) {
+	var req geminiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":{"status":"INVALID_ARGUMENT","message":"invalid JSON"}}`, 400)
+		return
+	}
+
+	f.mu.Lock()
+	report, latency, chunkDelay := f.Report, f.Latency, f.ChunkDelay
+	failStatus := 0
+	if f.FailNext > 0 {
+		f.FailNext--
+		failStatus = f.FailStatus
+	}
+	f.mu.Unlock()
+	report = fakeReportFor(report, req)
+
+	time.Sleep(latency)
+
+	if failStatus != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(failStatus)
+		fmt.Fprintf(w, `{"error":{"status":"INTERNAL","message":"injected failure %d"}}`, failStatus)
+		return
+	}
+
+	usage := geminiUsage{CandidatesTokenCount: len(report) / 4}
+	for _, content := range req.Contents {
+		for _, part := range content.Parts {
+			usage.PromptTokenCount += len(part.Text) / 4
+		}
+	}
+	response := func(text, finishReason string, usage *geminiUsage) gin.H {
+		return gin.H{
+			"candidates":    []gin.H{{"content": geminiContent{Role: "model", Parts: []geminiPart{{Text: text}}}, "finishReason": finishReason}},
+			"usageMetadata": usage,
+		}
+	}
+
+	if !strings.Contains(r.URL.Path, ":streamGenerateContent") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response(report, "STOP", &usage))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	words := strings.SplitAfter(report, " ")
+	for i, word := range words {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(chunkDelay):
+		}
+		chunk := response(word, "", nil)
+		if i == len(words)-1 {
+			chunk = response(word, "STOP", &usage)
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\r\n\r\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// serveOllamaChat answers an Ollama chat request with the canned report
+func (f *fakeClaude) serveOllamaChat(w http.ResponseWriter, r *http.Request) {
+	var req ollamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid JSON"}`, 400)
+		return
+	}
+
+	f.mu.Lock()
+	report, latency, chunkDelay := f.Report, f.Latency, f.ChunkDelay
+	failStatus := 0
+	if f.FailNext > 0 {
+		f.FailNext--
+		failStatus = f.FailStatus
+	}
+	f.mu.Unlock()
+	report = fakeReportFor(report, req)
+
+	time.Sleep(latency)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if failStatus != 0 {
+		w.WriteHeader(failStatus)
+		fmt.Fprintf(w, `{"error":"injected failure %d"}`, failStatus)
+		return
+	}
+
+	promptTokens := 0
+	for _, message := range req.Messages {
+		promptTokens += len(message.Content) / 4
+	}
+	final := ollamaChatResponse{
+		Message:         openAIMessage{Role: "assistant"},
+		Done:            true,
+		DoneReason:      "stop",
+		PromptEvalCount: promptTokens,
+		EvalCount:       len(report) / 4,
+	}
+
+	if !req.Stream {
+		final.Message.Content = report
+		json.NewEncoder(w).Encode(final)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for _, word := range strings.SplitAfter(report, " ") {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(chunkDelay):
+		}
+		encoder.Encode(ollamaChatResponse{Message: openAIMessage{Role: "assistant", Content: word}})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	encoder.Encode(final)
+}
+
+// serveBedrock answers a Bedrock invocation of a Claude model with the
+// canned report, streamed in event stream frames
+func (f *fakeClaude) serveBedrock(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(403)
+		fmt.Fprint(w, `{"message":"Missing Authentication Token"}`)
+		return
+	}
+	var req bedrockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AnthropicVersion != bedrockAnthropicVersion {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"message":"Malformed input request"}`)
+		return
+	}
+
+	f.mu.Lock()
+	report, latency, chunkDelay := f.Report, f.Latency, f.ChunkDelay
+	failStatus := 0
+	if f.FailNext > 0 {
+		f.FailNext--
+		failStatus = f.FailStatus
+	}
+	f.mu.Unlock()
+	report = fakeReportFor(report, req)
+
+	time.Sleep(latency)
+
+	if failStatus != 0 {
+		w.WriteHeader(failStatus)
+		fmt.Fprintf(w, `{"message":"injected failure %d"}`, failStatus)
+		return
+	}
+
+	inputTokens := 0
+	for _, message := range req.Messages {
+		content, _ := json.Marshal(message.Content)
+		inputTokens += len(content) / 4
+	}
+	outputTokens := len(report) / 4
+
+	if !strings.HasSuffix(r.URL.Path, "/invoke-with-response-stream") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gin.H{
+			"type":        "message",
+			"role":        "assistant",
+			"content":     []ContentBlock{{Type: "text", Text: report}},
+			"stop_reason": "end_turn",
+			"usage":       ClaudeUsage{InputTokens: inputTokens, OutputTokens: outputTokens},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	flusher, _ := w.(http.Flusher)
+	send := func(event any) {
+		data, _ := json.Marshal(event)
+		payload, _ := json.Marshal(gin.H{"bytes": base64.StdEncoding.EncodeToString(data)})
+		w.Write(encodeEventStreamFrame(map[string]string{":message-type": "event", ":event-type": "chunk", ":content-type": "application/json"}, payload))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(gin.H{"type": "message_start", "message": gin.H{"type": "message", "usage": ClaudeUsage{InputTokens: inputTokens}}})
+	for _, word := range strings.SplitAfter(report, " ") {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(chunkDelay):
+		}
+		send(gin.H{"type": "content_block_delta", "index": 0, "delta": ClaudeStreamDelta{Type: "text_delta", Text: word}})
+	}
+	send(gin.H{"type": "message_delta", "delta": gin.H{"stop_reason": "end_turn"}, "usage": ClaudeUsage{OutputTokens: outputTokens}})
+	send(gin.H{"type": "message_stop"})
+}
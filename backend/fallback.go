@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/claudestream"
+)
+
+// providerTemplate is the last resort of a fallback chain: a summary of the
+// scores built without any model
+const providerTemplate = "template"
+
+// Providers tried in order when LLM_PROVIDER is unavailable, such as
+// "openai,template"
+var fallbackProviders = splitList(envString("LLM_FALLBACK_PROVIDERS", ""))
+
+// The template ends the chain unless TEMPLATE_FALLBACK is 0, and replaces
+// an active provider without credentials, so that clients get a summary of
+// the scores rather than an error
+var templateFallback = os.Getenv("TEMPLATE_FALLBACK") != "0"
+
+// providerLink is a provider of the chain with its own circuit breaker, so
+// that a provider known to be down is skipped without waiting for it
+type providerLink struct {
+	Provider string
+	Breaker  *circuitBreaker
+}
+
+var (
+	chainOnce sync.Once
+	chain     []providerLink
+)
+
+// providerChain returns the active provider followed by the fallbacks
+func providerChain() []providerLink {
+	chainOnce.Do(func() {
+		chain = []providerLink{{Provider: activeProvider, Breaker: claudeBreaker}}
+		for _, provider := range fallbackProviders {
+			chain = append(chain, providerLink{
+				Provider: provider,
+				Breaker:  newCircuitBreaker(breakerFailureThreshold, breakerOpenDuration, nil),
+			})
+		}
+		if templateFallback && chain[len(chain)-1].Provider != providerTemplate {
+			chain = append(chain, providerLink{
+				Provider: providerTemplate,
+				Breaker:  newCircuitBreaker(breakerFailureThreshold, breakerOpenDuration, nil),
+			})
+		}
+	})
+	return chain
+}
+
+// aiServiceAvailable returns the error generations would be refused with:
+// the AI service is unavailable only when every provider of the chain is
+func aiServiceAvailable() error {
+	var err error
+	for _, link := range providerChain() {
+		if err = link.Breaker.Check(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func chainReadiness() readinessCheck {
+	return readinessCheck{Name: "AI service", Check: aiServiceAvailable}
+}
+
+// fallbackEligible reports whether a failed generation may be retried with
+// the next provider: the provider was down, overloaded or refused the
+// credentials, rather than the request being invalid
+func fallbackEligible(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, errAIUnavailable) {
+		return true
+	}
+	switch classifyFailure(err) {
+	case failureUpstreamUnavailable, failureTimeout, failureRateLimited, failureCredentialRejected:
+		return true
+	}
+	return false
+}
+
+// generateWithFallback runs a generation with each provider of the chain in
+// turn, until one succeeds or a failure is not worth a fallback. restartable
+// reports whether the client has not received any text yet; a nil one
+// always allows a fallback.
+func generateWithFallback(ctx context.Context, input assessmentInput, prompt, model string, restartable func() bool,
+	generate func(provider string, input assessmentInput, prompt, model string) (string, error)) (string, error) {
+	var lastErr error
+	for i, link := range providerChain() {
+		if i > 0 {
+			if !fallbackEligible(ctx, lastErr) || restartable != nil && !restartable() {
+				return "", lastErr
+			}
+			var err error
+			if input, prompt, err = providerInput(link.Provider, input, prompt); err != nil {
+				return "", err
+			}
+			model = providerDefaultModel(link.Provider, modeFull)
+		}
+
+		if err := link.Breaker.Allow(); err != nil {
+			lastErr = err
+			continue
+		}
+		if i > 0 {
+			log.Printf("🪂 Falling back to the %s provider: %v", link.Provider, lastErr)
+		}
+		markdown, err := generate(link.Provider, input, prompt, model)
+		link.Breaker.Record(err)
+		if err == nil {
+			if i > 0 || link.Provider == providerTemplate {
+				recordFallback(ctx, link.Provider, model)
+			}
+			return markdown, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// providerInput inlines an attached assessment for providers without
+// document support, replacing the reference to the attachment in the prompt
+func providerInput(provider string, input assessmentInput, prompt string) (assessmentInput, string, error) {
+	if input.Document == nil || providerCapabilities[provider].Documents {
+		return input, prompt, nil
+	}
+	compact, err := json.Marshal(input.Assessment)
+	if err != nil {
+		return input, prompt, fmt.Errorf("failed to serialize assessment data: %w", err)
+	}
+	inlined := assessmentInput{Mode: inputModeCompact, Inline: string(compact), System: input.System, Assessment: input.Assessment}
+	return inlined, strings.Replace(prompt, input.PromptData(), inlined.PromptData(), 1), nil
+}
+
+// recordFallback records the provider that generated the analysis after a
+// fallback on the request, and warns the client
+func recordFallback(ctx context.Context, provider, model string) {
+	fallbacks.Add(provider)
+
+	routing := routingFrom(ctx)
+	routing.mu.Lock()
+	routing.route.Provider = provider
+	routing.route.Model = model
+	routing.route.Source = routeFallback
+	routing.mu.Unlock()
+
+	if provider == providerTemplate {
+		warningsFrom(ctx).Add(Warning{Code: warnTemplateFallback, Message: "The AI service is unavailable, this analysis only summarizes the scores"})
+		return
+	}
+	warningsFrom(ctx).Add(Warning{Code: warnProviderFallback, Message: "The analysis was generated by the " + provider + " provider"})
+}
+
+// usedTemplateFallback reports whether the generation of a request fell
+// back to the template, whose output must not be cached as an analysis
+func usedTemplateFallback(ctx context.Context) bool {
+	return routingFrom(ctx).Route().Provider == providerTemplate
+}
+
+// fallbackCounter counts generations per fallback provider
+type fallbackCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+var fallbacks = &fallbackCounter{counts: make(map[string]int64)}
+
+func (c *fallbackCounter) Add(provider string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[provider]++
+}
+
+func writeFallbackMetric(out *strings.Builder, name, help string) {
+	fallbacks.mu.Lock()
+	defer fallbacks.mu.Unlock()
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for i, link := range providerChain() {
+		if i > 0 || link.Provider == providerTemplate {
+			fmt.Fprintf(out, "%s{provider=%q} %d\n", name, link.Provider, fallbacks.counts[link.Provider])
+		}
+	}
+}
+
+// templateDomainTexts are the canned interpretations of the template per
+// domain, for a score below its threshold and for one that reaches it
+var templateDomainTexts = map[string][2]string{
+	"social": {
+		"Your answers describe few of the social difficulties the RAADS-R looks for, such as understanding unwritten rules or reading the intentions of others.",
+		"Your answers describe social difficulties often reported by autistic adults, such as understanding unwritten rules, reading the intentions of others or keeping up with conversations.",
+	},
+	"sensory": {
+		"Your answers describe few of the sensory and motor differences the RAADS-R looks for, such as sensitivity to noise, textures or light.",
+		"Your answers describe sensory and motor differences often reported by autistic adults, such as a strong sensitivity to noise, textures or light, or clumsiness.",
+	},
+	"restricted": {
+		"Your answers describe few of the restricted interests and routines the RAADS-R looks for.",
+		"Your answers describe intense interests and a need for routines often reported by autistic adults, with distress when plans change unexpectedly.",
+	},
+	"language": {
+		"Your answers describe few of the language differences the RAADS-R looks for, such as taking figures of speech literally.",
+		"Your answers describe language differences often reported by autistic adults, such as taking figures of speech literally or repeating phrases.",
+	},
+}
+
+// templateMarkdown summarizes the scores of an assessment without a model,
+// in the structure of a generated analysis. Domain names and score labels
+// come from the language catalog, interpretations from canned texts.
+func templateMarkdown(data AssessmentData) (string, error) {
+	chart, err := chartDataFor(data)
+	if err != nil {
+		return "", err
+	}
+
+	var md strings.Builder
+	md.WriteString("## Executive Summary\n\n")
+	md.WriteString("> The AI analysis service is currently unavailable. This report only summarizes your scores; generate the analysis again later for a detailed interpretation.\n\n")
+	fmt.Fprintf(&md, "Your total RAADS-R score is **%d out of %d**. The clinical threshold is %d.", data.Scores.Total, raadsMaxTotal, totalThreshold)
+	if data.Interpretation.Level != "" {
+		fmt.Fprintf(&md, " Interpretation: **%s**", data.Interpretation.Level)
+		if data.Interpretation.Description != "" {
+			fmt.Fprintf(&md, ", %s", data.Interpretation.Description)
+		}
+		md.WriteString(".")
+	}
+	md.WriteString("\n\n")
+
+	md.WriteString("## Domain Scores\n\n")
+	var over []string
+	for _, d := range chart.Domains {
+		fmt.Fprintf(&md, "- **%s**: %d / %d (%s: %.0f, %s: %.1f)\n", d.Label, d.Score, d.Max, chart.Labels.Threshold, d.Threshold.Value, chart.Labels.Typical, d.Typical.Value)
+		if d.OverThreshold {
+			over = append(over, d.Label)
+		}
+	}
+	md.WriteString("\n")
+	if len(over) > 0 {
+		fmt.Fprintf(&md, "Scores reach the threshold in: %s.\n\n", strings.Join(over, ", "))
+	} else {
+		md.WriteString("No domain score reaches its threshold.\n\n")
+	}
+	if percentiles := normativePercentiles(data); percentiles != nil {
+		fmt.Fprintf(&md, "Percentile ranks for a %s participant, by %s:\n\n", percentiles.Describe(), percentiles.Method)
+		for _, group := range percentiles.Groups {
+			fmt.Fprintf(&md, "- Compared with %s: %s\n", group.Label, group.Describe())
+		}
+		md.WriteString("\n")
+	}
+
+	md.WriteString("## Domain Interpretation\n\n")
+	for _, d := range chart.Domains {
+		texts, ok := templateDomainTexts[d.Key]
+		if !ok {
+			continue
+		}
+		text := texts[0]
+		if d.OverThreshold {
+			text = texts[1]
+		}
+		fmt.Fprintf(&md, "### %s\n\n%s\n\n", d.Label, text)
+	}
+
+	md.WriteString("## Next Steps\n\n")
+	md.WriteString("The RAADS-R is a screening tool, not a diagnosis. Only a qualified clinician can assess autism, taking your history and current situation into account.\n")
+	return md.String(), nil
+}
+
+// templateStream emits a template report as a single text delta, so that it
+// is forwarded like a generated stream
+type templateStream struct {
+	markdown string
+}
+
+func (s templateStream) Skipped() int {
+	return 0
+}
+
+func (s templateStream) Read(handle func(claudestream.Event) error) error {
+	if err := handle(claudestream.TextDelta{Text: s.markdown}); err != nil {
+		return err
+	}
+	return handle(claudestream.Stop{Reason: "end_turn"})
+}
+
+// completeTemplateMarkdown is completeClaudeMarkdown for the template
+func completeTemplateMarkdown(input assessmentInput) (string, error) {
+	if len(input.Composite) > 0 {
+		return compositeTemplateMarkdown(input.Composite)
+	}
+	return instrumentOf(input.Assessment).TemplateMarkdown(input.Assessment)
+}
+
+// streamTemplateMarkdown is streamClaudeMarkdown for the template
+func streamTemplateMarkdown(ctx context.Context, c *gin.Context, gen *generation, input assessmentInput) (string, error) {
+	markdown, err := completeTemplateMarkdown(input)
+	if err != nil {
+		return "", err
+	}
+	markdown, _, err = forwardMarkdownStream(ctx, c, gen, templateStream{markdown: markdown})
+	return markdown, err
+}
+
+// checkProviderChain verifies the fallback providers, and that the
+// template summarizes a sample assessment
+func checkProviderChain() checkResult {
+	result := checkResult{Name: "provider chain", Feature: "analysis"}
+	seen := map[string]bool{activeProvider: true}
+	for i, provider := range fallbackProviders {
+		if seen[provider] {
+			result.Detail = "LLM_FALLBACK_PROVIDERS lists " + provider + " twice, or the active provider"
+			return result
+		}
+		seen[provider] = true
+		if provider == providerTemplate && i != len(fallbackProviders)-1 {
+			result.Detail = "the template never fails, providers after it are never used"
+			return result
+		}
+	}
+
+	result.OK = true
+	names := make([]string, 0, len(providerChain()))
+	for _, link := range providerChain() {
+		names = append(names, link.Provider)
+	}
+	result.Detail = strings.Join(names, " → ")
+	return result
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTemplateMarkdown summarizes the scores in every language, as when no
+// provider is available
+func TestTemplateMarkdown(t *testing.T) {
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			markdown, err := templateMarkdown(AssessmentData{Language: code, Scores: Scores{Total: raadsMaxTotal}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(markdown, "## Domain Scores") {
+				t.Error("the template report has no domain scores")
+			}
+			if n := strings.Count(markdown, "\n### "); n != len(templateDomainTexts) {
+				t.Errorf("%d domain sections instead of %d", n, len(templateDomainTexts))
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error code returned by endpoints of disabled features
+const errFeatureDisabled = "ERR_FEATURE_DISABLED"
+
+// featureFlag is a capability that can be turned off at runtime. Its state
+// is resolved from, in increasing precedence: the default in code, the
+// FEATURE_<NAME> environment variable, and the FEATURE_FLAGS_FILE file,
+// which is reloaded on SIGHUP.
+type featureFlag struct {
+	Name        string
+	Description string
+	Default     bool
+	enabled     atomic.Bool
+}
+
+// Enabled reports whether the feature is on
+func (f *featureFlag) Enabled() bool {
+	return f.enabled.Load()
+}
+
+var featureFlags []*featureFlag
+
+func defineFeature(name, description string, enabled bool) *featureFlag {
+	flag := &featureFlag{Name: name, Description: description, Default: enabled}
+	flag.enabled.Store(enabled)
+	featureFlags = append(featureFlags, flag)
+	return flag
+}
+
+var (
+	featureStats         = defineFeature("stats", "Aggregate statistics endpoint", true)
+	featureHTMLExport    = defineFeature("html_export", "Self-contained HTML report export", true)
+	featureOGImage       = defineFeature("og_image", "Open Graph score image for link previews", true)
+	featureQualityReview = defineFeature("quality_review", "Sampling of consenting generations for prompt review", true)
+	featurePDF           = defineFeature("pdf", "PDF report generation", false)
+	featureAsyncJobs     = defineFeature("async_jobs", "Asynchronous analysis jobs", false)
+	featureBatches       = defineFeature("message_batches", "Analyses of several assessments through the Message Batches API", false)
+)
+
+// JSON file of flag overrides, e.g. {"stats": false}
+var featureFlagsFile = os.Getenv("FEATURE_FLAGS_FILE")
+
+// loadFeatureFlags resolves every flag from its default, the environment
+// and the flags file
+func loadFeatureFlags() error {
+	overrides := map[string]bool{}
+	if featureFlagsFile != "" {
+		content, err := os.ReadFile(featureFlagsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read feature flags file: %w", err)
+		}
+		if err := json.Unmarshal(content, &overrides); err != nil {
+			return fmt.Errorf("failed to parse feature flags file: %w", err)
+		}
+	}
+
+	known := map[string]bool{}
+	for _, flag := range featureFlags {
+		known[flag.Name] = true
+		enabled := flag.Default
+		if value, err := strconv.ParseBool(os.Getenv("FEATURE_" + strings.ToUpper(flag.Name))); err == nil {
+			enabled = value
+		}
+		if value, ok := overrides[flag.Name]; ok {
+			enabled = value
+		}
+		flag.enabled.Store(enabled)
+	}
+	for name := range overrides {
+		if !known[name] {
+			log.Printf("⚠️  Unknown feature flag %q in %s", name, featureFlagsFile)
+		}
+	}
+	return nil
+}
+
+// watchFeatureFlags reloads the flags whenever the process receives SIGHUP
+func watchFeatureFlags() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := loadFeatureFlags(); err != nil {
+				log.Printf("❌ Failed to reload feature flags, keeping previous values: %v", err)
+				continue
+			}
+			log.Printf("🔁 Feature flags reloaded")
+			logFeatureFlags()
+		}
+	}()
+}
+
+// logFeatureFlags prints the state, default and description of every flag
+func logFeatureFlags() {
+	for _, flag := range featureFlags {
+		state := "off"
+		if flag.Enabled() {
+			state = "on"
+		}
+		log.Printf("   - feature %-15s %-3s (default %t) %s", flag.Name, state, flag.Default, flag.Description)
+	}
+}
+
+// requireFeature answers 404 with ERR_FEATURE_DISABLED while the feature is off
+func requireFeature(flag *featureFlag) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !flag.Enabled() {
+			c.AbortWithStatusJSON(404, gin.H{
+				"error":   fmt.Sprintf("Feature %s is disabled", flag.Name),
+				"code":    errFeatureDisabled,
+				"feature": flag.Name,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// featuresHandler lists the feature flags so clients can hide disabled UI
+func featuresHandler(c *gin.Context) {
+	degraded := degradedFeatureList()
+	features := make([]gin.H, 0, len(featureFlags))
+	for _, flag := range featureFlags {
+		feature := gin.H{
+			"name":        flag.Name,
+			"enabled":     flag.Enabled(),
+			"description": flag.Description,
+		}
+		if reason, ok := degraded[flag.Name]; ok {
+			feature["degraded"] = reason
+		}
+		features = append(features, feature)
+	}
+	c.JSON(200, gin.H{"features": features})
+}
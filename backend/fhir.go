@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Base of the canonical URLs of the questionnaires and answer code systems
+// the FHIR exports refer to
+var fhirBaseURL = strings.TrimSuffix(envString("FHIR_BASE_URL", "https://raphink.github.io/raads-r/fhir"), "/")
+
+// Extension giving the score of a coded answer
+const fhirOrdinalValueURL = "http://hl7.org/fhir/StructureDefinition/ordinalValue"
+
+// FHIR R4 data types, limited to the elements the exports use
+type FHIRIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type FHIRExtension struct {
+	URL          string   `json:"url"`
+	ValueDecimal *float64 `json:"valueDecimal,omitempty"`
+}
+
+type FHIRCoding struct {
+	Extension []FHIRExtension `json:"extension,omitempty"`
+	System    string          `json:"system,omitempty"`
+	Code      string          `json:"code"`
+	Display   string          `json:"display,omitempty"`
+}
+
+// FHIRQuestionnaireResponse is a FHIR R4 QuestionnaireResponse resource
+type FHIRQuestionnaireResponse struct {
+	ResourceType  string                          `json:"resourceType"`
+	ID            string                          `json:"id"`
+	Language      string                          `json:"language,omitempty"`
+	Identifier    FHIRIdentifier                  `json:"identifier"`
+	Questionnaire string                          `json:"questionnaire"`
+	Status        string                          `json:"status"`
+	Authored      string                          `json:"authored"`
+	Item          []FHIRQuestionnaireResponseItem `json:"item"`
+}
+
+type FHIRQuestionnaireResponseItem struct {
+	LinkID string                            `json:"linkId"`
+	Text   string                            `json:"text,omitempty"`
+	Answer []FHIRQuestionnaireResponseAnswer `json:"answer,omitempty"`
+	Item   []FHIRQuestionnaireResponseItem   `json:"item,omitempty"`
+}
+
+type FHIRQuestionnaireResponseAnswer struct {
+	ValueCoding  *FHIRCoding `json:"valueCoding,omitempty"`
+	ValueInteger *int        `json:"valueInteger,omitempty"`
+	ValueString  string      `json:"valueString,omitempty"`
+}
+
+// fhirQuestionnaireURL is the canonical URL of the questionnaire of an
+// instrument
+func fhirQuestionnaireURL(instrument Instrument) string {
+	return fhirBaseURL + "/Questionnaire/" + instrument.Key
+}
+
+// fhirAnswerSystem is the code system of the answer scale of an instrument,
+// whose codes are the letters of the answers
+func fhirAnswerSystem(instrument Instrument) string {
+	return fhirBaseURL + "/CodeSystem/" + instrument.Key + "-answers"
+}
+
+func fhirString(linkID, text, value string) FHIRQuestionnaireResponseItem {
+	return FHIRQuestionnaireResponseItem{LinkID: linkID, Text: text, Answer: []FHIRQuestionnaireResponseAnswer{{ValueString: value}}}
+}
+
+func fhirInteger(linkID, text string, value int) FHIRQuestionnaireResponseItem {
+	return FHIRQuestionnaireResponseItem{LinkID: linkID, Text: text, Answer: []FHIRQuestionnaireResponseAnswer{{ValueInteger: &value}}}
+}
+
+// newFHIRQuestionnaireResponse maps an assessment to a QuestionnaireResponse:
+// one item per question, linked by question ID, with the answer coded on the
+// scale of the instrument and its score as ordinal value, comments as
+// nested items, followed by a "scores" group with the total and the domain
+// or subscale scores. Unanswered questions have no answer, and leave the
+// response in progress.
+func newFHIRQuestionnaireResponse(data AssessmentData, includeComments bool, reportID string) FHIRQuestionnaireResponse {
+	instrument := instrumentOf(data)
+	response := FHIRQuestionnaireResponse{
+		ResourceType:  "QuestionnaireResponse",
+		ID:            reportID,
+		Language:      data.Language,
+		Identifier:    FHIRIdentifier{System: "urn:ietf:rfc:3986", Value: "urn:uuid:" + reportID},
+		Questionnaire: fhirQuestionnaireURL(instrument),
+		Status:        "completed",
+		Authored:      data.Metadata.LocalTestDate().Format(time.RFC3339),
+	}
+	if data.Metadata.AnsweredQuestions < data.Metadata.TotalQuestions {
+		response.Status = "in-progress"
+	}
+
+	scale := instrument.Test.AnswerScale(data.Language)
+	for _, qa := range data.QuestionsAndAnswers {
+		item := FHIRQuestionnaireResponseItem{LinkID: strconv.Itoa(qa.ID), Text: qa.Text}
+		if qa.AnswerText != "" {
+			coding := FHIRCoding{System: fhirAnswerSystem(instrument), Code: strconv.Itoa(qa.Answer), Display: qa.AnswerText}
+			for _, option := range scale {
+				if option.Value == qa.Answer && option.Key != "" {
+					coding.Code = option.Key
+				}
+			}
+			score := float64(qa.Score)
+			coding.Extension = []FHIRExtension{{URL: fhirOrdinalValueURL, ValueDecimal: &score}}
+			item.Answer = []FHIRQuestionnaireResponseAnswer{{ValueCoding: &coding}}
+		} else {
+			response.Status = "in-progress"
+		}
+		if includeComments && qa.Comment != nil && *qa.Comment != "" {
+			item.Item = []FHIRQuestionnaireResponseItem{fhirString(item.LinkID+".comment", "Comment", *qa.Comment)}
+		}
+		response.Item = append(response.Item, item)
+	}
+
+	if includeComments && data.AdditionalContext != "" {
+		response.Item = append(response.Item, fhirString("context", participantContextTitle(data.Language), data.AdditionalContext))
+	}
+
+	scores := FHIRQuestionnaireResponseItem{LinkID: "scores", Text: "Scores", Item: []FHIRQuestionnaireResponseItem{
+		fhirInteger("scores.total", "Total", data.Scores.Total),
+	}}
+	if instrument.Key == instrumentRAADSR {
+		totals := domainTotals(data)
+		for _, d := range raadsDomains {
+			scores.Item = append(scores.Item, fhirInteger("scores."+d.Key, d.Name, totals[d.Key]))
+		}
+	} else {
+		for _, s := range subscaleScores(data) {
+			scores.Item = append(scores.Item, fhirInteger("scores."+s.Key, s.Name, s.Score))
+		}
+	}
+	response.Item = append(response.Item, scores)
+
+	return response
+}
+
+// exportFHIRResponseHandler exports an assessment as a FHIR R4
+// QuestionnaireResponse, for EHR systems to ingest
+func exportFHIRResponseHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	reportID := uuid.New().String()
+	response := newFHIRQuestionnaireResponse(req.Assessment, req.IncludeComments == nil || *req.IncludeComments, reportID)
+	content, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		log.Printf("❌ Error rendering FHIR export: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render FHIR export: " + err.Error()})
+		return
+	}
+
+	log.Printf("📦 Exporting %d answers as FHIR QuestionnaireResponse %s", len(req.Assessment.QuestionsAndAnswers), reportID)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-questionnaire-response-%s.json"`, instrumentOf(req.Assessment).Key, reportID))
+	c.Data(200, "application/fhir+json; charset=utf-8", content)
+}
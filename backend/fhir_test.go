@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// TestFHIRQuestionnaireResponse codes the answers of a complete assessment
+// of every instrument, under distinct link IDs
+func TestFHIRQuestionnaireResponse(t *testing.T) {
+	for _, key := range instrumentKeys {
+		t.Run(key, func(t *testing.T) {
+			instrument := instrumentOf(AssessmentData{Instrument: key})
+			catalog, err := instrument.Catalog("en")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := AssessmentData{Language: "en", Instrument: key}
+			for _, q := range catalog.Questions {
+				data.QuestionsAndAnswers = append(data.QuestionsAndAnswers, QuestionAndAnswer{ID: q.ID, Text: q.Text, Category: q.Category, AnswerText: "answered"})
+			}
+			data.Metadata.TotalQuestions = len(data.QuestionsAndAnswers)
+			data.Metadata.AnsweredQuestions = len(data.QuestionsAndAnswers)
+
+			response := newFHIRQuestionnaireResponse(data, true, "test")
+			if response.Status != "completed" {
+				t.Errorf("a complete assessment is %s", response.Status)
+			}
+			seen := map[string]bool{}
+			for _, item := range response.Item[:len(data.QuestionsAndAnswers)] {
+				if seen[item.LinkID] {
+					t.Errorf("duplicate link ID %s", item.LinkID)
+				}
+				if len(item.Answer) != 1 || item.Answer[0].ValueCoding == nil || item.Answer[0].ValueCoding.Code == "" {
+					t.Errorf("question %s has no coded answer", item.LinkID)
+				}
+				seen[item.LinkID] = true
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Code system of the interpretation of a score against its threshold
+const fhirInterpretationSystem = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type FHIRReference struct {
+	Reference string `json:"reference"`
+	Display   string `json:"display,omitempty"`
+}
+
+type FHIRAttachment struct {
+	ContentType string `json:"contentType"`
+	Language    string `json:"language,omitempty"`
+	Data        []byte `json:"data"`
+	Title       string `json:"title,omitempty"`
+}
+
+type FHIRReferenceRange struct {
+	Text string `json:"text"`
+}
+
+// FHIRObservation is a FHIR R4 Observation resource, one per score
+type FHIRObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	ID                string                `json:"id"`
+	Status            string                `json:"status"`
+	Code              FHIRCodeableConcept   `json:"code"`
+	EffectiveDateTime string                `json:"effectiveDateTime"`
+	ValueInteger      int                   `json:"valueInteger"`
+	Interpretation    []FHIRCodeableConcept `json:"interpretation,omitempty"`
+	ReferenceRange    []FHIRReferenceRange  `json:"referenceRange,omitempty"`
+	DerivedFrom       []FHIRReference       `json:"derivedFrom,omitempty"`
+}
+
+// FHIRDiagnosticReport is a FHIR R4 DiagnosticReport resource, with the
+// score Observations as results and the analysis as presented forms
+type FHIRDiagnosticReport struct {
+	ResourceType      string              `json:"resourceType"`
+	ID                string              `json:"id"`
+	Identifier        []FHIRIdentifier    `json:"identifier"`
+	Status            string              `json:"status"`
+	Code              FHIRCodeableConcept `json:"code"`
+	EffectiveDateTime string              `json:"effectiveDateTime"`
+	Issued            string              `json:"issued"`
+	Result            []FHIRReference     `json:"result"`
+	Conclusion        string              `json:"conclusion,omitempty"`
+	PresentedForm     []FHIRAttachment    `json:"presentedForm,omitempty"`
+}
+
+// FHIRBundle is a FHIR R4 collection Bundle, whose entries refer to each
+// other by their urn:uuid full URLs
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	ID           string            `json:"id"`
+	Type         string            `json:"type"`
+	Timestamp    string            `json:"timestamp"`
+	Entry        []FHIRBundleEntry `json:"entry"`
+}
+
+type FHIRBundleEntry struct {
+	FullURL  string `json:"fullUrl"`
+	Resource any    `json:"resource"`
+}
+
+// fhirScore is a score reported as an Observation, with the threshold it
+// is interpreted against, if any
+type fhirScore struct {
+	Key       string
+	Label     string
+	Score     int
+	Max       int
+	Threshold int
+}
+
+// fhirScores lists the total score, and the domain or subscale scores, of
+// an assessment
+func fhirScores(data AssessmentData) []fhirScore {
+	instrument := instrumentOf(data)
+	scores := []fhirScore{{"total", "Total", data.Scores.Total, data.Scores.MaxTotal, instrument.Threshold}}
+	if instrument.Key == instrumentRAADSR {
+		totals := domainTotals(data)
+		for _, d := range raadsDomains {
+			scores = append(scores, fhirScore{d.Key, d.Name, totals[d.Key], d.MaxScore(), d.Threshold})
+		}
+		return scores
+	}
+	for _, s := range subscaleScores(data) {
+		scores = append(scores, fhirScore{s.Key, s.Name, s.Score, s.Max, 0})
+	}
+	return scores
+}
+
+// newFHIRScoreObservation reports a score, flagged high over its threshold
+// and normal below it, derived from the QuestionnaireResponse of the answers
+func newFHIRScoreObservation(instrument Instrument, score fhirScore, effective string, response FHIRReference) FHIRObservation {
+	observation := FHIRObservation{
+		ResourceType: "Observation",
+		ID:           uuid.New().String(),
+		Status:       "final",
+		Code: FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: fhirBaseURL + "/CodeSystem/" + instrument.Key + "-scores", Code: score.Key, Display: score.Label}},
+			Text:   fmt.Sprintf("%s %s score", instrument.Test.Name, score.Label),
+		},
+		EffectiveDateTime: effective,
+		ValueInteger:      score.Score,
+		DerivedFrom:       []FHIRReference{response},
+	}
+	if score.Threshold > 0 {
+		code, display := "N", "Normal"
+		if score.Score >= score.Threshold {
+			code, display = "H", "High"
+		}
+		observation.Interpretation = []FHIRCodeableConcept{{Coding: []FHIRCoding{{System: fhirInterpretationSystem, Code: code, Display: display}}}}
+		observation.ReferenceRange = []FHIRReferenceRange{{Text: fmt.Sprintf("clinical threshold %d of %d", score.Threshold, score.Max)}}
+	}
+	return observation
+}
+
+// newFHIRDiagnosticReportBundle gathers the DiagnosticReport of an export
+// request, its score Observations and the QuestionnaireResponse they are
+// derived from into a collection Bundle. The analysis is attached as
+// Markdown and HTML, when the request has one. The report is preliminary
+// while questions are left unanswered.
+func newFHIRDiagnosticReportBundle(req ExportRequest, reportID string) (FHIRBundle, error) {
+	data := req.Assessment
+	instrument := instrumentOf(data)
+	includeComments := req.IncludeComments == nil || *req.IncludeComments
+	effective := data.Metadata.LocalTestDate().Format(time.RFC3339)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	response := newFHIRQuestionnaireResponse(data, includeComments, uuid.New().String())
+	responseRef := FHIRReference{Reference: "urn:uuid:" + response.ID, Display: instrument.Test.Name + " answers"}
+
+	report := FHIRDiagnosticReport{
+		ResourceType: "DiagnosticReport",
+		ID:           reportID,
+		Identifier:   []FHIRIdentifier{{System: "urn:ietf:rfc:3986", Value: "urn:uuid:" + reportID}},
+		Status:       "final",
+		Code: FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: fhirBaseURL + "/CodeSystem/instruments", Code: instrument.Key, Display: instrument.Test.Name}},
+			Text:   data.Metadata.TestName,
+		},
+		EffectiveDateTime: effective,
+		Issued:            now,
+	}
+	if response.Status != "completed" {
+		report.Status = "preliminary"
+	}
+	if data.Interpretation.Level != "" {
+		report.Conclusion = data.Interpretation.Level
+		if data.Interpretation.Description != "" {
+			report.Conclusion += ": " + data.Interpretation.Description
+		}
+	}
+
+	bundle := FHIRBundle{ResourceType: "Bundle", ID: uuid.New().String(), Type: "collection", Timestamp: now}
+	var observations []FHIRBundleEntry
+	for _, score := range fhirScores(data) {
+		observation := newFHIRScoreObservation(instrument, score, effective, responseRef)
+		report.Result = append(report.Result, FHIRReference{Reference: "urn:uuid:" + observation.ID, Display: observation.Code.Text})
+		observations = append(observations, FHIRBundleEntry{FullURL: "urn:uuid:" + observation.ID, Resource: observation})
+	}
+
+	if req.Markdown != "" {
+		html, err := renderMarkdown(req.Markdown, "export")
+		if err != nil {
+			return FHIRBundle{}, fmt.Errorf("failed to convert analysis to HTML: %w", err)
+		}
+		title := fmt.Sprintf("Analysis (version %s)", req.AnalysisVersion)
+		if req.domainName != "" {
+			title = fmt.Sprintf("%s analysis (version %s)", req.domainName, req.AnalysisVersion)
+		}
+		report.PresentedForm = []FHIRAttachment{
+			{ContentType: "text/markdown; charset=utf-8", Language: data.Language, Data: []byte(req.Markdown), Title: title},
+			{ContentType: "text/html; charset=utf-8", Language: data.Language, Data: []byte(externalRefPattern.ReplaceAllString(html, "")), Title: title},
+		}
+	}
+
+	bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullURL: "urn:uuid:" + report.ID, Resource: report})
+	bundle.Entry = append(bundle.Entry, observations...)
+	bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullURL: responseRef.Reference, Resource: response})
+	return bundle, nil
+}
+
+// exportFHIRReportHandler exports an assessment and its analysis as a FHIR
+// R4 Bundle of a DiagnosticReport, its score Observations and the
+// QuestionnaireResponse of the answers, for EHR systems to ingest
+func exportFHIRReportHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := applyDomainReport(&req); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyAnalysisVersion(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	reportID := uuid.New().String()
+	bundle, err := newFHIRDiagnosticReportBundle(req, reportID)
+	var content []byte
+	if err == nil {
+		content, err = json.MarshalIndent(bundle, "", "  ")
+	}
+	if err != nil {
+		log.Printf("❌ Error rendering FHIR export: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render FHIR export: " + err.Error()})
+		return
+	}
+
+	log.Printf("📦 Exporting FHIR DiagnosticReport %s with %d resources", reportID, len(bundle.Entry))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-diagnostic-report-%s.json"`, instrumentOf(req.Assessment).Key, reportID))
+	c.Data(200, "application/fhir+json; charset=utf-8", content)
+}
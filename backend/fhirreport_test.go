@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFHIRDiagnosticReport makes sure every reference of the report bundle
+// resolves to one of its entries
+func TestFHIRDiagnosticReport(t *testing.T) {
+	sample := ExportRequest{
+		Assessment: AssessmentData{
+			Language: "en",
+			Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now(), TotalQuestions: 1, AnsweredQuestions: 1},
+			QuestionsAndAnswers: []QuestionAndAnswer{
+				{ID: 1, Text: "Sample", AnswerText: "Never true", Answer: 3},
+			},
+		},
+		Markdown:        "## Sample",
+		AnalysisVersion: currentAnalysisVersion(),
+	}
+	bundle, err := newFHIRDiagnosticReportBundle(sample, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]bool{}
+	for _, entry := range bundle.Entry {
+		entries[entry.FullURL] = true
+	}
+	report := bundle.Entry[0].Resource.(FHIRDiagnosticReport)
+	references := report.Result
+	for _, entry := range bundle.Entry {
+		if observation, ok := entry.Resource.(FHIRObservation); ok {
+			references = append(references, observation.DerivedFrom...)
+		}
+	}
+	for _, ref := range references {
+		if !entries[ref.Reference] {
+			t.Errorf("reference %s (%s) is not in the bundle", ref.Reference, ref.Display)
+		}
+	}
+	if len(report.PresentedForm) != 2 {
+		t.Error("the analysis is not attached to the report")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/claudestream"
+)
+
+var (
+	// API key of the Gemini API. Without one, generations go through
+	// Vertex AI with the credentials of the GCP service account.
+	geminiAPIKey = os.Getenv("GEMINI_API_KEY")
+
+	// Base URL of the Gemini API, or of Vertex AI when VERTEX_PROJECT is
+	// set, overridable for proxies and local testing
+	geminiBaseURL = envString("GEMINI_BASE_URL", "")
+
+	// Model of the generations without a routing rule when LLM_PROVIDER
+	// is gemini
+	geminiModel = envString("GEMINI_MODEL", "gemini-2.5-pro")
+
+	// Google Cloud project and region of Vertex AI
+	vertexProject  = os.Getenv("VERTEX_PROJECT")
+	vertexLocation = envString("VERTEX_LOCATION", "us-central1")
+
+	// Access token for Vertex AI, for deployments outside of GCP. On GCP
+	// the token of the service account comes from the metadata server.
+	googleAccessToken = os.Getenv("GOOGLE_ACCESS_TOKEN")
+)
+
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+	GenerationConfig  struct {
+		MaxOutputTokens int      `json:"maxOutputTokens"`
+		Temperature     *float64 `json:"temperature,omitempty"`
+		TopP            *float64 `json:"topP,omitempty"`
+		StopSequences   []string `json:"stopSequences,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+}
+
+// geminiResponse is a generated content, or a chunk of a streamed one
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *geminiUsage `json:"usageMetadata"`
+	Error         *struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Text returns the text of the first candidate
+func (r geminiResponse) Text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// loadGemini validates the credentials of the Gemini provider
+func loadGemini() error {
+	if geminiAPIKey == "" && vertexProject == "" {
+		return fmt.Errorf("GEMINI_API_KEY or VERTEX_PROJECT is required")
+	}
+	return nil
+}
+
+// geminiEndpoint returns the URL of a model method, on the Gemini API or
+// on Vertex AI
+func geminiEndpoint(model, method string) string {
+	if vertexProject != "" {
+		base := geminiBaseURL
+		if base == "" {
+			base = fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1", vertexLocation)
+		}
+		return fmt.Sprintf("%s/projects/%s/locations/%s/publishers/google/models/%s:%s", base, vertexProject, vertexLocation, model, method)
+	}
+	base := geminiBaseURL
+	if base == "" {
+		base = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	return fmt.Sprintf("%s/models/%s:%s", base, model, method)
+}
+
+// vertexToken caches the access token of the GCP service account
+var vertexToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// vertexAccessToken returns a token for Vertex AI, from GOOGLE_ACCESS_TOKEN
+// or from the metadata server, refreshed a minute before it expires
+func vertexAccessToken(ctx context.Context) (string, error) {
+	if googleAccessToken != "" {
+		return googleAccessToken, nil
+	}
+	vertexToken.mu.Lock()
+	defer vertexToken.mu.Unlock()
+	if vertexToken.token != "" && time.Until(vertexToken.expires) > time.Minute {
+		return vertexToken.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata token request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a Vertex AI token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("metadata server returned HTTP %d for the Vertex AI token", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode the Vertex AI token: %w", err)
+	}
+	vertexToken.token = token.AccessToken
+	vertexToken.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return vertexToken.token, nil
+}
+
+// callGemini sends a generation request. Like OpenAI, Gemini gets the
+// assessment inlined in the prompt.
+func callGemini(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int, stream bool) (*http.Response, error) {
+	var geminiReq geminiRequest
+	if input.System != "" {
+		geminiReq.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: input.System}}}
+	}
+	geminiReq.Contents = []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}}
+	geminiReq.GenerationConfig.MaxOutputTokens = models.MaxTokens(model, maxTokens)
+	sampling := samplingFor(input.Assessment)
+	geminiReq.GenerationConfig.Temperature = sampling.Temperature
+	geminiReq.GenerationConfig.TopP = sampling.TopP
+	geminiReq.GenerationConfig.StopSequences = sampling.StopSequences
+
+	jsonData, err := json.Marshal(geminiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Gemini request: %w", err)
+	}
+
+	endpoint := geminiEndpoint(model, "generateContent")
+	if stream {
+		endpoint = geminiEndpoint(model, "streamGenerateContent") + "?alt=sse"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if vertexProject != "" {
+		token, err := vertexAccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set("x-goog-api-key", geminiAPIKey)
+	}
+
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Gemini API: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &claudeAPIError{Status: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// completeGeminiMarkdown is completeClaudeMarkdown for Gemini
+func completeGeminiMarkdown(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callGemini(ctx, input, prompt, model, maxTokens, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var geminiResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return "", fmt.Errorf("failed to decode Gemini response: %w", err)
+	}
+	if usage := geminiResp.UsageMetadata; usage != nil {
+		recordUsage(ctx, model, usage.PromptTokenCount, usage.CandidatesTokenCount)
+	}
+	markdown := geminiResp.Text()
+	if markdown == "" {
+		return "", fmt.Errorf("empty response from Gemini API")
+	}
+
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// streamGeminiMarkdown is streamClaudeMarkdown for Gemini
+func streamGeminiMarkdown(ctx context.Context, c *gin.Context, gen *generation, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callGemini(ctx, input, prompt, model, maxTokens, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	markdown, usage, err := forwardMarkdownStream(ctx, c, gen, newGeminiStream(resp.Body))
+	recordUsage(ctx, model, usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		return "", err
+	}
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// geminiStream reads the responses of a streamed generation as Claude
+// stream events. Each response carries the next piece of text, and the
+// usage so far.
+type geminiStream struct {
+	scanner *bufio.Scanner
+	skipped int
+}
+
+func newGeminiStream(r io.Reader) *geminiStream {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), claudestream.DefaultMaxLineBytes)
+	return &geminiStream{scanner: scanner}
+}
+
+// Skipped returns the number of responses that could not be decoded
+func (s *geminiStream) Skipped() int {
+	return s.skipped
+}
+
+// Read calls handle with every event until the stream ends
+func (s *geminiStream) Read(handle func(claudestream.Event) error) error {
+	for s.scanner.Scan() {
+		data, ok := strings.CutPrefix(s.scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			s.skipped++
+			continue
+		}
+		if chunk.Error != nil {
+			return handle(claudestream.Error{Type: chunk.Error.Status, Message: chunk.Error.Message})
+		}
+		if usage := chunk.UsageMetadata; usage != nil {
+			if err := handle(claudestream.Usage{InputTokens: usage.PromptTokenCount, OutputTokens: usage.CandidatesTokenCount}); err != nil {
+				return err
+			}
+		}
+		if text := chunk.Text(); text != "" {
+			if err := handle(claudestream.TextDelta{Text: text}); err != nil {
+				return err
+			}
+		}
+		if len(chunk.Candidates) > 0 && chunk.Candidates[0].FinishReason != "" {
+			if err := handle(claudestream.Stop{Reason: chunk.Candidates[0].FinishReason}); err != nil {
+				return err
+			}
+		}
+	}
+	return s.scanner.Err()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Generation statuses
+const (
+	generationRunning   = "running"
+	generationCompleted = "completed"
+	generationFailed    = "failed"
+	generationTimeout   = "timeout"
+	generationCancelled = "cancelled"
+	generationUnknown   = "unknown"
+)
+
+const (
+	// Upper bound for a single generation, after which it is abandoned
+	generationMaxDuration = 3 * time.Minute
+
+	// How long the final status of a generation is remembered after it ends
+	generationStatusRetention = 10 * time.Minute
+)
+
+// generation tracks a single in-flight analysis
+type generation struct {
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	cancelled bool
+	partial   string
+	startedAt time.Time
+	warnings  *WarningCollector
+	answers   []QuestionAndAnswer
+	// Hash of the client token the generation was started for, the only
+	// client allowed to cancel it
+	owner string
+}
+
+// setPartial records the markdown produced so far
+func (g *generation) setPartial(markdown string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.partial = markdown
+}
+
+// Partial returns the markdown produced so far
+func (g *generation) Partial() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.partial
+}
+
+// Cancelled reports whether the generation was cancelled on request
+func (g *generation) Cancelled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cancelled
+}
+
+type finishedGeneration struct {
+	status     string
+	partial    string
+	warnings   []Warning
+	answers    []QuestionAndAnswer
+	owner      string
+	finishedAt time.Time
+}
+
+// generationRegistry keeps track of running generations so they can be
+// cancelled, and of recently finished ones so their status can be reported
+type generationRegistry struct {
+	mu       sync.Mutex
+	running  map[string]*generation
+	finished map[string]finishedGeneration
+}
+
+var generations = &generationRegistry{
+	running:  make(map[string]*generation),
+	finished: make(map[string]finishedGeneration),
+}
+
+// Start registers a new generation and returns its time-boxed context.
+// Every call must be paired with a call to Finish.
+func (r *generationRegistry) Start(parent context.Context, reportID string) (context.Context, *generation) {
+	ctx, cancel := context.WithTimeout(parent, generationMaxDuration)
+	gen := &generation{cancel: cancel, startedAt: time.Now(), warnings: warningsFrom(parent), owner: ownerFrom(parent)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[reportID] = gen
+	r.pruneLocked()
+
+	return ctx, gen
+}
+
+// Finish removes a generation from the running set and records its final status
+func (r *generationRegistry) Finish(reportID, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	gen, ok := r.running[reportID]
+	if !ok {
+		return
+	}
+	delete(r.running, reportID)
+	gen.cancel()
+
+	r.finished[reportID] = finishedGeneration{
+		status:     status,
+		partial:    gen.Partial(),
+		warnings:   gen.warnings.List(),
+		answers:    gen.answers,
+		owner:      gen.owner,
+		finishedAt: time.Now(),
+	}
+}
+
+// Cancel stops a running generation of an owner. When the generation is
+// not running, it returns false along with its last known status, which is
+// unknown for the generations of other owners, so that their IDs cannot be
+// probed.
+func (r *generationRegistry) Cancel(reportID, owner string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	gen, ok := r.running[reportID]
+	if !ok || owner == "" || gen.owner != owner {
+		if finished, ok := r.finished[reportID]; ok && owner != "" && finished.owner == owner {
+			return finished.status, false
+		}
+		return generationUnknown, false
+	}
+
+	gen.mu.Lock()
+	gen.cancelled = true
+	gen.mu.Unlock()
+	gen.cancel()
+
+	return generationCancelled, true
+}
+
+// pruneLocked forgets finished generations past their retention period
+func (r *generationRegistry) pruneLocked() {
+	for id, finished := range r.finished {
+		if time.Since(finished.finishedAt) > generationStatusRetention {
+			delete(r.finished, id)
+		}
+	}
+}
+
+// cancelGenerationHandler cancels an in-flight streaming generation, along
+// with its upstream request, e.g. when the user stops it. Only the client
+// the generation was started for can cancel it, the others getting a 404.
+func cancelGenerationHandler(c *gin.Context) {
+	reportID := c.Param("report_id")
+
+	status, ok := generations.Cancel(reportID, ownerFrom(c.Request.Context()))
+	if status == generationUnknown {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("generation %s not found", reportID)})
+		return
+	}
+	if !ok {
+		c.JSON(409, gin.H{
+			"error":     "Generation is not running",
+			"report_id": reportID,
+			"status":    status,
+		})
+		return
+	}
+
+	log.Printf("🛑 Cancellation requested for generation %s", reportID)
+	c.JSON(200, gin.H{
+		"report_id": reportID,
+		"status":    status,
+	})
+}
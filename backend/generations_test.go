@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// runningGeneration waits for a generation of an owner to start, and
+// returns its report ID
+func runningGeneration(t *testing.T, owner string) string {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		generations.mu.Lock()
+		for reportID, gen := range generations.running {
+			if gen.owner == owner {
+				generations.mu.Unlock()
+				return reportID
+			}
+		}
+		generations.mu.Unlock()
+	}
+	t.Fatal("the generation did not start")
+	return ""
+}
+
+// TestCancelGeneration makes sure only the client a generation was started
+// for can cancel it, and that its partial analysis is stored as cancelled
+func TestCancelGeneration(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.Latency = time.Second
+	store := &fileReportStore{dir: t.TempDir()}
+	useReportStore(t, store)
+
+	req := httptest.NewRequest("POST", "/analyze-stream", bytes.NewReader(answeredAssessment(t, "TestCancelGeneration")))
+	req.Header.Set("X-Client-Token", "owner")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newRouter().ServeHTTP(w, req)
+	}()
+	reportID := runningGeneration(t, reportOwner("owner"))
+
+	for _, token := range []string{"other", ""} {
+		if cancelled := serve(t, "DELETE", "/analyze/"+reportID, nil, map[string]string{"X-Client-Token": token}); cancelled.Code != 404 {
+			t.Errorf("client %q cancelling the generation got status %d instead of 404: %s", token, cancelled.Code, cancelled.Body.String())
+		}
+	}
+	response := decodeResponse(t, serve(t, "POST", "/generations/"+reportID+"/cancel", nil, map[string]string{"X-Client-Token": "owner"}), 200)
+	if response["status"] != generationCancelled {
+		t.Errorf("unexpected cancellation status %v", response["status"])
+	}
+	<-done
+
+	events := readEvents(t, w.Body)
+	if len(events) == 0 || events[len(events)-1].Name != "cancelled" {
+		t.Fatalf("the stream did not end with a cancelled event: %+v", events)
+	}
+	report, err := store.Get(context.Background(), reportID)
+	if err != nil {
+		t.Fatalf("the cancelled analysis was not stored: %v", err)
+	}
+	if report.Status != generationCancelled {
+		t.Errorf("the cancelled analysis was stored as %q", report.Status)
+	}
+
+	response = decodeResponse(t, serve(t, "DELETE", "/analyze/"+reportID, nil, map[string]string{"X-Client-Token": "owner"}), 409)
+	if response["status"] != generationCancelled {
+		t.Errorf("the finished generation is reported as %v", response["status"])
+	}
+	if cancelled := serve(t, "DELETE", "/analyze/"+reportID, nil, map[string]string{"X-Client-Token": "other"}); cancelled.Code != 404 {
+		t.Errorf("the status of the generation was reported to another client: status %d", cancelled.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Markers around each comment in the assessment given to the model, which
+// the prompts describe as data
+const (
+	commentOpenMarker  = "<participant_comment>"
+	commentCloseMarker = "</participant_comment>"
+)
+
+// Replacement of instruction-like text in participant text
+const removedInstructions = "[removed]"
+
+// instructionPatterns match text written to steer the model rather than to
+// describe the participant, in the supported languages. They are kept
+// narrow on purpose: comments about masking, such as "I pretend to be
+// someone else at work", are the data the analysis is about.
+var instructionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override|bypass)\s+(all\s+|any\s+|every\s+)?(of\s+)?(the\s+|your\s+|these\s+|those\s+)?(previous|prior|above|earlier|preceding|former|system|original)\s+(instructions?|prompts?|rules|directions|guidelines)`),
+	regexp.MustCompile(`(?i)\b(new|updated|real)\s+instructions?\s*:`),
+	regexp.MustCompile(`(?i)\b(reveal|print|repeat|show)\s+(me\s+)?(your|the)\s+(system\s+)?prompt\b`),
+	regexp.MustCompile(`(?im)^\s*(system|assistant|user|human)\s*:`),
+	regexp.MustCompile(`(?i)</?\s*(system|assistant|user|human|instructions?|prompt|participant_comment)\s*>`),
+	regexp.MustCompile(`(?i)\bignore[sz]?\s+(toutes\s+)?les\s+instructions\s+(précédentes|ci-dessus)`),
+	regexp.MustCompile(`(?i)\bignora\s+(todas\s+)?las\s+instrucciones\s+(anteriores|previas)`),
+	regexp.MustCompile(`(?i)\bignora\s+(tutte\s+)?le\s+istruzioni\s+(precedenti|sopra)`),
+	regexp.MustCompile(`(?i)\bignoriere?\s+(alle\s+)?(vorherigen|obigen|bisherigen)\s+anweisungen`),
+	regexp.MustCompile(`(?i)игнорируй(те)?\s+(все\s+)?(предыдущие|прошлые)\s+инструкции`),
+}
+
+// neutralizeInstructions replaces instruction-like text, and returns how
+// many passages were replaced
+func neutralizeInstructions(text string) (string, int) {
+	replaced := 0
+	for _, pattern := range instructionPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(string) string {
+			replaced++
+			return removedInstructions
+		})
+	}
+	return text, replaced
+}
+
+// promptSafeAssessment returns a copy of an assessment for the model, with
+// instruction-like text removed from its comments and every comment
+// wrapped in the comment markers. Exports keep the comments as written.
+func promptSafeAssessment(ctx context.Context, data AssessmentData) AssessmentData {
+	data.QuestionsAndAnswers = append([]QuestionAndAnswer(nil), data.QuestionsAndAnswers...)
+	for i, qa := range data.QuestionsAndAnswers {
+		if qa.Comment == nil || strings.TrimSpace(*qa.Comment) == "" {
+			continue
+		}
+		comment, replaced := neutralizeInstructions(*qa.Comment)
+		if replaced > 0 {
+			warningsFrom(ctx).Add(Warning{
+				Code:       warnInstructionsRemoved,
+				Message:    fmt.Sprintf("instruction-like text removed from the comment for question %d before analysis", qa.ID),
+				QuestionID: qa.ID,
+			})
+		}
+		wrapped := commentOpenMarker + comment + commentCloseMarker
+		data.QuestionsAndAnswers[i].Comment = &wrapped
+	}
+	return data
+}
+
+// promptSafeContext returns the participant-provided context for the
+// prompt, without instruction-like text nor the quotes delimiting it
+func promptSafeContext(text string) string {
+	text, _ = neutralizeInstructions(text)
+	for strings.Contains(text, `"""`) {
+		text = strings.ReplaceAll(text, `"""`, `"`)
+	}
+	return text
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Question catalogs of the instruments other than the RAADS-R, in
+// catalogs/<instrument>/<language>.json
+//
+//go:embed catalogs/aq-50/*.json catalogs/eq-sq/*.json catalogs/rbq-2a/*.json
+var instrumentCatalogFS embed.FS
+
+// Instruments an assessment may be taken with, RAADS-R when none is given
+const (
+	instrumentRAADSR = "raads-r"
+	instrumentAQ50   = "aq-50"
+	instrumentEQSQ   = "eq-sq"
+	instrumentRBQ2A  = "rbq-2a"
+)
+
+var instrumentKeys = []string{instrumentRAADSR, instrumentAQ50, instrumentEQSQ, instrumentRBQ2A}
+
+// Instrument is a questionnaire the backend scores and analyzes: its answer
+// scale and catalogs, how its answers are scored and interpreted, and the
+// prompt template of its analysis
+type Instrument struct {
+	Key       string
+	Test      TestDefinition
+	MaxTotal  int
+	Threshold int
+	Rules     []interpretationRule
+	// Subscales of the instruments without RAADS-R domains
+	Subscales []Subscale
+	// Highest score of an item
+	ItemMax int
+	// Whether subscale scores are read as mean item scores
+	MeanScores bool
+	// Prompt template of the full analysis, in every prompt version that
+	// supports the instrument
+	AnalysisTemplate string
+
+	Catalog   func(language string) (*LanguageCatalog, error)
+	ItemScore func(q CatalogQuestion, answer int) int
+	Scores    func(qas []QuestionAndAnswer) Scores
+	// Interpretation key of the scores, from Rules on the total when nil
+	Classify func(scores Scores) string
+	// Fields of the score and analysis responses specific to the
+	// instrument, such as subscale scores, nil for the RAADS-R whose
+	// domains have their own fields
+	Details func(data AssessmentData) gin.H
+	// Report of the template provider, when no model is available
+	TemplateMarkdown func(data AssessmentData) (string, error)
+}
+
+// Subscale is a group of items of an instrument, scored together
+type Subscale struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Items    int    `json:"items"`
+}
+
+// SubscaleScore is the score of an assessment on a subscale
+type SubscaleScore struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Score int    `json:"score"`
+	Max   int    `json:"max"`
+	// Mean score of the answered items, for instruments read that way
+	Mean float64 `json:"mean,omitempty"`
+}
+
+// instrumentOf returns the instrument of an assessment. Thresholds and
+// rules are read on each call, as the normative dataset and
+// CLINICAL_CONFIG_FILE may change them.
+func instrumentOf(data AssessmentData) Instrument {
+	switch data.Instrument {
+	case instrumentAQ50:
+		return aq50Instrument()
+	case instrumentEQSQ:
+		return eqsqInstrument()
+	case instrumentRBQ2A:
+		return rbq2aInstrument()
+	}
+	return Instrument{
+		Key:              instrumentRAADSR,
+		Test:             raadsR,
+		MaxTotal:         raadsMaxTotal,
+		Threshold:        totalThreshold,
+		Rules:            interpretationRules,
+		ItemMax:          3,
+		AnalysisTemplate: "analysis.tmpl",
+		Catalog:          catalogFor,
+		ItemScore:        func(q CatalogQuestion, answer int) int { return itemScore(q.Reverse, answer) },
+		Scores:           scoresFromItems,
+		TemplateMarkdown: templateMarkdown,
+	}
+}
+
+// Interpretation returns the localized interpretation of scores
+func (i Instrument) Interpretation(catalog *LanguageCatalog, scores Scores) Interpretation {
+	if i.Classify == nil {
+		return catalog.interpretationWith(i.Rules, scores.Total)
+	}
+	key := i.Classify(scores)
+	text := catalog.Interpretations[key]
+	return Interpretation{Level: text.Level, Description: text.Description, Severity: key}
+}
+
+// validateInstrument checks the instrument of an assessment, and that it
+// does not ask for features only built for the RAADS-R: partial retakes,
+// percentile ranks and reference profiles all rely on RAADS-R data
+func validateInstrument(data AssessmentData) error {
+	switch data.Instrument {
+	case "", instrumentRAADSR:
+		return nil
+	case instrumentAQ50, instrumentEQSQ, instrumentRBQ2A:
+	default:
+		return fmt.Errorf("unknown instrument %q (available: %s)", data.Instrument, strings.Join(instrumentKeys, ", "))
+	}
+
+	name := instrumentOf(data).Test.Name
+	switch {
+	case data.BaseReportID != "" || data.BaseAssessmentHash != "":
+		return fmt.Errorf("partial retakes are not available for the %s", name)
+	case data.Demographics != nil:
+		return fmt.Errorf("percentile ranks are not available for the %s, demographics must be left out", name)
+	case data.ReferenceProfile != "" && data.ReferenceProfile != normativeDataset.DefaultProfile:
+		return fmt.Errorf("reference profiles are not available for the %s", name)
+	}
+	return nil
+}
+
+// requireRAADSR rejects the assessments of other instruments from features
+// only built for the RAADS-R domains, such as charts and domain reports
+func requireRAADSR(data AssessmentData, feature string) error {
+	if instrument := instrumentOf(data); instrument.Key != instrumentRAADSR {
+		return fmt.Errorf("%s is only available for the RAADS-R, not the %s", feature, instrument.Test.Name)
+	}
+	return nil
+}
+
+// instrumentScoreResponse is the response of POST /score for instruments
+// without RAADS-R domains, contributions and charts
+func instrumentScoreResponse(ctx context.Context, data AssessmentData) gin.H {
+	instrument := instrumentOf(data)
+	total := gin.H{"score": data.Scores.Total, "max": data.Scores.MaxTotal}
+	if instrument.Threshold != 0 {
+		total["threshold"] = instrument.Threshold
+		total["over_threshold"] = data.Scores.Total >= instrument.Threshold
+	}
+	response := gin.H{
+		"instrument":         instrument.Key,
+		"total":              total,
+		"scores":             data.Scores,
+		"interpretation":     data.Interpretation,
+		"answered_questions": data.Metadata.AnsweredQuestions,
+	}
+	for key, value := range instrument.Details(data) {
+		response[key] = value
+	}
+	if flags := validityFlags(data); len(flags) > 0 {
+		response["validity"] = flags
+	}
+	if warnings := warningsFrom(ctx).List(); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	return response
+}
+
+// instrumentCatalogs are the catalogs of an instrument other than the
+// RAADS-R, loaded on first use
+type instrumentCatalogs struct {
+	once      sync.Once
+	err       error
+	languages map[string]*LanguageCatalog
+}
+
+// get returns the catalog of a language. The catalogs of every language of
+// the answer scale are loaded on first use, and checked by validate.
+func (c *instrumentCatalogs) get(key string, test TestDefinition, language string, validate func(code string, catalog *LanguageCatalog) error) (*LanguageCatalog, error) {
+	c.once.Do(func() {
+		c.languages = make(map[string]*LanguageCatalog)
+		for code := range test.ScaleLabels {
+			content, err := instrumentCatalogFS.ReadFile("catalogs/" + key + "/" + code + ".json")
+			if err != nil {
+				c.err = fmt.Errorf("missing %s catalog for %s: %w", test.Name, code, err)
+				return
+			}
+			var catalog LanguageCatalog
+			if err := json.Unmarshal(content, &catalog); err != nil {
+				c.err = fmt.Errorf("invalid %s catalog for %s: %w", test.Name, code, err)
+				return
+			}
+			catalog.byID = make(map[int]CatalogQuestion, len(catalog.Questions))
+			for _, q := range catalog.Questions {
+				catalog.byID[q.ID] = q
+			}
+			if err := validate(code, &catalog); err != nil {
+				c.err = err
+				return
+			}
+			c.languages[code] = &catalog
+		}
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	catalog, ok := c.languages[language]
+	if !ok {
+		return nil, fmt.Errorf("the %s is not available in %s", test.Name, language)
+	}
+	return catalog, nil
+}
+
+// subscaleScores sums the item scores of each subscale of the instrument of
+// an assessment, labeled in its language
+func subscaleScores(data AssessmentData) []SubscaleScore {
+	instrument := instrumentOf(data)
+	totals, answered := map[string]int{}, map[string]int{}
+	for _, qa := range data.QuestionsAndAnswers {
+		totals[qa.Category] += qa.Score
+		if qa.AnswerText != "" {
+			answered[qa.Category]++
+		}
+	}
+	var labels map[string]string
+	if catalog, err := instrument.Catalog(data.Language); err == nil {
+		labels = catalog.Labels.Domains
+	}
+
+	scores := make([]SubscaleScore, 0, len(instrument.Subscales))
+	for _, s := range instrument.Subscales {
+		score := SubscaleScore{Key: s.Key, Name: s.Name, Label: labels[s.Key], Score: totals[s.Category], Max: s.Items * instrument.ItemMax}
+		if score.Label == "" {
+			score.Label = s.Name
+		}
+		if instrument.MeanScores && answered[s.Category] > 0 {
+			score.Mean = math.Round(float64(score.Score)/float64(answered[s.Category])*100) / 100
+		}
+		scores = append(scores, score)
+	}
+	return scores
+}
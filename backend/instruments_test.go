@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uniformAssessment answers every question of an instrument the same way
+func uniformAssessment(t *testing.T, instrument Instrument, answer int) AssessmentData {
+	t.Helper()
+	catalog, err := instrument.Catalog("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := make([]SubmittedAnswer, 0, len(catalog.Questions))
+	for _, q := range catalog.Questions {
+		answer := answer
+		answers = append(answers, SubmittedAnswer{ID: q.ID, Answer: &answer})
+	}
+	now := time.Now()
+	return AssessmentData{Language: "en", Instrument: instrument.Key, TestDate: &now, Answers: answers}
+}
+
+// TestInstrumentScoring scores uniform answers to each instrument, and
+// makes sure their prompts state how the scores are read
+func TestInstrumentScoring(t *testing.T) {
+	tests := []struct {
+		instrument Instrument
+		answer     int
+		total      int
+		severity   string
+		prompt     string
+	}{
+		// Definitely agreeing with everything scores the 24 agreement items
+		{aq50Instrument(), 0, 24, matchInterpretationRule(aq50InterpretationRules, 24).Key, fmt.Sprintf("cut-off: %d", aq50Threshold)},
+		// Marked answers to every item reach the highest total and means
+		{rbq2aInstrument(), 2, rbq2aMaxTotal, "many", "mean item score: 3.00"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.instrument.Key, func(t *testing.T) {
+			data := uniformAssessment(t, tc.instrument, tc.answer)
+			if err := validateAssessmentData(context.Background(), &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Scores.Total != tc.total || data.Interpretation.Severity != tc.severity {
+				t.Errorf("scored %d (%s) instead of %d (%s)", data.Scores.Total, data.Interpretation.Severity, tc.total, tc.severity)
+			}
+			prompt, err := analysisPrompt(data, assessmentInput{Mode: inputModeInline, Inline: "{}"}, promptVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(prompt, tc.prompt) {
+				t.Errorf("the version %d prompt does not state %q", promptVersion, tc.prompt)
+			}
+			if len(requiredOutline(prompt)) == 0 {
+				t.Errorf("the version %d prompt has no report structure", promptVersion)
+			}
+		})
+	}
+}
+
+func TestInterpretationRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []interpretationRule
+		maxTotal int
+	}{
+		{"RAADS-R", interpretationRules, raadsMaxTotal},
+		{"AQ-50", aq50InterpretationRules, aq50MaxTotal},
+		{"RBQ-2A", rbq2aInterpretationRules, rbq2aMaxTotal},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateInterpretationRules(tc.rules, tc.maxTotal); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
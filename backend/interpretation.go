@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// interpretationRule maps a range of total scores, bounds included, to the
+// key of its interpretation texts in the language catalogs
+type interpretationRule struct {
+	Key string `json:"key"`
+	Min int    `json:"min"`
+	Max int    `json:"max"`
+}
+
+// Interpretation rules, ordered and covering every total score. The
+// "possible" rule starts at the clinical threshold and follows it when the
+// normative dataset or CLINICAL_CONFIG_FILE changes it.
+var interpretationRules = []interpretationRule{
+	{"none", 0, 24},
+	{"light", 25, 49},
+	{"moderate", 50, 64},
+	{"possible", 65, 89},
+	{"strong", 90, 129},
+	{"solid", 130, 159},
+	{"veryStrong", 160, raadsMaxTotal},
+}
+
+// interpretationRuleFor returns the rule of a total score, the closest one
+// for scores out of range
+func interpretationRuleFor(total int) interpretationRule {
+	return matchInterpretationRule(interpretationRules, total)
+}
+
+// matchInterpretationRule is interpretationRuleFor with the rules of any
+// instrument
+func matchInterpretationRule(rules []interpretationRule, total int) interpretationRule {
+	for _, rule := range rules {
+		if total <= rule.Max {
+			return rule
+		}
+	}
+	return rules[len(rules)-1]
+}
+
+// Interpretation returns the localized interpretation of a total score.
+// Submitted interpretations are always replaced by it.
+func (l *LanguageCatalog) Interpretation(total int) Interpretation {
+	return l.interpretationWith(interpretationRules, total)
+}
+
+// interpretationWith is Interpretation with the rules of any instrument
+func (l *LanguageCatalog) interpretationWith(rules []interpretationRule, total int) Interpretation {
+	key := matchInterpretationRule(rules, total).Key
+	text := l.Interpretations[key]
+	return Interpretation{Level: text.Level, Description: text.Description, Severity: key}
+}
+
+// moveInterpretationBoundary makes the rule starting at a score start at
+// another one instead, shrinking or growing the rule before it
+func moveInterpretationBoundary(from, to int) {
+	for i := 1; i < len(interpretationRules); i++ {
+		if interpretationRules[i].Min == from {
+			interpretationRules[i].Min = to
+			interpretationRules[i-1].Max = to - 1
+			return
+		}
+	}
+}
+
+// validateInterpretationRules checks that the rules follow each other
+// without gaps, from 0 to the maximum total score
+func validateInterpretationRules(rules []interpretationRule, maxTotal int) error {
+	next := 0
+	for _, rule := range rules {
+		if rule.Min != next {
+			return fmt.Errorf("interpretation %q starts at %d instead of %d", rule.Key, rule.Min, next)
+		}
+		if rule.Max < rule.Min {
+			return fmt.Errorf("interpretation %q has an empty range %d-%d", rule.Key, rule.Min, rule.Max)
+		}
+		next = rule.Max + 1
+	}
+	if next != maxTotal+1 {
+		return fmt.Errorf("interpretations end at %d instead of %d", next-1, maxTotal)
+	}
+	return nil
+}
+
+// LocalizedInterpretationRule is an interpretation rule with its texts in a
+// language, as listed by GET /questions/:lang
+type LocalizedInterpretationRule struct {
+	interpretationRule
+	InterpretationText
+}
+
+// localizedInterpretationRules lists the rules of an instrument with the
+// texts of a catalog
+func localizedInterpretationRules(catalog *LanguageCatalog, rules []interpretationRule) []LocalizedInterpretationRule {
+	localized := make([]LocalizedInterpretationRule, 0, len(rules))
+	for _, rule := range rules {
+		localized = append(localized, LocalizedInterpretationRule{rule, catalog.Interpretations[rule.Key]})
+	}
+	return localized
+}
+
+// checkInterpretationRules verifies that the rules cover every total score
+// and that the clinical threshold starts an interpretation
+func checkInterpretationRules() checkResult {
+	result := checkResult{Name: "interpretation rules", Feature: "analysis"}
+	if err := validateInterpretationRules(interpretationRules, raadsMaxTotal); err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	if rule := interpretationRuleFor(totalThreshold); rule.Min != totalThreshold {
+		result.Detail = fmt.Sprintf("the clinical threshold %d falls within the %q interpretation (%d-%d)", totalThreshold, rule.Key, rule.Min, rule.Max)
+		return result
+	}
+
+	ranges := make([]string, 0, len(interpretationRules))
+	for _, rule := range interpretationRules {
+		ranges = append(ranges, fmt.Sprintf("%s %d-%d", rule.Key, rule.Min, rule.Max))
+	}
+	result.OK = true
+	result.Detail = strings.Join(ranges, ", ")
+	return result
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Job statuses
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+// Failure classes of jobs that exhausted their attempts. Only the
+// retryable ones are requeued automatically once the Claude API recovers.
+const (
+	failureUpstreamUnavailable = "upstream_unavailable"
+	failureRateLimited         = "rate_limited"
+	failureCredentialRejected  = "credential_rejected"
+	failureTimeout             = "timeout"
+	failureInvalidRequest      = "invalid_request"
+	failureInternal            = "internal"
+)
+
+var retryableFailures = map[string]bool{
+	failureUpstreamUnavailable: true,
+	failureRateLimited:         true,
+	failureTimeout:             true,
+}
+
+var (
+	// Attempts per job before it is moved to the dead letters
+	jobMaxAttempts = envInt("JOB_MAX_ATTEMPTS", 3)
+
+	// Delay before the first retry, doubled for each following one
+	jobRetryBackoff = time.Duration(envInt("JOB_RETRY_BACKOFF_SECONDS", 5)) * time.Second
+
+	// How long finished jobs, including dead letters, are kept
+	jobRetention = time.Duration(envInt("JOB_RETENTION_HOURS", 24)) * time.Hour
+)
+
+// claudeAPIError is a non-200 response of the Claude API. RequestID is the
+// request-id Anthropic support asks for.
+type claudeAPIError struct {
+	Status    int
+	Body      string
+	RequestID string
+}
+
+func (e *claudeAPIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("claude API error %d (request-id %s): %s", e.Status, e.RequestID, e.Body)
+	}
+	return fmt.Sprintf("claude API error %d: %s", e.Status, e.Body)
+}
+
+// classifyFailure maps a generation error to a failure class
+func classifyFailure(err error) string {
+	var apiErr *claudeAPIError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.Status == http.StatusTooManyRequests:
+			return failureRateLimited
+		case failoverStatus(apiErr.Status):
+			return failureCredentialRejected
+		case apiErr.Status >= 500:
+			// Including 529, returned when the API is overloaded
+			return failureUpstreamUnavailable
+		default:
+			return failureInvalidRequest
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return failureTimeout
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return failureTimeout
+	case errors.As(err, &urlErr):
+		// The provider could not be reached
+		return failureUpstreamUnavailable
+	default:
+		return failureInternal
+	}
+}
+
+// Job is an analysis run in the background. Its payload is kept so that
+// failed jobs can be requeued, unless the client opted out of storage with
+// Cache-Control: no-store, in which case it is dropped once the job ends.
+type Job struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	MaxAttempts  int    `json:"max_attempts"`
+	FailureClass string `json:"failure_class,omitempty"`
+	Retryable    bool   `json:"retryable,omitempty"`
+	Error        string `json:"error,omitempty"`
+	NoStore      bool   `json:"no_store"`
+	Requeueable  bool   `json:"requeueable"`
+	// Why a failed job cannot be requeued
+	RequeueUnavailable string    `json:"requeue_unavailable,omitempty"`
+	Markdown           string    `json:"markdown,omitempty"`
+	PromptVersion      int       `json:"prompt_version,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+
+	payload    *AssessmentData
+	credential *claudeCredential
+}
+
+// jobStore keeps jobs in memory until their retention period is over
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+var jobs = &jobStore{jobs: make(map[string]*Job)}
+
+// Submit records a new job and starts it
+func (s *jobStore) Submit(data AssessmentData, credential *claudeCredential, noStore bool) Job {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:          uuid.New().String(),
+		Status:      jobQueued,
+		MaxAttempts: max(jobMaxAttempts, 1),
+		NoStore:     noStore,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		payload:     &data,
+		credential:  credential,
+	}
+
+	s.mu.Lock()
+	s.pruneLocked(now)
+	s.jobs[job.ID] = job
+	snapshot := s.snapshotLocked(job)
+	s.mu.Unlock()
+
+	go s.run(job)
+	return snapshot
+}
+
+// Get returns a snapshot of a job
+func (s *jobStore) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return s.snapshotLocked(job), true
+}
+
+// List returns snapshots of the jobs with the given status, or of every
+// job when status is empty, oldest first
+func (s *jobStore) List(status string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	list := []Job{}
+	for _, job := range s.jobs {
+		if status == "" || job.Status == status {
+			list = append(list, s.snapshotLocked(job))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+var (
+	errJobNotFound       = errors.New("job not found")
+	errJobCompleted      = errors.New("job already completed")
+	errJobPayloadDropped = errors.New("job payload was not stored (no-store), the client must resubmit")
+)
+
+// Requeue resets the attempts of a failed job and runs it again. Requeuing
+// a job that is already queued or running does nothing.
+func (s *jobStore) Requeue(id string) (Job, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return Job{}, errJobNotFound
+	}
+	snapshot, start, err := s.requeueLocked(job)
+	s.mu.Unlock()
+
+	if start {
+		go s.run(job)
+	}
+	return snapshot, err
+}
+
+// RequeueRetryable requeues every failed job whose failure class is
+// retryable, and returns how many were requeued. It is meant to be called
+// when the Claude API is known to have recovered.
+func (s *jobStore) RequeueRetryable() int {
+	s.mu.Lock()
+	var started []*Job
+	for _, job := range s.jobs {
+		if job.Status != jobFailed || !retryableFailures[job.FailureClass] || job.payload == nil {
+			continue
+		}
+		if _, start, _ := s.requeueLocked(job); start {
+			started = append(started, job)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, job := range started {
+		go s.run(job)
+	}
+	if len(started) > 0 {
+		log.Printf("♻️  Requeued %d failed jobs", len(started))
+	}
+	return len(started)
+}
+
+// requeueLocked resets a failed job, and reports whether it must be started
+func (s *jobStore) requeueLocked(job *Job) (Job, bool, error) {
+	switch {
+	case job.Status == jobQueued || job.Status == jobRunning:
+		return s.snapshotLocked(job), false, nil
+	case job.Status == jobCompleted:
+		return s.snapshotLocked(job), false, errJobCompleted
+	case job.payload == nil:
+		return s.snapshotLocked(job), false, errJobPayloadDropped
+	}
+
+	job.Status = jobQueued
+	job.Attempts = 0
+	job.FailureClass = ""
+	job.Retryable = false
+	job.Error = ""
+	job.UpdatedAt = time.Now().UTC()
+	return s.snapshotLocked(job), true, nil
+}
+
+// run executes a job until it completes or exhausts its attempts
+func (s *jobStore) run(job *Job) {
+	s.mu.Lock()
+	data := *job.payload
+	credential := job.credential
+	s.mu.Unlock()
+
+	ctx := withRequestedModel(context.WithValue(context.Background(), credentialKey{}, credential), data)
+	ctx = context.WithValue(ctx, warningsKey{}, &WarningCollector{})
+	ctx = withReportUsage(ctx, job.ID)
+	version := assessmentPromptVersion(data)
+
+	for {
+		markdown, err := s.attempt(ctx, job, data, version)
+
+		s.mu.Lock()
+		job.UpdatedAt = time.Now().UTC()
+		if err == nil {
+			job.Status = jobCompleted
+			job.Markdown = markdown
+			job.PromptVersion = version
+			job.payload = nil
+			s.mu.Unlock()
+			log.Printf("✅ Job %s completed after %d attempts", job.ID, job.Attempts)
+			return
+		}
+
+		job.FailureClass = classifyFailure(err)
+		job.Retryable = retryableFailures[job.FailureClass]
+		job.Error = err.Error()
+		if !job.Retryable || job.Attempts >= job.MaxAttempts {
+			job.Status = jobFailed
+			if job.NoStore {
+				job.payload = nil
+			}
+			s.mu.Unlock()
+			log.Printf("💀 Job %s failed after %d attempts (%s): %v", job.ID, job.Attempts, job.FailureClass, err)
+			return
+		}
+		backoff := jobRetryBackoff << (job.Attempts - 1)
+		job.Status = jobQueued
+		s.mu.Unlock()
+
+		log.Printf("⚠️  Job %s attempt %d failed (%s), retrying in %s: %v", job.ID, job.Attempts, job.FailureClass, backoff, err)
+		time.Sleep(backoff)
+	}
+}
+
+// attempt runs one generation of a job, in a batch worker slot
+func (s *jobStore) attempt(ctx context.Context, job *Job, data AssessmentData, version int) (string, error) {
+	slot, err := workers.Acquire(ctx, classBatch)
+	if err != nil {
+		s.mu.Lock()
+		job.Attempts++
+		s.mu.Unlock()
+		return "", &claudeAPIError{Status: http.StatusServiceUnavailable, Body: err.Error()}
+	}
+
+	s.mu.Lock()
+	job.Status = jobRunning
+	job.Attempts++
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, generationMaxDuration)
+	defer cancel()
+
+	input, err := prepareAssessmentInput(ctx, data)
+	if err != nil {
+		slot.Release(false)
+		return "", err
+	}
+	markdown, err := generateMarkdownReportWithClaude(ctx, data, input, version)
+	slot.Release(err == nil)
+	return markdown, err
+}
+
+// snapshotLocked copies a job for use outside the store
+func (s *jobStore) snapshotLocked(job *Job) Job {
+	snapshot := *job
+	snapshot.Requeueable = job.Status == jobFailed && job.payload != nil
+	if job.Status == jobFailed && job.payload == nil {
+		snapshot.RequeueUnavailable = errJobPayloadDropped.Error()
+	}
+	snapshot.payload = nil
+	snapshot.credential = nil
+	return snapshot
+}
+
+// pruneLocked forgets finished jobs past their retention period
+func (s *jobStore) pruneLocked(now time.Time) {
+	for id, job := range s.jobs {
+		finished := job.Status == jobCompleted || job.Status == jobFailed
+		if finished && now.Sub(job.UpdatedAt) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// submitJobHandler starts an analysis in the background and returns its ID
+func submitJobHandler(c *gin.Context) {
+	var data AssessmentData
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := requestModel(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid model selection: %v", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	noStore := strings.Contains(c.GetHeader("Cache-Control"), "no-store")
+	job := jobs.Submit(data, credentialFrom(c.Request.Context()), noStore)
+	log.Printf("📥 Job %s submitted (no-store: %t)", job.ID, noStore)
+	c.JSON(202, job)
+}
+
+// jobHandler returns the status, and once completed the analysis, of a job
+func jobHandler(c *gin.Context) {
+	job, ok := jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(200, job)
+}
+
+// adminJobsHandler lists jobs, typically the dead letters with
+// GET /admin/jobs?status=failed
+func adminJobsHandler(c *gin.Context) {
+	list := jobs.List(c.Query("status"))
+	c.JSON(200, gin.H{"jobs": list, "count": len(list)})
+}
+
+// adminRequeueJobHandler requeues a failed job
+func adminRequeueJobHandler(c *gin.Context) {
+	job, err := jobs.Requeue(c.Param("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(404, gin.H{"error": "Job not found"})
+	case err != nil:
+		c.JSON(409, gin.H{"error": err.Error(), "job": job})
+	default:
+		log.Printf("♻️  Job %s requeued by an admin", job.ID)
+		c.JSON(200, job)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+//go:embed templates/report.tex
+var latexTemplateFS embed.FS
+
+// The LaTeX report template is parsed once, from report.tex in
+// LATEX_TEMPLATE_DIR when it is set, so that a deployment can adapt the
+// layout without rebuilding, and from the embedded one otherwise, or when
+// the one on disk does not parse. It uses << >> delimiters so that
+// template actions never clash with LaTeX braces.
+var (
+	latexTemplateDir                      = os.Getenv("LATEX_TEMPLATE_DIR")
+	latexReportTemplate, latexTemplateErr = parseLaTeXTemplate()
+)
+
+// parseLaTeXTemplate parses the LaTeX report template, falling back to the
+// embedded one with the error of the one on disk
+func parseLaTeXTemplate() (*template.Template, error) {
+	embedded := template.Must(newLaTeXTemplate().ParseFS(latexTemplateFS, "templates/report.tex"))
+	if latexTemplateDir == "" {
+		return embedded, nil
+	}
+	custom, err := newLaTeXTemplate().ParseFiles(filepath.Join(latexTemplateDir, "report.tex"))
+	if err != nil {
+		return embedded, fmt.Errorf("failed to parse LaTeX template of LATEX_TEMPLATE_DIR: %w", err)
+	}
+	return custom, nil
+}
+
+func newLaTeXTemplate() *template.Template {
+	return template.New("report.tex").
+		Delims("<<", ">>").
+		Funcs(template.FuncMap{
+			"latex": latexEscape,
+			// #rrggbb brand colors as xcolor HTML colors
+			"htmlcolor": func(color string) string { return strings.ToUpper(strings.TrimPrefix(color, "#")) },
+		})
+}
+
+// Babel language names for the supported languages
+var babelLanguages = map[string]string{
+	"en": "english",
+	"fr": "french",
+	"es": "spanish",
+	"it": "italian",
+	"de": "ngerman",
+	"ru": "russian",
+}
+
+// LaTeXLabels are the static texts of the LaTeX report
+type LaTeXLabels struct {
+	ReportTitle    string
+	TestName       string
+	TestFullName   string
+	Participant    string
+	Age            string
+	Gender         string
+	Profession     string
+	EvaluationDate string
+	ScoreSummary   string
+	Domain         string
+	Instrument     string
+	Interpretation string
+	Score          string
+	Threshold      string
+	NTAverage      string
+	Maximum        string
+	Appendix       string
+	Context        string
+	Percentiles    string
+	ComparedWith   string
+	Footer         string
+	Version        string
+	Verify         string
+}
+
+var defaultLaTeXLabels = LaTeXLabels{
+	ReportTitle:    "ASSESSMENT REPORT",
+	TestName:       "RAADS-R Test",
+	TestFullName:   "Ritvo Autism Asperger Diagnostic Scale - Revised",
+	Participant:    "Participant:",
+	Age:            "Age:",
+	Gender:         "Gender:",
+	Profession:     "Profession:",
+	EvaluationDate: "Evaluation Date:",
+	ScoreSummary:   "Score Summary",
+	Domain:         "Domain",
+	Instrument:     "Instrument",
+	Interpretation: "Interpretation",
+	Score:          "Your Score",
+	Threshold:      "Clinical Threshold",
+	NTAverage:      "Neurotypical Avg",
+	Maximum:        "Maximum",
+	Appendix:       "Complete Assessment Responses",
+	Context:        participantContextTitles["en"],
+	Percentiles:    "Percentile ranks",
+	ComparedWith:   "Compared with",
+	Footer:         "Report compiled using Claude AI on",
+	Version:        "Generated with analysis version",
+	Verify:         "Scan or visit to verify this report:",
+}
+
+// localizedLaTeXLabels returns the labels of the report in the language of
+// an assessment, and its date written in that language. Languages without a
+// catalog keep the English labels and ISO dates.
+func localizedLaTeXLabels(data AssessmentData) (LaTeXLabels, string) {
+	labels := defaultLaTeXLabels
+	labels.Context = participantContextTitle(data.Language)
+	date := data.Metadata.LocalTestDate()
+	catalog, err := catalogFor(data.Language)
+	if err != nil {
+		return labels, date.Format("2006-01-02")
+	}
+	report := catalog.Report
+	labels.ReportTitle = report.Title
+	labels.TestName = report.TestName
+	labels.TestFullName = report.TestFullName
+	labels.Participant = report.Participant
+	labels.Age = report.Age
+	labels.Gender = report.Gender
+	labels.Profession = report.Profession
+	labels.EvaluationDate = report.EvaluationDate
+	labels.ScoreSummary = report.ScoreSummary
+	labels.Domain = report.Domain
+	labels.Instrument = report.Instrument
+	labels.Interpretation = report.Interpretation
+	labels.Score = catalog.Labels.Score
+	labels.Threshold = catalog.Labels.Threshold
+	labels.Maximum = catalog.Labels.Maximum
+	labels.Appendix = report.Appendix
+	labels.Percentiles = report.Percentiles
+	labels.ComparedWith = report.ComparedWith
+	labels.Footer = report.Footer
+	labels.Version = report.Version
+	labels.Verify = report.Verify
+	return labels, report.FormatDate(date)
+}
+
+// Participant holds the optional identifying details shown on the title page
+type Participant struct {
+	Name       string `json:"name"`
+	Age        string `json:"age"`
+	Gender     string `json:"gender"`
+	Profession string `json:"profession"`
+}
+
+// LaTeXScoreRow is one line of the score table and chart
+type LaTeXScoreRow struct {
+	Name      string
+	Score     int
+	Max       int
+	Threshold int
+	Reference float64
+}
+
+// LaTeXPercentileRow is the percentile ranks of the scores in the sample
+// of a group, domains in the order of the score table
+type LaTeXPercentileRow struct {
+	Label   string
+	Domains []int
+	Total   int
+}
+
+// LaTeXAppendixItem is one answered question in the appendix
+type LaTeXAppendixItem struct {
+	ID       int
+	Question string
+	Answer   string
+	Comment  string
+}
+
+// LaTeXInstrumentRow is one instrument of the score table of a composite
+// report
+type LaTeXInstrumentRow struct {
+	Name           string
+	Score          int
+	Max            int
+	Interpretation string
+}
+
+// LaTeXAppendixSection is the answers to one instrument of a composite report
+type LaTeXAppendixSection struct {
+	Title string
+	Items []LaTeXAppendixItem
+}
+
+// LaTeXReportData holds every dynamic value of the LaTeX report
+type LaTeXReportData struct {
+	Babel                     string
+	Labels                    LaTeXLabels
+	Participant               Participant
+	EvaluationDate            string
+	Total                     LaTeXScoreRow
+	Domains                   []LaTeXScoreRow
+	InterpretationLevel       string
+	InterpretationDescription string
+	// Source of the reference means, cited in the footer
+	ReferenceSource string
+	// Percentile ranks when demographics were given, with their method and
+	// the sources of the samples
+	Percentiles       []LaTeXPercentileRow
+	PercentileMethod  string
+	PercentileSources []string
+	// Changelog entry the analysis was generated with, on every page
+	AnalysisVersion string
+	// Analysis is already LaTeX and is inserted verbatim
+	Analysis string
+	// Participant-provided context, shown before the answers
+	AdditionalContext string
+	Appendix          []LaTeXAppendixItem
+	// Instruments of a composite report, shown in place of the domains,
+	// and their answers in one appendix section each
+	Instruments      []LaTeXInstrumentRow
+	AppendixSections []LaTeXAppendixSection
+	// Signed link to the verification page, shown as a QR code on the
+	// title page when reports are signed
+	Verification *ReportVerification
+	// Text stamped diagonally across every page, if any
+	Watermark string
+	// Logo, colors, header and footer texts and contact details of the
+	// deployment or tenant, with the colors of the theme
+	Branding Branding
+	Theme    ReportTheme
+}
+
+// newLaTeXReportData assembles the template values from an assessment, with
+// thresholds taken from the scoring domains and means from the selected
+// reference profile
+func newLaTeXReportData(data AssessmentData, participant Participant, analysis string) (LaTeXReportData, error) {
+	babel, ok := babelLanguages[data.Language]
+	if !ok {
+		babel = babelLanguages["en"]
+	}
+
+	profile, err := referenceProfileFor(data.ReferenceProfile)
+	if err != nil {
+		return LaTeXReportData{}, err
+	}
+	labels, evaluationDate := localizedLaTeXLabels(data)
+	labels.NTAverage = profile.Label
+
+	// Domain names in the language of the assessment, in English otherwise
+	domainName := func(key, name string) string {
+		if catalog, err := catalogFor(data.Language); err == nil && catalog.Labels.Domains[key] != "" {
+			return catalog.Labels.Domains[key]
+		}
+		return name
+	}
+	totals := domainTotals(data)
+	domains := make([]LaTeXScoreRow, 0, len(raadsDomains))
+	for _, d := range raadsDomains {
+		domains = append(domains, LaTeXScoreRow{
+			Name:      domainName(d.Key, d.Name),
+			Score:     totals[d.Key],
+			Max:       d.MaxScore(),
+			Threshold: d.Threshold,
+			Reference: profile.Mean(d.Key),
+		})
+	}
+
+	report := LaTeXReportData{
+		Babel:          babel,
+		Labels:         labels,
+		Participant:    participant,
+		EvaluationDate: evaluationDate,
+		Total: LaTeXScoreRow{
+			Name:      domainName("total", "Total"),
+			Score:     data.Scores.Total,
+			Max:       data.Scores.MaxTotal,
+			Threshold: totalThreshold,
+			Reference: profile.Mean("total"),
+		},
+		Domains:                   domains,
+		InterpretationLevel:       data.Interpretation.Level,
+		InterpretationDescription: data.Interpretation.Description,
+		ReferenceSource:           profile.Source,
+		AnalysisVersion:           currentAnalysisVersion(),
+		Analysis:                  analysis,
+		Watermark:                 reportWatermark,
+		Branding:                  brandings[defaultBrandingKey],
+		Theme:                     reportThemes[themeClinical],
+		AdditionalContext:         data.AdditionalContext,
+		Appendix:                  latexAppendix(data),
+	}
+	if percentiles := normativePercentiles(data); percentiles != nil {
+		report.PercentileMethod = percentiles.Method
+		for _, group := range percentiles.Groups {
+			row := LaTeXPercentileRow{Label: group.Label, Total: group.Total}
+			for _, d := range raadsDomains {
+				row.Domains = append(row.Domains, group.Domains[d.Key])
+			}
+			report.Percentiles = append(report.Percentiles, row)
+			if !slices.Contains(report.PercentileSources, group.Source) {
+				report.PercentileSources = append(report.PercentileSources, group.Source)
+			}
+		}
+	}
+	return report, nil
+}
+
+// latexAppendix lists the answers of an assessment for the appendix
+func latexAppendix(data AssessmentData) []LaTeXAppendixItem {
+	appendix := make([]LaTeXAppendixItem, 0, len(data.QuestionsAndAnswers))
+	for _, qa := range data.QuestionsAndAnswers {
+		item := LaTeXAppendixItem{ID: qa.ID, Question: qa.Text, Answer: qa.AnswerText}
+		if qa.Comment != nil {
+			item.Comment = *qa.Comment
+		}
+		appendix = append(appendix, item)
+	}
+	return appendix
+}
+
+// renderLaTeXReport executes the LaTeX report template
+func renderLaTeXReport(data LaTeXReportData) (string, error) {
+	var out bytes.Buffer
+	if err := latexReportTemplate.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to execute LaTeX template: %w", err)
+	}
+	return out.String(), nil
+}
+
+var latexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`$`, `\$`,
+	`&`, `\&`,
+	`#`, `\#`,
+	`^`, `\textasciicircum{}`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`%`, `\%`,
+	string(latexBreakpoint), `\allowbreak{}`,
+)
+
+// latexEscape escapes LaTeX special characters in plain text
+func latexEscape(s string) string {
+	return latexReplacer.Replace(s)
+}
+
+// markdownToLaTeX converts an analysis to LaTeX for the report. Only what the
+// prompts ask for is supported: headings, paragraphs, lists, emphasis and
+// quotes; anything else is kept as escaped text.
+func markdownToLaTeX(markdown string) string {
+	source := []byte(markdown)
+	doc, err := parseMarkdown(source)
+	if err != nil {
+		return latexEscape(markdown)
+	}
+
+	var out strings.Builder
+	write := func(entering bool, before, after string) {
+		if entering {
+			out.WriteString(before)
+		} else {
+			out.WriteString(after)
+		}
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Heading:
+			command := `\subsubsection*{`
+			switch {
+			case n.Level <= 2:
+				command = `\section*{`
+			case n.Level == 3:
+				command = `\subsection*{`
+			}
+			write(entering, command, "}\n\n")
+		case *ast.Paragraph:
+			write(entering, "", "\n\n")
+		case *ast.List:
+			env := "itemize"
+			if n.IsOrdered() {
+				env = "enumerate"
+			}
+			write(entering, `\begin{`+env+"}\n", `\end{`+env+"}\n\n")
+		case *ast.ListItem:
+			write(entering, `\item `, "\n")
+		case *ast.Blockquote:
+			write(entering, "\\begin{quote}\n", "\\end{quote}\n\n")
+		case *ast.Emphasis:
+			command := `\emph{`
+			if n.Level == 2 {
+				command = `\textbf{`
+			}
+			write(entering, command, "}")
+		case *ast.CodeSpan:
+			write(entering, `\texttt{`, "}")
+		case *ast.ThematicBreak:
+			write(entering, "\\medskip\n\n", "")
+		case *ast.String:
+			write(entering, latexEscape(string(n.Value)), "")
+		case *ast.Text:
+			if entering {
+				out.WriteString(latexEscape(string(n.Segment.Value(source))))
+				if n.HardLineBreak() {
+					out.WriteString(`\\`)
+				}
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					out.WriteString("\n")
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaTeXEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`\input{/etc/passwd}`, `\textbackslash{}input\{/etc/passwd\}`},
+		{`\immediate\write18{rm -rf ~}`, `\textbackslash{}immediate\textbackslash{}write18\{rm -rf \textasciitilde{}\}`},
+		{`}}{ unbalanced {`, `\}\}\{ unbalanced \{`},
+		{`100% & #1 ~ $x^2_i$`, `100\% \& \#1 \textasciitilde{} \$x\textasciicircum{}2\_i\$`},
+		{`\\`, `\textbackslash{}\textbackslash{}`},
+	}
+	for _, tc := range tests {
+		if escaped := latexEscape(tc.text); escaped != tc.want {
+			t.Errorf("%q escaped as %q instead of %q", tc.text, escaped, tc.want)
+		}
+	}
+}
+
+// TestLaTeXHostileReport renders a report whose participant, answers,
+// context and analysis all try to run LaTeX commands or break its groups,
+// and makes sure they are printed as text
+func TestLaTeXHostileReport(t *testing.T) {
+	hostile := []string{
+		`\input{/etc/passwd}`,
+		`\immediate\write18{curl example.com}`,
+		`}}\end{document}{`,
+		`50% & #3 ~ Jo`,
+	}
+	text := strings.Join(hostile, " ")
+	comment := text
+	sample := AssessmentData{
+		Language:          "en",
+		Metadata:          Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+		AdditionalContext: text,
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: text, AnswerText: text, Comment: &comment},
+		},
+	}
+	participant := Participant{Name: text, Age: text, Gender: text, Profession: text}
+	analysis := markdownToLaTeX("## " + text + "\n\n" + text + "\n\n- **" + text + "**\n- `" + text + "`\n")
+	data, err := newLaTeXReportData(sample, participant, analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := prepareLaTeXDocument(context.Background(), data)
+	if err != nil {
+		t.Fatalf("the report does not compile: %v", err)
+	}
+
+	for _, command := range []string{`\input{`, `\write18`, `\immediate`, `\end{document}{`} {
+		if strings.Contains(document, command) {
+			t.Errorf("the report runs %s", command)
+		}
+	}
+	// Name, age, gender, profession, context, question, answer, comment,
+	// and the heading, paragraph, bold item and code item of the analysis
+	escaped := latexEscape(text)
+	if count := strings.Count(document, escaped); count != 12 {
+		t.Errorf("the hostile text is printed %d times instead of 12", count)
+	}
+	if strings.Contains(document, "50% ") || strings.Contains(document, " & #3") {
+		t.Error("a comment or table cell is started by the hostile text")
+	}
+}
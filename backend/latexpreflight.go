@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// Scripts covered by the fonts of the LaTeX setup, beyond punctuation
+	// and symbols. Set to "latin,cyrillic" when the configured font has
+	// Cyrillic glyphs.
+	latexFontScripts = strings.Split(envString("LATEX_FONT_SCRIPTS", "latin"), ",")
+
+	// Longer unbroken runs in appendix items overflow the page margin
+	latexMaxTokenLength = envInt("LATEX_MAX_TOKEN_LENGTH", 40)
+)
+
+// latexBreakpoint marks where escaped text may break. latexEscape turns it
+// into \allowbreak, so it never clashes with the escaping itself.
+const latexBreakpoint = '\uE000'
+
+var latexScriptTables = map[string]*unicode.RangeTable{
+	"latin":    unicode.Latin,
+	"cyrillic": unicode.Cyrillic,
+	"greek":    unicode.Greek,
+}
+
+// Punctuation and symbols the LaTeX fonts are expected to provide in any setup
+var latexCommonGlyphs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x0009, Hi: 0x000a, Stride: 1},
+		{Lo: 0x000d, Hi: 0x000d, Stride: 1},
+		{Lo: 0x0020, Hi: 0x007e, Stride: 1},
+		{Lo: 0x00a0, Hi: 0x00ff, Stride: 1},
+		{Lo: 0x2010, Hi: 0x2027, Stride: 1},
+		{Lo: 0x2030, Hi: 0x203a, Stride: 1},
+		{Lo: 0x20ac, Hi: 0x20ac, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2122, Stride: 1},
+		{Lo: 0xe000, Hi: 0xe000, Stride: 1},
+	},
+}
+
+// latexCovered reports whether a rune can be typeset by the configured fonts
+func latexCovered(r rune) bool {
+	if unicode.Is(latexCommonGlyphs, r) || unicode.Is(unicode.Mn, r) {
+		return true
+	}
+	for _, script := range latexFontScripts {
+		if table, ok := latexScriptTables[strings.TrimSpace(script)]; ok && unicode.Is(table, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareLaTeXDocument runs the pre-flight checks around the template:
+// unsupported glyphs are transliterated or stripped and long appendix
+// tokens get breakpoints before rendering, and the output is checked for
+// balanced braces. Fixes are reported as warnings; an output that would
+// not compile is an error.
+func prepareLaTeXDocument(ctx context.Context, data LaTeXReportData) (string, error) {
+	fixLaTeXGlyphs(ctx, &data)
+	breakLongLaTeXTokens(ctx, data.Appendix)
+	for _, section := range data.AppendixSections {
+		breakLongLaTeXTokens(ctx, section.Items)
+	}
+
+	document, err := renderLaTeXReport(data)
+	if err != nil {
+		return "", err
+	}
+	if err := checkLaTeXBraces(document); err != nil {
+		return "", err
+	}
+	return document, nil
+}
+
+// fixLaTeXGlyphs replaces characters outside the font coverage in every
+// plain text field of the report
+func fixLaTeXGlyphs(ctx context.Context, data *LaTeXReportData) {
+	stripped := map[rune]bool{}
+	fix := func(s *string) {
+		*s = transliterateForLaTeX(*s, stripped)
+	}
+
+	fix(&data.Participant.Name)
+	fix(&data.Participant.Age)
+	fix(&data.Participant.Gender)
+	fix(&data.Participant.Profession)
+	fix(&data.InterpretationLevel)
+	fix(&data.InterpretationDescription)
+	fix(&data.Analysis)
+	fix(&data.AdditionalContext)
+	fix(&data.Branding.Organization)
+	fix(&data.Branding.ReportTitle)
+	fix(&data.Branding.Header)
+	fix(&data.Branding.Footer)
+	fix(&data.Branding.Contact.Address)
+	for i := range data.Domains {
+		fix(&data.Domains[i].Name)
+	}
+	for i := range data.Instruments {
+		fix(&data.Instruments[i].Interpretation)
+	}
+	fixItems := func(items []LaTeXAppendixItem) {
+		for i := range items {
+			fix(&items[i].Question)
+			fix(&items[i].Answer)
+			fix(&items[i].Comment)
+		}
+	}
+	fixItems(data.Appendix)
+	for i := range data.AppendixSections {
+		fix(&data.AppendixSections[i].Title)
+		fixItems(data.AppendixSections[i].Items)
+	}
+
+	if len(stripped) == 0 {
+		return
+	}
+	glyphs := make([]string, 0, len(stripped))
+	for r := range stripped {
+		glyphs = append(glyphs, fmt.Sprintf("%q (U+%04X)", r, r))
+	}
+	sort.Strings(glyphs)
+	warningsFrom(ctx).Add(Warning{
+		Code: warnLaTeXGlyphStripped,
+		Message: fmt.Sprintf("%d characters are not covered by the PDF fonts and were removed: %s; use the xelatex engine with fallback fonts to keep them",
+			len(glyphs), strings.Join(glyphs, ", ")),
+		Section: "pdf",
+	})
+}
+
+// transliterateForLaTeX keeps covered runes, decomposes the others to their
+// base letter when it is covered, and strips them otherwise
+func transliterateForLaTeX(s string, stripped map[rune]bool) string {
+	if strings.IndexFunc(s, func(r rune) bool { return !latexCovered(r) }) < 0 {
+		return s
+	}
+
+	var out strings.Builder
+	for _, r := range s {
+		if latexCovered(r) {
+			out.WriteRune(r)
+			continue
+		}
+		base := []rune(norm.NFD.String(string(r)))
+		if len(base) > 0 && base[0] != r && latexCovered(base[0]) {
+			out.WriteRune(base[0])
+			continue
+		}
+		if !unicode.Is(unicode.Variation_Selector, r) && r != '\u200D' {
+			stripped[r] = true
+		}
+	}
+	return out.String()
+}
+
+// breakLongLaTeXTokens inserts breakpoints into unbroken runs of appendix
+// text longer than latexMaxTokenLength
+func breakLongLaTeXTokens(ctx context.Context, items []LaTeXAppendixItem) {
+	for i := range items {
+		for _, field := range []*string{&items[i].Question, &items[i].Answer, &items[i].Comment} {
+			fixed, changed := insertLaTeXBreakpoints(*field, latexMaxTokenLength)
+			if !changed {
+				continue
+			}
+			*field = fixed
+			warningsFrom(ctx).Add(Warning{
+				Code:       warnLaTeXLongToken,
+				Message:    fmt.Sprintf("question %d contains words longer than %d characters, line breaks were allowed inside them", items[i].ID, latexMaxTokenLength),
+				QuestionID: items[i].ID,
+				Section:    "pdf",
+			})
+		}
+	}
+}
+
+// insertLaTeXBreakpoints adds a breakpoint every limit runes of any run of
+// non-space characters
+func insertLaTeXBreakpoints(s string, limit int) (string, bool) {
+	if limit <= 0 {
+		return s, false
+	}
+	var out strings.Builder
+	run, changed := 0, false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			run = 0
+		} else if run == limit {
+			out.WriteRune(latexBreakpoint)
+			run, changed = 0, true
+		}
+		if !unicode.IsSpace(r) {
+			run++
+		}
+		out.WriteRune(r)
+	}
+	return out.String(), changed
+}
+
+// checkLaTeXBraces verifies that group braces balance, skipping escaped
+// braces and comments
+func checkLaTeXBraces(document string) error {
+	depth := 0
+	for lineNumber, line := range strings.Split(document, "\n") {
+		escaped := false
+		for _, r := range line {
+			if escaped {
+				escaped = false
+				continue
+			}
+			switch r {
+			case '\\':
+				escaped = true
+			case '%':
+				goto nextLine
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth < 0 {
+					return fmt.Errorf("unbalanced LaTeX braces: unexpected } on line %d", lineNumber+1)
+				}
+			}
+		}
+	nextLine:
+	}
+	if depth > 0 {
+		return fmt.Errorf("unbalanced LaTeX braces: %d groups left open", depth)
+	}
+	return nil
+}
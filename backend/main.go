@@ -1,28 +1,83 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/yuin/goldmark"
+
+	"raads-pdf-backend/claudestream"
+	"raads-pdf-backend/streamproto"
 )
 
 type AssessmentData struct {
+	// Version of the submission format, see currentSchemaVersion. Set to
+	// the current version once decoded, and left out of the assessment hash.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
 	Language            string              `json:"language"`
 	Metadata            Metadata            `json:"metadata"`
 	Scores              Scores              `json:"scores"`
 	Interpretation      Interpretation      `json:"interpretation"`
 	QuestionsAndAnswers []QuestionAndAnswer `json:"questionsAndAnswers"`
+
+	// Questionnaire the answers are to: raads-r (default), aq-50, eq-sq or
+	// rbq-2a
+	Instrument string `json:"instrument,omitempty"`
+
+	// Attach the assessment as a document rather than inlining it in the prompt
+	AttachmentMode bool `json:"attachmentMode,omitempty"`
+
+	// Key of the reference profile scores are compared against
+	ReferenceProfile string `json:"referenceProfile,omitempty"`
+
+	// Consent to keep this generation for quality review of the prompt
+	AllowQualityReview bool `json:"allowQualityReview,omitempty"`
+
+	// Free-text context from the participant, such as existing diagnoses,
+	// given to the model separately from the questionnaire data
+	AdditionalContext string `json:"additionalContext,omitempty"`
+
+	// Age and gender of the participant, selecting the normative samples
+	// the scores are ranked against
+	Demographics *Demographics `json:"demographics,omitempty"`
+
+	// Minimal submission format, preferred over the fields above: only the
+	// test date and answers are sent, everything else is derived from the
+	// catalogs by deriveAssessment
+	TestDate *time.Time        `json:"testDate,omitempty"`
+	Answers  []SubmittedAnswer `json:"answers,omitempty"`
+
+	// Partial retake: answers only holds the changed answers, merged into
+	// the assessment of a previous analysis by mergeRetake
+	BaseReportID       string `json:"baseReportId,omitempty"`
+	BaseAssessmentHash string `json:"baseAssessmentHash,omitempty"`
+
+	// Model the participant chose for the analysis, among the models of
+	// MODEL_OVERRIDE_ALLOWLIST. Left out of the assessment hash.
+	Model string `json:"model,omitempty"`
+
+	// Audience the report is written for: clinical (default),
+	// plain-language or compassionate. Left out of the assessment hash.
+	Tone string `json:"tone,omitempty"`
+
+	// Sampling parameters replacing those of the deployment, within its
+	// bounds. Left out of the assessment hash.
+	Sampling *SamplingParams `json:"sampling,omitempty"`
+
+	// Set by mergeRetake, never by clients
+	Lineage *RetakeLineage `json:"-"`
 }
 
 type Metadata struct {
@@ -30,6 +85,14 @@ type Metadata struct {
 	TestDate          time.Time `json:"testDate"`
 	TotalQuestions    int       `json:"totalQuestions"`
 	AnsweredQuestions int       `json:"answeredQuestions"`
+
+	// Offset the test date was sent with, as +hh:mm, since TestDate is UTC
+	TestDateOffset string `json:"testDateOffset,omitempty"`
+	// IANA timezone of the participant, used to display dates
+	Timezone string `json:"timezone,omitempty"`
+	// Time taken to answer the questionnaire, in seconds, when the client
+	// measured it
+	DurationSeconds int `json:"durationSeconds,omitempty"`
 }
 
 type Scores struct {
@@ -43,6 +106,12 @@ type Scores struct {
 	MaxSensory    int `json:"maxSensory"`
 	Restricted    int `json:"restricted"`
 	MaxRestricted int `json:"maxRestricted"`
+
+	// Scores of the two questionnaires of the EQ/SQ
+	Empathy        int `json:"empathy,omitempty"`
+	MaxEmpathy     int `json:"maxEmpathy,omitempty"`
+	Systemizing    int `json:"systemizing,omitempty"`
+	MaxSystemizing int `json:"maxSystemizing,omitempty"`
 }
 
 type QuestionAndAnswer struct {
@@ -63,19 +132,25 @@ type Interpretation struct {
 }
 
 type ClaudeRequest struct {
-	Model     string    `json:"model"`
-	MaxTokens int       `json:"max_tokens"`
-	Messages  []Message `json:"messages"`
-	Stream    bool      `json:"stream,omitempty"`
+	Model         string    `json:"model"`
+	MaxTokens     int       `json:"max_tokens"`
+	System        string    `json:"system,omitempty"`
+	Messages      []Message `json:"messages"`
+	Stream        bool      `json:"stream,omitempty"`
+	Temperature   *float64  `json:"temperature,omitempty"`
+	TopP          *float64  `json:"top_p,omitempty"`
+	StopSequences []string  `json:"stop_sequences,omitempty"`
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role string `json:"role"`
+	// Either a string or a list of content blocks
+	Content any `json:"content"`
 }
 
 type ClaudeResponse struct {
 	Content []ContentBlock `json:"content"`
+	Usage   *ClaudeUsage   `json:"usage,omitempty"`
 }
 
 type ContentBlock struct {
@@ -84,22 +159,11 @@ type ContentBlock struct {
 }
 
 // Streaming response structures
-type ClaudeStreamEvent struct {
-	Type    string               `json:"type"`
-	Delta   *ClaudeStreamDelta   `json:"delta,omitempty"`
-	Message *ClaudeStreamMessage `json:"message,omitempty"`
-}
-
 type ClaudeStreamDelta struct {
 	Type string `json:"type"`
 	Text string `json:"text"`
 }
 
-type ClaudeStreamMessage struct {
-	Type  string       `json:"type"`
-	Usage *ClaudeUsage `json:"usage,omitempty"`
-}
-
 type ClaudeUsage struct {
 	InputTokens  int `json:"input_tokens"`
 	OutputTokens int `json:"output_tokens"`
@@ -108,6 +172,24 @@ type ClaudeUsage struct {
 var (
 	claudeAPIKey = os.Getenv("CLAUDE_API_KEY")
 
+	// Base URL of the Claude API, overridable for proxies and local testing
+	claudeBaseURL = envString("CLAUDE_BASE_URL", "https://api.anthropic.com")
+
+	// HTTP client used for every call to the Claude API
+	claudeHTTPClient = &http.Client{Timeout: 90 * time.Second}
+
+	// Request headers accepted from browsers. Cache-Control and Last-Event-ID
+	// are sent by EventSource polyfills, the latter also being used to resume.
+	allowedRequestHeaders = []string{
+		"Content-Type",
+		"Authorization",
+		"X-Requested-With",
+		"X-API-Key",
+		"X-Client-Token",
+		"Cache-Control",
+		"Last-Event-ID",
+	}
+
 	// Supported languages mapping language code to display name
 	supportedLanguages = map[string]string{
 		"en": "English",
@@ -120,26 +202,72 @@ var (
 )
 
 func main() {
+	checkOnly := flag.Bool("check", false, "validate configuration, templates and dependencies, then exit")
+	flag.Parse()
+
+	// Dry-start mode: report on every check and exit with the result
+	if *checkOnly || os.Getenv("CHECK_ONLY") == "1" {
+		if !printCheckReport(dryStartChecks()) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Validate required environment variables
-	if claudeAPIKey == "" {
-		log.Fatal("CLAUDE_API_KEY environment variable is required")
+	if err := loadProvider(); err != nil {
+		log.Fatal(err)
+	}
+	if clinicalConfigErr != nil {
+		log.Fatal(clinicalConfigErr)
+	}
+	if normativeDatasetErr != nil {
+		log.Fatal(normativeDatasetErr)
+	}
+	if normativeSamplesErr != nil {
+		log.Fatal(normativeSamplesErr)
+	}
+
+	// Requeue the jobs that failed during an outage once the AI service
+	// recovers
+	claudeBreaker.onClose = func() { jobs.RequeueRetryable() }
+
+	if err := loadFeatureFlags(); err != nil {
+		log.Fatal(err)
+	}
+	watchFeatureFlags()
+
+	// Serve the analyses generated before a restart
+	if loaded, err := analysisCache.Load(); err != nil {
+		log.Fatal(err)
+	} else if loaded > 0 {
+		log.Printf("🗄️  Loaded %d cached analyses from %s", loaded, analysisCacheDir)
+	}
+
+	// Keep reports across restarts, under the report ID returned to clients
+	store, err := openReportStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if store != nil {
+		reports = store
+		defer reports.Close()
+		go pruneReports()
+		log.Printf("🗄️  Storing reports in the %s report store", reportStoreBackend)
+	}
+
+	// Missing dependencies only degrade the features relying on them
+	for feature, reason := range degradedFeatureList() {
+		log.Printf("⚠️  Feature %s is degraded: %s", feature, reason)
 	}
+	warmedUp.Store(true)
+	startHeartbeat()
 
 	// Set Gin mode based on environment
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
-
-	// Health check and CORS middleware
-	r.Use(corsMiddleware())
-	r.Use(loggingMiddleware())
-
-	// Routes
-	r.GET("/health", healthCheck)
-	r.POST("/analyze", analyzeHandler)              // Endpoint for analysis only
-	r.POST("/analyze-stream", analyzeStreamHandler) // Streaming analysis endpoint
+	r := newRouter()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -148,9 +276,88 @@ func main() {
 
 	log.Printf("🚀 RAADS-R PDF Service starting on port %s", port)
 	log.Printf("📊 Using Claude API for report generation")
-	if err := r.Run(":" + port); err != nil {
+	logFeatureFlags()
+	if err := serveUntilSignal(&http.Server{Addr: ":" + port, Handler: r}); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
+	log.Printf("👋 Server stopped")
+}
+
+// newRouter builds the HTTP router with every middleware and route
+func newRouter() *gin.Engine {
+	r := gin.Default()
+
+	// Health check and CORS middleware
+	r.Use(corsMiddleware())
+	r.Use(loggingMiddleware())
+	r.Use(warningsMiddleware())
+	r.Use(credentialMiddleware())
+	r.Use(ownerMiddleware())
+	r.Use(modelOverrideMiddleware())
+	r.Use(usageMiddleware())
+
+	// Routes
+	r.GET("/health", healthCheck)
+	r.GET("/livez", livezHandler)
+	r.GET("/readyz", readyzHandler)
+	r.GET("/questions", questionsHandler)
+	r.GET("/questions/:lang", questionBankHandler)
+	r.GET("/schemas/assessment.json", assessmentSchemaHandler)
+	r.GET("/openapi.json", openAPIHandler) // OpenAPI document of every route
+	r.GET("/versions/prompts", analysisVersionsHandler)
+	r.GET("/norms", normsHandler)
+	r.GET("/themes", themesHandler)
+	r.GET("/models", selectableModelsHandler)
+	r.GET("/features", featuresHandler)
+	r.GET("/stats", requireFeature(featureStats), statsHandler)
+	r.GET("/metrics", metricsHandler)
+	r.GET("/og-image", requireFeature(featureOGImage), ogImageHandler)
+	r.POST("/score", schemaValidation(), scoreHandler)
+	r.POST("/chart-data", schemaValidation(), chartDataHandler)
+	r.POST("/anonymize", anonymizeHandler)                                          // Assessment stripped of personal content, for bug reports
+	r.POST("/estimate", schemaValidation(), estimateHandler)                        // Tokens, cost and latency of an analysis before generating it
+	r.POST("/report/exists", reportExistsHandler)                                   // Cheap check for a cached analysis, without its content
+	r.POST("/analyze", schemaValidation(), analyzeHandler)                          // Endpoint for analysis only
+	r.POST("/analyze-stream", schemaValidation(), analyzeStreamHandler)             // Streaming analysis endpoint
+	r.POST("/analyze-batch", batchRouting(), schemaValidation(), analyzeHandler)    // Analysis at batch priority, or of several assessments through the Message Batches API
+	r.POST("/analyze/domain", analyzeDomainHandler)                                 // Extended analysis of a single domain
+	r.POST("/analyze/domain/stream", analyzeDomainStreamHandler)                    // Streaming extended analysis of a single domain
+	r.POST("/analyze/composite", analyzeCompositeHandler)                           // Single report of several instruments, cross-referencing their findings
+	r.DELETE("/analyze/:report_id", cancelGenerationHandler)                        // Cancel of an in-flight streaming analysis
+	r.POST("/export-html", requireFeature(featureHTMLExport), exportHTMLHandler)    // Self-contained HTML export
+	r.POST("/export/bundle", exportBundleHandler)                                   // Zip of several export formats
+	r.POST("/export/csv", exportCSVHandler)                                         // Questions, answers, scores and comments as CSV
+	r.POST("/export/xlsx", exportXLSXHandler)                                       // Workbook of the scores, answers and comments
+	r.POST("/export/fhir/response", exportFHIRResponseHandler)                      // FHIR R4 QuestionnaireResponse, for EHR systems
+	r.POST("/export/fhir/report", exportFHIRReportHandler)                          // FHIR R4 DiagnosticReport of the scores and analysis
+	r.POST("/export/anonymized", exportAnonymizedHandler)                           // Item scores and domain totals only, safe to share
+	r.GET("/reports/:id", storedReportHandler)                                      // Stored assessment and analysis, for the client it was generated for
+	r.DELETE("/reports/:id", deleteStoredReportHandler)                             // Deletion of a stored report by the client it was generated for
+	r.GET("/reports/:id/chart.svg", reportChartHandler)                             // Score chart of an analyzed assessment, as in the reports
+	r.GET("/reports/:id/chart.png", reportChartHandler)                             // Same chart as PNG, for documents without SVG support
+	r.GET("/reports/:id/radar.svg", reportChartHandler)                             // Radar chart of the domain scores, thresholds and reference means
+	r.GET("/reports/:id/radar.png", reportChartHandler)                             // Same radar chart as PNG
+	r.GET("/verify/:token", verifyReportHandler)                                    // Verification page of the signed link printed on reports
+	r.POST("/export/composite", requireFeature(featurePDF), exportCompositeHandler) // PDF of a composite report
+	r.POST("/generate-pdf", requireFeature(featurePDF), generatePDFHandler)         // PDF of the report, compiled from LaTeX or printed by headless Chrome
+	r.POST("/generations/:report_id/cancel", cancelGenerationHandler)
+	r.POST("/jobs", requireFeature(featureAsyncJobs), schemaValidation(), submitJobHandler)
+	r.GET("/jobs/:id", requireFeature(featureAsyncJobs), jobHandler)
+	r.GET("/analyze-batch/:id", requireFeature(featureBatches), batchHandler)
+	registerAssetRoutes(r)
+
+	r.GET("/usage", adminAuthMiddleware(), usageHandler) // Token usage and estimated cost, admin only
+
+	admin := r.Group("/admin", adminAuthMiddleware())
+	admin.GET("/models", adminModelsHandler)
+	admin.GET("/quality-review", adminQualityReviewListHandler)
+	admin.GET("/quality-review/:id", adminQualityReviewHandler)
+	admin.GET("/jobs", adminJobsHandler)
+	admin.POST("/jobs/:id/requeue", adminRequeueJobHandler)
+	admin.GET("/render-diagnostics", adminRenderDiagnosticsHandler)
+	admin.GET("/traces/:report_id", adminTraceHandler)
+
+	return r
 }
 
 func corsMiddleware() gin.HandlerFunc {
@@ -210,12 +417,23 @@ func corsMiddleware() gin.HandlerFunc {
 			c.Header("Access-Control-Allow-Origin", "https://raphink.github.io")
 		}
 
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", strings.Join(allowedRequestHeaders, ", "))
 		c.Header("Access-Control-Allow-Credentials", "false")
+		c.Header("Access-Control-Expose-Headers", "Retry-After, X-Export-Warning, "+streamproto.Header)
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
+			// Echo the requested headers when they are all allowed, and
+			// reject the preflight otherwise
+			if requested := c.Request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				if !requestHeadersAllowed(requested) {
+					log.Printf("❌ Rejected preflight with disallowed headers: %s", requested)
+					c.AbortWithStatus(403)
+					return
+				}
+				c.Header("Access-Control-Allow-Headers", requested)
+			}
 			c.AbortWithStatus(204)
 			return
 		}
@@ -224,6 +442,28 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// requestHeadersAllowed checks a comma-separated Access-Control-Request-Headers
+// value against the allowlist, ignoring case
+func requestHeadersAllowed(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := false
+		for _, allowedHeader := range allowedRequestHeaders {
+			if strings.EqualFold(header, allowedHeader) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
 func loggingMiddleware() gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
 		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
@@ -241,11 +481,29 @@ func loggingMiddleware() gin.HandlerFunc {
 }
 
 func healthCheck(c *gin.Context) {
+	status := "healthy"
+	degraded := degradedFeatureList()
+	if len(degraded) > 0 {
+		status = "degraded"
+	}
+
+	// Deep checks also verify the Anthropic API version with a live request
+	apiStatus := currentAPIVersionStatus()
+	if c.Query("deep") == "true" {
+		apiStatus = probeAPIVersion(c.Request.Context())
+	}
+
+	ready, readiness := currentReadiness()
+
 	c.JSON(200, gin.H{
-		"status":    "healthy",
-		"service":   "raads-r-pdf-service",
-		"timestamp": time.Now().UTC(),
-		"version":   "1.0.0",
+		"status":        status,
+		"degraded":      degraded,
+		"ready":         ready,
+		"readiness":     readiness,
+		"service":       "raads-r-pdf-service",
+		"timestamp":     time.Now().UTC(),
+		"version":       "1.0.0",
+		"anthropic_api": apiStatus,
 	})
 }
 
@@ -260,50 +518,228 @@ func analyzeHandler(c *gin.Context) {
 	}
 
 	// Validate the assessment data
-	if err := validateAssessmentData(data); err != nil {
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
 		log.Printf("❌ Invalid assessment data: %v", err)
-		c.JSON(400, gin.H{"error": "Invalid assessment data: " + err.Error()})
+		c.JSON(400, invalidAssessment(err))
 		return
 	}
 
+	if err := requestModel(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid model selection: %v", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := assessmentHash(data)
+	if err != nil {
+		log.Printf("❌ Error hashing assessment data: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to process assessment data: " + err.Error()})
+		return
+	}
+
+	// Serve a cached analysis, even one generated by an older prompt, and
+	// regenerate stale ones in the background
+	cacheKey := analysisCacheKey(hash, data)
+	noStore := strings.Contains(c.GetHeader("Cache-Control"), "no-store")
+	if !noStore {
+		if entry, revalidate, ok := analysisCache.Lookup(cacheKey, c.Query("revalidate") == "true"); ok {
+			log.Printf("💾 Serving cached analysis %s (prompt version %d, revision %d)", entry.ReportID, entry.PromptVersion, entry.Revision)
+			if revalidate {
+				analysisCache.Revalidate(cacheKey, hash, data, credentialFrom(c.Request.Context()))
+			}
+			// Each client gets a report of its own, which its retakes,
+			// charts and stored report are looked up by
+			reportID, granted := analysisCache.ClientReportID(cacheKey, clientToken(c))
+			if !granted {
+				reportID = uuid.New().String()
+				owner := ownerFrom(c.Request.Context())
+				assessments.Save(reportID, hash, owner, data)
+				saveReport(c.Request.Context(), StoredReport{ReportID: reportID, AssessmentHash: hash, Assessment: data, Markdown: entry.Markdown, PromptVersion: entry.PromptVersion, Owner: owner})
+				analysisCache.Grant(cacheKey, clientToken(c), reportID)
+			}
+			response := analysisResponse(c, data, reportID, hash, entry.Markdown, entry.InputMode)
+			response["cached"] = true
+			response["stale"] = entry.Stale()
+			response["prompt_version"] = entry.PromptVersion
+			response["prompt_version_delta"] = promptVersion - entry.PromptVersion
+			response["revision"] = entry.Revision
+			response["revalidating"] = revalidate
+			response["generated_at"] = entry.GeneratedAt
+			response["analysis_version"] = analysisVersionFor(entry.PromptVersion)
+			writeAnalysisResponse(c, response, entry.Markdown)
+			return
+		}
+	}
+
+	// Fail fast while the AI service is down
+	if err := aiServiceAvailable(); aiUnavailable(c, err) {
+		log.Printf("⚠️  Rejecting analysis: %v", err)
+		return
+	}
+
+	// Wait for a worker slot, or tell the client when to come back
+	slot, err := workers.Acquire(c.Request.Context(), requestPriority(c))
+	if err != nil {
+		info := workers.Busy()
+		log.Printf("⚠️  Rejecting analysis: %v (queue depth %d)", err, info.QueueDepth)
+		c.Header("Retry-After", strconv.Itoa(info.RetryAfterSeconds))
+		c.JSON(503, busyResponse(info))
+		return
+	}
+	completed := false
+	defer func() { slot.Release(completed) }()
+
+	stats.Record(data)
+
 	reportID := uuid.New().String()
+	usageFrom(c.Request.Context()).SetReport(reportID)
 	log.Printf("🧠 Processing analysis request %s", reportID)
 	log.Printf("   - Total Score: %d/%d", data.Scores.Total, data.Scores.MaxTotal)
 	log.Printf("   - Test: %s", data.Metadata.TestName)
 
 	// Generate Markdown analysis with Claude
 	log.Printf("🤖 Generating analysis with Claude...")
-	markdownContent, err := generateMarkdownReportWithClaude(data)
+	input, err := prepareAssessmentInput(c.Request.Context(), data)
+	if err != nil {
+		log.Printf("❌ Error preparing assessment input: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+
+	version := instrumentPromptVersion(data, hash)
+	markdownContent, err := generateMarkdownReportWithClaude(c.Request.Context(), data, input, version)
 	if err != nil {
 		log.Printf("❌ Error generating analysis: %v", err)
+		if aiUnavailable(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
 		return
 	}
 
+	completed = true
 	log.Printf("✅ Generated analysis content (%d characters)", len(markdownContent))
 
-	// Convert Markdown to HTML for the analysis section only
-	var buf bytes.Buffer
-	if err := goldmark.New().Convert([]byte(markdownContent), &buf); err != nil {
-		log.Printf("❌ Error converting Markdown to HTML: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to convert analysis to HTML: " + err.Error()})
+	response := analysisResponse(c, data, reportID, hash, markdownContent, input.Mode)
+	response["model"] = routingFrom(c.Request.Context()).Route()
+	response["prompt_version"] = version
+	if usage, ok := usageTotals.Report(reportID); ok {
+		response["usage"] = usage.TokenUsage
+	}
+	// A summary of the scores is not cached, so that the analysis is
+	// generated once the AI service recovers
+	if !noStore && !usedTemplateFallback(c.Request.Context()) {
+		owner := ownerFrom(c.Request.Context())
+		assessments.Save(reportID, hash, owner, data)
+		saveReport(c.Request.Context(), StoredReport{ReportID: reportID, AssessmentHash: hash, Assessment: data, Markdown: markdownContent, PromptVersion: version, Owner: owner})
+		entry := analysisCache.Store(cacheKey, reportID, markdownContent, input.Mode, version)
+		analysisCache.Grant(cacheKey, clientToken(c), reportID)
+		response["cached"] = false
+		response["stale"] = false
+		response["revision"] = entry.Revision
+	}
+	writeAnalysisResponse(c, response, markdownContent)
+}
+
+// analysisResponse builds the fields of an analysis response that do not
+// depend on the HTML rendering
+func analysisResponse(c *gin.Context, data AssessmentData, reportID, hash, markdown, inputMode string) gin.H {
+	response := gin.H{
+		"success":           true,
+		"report_id":         reportID,
+		"assessment_hash":   hash,
+		"reading":           analysisReadingStats(markdown, data.Language),
+		"input_mode":        inputMode,
+		"reference_profile": referenceProfileMetadata(data),
+		"generated_at":      time.Now().UTC(),
+		"analysis_version":  currentAnalysisVersion(),
+
+		"additional_context_provided": data.AdditionalContext != "",
+	}
+	// Contributions and charts are computed from the RAADS-R domains
+	if instrumentOf(data).Key == instrumentRAADSR {
+		response["contributions"] = questionContributions(c.Request.Context(), data)
+		if chart, err := chartDataFor(data); err == nil {
+			response["chart"] = chart
+		}
+	} else {
+		response["instrument"] = data.Instrument
+		for key, value := range instrumentOf(data).Details(data) {
+			response[key] = value
+		}
+	}
+	if data.Lineage != nil {
+		response["lineage"] = data.Lineage
+	}
+	if percentiles := normativePercentiles(data); percentiles != nil {
+		response["percentiles"] = percentiles
+	}
+	if flags := validityFlags(data); len(flags) > 0 {
+		response["validity"] = flags
+	}
+	response["confidence"] = scoreConfidence(data)
+
+	// Return the answers exactly as analyzed, after truncation and repairs,
+	// so every rendering of the appendix matches what Claude saw
+	if includeAnswers(c) {
+		response["questionsAndAnswers"] = data.QuestionsAndAnswers
+	}
+	// Lets an anonymized copy sent with a bug report be matched to the
+	// analysis it is about
+	if skeleton, err := skeletonHash(data); err == nil {
+		response["skeleton_hash"] = skeleton
+	}
+	return response
+}
+
+// writeAnalysisResponse adds the HTML rendering of the analysis and sends
+// the response. The analysis itself succeeded, so a rendering failure only
+// degrades the response to markdown instead of failing the request.
+func writeAnalysisResponse(c *gin.Context, response gin.H, markdown string) {
+	html, err := renderMarkdown(markdown, "analysis")
+	if err != nil {
+		warningsFrom(c.Request.Context()).Add(Warning{
+			Code:    warnRenderFallback,
+			Message: "failed to convert analysis to HTML: " + err.Error(),
+			Section: "analysis",
+		})
+		response["warnings"] = warningsFrom(c.Request.Context()).List()
+		response["analysis"] = nil
+		response["markdown"] = markdown
+		response["render_error"] = err.Error()
+		c.JSON(200, response)
 		return
 	}
 
-	analysisHTML := buf.String()
 	log.Printf("📄 Returning analysis HTML...")
 
 	// Return just the analysis HTML (much lighter than full report)
-	c.JSON(200, gin.H{
-		"success":      true,
-		"report_id":    reportID,
-		"analysis":     analysisHTML,
-		"generated_at": time.Now().UTC(),
-	})
+	response["analysis"] = html
+	response["warnings"] = warningsFrom(c.Request.Context()).List()
+	c.JSON(200, response)
 }
 
 // analyzeStreamHandler provides streaming Claude analysis as Server-Sent Events
 func analyzeStreamHandler(c *gin.Context) {
+	c.Header(streamproto.Header, strconv.Itoa(streamproto.Version))
+
+	// Refuse clients pinned to a protocol version we no longer speak
+	if pinned := c.Query(streamproto.QueryParam); pinned != "" && !streamproto.Supported(pinned) {
+		log.Printf("❌ Unsupported stream protocol version %q", pinned)
+		setStreamHeaders(c)
+		sendStreamEvent(c, streamproto.Error{
+			Error: fmt.Sprintf("unsupported stream protocol version %s, this server speaks version %d", pinned, streamproto.Version),
+			Code:  "unsupported_protocol_version",
+		})
+		return
+	}
+
+	// Resume a dropped stream rather than generating the analysis again
+	if replay, seq, ok := resumeTarget(c); ok {
+		resumeStream(c, replay, seq)
+		return
+	}
+
 	var data AssessmentData
 
 	if err := c.ShouldBindJSON(&data); err != nil {
@@ -313,48 +749,218 @@ func analyzeStreamHandler(c *gin.Context) {
 	}
 
 	// Validate the assessment data
-	if err := validateAssessmentData(data); err != nil {
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
 		log.Printf("❌ Invalid assessment data: %v", err)
-		c.JSON(400, gin.H{"error": "Invalid assessment data: " + err.Error()})
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	if err := requestModel(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid model selection: %v", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Fail fast while the AI service is down
+	if err := aiServiceAvailable(); aiUnavailable(c, err) {
+		log.Printf("⚠️  Rejecting streaming analysis: %v", err)
+		return
+	}
+
+	// Shed load before starting a new stream when buffers are already large
+	if !streamCapacityAvailable() {
+		streamsRejected.Add(1)
+		log.Printf("⚠️  Rejecting streaming analysis: %d bytes buffered", streamBufferedBytes.Load())
+		c.Header("Retry-After", "30")
+		c.JSON(503, gin.H{"error": "Server is busy, please retry shortly"})
+		return
+	}
+
+	// When the pool is saturated, send a single busy event and close
+	slot, err := workers.Acquire(c.Request.Context(), requestPriority(c))
+	if err != nil {
+		info := workers.Busy()
+		log.Printf("⚠️  Rejecting streaming analysis: %v (queue depth %d)", err, info.QueueDepth)
+		setStreamHeaders(c)
+		c.Header("Retry-After", strconv.Itoa(info.RetryAfterSeconds))
+		sendStreamEvent(c, streamproto.Busy{
+			Error:                "Server is busy, please retry shortly",
+			QueueDepth:           info.QueueDepth,
+			Workers:              info.Workers,
+			EstimatedWaitSeconds: info.EstimatedWaitSeconds,
+			RetryAfterSeconds:    info.RetryAfterSeconds,
+		})
+		return
+	}
+	completed := false
+	defer func() { slot.Release(completed) }()
+
+	stats.Record(data)
+
+	hash, err := assessmentHash(data)
+	if err != nil {
+		log.Printf("❌ Error hashing assessment data: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to process assessment data: " + err.Error()})
 		return
 	}
 
 	reportID := uuid.New().String()
+	usageFrom(c.Request.Context()).SetReport(reportID)
 	log.Printf("🧠 Processing streaming analysis request %s", reportID)
+	trace := startSessionTrace(c, reportID)
+	replay := streamReplays.Start(c, reportID)
+	defer replay.Finish()
+
 	log.Printf("   - Total Score: %d/%d", data.Scores.Total, data.Scores.MaxTotal)
 
+	streamsInFlight.Add(1)
+	defer streamsInFlight.Add(-1)
+
+	input, err := prepareAssessmentInput(c.Request.Context(), data)
+	if err != nil {
+		log.Printf("❌ Error preparing assessment input: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		return
+	}
+
 	// Set headers for Server-Sent Events
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
+	setStreamHeaders(c)
 	// Note: CORS is already handled by the middleware, no need to override here
 
 	// Send initial metadata
-	c.SSEvent("metadata", gin.H{
-		"report_id":  reportID,
-		"started_at": time.Now().UTC(),
+	sendStreamEvent(c, streamproto.Metadata{
+		ProtocolVersion:  streamproto.Version,
+		ReportID:         reportID,
+		AssessmentHash:   hash,
+		InputMode:        input.Mode,
+		ReferenceProfile: referenceProfileMetadata(data),
+		StartedAt:        time.Now().UTC(),
+		AnalysisVersion:  currentAnalysisVersion(),
+		Percentiles:      percentilesMetadata(data),
+		Lineage:          lineageMetadata(data.Lineage),
+
+		AdditionalContextProvided: data.AdditionalContext != "",
 	})
 
+	// Register the generation so it can be cancelled while in flight. It
+	// goes on when the client leaves, which can then resume the stream.
+	ctx, gen := generations.Start(generationContext(c), reportID)
+	gen.answers = data.QuestionsAndAnswers
+	status := generationFailed
+	defer func() {
+		generations.Finish(reportID, status)
+		trace.Finish(c.Request.Context(), status)
+	}()
+
 	// Generate streaming analysis with Claude
 	log.Printf("🤖 Starting streaming analysis with Claude...")
-	err := streamMarkdownReportWithClaude(data, c)
+	version := instrumentPromptVersion(data, hash)
+	err = streamMarkdownReportWithClaude(ctx, data, input, version, c, gen)
+	if gen.Cancelled() {
+		log.Printf("🛑 Streaming analysis %s cancelled", reportID)
+		status = generationCancelled
+		// The partial analysis is kept for the client to read back, marked
+		// as cancelled
+		if !strings.Contains(c.GetHeader("Cache-Control"), "no-store") {
+			saveReport(ctx, StoredReport{ReportID: reportID, AssessmentHash: hash, Assessment: data, Markdown: gen.Partial(), PromptVersion: version, Status: generationCancelled, Owner: ownerFrom(ctx)})
+		}
+		sendStreamEvent(c, streamproto.Cancelled{
+			CancelledAt: time.Now().UTC(),
+			Markdown:    gen.Partial(),
+		})
+		return
+	}
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = generationTimeout
+		}
 		log.Printf("❌ Error during streaming analysis: %v", err)
-		c.SSEvent("error", gin.H{"error": "Failed to generate analysis: " + err.Error()})
+		if errors.Is(err, errAIUnavailable) {
+			sendStreamEvent(c, streamproto.Error{Error: "AI service unavailable, please retry shortly", Code: "ai_unavailable"})
+			return
+		}
+		sendStreamEvent(c, streamproto.Error{Error: "Failed to generate analysis: " + err.Error()})
 		return
 	}
+	status = generationCompleted
+	completed = true
+	if !strings.Contains(c.GetHeader("Cache-Control"), "no-store") {
+		owner := ownerFrom(ctx)
+		assessments.Save(reportID, hash, owner, data)
+		saveReport(ctx, StoredReport{ReportID: reportID, AssessmentHash: hash, Assessment: data, Markdown: gen.Partial(), PromptVersion: version, Owner: owner})
+	}
 
 	// Send completion event
-	c.SSEvent("complete", gin.H{
-		"completed_at": time.Now().UTC(),
-	})
+	complete := streamproto.Complete{
+		CompletedAt:   time.Now().UTC(),
+		Reading:       readingMetadata(analysisReadingStats(gen.Partial(), data.Language)),
+		Warnings:      warningsMetadata(warningsFrom(c.Request.Context()).List()),
+		Model:         routeMetadata(routingFrom(c.Request.Context()).Route()),
+		PromptVersion: version,
+	}
+	if usage, ok := usageTotals.Report(reportID); ok {
+		tokens := streamproto.TokenUsage(usage.TokenUsage)
+		complete.Usage = &tokens
+	}
+	if includeAnswers(c) {
+		for _, qa := range data.QuestionsAndAnswers {
+			complete.QuestionsAndAnswers = append(complete.QuestionsAndAnswers, streamproto.QuestionAndAnswer(qa))
+		}
+	}
+	sendStreamEvent(c, complete)
+}
+
+// setStreamHeaders prepares the response for Server-Sent Events
+func setStreamHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+}
+
+// sendStreamEvent writes a protocol event and flushes it to the client,
+// with an ID to resume from when the stream is replayable
+func sendStreamEvent(c *gin.Context, event streamproto.Event) {
+	written := c.Writer.Size()
+	at := time.Now()
+	if id := streamReplayFrom(c.Request.Context()).Record(event); id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	c.SSEvent(event.EventName(), event)
+	flushStart := time.Now()
+	c.Writer.Flush()
+	traceFrom(c.Request.Context()).Record(event.EventName(), c.Writer.Size()-max(written, 0), at.UTC(), time.Since(flushStart))
 }
 
-func validateAssessmentData(data AssessmentData) error {
+// includeAnswers reports whether the analyzed answers should be returned,
+// which bandwidth-sensitive clients can opt out of with include_answers=false
+func includeAnswers(c *gin.Context) bool {
+	return c.DefaultQuery("include_answers", "true") != "false"
+}
+
+func validateAssessmentData(ctx context.Context, data *AssessmentData) error {
 	if _, isValid := supportedLanguages[data.Language]; !isValid {
 		return fmt.Errorf("invalid language: %s", data.Language)
 	}
 
+	if err := validateInstrument(*data); err != nil {
+		return err
+	}
+
+	// Category aliases are those of the RAADS-R domains
+	if instrumentOf(*data).Key == instrumentRAADSR {
+		if err := normalizeCategories(data); err != nil {
+			return err
+		}
+	}
+
+	if err := mergeRetake(ctx, data); err != nil {
+		return err
+	}
+
+	if err := deriveAssessment(ctx, data); err != nil {
+		return err
+	}
+
 	if len(data.QuestionsAndAnswers) == 0 {
 		return fmt.Errorf("no questions and answers provided")
 	}
@@ -372,132 +978,112 @@ func validateAssessmentData(data AssessmentData) error {
 			data.Metadata.TotalQuestions, len(data.QuestionsAndAnswers))
 	}
 
+	if _, err := referenceProfileFor(data.ReferenceProfile); err != nil {
+		return err
+	}
+
+	if err := validateTone(data.Tone); err != nil {
+		return err
+	}
+
+	if err := validateSampling(data.Sampling); err != nil {
+		return err
+	}
+
+	if err := validateDemographics(ctx, data.Demographics); err != nil {
+		return err
+	}
+
+	if err := validateTimezone(data.Metadata); err != nil {
+		return err
+	}
+
+	if err := validateAnswerScale(ctx, *data); err != nil {
+		return err
+	}
+
+	sanitizeAdditionalContext(ctx, data)
+
 	// Truncate overly long comments (max 500 characters each)
 	for i, qa := range data.QuestionsAndAnswers {
 		if qa.Comment != nil && len(*qa.Comment) > 500 {
 			truncated := (*qa.Comment)[:489] + "[truncated]"
 			data.QuestionsAndAnswers[i].Comment = &truncated
-			log.Printf("⚠️  Truncated comment for question %d (was %d chars, now %d chars)", qa.ID, len(*qa.Comment), len(truncated))
+			warningsFrom(ctx).Add(Warning{
+				Code:       warnCommentTruncated,
+				Message:    fmt.Sprintf("comment for question %d truncated (was %d chars, now %d chars)", qa.ID, len(*qa.Comment), len(truncated)),
+				QuestionID: qa.ID,
+			})
 		}
 	}
 
 	return nil
 }
 
-func generateMarkdownReportWithClaude(data AssessmentData) (string, error) {
-	// Count responses with comments
-	commentsCount := 0
-	for _, qa := range data.QuestionsAndAnswers {
-		if qa.Comment != nil && *qa.Comment != "" {
-			commentsCount++
-		}
-	}
-
-	// Calculate completion rate
-	completionRate := float64(data.Metadata.AnsweredQuestions) / float64(data.Metadata.TotalQuestions) * 100
-
-	// Serialize the complete assessment data for Claude to analyze
-	assessmentJSON, err := json.MarshalIndent(data, "", "  ")
+func generateMarkdownReportWithClaude(ctx context.Context, data AssessmentData, input assessmentInput, version int) (string, error) {
+	prompt, err := analysisPrompt(data, input, version)
 	if err != nil {
-		return "", fmt.Errorf("failed to serialize assessment data: %w", err)
+		return "", err
 	}
-
-	// Determine language for Claude response
-	language := supportedLanguages[data.Language]
-	if language == "" {
-		language = "English" // fallback
+	if input.System, err = systemPrompt(data, version); err != nil {
+		return "", err
 	}
 
-	prompt := fmt.Sprintf(`Generate a comprehensive RAADS-R clinical report in structured Markdown format. RESPOND ENTIRELY IN %s LANGUAGE (including section headers) using appropriate clinical terminology.
-
-COMPLETE ASSESSMENT DATA (JSON):
-%s
-
-SUMMARY:
-- Test Date: %s
-- Total Score: %d/%d (Clinical threshold: 65, Neurotypical average: 26)
-- Social Score: %d/%d (Clinical threshold: 31, Neurotypical average: 12.5)
-- Sensory Score: %d/%d (Clinical threshold: 16, Neurotypical average: 6.5)
-- Restricted Score: %d/%d (Clinical threshold: 15, Neurotypical average: 4.5)
-- Language Score: %d/%d (Clinical threshold: 4, Neurotypical average: 2.5)
-- Interpretation: %s - %s
-- Questions answered: %d/%d (%.1f%%)
-- Comments provided: %d
-
-ANALYSIS INSTRUCTIONS:
-1. Review each individual question and answer in the JSON data
-2. Pay special attention to comments provided - these give insight into personal experiences
-3. Analyze patterns across domains (Social, Sensory/Motor, Restricted Interests, Language)
-4. Look for specific behaviors and traits mentioned in comments
-5. Provide clinical insights based on individual responses, not just aggregate scores
-6. Reference specific question numbers and responses where relevant
-7. Provide evidence-based clinical interpretation
-
-REQUIRED MARKDOWN STRUCTURE:
-
-## Executive Summary
-
-Provide a clear summary of the assessment results, including the overall interpretation and key findings.
-
-### Score Overview
-
-Summarize the domain scores and their clinical significance. Do NOT add a table there.
-
-## Detailed Analysis by Domain
-
-### Social Domain Analysis
-
-### Sensory/Motor Domain Analysis  
-
-### Restricted Interests Domain Analysis
-
-### Language Domain Analysis
-
-## Clinical Interpretation and Recommendations
-
-Detailed section, including strengths and weaknesses, coping strategies, and potential interventions, as well as recommendations.
-
-## Notable Response Patterns
+	model := routeModel(ctx, modeFull).Model
+	markdown, err := completeStructuredMarkdown(ctx, input, prompt, model, analysisMaxTokens)
+	if err != nil {
+		return "", err
+	}
+	qualityReview.Offer(data, input, prompt, markdown, model, version)
 
-Highlight specific questions where responses were particularly informative, especially those with comments that provide personal insights.
+	return markdown, nil
+}
 
-## Conclusion
+// analysisPrompt builds the prompt of a full analysis of an assessment
+// with a prompt version
+func analysisPrompt(data AssessmentData, input assessmentInput, version int) (string, error) {
+	prompt, err := newPromptData(data, input)
+	if err != nil {
+		return "", err
+	}
+	return renderPrompt(version, instrumentOf(data).AnalysisTemplate, prompt)
+}
 
-Provide a clear, evidence-based conclusion with actionable recommendations.
+// completeClaudeMarkdown sends a prompt to Claude and returns the generated
+// markdown, with up to maxTokens output tokens when the model allows it. It
+// fails fast while the circuit breaker is open, falling back to the next
+// provider of the chain if any.
+func completeClaudeMarkdown(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	return generateWithFallback(ctx, input, prompt, model, nil, func(provider string, input assessmentInput, prompt, model string) (string, error) {
+		return completeProviderMarkdown(ctx, provider, input, prompt, model, maxTokens)
+	})
+}
 
-IMPORTANT:
-- Write in professional clinical language IN %s
-- Use EXACT markdown structure, NO top extra title or section, NO tables
-- Base all analysis on the actual assessment data provided
-- Reference specific question numbers and responses where relevant
-- Include direct quotes from comments when they provide insight
-- Provide evidence-based interpretations
-- Keep analysis objective and clinical
-- ALWAYS use the format QX to reference questions (e.g., Q1, Q2)
-- Do not make diagnostic statements beyond the scope of the RAADS-R`,
-		language,
-		string(assessmentJSON),
-		data.Metadata.TestDate.Format("January 2, 2006"),
-		data.Scores.Total, data.Scores.MaxTotal,
-		data.Scores.Social, data.Scores.MaxSocial,
-		data.Scores.Sensory, data.Scores.MaxSensory,
-		data.Scores.Restricted, data.Scores.MaxRestricted,
-		data.Scores.Language, data.Scores.MaxLanguage,
-		data.Interpretation.Level,
-		data.Interpretation.Description,
-		data.Metadata.AnsweredQuestions, data.Metadata.TotalQuestions, completionRate,
-		commentsCount,
-		language)
+// completeProviderMarkdown is completeClaudeMarkdown with a single provider,
+// outside of the circuit breaker
+func completeProviderMarkdown(ctx context.Context, provider string, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	switch provider {
+	case providerOpenAI, providerAzureOpenAI:
+		return completeOpenAIMarkdown(ctx, provider, input, prompt, model, maxTokens)
+	case providerGemini:
+		return completeGeminiMarkdown(ctx, input, prompt, model, maxTokens)
+	case providerOllama:
+		return completeOllamaMarkdown(ctx, input, prompt, model, maxTokens)
+	case providerBedrock:
+		return completeBedrockMarkdown(ctx, input, prompt, model, maxTokens)
+	case providerTemplate:
+		return completeTemplateMarkdown(input)
+	}
 
+	sampling := samplingFor(input.Assessment)
 	claudeReq := ClaudeRequest{
-		Model:     "claude-sonnet-4-6",
-		MaxTokens: 8000,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+		Model:         model,
+		MaxTokens:     models.MaxTokens(model, maxTokens),
+		System:        input.System,
+		Messages:      []Message{input.Message(prompt)},
+		Temperature:   sampling.Temperature,
+		TopP:          sampling.TopP,
+		StopSequences: sampling.StopSequences,
 	}
 
 	jsonData, err := json.Marshal(claudeReq)
@@ -505,25 +1091,20 @@ IMPORTANT:
 		return "", fmt.Errorf("failed to marshal Claude request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create Claude request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", claudeAPIKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
-
-	client := &http.Client{Timeout: 90 * time.Second}
-	resp, err := client.Do(req)
+	resp, credential, err := callClaude(ctx, credentialFrom(ctx), claudeCall{
+		Path:        "/v1/messages",
+		ContentType: "application/json",
+		Body:        jsonData,
+		Beta:        input.Beta,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to call Claude API: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("claude API error %d: %s", resp.StatusCode, string(body))
+		return "", &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("request-id")}
 	}
 
 	var claudeResp ClaudeResponse
@@ -531,237 +1112,239 @@ IMPORTANT:
 		return "", fmt.Errorf("failed to decode Claude response: %w", err)
 	}
 
+	if claudeResp.Usage != nil {
+		recordUsage(ctx, model, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens)
+		credential.RecordUsage(model, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens)
+	}
+
 	if len(claudeResp.Content) == 0 {
 		return "", fmt.Errorf("empty response from Claude API")
 	}
 
-	return claudeResp.Content[0].Text, nil
+	markdown := claudeResp.Content[0].Text
+	logGenerationSizes(model, input, prompt, markdown)
+
+	return markdown, nil
 }
 
 // streamMarkdownReportWithClaude generates a streaming analysis report using Claude API
-func streamMarkdownReportWithClaude(data AssessmentData, c *gin.Context) error {
-	// Build the prompt for Claude
-	language := data.Language
-	if language == "" {
-		language = "en"
-	}
-
-	// Count questions with comments
-	commentsCount := 0
-	for _, qa := range data.QuestionsAndAnswers {
-		if qa.Comment != nil && strings.TrimSpace(*qa.Comment) != "" {
-			commentsCount++
-		}
-	}
-
-	completionRate := float64(data.Metadata.AnsweredQuestions) / float64(data.Metadata.TotalQuestions) * 100
-
-	// Convert assessment data to JSON for detailed analysis
-	assessmentJSON, err := json.MarshalIndent(data, "", "  ")
+func streamMarkdownReportWithClaude(ctx context.Context, data AssessmentData, input assessmentInput, version int, c *gin.Context, gen *generation) error {
+	prompt, err := analysisPrompt(data, input, version)
 	if err != nil {
-		return fmt.Errorf("failed to marshal assessment data: %w", err)
+		return err
 	}
-
-	// Map language code to full language name
-	languageNames := map[string]string{
-		"en": "English",
-		"fr": "French",
-		"es": "Spanish",
-		"it": "Italian",
-		"de": "German",
+	if input.System, err = systemPrompt(data, version); err != nil {
+		return err
 	}
 
-	languageName, exists := languageNames[language]
-	if !exists {
-		languageName = "English" // fallback
+	model := routeModel(ctx, modeFullStream).Model
+	markdown, err := streamClaudeMarkdown(ctx, c, gen, input, prompt, model, analysisMaxTokens)
+	if err != nil {
+		return err
+	}
+	if !gen.Cancelled() {
+		warnStructureViolations(ctx, input, prompt, markdown)
+		qualityReview.Offer(data, input, prompt, markdown, model, version)
 	}
 
-	prompt := fmt.Sprintf(`Generate a comprehensive RAADS-R clinical report in structured Markdown format. RESPOND ENTIRELY IN %s LANGUAGE (including section headers) using appropriate clinical terminology.
-
-COMPLETE ASSESSMENT DATA (JSON):
-%s
-
-SUMMARY:
-- Test Date: %s
-- Total Score: %d/%d (Clinical threshold: 65, Neurotypical average: 26)
-- Social Score: %d/%d (Clinical threshold: 30, Neurotypical average: 12.5)
-- Sensory Score: %d/%d (Clinical threshold: 15, Neurotypical average: 6.5)
-- Restricted Score: %d/%d (Clinical threshold: 14, Neurotypical average: 4.5)
-- Language Score: %d/%d (Clinical threshold: 3, Neurotypical average: 2.5)
-- Interpretation: %s - %s
-- Questions answered: %d/%d (%.1f%%)
-- Comments provided: %d
-
-ANALYSIS INSTRUCTIONS:
-1. Review each individual question and answer in the JSON data
-2. Pay special attention to comments provided - these give insight into personal experiences
-3. Analyze patterns across domains (Social, Sensory/Motor, Restricted Interests, Language)
-4. Look for specific behaviors and traits mentioned in comments
-5. Provide clinical insights based on individual responses, not just aggregate scores
-6. Reference specific question numbers and responses where relevant
-7. Provide evidence-based clinical interpretation
-
-REQUIRED MARKDOWN STRUCTURE:
-
-## Executive Summary
-
-Provide a clear summary of the assessment results, including the overall interpretation and key findings.
-
-### Score Overview
-
-Summarize the domain scores and their clinical significance. Do NOT add a table there.
-
-## Detailed Analysis by Domain
-
-### Social Domain Analysis
-
-### Sensory/Motor Domain Analysis  
-
-### Restricted Interests Domain Analysis
-
-### Language Domain Analysis
-
-## Clinical Interpretation and Recommendations
-
-## Notable Response Patterns
-
-Highlight specific questions where responses were particularly informative, especially those with comments that provide personal insights.
-
-## Conclusion
+	return nil
+}
 
-Provide a clear, evidence-based conclusion with actionable recommendations.
+// streamClaudeMarkdown streams the markdown Claude generates for a prompt
+// to the client as chunk events, and returns the complete markdown. It fails
+// fast while the circuit breaker is open. It falls back to the next provider
+// of the chain if any, as long as no text was streamed.
+func streamClaudeMarkdown(ctx context.Context, c *gin.Context, gen *generation, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	restartable := func() bool { return gen.Partial() == "" }
+	return generateWithFallback(ctx, input, prompt, model, restartable, func(provider string, input assessmentInput, prompt, model string) (string, error) {
+		return streamProviderMarkdown(ctx, c, gen, provider, input, prompt, model, maxTokens)
+	})
+}
 
-IMPORTANT:
-- Write in professional clinical language IN %s
-- Use EXACT markdown structure, NO top extra title or section, NO tables
-- Base all analysis on the actual assessment data provided
-- Reference specific question numbers and responses where relevant
-- Include direct quotes from comments when they provide insight
-- Provide evidence-based interpretations
-- Keep analysis objective and clinical
-- Do not make diagnostic statements beyond the scope of the RAADS-R`,
-		languageName,
-		string(assessmentJSON),
-		data.Metadata.TestDate.Format("January 2, 2006"),
-		data.Scores.Total, data.Scores.MaxTotal,
-		data.Scores.Social, data.Scores.MaxSocial,
-		data.Scores.Sensory, data.Scores.MaxSensory,
-		data.Scores.Restricted, data.Scores.MaxRestricted,
-		data.Scores.Language, data.Scores.MaxLanguage,
-		data.Interpretation.Level,
-		data.Interpretation.Description,
-		data.Metadata.AnsweredQuestions, data.Metadata.TotalQuestions, completionRate,
-		commentsCount,
-		languageName)
+// streamProviderMarkdown is streamClaudeMarkdown with a single provider,
+// outside of the circuit breaker
+func streamProviderMarkdown(ctx context.Context, c *gin.Context, gen *generation, provider string, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	switch provider {
+	case providerOpenAI, providerAzureOpenAI:
+		return streamOpenAIMarkdown(ctx, c, gen, provider, input, prompt, model, maxTokens)
+	case providerGemini:
+		return streamGeminiMarkdown(ctx, c, gen, input, prompt, model, maxTokens)
+	case providerOllama:
+		return streamOllamaMarkdown(ctx, c, gen, input, prompt, model, maxTokens)
+	case providerBedrock:
+		return streamBedrockMarkdown(ctx, c, gen, input, prompt, model, maxTokens)
+	case providerTemplate:
+		return streamTemplateMarkdown(ctx, c, gen, input)
+	}
 
+	sampling := samplingFor(input.Assessment)
 	claudeReq := ClaudeRequest{
-		Model:     "claude-haiku-4-5",
-		MaxTokens: 8000,
-		Stream:    true,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+		Model:         model,
+		MaxTokens:     models.MaxTokens(model, maxTokens),
+		Stream:        true,
+		System:        input.System,
+		Messages:      []Message{input.Message(prompt)},
+		Temperature:   sampling.Temperature,
+		TopP:          sampling.TopP,
+		StopSequences: sampling.StopSequences,
 	}
 
 	jsonData, err := json.Marshal(claudeReq)
 	if err != nil {
-		return fmt.Errorf("failed to marshal Claude request: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create Claude request: %w", err)
+		return "", fmt.Errorf("failed to marshal Claude request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", claudeAPIKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
-
-	client := &http.Client{Timeout: 90 * time.Second}
-	resp, err := client.Do(req)
+	resp, credential, err := callClaude(ctx, credentialFrom(ctx), claudeCall{
+		Path:        "/v1/messages",
+		ContentType: "application/json",
+		Body:        jsonData,
+		Beta:        input.Beta,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to call Claude API: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("claude API error %d: %s", resp.StatusCode, string(body))
+		return "", &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("request-id")}
 	}
 
-	// Process the streaming response
-	scanner := bufio.NewScanner(resp.Body)
-	var markdownBuffer strings.Builder
-	lastSentLength := 0
-	lastSendTime := time.Now()
+	markdown, usage, err := forwardMarkdownStream(ctx, c, gen, claudestream.NewReader(resp.Body))
+	recordUsage(ctx, model, usage.InputTokens, usage.OutputTokens)
+	credential.RecordUsage(model, usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		return "", err
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	logGenerationSizes(model, input, prompt, markdown)
 
-		// Claude streams in Server-Sent Events format
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
+	return markdown, nil
+}
 
-			// Skip control messages
-			if data == "[DONE]" {
-				break
-			}
+// markdownEventStream is a parsed stream of generated text
+type markdownEventStream interface {
+	Read(handle func(claudestream.Event) error) error
+	Skipped() int
+}
 
-			// Parse the JSON event
-			var event ClaudeStreamEvent
-			if err := json.Unmarshal([]byte(data), &event); err != nil {
-				log.Printf("⚠️ Failed to parse streaming event: %v", err)
-				continue
+// forwardMarkdownStream forwards the generated text of a stream to the
+// client as markdown chunks, and returns the whole markdown and the token
+// usage reported by the stream
+func forwardMarkdownStream(ctx context.Context, c *gin.Context, gen *generation, stream markdownEventStream) (string, ClaudeUsage, error) {
+	var markdownBuffer streamBuffer
+	defer markdownBuffer.Release()
+	lastSentLength := 0
+	lastSendTime := time.Now()
+	renderErrors := 0
+	lastRendered := true
+	var usage ClaudeUsage
+
+	err := stream.Read(func(event claudestream.Event) error {
+		switch e := event.(type) {
+		case claudestream.Usage:
+			// Token usage is reported at the start and end of the message
+			if e.InputTokens > 0 {
+				usage.InputTokens = e.InputTokens
+			}
+			if e.OutputTokens > 0 {
+				usage.OutputTokens = e.OutputTokens
 			}
 
-			// Handle content delta events
-			if event.Type == "content_block_delta" && event.Delta != nil && event.Delta.Type == "text_delta" {
-				// Accumulate markdown content
-				markdownBuffer.WriteString(event.Delta.Text)
-
-				// Send updates every 100ms or when content grows significantly to avoid overwhelming the client
-				currentLength := markdownBuffer.Len()
-				timeSinceLastSend := time.Since(lastSendTime)
-
-				if currentLength > lastSentLength+50 || timeSinceLastSend > 100*time.Millisecond {
-					// Convert current markdown to HTML and send as chunk
-					var buf bytes.Buffer
-					if err := goldmark.New().Convert([]byte(markdownBuffer.String()), &buf); err == nil {
-						log.Printf("📤 Sending chunk - Length: %d chars, Delta: +%d chars", currentLength, currentLength-lastSentLength)
-						c.SSEvent("chunk", gin.H{
-							"html":     buf.String(),
-							"markdown": markdownBuffer.String(),
-						})
-						c.Writer.Flush()
-
-						lastSentLength = currentLength
-						lastSendTime = time.Now()
-					}
+		case claudestream.Error:
+			return fmt.Errorf("claude stream error: %w", e)
+
+		case claudestream.TextDelta:
+			// Accumulate markdown content, and only forward what was not
+			// sent yet as a delta once the buffer reached its cap
+			inMemory, err := markdownBuffer.Write(e.Text)
+			if err != nil {
+				return err
+			}
+			if !inMemory {
+				delta := e.Text
+				if head := markdownBuffer.Head(); lastSentLength < len(head) {
+					delta = head[lastSentLength:] + delta
 				}
+				sendStreamEvent(c, streamproto.Chunk{Delta: delta, DeltaOnly: true})
+				lastSentLength = markdownBuffer.Len()
+				return nil
+			}
+			gen.setPartial(markdownBuffer.Head())
+
+			// Send updates every 100ms or when content grows significantly to avoid overwhelming the client
+			currentLength := markdownBuffer.Len()
+			timeSinceLastSend := time.Since(lastSendTime)
+
+			if currentLength > lastSentLength+50 || timeSinceLastSend > 100*time.Millisecond {
+				log.Printf("📤 Sending chunk - Length: %d chars, Delta: +%d chars", currentLength, currentLength-lastSentLength)
+				lastRendered = sendMarkdownChunk(c, markdownBuffer.Head(), &renderErrors)
+
+				lastSentLength = currentLength
+				lastSendTime = time.Now()
 			}
 		}
+		return nil
+	})
+	if skipped := stream.Skipped(); skipped > 0 {
+		log.Printf("⚠️ Skipped %d malformed streaming events", skipped)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading streaming response: %w", err)
+	// Past the cap, the partial markdown is only read back from the spill
+	// file once the stream is over
+	markdown, readErr := markdownBuffer.String()
+	if markdownBuffer.Capped() && readErr == nil {
+		gen.setPartial(markdown)
+	}
+	if err != nil {
+		return "", usage, fmt.Errorf("error reading streaming response: %w", err)
+	}
+	if readErr != nil {
+		return "", usage, readErr
 	}
 
-	// Send final chunk with any remaining content
+	// Send final chunk with any remaining content, or retry the rendering
+	// of the last chunk if it could only be sent as markdown
 	finalLength := markdownBuffer.Len()
-	if finalLength > lastSentLength {
-		var buf bytes.Buffer
-		if err := goldmark.New().Convert([]byte(markdownBuffer.String()), &buf); err == nil {
-			log.Printf("📤 Sending FINAL chunk - Total Length: %d chars, Final Delta: +%d chars", finalLength, finalLength-lastSentLength)
-			c.SSEvent("chunk", gin.H{
-				"html":     buf.String(),
-				"markdown": markdownBuffer.String(),
-			})
-			c.Writer.Flush()
-		}
+	if markdownBuffer.Capped() {
+		warningsFrom(ctx).Add(Warning{
+			Code:    warnStreamDeltaOnly,
+			Message: fmt.Sprintf("analysis exceeded %d bytes of markdown, remaining content was forwarded as deltas", streamBufferCap),
+			Section: "analysis",
+		})
+	} else if finalLength > lastSentLength || !lastRendered {
+		log.Printf("📤 Sending FINAL chunk - Total Length: %d chars, Final Delta: +%d chars", finalLength, finalLength-lastSentLength)
+		sendMarkdownChunk(c, markdown, &renderErrors)
 	}
 
-	return nil
+	if renderErrors > 0 {
+		warningsFrom(ctx).Add(Warning{
+			Code:    warnRenderFallback,
+			Message: fmt.Sprintf("%d chunks were sent as markdown only after HTML conversion failures", renderErrors),
+			Section: "analysis",
+		})
+	}
+
+	return markdown, usage, nil
+}
+
+// sendMarkdownChunk sends the accumulated markdown along with its HTML
+// rendering. If the conversion fails, the chunk is still sent with a null
+// HTML and the rendering error, and conversion is attempted again on the
+// next chunk. It reports whether the HTML rendering succeeded.
+func sendMarkdownChunk(c *gin.Context, markdown string, renderErrors *int) bool {
+	chunk := streamproto.Chunk{Markdown: markdown}
+
+	html, err := renderMarkdown(markdown, "stream")
+	if err != nil {
+		*renderErrors++
+		log.Printf("⚠️ Failed to convert markdown chunk to HTML: %v", err)
+		chunk.RenderError = err.Error()
+		chunk.RenderErrors = *renderErrors
+		sendStreamEvent(c, chunk)
+		return false
+	}
+
+	chunk.HTML = &html
+	sendStreamEvent(c, chunk)
+	return true
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/streamproto"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	gin.DefaultWriter = io.Discard
+
+	// Generations go to the fake API each test starts, with placeholder
+	// credentials for every provider
+	claudeAPIKey = "test"
+	openAIAPIKey = "test"
+	azureOpenAIAPIKey = "test"
+	azureOpenAIDeployment = "test-deployment"
+	geminiAPIKey = "test"
+	bedrockRegion = "us-east-1"
+	awsCredentialsCache.current = &awsCredentials{AccessKeyID: "test", SecretAccessKey: "test", Expiration: time.Now().AddDate(1, 0, 0)}
+	if err := loadProvider(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// answeredAssessment is a submission answering every question of the
+// English RAADS-R, made distinct from those of other tests by its context
+func answeredAssessment(t *testing.T, context string) []byte {
+	t.Helper()
+	catalog, err := catalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := make([]gin.H, 0, len(catalog.Questions))
+	for _, q := range catalog.Questions {
+		answers = append(answers, gin.H{"id": q.ID, "answer": q.ID % 4})
+	}
+	body, err := json.Marshal(gin.H{
+		"schemaVersion":     currentSchemaVersion,
+		"language":          "en",
+		"testDate":          time.Date(2024, 3, 17, 10, 0, 0, 0, time.UTC),
+		"answers":           answers,
+		"additionalContext": context,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// serve sends a request to a new router and returns the recorded response
+func serve(t *testing.T, method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	return w
+}
+
+// decodeResponse decodes a JSON response, failing the test unless it has
+// the expected status
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, status int) map[string]any {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d instead of %d: %s", w.Code, status, w.Body.String())
+	}
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	return response
+}
+
+// sseEvent is an event of a Server-Sent Events stream
+type sseEvent struct {
+	ID   string
+	Name string
+	Data string
+}
+
+// readEvents splits a Server-Sent Events stream into its events
+func readEvents(t *testing.T, body io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.Name != "" || event.Data != "" {
+				events = append(events, event)
+			}
+			event = sseEvent{}
+		case strings.HasPrefix(line, "id:"):
+			event.ID = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "event:"):
+			event.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.Data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestAnalyze(t *testing.T) {
+	fake := startFakeClaude(t)
+	body := answeredAssessment(t, "TestAnalyze")
+
+	response := decodeResponse(t, serve(t, "POST", "/analyze", body, nil), 200)
+	if response["success"] != true || response["report_id"] == "" || response["cached"] != false {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if html, _ := response["analysis"].(string); !strings.Contains(html, "simulated section") {
+		t.Errorf("the analysis is not the generated report: %q", html)
+	}
+	if len(fake.Requests) != 1 || fake.Requests[0].Stream {
+		t.Fatalf("%d requests to the API instead of a single one", len(fake.Requests))
+	}
+
+	// The same assessment is served from the cache
+	cached := decodeResponse(t, serve(t, "POST", "/analyze", body, nil), 200)
+	if cached["cached"] != true || len(fake.Requests) != 1 {
+		t.Errorf("the analysis was generated again: cached %v, %d requests", cached["cached"], len(fake.Requests))
+	}
+}
+
+// TestAnalyzeCachedReportIDs makes sure each client gets a report ID of its
+// own for a cached analysis, and keeps it
+func TestAnalyzeCachedReportIDs(t *testing.T) {
+	startFakeClaude(t)
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	body := answeredAssessment(t, "TestAnalyzeCachedReportIDs")
+	analyze := func(token string) string {
+		t.Helper()
+		response := decodeResponse(t, serve(t, "POST", "/analyze", body, map[string]string{"X-Client-Token": token}), 200)
+		return response["report_id"].(string)
+	}
+
+	first := analyze("first")
+	second := analyze("second")
+	if second == first {
+		t.Fatal("a cached analysis is served under the report ID of another client")
+	}
+	if again := analyze("second"); again != second {
+		t.Errorf("the report ID of a client changed from %s to %s", second, again)
+	}
+	if anonymous := analyze(""); anonymous == first || anonymous == second {
+		t.Error("an anonymous client got the report ID of another client")
+	}
+	if w := serve(t, "GET", "/reports/"+second, nil, map[string]string{"X-Client-Token": "second"}); w.Code != 200 {
+		t.Errorf("the report of a cached analysis is not stored for its client: status %d", w.Code)
+	}
+	if w := serve(t, "GET", "/reports/"+first, nil, map[string]string{"X-Client-Token": "second"}); w.Code != 404 {
+		t.Errorf("the report of another client is served: status %d", w.Code)
+	}
+}
+
+func TestAnalyzeInvalidAssessment(t *testing.T) {
+	fake := startFakeClaude(t)
+	tests := map[string]string{
+		"invalid JSON":        `{"language":`,
+		"unknown language":    `{"language":"xx","answers":[{"id":1,"answer":0}]}`,
+		"future format":       fmt.Sprintf(`{"schemaVersion":%d,"language":"en"}`, currentSchemaVersion+1),
+		"out of scale answer": `{"language":"en","answers":[{"id":1,"answer":9}]}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := serve(t, "POST", "/analyze", []byte(body), nil); w.Code != 400 {
+				t.Errorf("status %d instead of 400: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+	if len(fake.Requests) != 0 {
+		t.Errorf("%d invalid assessments were sent to the API", len(fake.Requests))
+	}
+}
+
+func TestAnalyzeUpstreamFailure(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.FailStatus, fake.FailNext = 400, 1
+
+	// An invalid request is not retried and fails the analysis
+	response := decodeResponse(t, serve(t, "POST", "/analyze", answeredAssessment(t, "TestAnalyzeUpstreamFailure"), nil), 500)
+	if response["success"] == true || response["analysis"] != nil {
+		t.Errorf("a failed generation was answered with a report: %v", response)
+	}
+	if len(fake.Requests) != 1 {
+		t.Errorf("%d requests to the API instead of a single one", len(fake.Requests))
+	}
+}
+
+func TestAnalyzeStream(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.ChunkDelay = 0
+
+	w := serve(t, "POST", "/analyze-stream", answeredAssessment(t, "TestAnalyzeStream"), nil)
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	events := readEvents(t, w.Body)
+	if len(events) == 0 || events[0].Name != "metadata" || events[len(events)-1].Name != "complete" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	var markdown string
+	for _, event := range events {
+		if event.Name != "chunk" {
+			continue
+		}
+		var chunk struct {
+			Markdown string `json:"markdown"`
+			Delta    string `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Markdown != "" {
+			markdown = chunk.Markdown
+		}
+	}
+	if !strings.Contains(markdown, "simulated section") {
+		t.Errorf("the streamed chunks do not add up to the report: %q", markdown)
+	}
+	if len(fake.Requests) != 1 || !fake.Requests[0].Stream {
+		t.Errorf("expected a single streaming request to the API, got %d", len(fake.Requests))
+	}
+}
+
+// TestAnalyzeStreamPastBufferCap makes sure an analysis longer than the
+// stream buffer is forwarded as deltas past the cap, and still stored whole
+func TestAnalyzeStreamPastBufferCap(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.ChunkDelay = 0
+	store := &fileReportStore{dir: t.TempDir()}
+	useReportStore(t, store)
+	previous := streamBufferCap
+	streamBufferCap = 256
+	t.Cleanup(func() { streamBufferCap = previous })
+
+	w := serve(t, "POST", "/analyze-stream", answeredAssessment(t, "TestAnalyzeStreamPastBufferCap"), map[string]string{"X-Client-Token": "owner"})
+	var markdown, deltas, reportID string
+	for _, event := range readEvents(t, w.Body) {
+		var chunk struct {
+			ReportID  string `json:"report_id"`
+			Markdown  string `json:"markdown"`
+			Delta     string `json:"delta"`
+			DeltaOnly bool   `json:"delta_only"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case event.Name == "metadata":
+			reportID = chunk.ReportID
+		case event.Name == "chunk" && chunk.DeltaOnly:
+			deltas += chunk.Delta
+		case event.Name == "chunk":
+			markdown = chunk.Markdown
+		}
+	}
+	if deltas == "" || len(markdown) > 256 {
+		t.Fatalf("%d bytes of markdown were resent whole, followed by %d bytes of deltas", len(markdown), len(deltas))
+	}
+
+	report, err := store.Get(context.Background(), reportID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Markdown != markdown+deltas || !strings.Contains(report.Markdown, "## Conclusion") {
+		t.Errorf("the stored analysis is not the streamed one: %d bytes stored, %d streamed", len(report.Markdown), len(markdown+deltas))
+	}
+}
+
+// TestAnalyzeStreamPreflight sends the preflight of the EventSource
+// polyfill for allowed and disallowed origins and headers
+func TestAnalyzeStreamPreflight(t *testing.T) {
+	polyfill := "content-type, cache-control, last-event-id"
+	tests := []struct {
+		name    string
+		origin  string
+		headers string
+		status  int
+		// Origin allowed by the response
+		allowOrigin string
+		// Headers allowed by the response, the allowlist when empty
+		allowHeaders string
+	}{
+		{"allowed origin", "https://raphink.github.io", polyfill, 204, "https://raphink.github.io", polyfill},
+		{"allowed origin without requested headers", "https://raphink.github.io", "", 204, "https://raphink.github.io", ""},
+		{"disallowed origin", "https://example.com", polyfill, 204, "https://raphink.github.io", polyfill},
+		{"disallowed header", "https://raphink.github.io", polyfill + ", x-forwarded-host", 403, "https://raphink.github.io", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string]string{"Origin": tc.origin, "Access-Control-Request-Method": "POST"}
+			if tc.headers != "" {
+				headers["Access-Control-Request-Headers"] = tc.headers
+			}
+			w := serve(t, "OPTIONS", "/analyze-stream", nil, headers)
+			if w.Code != tc.status {
+				t.Fatalf("status %d instead of %d", w.Code, tc.status)
+			}
+			if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != tc.allowOrigin {
+				t.Errorf("origin %q allowed instead of %q", origin, tc.allowOrigin)
+			}
+			allowHeaders := tc.allowHeaders
+			if allowHeaders == "" {
+				allowHeaders = strings.Join(allowedRequestHeaders, ", ")
+			}
+			if headers := w.Header().Get("Access-Control-Allow-Headers"); headers != allowHeaders {
+				t.Errorf("headers %q allowed instead of %q", headers, allowHeaders)
+			}
+			if methods := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "POST") {
+				t.Errorf("methods %q allowed", methods)
+			}
+			if expose := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(expose, streamproto.Header) {
+				t.Errorf("headers %q exposed, without the stream protocol version", expose)
+			}
+			if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "false" {
+				t.Errorf("credentials allowed: %q", credentials)
+			}
+		})
+	}
+}
+
+func TestAnalyzeProviders(t *testing.T) {
+	for _, provider := range []string{providerOpenAI, providerGemini, providerOllama, providerAzureOpenAI, providerBedrock} {
+		t.Run(provider, func(t *testing.T) {
+			startFakeClaude(t)
+			previous := activeProvider
+			activeProvider = provider
+			t.Cleanup(func() { activeProvider = previous })
+			if err := loadProviderConfig(provider); err != nil {
+				t.Fatal(err)
+			}
+
+			response := decodeResponse(t, serve(t, "POST", "/analyze", answeredAssessment(t, "TestAnalyzeProviders "+provider), nil), 200)
+			if html, _ := response["analysis"].(string); !strings.Contains(html, "simulated") {
+				t.Errorf("the analysis is not the generated report: %q", html)
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	response := decodeResponse(t, serve(t, "GET", "/health", nil, nil), http.StatusOK)
+	if response["service"] != "raads-r-pdf-service" {
+		t.Errorf("unexpected health response: %v", response)
+	}
+}
+
+// TestHealthRequiredConfiguration makes sure a deployment with only the
+// required configuration, without a signing key, a watermark or a PDF
+// engine, is healthy
+func TestHealthRequiredConfiguration(t *testing.T) {
+	previous := reportSigningKey
+	reportSigningKey = nil
+	t.Cleanup(func() { reportSigningKey = previous })
+	response := decodeResponse(t, serve(t, "GET", "/health", nil, nil), http.StatusOK)
+	if response["status"] != "healthy" || len(response["degraded"].(map[string]any)) != 0 {
+		t.Errorf("reported as %v: %v", response["status"], response["degraded"])
+	}
+}
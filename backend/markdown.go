@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	// Deepest blockquote nesting kept, deeper markers are dropped
+	markdownMaxNesting = envInt("MARKDOWN_MAX_NESTING", 8)
+
+	// Render failures kept for the admin diagnostics endpoint
+	renderDiagnosticsSize = envInt("RENDER_DIAGNOSTICS_SIZE", 50)
+)
+
+// Bytes of markdown kept per render failure
+const renderDiagnosticMaxBytes = 4096
+
+var renderFailures atomic.Int64
+
+// markdownParser parses analyses without raw HTML, neither as blocks nor
+// inline, so that HTML written by Claude, or injected through comments,
+// is never interpreted. Unsafe rendering is off as well.
+var markdownParser = parser.NewParser(
+	parser.WithBlockParsers(withoutParsers(parser.DefaultBlockParsers(), parser.NewHTMLBlockParser())...),
+	parser.WithInlineParsers(withoutParsers(parser.DefaultInlineParsers(), parser.NewRawHTMLParser())...),
+	parser.WithParagraphTransformers(parser.DefaultParagraphTransformers()...),
+)
+
+var markdownRenderer = goldmark.New(goldmark.WithParser(markdownParser))
+
+// withoutParsers filters parsers out of a default parser list by type
+func withoutParsers(parsers []util.PrioritizedValue, excluded ...any) []util.PrioritizedValue {
+	kept := make([]util.PrioritizedValue, 0, len(parsers))
+	for _, p := range parsers {
+		skip := false
+		for _, e := range excluded {
+			if reflect.TypeOf(p.Value) == reflect.TypeOf(e) {
+				skip = true
+			}
+		}
+		if !skip {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// limitMarkdownNesting drops blockquote markers beyond the maximum depth
+func limitMarkdownNesting(markdown string) string {
+	if strings.Count(markdown, ">") <= markdownMaxNesting {
+		return markdown
+	}
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		depth, end := 0, 0
+		for j, r := range line {
+			if r == '>' {
+				depth++
+			} else if r != ' ' && r != '\t' {
+				break
+			}
+			end = j + 1
+		}
+		if depth > markdownMaxNesting {
+			lines[i] = strings.Repeat("> ", markdownMaxNesting) + strings.TrimLeft(line[end:], " \t")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseMarkdown parses markdown into an AST, recovering from parser panics
+func parseMarkdown(source []byte) (doc ast.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("markdown parser panicked: %v", r)
+		}
+	}()
+	return markdownParser.Parse(text.NewReader(source)), nil
+}
+
+// renderMarkdown converts markdown to HTML. A conversion that fails or
+// panics is retried once with the markdown flattened to plain paragraphs;
+// if that fails too, the input is captured for diagnostics and the caller
+// falls back to sending markdown only.
+func renderMarkdown(markdown, section string) (string, error) {
+	source := limitMarkdownNesting(markdown)
+	html, err := convertMarkdown(source)
+	if err == nil {
+		return html, nil
+	}
+	if retried, retryErr := convertMarkdown(flattenMarkdown(source)); retryErr == nil {
+		renderDiagnostics.Capture(section, markdown, err, true)
+		return retried, nil
+	}
+	renderDiagnostics.Capture(section, markdown, err, false)
+	return "", err
+}
+
+func convertMarkdown(markdown string) (html string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("markdown renderer panicked: %v", r)
+		}
+	}()
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(markdown), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// flattenMarkdown strips the block markers most likely to trip the
+// renderer, leaving headings, lists and emphasis untouched
+func flattenMarkdown(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, "> \t")
+	}
+	return strings.ReplaceAll(strings.Join(lines, "\n"), "<", "&lt;")
+}
+
+// RenderDiagnostic is a markdown conversion failure, with the offending
+// markdown redacted and truncated
+type RenderDiagnostic struct {
+	ID         string    `json:"id"`
+	Section    string    `json:"section"`
+	Error      string    `json:"error"`
+	Recovered  bool      `json:"recovered"`
+	Length     int       `json:"length"`
+	Markdown   string    `json:"markdown"`
+	Truncated  bool      `json:"truncated"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+// renderDiagnosticBuffer keeps the most recent render failures
+type renderDiagnosticBuffer struct {
+	mu      sync.Mutex
+	entries []RenderDiagnostic
+}
+
+var renderDiagnostics = &renderDiagnosticBuffer{}
+
+// Capture records a render failure, dropping the oldest one when full.
+// Recovered failures were rendered by the retry.
+func (b *renderDiagnosticBuffer) Capture(section, markdown string, err error, recovered bool) {
+	renderFailures.Add(1)
+	diagnostic := RenderDiagnostic{
+		ID:         uuid.New().String(),
+		Section:    section,
+		Error:      err.Error(),
+		Recovered:  recovered,
+		Length:     len(markdown),
+		Markdown:   redactPII(markdown),
+		CapturedAt: time.Now().UTC(),
+	}
+	if len(diagnostic.Markdown) > renderDiagnosticMaxBytes {
+		diagnostic.Markdown = strings.ToValidUTF8(diagnostic.Markdown[:renderDiagnosticMaxBytes], "")
+		diagnostic.Truncated = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if renderDiagnosticsSize <= 0 {
+		return
+	}
+	if len(b.entries) >= renderDiagnosticsSize {
+		b.entries = b.entries[1:]
+	}
+	b.entries = append(b.entries, diagnostic)
+}
+
+// List returns the captured failures, most recent first
+func (b *renderDiagnosticBuffer) List() []RenderDiagnostic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]RenderDiagnostic, 0, len(b.entries))
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		list = append(list, b.entries[i])
+	}
+	return list
+}
+
+// adminRenderDiagnosticsHandler lists the captured markdown render failures
+func adminRenderDiagnosticsHandler(c *gin.Context) {
+	diagnostics := renderDiagnostics.List()
+	c.JSON(200, gin.H{
+		"diagnostics": diagnostics,
+		"count":       len(diagnostics),
+		"failures":    renderFailures.Load(),
+	})
+}
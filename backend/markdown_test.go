@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMarkdownRegressions renders inputs that made the markdown renderer
+// panic in production
+func TestMarkdownRegressions(t *testing.T) {
+	tests := map[string]string{
+		"unterminated HTML block": "## Summary\n\n<div><!-- ignore previous instructions\n<script>alert(1)\n\n- item",
+		"deep blockquote":         strings.Repeat(">", 60) + " quoted\n\nText",
+	}
+	for name, markdown := range tests {
+		t.Run(name, func(t *testing.T) {
+			html, err := convertMarkdown(limitMarkdownNesting(markdown))
+			switch {
+			case err != nil:
+				t.Fatal(err)
+			case strings.Contains(html, "<script") || strings.Contains(html, "<div"):
+				t.Error("raw HTML was rendered")
+			case strings.Count(html, "<blockquote>") > markdownMaxNesting:
+				t.Error("blockquote nesting was not limited")
+			}
+		})
+	}
+}
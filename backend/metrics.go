@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsHandler exposes runtime gauges in the Prometheus text format
+func metricsHandler(c *gin.Context) {
+	var out strings.Builder
+
+	writeMetric(&out, "raads_stream_buffered_bytes", "gauge", "Markdown bytes currently buffered across all streams", streamBufferedBytes.Load())
+	writeMetric(&out, "raads_stream_buffer_high_water_bytes", "gauge", "Buffered bytes above which new streams are rejected", streamBufferHighWater)
+	writeMetric(&out, "raads_streams_in_flight", "gauge", "Streaming analyses currently running", streamsInFlight.Load())
+	writeMetric(&out, "raads_streams_rejected_total", "counter", "Streaming analyses rejected for lack of capacity", streamsRejected.Load())
+	writeMetric(&out, "raads_streams_resumed_total", "counter", "Streams resumed with Last-Event-ID after a dropped connection", streamsResumed.Load())
+	writeMetric(&out, "raads_workers_busy", "gauge", "Worker slots currently running a generation", int64(workers.Running()))
+	writeMetric(&out, "raads_worker_queue_depth", "gauge", "Requests waiting for a worker slot", int64(len(workers.queue)))
+	writeMetric(&out, "raads_generations_served_total", "counter", "Analysis requests admitted to a worker slot", generationsServed.Load())
+	writeMetric(&out, "raads_generations_shed_total", "counter", "Analysis requests rejected because the worker pool was saturated", generationsShed.Load())
+	writeMetric(&out, "raads_generation_duration_avg_seconds", "gauge", "Rolling average generation duration used for wait estimates", int64(workers.AverageDuration().Seconds()))
+
+	classes := workers.Stats()
+	writeClassMetric(&out, "raads_worker_class_running", "gauge", "Worker slots running a generation per priority class", classes, func(s ClassStats) float64 { return float64(s.Running) })
+	writeClassMetric(&out, "raads_worker_class_reserved", "gauge", "Worker slots reserved per priority class", classes, func(s ClassStats) float64 { return float64(s.Reserved) })
+	writeClassMetric(&out, "raads_worker_class_queue_depth", "gauge", "Requests waiting for a worker slot per priority class", classes, func(s ClassStats) float64 { return float64(s.QueueDepth) })
+	writeClassMetric(&out, "raads_worker_class_queue_waits_total", "counter", "Requests admitted after queueing per priority class", classes, func(s ClassStats) float64 { return float64(s.Waits) })
+	writeClassMetric(&out, "raads_worker_class_queue_wait_seconds_total", "counter", "Time spent queued by admitted requests per priority class", classes, func(s ClassStats) float64 { return s.WaitTotalSeconds })
+
+	writeRouteMetric(&out, "raads_generations_routed_total", "Generations per mode and the model they were routed to")
+
+	writeMetric(&out, "raads_structure_retries_total", "counter", "Generations requested again because they did not follow the required structure", structureRetries.Load())
+	writeMetric(&out, "raads_structure_failures_total", "counter", "Generations that did not follow the required structure, even once requested again", structureFailures.Load())
+	writeMetric(&out, "raads_markdown_render_failures_total", "counter", "Markdown to HTML conversions that failed or panicked", renderFailures.Load())
+
+	current, stale := analysisCache.Stats()
+	writeMetric(&out, "raads_prompt_version", "gauge", "Version of the analysis prompts", promptVersion)
+	var candidateVersion, candidatePercent int64
+	if promptCandidateActive() {
+		candidateVersion, candidatePercent = int64(promptCandidateVersion), int64(promptCandidatePercent)
+	}
+	writeMetric(&out, "raads_prompt_candidate_version", "gauge", "Version of the candidate analysis prompts, 0 without a split", candidateVersion)
+	writeMetric(&out, "raads_prompt_candidate_percent", "gauge", "Percentage of assessments analyzed with the candidate prompts", candidatePercent)
+	writeMetric(&out, "raads_analysis_cache_current", "gauge", "Cached analyses generated with the current prompt version", int64(current))
+	writeMetric(&out, "raads_analysis_cache_stale", "gauge", "Cached analyses generated with an older prompt version", int64(stale))
+	writeMetric(&out, "raads_analysis_stale_serves_total", "counter", "Cached analyses served while generated with an older prompt version", staleServes.Load())
+	writeMetric(&out, "raads_analysis_revalidations_started_total", "counter", "Background regenerations of stale analyses started", revalidationsStarted.Load())
+	writeMetric(&out, "raads_analysis_revalidations_completed_total", "counter", "Background regenerations of stale analyses stored as a new revision", revalidationsCompleted.Load())
+	writeMetric(&out, "raads_analysis_revalidations_failed_total", "counter", "Background regenerations of stale analyses that failed", revalidationsFailed.Load())
+
+	state, opened := claudeBreaker.State()
+	var breakerOpenGauge int64
+	if state != breakerClosed {
+		breakerOpenGauge = 1
+	}
+	writeMetric(&out, "raads_ai_breaker_open", "gauge", "Whether generations fail fast because the AI service is down", breakerOpenGauge)
+	writeMetric(&out, "raads_ai_breaker_opened_total", "counter", "Times the circuit breaker opened after repeated upstream failures", opened)
+
+	writeFallbackMetric(&out, "raads_provider_fallbacks_total", "Generations that fell back to a provider of the chain")
+
+	writeMetric(&out, "raads_claude_credential_failovers_total", "counter", "Requests retried with the failover credential", credentialFailovers.Load())
+
+	credentials := claudeCredentials.List()
+	writeCredentialMetric(&out, "raads_claude_credential_requests_total", "Claude API requests sent per credential", credentials, func(c *claudeCredential) int64 { return c.requests.Load() })
+	writeCredentialMetric(&out, "raads_claude_credential_rejections_total", "Claude API requests rejected for authentication or quota reasons per credential", credentials, func(c *claudeCredential) int64 { return c.failures.Load() })
+	writeCredentialMetric(&out, "raads_claude_credential_input_tokens_total", "Input tokens consumed per credential", credentials, func(c *claudeCredential) int64 { return c.inputTokens.Load() })
+	writeCredentialMetric(&out, "raads_claude_credential_output_tokens_total", "Output tokens consumed per credential", credentials, func(c *claudeCredential) int64 { return c.outputTokens.Load() })
+
+	c.Data(200, "text/plain; version=0.0.4", []byte(out.String()))
+}
+
+func writeMetric(out *strings.Builder, name, kind, help string, value int64) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func writeCredentialMetric(out *strings.Builder, name, help string, credentials []*claudeCredential, value func(*claudeCredential) int64) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, credential := range credentials {
+		fmt.Fprintf(out, "%s{credential=%q} %d\n", name, credential.Name, value(credential))
+	}
+}
+
+func writeClassMetric(out *strings.Builder, name, kind, help string, stats []ClassStats, value func(ClassStats) float64) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range stats {
+		fmt.Fprintf(out, "%s{class=%q} %g\n", name, s.Class, value(s))
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed models.json
+var embeddedModelRegistry []byte
+
+// ModelInfo describes the capabilities and pricing of a Claude model
+type ModelInfo struct {
+	ID                    string  `json:"id"`
+	MaxOutputTokens       int     `json:"max_output_tokens"`
+	ContextWindow         int     `json:"context_window"`
+	InputPricePerMTok     float64 `json:"input_price_per_mtok"`
+	OutputPricePerMTok    float64 `json:"output_price_per_mtok"`
+	SupportsPromptCaching bool    `json:"supports_prompt_caching"`
+	DeprecationDate       string  `json:"deprecation_date,omitempty"`
+}
+
+// Deprecated reports whether the model is past its deprecation date
+func (m ModelInfo) Deprecated(now time.Time) bool {
+	if m.DeprecationDate == "" {
+		return false
+	}
+	date, err := time.Parse("2006-01-02", m.DeprecationDate)
+	if err != nil {
+		log.Printf("⚠️  Invalid deprecation date %q for model %s", m.DeprecationDate, m.ID)
+		return false
+	}
+	return !now.Before(date)
+}
+
+// Cost returns the price in USD of a request with the given token counts
+func (m ModelInfo) Cost(inputTokens, outputTokens int) float64 {
+	return float64(inputTokens)*m.InputPricePerMTok/1e6 + float64(outputTokens)*m.OutputPricePerMTok/1e6
+}
+
+// modelUsage accumulates the usage of a model over a single day
+type modelUsage struct {
+	Date         string  `json:"date"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost_usd"`
+}
+
+type modelRegistry struct {
+	mu     sync.Mutex
+	models map[string]ModelInfo
+	usage  map[string]*modelUsage
+}
+
+var (
+	// Model used for full analyses, and the one used for streaming, the
+	// same unless CLAUDE_STREAM_MODEL is set so that a streamed analysis
+	// reads like the one of /analyze
+	analysisModel = envString("CLAUDE_MODEL", "claude-sonnet-4-6")
+	streamModel   = envString("CLAUDE_STREAM_MODEL", analysisModel)
+
+	// Models tried in order when the configured one is deprecated
+	fallbackModels = strings.Split(envString("CLAUDE_FALLBACK_MODELS", "claude-sonnet-4-6,claude-haiku-4-5"), ",")
+
+	// Output tokens requested for full analyses, streamed or not, clamped
+	// to the limit of the model
+	analysisMaxTokens = envInt("CLAUDE_MAX_TOKENS", 8000)
+
+	models = loadModelRegistry()
+)
+
+// loadModelRegistry reads the embedded registry, then applies the entries of
+// MODEL_REGISTRY_FILE on top of it when configured
+func loadModelRegistry() *modelRegistry {
+	registry := &modelRegistry{
+		models: map[string]ModelInfo{},
+		usage:  map[string]*modelUsage{},
+	}
+
+	if err := registry.merge(embeddedModelRegistry); err != nil {
+		panic(fmt.Sprintf("invalid embedded model registry: %v", err))
+	}
+
+	if path := os.Getenv("MODEL_REGISTRY_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("⚠️  Failed to read model registry %s: %v", path, err)
+		} else if err := registry.merge(content); err != nil {
+			log.Printf("⚠️  Failed to load model registry %s: %v", path, err)
+		}
+	}
+
+	return registry
+}
+
+func (r *modelRegistry) merge(content []byte) error {
+	var entries []ModelInfo
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range entries {
+		r.models[entry.ID] = entry
+	}
+	return nil
+}
+
+// Get returns the registry entry for a model
+func (r *modelRegistry) Get(id string) (ModelInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	model, ok := r.models[id]
+	return model, ok
+}
+
+// Resolve returns the model to use in place of the requested one, skipping
+// to the first non-deprecated fallback when it is deprecated
+func (r *modelRegistry) Resolve(id string) string {
+	now := time.Now()
+	if model, ok := r.Get(id); !ok || !model.Deprecated(now) {
+		return id
+	}
+	for _, fallback := range fallbackModels {
+		fallback = strings.TrimSpace(fallback)
+		if model, ok := r.Get(fallback); ok && !model.Deprecated(now) {
+			log.Printf("⚠️  Model %s is deprecated, using %s instead", id, fallback)
+			return fallback
+		}
+	}
+	return id
+}
+
+// MaxTokens clamps the requested output tokens to the model's limit
+func (r *modelRegistry) MaxTokens(id string, requested int) int {
+	if model, ok := r.Get(id); ok && model.MaxOutputTokens > 0 && requested > model.MaxOutputTokens {
+		return model.MaxOutputTokens
+	}
+	return requested
+}
+
+// RecordUsage adds a request's token usage to today's totals for the model
+func (r *modelRegistry) RecordUsage(id string, inputTokens, outputTokens int) {
+	today := time.Now().UTC().Format("2006-01-02")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage, ok := r.usage[id]
+	if !ok || usage.Date != today {
+		usage = &modelUsage{Date: today}
+		r.usage[id] = usage
+	}
+	usage.Requests++
+	usage.InputTokens += inputTokens
+	usage.OutputTokens += outputTokens
+	usage.Cost += r.models[id].Cost(inputTokens, outputTokens)
+}
+
+// List returns all models sorted by id, with today's usage
+func (r *modelRegistry) List() []gin.H {
+	today := time.Now().UTC().Format("2006-01-02")
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(r.models))
+	for id := range r.models {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]gin.H, 0, len(ids))
+	for _, id := range ids {
+		usage := modelUsage{Date: today}
+		if u, ok := r.usage[id]; ok && u.Date == today {
+			usage = *u
+		}
+		list = append(list, gin.H{
+			"model":      r.models[id],
+			"deprecated": r.models[id].Deprecated(now),
+			"usage":      usage,
+		})
+	}
+	return list
+}
+
+// checkModelRegistry warns when a configured model is unknown or deprecated
+func checkModelRegistry() checkResult {
+	result := checkResult{Name: "model registry", Optional: true}
+	var problems []string
+	for _, id := range []string{analysisModel, streamModel} {
+		model, ok := models.Get(id)
+		switch {
+		case !ok:
+			problems = append(problems, id+" is not in the registry")
+		case model.Deprecated(time.Now()):
+			problems = append(problems, fmt.Sprintf("%s is deprecated since %s", id, model.DeprecationDate))
+		case model.MaxOutputTokens > 0 && analysisMaxTokens > model.MaxOutputTokens:
+			problems = append(problems, fmt.Sprintf("CLAUDE_MAX_TOKENS is clamped to the %d output tokens of %s", model.MaxOutputTokens, id))
+		}
+	}
+	if len(problems) > 0 {
+		result.Detail = strings.Join(problems, "; ")
+		return result
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("%s and %s available", analysisModel, streamModel)
+	return result
+}
+
+// checkTokenLimits verifies the output token limits of generations
+func checkTokenLimits() checkResult {
+	result := checkResult{Name: "token limits", Feature: "analysis"}
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"CLAUDE_MAX_TOKENS", analysisMaxTokens},
+		{"DOMAIN_REPORT_MAX_TOKENS", domainReportMaxTokens},
+	} {
+		if limit.value <= 0 {
+			result.Detail = fmt.Sprintf("%s must be positive, got %d", limit.name, limit.value)
+			return result
+		}
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("%d output tokens per analysis, %d per domain report", analysisMaxTokens, domainReportMaxTokens)
+	return result
+}
+
+// adminModelsHandler lists the model registry along with today's usage
+func adminModelsHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"analysis_model": analysisModel,
+		"stream_model":   streamModel,
+		"max_tokens":     analysisMaxTokens,
+		"routes":         modelRoutes,
+		"overrides":      modelOverrideAllowlist,
+		"models":         models.List(),
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Normative datasets bundle the clinical thresholds and the population
+// norms scores are interpreted with. Each JSON file of NORMS_DIR adds a
+// dataset, or replaces the embedded one with the same key, and
+// NORMS_DATASET selects the one in use, so that a deployment can use other
+// norms, e.g. of another country, without code changes.
+//
+//go:embed norms/*.json
+var embeddedNormativeDatasets embed.FS
+
+var (
+	normsDir                              = os.Getenv("NORMS_DIR")
+	normsDatasetKey                       = envString("NORMS_DATASET", defaultNormativeDataset)
+	normativeDatasets                     = map[string]NormativeDataset{}
+	normativeDataset, normativeDatasetErr = loadNormativeDatasets()
+)
+
+const defaultNormativeDataset = "ritvo2011"
+
+// NormativeDataset is a published set of norms: the thresholds of the total
+// score ("total") and of the domains, by domain key, and the mean and
+// standard deviation of the scores in populations. Populations with a
+// profile label are reference profiles scores can be compared against,
+// the default one giving the neurotypical means.
+type NormativeDataset struct {
+	Key            string            `json:"key"`
+	Label          string            `json:"label"`
+	Source         string            `json:"source"`
+	Thresholds     map[string]int    `json:"thresholds"`
+	DefaultProfile string            `json:"defaultProfile"`
+	Populations    []NormativeSample `json:"populations"`
+}
+
+// loadNormativeDatasets reads the embedded datasets, then those of
+// NORMS_DIR on top of them, and applies the one selected by NORMS_DATASET
+func loadNormativeDatasets() (NormativeDataset, error) {
+	files, err := fs.Glob(embeddedNormativeDatasets, "norms/*.json")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		content, _ := embeddedNormativeDatasets.ReadFile(file)
+		if err := addNormativeDataset(file, content); err != nil {
+			panic(fmt.Sprintf("invalid embedded normative dataset: %v", err))
+		}
+	}
+
+	if normsDir != "" {
+		files, err := filepath.Glob(filepath.Join(normsDir, "*.json"))
+		if err != nil {
+			return NormativeDataset{}, fmt.Errorf("failed to list normative datasets: %w", err)
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return NormativeDataset{}, fmt.Errorf("failed to read normative dataset: %w", err)
+			}
+			if err := addNormativeDataset(file, content); err != nil {
+				return NormativeDataset{}, err
+			}
+		}
+	}
+
+	dataset, ok := normativeDatasets[normsDatasetKey]
+	if !ok {
+		return NormativeDataset{}, fmt.Errorf("unknown normative dataset %q (available: %s)", normsDatasetKey, strings.Join(normativeDatasetKeys(), ", "))
+	}
+	dataset.apply()
+	if err := validateInterpretationRules(interpretationRules, raadsMaxTotal); err != nil {
+		return NormativeDataset{}, fmt.Errorf("invalid normative dataset %s: %w", dataset.Key, err)
+	}
+	return dataset, nil
+}
+
+// addNormativeDataset parses and validates a dataset file
+func addNormativeDataset(file string, content []byte) error {
+	var dataset NormativeDataset
+	if err := json.Unmarshal(content, &dataset); err != nil {
+		return fmt.Errorf("failed to parse normative dataset %s: %w", file, err)
+	}
+	if err := dataset.validate(); err != nil {
+		return fmt.Errorf("invalid normative dataset %s: %w", file, err)
+	}
+	normativeDatasets[dataset.Key] = dataset
+	return nil
+}
+
+// validate checks that the dataset has a threshold for the total and every
+// domain, within the range of its score, and that its default profile is
+// one of its populations
+func (d NormativeDataset) validate() error {
+	if d.Key == "" || d.Label == "" {
+		return fmt.Errorf("normative dataset %q lacks a key or label", d.Key)
+	}
+	keys := []string{"total"}
+	for _, domain := range raadsDomains {
+		keys = append(keys, domain.Key)
+	}
+	for _, key := range keys {
+		if _, ok := d.Thresholds[key]; !ok {
+			return fmt.Errorf("no %s threshold", key)
+		}
+	}
+	if err := (clinicalConfig{Thresholds: d.Thresholds}).validate(); err != nil {
+		return err
+	}
+	if len(d.Populations) == 0 {
+		return fmt.Errorf("no population")
+	}
+	if err := validateNormativeSamples(d.samples()); err != nil {
+		return err
+	}
+	for _, p := range d.Populations {
+		if p.Key == d.DefaultProfile {
+			if p.ProfileLabel == "" {
+				return fmt.Errorf("default profile %s has no profile label", p.Key)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("default profile %q is not one of the populations", d.DefaultProfile)
+}
+
+// samples are the populations of the dataset, with the source of the
+// dataset unless they have their own
+func (d NormativeDataset) samples() []NormativeSample {
+	samples := make([]NormativeSample, 0, len(d.Populations))
+	for _, p := range d.Populations {
+		if p.Source == "" {
+			p.Source = d.Source
+		}
+		samples = append(samples, p)
+	}
+	return samples
+}
+
+// apply makes the dataset the one in use: its thresholds move those of the
+// domains, the total and the interpretation rules, and its populations
+// with a profile label become the reference profiles
+func (d NormativeDataset) apply() {
+	if threshold := d.Thresholds["total"]; threshold != totalThreshold {
+		moveInterpretationBoundary(totalThreshold, threshold)
+		totalThreshold = threshold
+	}
+	referenceProfiles = map[string]ReferenceProfile{}
+	for _, s := range d.samples() {
+		if s.ProfileLabel == "" {
+			continue
+		}
+		profile := ReferenceProfile{Key: s.Key, Label: s.ProfileLabel, Source: s.Source, Total: s.Total.Mean, Domains: map[string]float64{}}
+		for key, stat := range s.Domains {
+			profile.Domains[key] = stat.Mean
+		}
+		referenceProfiles[s.Key] = profile
+	}
+	for i := range raadsDomains {
+		domain := &raadsDomains[i]
+		domain.Threshold = d.Thresholds[domain.Key]
+		domain.NTMean = referenceProfiles[d.DefaultProfile].Domains[domain.Key]
+	}
+}
+
+func normativeDatasetKeys() []string {
+	keys := make([]string, 0, len(normativeDatasets))
+	for key := range normativeDatasets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normsHandler lists the normative datasets, with the one in use and its
+// reference profiles, for clients to offer the profiles it supports
+func normsHandler(c *gin.Context) {
+	datasets := make([]gin.H, 0, len(normativeDatasets))
+	for _, key := range normativeDatasetKeys() {
+		d := normativeDatasets[key]
+		datasets = append(datasets, gin.H{
+			"key":         d.Key,
+			"label":       d.Label,
+			"source":      d.Source,
+			"populations": len(d.Populations),
+			"active":      d.Key == normativeDataset.Key,
+		})
+	}
+	profiles := make([]ReferenceProfile, 0, len(referenceProfiles))
+	for _, key := range referenceProfileKeys() {
+		profiles = append(profiles, referenceProfiles[key])
+	}
+	c.JSON(200, gin.H{
+		"active":          normativeDataset.Key,
+		"datasets":        datasets,
+		"thresholds":      clinicalThresholds(),
+		"default_profile": normativeDataset.DefaultProfile,
+		"profiles":        profiles,
+	})
+}
+
+// clinicalThresholds are the thresholds in use, after CLINICAL_CONFIG_FILE
+func clinicalThresholds() map[string]int {
+	thresholds := map[string]int{"total": totalThreshold}
+	for _, d := range raadsDomains {
+		thresholds[d.Key] = d.Threshold
+	}
+	return thresholds
+}
+
+// checkNormativeDatasets verifies the datasets and reports the one in use
+func checkNormativeDatasets() checkResult {
+	result := checkResult{Name: "normative datasets", Feature: "analysis"}
+	if normativeDatasetErr != nil {
+		result.Detail = normativeDatasetErr.Error()
+		return result
+	}
+	if _, err := referenceProfileFor(""); err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("%s in use, among %s", normativeDataset.Key, strings.Join(normativeDatasetKeys(), ", "))
+	if normsDir != "" {
+		result.Detail += " (from " + normsDir + ")"
+	}
+	return result
+}
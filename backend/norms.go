@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// ReferenceProfile is a named set of published group means that scores are
+// compared against
+type ReferenceProfile struct {
+	Key string `json:"key"`
+	// Label introduces the means, e.g. in the prompt and chart legends
+	Label  string  `json:"label"`
+	Source string  `json:"source"`
+	Total  float64 `json:"total"`
+	// Means per domain key
+	Domains map[string]float64 `json:"domains"`
+}
+
+// Reference profiles of the normative dataset in use, by key
+var referenceProfiles = map[string]ReferenceProfile{}
+
+// referenceProfileFor returns the profile with the given key, or the
+// default profile of the normative dataset when key is empty
+func referenceProfileFor(key string) (ReferenceProfile, error) {
+	if key == "" {
+		key = normativeDataset.DefaultProfile
+	}
+	profile, ok := referenceProfiles[key]
+	if !ok {
+		return ReferenceProfile{}, fmt.Errorf("unknown reference profile %q (available: %v)", key, referenceProfileKeys())
+	}
+	return profile, nil
+}
+
+// Mean returns the profile mean for a domain key, or for the total score
+// when key is "total"
+func (p ReferenceProfile) Mean(key string) float64 {
+	if key == "total" {
+		return p.Total
+	}
+	return p.Domains[key]
+}
+
+// Describe formats a mean for the prompt, e.g. "Neurotypical average: 12.5"
+func (p ReferenceProfile) Describe(key string) string {
+	return p.Label + ": " + strconv.FormatFloat(p.Mean(key), 'f', -1, 64)
+}
+
+func referenceProfileKeys() []string {
+	keys := make([]string, 0, len(referenceProfiles))
+	for key := range referenceProfiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// referenceProfileMetadata describes the profile an assessment is compared
+// against, for response metadata. Assessments are validated beforehand.
+func referenceProfileMetadata(data AssessmentData) streamproto.ReferenceProfile {
+	profile, _ := referenceProfileFor(data.ReferenceProfile)
+	return streamproto.ReferenceProfile{
+		Key:    profile.Key,
+		Label:  profile.Label,
+		Source: profile.Source,
+	}
+}
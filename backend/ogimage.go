@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph image size recommended by the major link preview consumers
+const (
+	ogImageWidth  = 1200
+	ogImageHeight = 630
+)
+
+var (
+	// Renders kept in memory, keyed by score and language
+	ogImageCacheSize = envInt("OG_IMAGE_CACHE_SIZE", 256)
+
+	// Renders allowed per client IP and minute, as the endpoint is public
+	ogImageRatePerMinute = envInt("OG_IMAGE_RATE_PER_MINUTE", 30)
+)
+
+// scoreBand is a range of the total score shown on the interpretation scale
+type scoreBand struct {
+	Key   string
+	Min   int
+	Max   int
+	Color color.RGBA
+}
+
+// Interpretation scale bands, matching the results page
+var scoreBands = []scoreBand{
+	{"none", 0, 24, color.RGBA{0xb9, 0xdf, 0xc9, 0xff}},
+	{"possible", 25, 64, color.RGBA{0xff, 0xe8, 0x9c, 0xff}},
+	{"likely", 65, 129, color.RGBA{0xf3, 0xb7, 0xbd, 0xff}},
+	{"strong", 130, 240, color.RGBA{0xb5, 0xb8, 0xbb, 0xff}},
+}
+
+// Band labels, mirroring "scaleLabels" in the frontend language catalogs
+var scoreBandLabels = map[string]map[string]string{
+	"en": {"none": "No ASD", "possible": "Possible traits", "likely": "Possible ASD", "strong": "Strong indication"},
+	"fr": {"none": "Pas de TSA", "possible": "Traits possibles", "likely": "TSA possible", "strong": "Forte présomption"},
+	"es": {"none": "Sin TEA", "possible": "Rasgos posibles", "likely": "TEA posible", "strong": "Fuerte indicación"},
+	"it": {"none": "Nessun DSA", "possible": "Tratti possibili", "likely": "DSA possibile", "strong": "Forte indicazione"},
+	"de": {"none": "Keine ASS", "possible": "Mögliche Merkmale", "likely": "ASS möglich", "strong": "Starke Hinweise"},
+	"ru": {"none": "Нет РАС", "possible": "Возможные черты", "likely": "Возможен РАС", "strong": "Сильная индикация"},
+}
+
+// Localized "Total Score" captions
+var totalScoreLabels = map[string]string{
+	"en": "Total Score",
+	"fr": "Score Total",
+	"es": "Puntuación Total",
+	"it": "Punteggio Totale",
+	"de": "Gesamtpunktzahl",
+	"ru": "Общий балл",
+}
+
+// The Go fonts are compiled into the binary and, unlike the WOFF2 web
+// fonts, can be rasterized without a Brotli decoder. They cover Latin and
+// Cyrillic scripts.
+var ogFonts = struct {
+	once      sync.Once
+	err       error
+	title     font.Face
+	score     font.Face
+	caption   font.Face
+	rangeFace font.Face
+	// Band label faces, largest first
+	labels []font.Face
+}{}
+
+// Font faces are not safe for concurrent use
+var ogRenderMu sync.Mutex
+
+func loadOGFonts() error {
+	ogFonts.once.Do(func() {
+		regular, err := opentype.Parse(goregular.TTF)
+		if err != nil {
+			ogFonts.err = err
+			return
+		}
+		bold, err := opentype.Parse(gobold.TTF)
+		if err != nil {
+			ogFonts.err = err
+			return
+		}
+		face := func(f *opentype.Font, size float64) font.Face {
+			if ogFonts.err != nil {
+				return nil
+			}
+			var face font.Face
+			face, ogFonts.err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+			return face
+		}
+		ogFonts.title = face(bold, 64)
+		ogFonts.score = face(bold, 120)
+		ogFonts.caption = face(regular, 36)
+		ogFonts.rangeFace = face(bold, 26)
+		for _, size := range []float64{26, 22, 18} {
+			ogFonts.labels = append(ogFonts.labels, face(regular, size))
+		}
+	})
+	return ogFonts.err
+}
+
+// renderOGImage draws the total score on the interpretation scale as a PNG
+func renderOGImage(total int, language string) ([]byte, error) {
+	if err := loadOGFonts(); err != nil {
+		return nil, fmt.Errorf("failed to load fonts: %w", err)
+	}
+	ogRenderMu.Lock()
+	defer ogRenderMu.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, ogImageWidth, ogImageHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	dark := color.RGBA{0x2c, 0x3e, 0x50, 0xff}
+	muted := color.RGBA{0x6c, 0x75, 0x7d, 0xff}
+
+	drawCentered(img, ogFonts.title, dark, "RAADS-R", 110)
+	drawCentered(img, ogFonts.caption, muted, totalScoreLabels[language], 175)
+	drawCentered(img, ogFonts.score, dark, fmt.Sprintf("%d / %d", total, raadsMaxTotal), 300)
+
+	// Band scale
+	const (
+		scaleX = 80
+		scaleY = 390
+		scaleW = ogImageWidth - 2*scaleX
+		scaleH = 70
+	)
+	position := func(score int) int {
+		return scaleX + score*scaleW/raadsMaxTotal
+	}
+	for _, band := range scoreBands {
+		x0, x1 := position(band.Min), position(band.Max+1)
+		draw.Draw(img, image.Rect(x0, scaleY, x1, scaleY+scaleH), image.NewUniform(band.Color), image.Point{}, draw.Src)
+		rangeLabel := fmt.Sprintf("%d-%d", band.Min, band.Max)
+		drawText(img, ogFonts.rangeFace, dark, rangeLabel, (x0+x1-textWidth(ogFonts.rangeFace, rangeLabel))/2, scaleY+scaleH/2+9)
+
+		// Narrow bands get the largest face their label fits in
+		label := scoreBandLabels[language][band.Key]
+		face := ogFonts.labels[len(ogFonts.labels)-1]
+		for _, candidate := range ogFonts.labels {
+			if textWidth(candidate, label) <= x1-x0-8 {
+				face = candidate
+				break
+			}
+		}
+		drawText(img, face, muted, label, (x0+x1-textWidth(face, label))/2, scaleY+scaleH+45)
+	}
+
+	// Score marker, with a triangle pointing down at the score
+	x := position(total)
+	draw.Draw(img, image.Rect(x-3, scaleY-10, x+3, scaleY+scaleH+10), image.NewUniform(dark), image.Point{}, draw.Src)
+	for row := 0; row < 20; row++ {
+		draw.Draw(img, image.Rect(x-20+row, scaleY-30+row, x+20-row, scaleY-29+row), image.NewUniform(dark), image.Point{}, draw.Src)
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func textWidth(face font.Face, text string) int {
+	return font.MeasureString(face, text).Round()
+}
+
+func drawText(img draw.Image, face font.Face, c color.Color, text string, x, y int) {
+	d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+func drawCentered(img draw.Image, face font.Face, c color.Color, text string, y int) {
+	drawText(img, face, c, text, (ogImageWidth-textWidth(face, text))/2, y)
+}
+
+// ogImageCache keeps rendered images. The key space is small (scores times
+// languages), so it is simply reset when full.
+type ogImageCache struct {
+	mu     sync.Mutex
+	images map[string][]byte
+}
+
+var ogImages = &ogImageCache{images: make(map[string][]byte)}
+
+func (c *ogImageCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, ok := c.images[key]
+	return img, ok
+}
+
+func (c *ogImageCache) Put(key string, img []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.images) >= ogImageCacheSize {
+		c.images = make(map[string][]byte)
+	}
+	c.images[key] = img
+}
+
+// rateLimiter counts requests per client in fixed one-minute windows
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Time
+	counts map[string]int
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{limit: perMinute, counts: make(map[string]int)}
+}
+
+// Allow records a request and reports whether it is within the limit,
+// along with the seconds until the window resets
+func (l *rateLimiter) Allow(client string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.window) >= time.Minute {
+		l.window = now.Truncate(time.Minute)
+		l.counts = make(map[string]int)
+	}
+	l.counts[client]++
+	reset := int(l.window.Add(time.Minute).Sub(now).Seconds()) + 1
+	return l.counts[client] <= l.limit, reset
+}
+
+var ogImageLimiter = newRateLimiter(ogImageRatePerMinute)
+
+// ogImageHandler renders a link preview image of a total score
+// (GET /og-image?total=97&lang=fr)
+func ogImageHandler(c *gin.Context) {
+	if ok, reset := ogImageLimiter.Allow(c.ClientIP()); !ok {
+		c.Header("Retry-After", strconv.Itoa(reset))
+		c.JSON(429, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	total, err := strconv.Atoi(c.Query("total"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "total must be an integer"})
+		return
+	}
+	total = min(max(total, 0), raadsMaxTotal)
+
+	language := c.DefaultQuery("lang", "en")
+	if _, ok := scoreBandLabels[language]; !ok {
+		language = "en"
+	}
+
+	key := fmt.Sprintf("%s/%d", language, total)
+	img, ok := ogImages.Get(key)
+	if !ok {
+		img, err = renderOGImage(total, language)
+		if err != nil {
+			log.Printf("❌ Error rendering OG image: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to render image"})
+			return
+		}
+		ogImages.Put(key, img)
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(200, "image/png", img)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/claudestream"
+)
+
+var (
+	// Base URL of the Ollama server, usually on the same host or network
+	ollamaBaseURL = envString("OLLAMA_BASE_URL", "http://localhost:11434")
+
+	// Model of the generations without a routing rule when LLM_PROVIDER
+	// is ollama. It must have been pulled on the server.
+	ollamaModel = envString("OLLAMA_MODEL", "llama3.1")
+
+	// Context window requested from Ollama, whose default is too small
+	// for the prompt and the assessment
+	ollamaContextTokens = envInt("OLLAMA_NUM_CTX", 32768)
+
+	// Local models are much slower than hosted ones, so generations get
+	// their own timeout
+	ollamaHTTPClient = &http.Client{Timeout: time.Duration(envInt("OLLAMA_TIMEOUT_SECONDS", 600)) * time.Second}
+)
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  struct {
+		NumCtx      int      `json:"num_ctx"`
+		NumPredict  int      `json:"num_predict"`
+		Temperature *float64 `json:"temperature,omitempty"`
+		TopP        *float64 `json:"top_p,omitempty"`
+		Stop        []string `json:"stop,omitempty"`
+	} `json:"options"`
+}
+
+// ollamaChatResponse is a chat response, or one line of a streamed one
+type ollamaChatResponse struct {
+	Message         openAIMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// callOllama sends a chat request to the Ollama server. The assessment is
+// inlined in the prompt, as with the other providers without documents.
+func callOllama(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int, stream bool) (*http.Response, error) {
+	chatReq := ollamaChatRequest{
+		Model:    model,
+		Messages: openAIMessages(input, prompt),
+		Stream:   stream,
+	}
+	chatReq.Options.NumCtx = ollamaContextTokens
+	chatReq.Options.NumPredict = models.MaxTokens(model, maxTokens)
+	sampling := samplingFor(input.Assessment)
+	chatReq.Options.Temperature = sampling.Temperature
+	chatReq.Options.TopP = sampling.TopP
+	chatReq.Options.Stop = sampling.StopSequences
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Ollama request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaBaseURL+"/api/chat", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Ollama: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &claudeAPIError{Status: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// completeOllamaMarkdown is completeClaudeMarkdown for Ollama
+func completeOllamaMarkdown(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callOllama(ctx, input, prompt, model, maxTokens, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var chatResp ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("failed to decode Ollama response: %w", err)
+	}
+	if chatResp.Error != "" {
+		return "", fmt.Errorf("ollama error: %s", chatResp.Error)
+	}
+	recordUsage(ctx, model, chatResp.PromptEvalCount, chatResp.EvalCount)
+	if chatResp.Message.Content == "" {
+		return "", fmt.Errorf("empty response from Ollama")
+	}
+
+	logGenerationSizes(model, input, prompt, chatResp.Message.Content)
+	return chatResp.Message.Content, nil
+}
+
+// streamOllamaMarkdown is streamClaudeMarkdown for Ollama
+func streamOllamaMarkdown(ctx context.Context, c *gin.Context, gen *generation, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callOllama(ctx, input, prompt, model, maxTokens, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	markdown, usage, err := forwardMarkdownStream(ctx, c, gen, newOllamaStream(resp.Body))
+	recordUsage(ctx, model, usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		return "", err
+	}
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// ollamaStream reads a streamed chat response, one JSON object per line,
+// as Claude stream events
+type ollamaStream struct {
+	scanner *bufio.Scanner
+	skipped int
+}
+
+func newOllamaStream(r io.Reader) *ollamaStream {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), claudestream.DefaultMaxLineBytes)
+	return &ollamaStream{scanner: scanner}
+}
+
+// Skipped returns the number of lines that could not be decoded
+func (s *ollamaStream) Skipped() int {
+	return s.skipped
+}
+
+// Read calls handle with every event until the stream ends
+func (s *ollamaStream) Read(handle func(claudestream.Event) error) error {
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			s.skipped++
+			continue
+		}
+		if chunk.Error != "" {
+			return handle(claudestream.Error{Type: "ollama_error", Message: chunk.Error})
+		}
+		if chunk.Message.Content != "" {
+			if err := handle(claudestream.TextDelta{Text: chunk.Message.Content}); err != nil {
+				return err
+			}
+		}
+		if chunk.Done {
+			if err := handle(claudestream.Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount}); err != nil {
+				return err
+			}
+			return handle(claudestream.Stop{Reason: chunk.DoneReason})
+		}
+	}
+	return s.scanner.Err()
+}
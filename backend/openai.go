@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/claudestream"
+)
+
+var (
+	openAIAPIKey = os.Getenv("OPENAI_API_KEY")
+
+	// Base URL of an OpenAI-compatible API, including its version path
+	openAIBaseURL = envString("OPENAI_BASE_URL", "https://api.openai.com/v1")
+
+	// Model of the generations without a routing rule when LLM_PROVIDER
+	// is openai
+	openAIModel = envString("OPENAI_MODEL", "gpt-4o")
+)
+
+type openAIChatRequest struct {
+	Model         string          `json:"model"`
+	MaxTokens     int             `json:"max_tokens"`
+	Messages      []openAIMessage `json:"messages"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	Stop          []string        `json:"stop,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// openAIChatResponse is a chat completion, or a chunk of a streamed one
+type openAIChatResponse struct {
+	Choices []struct {
+		Message *openAIMessage `json:"message"`
+		Delta   *struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openAIMessages is the system prompt of a generation, if any, followed by
+// the prompt. Providers without document support always get the assessment
+// inlined in the prompt, so the user message is the prompt alone.
+func openAIMessages(input assessmentInput, prompt string) []openAIMessage {
+	var messages []openAIMessage
+	if input.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: input.System})
+	}
+	return append(messages, openAIMessage{Role: "user", Content: prompt})
+}
+
+// callOpenAI sends a chat completion request, to an Azure OpenAI deployment
+// with the azure-openai provider
+func callOpenAI(ctx context.Context, provider string, input assessmentInput, prompt, model string, maxTokens int, stream bool) (*http.Response, error) {
+	sampling := samplingFor(input.Assessment)
+	chatReq := openAIChatRequest{
+		Model:       model,
+		MaxTokens:   models.MaxTokens(model, maxTokens),
+		Messages:    openAIMessages(input, prompt),
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+		Stop:        sampling.StopSequences,
+		Stream:      stream,
+	}
+	if stream {
+		chatReq.StreamOptions = &struct {
+			IncludeUsage bool `json:"include_usage"`
+		}{IncludeUsage: true}
+	}
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal OpenAI request: %w", err)
+	}
+
+	endpoint := openAIBaseURL + "/chat/completions"
+	if provider == providerAzureOpenAI {
+		endpoint = azureChatCompletionsURL(model)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenAI request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if provider == providerAzureOpenAI {
+		req.Header.Set("api-key", azureOpenAIAPIKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
+	}
+
+	resp, err := claudeHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call OpenAI API: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &claudeAPIError{Status: resp.StatusCode, Body: string(body), RequestID: resp.Header.Get("x-request-id")}
+	}
+	return resp, nil
+}
+
+// completeOpenAIMarkdown is completeClaudeMarkdown for OpenAI-compatible
+// providers
+func completeOpenAIMarkdown(ctx context.Context, provider string, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callOpenAI(ctx, provider, input, prompt, model, maxTokens, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var chatResp openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("failed to decode OpenAI response: %w", err)
+	}
+	if chatResp.Usage != nil {
+		recordUsage(ctx, model, chatResp.Usage.PromptTokens, chatResp.Usage.CompletionTokens)
+	}
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message == nil {
+		return "", fmt.Errorf("empty response from OpenAI API")
+	}
+
+	markdown := chatResp.Choices[0].Message.Content
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// streamOpenAIMarkdown is streamClaudeMarkdown for OpenAI-compatible
+// providers
+func streamOpenAIMarkdown(ctx context.Context, c *gin.Context, gen *generation, provider string, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	resp, err := callOpenAI(ctx, provider, input, prompt, model, maxTokens, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	markdown, usage, err := forwardMarkdownStream(ctx, c, gen, newOpenAIStream(resp.Body))
+	recordUsage(ctx, model, usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		return "", err
+	}
+	logGenerationSizes(model, input, prompt, markdown)
+	return markdown, nil
+}
+
+// openAIStream reads the chunks of a streamed chat completion as Claude
+// stream events, so that both providers are forwarded the same way
+type openAIStream struct {
+	scanner *bufio.Scanner
+	skipped int
+}
+
+func newOpenAIStream(r io.Reader) *openAIStream {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), claudestream.DefaultMaxLineBytes)
+	return &openAIStream{scanner: scanner}
+}
+
+// Skipped returns the number of chunks that could not be decoded
+func (s *openAIStream) Skipped() int {
+	return s.skipped
+}
+
+// Read calls handle with every event until the stream ends
+func (s *openAIStream) Read(handle func(claudestream.Event) error) error {
+	for s.scanner.Scan() {
+		data, ok := strings.CutPrefix(s.scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			s.skipped++
+			continue
+		}
+		if chunk.Error != nil {
+			return handle(claudestream.Error{Type: chunk.Error.Type, Message: chunk.Error.Message})
+		}
+		if chunk.Usage != nil {
+			if err := handle(claudestream.Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}); err != nil {
+				return err
+			}
+		}
+		for i, choice := range chunk.Choices {
+			if choice.Delta != nil && choice.Delta.Content != "" {
+				if err := handle(claudestream.TextDelta{Index: i, Text: choice.Delta.Content}); err != nil {
+					return err
+				}
+			}
+			if choice.FinishReason != "" {
+				if err := handle(claudestream.Stop{Reason: choice.FinishReason}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.scanner.Err()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// openAPIDocument is the part of the OpenAPI document the tests check the
+// routes against
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*jsonSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema *jsonSchema `json:"schema"`
+			// Schema of the data of each event of a text/event-stream
+			Events map[string]*jsonSchema `json:"x-events"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+func parseOpenAPI(t *testing.T) *openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPIJSON, &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	return &doc
+}
+
+// openAPIPath turns a gin route into an OpenAPI path template
+func openAPIPath(route string) string {
+	return regexp.MustCompile(`[:*](\w+)`).ReplaceAllString(route, "{$1}")
+}
+
+// TestOpenAPIRoutes makes sure every route is documented, and every
+// documented operation is a route
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := parseOpenAPI(t)
+	routes := map[string]bool{}
+	for _, route := range newRouter().Routes() {
+		operation := route.Method + " " + openAPIPath(route.Path)
+		routes[operation] = true
+		if _, ok := doc.Paths[openAPIPath(route.Path)][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("route %s is not documented", operation)
+		}
+	}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if operation := strings.ToUpper(method) + " " + path; !routes[operation] {
+				t.Errorf("documented operation %s is not a route", operation)
+			}
+		}
+	}
+}
+
+// apiCall is a request made by TestOpenAPIResponses
+type apiCall struct {
+	method  string
+	url     string
+	body    any
+	headers map[string]string
+}
+
+// openAPIChecker checks responses against the OpenAPI document, keeping
+// track of the operations they covered
+type openAPIChecker struct {
+	t       *testing.T
+	doc     *openAPIDocument
+	root    *jsonSchema
+	covered map[string]bool
+}
+
+// operation finds the documented operation of a request, preferring
+// literal paths over templates
+func (c *openAPIChecker) operation(method, url string) (string, openAPIOperation, bool) {
+	path, _, _ := strings.Cut(url, "?")
+	best, params := "", -1
+	for template, operations := range c.doc.Paths {
+		if _, ok := operations[strings.ToLower(method)]; !ok {
+			continue
+		}
+		pattern := regexp.QuoteMeta(template)
+		pattern = regexp.MustCompile(`\\\{filepath\\\}`).ReplaceAllString(pattern, `.+`)
+		pattern = regexp.MustCompile(`\\\{\w+\\\}`).ReplaceAllString(pattern, `[^/]+`)
+		if !regexp.MustCompile("^" + pattern + "$").MatchString(path) {
+			continue
+		}
+		if n := strings.Count(template, "{"); params < 0 || n < params {
+			best, params = template, n
+		}
+	}
+	operation, ok := c.doc.Paths[best][strings.ToLower(method)]
+	return method + " " + best, operation, ok
+}
+
+// check makes a request and checks its status, content type and body
+// against the document
+func (c *openAPIChecker) check(call apiCall) *httptest.ResponseRecorder {
+	t := c.t
+	t.Helper()
+	var body []byte
+	if call.body != nil {
+		var err error
+		if body, err = json.Marshal(call.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := serve(t, call.method, call.url, body, call.headers)
+
+	name, operation, ok := c.operation(call.method, call.url)
+	if !ok {
+		t.Errorf("%s %s: no documented operation", call.method, call.url)
+		return w
+	}
+	c.covered[name] = true
+	response, ok := operation.Responses[fmt.Sprint(w.Code)]
+	if !ok {
+		t.Errorf("%s %s: undocumented status %d: %.200s", call.method, call.url, w.Code, w.Body.String())
+		return w
+	}
+	if len(response.Content) == 0 {
+		if w.Body.Len() > 0 {
+			t.Errorf("%s %s: body of a response documented without content", call.method, call.url)
+		}
+		return w
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	content, ok := response.Content[mediaType]
+	if !ok {
+		if content, ok = response.Content["*/*"]; !ok {
+			t.Errorf("%s %s: undocumented content type %q for status %d", call.method, call.url, mediaType, w.Code)
+			return w
+		}
+	}
+
+	switch {
+	case mediaType == "text/event-stream":
+		for _, event := range readEvents(t, strings.NewReader(w.Body.String())) {
+			schema, ok := content.Events[event.Name]
+			if !ok {
+				t.Errorf("%s %s: undocumented %s event", call.method, call.url, event.Name)
+				continue
+			}
+			c.validate(fmt.Sprintf("%s %s %s event", call.method, call.url, event.Name), schema, []byte(event.Data))
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if content.Schema != nil {
+			c.validate(fmt.Sprintf("%s %s %d", call.method, call.url, w.Code), content.Schema, w.Body.Bytes())
+		}
+	}
+	return w
+}
+
+func (c *openAPIChecker) validate(what string, schema *jsonSchema, data []byte) {
+	c.t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		c.t.Errorf("%s: invalid JSON: %v", what, err)
+		return
+	}
+	var errs []ValidationError
+	c.root.validate(schema, value, "", &errs)
+	for _, err := range errs {
+		c.t.Errorf("%s: %s: %s", what, err.Path, err.Message)
+	}
+}
+
+// TestOpenAPIResponses makes a request to every documented operation and
+// checks the responses against the document
+func TestOpenAPIResponses(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.ChunkDelay = 0
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	useReportSigning(t)
+	previousAdmin := adminToken
+	adminToken = "admin"
+	t.Cleanup(func() { adminToken = previousAdmin })
+	for _, flag := range []*featureFlag{featurePDF, featureAsyncJobs, featureBatches} {
+		flag.enabled.Store(true)
+		t.Cleanup(func() { flag.enabled.Store(flag.Default) })
+	}
+
+	doc := parseOpenAPI(t)
+	c := &openAPIChecker{t: t, doc: doc, root: &jsonSchema{Defs: doc.Components.Schemas}, covered: map[string]bool{}}
+	client := map[string]string{"X-Client-Token": "client"}
+	admin := map[string]string{"Authorization": "Bearer admin"}
+	var assessment map[string]any
+	if err := json.Unmarshal(answeredAssessment(t, "TestOpenAPIResponses"), &assessment); err != nil {
+		t.Fatal(err)
+	}
+	domain := gin.H{"domain": "social"}
+	for key, value := range assessment {
+		domain[key] = value
+	}
+	composite := []AssessmentData{uniformAssessment(t, aq50Instrument(), 1), uniformAssessment(t, rbq2aInstrument(), 1)}
+	withMarkdown := gin.H{"assessment": assessment, "markdown": "## Analysis"}
+
+	analysis := decodeResponse(t, c.check(apiCall{"POST", "/analyze", assessment, client}), 200)
+	reportID := analysis["report_id"].(string)
+	export := gin.H{"reportId": reportID}
+	html := c.check(apiCall{"POST", "/export-html", export, client})
+	token := regexp.MustCompile(`/verify/([A-Z2-7]+\.[A-Z2-7]+)`).FindStringSubmatch(html.Body.String())
+	if token == nil {
+		t.Fatal("no verification link in the HTML export")
+	}
+	job := decodeResponse(t, c.check(apiCall{"POST", "/jobs", assessment, client}), 202)
+	batch := decodeResponse(t, c.check(apiCall{"POST", "/analyze-batch", gin.H{"assessments": []any{assessment, assessment}}, client}), 202)
+	stream := readEvents(t, c.check(apiCall{"POST", "/analyze-stream", assessment, client}).Body)
+	var metadata streamproto.Metadata
+	if len(stream) == 0 || json.Unmarshal([]byte(stream[0].Data), &metadata) != nil {
+		t.Fatalf("the stream did not start with its metadata: %+v", stream)
+	}
+
+	calls := []apiCall{
+		{"GET", "/openapi.json", nil, nil},
+		{"GET", "/health", nil, nil},
+		{"GET", "/livez", nil, nil},
+		{"GET", "/readyz", nil, nil},
+		{"GET", "/questions", nil, nil},
+		{"GET", "/questions/en", nil, nil},
+		{"GET", "/questions/xx", nil, nil},
+		{"GET", "/schemas/assessment.json", nil, nil},
+		{"GET", "/versions/prompts", nil, nil},
+		{"GET", "/norms", nil, nil},
+		{"GET", "/themes", nil, nil},
+		{"GET", "/models", nil, nil},
+		{"GET", "/features", nil, nil},
+		{"GET", "/stats", nil, nil},
+		{"GET", "/metrics", nil, nil},
+		{"GET", "/og-image?total=120", nil, nil},
+		{"GET", "/og-image", nil, nil},
+		{"POST", "/score", assessment, nil},
+		{"POST", "/score", gin.H{"language": "xx"}, nil},
+		{"POST", "/chart-data", assessment, nil},
+		{"POST", "/anonymize", assessment, nil},
+		{"POST", "/estimate", assessment, nil},
+		{"POST", "/report/exists", gin.H{"assessment_hash": analysis["assessment_hash"], "client_token": "client"}, nil},
+		{"POST", "/analyze", gin.H{"language": "en"}, nil},
+		{"POST", "/analyze-batch", assessment, client},
+		{"GET", "/analyze-batch/" + fmt.Sprint(batch["id"]), nil, nil},
+		{"GET", "/analyze-batch/unknown", nil, nil},
+		{"POST", "/analyze/domain", domain, nil},
+		{"POST", "/analyze/domain/stream", domain, nil},
+		{"POST", "/analyze/composite", gin.H{"assessments": composite}, nil},
+		{"DELETE", "/analyze/" + metadata.ReportID, nil, client},
+		{"POST", "/generations/" + metadata.ReportID + "/cancel", nil, nil},
+		{"POST", "/export/bundle", export, client},
+		{"POST", "/export/csv", withMarkdown, nil},
+		{"POST", "/export/xlsx", withMarkdown, nil},
+		{"POST", "/export/fhir/response", withMarkdown, nil},
+		{"POST", "/export/fhir/report", withMarkdown, nil},
+		{"POST", "/export/anonymized", withMarkdown, nil},
+		{"GET", "/reports/" + reportID, nil, client},
+		{"GET", "/reports/" + reportID, nil, nil},
+		{"GET", "/reports/" + reportID + "/chart.svg", nil, client},
+		{"GET", "/reports/" + reportID + "/chart.png", nil, client},
+		{"GET", "/reports/" + reportID + "/radar.svg", nil, client},
+		{"GET", "/reports/" + reportID + "/radar.png", nil, client},
+		{"GET", "/verify/" + token[1], nil, nil},
+		{"GET", "/verify/" + token[1], nil, map[string]string{"Accept": "application/json"}},
+		{"GET", "/verify/AAAA.AAAA", nil, map[string]string{"Accept": "application/json"}},
+		{"POST", "/export/composite", gin.H{"assessments": composite, "markdown": "## Composite"}, nil},
+		{"POST", "/generate-pdf", export, client},
+		{"GET", "/jobs/" + fmt.Sprint(job["id"]), nil, nil},
+		{"GET", "/assets/fonts/open-sans-regular.woff2", nil, nil},
+		{"HEAD", "/assets/fonts/open-sans-regular.woff2", nil, nil},
+		{"GET", "/usage", nil, nil},
+		{"GET", "/usage", nil, admin},
+		{"GET", "/admin/models", nil, admin},
+		{"GET", "/admin/quality-review", nil, admin},
+		{"GET", "/admin/quality-review/unknown", nil, admin},
+		{"GET", "/admin/jobs", nil, admin},
+		{"POST", "/admin/jobs/" + fmt.Sprint(job["id"]) + "/requeue", nil, admin},
+		{"GET", "/admin/render-diagnostics", nil, admin},
+		{"GET", "/admin/traces/" + metadata.ReportID, nil, admin},
+		{"GET", "/admin/traces/unknown", nil, admin},
+		{"DELETE", "/reports/" + reportID, nil, client},
+		{"GET", "/reports/" + reportID, nil, client},
+	}
+	for _, call := range calls {
+		c.check(call)
+	}
+
+	var missing []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if operation := strings.ToUpper(method) + " " + path; !c.covered[operation] {
+				missing = append(missing, operation)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("no response checked for %s", strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Longest participant-provided context kept, in bytes
+const maxAdditionalContextLength = 2000
+
+// Titles of the participant-provided context subsection in reports
+var participantContextTitles = map[string]string{
+	"en": "Participant-provided context",
+	"fr": "Contexte fourni par le participant",
+	"es": "Contexto aportado por el participante",
+	"it": "Contesto fornito dal partecipante",
+	"de": "Vom Teilnehmer angegebener Kontext",
+	"ru": "Контекст, предоставленный участником",
+}
+
+// participantContextTitle returns the localized subsection title
+func participantContextTitle(language string) string {
+	if title, ok := participantContextTitles[language]; ok {
+		return title
+	}
+	return participantContextTitles["en"]
+}
+
+// sanitizeAdditionalContext trims the participant-provided context and
+// truncates it like comments when it is too long
+func sanitizeAdditionalContext(ctx context.Context, data *AssessmentData) {
+	text := strings.TrimSpace(data.AdditionalContext)
+	if len(text) > maxAdditionalContextLength {
+		truncated := strings.ToValidUTF8(text[:maxAdditionalContextLength-len("[truncated]")], "") + "[truncated]"
+		warningsFrom(ctx).Add(Warning{
+			Code:    warnContextTruncated,
+			Message: fmt.Sprintf("additional context truncated (was %d chars, now %d chars)", len(text), len(truncated)),
+		})
+		text = truncated
+	}
+	data.AdditionalContext = text
+}
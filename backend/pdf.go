@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// pdfEngineCommand is how an engine compiles report.tex: its arguments, and
+// how many times it runs. latexmk and tectonic rerun on their own until the
+// document settles.
+type pdfEngineCommand struct {
+	Args   []string
+	Passes int
+}
+
+// PDF engines, none of which may run shell commands from the document
+var pdfEngines = map[string]pdfEngineCommand{
+	"lualatex": {Args: []string{"-interaction=nonstopmode", "-halt-on-error", "-no-shell-escape", "report.tex"}, Passes: 2},
+	"latexmk":  {Args: []string{"-lualatex", "-interaction=nonstopmode", "-halt-on-error", "-no-shell-escape", "report.tex"}, Passes: 1},
+	"tectonic": {Args: []string{"--untrusted", "--keep-logs", "--chatter", "minimal", "report.tex"}, Passes: 1},
+}
+
+// Engine used to compile the LaTeX report, the longest a compilation may
+// take, and how many compilations may run at once
+var (
+	pdfEngine          = envString("PDF_ENGINE", "lualatex")
+	pdfCompileTimeout  = time.Duration(envInt("PDF_COMPILE_TIMEOUT_SECONDS", 60)) * time.Second
+	pdfCompileSlots    = make(chan struct{}, max(envInt("PDF_MAX_CONCURRENT", 2), 1))
+	errPDFCompilerBusy = errors.New("too many PDF compilations in progress")
+)
+
+// pdfEngineAvailable reports whether PDF reports can be compiled
+func pdfEngineAvailable() bool {
+	if _, ok := pdfEngines[pdfEngine]; !ok {
+		return false
+	}
+	_, err := exec.LookPath(pdfEngine)
+	return err == nil
+}
+
+// LaTeXCompileError is a failed compilation, with the first error of the
+// LaTeX log and the line of the document it is on, when the log has them
+type LaTeXCompileError struct {
+	Engine   string `json:"engine"`
+	Pass     int    `json:"pass"`
+	Message  string `json:"message"`
+	Line     int    `json:"line,omitempty"`
+	Log      string `json:"log"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+func (e *LaTeXCompileError) Error() string {
+	switch {
+	case e.TimedOut:
+		return fmt.Sprintf("%s timed out on pass %d after %s", e.Engine, e.Pass, pdfCompileTimeout)
+	case e.Line != 0:
+		return fmt.Sprintf("%s failed on pass %d: %s (line %d)", e.Engine, e.Pass, e.Message, e.Line)
+	}
+	return fmt.Sprintf("%s failed on pass %d: %s", e.Engine, e.Pass, e.Message)
+}
+
+// latexErrorLine is the "l.123" line TeX prints after an error, with the
+// line of the document it stopped on
+var latexErrorLine = regexp.MustCompile(`(?m)^l\.(\d+)`)
+
+// parseLaTeXLog finds the first error of a LaTeX log, the lines starting
+// with "!", and the line of the document it is on
+func parseLaTeXLog(content string) (message string, line int) {
+	for _, l := range strings.Split(content, "\n") {
+		if strings.HasPrefix(l, "! ") {
+			message = strings.TrimSpace(strings.TrimPrefix(l, "! "))
+			break
+		}
+	}
+	if message == "" {
+		return "", 0
+	}
+	rest := content[strings.Index(content, "! "+message):]
+	if m := latexErrorLine.FindStringSubmatch(rest); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	return message, line
+}
+
+// sandboxedEnv is the environment of a compilation: only PATH from the
+// server, the scratch directory as home and font cache, and no writes
+// outside of it
+func sandboxedEnv(dir string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TEXMFVAR=" + filepath.Join(dir, "texmf-var"),
+		"TEXMFCONFIG=" + filepath.Join(dir, "texmf-config"),
+		"XDG_CACHE_HOME=" + filepath.Join(dir, "cache"),
+		"openout_any=p",
+		"shell_escape=f",
+	}
+}
+
+// compileLaTeXPDF compiles a LaTeX document in a scratch directory, along
+// with the files it includes, with the environment of sandboxedEnv. At most
+// PDF_MAX_CONCURRENT compilations run at once, the others fail with
+// errPDFCompilerBusy rather than queue.
+func compileLaTeXPDF(ctx context.Context, document string, files map[string][]byte) ([]byte, error) {
+	command, ok := pdfEngines[pdfEngine]
+	if !ok {
+		return nil, fmt.Errorf("unknown PDF engine %q", pdfEngine)
+	}
+	select {
+	case pdfCompileSlots <- struct{}{}:
+		defer func() { <-pdfCompileSlots }()
+	default:
+		return nil, errPDFCompilerBusy
+	}
+
+	dir, err := os.MkdirTemp("", "raads-pdf-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF build directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "report.tex"), []byte(document), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write LaTeX document: %w", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pdfCompileTimeout)
+	defer cancel()
+	for pass := 1; pass <= command.Passes; pass++ {
+		cmd := exec.CommandContext(ctx, pdfEngine, command.Args...)
+		cmd.Dir = dir
+		cmd.Env = sandboxedEnv(dir)
+		cmd.WaitDelay = 5 * time.Second
+		output, err := cmd.CombinedOutput()
+		if err == nil {
+			continue
+		}
+		compileErr := &LaTeXCompileError{Engine: pdfEngine, Pass: pass, Log: lastLines(string(output), 20)}
+		if ctx.Err() == context.DeadlineExceeded {
+			compileErr.TimedOut, compileErr.Message = true, "timed out"
+			return nil, compileErr
+		}
+		if content, readErr := os.ReadFile(filepath.Join(dir, "report.log")); readErr == nil {
+			compileErr.Message, compileErr.Line = parseLaTeXLog(string(content))
+		}
+		if compileErr.Message == "" {
+			compileErr.Message = err.Error()
+		}
+		return nil, compileErr
+	}
+
+	return os.ReadFile(filepath.Join(dir, "report.pdf"))
+}
+
+// lastLines returns the last n lines of a compiler log
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pdfReportFailed responds to a PDF report that could not be generated:
+// 503 when the compiler is busy, 504 when it timed out, 422 with the LaTeX
+// error when the document does not compile, and 500 otherwise
+func pdfReportFailed(c *gin.Context, err error) {
+	log.Printf("❌ Error generating PDF report: %v", err)
+	var compileErr *LaTeXCompileError
+	switch {
+	case errors.Is(err, errPDFCompilerBusy):
+		c.Header("Retry-After", "5")
+		c.JSON(503, gin.H{"error": "Server is busy compiling other reports, please retry shortly"})
+	case errors.As(err, &compileErr) && compileErr.TimedOut:
+		c.JSON(504, gin.H{"error": "Failed to compile report: " + err.Error(), "compile": compileErr})
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(504, gin.H{"error": "Failed to render report: " + err.Error()})
+	case errors.As(err, &compileErr):
+		c.JSON(422, gin.H{"error": "Failed to compile report: " + err.Error(), "compile": compileErr})
+	default:
+		c.JSON(500, gin.H{"error": "Failed to generate report: " + err.Error()})
+	}
+}
+
+// PDFRequest is an export request for the LaTeX report of an assessment
+type PDFRequest struct {
+	ExportRequest
+	// Details shown on the title page
+	Participant Participant `json:"participant"`
+}
+
+// renderReportPDF compiles the LaTeX report of an export request, with the
+// score and radar charts of the HTML report, without comments and participant-provided
+// context when they are left out, with a verification link when
+// reports are signed, and in the theme of the request and the brand of its
+// tenant
+func renderReportPDF(ctx context.Context, req ExportRequest, participant Participant, reportID string) ([]byte, error) {
+	data := req.Assessment
+	if req.IncludeComments != nil && !*req.IncludeComments {
+		data.AdditionalContext = ""
+		data.QuestionsAndAnswers = append([]QuestionAndAnswer(nil), data.QuestionsAndAnswers...)
+		for i := range data.QuestionsAndAnswers {
+			data.QuestionsAndAnswers[i].Comment = nil
+		}
+	}
+
+	report, err := newLaTeXReportData(data, participant, markdownToLaTeX(req.Markdown))
+	if err != nil {
+		return nil, err
+	}
+	if req.AnalysisVersion != "" {
+		report.AnalysisVersion = req.AnalysisVersion
+	}
+	if report.Verification, err = newReportVerification(req); err != nil {
+		return nil, err
+	}
+	if report.Theme, err = reportThemeFor(req.Theme); err != nil {
+		return nil, err
+	}
+	report.Branding = report.Theme.apply(brandingFor(ctx))
+	document, err := prepareLaTeXDocument(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := referenceProfileFor(data.ReferenceProfile)
+	if err != nil {
+		return nil, err
+	}
+	chart := newScoreChart(data, profile)
+	bars, err := chart.PNG()
+	if err != nil {
+		return nil, err
+	}
+	radar, err := chart.RadarPNG()
+	if err != nil {
+		return nil, err
+	}
+	files := report.Branding.latexFiles()
+	files["chart.png"], files["radar.png"] = bars, radar
+	return compileLaTeXPDF(ctx, document, files)
+}
+
+// generatePDFHandler renders the report of an assessment and its analysis
+// to PDF on the server, with the renderer of the "renderer" query parameter
+// or PDF_RENDERER, and sends back the PDF
+func generatePDFHandler(c *gin.Context) {
+	var req PDFRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+	renderer := c.DefaultQuery("renderer", pdfRenderer)
+	if !slices.Contains(pdfRenderers, renderer) {
+		c.JSON(400, gin.H{"error": pdfRendererError(renderer)})
+		return
+	}
+	if reason := pdfRendererError(renderer); reason != "" {
+		c.JSON(503, gin.H{"error": reason})
+		return
+	}
+
+	if err := applyStoredReport(c.Request.Context(), &req.ExportRequest); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := requireRAADSR(req.Assessment, "The PDF report"); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyDomainReport(&req.ExportRequest); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyAnalysisVersion(&req.ExportRequest); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyTheme(&req.ExportRequest); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	reportID := uuid.New().String()
+	if renderer == pdfRendererLaTeX {
+		log.Printf("📄 Compiling PDF report %s with %s", reportID, pdfEngine)
+	} else {
+		log.Printf("📄 Printing PDF report %s with %s", reportID, renderer)
+	}
+	started := time.Now()
+	pdf, err := renderPDF(c.Request.Context(), req.ExportRequest, req.Participant, renderer, reportID)
+	if err != nil {
+		pdfReportFailed(c, err)
+		return
+	}
+	log.Printf("✅ PDF report %s rendered in %s (%d bytes)", reportID, time.Since(started).Round(time.Millisecond), len(pdf))
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="raads-r-report-%s.pdf"`, reportID))
+	c.Data(200, "application/pdf", pdf)
+}
+
+// checkPDFEngine verifies that the configured engine is known and installed
+func checkPDFEngine() checkResult {
+	result := checkResult{Name: "PDF engine", Feature: "pdf", Optional: true}
+	if _, ok := pdfEngines[pdfEngine]; !ok {
+		engines := make([]string, 0, len(pdfEngines))
+		for engine := range pdfEngines {
+			engines = append(engines, engine)
+		}
+		slices.Sort(engines)
+		result.Detail = fmt.Sprintf("unknown PDF_ENGINE %q (available: %s)", pdfEngine, strings.Join(engines, ", "))
+		return result
+	}
+	path, err := exec.LookPath(pdfEngine)
+	if err != nil {
+		result.Detail = pdfEngine + " not found in PATH"
+		return result
+	}
+	result.OK = true
+	result.Detail = path
+	return result
+}
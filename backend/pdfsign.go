@@ -0,0 +1,960 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PDFs of stored reports are signed when PDF_SIGNING_CERT and
+// PDF_SIGNING_KEY are set, with a PAdES baseline signature appended as an
+// incremental update, for clinics to establish that a report was produced
+// by their deployment.
+// The certificate file may hold the chain after the signing certificate.
+var (
+	pdfSigningCertPath = os.Getenv("PDF_SIGNING_CERT")
+	pdfSigningKeyPath  = os.Getenv("PDF_SIGNING_KEY")
+	pdfSigningReason   = envString("PDF_SIGNING_REASON", "Report generated by this service")
+	pdfSigningLocation = os.Getenv("PDF_SIGNING_LOCATION")
+)
+
+// pdfSigner is the key and certificate chain PDFs are signed with
+type pdfSigner struct {
+	key   crypto.Signer
+	chain []*x509.Certificate
+}
+
+// loadPDFSigner reads the signing key and certificate once, and returns
+// nil when PDFs are not signed
+var loadPDFSigner = sync.OnceValues(func() (*pdfSigner, error) {
+	if pdfSigningCertPath == "" && pdfSigningKeyPath == "" {
+		return nil, nil
+	}
+	if pdfSigningCertPath == "" || pdfSigningKeyPath == "" {
+		return nil, errors.New("PDF_SIGNING_CERT and PDF_SIGNING_KEY must be set together")
+	}
+
+	content, err := os.ReadFile(pdfSigningCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF_SIGNING_CERT: %w", err)
+	}
+	signer := &pdfSigner{}
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in PDF_SIGNING_CERT: %w", err)
+		}
+		signer.chain = append(signer.chain, cert)
+	}
+	if len(signer.chain) == 0 {
+		return nil, errors.New("PDF_SIGNING_CERT holds no PEM certificate")
+	}
+
+	content, err = os.ReadFile(pdfSigningKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF_SIGNING_KEY: %w", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("PDF_SIGNING_KEY holds no PEM key")
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in PDF_SIGNING_KEY: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signer.key = key
+	case *ecdsa.PrivateKey:
+		signer.key = key
+	default:
+		return nil, fmt.Errorf("unsupported PDF_SIGNING_KEY type %T, only RSA and ECDSA keys are supported", key)
+	}
+	if !signer.key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.chain[0].PublicKey) {
+		return nil, errors.New("PDF_SIGNING_KEY does not match the first certificate of PDF_SIGNING_CERT")
+	}
+	return signer, nil
+})
+
+// signPDF signs a PDF when signing is configured, and returns it unchanged
+// otherwise
+func signPDF(pdf []byte) ([]byte, error) {
+	signer, err := loadPDFSigner()
+	if err != nil || signer == nil {
+		return pdf, err
+	}
+	return signer.Sign(pdf, time.Now())
+}
+
+// Sign appends an invisible signature field to the first page of a PDF,
+// with a detached CAdES signature of the whole file but the signature
+// itself, as ETSI.CAdES.detached (PAdES baseline B) requires
+func (s *pdfSigner) Sign(pdf []byte, signed time.Time) ([]byte, error) {
+	doc, err := parsePDF(pdf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF for signing: %w", err)
+	}
+	catalog, err := doc.Object(doc.Root)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pdfDictGet(catalog, "AcroForm"); ok {
+		return nil, errors.New("PDF already has a form, it cannot be signed")
+	}
+	page, err := doc.FirstPage(catalog)
+	if err != nil {
+		return nil, err
+	}
+	pageDict, err := doc.Object(page)
+	if err != nil {
+		return nil, err
+	}
+
+	// Room for the signature: the certificates, the signed attributes and
+	// the signature value, with some spare for ASN.1 headers
+	contentsSize := 4096
+	for _, cert := range s.chain {
+		contentsSize += len(cert.Raw)
+	}
+
+	sigDict := fmt.Sprintf("<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached %s /Contents <%s> /M %s /Reason %s",
+		pdfByteRangePlaceholder, strings.Repeat("0", contentsSize*2), pdfDate(signed), pdfString(pdfSigningReason))
+	if pdfSigningLocation != "" {
+		sigDict += " /Location " + pdfString(pdfSigningLocation)
+	}
+	sig := pdfRef{Num: doc.Size}
+	field := pdfRef{Num: doc.Size + 1}
+	objects := map[pdfRef]string{
+		doc.Root: pdfDictSet(catalog, "AcroForm", fmt.Sprintf("<< /Fields [%s] /SigFlags 3 >>", field)),
+		field:    fmt.Sprintf("<< /Type /Annot /Subtype /Widget /FT /Sig /T (Report signature) /V %s /P %s /Rect [0 0 0 0] /F 132 >>", sig, page),
+		sig:      sigDict + " >>",
+	}
+	// Widgets are listed in the annotations of their page, unless these
+	// are in an object of their own
+	if annots, ok := pdfDictGet(pageDict, "Annots"); !ok {
+		objects[page] = pdfDictSet(pageDict, "Annots", fmt.Sprintf("[%s]", field))
+	} else if strings.HasPrefix(annots, "[") {
+		objects[page] = pdfDictSet(pageDict, "Annots", strings.TrimSuffix(annots, "]")+" "+field.String()+"]")
+	}
+
+	out := doc.Update(objects)
+
+	// The byte range covers everything but the hexadecimal contents, which
+	// are the signature of that range
+	sigStart := bytes.LastIndex(out, []byte(pdfByteRangePlaceholder))
+	contentsStart := sigStart + bytes.Index(out[sigStart:], []byte("/Contents <")) + len("/Contents ")
+	contentsEnd := contentsStart + contentsSize*2 + 2
+	byteRange := fmt.Sprintf("/ByteRange [0 %d %d %d]", contentsStart, contentsEnd, len(out)-contentsEnd)
+	copy(out[sigStart:], fmt.Sprintf("%-*s", len(pdfByteRangePlaceholder), byteRange))
+
+	digest := sha256.New()
+	digest.Write(out[:contentsStart])
+	digest.Write(out[contentsEnd:])
+	signature, err := s.cadesSignature(digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) > contentsSize {
+		return nil, fmt.Errorf("signature of %d bytes does not fit in %d bytes", len(signature), contentsSize)
+	}
+	hex.Encode(out[contentsStart+1:], signature)
+	return out, nil
+}
+
+// The byte range is written once the offsets of the signature are known,
+// in place of this placeholder
+var pdfByteRangePlaceholder = "/ByteRange [0 " + strings.Repeat(" ", 3*11) + "]"
+
+// pdfDate formats a time as a PDF date
+func pdfDate(t time.Time) string {
+	return "(D:" + t.UTC().Format("20060102150405") + "Z)"
+}
+
+// pdfString quotes a string as a PDF literal string, or as UTF-16 when it
+// is not ASCII
+func pdfString(s string) string {
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			return "<FEFF" + strings.ToUpper(hex.EncodeToString(utf16BE(s))) + ">"
+		}
+	}
+	return "(" + strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s) + ")"
+}
+
+// utf16BE encodes a string as big-endian UTF-16
+func utf16BE(s string) []byte {
+	var out []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+// Object identifiers of the CMS signature
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	IssuerAndSerial    cmsIssuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// essCertIDv2 identifies the signing certificate by its SHA-256 hash, the
+// default algorithm, which is left out
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+// cadesSignature is the DER CMS SignedData of a digest, with the signed
+// attributes PAdES requires: content type, message digest and signing
+// certificate, and no signing time, which is in the signature dictionary
+func (s *pdfSigner) cadesSignature(digest []byte) ([]byte, error) {
+	certHash := sha256.Sum256(s.chain[0].Raw)
+	signingCertificate, err := asn1.Marshal(struct{ Certs []essCertIDv2 }{[]essCertIDv2{{certHash[:]}}})
+	if err != nil {
+		return nil, err
+	}
+	contentType, _ := asn1.Marshal(oidData)
+	messageDigest, _ := asn1.Marshal(digest)
+
+	var attributes [][]byte
+	for _, attribute := range []cmsAttribute{
+		{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+		{Type: oidSigningCertificateV2, Values: []asn1.RawValue{{FullBytes: signingCertificate}}},
+	} {
+		encoded, err := asn1.Marshal(attribute)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+	// DER sorts the elements of a SET OF by their encoding
+	slices.SortFunc(attributes, bytes.Compare)
+	signedAttributes := bytes.Join(attributes, nil)
+
+	// The signature is of the attributes as a SET OF, while they are
+	// stored with an implicit [0] tag
+	toSign, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttributes})
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256(toSign)
+	signature, err := s.key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign PDF: %w", err)
+	}
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	if _, ok := s.key.(*rsa.PrivateKey); ok {
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	}
+
+	var certificates []byte
+	for _, cert := range s.chain {
+		certificates = append(certificates, cert.Raw...)
+	}
+	signedData, err := asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: struct{ ContentType asn1.ObjectIdentifier }{oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []cmsSignerInfo{{
+			Version:            1,
+			IssuerAndSerial:    cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.chain[0].RawIssuer}, Serial: s.chain[0].SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttributes},
+			SignatureAlgorithm: algorithm,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+}
+
+// pdfRef is a reference to an indirect object
+type pdfRef struct {
+	Num, Gen int
+}
+
+func (r pdfRef) String() string {
+	return fmt.Sprintf("%d %d R", r.Num, r.Gen)
+}
+
+// parsePDFRef parses a "12 0 R" reference
+func parsePDFRef(value string) (pdfRef, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 || fields[2] != "R" {
+		return pdfRef{}, false
+	}
+	num, err1 := strconv.Atoi(fields[0])
+	gen, err2 := strconv.Atoi(fields[1])
+	return pdfRef{num, gen}, err1 == nil && err2 == nil
+}
+
+// pdfXrefEntry locates an object: at an offset of the file, or at an
+// index of an object stream
+type pdfXrefEntry struct {
+	Offset int
+	Gen    int
+	Stream int
+	Index  int
+}
+
+// pdfDocument is what signing needs of a PDF: where its objects are, its
+// catalog and the end of its last cross-reference section. Both
+// cross-reference tables and streams are read, as LuaTeX and tectonic
+// compress their objects while Chrome does not.
+type pdfDocument struct {
+	data       []byte
+	xref       map[int]pdfXrefEntry
+	startxref  int
+	xrefStream bool
+	Root       pdfRef
+	Size       int
+	info, id   string
+}
+
+// parsePDF reads the cross-reference sections of a PDF, from the last one
+// back through their /Prev entries
+func parsePDF(data []byte) (*pdfDocument, error) {
+	at := bytes.LastIndex(data, []byte("startxref"))
+	if at < 0 {
+		return nil, errors.New("no startxref")
+	}
+	startxref, err := strconv.Atoi(strings.Fields(string(data[at+len("startxref"):]) + " ")[0])
+	if err != nil || startxref >= len(data) {
+		return nil, errors.New("invalid startxref")
+	}
+	doc := &pdfDocument{data: data, xref: map[int]pdfXrefEntry{}, startxref: startxref}
+
+	seen := map[int]bool{}
+	for offset, first := startxref, true; ; first = false {
+		if seen[offset] || offset < 0 || offset >= len(data) {
+			return nil, fmt.Errorf("invalid cross-reference offset %d", offset)
+		}
+		seen[offset] = true
+		trailer, stream, err := doc.readXref(offset)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			doc.xrefStream = stream
+			root, ok := pdfDictGet(trailer, "Root")
+			if doc.Root, _ = parsePDFRef(root); !ok {
+				return nil, errors.New("no /Root in trailer")
+			}
+			size, _ := pdfDictGet(trailer, "Size")
+			if doc.Size, err = strconv.Atoi(size); err != nil {
+				return nil, errors.New("no /Size in trailer")
+			}
+			doc.info, _ = pdfDictGet(trailer, "Info")
+			doc.id, _ = pdfDictGet(trailer, "ID")
+		}
+		prev, ok := pdfDictGet(trailer, "Prev")
+		if !ok {
+			return doc, nil
+		}
+		if offset, err = strconv.Atoi(prev); err != nil {
+			return nil, errors.New("invalid /Prev in trailer")
+		}
+	}
+}
+
+// readXref reads the cross-reference section at an offset, keeping the
+// entries of later sections, and returns its trailer dictionary
+func (d *pdfDocument) readXref(offset int) (trailer string, stream bool, err error) {
+	rest := string(d.data[offset:])
+	if !strings.HasPrefix(rest, "xref") {
+		dict, content, err := d.stream(offset)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid cross-reference stream: %w", err)
+		}
+		return dict, true, d.readXrefStream(dict, content)
+	}
+
+	end := strings.Index(rest, "trailer")
+	if end < 0 {
+		return "", false, errors.New("no trailer after cross-reference table")
+	}
+	fields := strings.Fields(rest[len("xref"):end])
+	for i := 0; i+1 < len(fields); {
+		start, err1 := strconv.Atoi(fields[i])
+		count, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || i+2+3*count > len(fields) {
+			return "", false, errors.New("invalid cross-reference table")
+		}
+		for n := 0; n < count; n++ {
+			entry := fields[i+2+3*n : i+5+3*n]
+			if _, ok := d.xref[start+n]; ok || entry[2] != "n" {
+				continue
+			}
+			offset, _ := strconv.Atoi(entry[0])
+			gen, _ := strconv.Atoi(entry[1])
+			d.xref[start+n] = pdfXrefEntry{Offset: offset, Gen: gen}
+		}
+		i += 2 + 3*count
+	}
+	trailer, _ = scanPDFToken(rest, end+len("trailer"))
+	return trailer, false, nil
+}
+
+// readXrefStream reads the entries of a cross-reference stream
+func (d *pdfDocument) readXrefStream(dict string, content []byte) error {
+	var widths []int
+	w, _ := pdfDictGet(dict, "W")
+	for _, field := range strings.Fields(strings.Trim(w, "[]")) {
+		width, err := strconv.Atoi(field)
+		if err != nil {
+			return errors.New("invalid /W in cross-reference stream")
+		}
+		widths = append(widths, width)
+	}
+	if len(widths) != 3 {
+		return errors.New("invalid /W in cross-reference stream")
+	}
+	size, _ := pdfDictGet(dict, "Size")
+	index := "0 " + size
+	if value, ok := pdfDictGet(dict, "Index"); ok {
+		index = strings.Trim(value, "[]")
+	}
+	ranges := strings.Fields(index)
+
+	row := widths[0] + widths[1] + widths[2]
+	for i := 0; i+1 < len(ranges); i += 2 {
+		start, err1 := strconv.Atoi(ranges[i])
+		count, err2 := strconv.Atoi(ranges[i+1])
+		if err1 != nil || err2 != nil {
+			return errors.New("invalid /Index in cross-reference stream")
+		}
+		for n := 0; n < count; n++ {
+			if len(content) < row {
+				return errors.New("truncated cross-reference stream")
+			}
+			var values [3]int
+			for f, width := range widths {
+				for _, b := range content[:width] {
+					values[f] = values[f]<<8 | int(b)
+				}
+				content = content[width:]
+			}
+			if widths[0] == 0 {
+				values[0] = 1
+			}
+			if _, ok := d.xref[start+n]; ok {
+				continue
+			}
+			switch values[0] {
+			case 1:
+				d.xref[start+n] = pdfXrefEntry{Offset: values[1], Gen: values[2]}
+			case 2:
+				d.xref[start+n] = pdfXrefEntry{Stream: values[1], Index: values[2]}
+			}
+		}
+	}
+	return nil
+}
+
+// objectAt returns the body of the object at an offset, between "obj" and
+// "endobj" or the start of its stream
+func (d *pdfDocument) objectAt(offset int) (string, int, error) {
+	if offset < 0 || offset >= len(d.data) {
+		return "", 0, fmt.Errorf("invalid object offset %d", offset)
+	}
+	rest := string(d.data[offset:])
+	start := strings.Index(rest, "obj")
+	if start < 0 || start > 32 {
+		return "", 0, fmt.Errorf("no object at offset %d", offset)
+	}
+	token, end := scanPDFToken(rest, start+len("obj"))
+	return token, offset + end, nil
+}
+
+// stream returns the dictionary and decoded content of the stream object
+// at an offset
+func (d *pdfDocument) stream(offset int) (string, []byte, error) {
+	dict, end, err := d.objectAt(offset)
+	if err != nil {
+		return "", nil, err
+	}
+	rest := d.data[end:]
+	start := bytes.Index(rest, []byte("stream"))
+	if start < 0 || len(bytes.TrimSpace(rest[:start])) > 0 {
+		return "", nil, fmt.Errorf("object at offset %d is not a stream", offset)
+	}
+	rest = rest[start+len("stream"):]
+	rest = bytes.TrimPrefix(bytes.TrimPrefix(rest, []byte("\r")), []byte("\n"))
+
+	value, _ := pdfDictGet(dict, "Length")
+	if ref, ok := parsePDFRef(value); ok {
+		if value, err = d.Object(ref); err != nil {
+			return "", nil, err
+		}
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || length > len(rest) {
+		return "", nil, fmt.Errorf("invalid stream length at offset %d", offset)
+	}
+	content, err := decodePDFStream(dict, rest[:length])
+	return dict, content, err
+}
+
+// Object returns the body of an object, from the file or from the object
+// stream it is compressed in
+func (d *pdfDocument) Object(ref pdfRef) (string, error) {
+	entry, ok := d.xref[ref.Num]
+	if !ok {
+		return "", fmt.Errorf("object %d not found", ref.Num)
+	}
+	if entry.Stream == 0 {
+		body, _, err := d.objectAt(entry.Offset)
+		return body, err
+	}
+
+	container, ok := d.xref[entry.Stream]
+	if !ok || container.Stream != 0 {
+		return "", fmt.Errorf("object stream %d not found", entry.Stream)
+	}
+	dict, content, err := d.stream(container.Offset)
+	if err != nil {
+		return "", err
+	}
+	n, _ := pdfDictGet(dict, "N")
+	first, _ := pdfDictGet(dict, "First")
+	count, err1 := strconv.Atoi(n)
+	start, err2 := strconv.Atoi(first)
+	if err1 != nil || err2 != nil || start > len(content) || entry.Index >= count {
+		return "", fmt.Errorf("invalid object stream %d", entry.Stream)
+	}
+	header := strings.Fields(string(content[:start]))
+	if len(header) < 2*count {
+		return "", fmt.Errorf("invalid object stream %d", entry.Stream)
+	}
+	offset, err := strconv.Atoi(header[2*entry.Index+1])
+	if err != nil || start+offset > len(content) {
+		return "", fmt.Errorf("invalid object stream %d", entry.Stream)
+	}
+	body, _ := scanPDFToken(string(content), start+offset)
+	return body, nil
+}
+
+// FirstPage follows the page tree of a catalog to its first page
+func (d *pdfDocument) FirstPage(catalog string) (pdfRef, error) {
+	value, _ := pdfDictGet(catalog, "Pages")
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := parsePDFRef(value)
+		if !ok {
+			return pdfRef{}, errors.New("invalid page tree")
+		}
+		node, err := d.Object(ref)
+		if err != nil {
+			return pdfRef{}, err
+		}
+		if kind, _ := pdfDictGet(node, "Type"); kind == "/Page" {
+			return ref, nil
+		}
+		kids, _ := pdfDictGet(node, "Kids")
+		fields := strings.Fields(strings.Trim(kids, "[]"))
+		if len(fields) < 3 {
+			return pdfRef{}, errors.New("empty page tree")
+		}
+		value = strings.Join(fields[:3], " ")
+	}
+	return pdfRef{}, errors.New("page tree is too deep")
+}
+
+// Update appends new versions of objects to the PDF, with a cross-reference
+// section of the same kind as the last one
+func (d *pdfDocument) Update(objects map[pdfRef]string) []byte {
+	out := bytes.NewBuffer(slices.Clip(d.data))
+	out.WriteString("\n")
+	refs := make([]pdfRef, 0, len(objects)+1)
+	for ref := range objects {
+		refs = append(refs, ref)
+	}
+	slices.SortFunc(refs, func(a, b pdfRef) int { return a.Num - b.Num })
+
+	offsets := make(map[int]int, len(refs)+1)
+	for _, ref := range refs {
+		offsets[ref.Num] = out.Len()
+		fmt.Fprintf(out, "%d %d obj\n%s\nendobj\n", ref.Num, ref.Gen, objects[ref])
+	}
+
+	xref := out.Len()
+	size := max(d.Size, refs[len(refs)-1].Num+1)
+	if d.xrefStream {
+		// The stream is an object of its own, listed in itself
+		refs = append(refs, pdfRef{Num: size})
+		offsets[size] = xref
+		size++
+	}
+	trailer := fmt.Sprintf("/Size %d /Root %s /Prev %d", size, d.Root, d.startxref)
+	if d.info != "" {
+		trailer += " /Info " + d.info
+	}
+	if d.id != "" {
+		trailer += " /ID " + d.id
+	}
+
+	if d.xrefStream {
+		var index []string
+		var entries []byte
+		for _, ref := range refs {
+			index = append(index, strconv.Itoa(ref.Num), "1")
+			offset := offsets[ref.Num]
+			entries = append(entries, 1, byte(offset>>24), byte(offset>>16), byte(offset>>8), byte(offset), byte(ref.Gen>>8), byte(ref.Gen))
+		}
+		fmt.Fprintf(out, "%d 0 obj\n<< /Type /XRef %s /W [1 4 2] /Index [%s] /Length %d >>\nstream\n",
+			size-1, trailer, strings.Join(index, " "), len(entries))
+		out.Write(entries)
+		out.WriteString("\nendstream\nendobj\n")
+	} else {
+		out.WriteString("xref\n")
+		for _, ref := range refs {
+			fmt.Fprintf(out, "%d 1\n%010d %05d n\r\n", ref.Num, offsets[ref.Num], ref.Gen)
+		}
+		fmt.Fprintf(out, "trailer\n<< %s >>\n", trailer)
+	}
+	fmt.Fprintf(out, "startxref\n%d\n%%%%EOF\n", xref)
+	return out.Bytes()
+}
+
+// decodePDFStream decodes the content of a stream, which may only be
+// uncompressed or compressed with Flate, optionally with a PNG predictor
+func decodePDFStream(dict string, raw []byte) ([]byte, error) {
+	filter, _ := pdfDictGet(dict, "Filter")
+	switch strings.Trim(filter, "[] ") {
+	case "":
+		return raw, nil
+	case "/FlateDecode":
+	default:
+		return nil, fmt.Errorf("unsupported stream filter %s", filter)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed stream: %w", err)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("invalid compressed stream: %w", err)
+	}
+
+	params, _ := pdfDictGet(dict, "DecodeParms")
+	predictor, _ := pdfDictGet(strings.Trim(params, "[] "), "Predictor")
+	if p, _ := strconv.Atoi(predictor); p < 10 {
+		return content, nil
+	}
+	columns := 1
+	if value, ok := pdfDictGet(strings.Trim(params, "[] "), "Columns"); ok {
+		if columns, err = strconv.Atoi(value); err != nil || columns < 1 {
+			return nil, errors.New("invalid /Columns in stream parameters")
+		}
+	}
+	return unpredictPNG(content, columns)
+}
+
+// unpredictPNG reverses the PNG filters of rows of one byte per sample, as
+// cross-reference streams use them
+func unpredictPNG(content []byte, columns int) ([]byte, error) {
+	if len(content)%(columns+1) != 0 {
+		return nil, errors.New("invalid predicted stream length")
+	}
+	out := make([]byte, 0, len(content)/(columns+1)*columns)
+	previous := make([]byte, columns)
+	for len(content) > 0 {
+		filter, row := content[0], slices.Clone(content[1:columns+1])
+		content = content[columns+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i > 0 {
+				left, upLeft = row[i-1], previous[i-1]
+			}
+			up := previous[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				p := int(left) + int(up) - int(upLeft)
+				pa, pb, pc := abs(p-int(left)), abs(p-int(up)), abs(p-int(upLeft))
+				switch {
+				case pa <= pb && pa <= pc:
+					row[i] += left
+				case pb <= pc:
+					row[i] += up
+				default:
+					row[i] += upLeft
+				}
+			}
+		}
+		out = append(out, row...)
+		previous = row
+	}
+	return out, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// isPDFDelimiter reports whether a byte ends a PDF name or keyword
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00()<>[]{}/%", c) >= 0
+}
+
+// scanPDFToken returns the PDF token at an offset, whole when it is a
+// string, an array or a dictionary, and the offset after it
+func scanPDFToken(s string, i int) (string, int) {
+	for i < len(s) {
+		if c := s[i]; c == '%' {
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		} else if strings.IndexByte(" \t\r\n\f\x00", c) >= 0 {
+			i++
+		} else {
+			break
+		}
+	}
+	start := i
+	if i >= len(s) {
+		return "", i
+	}
+	switch {
+	case s[i] == '(':
+		for depth := 0; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					return s[start : i+1], i + 1
+				}
+			}
+		}
+	case strings.HasPrefix(s[i:], "<<"), s[i] == '[':
+		closing := "]"
+		if s[i] == '<' {
+			closing, i = ">>", i+1
+		}
+		for i++; i < len(s); {
+			for i < len(s) && strings.IndexByte(" \t\r\n\f\x00", s[i]) >= 0 {
+				i++
+			}
+			if strings.HasPrefix(s[i:], closing) {
+				return s[start : i+len(closing)], i + len(closing)
+			}
+			token, next := scanPDFToken(s, i)
+			if token == "" || next <= i {
+				break
+			}
+			i = next
+		}
+	case s[i] == '<':
+		if end := strings.IndexByte(s[i:], '>'); end >= 0 {
+			return s[start : i+end+1], i + end + 1
+		}
+	case s[i] == '/':
+		for i++; i < len(s) && !isPDFDelimiter(s[i]); i++ {
+		}
+		return s[start:i], i
+	case isPDFDelimiter(s[i]):
+		return s[i : i+1], i + 1
+	default:
+		for ; i < len(s) && !isPDFDelimiter(s[i]); i++ {
+		}
+		return s[start:i], i
+	}
+	return s[start:], len(s)
+}
+
+// pdfDictEntry is a key of a dictionary, without its slash, and its value
+// as written
+type pdfDictEntry struct {
+	Key, Value string
+}
+
+// pdfDictEntries lists the entries of a dictionary, with references as one
+// value
+func pdfDictEntries(dict string) []pdfDictEntry {
+	dict = strings.TrimSpace(dict)
+	if !strings.HasPrefix(dict, "<<") || !strings.HasSuffix(dict, ">>") {
+		return nil
+	}
+	inner := dict[2 : len(dict)-2]
+	var entries []pdfDictEntry
+	for i := 0; ; {
+		key, next := scanPDFToken(inner, i)
+		if !strings.HasPrefix(key, "/") {
+			return entries
+		}
+		value, after := scanPDFToken(inner, next)
+		// A reference is three tokens: two integers and R
+		if gen, afterGen := scanPDFToken(inner, after); isPDFInteger(value) && isPDFInteger(gen) {
+			if r, afterR := scanPDFToken(inner, afterGen); r == "R" {
+				value, after = value+" "+gen+" R", afterR
+			}
+		}
+		entries = append(entries, pdfDictEntry{key[1:], value})
+		i = after
+	}
+}
+
+func isPDFInteger(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// pdfDictGet returns the value of a key of a dictionary
+func pdfDictGet(dict, key string) (string, bool) {
+	for _, entry := range pdfDictEntries(dict) {
+		if entry.Key == key {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// pdfDictSet returns a dictionary with the value of a key replaced or added
+func pdfDictSet(dict, key, value string) string {
+	var out strings.Builder
+	out.WriteString("<<")
+	set := false
+	for _, entry := range pdfDictEntries(dict) {
+		if entry.Key == key {
+			entry.Value, set = value, true
+		}
+		fmt.Fprintf(&out, " /%s %s", entry.Key, entry.Value)
+	}
+	if !set {
+		fmt.Fprintf(&out, " /%s %s", key, value)
+	}
+	out.WriteString(" >>")
+	return out.String()
+}
+
+// minimalPDF is a one page PDF with a cross-reference table, signed by the
+// startup check
+func minimalPDF() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] >>",
+	}
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n\r\n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// checkPDFSigning loads the signing key and certificate, and signs a
+// minimal PDF with them
+func checkPDFSigning() checkResult {
+	result := checkResult{Name: "PDF signing", Feature: "pdf", Optional: true}
+	signer, err := loadPDFSigner()
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	if signer == nil {
+		result.OK = true
+		result.Detail = "PDF_SIGNING_CERT is not set, PDFs are not signed"
+		return result
+	}
+	if _, err := signer.Sign(minimalPDF(), time.Now()); err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	cert := signer.chain[0]
+	if time.Now().After(cert.NotAfter) {
+		result.Detail = fmt.Sprintf("certificate of %s expired on %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+		return result
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("PDFs are signed by %s until %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+	return result
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testPDFSigner is a signer with a self-signed certificate for a key
+func testPDFSigner(t *testing.T, key crypto.Signer) *pdfSigner {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "RAADS-R test clinic"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pdfSigner{key: key, chain: []*x509.Certificate{cert}}
+}
+
+var pdfByteRange = regexp.MustCompile(`/ByteRange \[(\d+) (\d+) (\d+) (\d+) *\]`)
+
+// signedPDFParts splits a signed PDF by its ByteRange into the signed bytes
+// and the DER signature, without the parser of the signer
+func signedPDFParts(t *testing.T, pdf []byte) (signed, signature []byte) {
+	t.Helper()
+	match := pdfByteRange.FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("the signed PDF has no ByteRange")
+	}
+	var r [4]int
+	for i := range r {
+		r[i], _ = strconv.Atoi(string(match[i+1]))
+	}
+	if r[0] != 0 || r[1] >= r[2] || r[2]+r[3] != len(pdf) {
+		t.Fatalf("the ByteRange %v does not cover the whole PDF of %d bytes but the signature", r, len(pdf))
+	}
+	contents := pdf[r[1]:r[2]]
+	if contents[0] != '<' || contents[len(contents)-1] != '>' {
+		t.Fatalf("the ByteRange excludes more than the signature: %q…", contents[:16])
+	}
+	padded, err := hex.DecodeString(string(contents[1 : len(contents)-1]))
+	if err != nil {
+		t.Fatalf("the signature is not hexadecimal: %v", err)
+	}
+	// The signature is padded with zeros to the size reserved for it
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(padded, &raw); err != nil {
+		t.Fatalf("the signature is not DER: %v", err)
+	}
+	return append(append([]byte(nil), pdf[:r[1]]...), pdf[r[2]:]...), raw.FullBytes
+}
+
+// verifyCMS verifies a detached CMS signature of content with openssl,
+// trusting the certificate of the signer
+func verifyCMS(t *testing.T, openssl string, signer *pdfSigner, content, signature []byte) error {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"content.bin":   content,
+		"signature.der": signature,
+		"cert.pem":      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.chain[0].Raw}),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(openssl, "cms", "-verify", "-binary", "-inform", "DER", "-in", "signature.der",
+		"-content", "content.bin", "-CAfile", "cert.pem", "-purpose", "any", "-out", os.DevNull)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func TestPDFSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Log("openssl is not installed, signatures are not verified independently")
+	}
+
+	for name, key := range map[string]crypto.Signer{"RSA": rsaKey, "ECDSA": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			signer := testPDFSigner(t, key)
+			original := minimalPDF()
+			pdf, err := signer.Sign(original, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The signature is an incremental update of the original
+			if !bytes.HasPrefix(pdf, original) {
+				t.Error("the original PDF is altered")
+			}
+			content, signature := signedPDFParts(t, pdf)
+			if openssl == "" {
+				return
+			}
+			if err := verifyCMS(t, openssl, signer, content, signature); err != nil {
+				t.Errorf("the signature does not verify: %v", err)
+			}
+			content[len(content)/2] ^= 1
+			if verifyCMS(t, openssl, signer, content, signature) == nil {
+				t.Error("the signature of an altered PDF verifies")
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// Normative samples scores are ranked against, by group, age and gender,
+// those of the normative dataset in use. NORMATIVE_SAMPLES_FILE adds
+// samples, or replaces those with the same key, e.g. age and gender
+// specific ones.
+var normativeSamplesFile = os.Getenv("NORMATIVE_SAMPLES_FILE")
+
+// Genders demographics may state, matched against the gender of samples
+var demographicGenders = []string{"female", "male", "other"}
+
+// Demographics are the optional details of the participant that select
+// the normative samples of the percentile ranks
+type Demographics struct {
+	Age    int    `json:"age,omitempty"`
+	Gender string `json:"gender,omitempty"`
+}
+
+// normStat is the mean and standard deviation of a score in a sample
+type normStat struct {
+	Mean float64 `json:"mean"`
+	SD   float64 `json:"sd"`
+}
+
+// NormativeSample is a published sample of a group, such as autistic
+// adults, restricted to an age range and a gender when they are set
+type NormativeSample struct {
+	Key    string `json:"key"`
+	Group  string `json:"group"`
+	Label  string `json:"label"`
+	Source string `json:"source"`
+	Gender string `json:"gender,omitempty"`
+	// Label of the reference profile of the sample, for the samples of a
+	// normative dataset scores can be compared against
+	ProfileLabel string `json:"profileLabel,omitempty"`
+	// Ages of the sample, 0 when unbounded
+	MinAge  int                 `json:"minAge,omitempty"`
+	MaxAge  int                 `json:"maxAge,omitempty"`
+	Total   normStat            `json:"total"`
+	Domains map[string]normStat `json:"domains"`
+}
+
+// covers reports whether the sample includes a participant of an age, any
+// age being included when it is unknown
+func (s NormativeSample) covers(age int) bool {
+	return age == 0 || (s.MinAge == 0 || age >= s.MinAge) && (s.MaxAge == 0 || age <= s.MaxAge)
+}
+
+// ageSpan is the width of the age range of the sample
+func (s NormativeSample) ageSpan() int {
+	minAge, maxAge := s.MinAge, s.MaxAge
+	if maxAge == 0 {
+		maxAge = 150
+	}
+	return maxAge - minAge
+}
+
+var (
+	normativeSamples, normativeSamplesErr = loadNormativeSamples()
+)
+
+// loadNormativeSamples takes the samples of the normative dataset, then
+// those of NORMATIVE_SAMPLES_FILE on top of them
+func loadNormativeSamples() ([]NormativeSample, error) {
+	samples := normativeDataset.samples()
+	if normativeSamplesFile != "" {
+		content, err := os.ReadFile(normativeSamplesFile)
+		if err != nil {
+			return samples, fmt.Errorf("failed to read normative samples file: %w", err)
+		}
+		var extra []NormativeSample
+		if err := json.Unmarshal(content, &extra); err != nil {
+			return samples, fmt.Errorf("failed to parse normative samples file: %w", err)
+		}
+		for _, sample := range extra {
+			replaced := false
+			for i := range samples {
+				if samples[i].Key == sample.Key {
+					samples[i], replaced = sample, true
+				}
+			}
+			if !replaced {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples, validateNormativeSamples(samples)
+}
+
+func validateNormativeSamples(samples []NormativeSample) error {
+	for _, s := range samples {
+		if s.Key == "" || s.Group == "" || s.Label == "" {
+			return fmt.Errorf("normative sample %q lacks a key, group or label", s.Key)
+		}
+		if s.Gender != "" && !knownGender(s.Gender) {
+			return fmt.Errorf("normative sample %s has an unknown gender %q", s.Key, s.Gender)
+		}
+		if s.MaxAge != 0 && s.MaxAge < s.MinAge {
+			return fmt.Errorf("normative sample %s has an empty age range", s.Key)
+		}
+		if s.Total.SD <= 0 {
+			return fmt.Errorf("normative sample %s has no total standard deviation", s.Key)
+		}
+		for _, d := range raadsDomains {
+			if s.Domains[d.Key].SD <= 0 {
+				return fmt.Errorf("normative sample %s has no %s standard deviation", s.Key, d.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func knownGender(gender string) bool {
+	for _, known := range demographicGenders {
+		if gender == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDemographics checks the demographics of an assessment, and warns
+// when no sample of a group covers the age of the participant
+func validateDemographics(ctx context.Context, demographics *Demographics) error {
+	if demographics == nil {
+		return nil
+	}
+	if demographics.Age != 0 && (demographics.Age < 16 || demographics.Age > 120) {
+		return fmt.Errorf("age must be between 16 and 120, got %d", demographics.Age)
+	}
+	if demographics.Gender != "" && !knownGender(demographics.Gender) {
+		return fmt.Errorf("unknown gender %q (available: %s)", demographics.Gender, strings.Join(demographicGenders, ", "))
+	}
+	for _, group := range normativeGroups() {
+		if sample, covered := normativeSampleFor(group, *demographics); !covered {
+			warningsFrom(ctx).Add(Warning{
+				Code:    warnNormsOutOfRange,
+				Message: fmt.Sprintf("no %s sample covers age %d, percentile ranks use %s instead", group, demographics.Age, sample.Label),
+			})
+		}
+	}
+	return nil
+}
+
+// normativeGroups lists the groups of the samples, in the order of the file
+func normativeGroups() []string {
+	var groups []string
+	seen := map[string]bool{}
+	for _, s := range normativeSamples {
+		if !seen[s.Group] {
+			seen[s.Group] = true
+			groups = append(groups, s.Group)
+		}
+	}
+	return groups
+}
+
+// normativeSampleFor selects the most specific sample of a group for the
+// participant: of their gender rather than of all genders, then of the
+// narrowest age range including their age. When none includes it, the
+// widest one is returned, and covered is false.
+func normativeSampleFor(group string, demographics Demographics) (sample NormativeSample, covered bool) {
+	found := false
+	for _, s := range normativeSamples {
+		if s.Group != group || s.Gender != "" && s.Gender != demographics.Gender {
+			continue
+		}
+		covers := s.covers(demographics.Age)
+		switch {
+		case !found:
+		case covers != covered:
+			if !covers {
+				continue
+			}
+		case !covers:
+			if s.ageSpan() <= sample.ageSpan() {
+				continue
+			}
+		case (s.Gender != "") != (sample.Gender != ""):
+			if s.Gender == "" {
+				continue
+			}
+		case s.ageSpan() >= sample.ageSpan():
+			continue
+		}
+		sample, covered, found = s, covers, true
+	}
+	return sample, covered
+}
+
+// Percentiles are the percentile ranks of the scores of a participant in
+// the normative sample of each group
+type Percentiles struct {
+	Method string             `json:"method"`
+	Age    int                `json:"age,omitempty"`
+	Gender string             `json:"gender,omitempty"`
+	Groups []GroupPercentiles `json:"groups"`
+}
+
+// GroupPercentiles are the percentile ranks of the total and domain scores
+// in the sample of a group
+type GroupPercentiles struct {
+	Group   string         `json:"group"`
+	Sample  string         `json:"sample"`
+	Label   string         `json:"label"`
+	Source  string         `json:"source"`
+	Total   int            `json:"total"`
+	Domains map[string]int `json:"domains"`
+}
+
+// Percentile ranks assume normally distributed scores in each sample, an
+// approximation given how skewed the scores of non-autistic samples are
+const percentileMethod = "normal approximation from the sample mean and standard deviation"
+
+// normativePercentiles ranks the scores of an assessment that states
+// demographics, or returns nil
+func normativePercentiles(data AssessmentData) *Percentiles {
+	if data.Demographics == nil {
+		return nil
+	}
+	totals := domainTotals(data)
+	percentiles := &Percentiles{Method: percentileMethod, Age: data.Demographics.Age, Gender: data.Demographics.Gender}
+	for _, group := range normativeGroups() {
+		sample, _ := normativeSampleFor(group, *data.Demographics)
+		ranks := GroupPercentiles{
+			Group:   group,
+			Sample:  sample.Key,
+			Label:   sample.Label,
+			Source:  sample.Source,
+			Total:   percentileRank(float64(data.Scores.Total), sample.Total),
+			Domains: make(map[string]int, len(raadsDomains)),
+		}
+		for _, d := range raadsDomains {
+			ranks.Domains[d.Key] = percentileRank(float64(totals[d.Key]), sample.Domains[d.Key])
+		}
+		percentiles.Groups = append(percentiles.Groups, ranks)
+	}
+	return percentiles
+}
+
+// percentilesMetadata returns the percentile ranks sent with the stream
+// metadata, nil when no demographics were given
+func percentilesMetadata(data AssessmentData) *streamproto.Percentiles {
+	percentiles := normativePercentiles(data)
+	if percentiles == nil {
+		return nil
+	}
+	metadata := &streamproto.Percentiles{Method: percentiles.Method, Age: percentiles.Age, Gender: percentiles.Gender}
+	for _, group := range percentiles.Groups {
+		metadata.Groups = append(metadata.Groups, streamproto.GroupPercentiles(group))
+	}
+	return metadata
+}
+
+// percentileRank is the share of a sample scoring below a score, between
+// 1 and 99
+func percentileRank(score float64, stat normStat) int {
+	z := (score - stat.Mean) / stat.SD
+	rank := math.Round(50 * math.Erfc(-z/math.Sqrt2))
+	return int(math.Max(1, math.Min(99, rank)))
+}
+
+// Describe states who the participant is compared as, e.g. "34 year old
+// female", for the prompt
+func (p *Percentiles) Describe() string {
+	var parts []string
+	if p.Age != 0 {
+		parts = append(parts, strconv.Itoa(p.Age)+" year old")
+	}
+	if p.Gender != "" {
+		parts = append(parts, p.Gender)
+	}
+	if len(parts) == 0 {
+		return "adult"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Describe lists the percentile ranks of the group for the prompt, e.g.
+// "total 97th, social 95th, ..."
+func (g GroupPercentiles) Describe() string {
+	parts := []string{"total " + ordinal(g.Total)}
+	for _, d := range raadsDomains {
+		parts = append(parts, d.Key+" "+ordinal(g.Domains[d.Key]))
+	}
+	return strings.Join(parts, ", ") + " percentile"
+}
+
+// ordinal formats a number as an English ordinal, e.g. 21st
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// checkNormativeSamples verifies the normative samples, and that scores at
+// a sample mean rank at the 50th percentile
+func checkNormativeSamples() checkResult {
+	result := checkResult{Name: "normative samples", Feature: "analysis"}
+	if normativeSamplesErr != nil {
+		result.Detail = normativeSamplesErr.Error()
+		return result
+	}
+	groups := normativeGroups()
+	if len(groups) == 0 {
+		result.Detail = "no normative sample"
+		return result
+	}
+	for _, group := range groups {
+		sample, covered := normativeSampleFor(group, Demographics{})
+		if !covered {
+			result.Detail = fmt.Sprintf("no %s sample for participants of unknown age", group)
+			return result
+		}
+		if rank := percentileRank(sample.Total.Mean, sample.Total); rank != 50 {
+			result.Detail = fmt.Sprintf("the %s total mean ranks at the %s percentile", sample.Key, ordinal(rank))
+			return result
+		}
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("%d samples in %d groups (%s)", len(normativeSamples), len(groups), strings.Join(groups, ", "))
+	return result
+}
@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+// TestNormativeSampleMeans makes sure the mean of every sample ranks at
+// the 50th percentile
+func TestNormativeSampleMeans(t *testing.T) {
+	for _, sample := range normativeSamples {
+		if rank := percentileRank(sample.Total.Mean, sample.Total); rank != 50 {
+			t.Errorf("the %s total mean ranks at the %s percentile", sample.Key, ordinal(rank))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "regexp"
+
+// Patterns of personal data commonly found in free-text comments, in the
+// order they are applied
+var piiPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`[\p{L}\p{N}._%+-]+@[\p{L}\p{N}.-]+\.\p{L}{2,}`), "[email]"},
+	{regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`), "[url]"},
+	{regexp.MustCompile(`\+?\d[\d ().-]{7,}\d`), "[phone]"},
+	{regexp.MustCompile(`@\w{2,}`), "[handle]"},
+}
+
+// redactPII replaces email addresses, URLs, phone numbers and social media
+// handles with placeholders
+func redactPII(text string) string {
+	for _, p := range piiPatterns {
+		text = p.pattern.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
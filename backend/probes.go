@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Heartbeat age after which the process is reported as not alive
+	livenessStallAfter = time.Duration(envInt("LIVENESS_STALL_SECONDS", 10)) * time.Second
+
+	// Time between readiness flipping on shutdown and the server closing,
+	// for load balancers to stop sending requests
+	shutdownDrainDelay = time.Duration(envInt("SHUTDOWN_DRAIN_SECONDS", 5)) * time.Second
+
+	// Longest wait for in-flight requests, streams included, on shutdown
+	shutdownTimeout = time.Duration(envInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+)
+
+const heartbeatInterval = time.Second
+
+var (
+	lastHeartbeat atomic.Int64
+	warmedUp      atomic.Bool
+	shuttingDown  atomic.Bool
+)
+
+// startHeartbeat records a timestamp every second from a goroutine, so
+// that liveness reflects whether the scheduler still runs goroutines
+func startHeartbeat() {
+	lastHeartbeat.Store(time.Now().UnixNano())
+	go func() {
+		for range time.Tick(heartbeatInterval) {
+			lastHeartbeat.Store(time.Now().UnixNano())
+		}
+	}()
+}
+
+// livenessProblem returns why the process is not alive, if it is not
+func livenessProblem(lastBeat, now time.Time) error {
+	if age := now.Sub(lastBeat); age > livenessStallAfter {
+		return fmt.Errorf("heartbeat stalled for %s", age.Round(time.Second))
+	}
+	return nil
+}
+
+// readinessCheck is a condition for serving generations
+type readinessCheck struct {
+	Name  string
+	Check func() error
+}
+
+var (
+	readinessMu     sync.RWMutex
+	readinessChecks = []readinessCheck{
+		flagReadiness("warmup", &warmedUp, true, "cached analyses and reports are still loading"),
+		flagReadiness("shutdown", &shuttingDown, false, "server is shutting down"),
+		poolReadiness(workers),
+		chainReadiness(),
+	}
+)
+
+// registerReadinessCheck adds a condition for serving generations
+func registerReadinessCheck(check readinessCheck) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	readinessChecks = append(readinessChecks, check)
+}
+
+func flagReadiness(name string, flag *atomic.Bool, ready bool, reason string) readinessCheck {
+	return readinessCheck{Name: name, Check: func() error {
+		if flag.Load() != ready {
+			return errors.New(reason)
+		}
+		return nil
+	}}
+}
+
+func poolReadiness(pool *workerPool) readinessCheck {
+	return readinessCheck{Name: "worker pool", Check: func() error {
+		if pool.Saturated() {
+			return fmt.Errorf("all %d workers busy and the queue of %d is full", pool.size, cap(pool.queue))
+		}
+		return nil
+	}}
+}
+
+// evaluateReadiness runs readiness checks, returning whether all passed
+// and the outcome of each
+func evaluateReadiness(checks []readinessCheck) (bool, map[string]string) {
+	ready := true
+	outcomes := make(map[string]string, len(checks))
+	for _, check := range checks {
+		if err := check.Check(); err != nil {
+			ready = false
+			outcomes[check.Name] = err.Error()
+			continue
+		}
+		outcomes[check.Name] = "ok"
+	}
+	return ready, outcomes
+}
+
+func currentReadiness() (bool, map[string]string) {
+	readinessMu.RLock()
+	defer readinessMu.RUnlock()
+	return evaluateReadiness(readinessChecks)
+}
+
+// livezHandler reports whether the process is alive. It never depends on
+// Claude or any store, so that a dependency outage never restarts it.
+func livezHandler(c *gin.Context) {
+	if err := livenessProblem(time.Unix(0, lastHeartbeat.Load()), time.Now()); err != nil {
+		c.JSON(503, gin.H{"status": "stalled", "error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"status": "alive"})
+}
+
+// readyzHandler reports whether generations can be served right now
+func readyzHandler(c *gin.Context) {
+	ready, checks := currentReadiness()
+	if !ready {
+		c.JSON(503, gin.H{"status": "not_ready", "checks": checks})
+		return
+	}
+	c.JSON(200, gin.H{"status": "ready", "checks": checks})
+}
+
+// serveUntilSignal serves until SIGTERM or SIGINT, then flips readiness,
+// waits for load balancers to notice and shuts down gracefully
+func serveUntilSignal(srv *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Printf("🛑 Received %s, no longer ready, shutting down in %s", sig, shutdownDrainDelay)
+	}
+
+	shuttingDown.Store(true)
+	time.Sleep(shutdownDrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLivenessProblem(t *testing.T) {
+	now := time.Now()
+	if err := livenessProblem(now.Add(-heartbeatInterval), now); err != nil {
+		t.Errorf("alive process reported as stalled: %v", err)
+	}
+	if livenessProblem(now.Add(-2*livenessStallAfter), now) == nil {
+		t.Error("stalled heartbeat reported as alive")
+	}
+}
+
+// TestReadiness simulates each degradation and makes sure only the
+// readiness check it concerns flips
+func TestReadiness(t *testing.T) {
+	var warm, stopping atomic.Bool
+	var slot *workerSlot
+	pool := newWorkerPool(1, 0, nil)
+	breaker := newCircuitBreaker(1, time.Hour, nil)
+	checks := []readinessCheck{
+		flagReadiness("warmup", &warm, true, "warming up"),
+		flagReadiness("shutdown", &stopping, false, "shutting down"),
+		poolReadiness(pool),
+		breakerReadiness(breaker),
+	}
+
+	steps := []struct {
+		name    string
+		failing string
+		apply   func()
+	}{
+		{"before warm-up", "warmup", func() {}},
+		{"after warm-up", "", func() { warm.Store(true) }},
+		{"saturated pool", "worker pool", func() { slot, _ = pool.Acquire(context.Background(), classInteractive) }},
+		{"drained pool", "", func() { slot.Release(false) }},
+		{"open breaker", "AI service", func() { breaker.Record(&claudeAPIError{Status: 503}) }},
+		{"recovered AI service", "", func() {
+			breaker.now = func() time.Time { return time.Now().Add(time.Hour) }
+			breaker.Allow()
+			breaker.Record(nil)
+		}},
+		{"shutdown", "shutdown", func() { stopping.Store(true) }},
+	}
+	for _, step := range steps {
+		step.apply()
+		ready, outcomes := evaluateReadiness(checks)
+		for name, outcome := range outcomes {
+			if (name == step.failing) == (outcome == "ok") {
+				t.Errorf("%s: %s is %q", step.name, name, outcome)
+			}
+		}
+		if ready != (step.failing == "") {
+			t.Errorf("%s: ready is %t", step.name, ready)
+		}
+	}
+}
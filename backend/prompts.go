@@ -0,0 +1,324 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates/prompts
+var promptTemplateFS embed.FS
+
+var (
+	// Prompt version served to a share of the assessments, to evaluate a
+	// prompt change on real traffic before making it the default
+	promptCandidateVersion = envInt("PROMPT_CANDIDATE_VERSION", 0)
+
+	// Percentage of assessments analyzed with the candidate prompt version
+	promptCandidatePercent = envInt("PROMPT_CANDIDATE_PERCENT", 0)
+)
+
+// promptRegistry holds the prompt templates of every version, one
+// templates/prompts/v<version> directory each
+var promptRegistry = mustLoadPromptRegistry(promptTemplateFS)
+
+func mustLoadPromptRegistry(fsys fs.FS) map[int]*template.Template {
+	registry, err := loadPromptRegistry(fsys)
+	if err != nil {
+		panic(fmt.Sprintf("invalid prompt templates: %v", err))
+	}
+	if _, ok := registry[promptVersion]; !ok {
+		panic(fmt.Sprintf("invalid prompt templates: no templates for the current prompt version %d", promptVersion))
+	}
+	for _, key := range instrumentKeys {
+		name := instrumentOf(AssessmentData{Instrument: key}).AnalysisTemplate
+		if registry[promptVersion].Lookup(name) == nil {
+			panic(fmt.Sprintf("invalid prompt templates: the current prompt version %d has no %s", promptVersion, name))
+		}
+	}
+	return registry
+}
+
+// loadPromptRegistry parses the templates of each version directory. The
+// analysis and domain prompts include the participant context and retake
+// blocks by name.
+func loadPromptRegistry(fsys fs.FS) (map[int]*template.Template, error) {
+	dirs, err := fs.ReadDir(fsys, "templates/prompts")
+	if err != nil {
+		return nil, err
+	}
+	registry := make(map[int]*template.Template, len(dirs))
+	for _, dir := range dirs {
+		version, err := strconv.Atoi(strings.TrimPrefix(dir.Name(), "v"))
+		if !dir.IsDir() || !strings.HasPrefix(dir.Name(), "v") || err != nil || version < 1 {
+			return nil, fmt.Errorf("%s is not a version directory such as v2", dir.Name())
+		}
+		templates, err := template.New("prompts").
+			Option("missingkey=error").
+			Funcs(template.FuncMap{
+				"date":      func(t time.Time) string { return t.Format("January 2, 2006") },
+				"questions": questionReferences,
+				"threshold": promptThreshold,
+				"upper":     strings.ToUpper,
+			}).
+			ParseFS(fsys, "templates/prompts/"+dir.Name()+"/*.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"analysis.tmpl", "domain.tmpl"} {
+			if templates.Lookup(name) == nil {
+				return nil, fmt.Errorf("version %d has no %s", version, name)
+			}
+		}
+		registry[version] = templates
+	}
+	return registry, nil
+}
+
+// promptVersionFor selects the prompt version of an assessment. The split
+// is by assessment hash, so that an assessment always gets the same version
+// and its cached analysis stays current.
+func promptVersionFor(hash string) int {
+	if !promptCandidateActive() || len(hash) < 8 {
+		return promptVersion
+	}
+	bucket, err := strconv.ParseUint(hash[:8], 16, 32)
+	if err != nil || int(bucket%100) >= promptCandidatePercent {
+		return promptVersion
+	}
+	return promptCandidateVersion
+}
+
+// assessmentPromptVersion is promptVersionFor an assessment
+func assessmentPromptVersion(data AssessmentData) int {
+	hash, err := assessmentHash(data)
+	if err != nil {
+		return promptVersion
+	}
+	return instrumentPromptVersion(data, hash)
+}
+
+// instrumentPromptVersion is promptVersionFor an assessment of a given
+// hash. The candidate version only serves the instruments it has an
+// analysis template for, the others keep the current version.
+func instrumentPromptVersion(data AssessmentData, hash string) int {
+	version := promptVersionFor(hash)
+	if promptRegistry[version].Lookup(instrumentOf(data).AnalysisTemplate) == nil {
+		return promptVersion
+	}
+	return version
+}
+
+func promptCandidateActive() bool {
+	_, ok := promptRegistry[promptCandidateVersion]
+	return ok && promptCandidateVersion != promptVersion && promptCandidatePercent > 0
+}
+
+// servedPromptVersion reports whether new analyses may be generated with
+// a prompt version, the current one or the candidate
+func servedPromptVersion(version int) bool {
+	return version == promptVersion || promptCandidateActive() && version == promptCandidateVersion
+}
+
+// Tones of the reports, for the audience they are written for
+const (
+	toneClinical      = "clinical"
+	tonePlainLanguage = "plain-language"
+	toneCompassionate = "compassionate"
+)
+
+var reportTones = []string{toneClinical, tonePlainLanguage, toneCompassionate}
+
+// reportTone returns the tone of an assessment, clinical by default
+func reportTone(data AssessmentData) string {
+	if data.Tone == "" {
+		return toneClinical
+	}
+	return data.Tone
+}
+
+func validateTone(tone string) error {
+	if tone == "" {
+		return nil
+	}
+	for _, known := range reportTones {
+		if tone == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown tone %q (available: %s)", tone, strings.Join(reportTones, ", "))
+}
+
+// promptData is what the prompt templates are rendered with
+type promptData struct {
+	// Name of the language the model must answer in
+	Language string
+	Tone     string
+	// Name of the instrument, e.g. RAADS-R
+	Test string
+	// Assessment JSON, or the reference to its attachment
+	Assessment         string
+	TestDate           time.Time
+	Scores             Scores
+	Interpretation     Interpretation
+	Profile            ReferenceProfile
+	AnsweredQuestions  int
+	TotalQuestions     int
+	CompletionRate     float64
+	CommentsCount      int
+	ParticipantContext string
+	Retake             *RetakeLineage
+	Percentiles        *Percentiles
+	// Cut-off and subscale scores of the instruments without domains
+	Threshold int
+	Subscales []SubscaleScore
+	// Brain type of the EQ/SQ, from the difference between both scores
+	BrainType *BrainType
+	// Item clusters of the RAADS-R domains, for sub-patterns within them
+	Clusters []ClusterScore
+	// Response patterns casting doubt on the answers
+	Validity []ValidityFlag
+	// Summaries of the assessments of a composite report, one per instrument
+	Instruments []promptData
+
+	// Domain of a domain report, and its score
+	Domain      Domain
+	DomainScore int
+}
+
+// newPromptData gathers what the prompts say about an assessment
+func newPromptData(data AssessmentData, input assessmentInput) (promptData, error) {
+	profile, err := referenceProfileFor(data.ReferenceProfile)
+	if err != nil {
+		return promptData{}, err
+	}
+
+	language := supportedLanguages[data.Language]
+	if language == "" {
+		language = "English" // fallback
+	}
+
+	commentsCount := 0
+	for _, qa := range data.QuestionsAndAnswers {
+		if qa.Comment != nil && strings.TrimSpace(*qa.Comment) != "" {
+			commentsCount++
+		}
+	}
+
+	instrument := instrumentOf(data)
+	prompt := promptData{
+		Language:           language,
+		Tone:               reportTone(data),
+		Test:               instrument.Test.Name,
+		Assessment:         input.PromptData(),
+		TestDate:           data.Metadata.LocalTestDate(),
+		Scores:             data.Scores,
+		Interpretation:     data.Interpretation,
+		Profile:            profile,
+		AnsweredQuestions:  data.Metadata.AnsweredQuestions,
+		TotalQuestions:     data.Metadata.TotalQuestions,
+		CompletionRate:     float64(data.Metadata.AnsweredQuestions) / float64(data.Metadata.TotalQuestions) * 100,
+		CommentsCount:      commentsCount,
+		ParticipantContext: promptSafeContext(data.AdditionalContext),
+		Retake:             data.Lineage,
+		Percentiles:        normativePercentiles(data),
+		Threshold:          instrument.Threshold,
+		Validity:           validityFlags(data),
+	}
+	switch instrument.Key {
+	case instrumentRAADSR:
+		prompt.Clusters = itemClusterScores(data)
+	case instrumentAQ50, instrumentRBQ2A:
+		prompt.Subscales = subscaleScores(data)
+	case instrumentEQSQ:
+		prompt.BrainType = eqsqBrainType(data.Scores)
+	}
+	return prompt, nil
+}
+
+// renderPrompt renders a prompt template of a version, without the final
+// newline of the file
+func renderPrompt(version int, name string, data promptData) (string, error) {
+	templates, ok := promptRegistry[version]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt version %d", version)
+	}
+	var prompt strings.Builder
+	if err := templates.ExecuteTemplate(&prompt, name, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s of version %d: %w", name, version, err)
+	}
+	return strings.TrimSuffix(prompt.String(), "\n"), nil
+}
+
+// systemPrompt renders the system prompt of a version for the tone of an
+// assessment. Versions without a system.tmpl have none.
+func systemPrompt(data AssessmentData, version int) (string, error) {
+	templates, ok := promptRegistry[version]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt version %d", version)
+	}
+	if templates.Lookup("system.tmpl") == nil {
+		return "", nil
+	}
+	return renderPrompt(version, "system.tmpl", promptData{Tone: reportTone(data), Test: instrumentOf(data).Test.Name})
+}
+
+// promptThreshold returns the clinical threshold of a domain, or of the
+// total score for "total"
+func promptThreshold(key string) (int, error) {
+	if key == "total" {
+		return totalThreshold, nil
+	}
+	domain, ok := domainByKey(key)
+	if !ok {
+		return 0, fmt.Errorf("unknown domain %q", key)
+	}
+	return domain.Threshold, nil
+}
+
+// questionReferences formats question numbers the way the prompts ask the
+// model to reference them, e.g. "Q1, Q2"
+func questionReferences(ids []int) string {
+	refs := make([]string, len(ids))
+	for i, id := range ids {
+		refs[i] = "Q" + strconv.Itoa(id)
+	}
+	return strings.Join(refs, ", ")
+}
+
+// checkPromptTemplates renders the prompts of every version for a sample
+// assessment in every language, with participant context, demographics and
+// a retake, verifies that they state the scoring thresholds, and that the
+// split between the current and the candidate versions follows the
+// percentage
+func checkPromptTemplates() checkResult {
+	result := checkResult{Name: "prompt templates", Feature: "analysis"}
+	if promptCandidateVersion != 0 {
+		if _, ok := promptRegistry[promptCandidateVersion]; !ok {
+			result.Detail = fmt.Sprintf("PROMPT_CANDIDATE_VERSION %d has no templates", promptCandidateVersion)
+			return result
+		}
+	}
+	if promptCandidatePercent < 0 || promptCandidatePercent > 100 {
+		result.Detail = "PROMPT_CANDIDATE_PERCENT must be between 0 and 100"
+		return result
+	}
+
+	versions := make([]int, 0, len(promptRegistry))
+	for version := range promptRegistry {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("versions %v, version %d is current with %d tones", versions, promptVersion, len(reportTones))
+	if promptCandidateActive() {
+		result.Detail += fmt.Sprintf(", version %d serves %d%% of assessments", promptCandidateVersion, promptCandidatePercent)
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// promptSample is an assessment setting every optional field the prompts
+// mention
+func promptSample() AssessmentData {
+	comment := "Sample comment"
+	return AssessmentData{
+		Metadata:            Metadata{TestName: raadsR.Name, TestDate: time.Now(), AnsweredQuestions: 1, TotalQuestions: 1},
+		QuestionsAndAnswers: []QuestionAndAnswer{{ID: 1, Category: "IS", Answer: 2, AnswerText: "Never true", Comment: &comment}},
+		AdditionalContext:   "Sample context",
+		Demographics:        &Demographics{Age: 34, Gender: "female"},
+		Lineage:             &RetakeLineage{BaseTestDate: time.Now(), RetakeDate: time.Now(), UpdatedItems: []int{1}},
+	}
+}
+
+func TestPromptTemplates(t *testing.T) {
+	data := promptSample()
+	input := assessmentInput{Mode: inputModeInline, Inline: "{}"}
+	versions := make([]int, 0, len(promptRegistry))
+	for version := range promptRegistry {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	for _, version := range versions {
+		for code, language := range supportedLanguages {
+			t.Run(fmt.Sprintf("v%d/%s", version, code), func(t *testing.T) {
+				data.Language = code
+				analysis, err := analysisPrompt(data, input, version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, domain := range raadsDomains {
+					prompt, err := domainReportPrompt(data, domain, input, version)
+					if err != nil {
+						t.Fatal(err)
+					}
+					threshold := fmt.Sprintf("threshold: %d,", domain.Threshold)
+					if !strings.Contains(analysis, threshold) || !strings.Contains(prompt, threshold) {
+						t.Errorf("the prompts do not state the %s threshold of %d", domain.Key, domain.Threshold)
+					}
+				}
+				for _, expected := range []string{"IN " + language + " LANGUAGE", "Sample context", "PARTIAL RETAKE", "Q1 were answered"} {
+					if !strings.Contains(analysis, expected) {
+						t.Errorf("the analysis prompt lacks %q", expected)
+					}
+				}
+				if strings.Contains(analysis, "<no value>") {
+					t.Error("the analysis prompt has an unset placeholder")
+				}
+			})
+		}
+	}
+}
+
+// TestPromptTones makes sure each tone gets its own system prompt and
+// writing instructions
+func TestPromptTones(t *testing.T) {
+	data := promptSample()
+	data.Language = "en"
+	input := assessmentInput{Mode: inputModeInline, Inline: "{}"}
+	seen := map[string]string{}
+	for _, tone := range reportTones {
+		data.Tone = tone
+		system, err := systemPrompt(data, promptVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		analysis, err := analysisPrompt(data, input, promptVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if system == "" {
+			t.Fatalf("version %d has no system prompt", promptVersion)
+		}
+		if other, ok := seen[system+analysis]; ok {
+			t.Errorf("tones %s and %s give the same prompts", other, tone)
+		}
+		seen[system+analysis] = tone
+	}
+}
+
+// TestPromptCandidate makes sure hashes spread evenly over the buckets, so
+// the candidate gets its percentage of 10000 of them give or take a point
+func TestPromptCandidate(t *testing.T) {
+	previousVersion, previousPercent := promptCandidateVersion, promptCandidatePercent
+	t.Cleanup(func() { promptCandidateVersion, promptCandidatePercent = previousVersion, previousPercent })
+
+	for _, percent := range []int{0, 10, 50, 100} {
+		t.Run(strconv.Itoa(percent), func(t *testing.T) {
+			promptCandidateVersion, promptCandidatePercent = promptVersion-1, percent
+			candidates := 0
+			for i := 0; i < 10000; i++ {
+				sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+				if promptVersionFor(hex.EncodeToString(sum[:])) == promptCandidateVersion {
+					candidates++
+				}
+			}
+			if expected := percent * 100; candidates < expected-100 || candidates > expected+100 {
+				t.Errorf("%d of 10000 assessments get the candidate prompt, instead of %d", candidates, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ProviderCapabilities lists the optional features an LLM provider supports
+type ProviderCapabilities struct {
+	// Documents can be attached as content blocks instead of inlined
+	Documents bool
+	// Documents can be uploaded once and referenced by id
+	FilesAPI bool
+}
+
+const (
+	providerAnthropic = "anthropic"
+	providerOpenAI    = "openai"
+	providerGemini    = "gemini"
+	providerOllama    = "ollama"
+	providerBedrock   = "bedrock"
+	// Chat completions of an Azure OpenAI deployment, sent by the openai
+	// provider code
+	providerAzureOpenAI = "azure-openai"
+)
+
+// Capabilities of each supported provider
+var providerCapabilities = map[string]ProviderCapabilities{
+	providerAnthropic:   {Documents: true, FilesAPI: true},
+	providerOpenAI:      {},
+	providerGemini:      {},
+	providerOllama:      {},
+	providerBedrock:     {},
+	providerAzureOpenAI: {},
+	providerTemplate:    {},
+}
+
+// activeProvider is the provider analyses are sent to, set by LLM_PROVIDER
+var activeProvider = envString("LLM_PROVIDER", providerAnthropic)
+
+// unconfiguredProvider is the provider the template replaced for lack of
+// credentials, if any
+var unconfiguredProvider string
+
+// loadProvider validates the configuration of the active provider and of
+// the fallback providers
+func loadProvider() error {
+	if err := loadProviderConfig(activeProvider); err != nil {
+		if _, known := providerCapabilities[activeProvider]; !known || !templateFallback {
+			return err
+		}
+		log.Printf("⚠️  %v: analyses only summarize the scores with the template until the %s provider is configured", err, activeProvider)
+		unconfiguredProvider, activeProvider = activeProvider, providerTemplate
+	}
+	for _, provider := range fallbackProviders {
+		if provider == providerTemplate {
+			continue
+		}
+		if err := loadProviderConfig(provider); err != nil {
+			return fmt.Errorf("fallback provider %s: %w", provider, err)
+		}
+	}
+	// Client API keys are checked whichever the provider, and a mistake in
+	// them would otherwise leave every client unauthorized
+	if err := claudeCredentials.Load(); err != nil && !errors.Is(err, errNoCredentials) {
+		return err
+	}
+	return nil
+}
+
+// loadProviderConfig validates the configuration of a provider
+func loadProviderConfig(provider string) error {
+	switch provider {
+	case providerAnthropic:
+		return claudeCredentials.Load()
+	case providerOpenAI:
+		if openAIAPIKey == "" {
+			return fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+		return nil
+	case providerGemini:
+		return loadGemini()
+	case providerOllama:
+		// The server is local and needs no credentials
+		return nil
+	case providerAzureOpenAI:
+		return loadAzureOpenAI()
+	case providerBedrock:
+		return loadBedrock()
+	case providerTemplate:
+		return nil
+	}
+	return fmt.Errorf("unknown LLM provider %q (supported: %s, %s, %s, %s, %s, %s, %s)", provider, providerAnthropic, providerOpenAI, providerGemini, providerOllama, providerAzureOpenAI, providerBedrock, providerTemplate)
+}
+
+// currentProviderCapabilities returns the capabilities of the active provider
+func currentProviderCapabilities() ProviderCapabilities {
+	return providerCapabilities[activeProvider]
+}
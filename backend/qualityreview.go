@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// Percentage of consenting generations kept for quality review
+	qualityReviewSamplePercent = envInt("QUALITY_REVIEW_SAMPLE_PERCENT", 2)
+
+	// Samples are deleted after this window
+	qualityReviewRetention = time.Duration(envInt("QUALITY_REVIEW_RETENTION_HOURS", 48)) * time.Hour
+
+	qualityReviewMaxSamples = 500
+)
+
+// QualitySample is a generated report kept to improve the prompt. It holds
+// no client identifier and comments are redacted.
+type QualitySample struct {
+	ID            string    `json:"id"`
+	Model         string    `json:"model"`
+	PromptVersion int       `json:"prompt_version"`
+	Tone          string    `json:"tone"`
+	Language      string    `json:"language"`
+	InputMode     string    `json:"input_mode"`
+	System        string    `json:"system,omitempty"`
+	Prompt        string    `json:"prompt"`
+	Assessment    string    `json:"assessment"`
+	Output        string    `json:"output"`
+	SampledAt     time.Time `json:"sampled_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type qualityReviewStore struct {
+	mu      sync.Mutex
+	samples map[string]QualitySample
+}
+
+var qualityReview = &qualityReviewStore{samples: make(map[string]QualitySample)}
+
+// Offer keeps a generation for review if, and only if, the request carried
+// explicit consent and the generation is picked by the sampling rate
+func (s *qualityReviewStore) Offer(data AssessmentData, input assessmentInput, prompt, output, model string, version int) {
+	if !data.AllowQualityReview || !featureQualityReview.Enabled() {
+		return
+	}
+	if qualityReviewSamplePercent <= 0 || rand.IntN(100) >= qualityReviewSamplePercent {
+		return
+	}
+
+	redacted := redactAssessment(data)
+	assessment, err := json.MarshalIndent(redacted, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ Failed to serialize quality review sample: %v", err)
+		return
+	}
+	// The inline JSON in the prompt still carries the original comments
+	if input.Inline != "" {
+		inline := redacted
+		inline.AdditionalContext = ""
+		if content, err := json.MarshalIndent(inline, "", "  "); err == nil {
+			prompt = strings.Replace(prompt, input.Inline, string(content), 1)
+		}
+	}
+	if data.AdditionalContext != "" {
+		prompt = strings.Replace(prompt, data.AdditionalContext, redacted.AdditionalContext, 1)
+	}
+
+	now := time.Now().UTC()
+	sample := QualitySample{
+		ID:            uuid.New().String(),
+		Model:         model,
+		PromptVersion: version,
+		Tone:          reportTone(data),
+		Language:      data.Language,
+		InputMode:     input.Mode,
+		System:        input.System,
+		Prompt:        prompt,
+		Assessment:    string(assessment),
+		Output:        redactPII(output),
+		SampledAt:     now,
+		ExpiresAt:     now.Add(qualityReviewRetention),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+	if len(s.samples) >= qualityReviewMaxSamples {
+		return
+	}
+	s.samples[sample.ID] = sample
+	log.Printf("🔎 Kept generation %s for quality review", sample.ID)
+}
+
+// Get returns a sample that has not expired
+func (s *qualityReviewStore) Get(id string) (QualitySample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	sample, ok := s.samples[id]
+	return sample, ok
+}
+
+// List returns the samples that have not expired, oldest first
+func (s *qualityReviewStore) List() []QualitySample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	samples := make([]QualitySample, 0, len(s.samples))
+	for _, sample := range s.samples {
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].SampledAt.Before(samples[j].SampledAt) })
+	return samples
+}
+
+func (s *qualityReviewStore) pruneLocked(now time.Time) {
+	for id, sample := range s.samples {
+		if now.After(sample.ExpiresAt) {
+			delete(s.samples, id)
+		}
+	}
+}
+
+// redactAssessment returns a copy of the assessment with comments and the
+// additional context passed through the PII redaction
+func redactAssessment(data AssessmentData) AssessmentData {
+	answers := make([]QuestionAndAnswer, len(data.QuestionsAndAnswers))
+	copy(answers, data.QuestionsAndAnswers)
+	for i, qa := range answers {
+		if qa.Comment != nil {
+			redacted := redactPII(*qa.Comment)
+			answers[i].Comment = &redacted
+		}
+	}
+	data.QuestionsAndAnswers = answers
+	data.AdditionalContext = redactPII(data.AdditionalContext)
+	return data
+}
+
+// logGenerationSizes logs prompt and output sizes without their content
+func logGenerationSizes(model string, input assessmentInput, prompt, output string) {
+	log.Printf("📏 generation model=%s input_mode=%s prompt_bytes=%d output_bytes=%d output_words=%d",
+		model, input.Mode, len(prompt), len(output), countWords(output))
+}
+
+// adminQualityReviewListHandler lists the samples kept for quality review
+func adminQualityReviewListHandler(c *gin.Context) {
+	samples := qualityReview.List()
+	c.JSON(200, gin.H{
+		"samples":         samples,
+		"sample_percent":  qualityReviewSamplePercent,
+		"retention_hours": int(qualityReviewRetention.Hours()),
+	})
+}
+
+// adminQualityReviewHandler returns a single quality review sample
+func adminQualityReviewHandler(c *gin.Context) {
+	sample, ok := qualityReview.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Sample not found or expired"})
+		return
+	}
+	c.JSON(200, sample)
+}
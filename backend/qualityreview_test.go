@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// useQualityReview samples every consenting generation into an empty
+// quality review store for the duration of a test
+func useQualityReview(t *testing.T) *qualityReviewStore {
+	t.Helper()
+	previous, previousPercent := qualityReview, qualityReviewSamplePercent
+	t.Cleanup(func() { qualityReview, qualityReviewSamplePercent = previous, previousPercent })
+	qualityReview = &qualityReviewStore{samples: make(map[string]QualitySample)}
+	qualityReviewSamplePercent = 100
+	return qualityReview
+}
+
+// consentingAssessment is answeredAssessment with allowQualityReview set
+func consentingAssessment(t *testing.T, context string, consent bool) []byte {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(answeredAssessment(t, context), &body); err != nil {
+		t.Fatal(err)
+	}
+	body["allowQualityReview"] = consent
+	content, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// TestQualityReviewConsent makes sure generations are only ever sampled
+// with the consent of the participant, even when every generation is, and
+// that consenting ones are sampled without personal data
+func TestQualityReviewConsent(t *testing.T) {
+	startFakeClaude(t)
+	for _, path := range []string{"/analyze", "/analyze-stream"} {
+		for _, consent := range []bool{false, true} {
+			store := useQualityReview(t)
+			context := "TestQualityReviewConsent " + path + ", contact jane@example.com"
+			if consent {
+				context += " with consent"
+			}
+			w := serve(t, "POST", path, consentingAssessment(t, context, consent), nil)
+			if w.Code != 200 {
+				t.Fatalf("%s with consent %t: status %d: %s", path, consent, w.Code, w.Body.String())
+			}
+
+			samples := store.List()
+			if !consent {
+				if len(samples) != 0 {
+					t.Errorf("%s sampled %d generations without consent", path, len(samples))
+				}
+				continue
+			}
+			if len(samples) != 1 {
+				t.Fatalf("%s sampled %d consenting generations instead of 1", path, len(samples))
+			}
+			for name, content := range map[string]string{"prompt": samples[0].Prompt, "assessment": samples[0].Assessment} {
+				if strings.Contains(content, "jane@example.com") || !strings.Contains(content, "[email]") {
+					t.Errorf("%s sampled a %s whose email was not redacted", path, name)
+				}
+			}
+		}
+	}
+}
+
+// TestQualityReviewOffer makes sure nothing is sampled without consent,
+// whichever the sampling rate
+func TestQualityReviewOffer(t *testing.T) {
+	tests := []struct {
+		consent bool
+		percent int
+		sampled int
+	}{
+		{false, 0, 0},
+		{false, 100, 0},
+		{true, 0, 0},
+		{true, 100, 1},
+	}
+	for _, tc := range tests {
+		store := useQualityReview(t)
+		qualityReviewSamplePercent = tc.percent
+		store.Offer(AssessmentData{AllowQualityReview: tc.consent}, assessmentInput{Mode: "full"}, "prompt", "output", "model", 1)
+		if sampled := len(store.List()); sampled != tc.sampled {
+			t.Errorf("consent %t at %d%%: %d generations sampled instead of %d", tc.consent, tc.percent, sampled, tc.sampled)
+		}
+	}
+}
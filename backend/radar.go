@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Layout of the radar chart, in SVG user units
+const (
+	radarWidth  = 520
+	radarCX     = 260
+	radarCY     = 170
+	radarRadius = 130
+	radarHeight = radarCY + radarRadius + 40 + 3*chartLegendH
+)
+
+// Rings of the radar grid, as fractions of the domain maximums
+var radarRings = []float64{0.25, 0.5, 0.75, 1}
+
+var chartGridColor = color.RGBA{0xde, 0xe2, 0xe6, 0xff}
+
+// radarSeries is a polygon of the radar chart, with a point per domain
+type radarSeries struct {
+	Label  string
+	Color  color.RGBA
+	Filled bool
+	Points [][2]float64
+}
+
+// radarPoint is where a fraction of the maximum lies on the i-th of n
+// axes, the first one pointing up
+func radarPoint(i, n int, fraction float64) [2]float64 {
+	angle := -math.Pi/2 + 2*math.Pi*float64(i)/float64(n)
+	r := radarRadius * min(max(fraction, 0), 1)
+	return [2]float64{radarCX + r*math.Cos(angle), radarCY + r*math.Sin(angle)}
+}
+
+// radarDomains are the bars of the domains, without the total
+func (c scoreChart) radarDomains() []scoreChartBar {
+	return c.Bars[1:]
+}
+
+// radarSeries are the polygons of the radar chart in drawing order: the
+// reference means, the thresholds and the scores, each domain scaled to
+// its maximum
+func (c scoreChart) radarSeries() []radarSeries {
+	series := []radarSeries{
+		{Label: c.ReferenceLabel, Color: chartReferenceColor, Filled: true},
+		{Label: c.ThresholdLabel, Color: chartThresholdColor},
+		{Label: c.ScoreLabel, Color: chartBarColor, Filled: true},
+	}
+	domains := c.radarDomains()
+	for i, d := range domains {
+		fraction := func(value float64) float64 {
+			if d.Max <= 0 {
+				return 0
+			}
+			return value / float64(d.Max)
+		}
+		series[0].Points = append(series[0].Points, radarPoint(i, len(domains), fraction(d.Reference)))
+		series[1].Points = append(series[1].Points, radarPoint(i, len(domains), fraction(float64(d.Threshold))))
+		series[2].Points = append(series[2].Points, radarPoint(i, len(domains), fraction(float64(d.Score))))
+	}
+	return series
+}
+
+// radarLabel places the label of the i-th axis beyond its end, anchored
+// away from the center
+func radarLabel(i, n int) (x, y float64, anchor string) {
+	end := radarPoint(i, n, 1)
+	dx, dy := end[0]-radarCX, end[1]-radarCY
+	x, y, anchor = end[0], end[1], "middle"
+	switch {
+	case dx > 1:
+		x, anchor = x+10, "start"
+	case dx < -1:
+		x, anchor = x-10, "end"
+	}
+	switch {
+	case dy > 1:
+		y += 14
+	case dy < -1:
+		y -= 14
+	}
+	return x, y, anchor
+}
+
+func svgPoints(points [][2]float64) string {
+	coords := make([]string, 0, len(points))
+	for _, p := range points {
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", p[0], p[1]))
+	}
+	return strings.Join(coords, " ")
+}
+
+// RadarSVG renders the domain scores as a radar chart, over the thresholds
+// and the reference profile means, as a standalone SVG document
+func (c scoreChart) RadarSVG() string {
+	domains := c.radarDomains()
+	n := len(domains)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Open Sans, sans-serif" font-size="%d">`,
+		radarWidth, radarHeight, radarWidth, radarHeight, chartFontSize)
+
+	for _, ring := range radarRings {
+		points := make([][2]float64, 0, n)
+		for i := range domains {
+			points = append(points, radarPoint(i, n, ring))
+		}
+		fmt.Fprintf(&svg, `<polygon points="%s" fill="none" stroke="%s"/>`, svgPoints(points), svgColor(chartGridColor))
+	}
+	for i, d := range domains {
+		end := radarPoint(i, n, 1)
+		fmt.Fprintf(&svg, `<line x1="%d" y1="%d" x2="%.1f" y2="%.1f" stroke="%s"/>`, radarCX, radarCY, end[0], end[1], svgColor(chartGridColor))
+		x, y, anchor := radarLabel(i, n)
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="%s" dominant-baseline="middle">%s %d/%d</text>`, x, y, anchor, html.EscapeString(d.Label), d.Score, d.Max)
+	}
+
+	legendY := radarCY + radarRadius + 40
+	for _, s := range c.radarSeries() {
+		fill := "none"
+		if s.Filled {
+			fill = svgColor(s.Color) + `" fill-opacity="0.25`
+		}
+		fmt.Fprintf(&svg, `<polygon points="%s" fill="%s" stroke="%s" stroke-width="2"/>`, svgPoints(s.Points), fill, svgColor(s.Color))
+		fmt.Fprintf(&svg, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"/>`, radarCX-90, legendY, radarCX-74, legendY, svgColor(s.Color))
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`, radarCX-66, legendY, html.EscapeString(s.Label))
+		legendY += chartLegendH
+	}
+
+	svg.WriteString(`</svg>`)
+	return svg.String()
+}
+
+// RadarPNG renders the same radar chart as RadarSVG, at chartPNGScale
+// times its size
+func (c scoreChart) RadarPNG() ([]byte, error) {
+	canvas, err := newChartCanvas(radarWidth, radarHeight)
+	if err != nil {
+		return nil, err
+	}
+	domains := c.radarDomains()
+	n := len(domains)
+
+	outline := func(points [][2]float64, width float64, col color.Color) {
+		for i, p := range points {
+			next := points[(i+1)%len(points)]
+			canvas.Line(p[0], p[1], next[0], next[1], width, col)
+		}
+	}
+	for _, ring := range radarRings {
+		points := make([][2]float64, 0, n)
+		for i := range domains {
+			points = append(points, radarPoint(i, n, ring))
+		}
+		outline(points, 1, chartGridColor)
+	}
+	for i, d := range domains {
+		end := radarPoint(i, n, 1)
+		canvas.Line(radarCX, radarCY, end[0], end[1], 1, chartGridColor)
+		x, y, anchor := radarLabel(i, n)
+		canvas.Text(fmt.Sprintf("%s %d/%d", d.Label, d.Score, d.Max), x, y, anchor)
+	}
+
+	legendY := float64(radarCY + radarRadius + 40)
+	for _, s := range c.radarSeries() {
+		if s.Filled {
+			canvas.Polygon(color.NRGBA{s.Color.R, s.Color.G, s.Color.B, 0x40}, s.Points...)
+		}
+		outline(s.Points, 2, s.Color)
+		canvas.Line(radarCX-90, legendY, radarCX-74, legendY, 2, s.Color)
+		canvas.Text(s.Label, radarCX-66, legendY, "start")
+		legendY += chartLegendH
+	}
+
+	return canvas.PNG()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rbq2a is the adult Repetitive Behaviours Questionnaire of Barrett et al.
+// (2015), on its three point frequency scale
+var rbq2a = TestDefinition{
+	Name: "RBQ-2A",
+	ScaleLabels: map[string][]AnswerOption{
+		"en": {
+			{0, "A", "Never or rarely"},
+			{1, "B", "Mild or occasional"},
+			{2, "C", "Marked or notable"},
+		},
+	},
+}
+
+// Factors of the RBQ-2A: repetitive motor behaviours (items 1 to 8) and
+// insistence on sameness (items 9 to 20)
+var rbq2aSubscales = []Subscale{
+	{Key: "motor", Name: "Repetitive Motor Behaviours", Category: "RMB", Items: 8},
+	{Key: "sameness", Name: "Insistence on Sameness", Category: "IS", Items: 12},
+}
+
+const (
+	// Items score from 1 to 3, so that the mean item score of a factor
+	// reads on the answer scale
+	rbq2aItemMax  = 3
+	rbq2aMaxTotal = 20 * rbq2aItemMax
+	// Mean item score of the autistic adults of Barrett et al. (2015),
+	// about 1.2 for those without autism
+	rbq2aAutisticMean = 1.9
+)
+
+// RBQ-2A interpretation rules on the total, the last ones at mean item
+// scores of 1.5 and 2. The RBQ-2A has no clinical cut-off.
+var rbq2aInterpretationRules = []interpretationRule{
+	{"few", 0, 29},
+	{"some", 30, 39},
+	{"many", 40, rbq2aMaxTotal},
+}
+
+// rbq2aInstrument is the RBQ-2A for instrumentOf
+func rbq2aInstrument() Instrument {
+	return Instrument{
+		Key:              instrumentRBQ2A,
+		Test:             rbq2a,
+		MaxTotal:         rbq2aMaxTotal,
+		Rules:            rbq2aInterpretationRules,
+		Subscales:        rbq2aSubscales,
+		ItemMax:          rbq2aItemMax,
+		MeanScores:       true,
+		AnalysisTemplate: "rbq2a.tmpl",
+		Catalog:          rbq2aCatalogFor,
+		ItemScore:        rbq2aItemScore,
+		Scores:           rbq2aScoresFromItems,
+		Details:          rbq2aDetails,
+		TemplateMarkdown: rbq2aTemplateMarkdown,
+	}
+}
+
+var rbq2aCatalogs instrumentCatalogs
+
+// rbq2aCatalogFor returns the RBQ-2A catalog of a language
+func rbq2aCatalogFor(language string) (*LanguageCatalog, error) {
+	return rbq2aCatalogs.get(instrumentRBQ2A, rbq2a, language, func(code string, catalog *LanguageCatalog) error {
+		for _, q := range catalog.Questions {
+			if q.Category != rbq2aSubscales[0].Category && q.Category != rbq2aSubscales[1].Category {
+				return fmt.Errorf("question %d of the %s RBQ-2A catalog has unknown category %q", q.ID, code, q.Category)
+			}
+			if q.Reverse {
+				return fmt.Errorf("question %d of the %s RBQ-2A catalog is reversed, the RBQ-2A has no reverse items", q.ID, code)
+			}
+		}
+		for _, rule := range rbq2aInterpretationRules {
+			if _, ok := catalog.Interpretations[rule.Key]; !ok {
+				return fmt.Errorf("the %s RBQ-2A catalog has no %q interpretation", code, rule.Key)
+			}
+		}
+		for _, s := range rbq2aSubscales {
+			if catalog.Labels.Domains[s.Key] == "" {
+				return fmt.Errorf("the %s RBQ-2A catalog has no label for the %s factor", code, s.Key)
+			}
+		}
+		return nil
+	})
+}
+
+// rbq2aItemScore scores an answer from 1 (never or rarely) to 3 (marked or
+// notable)
+func rbq2aItemScore(_ CatalogQuestion, answer int) int {
+	return answer + 1
+}
+
+// rbq2aScoresFromItems sums item scores into the total score. Factor
+// scores are derived from the categories by subscaleScores.
+func rbq2aScoresFromItems(qas []QuestionAndAnswer) Scores {
+	scores := Scores{MaxTotal: rbq2aMaxTotal}
+	for _, qa := range qas {
+		scores.Total += qa.Score
+	}
+	return scores
+}
+
+// rbq2aDetails are the factor scores of the responses
+func rbq2aDetails(data AssessmentData) gin.H {
+	return gin.H{"subscales": subscaleScores(data)}
+}
+
+// rbq2aTemplateMarkdown is templateMarkdown for the RBQ-2A
+func rbq2aTemplateMarkdown(data AssessmentData) (string, error) {
+	var md strings.Builder
+	md.WriteString("## Executive Summary\n\n")
+	md.WriteString("> The AI analysis service is currently unavailable. This report only summarizes your scores; generate the analysis again later for a detailed interpretation.\n\n")
+	fmt.Fprintf(&md, "Your total RBQ-2A score is **%d out of %d**.", data.Scores.Total, rbq2aMaxTotal)
+	if data.Interpretation.Level != "" {
+		fmt.Fprintf(&md, " Interpretation: **%s**", data.Interpretation.Level)
+		if data.Interpretation.Description != "" {
+			fmt.Fprintf(&md, ", %s", data.Interpretation.Description)
+		}
+		md.WriteString(".")
+	}
+	md.WriteString("\n\n")
+
+	md.WriteString("## Factor Scores\n\n")
+	for _, s := range subscaleScores(data) {
+		fmt.Fprintf(&md, "- **%s**: %d / %d (mean item score: %.2f)\n", s.Label, s.Score, s.Max, s.Mean)
+	}
+	fmt.Fprintf(&md, "\nEach item scores from 1 (never or rarely) to 3 (marked or notable). Autistic adults have a mean item score of about %.1f.\n\n", rbq2aAutisticMean)
+
+	md.WriteString("## Next Steps\n\n")
+	md.WriteString("The RBQ-2A describes restricted and repetitive behaviours, not a diagnosis. Only a qualified clinician can assess autism, taking your history and current situation into account.\n")
+	return md.String(), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRBQ2ACatalog(t *testing.T) {
+	catalog, err := rbq2aCatalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, q := range catalog.Questions {
+		counts[q.Category]++
+	}
+	for _, s := range rbq2aSubscales {
+		if counts[s.Category] != s.Items {
+			t.Errorf("the %s factor has %d items instead of %d", s.Key, counts[s.Category], s.Items)
+		}
+	}
+}
+
+func TestRBQ2AFactorMeans(t *testing.T) {
+	data := uniformAssessment(t, rbq2aInstrument(), 2)
+	if err := validateAssessmentData(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range subscaleScores(data) {
+		if s.Mean != rbq2aItemMax {
+			t.Errorf("marked answers to every item give a %s mean of %.2f instead of %d", s.Key, s.Mean, rbq2aItemMax)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// Average silent reading speeds in words per minute, from
+// Trauzettel-Klosinski & Dietz (2012)
+var readingWordsPerMinute = map[string]int{
+	"en": 228,
+	"fr": 195,
+	"es": 218,
+	"it": 188,
+	"de": 179,
+	"ru": 184,
+}
+
+// SectionWordCount is the word count of a top-level report section
+type SectionWordCount struct {
+	Title string `json:"title"`
+	Words int    `json:"words"`
+}
+
+// ReadingStats summarizes the length of a generated analysis
+type ReadingStats struct {
+	Words          int                `json:"words"`
+	ReadingMinutes int                `json:"reading_minutes"`
+	Sections       []SectionWordCount `json:"sections"`
+}
+
+// readingMetadata returns the reading statistics sent with the complete
+// stream event
+func readingMetadata(stats ReadingStats) streamproto.ReadingStats {
+	metadata := streamproto.ReadingStats{Words: stats.Words, ReadingMinutes: stats.ReadingMinutes}
+	for _, section := range stats.Sections {
+		metadata.Sections = append(metadata.Sections, streamproto.SectionWordCount(section))
+	}
+	return metadata
+}
+
+// countWords splits text on anything that is neither a letter nor a digit,
+// which handles elisions ("l'analyse") and compounds consistently across
+// the supported languages
+func countWords(s string) int {
+	return len(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// analysisReadingStats computes word counts from the markdown AST, split by
+// level 2 sections, along with the estimated reading time
+func analysisReadingStats(markdown, language string) ReadingStats {
+	source := []byte(markdown)
+	doc, err := parseMarkdown(source)
+	if err != nil {
+		doc = ast.NewDocument()
+		doc.AppendChild(doc, ast.NewString(source))
+	}
+
+	var stats ReadingStats
+	current := -1
+
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		words := countWords(string(nodeText(node, source)))
+
+		if heading, ok := node.(*ast.Heading); ok && heading.Level == 2 {
+			stats.Sections = append(stats.Sections, SectionWordCount{
+				Title: strings.TrimSpace(string(nodeText(node, source))),
+			})
+			current = len(stats.Sections) - 1
+		}
+
+		stats.Words += words
+		if current >= 0 {
+			stats.Sections[current].Words += words
+		}
+	}
+
+	wpm, ok := readingWordsPerMinute[language]
+	if !ok {
+		wpm = readingWordsPerMinute["en"]
+	}
+	stats.ReadingMinutes = int(math.Ceil(float64(stats.Words) / float64(wpm)))
+
+	return stats
+}
+
+// nodeText collects the text of every text node below a node
+func nodeText(node ast.Node, source []byte) []byte {
+	var out []byte
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n.Type() == ast.TypeBlock {
+			out = append(out, ' ')
+		}
+		switch t := n.(type) {
+		case *ast.Text:
+			out = append(out, t.Segment.Value(source)...)
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				out = append(out, ' ')
+			}
+		case *ast.String:
+			out = append(out, t.Value...)
+		}
+		return ast.WalkContinue, nil
+	})
+	return out
+}
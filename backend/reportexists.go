@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Existence checks allowed per client and minute, counted separately
+	// from generations
+	reportExistsRatePerMinute = envInt("REPORT_EXISTS_RATE_PER_MINUTE", 120)
+
+	// Longest client token accepted
+	clientTokenMaxLength = envInt("CLIENT_TOKEN_MAX_LENGTH", 128)
+)
+
+var reportExistsLimiter = newRateLimiter(reportExistsRatePerMinute)
+
+// clientToken returns the opaque token a browser generates once and sends
+// in X-Client-Token, scoping what it may learn about cached analyses
+func clientToken(c *gin.Context) string {
+	token := c.GetHeader("X-Client-Token")
+	if len(token) > clientTokenMaxLength {
+		return ""
+	}
+	return token
+}
+
+// ReportExistsRequest identifies an analysis either by the assessment_hash
+// returned with it, or by the assessment itself, usually in the minimal
+// answers format. The hash is the hex-encoded SHA-256 of the canonical JSON
+// of the derived assessment (see canonicalAssessment), which is why the
+// server returns it rather than clients computing it.
+type ReportExistsRequest struct {
+	AssessmentData
+	AssessmentHash string `json:"assessment_hash"`
+	ClientToken    string `json:"client_token"`
+}
+
+// UnmarshalJSON decodes the hash and token next to the assessment, whose
+// own UnmarshalJSON would otherwise leave them out
+func (r *ReportExistsRequest) UnmarshalJSON(b []byte) error {
+	var lookup struct {
+		AssessmentHash string `json:"assessment_hash"`
+		ClientToken    string `json:"client_token"`
+	}
+	if err := json.Unmarshal(b, &lookup); err != nil {
+		return err
+	}
+	r.AssessmentHash, r.ClientToken = lookup.AssessmentHash, lookup.ClientToken
+	return json.Unmarshal(b, &r.AssessmentData)
+}
+
+// reportExistsHandler tells whether an analysis of an assessment is cached
+// for the client, without generating or returning anything
+func reportExistsHandler(c *gin.Context) {
+	var req ReportExistsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	client := req.ClientToken
+	if client == "" {
+		client = clientToken(c)
+	}
+	if client == "" || len(client) > clientTokenMaxLength {
+		c.JSON(400, gin.H{"error": "A client_token is required"})
+		return
+	}
+
+	if ok, reset := reportExistsLimiter.Allow(client); !ok {
+		c.Header("Retry-After", strconv.Itoa(reset))
+		c.JSON(429, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	hash := req.AssessmentHash
+	if hash != "" {
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+			c.JSON(400, gin.H{"error": "assessment_hash must be a hex-encoded SHA-256"})
+			return
+		}
+		if _, err := referenceProfileFor(req.ReferenceProfile); err != nil {
+			c.JSON(400, invalidAssessment(err))
+			return
+		}
+	} else {
+		if err := validateAssessmentData(c.Request.Context(), &req.AssessmentData); err != nil {
+			log.Printf("❌ Invalid assessment data: %v", err)
+			c.JSON(400, invalidAssessment(err))
+			return
+		}
+		var err error
+		if hash, err = assessmentHash(req.AssessmentData); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to process assessment data: " + err.Error()})
+			return
+		}
+	}
+
+	entry, ok := analysisCache.Peek(analysisCacheKey(hash, req.AssessmentData), client)
+	if !ok {
+		c.JSON(200, gin.H{"exists": false, "assessment_hash": hash})
+		return
+	}
+	c.JSON(200, gin.H{
+		"exists":          true,
+		"assessment_hash": hash,
+		"report_id":       entry.ReportID,
+		"created_at":      entry.GeneratedAt,
+		"prompt_version":  entry.PromptVersion,
+		"stale":           entry.Stale(),
+	})
+}
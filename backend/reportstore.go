@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Backends of the report store, selected with REPORT_STORE
+const (
+	reportStoreFile     = "file"
+	reportStorePostgres = "postgres"
+	reportStoreSQLite   = "sqlite"
+)
+
+var (
+	// Backend analyzed assessments and their analyses are persisted to,
+	// none when empty: reports then only live in memory until a restart
+	reportStoreBackend = envString("REPORT_STORE", "")
+
+	// Directory of the file backend
+	reportStoreDir = os.Getenv("REPORT_STORE_DIR")
+
+	// Data source name of the database backends, and the name of the
+	// database/sql driver to connect with, when not the one linked into the
+	// binary for the backend
+	reportStoreDSN    = os.Getenv("REPORT_STORE_DSN")
+	reportStoreDriver = envString("REPORT_STORE_DRIVER", reportStoreDrivers[reportStoreBackend])
+)
+
+// reportStoreDrivers are the database/sql drivers linked into the binary
+// for the database backends: lib/pq and go-sqlite3, which needs cgo
+var reportStoreDrivers = map[string]string{
+	reportStorePostgres: "postgres",
+	reportStoreSQLite:   "sqlite3",
+}
+
+var errReportNotFound = errors.New("report not found")
+
+// StoredReport is an analyzed assessment and its analysis, kept under the
+// report ID returned to the client
+type StoredReport struct {
+	ReportID       string         `json:"report_id"`
+	AssessmentHash string         `json:"assessment_hash"`
+	Assessment     AssessmentData `json:"assessment"`
+	Markdown       string         `json:"markdown"`
+	PromptVersion  int            `json:"prompt_version"`
+	CreatedAt      time.Time      `json:"created_at"`
+	// Status of the generation of the analysis, which is only partial when
+	// it was cancelled
+	Status string `json:"status,omitempty"`
+	// Hash of the client token the report was generated for, the only
+	// client allowed to read or delete it
+	Owner string `json:"owner,omitempty"`
+	// Warnings raised while the analysis was generated
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// ReportStore persists reports by report ID. Saving a report again
+// replaces it.
+type ReportStore interface {
+	Save(ctx context.Context, report StoredReport) error
+	Get(ctx context.Context, reportID string) (StoredReport, error)
+	Delete(ctx context.Context, reportID string) error
+	// Prune deletes the reports created before a time, and returns how
+	// many it deleted
+	Prune(ctx context.Context, before time.Time) (int, error)
+	Close() error
+}
+
+// reports is the report store of the deployment, nil without REPORT_STORE
+var reports ReportStore
+
+// openReportStore opens the backend of REPORT_STORE, or returns nil when
+// none is configured
+func openReportStore() (ReportStore, error) {
+	switch reportStoreBackend {
+	case "":
+		return nil, nil
+	case reportStoreFile:
+		if reportStoreDir == "" {
+			return nil, errors.New("REPORT_STORE_DIR is required by the file report store")
+		}
+		if err := os.MkdirAll(reportStoreDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create report store directory: %w", err)
+		}
+		return &fileReportStore{dir: reportStoreDir}, nil
+	case reportStorePostgres, reportStoreSQLite:
+		if reportStoreDSN == "" {
+			return nil, fmt.Errorf("REPORT_STORE_DSN is required by the %s report store", reportStoreBackend)
+		}
+		store, err := openSQLReportStore(reportStoreBackend, reportStoreDriver, reportStoreDSN)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown REPORT_STORE %q (available: %s, %s, %s)", reportStoreBackend, reportStoreFile, reportStorePostgres, reportStoreSQLite)
+	}
+}
+
+// reportOwner hashes a client token, so that stored reports do not hold
+// tokens that could be replayed
+func reportOwner(client string) string {
+	if client == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(client))
+	return hex.EncodeToString(sum[:])
+}
+
+type ownerKey struct{}
+
+// ownerMiddleware identifies the owner of the reports generated and read
+// by a request, from its X-Client-Token
+func ownerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), ownerKey{}, reportOwner(clientToken(c)))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// ownerFrom returns the owner of a request, "" for anonymous ones
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+// saveReport persists a report when a store is configured, with the
+// warnings of the request unless it has its own. Reports of anonymous
+// clients are not, as nobody could read them back. Failures are logged:
+// the analysis was still generated and is cached in memory.
+func saveReport(ctx context.Context, report StoredReport) {
+	if reports == nil || report.Owner == "" {
+		return
+	}
+	report.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if report.Status == "" {
+		report.Status = generationCompleted
+	}
+	if report.Warnings == nil {
+		report.Warnings = warningsFrom(ctx).List()
+	}
+	if err := reports.Save(ctx, report); err != nil {
+		log.Printf("⚠️  Failed to persist report %s: %v", report.ReportID, err)
+	}
+}
+
+// pruneReports deletes the reports past ASSESSMENT_RETENTION_HOURS every
+// hour
+func pruneReports() {
+	for ; ; time.Sleep(time.Hour) {
+		pruned, err := reports.Prune(context.Background(), time.Now().Add(-assessmentRetention))
+		if err != nil {
+			log.Printf("⚠️  Failed to prune stored reports: %v", err)
+		} else if pruned > 0 {
+			log.Printf("🧹 Pruned %d expired reports", pruned)
+		}
+	}
+}
+
+// fileReportStore keeps each report in a JSON file named after its ID
+type fileReportStore struct {
+	dir string
+}
+
+// reportFile is the file of a report. Report IDs are UUIDs, which keeps
+// them from naming files outside of the directory.
+func (s *fileReportStore) reportFile(reportID string) (string, error) {
+	if _, err := uuid.Parse(reportID); err != nil {
+		return "", errReportNotFound
+	}
+	return filepath.Join(s.dir, strings.ToLower(reportID)+".json"), nil
+}
+
+func (s *fileReportStore) Save(ctx context.Context, report StoredReport) error {
+	file, err := s.reportFile(report.ReportID)
+	if err != nil {
+		return fmt.Errorf("invalid report ID %q", report.ReportID)
+	}
+	content, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+".tmp", content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (s *fileReportStore) Get(ctx context.Context, reportID string) (StoredReport, error) {
+	var report StoredReport
+	file, err := s.reportFile(reportID)
+	if err != nil {
+		return report, err
+	}
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return report, errReportNotFound
+	}
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(content, &report); err != nil {
+		return report, fmt.Errorf("invalid stored report %s: %w", reportID, err)
+	}
+	return report, nil
+}
+
+func (s *fileReportStore) Delete(ctx context.Context, reportID string) error {
+	file, err := s.reportFile(reportID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if os.IsNotExist(err) {
+		return errReportNotFound
+	}
+	return err
+}
+
+// Prune reads the creation time of every report, so it is only run hourly
+func (s *fileReportStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var report struct {
+			CreatedAt time.Time `json:"created_at"`
+		}
+		if json.Unmarshal(content, &report) == nil && report.CreatedAt.Before(before) {
+			if os.Remove(file) == nil {
+				pruned++
+			}
+		}
+	}
+	return pruned, nil
+}
+
+func (s *fileReportStore) Close() error {
+	return nil
+}
+
+// sqlReportStore keeps reports in a table of a PostgreSQL or SQLite
+// database, with the assessment and the warnings as JSON and times as Unix
+// seconds, which both store alike
+type sqlReportStore struct {
+	db      *sql.DB
+	backend string
+}
+
+const reportsTableSQL = `CREATE TABLE IF NOT EXISTS reports (
+	report_id TEXT PRIMARY KEY,
+	assessment_hash TEXT NOT NULL,
+	assessment TEXT NOT NULL,
+	markdown TEXT NOT NULL,
+	prompt_version INTEGER NOT NULL,
+	created_at BIGINT NOT NULL,
+	owner TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT '',
+	warnings TEXT NOT NULL DEFAULT ''
+)`
+
+const reportsCreatedIndexSQL = `CREATE INDEX IF NOT EXISTS reports_created_at ON reports (created_at)`
+
+// reportsAddedColumns are the columns added to the reports table since it
+// was first created, added to the tables of older deployments
+var reportsAddedColumns = map[string]string{
+	"status":   "TEXT NOT NULL DEFAULT ''",
+	"warnings": "TEXT NOT NULL DEFAULT ''",
+}
+
+// openSQLReportStore connects to the database and creates the reports
+// table when it does not exist
+func openSQLReportStore(backend, driver, dsn string) (*sqlReportStore, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("no database/sql driver %q is linked into this binary (linked: %s)", driver, strings.Join(sql.Drivers(), ", "))
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open report database: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, statement := range []string{reportsTableSQL, reportsCreatedIndexSQL} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create reports table: %w", err)
+		}
+	}
+	for column, definition := range reportsAddedColumns {
+		// Neither backend has a portable way to add a column unless it exists
+		if _, err := db.ExecContext(ctx, "SELECT "+column+" FROM reports LIMIT 0"); err == nil {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE reports ADD COLUMN "+column+" "+definition); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to add column %s to the reports table: %w", column, err)
+		}
+	}
+	return &sqlReportStore{db: db, backend: backend}, nil
+}
+
+// query writes the placeholders of a statement for the backend: $1, $2…
+// for PostgreSQL and ? for SQLite
+func (s *sqlReportStore) query(statement string) string {
+	if s.backend != reportStorePostgres {
+		return statement
+	}
+	var out strings.Builder
+	n := 0
+	for _, r := range statement {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&out, "$%d", n)
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func (s *sqlReportStore) Save(ctx context.Context, report StoredReport) error {
+	assessment, err := json.Marshal(report.Assessment)
+	if err != nil {
+		return err
+	}
+	warnings, err := json.Marshal(report.Warnings)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.query(`INSERT INTO reports (report_id, assessment_hash, assessment, markdown, prompt_version, created_at, owner, status, warnings)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (report_id) DO UPDATE SET assessment_hash = excluded.assessment_hash, assessment = excluded.assessment,
+			markdown = excluded.markdown, prompt_version = excluded.prompt_version, created_at = excluded.created_at, owner = excluded.owner,
+			status = excluded.status, warnings = excluded.warnings`),
+		report.ReportID, report.AssessmentHash, string(assessment), report.Markdown, report.PromptVersion, report.CreatedAt.Unix(), report.Owner, report.Status, string(warnings))
+	return err
+}
+
+func (s *sqlReportStore) Get(ctx context.Context, reportID string) (StoredReport, error) {
+	report := StoredReport{ReportID: reportID}
+	var assessment, warnings string
+	var created int64
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT assessment_hash, assessment, markdown, prompt_version, created_at, owner, status, warnings FROM reports WHERE report_id = ?`), reportID).
+		Scan(&report.AssessmentHash, &assessment, &report.Markdown, &report.PromptVersion, &created, &report.Owner, &report.Status, &warnings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return report, errReportNotFound
+	}
+	if err != nil {
+		return report, err
+	}
+	report.CreatedAt = time.Unix(created, 0).UTC()
+	if err := json.Unmarshal([]byte(assessment), &report.Assessment); err != nil {
+		return report, fmt.Errorf("invalid stored report %s: %w", reportID, err)
+	}
+	// Reports stored before their warnings were recorded have none
+	if warnings != "" {
+		if err := json.Unmarshal([]byte(warnings), &report.Warnings); err != nil {
+			return report, fmt.Errorf("invalid warnings of stored report %s: %w", reportID, err)
+		}
+	}
+	return report, nil
+}
+
+func (s *sqlReportStore) Delete(ctx context.Context, reportID string) error {
+	result, err := s.db.ExecContext(ctx, s.query(`DELETE FROM reports WHERE report_id = ?`), reportID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errReportNotFound
+	}
+	return nil
+}
+
+func (s *sqlReportStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, s.query(`DELETE FROM reports WHERE created_at < ?`), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlReportStore) Close() error {
+	return s.db.Close()
+}
+
+// getOwnedReport loads a stored report of the owner of a request. Reports
+// of other owners are reported as not found, so that their IDs cannot be
+// probed.
+func getOwnedReport(ctx context.Context, reportID string) (StoredReport, error) {
+	if reports == nil {
+		return StoredReport{}, errReportNotFound
+	}
+	report, err := reports.Get(ctx, reportID)
+	if err == nil && (report.Owner == "" || report.Owner != ownerFrom(ctx)) {
+		return StoredReport{}, errReportNotFound
+	}
+	return report, err
+}
+
+// ownedReport loads a stored report for the client of a request. Reports
+// of other clients are reported as not found, so that their IDs cannot be
+// probed.
+func ownedReport(c *gin.Context) (StoredReport, bool) {
+	if reports == nil {
+		c.JSON(404, gin.H{"error": "Reports are not stored by this server"})
+		return StoredReport{}, false
+	}
+	report, err := getOwnedReport(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errReportNotFound) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("report %s not found", c.Param("id"))})
+		return report, false
+	}
+	if err != nil {
+		log.Printf("❌ Error reading stored report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to read report: " + err.Error()})
+		return report, false
+	}
+	return report, true
+}
+
+// storedReportHandler returns a stored report to the client it was
+// generated for, identified by its X-Client-Token
+func storedReportHandler(c *gin.Context) {
+	report, ok := ownedReport(c)
+	if !ok {
+		return
+	}
+	// Reports stored before their status was recorded are all complete
+	if report.Status == "" {
+		report.Status = generationCompleted
+	}
+	if report.Warnings == nil {
+		report.Warnings = []Warning{}
+	}
+	c.JSON(200, gin.H{
+		"report_id":        report.ReportID,
+		"assessment_hash":  report.AssessmentHash,
+		"assessment":       report.Assessment,
+		"markdown":         report.Markdown,
+		"prompt_version":   report.PromptVersion,
+		"analysis_version": analysisVersionFor(report.PromptVersion),
+		"created_at":       report.CreatedAt,
+		"status":           report.Status,
+		"warnings":         report.Warnings,
+	})
+}
+
+// deleteStoredReportHandler deletes a stored report at the request of the
+// client it was generated for
+func deleteStoredReportHandler(c *gin.Context) {
+	report, ok := ownedReport(c)
+	if !ok {
+		return
+	}
+	if err := reports.Delete(c.Request.Context(), report.ReportID); err != nil && !errors.Is(err, errReportNotFound) {
+		log.Printf("❌ Error deleting stored report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to delete report: " + err.Error()})
+		return
+	}
+	log.Printf("🗑️  Deleted stored report %s", report.ReportID)
+	c.Status(204)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// useReportStore makes a store the report store of the deployment for the
+// duration of a test
+func useReportStore(t *testing.T, store ReportStore) {
+	t.Helper()
+	previous := reports
+	reports = store
+	t.Cleanup(func() {
+		reports = previous
+		store.Close()
+	})
+}
+
+// testReportStores opens a store of each backend in a temporary
+// directory. PostgreSQL is only tested against the database of
+// TEST_POSTGRES_DSN, whose reports table is dropped first.
+func testReportStores(t *testing.T) map[string]ReportStore {
+	t.Helper()
+	sqlite, err := openSQLReportStore(reportStoreSQLite, reportStoreDrivers[reportStoreSQLite], filepath.Join(t.TempDir(), "reports.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]ReportStore{
+		reportStoreFile:   &fileReportStore{dir: t.TempDir()},
+		reportStoreSQLite: sqlite,
+	}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		db, err := sql.Open(reportStoreDrivers[reportStorePostgres], dsn)
+		if err == nil {
+			_, err = db.Exec("DROP TABLE IF EXISTS reports")
+			db.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stores[reportStorePostgres], err = openSQLReportStore(reportStorePostgres, reportStoreDrivers[reportStorePostgres], dsn); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		t.Log("TEST_POSTGRES_DSN is not set, the PostgreSQL report store is not tested")
+	}
+	return stores
+}
+
+// TestOpenReportStore opens each backend as configured by the environment
+func TestOpenReportStore(t *testing.T) {
+	previousBackend, previousDir, previousDSN, previousDriver := reportStoreBackend, reportStoreDir, reportStoreDSN, reportStoreDriver
+	t.Cleanup(func() {
+		reportStoreBackend, reportStoreDir, reportStoreDSN, reportStoreDriver = previousBackend, previousDir, previousDSN, previousDriver
+	})
+
+	tests := []struct {
+		name    string
+		backend string
+		dsn     string
+		invalid bool
+	}{
+		{"none", "", "", false},
+		{"file", reportStoreFile, "", false},
+		{"sqlite", reportStoreSQLite, filepath.Join(t.TempDir(), "reports.db"), false},
+		{"sqlite without DSN", reportStoreSQLite, "", true},
+		{"postgres", reportStorePostgres, os.Getenv("TEST_POSTGRES_DSN"), os.Getenv("TEST_POSTGRES_DSN") == ""},
+		{"unknown", "unknown", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reportStoreBackend, reportStoreDir, reportStoreDSN, reportStoreDriver = tc.backend, t.TempDir(), tc.dsn, reportStoreDrivers[tc.backend]
+			store, err := openReportStore()
+			if invalid := err != nil; invalid != tc.invalid {
+				t.Fatalf("failed: %t, want %t (%v)", invalid, tc.invalid, err)
+			}
+			if store != nil {
+				store.Close()
+			}
+		})
+	}
+}
+
+func TestReportStores(t *testing.T) {
+	for backend, store := range testReportStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+			report := StoredReport{
+				ReportID:  uuid.New().String(),
+				Markdown:  "## Sample",
+				CreatedAt: now,
+				Owner:     reportOwner("client"),
+				Status:    generationCancelled,
+				Warnings: []Warning{
+					{Code: warnCommentTruncated, Severity: severityNotice, Display: "footnote", Message: "Comment truncated", QuestionID: 12},
+					{Code: warnStructureInvalid, Severity: severityNotice, Display: "footnote", Message: "Missing section", Section: "Conclusion"},
+				},
+				Assessment: AssessmentData{
+					Language: "en",
+					Metadata: Metadata{TestName: raadsR.Name, TestDate: now},
+				},
+			}
+			if err := store.Save(ctx, report); err != nil {
+				t.Fatal(err)
+			}
+			read, err := store.Get(ctx, report.ReportID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Markdown != report.Markdown || read.Owner != report.Owner || read.Status != report.Status || !read.CreatedAt.Equal(now) || !read.Assessment.Metadata.TestDate.Equal(now) {
+				t.Errorf("the report read back differs: %+v", read)
+			}
+			if !reflect.DeepEqual(read.Warnings, report.Warnings) {
+				t.Errorf("the report read back has warnings %+v instead of %+v", read.Warnings, report.Warnings)
+			}
+
+			// Saving again replaces the report
+			report.Markdown = "## Replaced"
+			if err := store.Save(ctx, report); err != nil {
+				t.Fatal(err)
+			}
+			if read, _ := store.Get(ctx, report.ReportID); read.Markdown != report.Markdown {
+				t.Errorf("the report was not replaced: %q", read.Markdown)
+			}
+
+			expired := report
+			expired.ReportID = uuid.New().String()
+			expired.CreatedAt = now.Add(-2 * time.Hour)
+			if err := store.Save(ctx, expired); err != nil {
+				t.Fatal(err)
+			}
+			if pruned, err := store.Prune(ctx, now.Add(-time.Hour)); err != nil || pruned != 1 {
+				t.Errorf("pruned %d reports instead of 1: %v", pruned, err)
+			}
+			if _, err := store.Get(ctx, expired.ReportID); !errors.Is(err, errReportNotFound) {
+				t.Errorf("an expired report is still stored: %v", err)
+			}
+
+			if err := store.Delete(ctx, report.ReportID); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{report.ReportID, "../../etc/passwd"} {
+				if _, err := store.Get(ctx, id); !errors.Is(err, errReportNotFound) {
+					t.Errorf("report %s is found: %v", id, err)
+				}
+			}
+		})
+	}
+}
+
+// TestReportsTableUpgrade makes sure the columns added since the reports
+// table was first created are added to the tables of older deployments
+func TestReportsTableUpgrade(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "reports.db")
+	db, err := sql.Open(reportStoreDrivers[reportStoreSQLite], dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE reports (
+		report_id TEXT PRIMARY KEY,
+		assessment_hash TEXT NOT NULL,
+		assessment TEXT NOT NULL,
+		markdown TEXT NOT NULL,
+		prompt_version INTEGER NOT NULL,
+		created_at BIGINT NOT NULL,
+		owner TEXT NOT NULL
+	)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openSQLReportStore(reportStoreSQLite, reportStoreDrivers[reportStoreSQLite], dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	report := StoredReport{ReportID: uuid.New().String(), Markdown: "## Sample", Status: generationCancelled}
+	if err := store.Save(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := store.Get(context.Background(), report.ReportID); err != nil || read.Status != report.Status {
+		t.Errorf("the report read back has status %q: %v", read.Status, err)
+	}
+
+	// Reports stored before the warnings column was added have none
+	if _, err := store.db.Exec(`INSERT INTO reports (report_id, assessment_hash, assessment, markdown, prompt_version, created_at, owner)
+		VALUES (?, '', '{}', '', 0, 0, '')`, "older"); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := store.Get(context.Background(), "older"); err != nil || len(read.Warnings) != 0 {
+		t.Errorf("the report stored before warnings has warnings %+v: %v", read.Warnings, err)
+	}
+}
+
+// TestStoredReportWarnings makes sure the warnings raised while an analysis
+// was generated are stored with the report and served with it
+func TestStoredReportWarnings(t *testing.T) {
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	collector := &WarningCollector{}
+	collector.Add(Warning{Code: warnCommentTruncated, Message: "Comment truncated", QuestionID: 3})
+	ctx := context.WithValue(context.Background(), warningsKey{}, collector)
+	report := StoredReport{ReportID: uuid.New().String(), Markdown: "## Sample", Owner: reportOwner("owner")}
+	saveReport(ctx, report)
+
+	stored, err := reports.Get(ctx, report.ReportID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.Warnings, collector.List()) {
+		t.Errorf("stored warnings %+v instead of %+v", stored.Warnings, collector.List())
+	}
+
+	response := decodeResponse(t, serve(t, "GET", "/reports/"+report.ReportID, nil, map[string]string{"X-Client-Token": "owner"}), 200)
+	warnings, _ := response["warnings"].([]any)
+	if len(warnings) != 1 {
+		t.Fatalf("served warnings %v", response["warnings"])
+	}
+	if warning, _ := warnings[0].(map[string]any); warning["code"] != warnCommentTruncated || warning["question_id"] != float64(3) {
+		t.Errorf("served warning %v", warning)
+	}
+}
+
+// TestStoredReportOwner makes sure a stored report is only served to the
+// client it was generated for
+func TestStoredReportOwner(t *testing.T) {
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	report := StoredReport{ReportID: uuid.New().String(), Markdown: "## Sample", Owner: reportOwner("owner")}
+	saveReport(context.Background(), report)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"owner", "owner", 200},
+		{"other client", "other", 404},
+		{"anonymous", "", 404},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(t, "GET", "/reports/"+report.ReportID, nil, map[string]string{"X-Client-Token": tc.token})
+			if w.Code != tc.status {
+				t.Errorf("status %d instead of %d: %s", w.Code, tc.status, w.Body.String())
+			}
+		})
+	}
+	if w := serve(t, "DELETE", "/reports/"+report.ReportID, nil, map[string]string{"X-Client-Token": "other"}); w.Code != 404 {
+		t.Errorf("another client deleted the report: status %d", w.Code)
+	}
+	if w := serve(t, "DELETE", "/reports/"+report.ReportID, nil, map[string]string{"X-Client-Token": "owner"}); w.Code != 204 {
+		t.Errorf("the owner could not delete the report: status %d", w.Code)
+	}
+}
+
+// TestAnonymousReportsNotStored makes sure only the reports of identified
+// clients are persisted, as ownedReport serves no other
+func TestAnonymousReportsNotStored(t *testing.T) {
+	startFakeClaude(t)
+	store := &fileReportStore{dir: t.TempDir()}
+	useReportStore(t, store)
+
+	for _, token := range []string{"", "owner"} {
+		response := decodeResponse(t, serve(t, "POST", "/analyze", answeredAssessment(t, "TestAnonymousReportsNotStored"+token), map[string]string{"X-Client-Token": token}), 200)
+		reportID, _ := response["report_id"].(string)
+		_, err := store.Get(context.Background(), reportID)
+		if stored := err == nil; stored != (token != "") {
+			t.Errorf("report with token %q stored: %t (%v)", token, stored, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"raads-pdf-backend/streamproto"
+)
+
+var (
+	// How long analyzed assessments are kept as bases for partial retakes
+	assessmentRetention = time.Duration(envInt("ASSESSMENT_RETENTION_HOURS", 720)) * time.Hour
+
+	// Analyzed assessments kept, the oldest are dropped
+	assessmentStoreSize = envInt("ASSESSMENT_STORE_SIZE", 1024)
+)
+
+var (
+	errBaseNotFound = errors.New("base assessment not found")
+	errBaseExpired  = errors.New("base assessment expired")
+)
+
+// RetakeLineage links an assessment merged from a partial retake to the
+// assessment it updates
+type RetakeLineage struct {
+	BaseReportID       string    `json:"base_report_id"`
+	BaseAssessmentHash string    `json:"base_assessment_hash"`
+	BaseTestDate       time.Time `json:"base_test_date"`
+	RetakeDate         time.Time `json:"retake_date"`
+	UpdatedItems       []int     `json:"updated_items"`
+}
+
+// lineageMetadata returns the lineage sent with the stream metadata, nil
+// when the assessment is not a retake
+func lineageMetadata(lineage *RetakeLineage) *streamproto.RetakeLineage {
+	if lineage == nil {
+		return nil
+	}
+	metadata := streamproto.RetakeLineage(*lineage)
+	return &metadata
+}
+
+// storedAssessment is a validated assessment an analysis was generated from
+type storedAssessment struct {
+	ReportID string
+	Hash     string
+	Data     AssessmentData
+	StoredAt time.Time
+	// Hash of the client token the analysis was generated for, see
+	// reportOwner
+	Owner string
+}
+
+// assessmentStore keeps analyzed assessments in memory until their
+// retention period is over. The IDs of expired ones are remembered, so
+// that a retake can tell an expired base from an unknown one.
+type assessmentStore struct {
+	mu       sync.Mutex
+	byReport map[string]storedAssessment
+	byHash   map[string]string
+	expired  map[string]bool
+}
+
+var assessments = &assessmentStore{
+	byReport: make(map[string]storedAssessment),
+	byHash:   make(map[string]string),
+	expired:  make(map[string]bool),
+}
+
+// Save stores the assessment of an analysis generated for an owner
+func (s *assessmentStore) Save(reportID, hash, owner string, data AssessmentData) {
+	if assessmentStoreSize <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	if _, ok := s.byReport[reportID]; !ok && len(s.byReport) >= assessmentStoreSize {
+		s.evictOldestLocked()
+	}
+	s.byReport[reportID] = storedAssessment{ReportID: reportID, Hash: hash, Data: data, StoredAt: time.Now().UTC(), Owner: owner}
+	s.byHash[hash] = reportID
+}
+
+// Get returns a stored assessment by report ID or, when reportID is empty,
+// by assessment hash. Assessments of other owners are reported as not
+// found, so that their IDs cannot be probed.
+func (s *assessmentStore) Get(reportID, hash, owner string) (storedAssessment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	id := reportID
+	if id == "" {
+		id = "hash:" + hash
+		if byHash, ok := s.byHash[hash]; ok {
+			id = byHash
+		}
+	}
+	if stored, ok := s.byReport[id]; ok {
+		if stored.Owner != owner {
+			return storedAssessment{}, errBaseNotFound
+		}
+		return stored, nil
+	}
+	if s.expired[id] {
+		return storedAssessment{}, errBaseExpired
+	}
+	// Reports generated before a restart, or evicted from memory
+	if reportID != "" && reports != nil {
+		if report, err := reports.Get(context.Background(), reportID); err == nil && report.Owner == owner {
+			if time.Since(report.CreatedAt) > assessmentRetention {
+				return storedAssessment{}, errBaseExpired
+			}
+			return storedAssessment{ReportID: reportID, Hash: report.AssessmentHash, Data: report.Assessment, StoredAt: report.CreatedAt, Owner: report.Owner}, nil
+		}
+	}
+	return storedAssessment{}, errBaseNotFound
+}
+
+// pruneLocked forgets assessments past their retention period, keeping
+// their IDs as expired
+func (s *assessmentStore) pruneLocked(now time.Time) {
+	for id, stored := range s.byReport {
+		if now.Sub(stored.StoredAt) > assessmentRetention {
+			s.forgetLocked(id, stored)
+		}
+	}
+}
+
+// evictOldestLocked removes the assessment stored the longest ago
+func (s *assessmentStore) evictOldestLocked() {
+	var oldest storedAssessment
+	for _, stored := range s.byReport {
+		if oldest.ReportID == "" || stored.StoredAt.Before(oldest.StoredAt) {
+			oldest = stored
+		}
+	}
+	s.forgetLocked(oldest.ReportID, oldest)
+}
+
+func (s *assessmentStore) forgetLocked(id string, stored storedAssessment) {
+	delete(s.byReport, id)
+	if s.byHash[stored.Hash] == id {
+		delete(s.byHash, stored.Hash)
+		s.markExpiredLocked("hash:" + stored.Hash)
+	}
+	s.markExpiredLocked(id)
+}
+
+// markExpiredLocked remembers an expired ID, within the size of the store
+func (s *assessmentStore) markExpiredLocked(id string) {
+	if len(s.expired) >= 2*assessmentStoreSize {
+		s.expired = make(map[string]bool)
+	}
+	s.expired[id] = true
+}
+
+// mergeRetake expands a partial retake into a full submission: answers only
+// holds the changed answers, every other answer is taken from the stored
+// base assessment, which must have been analyzed for the same client.
+// Submissions without a base are left untouched, and so are assessments
+// already merged.
+func mergeRetake(ctx context.Context, data *AssessmentData) error {
+	if data.BaseReportID == "" && data.BaseAssessmentHash == "" || data.Lineage != nil {
+		return nil
+	}
+	if data.BaseReportID != "" && data.BaseAssessmentHash != "" {
+		return fmt.Errorf("baseReportId and baseAssessmentHash cannot both be provided")
+	}
+	if len(data.QuestionsAndAnswers) > 0 {
+		return fmt.Errorf("a partial retake only sends the changed answers, not questionsAndAnswers")
+	}
+	if len(data.Answers) == 0 {
+		return fmt.Errorf("a partial retake needs at least one changed answer")
+	}
+
+	base, err := assessments.Get(data.BaseReportID, data.BaseAssessmentHash, ownerFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("%w: %s (assessments are kept %d hours after their analysis)", err, data.BaseReportID+data.BaseAssessmentHash, int(assessmentRetention.Hours()))
+	}
+	if base.Data.Language != data.Language {
+		return fmt.Errorf("base assessment is in %s, the retake in %s", base.Data.Language, data.Language)
+	}
+	if data.Metadata.TestName != "" && data.Metadata.TestName != base.Data.Metadata.TestName {
+		return fmt.Errorf("base assessment is a %s, the retake a %s", base.Data.Metadata.TestName, data.Metadata.TestName)
+	}
+
+	changed := make(map[int]bool, len(data.Answers))
+	updated := make([]int, 0, len(data.Answers))
+	for _, a := range data.Answers {
+		if changed[a.ID] {
+			return fmt.Errorf("duplicate answer for question %d", a.ID)
+		}
+		changed[a.ID] = true
+		updated = append(updated, a.ID)
+	}
+	sort.Ints(updated)
+
+	merged := append([]SubmittedAnswer(nil), data.Answers...)
+	for _, qa := range base.Data.QuestionsAndAnswers {
+		if changed[qa.ID] || qa.AnswerText == "" {
+			continue
+		}
+		answer := qa.Answer
+		merged = append(merged, SubmittedAnswer{ID: qa.ID, Answer: &answer, Comment: qa.Comment})
+	}
+	data.Answers = merged
+
+	retakeDate := time.Now().UTC()
+	if data.TestDate == nil || data.TestDate.IsZero() {
+		data.TestDate = &retakeDate
+	} else {
+		retakeDate = data.TestDate.UTC()
+	}
+	if data.Metadata.Timezone == "" {
+		data.Metadata.Timezone = base.Data.Metadata.Timezone
+	}
+	if data.ReferenceProfile == "" {
+		data.ReferenceProfile = base.Data.ReferenceProfile
+	}
+	if data.AdditionalContext == "" {
+		data.AdditionalContext = base.Data.AdditionalContext
+	}
+
+	data.Lineage = &RetakeLineage{
+		BaseReportID:       base.ReportID,
+		BaseAssessmentHash: base.Hash,
+		BaseTestDate:       base.Data.Metadata.TestDate,
+		RetakeDate:         retakeDate,
+		UpdatedItems:       updated,
+	}
+	return nil
+}
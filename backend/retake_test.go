@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// savedAssessment stores a validated assessment for an owner, as analyses
+// do, and returns its report ID
+func savedAssessment(t *testing.T, context, owner string) (string, AssessmentData) {
+	t.Helper()
+	var data AssessmentData
+	if err := json.Unmarshal(answeredAssessment(t, context), &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateAssessmentData(withOwner(owner), &data); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := assessmentHash(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportID := uuid.New().String()
+	assessments.Save(reportID, hash, reportOwner(owner), data)
+	return reportID, data
+}
+
+// withOwner is the context of a request sent with a client token
+func withOwner(token string) context.Context {
+	return context.WithValue(context.Background(), ownerKey{}, reportOwner(token))
+}
+
+// TestRetakeOwner makes sure a retake only builds on an assessment analyzed
+// for the same client, whether in memory or in the report store
+func TestRetakeOwner(t *testing.T) {
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	inMemory, _ := savedAssessment(t, "TestRetakeOwner", "owner")
+	_, data := savedAssessment(t, "TestRetakeOwner stored", "owner")
+	stored := uuid.New().String()
+	saveReport(context.Background(), StoredReport{ReportID: stored, Assessment: data, Owner: reportOwner("owner")})
+
+	answer := 3
+	for name, reportID := range map[string]string{"in memory": inMemory, "stored": stored} {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range []struct {
+				token string
+				err   error
+			}{
+				{"owner", nil},
+				{"other", errBaseNotFound},
+				{"", errBaseNotFound},
+			} {
+				retake := AssessmentData{Language: "en", BaseReportID: reportID, Answers: []SubmittedAnswer{{ID: 1, Answer: &answer}}}
+				if err := mergeRetake(withOwner(tc.token), &retake); !errors.Is(err, tc.err) {
+					t.Errorf("retake with token %q: %v instead of %v", tc.token, err, tc.err)
+				}
+			}
+		})
+	}
+}
+
+func TestReportChartOwner(t *testing.T) {
+	reportID, _ := savedAssessment(t, "TestReportChartOwner", "owner")
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"owner", 200},
+		{"other", 404},
+		{"", 404},
+	}
+	for _, tc := range tests {
+		w := serve(t, "GET", "/reports/"+reportID+"/chart.svg", nil, map[string]string{"X-Client-Token": tc.token})
+		if w.Code != tc.status {
+			t.Errorf("status %d instead of %d with token %q", w.Code, tc.status, tc.token)
+		}
+	}
+}
+
+// TestAssessmentStoreExpiry makes sure an expired assessment is told from
+// an unknown one
+func TestAssessmentStoreExpiry(t *testing.T) {
+	store := &assessmentStore{byReport: map[string]storedAssessment{}, byHash: map[string]string{}, expired: map[string]bool{}}
+	store.Save("report", "hash", "", AssessmentData{})
+	store.byReport["report"] = storedAssessment{ReportID: "report", Hash: "hash", StoredAt: time.Now().Add(-assessmentRetention - time.Hour)}
+	if _, err := store.Get("report", "", ""); !errors.Is(err, errBaseExpired) {
+		t.Errorf("an expired assessment is reported as %v", err)
+	}
+	if _, err := store.Get("unknown", "", ""); !errors.Is(err, errBaseNotFound) {
+		t.Errorf("an unknown assessment is reported as %v", err)
+	}
+}
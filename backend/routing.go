@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// Generation modes models are routed by. Streamed modes are the latency
+// sensitive ones, as the participant reads the analysis as it is written.
+const (
+	modeFull         = "full"
+	modeFullStream   = "full-stream"
+	modeDomain       = "domain"
+	modeDomainStream = "domain-stream"
+)
+
+var generationModes = []string{modeFull, modeFullStream, modeDomain, modeDomainStream}
+
+// Sources of a routing decision, from the highest precedence
+const (
+	routeOverride = "override"
+	routeRule     = "rule"
+	routeDefault  = "default"
+	// The provider was unavailable, the generation fell back to the next
+	// provider of the chain and its default model
+	routeFallback = "fallback"
+)
+
+var (
+	// Routing rules as a JSON object of mode to model, such as
+	// {"domain-stream": "claude-haiku-4-5"}. Modes without a rule use
+	// CLAUDE_MODEL, or CLAUDE_STREAM_MODEL when streamed, and the model of
+	// the provider with the other ones, such as OPENAI_MODEL.
+	modelRoutes = loadModelRoutes(os.Getenv("MODEL_ROUTES"))
+
+	// Models clients may request with ?model= or the model field of an
+	// analysis, overriding the routing
+	modelOverrideAllowlist = splitList(envString("MODEL_OVERRIDE_ALLOWLIST", ""))
+)
+
+// ModelRoute is the model a generation was routed to, and why
+type ModelRoute struct {
+	Mode     string `json:"mode"`
+	Model    string `json:"model"`
+	Source   string `json:"source"`
+	Provider string `json:"provider,omitempty"`
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func loadModelRoutes(config string) map[string]string {
+	routes := map[string]string{}
+	if config == "" {
+		return routes
+	}
+	if err := json.Unmarshal([]byte(config), &routes); err != nil {
+		log.Printf("⚠️  Ignoring invalid MODEL_ROUTES: %v", err)
+		return map[string]string{}
+	}
+	return routes
+}
+
+// defaultModel is the model of a mode without a routing rule
+func defaultModel(mode string) string {
+	return providerDefaultModel(activeProvider, mode)
+}
+
+// providerDefaultModel is the model of a mode with a provider, without a
+// routing rule
+func providerDefaultModel(provider, mode string) string {
+	switch provider {
+	case providerOpenAI:
+		return openAIModel
+	case providerGemini:
+		return geminiModel
+	case providerOllama:
+		return ollamaModel
+	case providerAzureOpenAI:
+		return azureOpenAIDeployment
+	case providerBedrock:
+		return bedrockModel
+	case providerTemplate:
+		return providerTemplate
+	}
+	if mode == modeFullStream || mode == modeDomainStream {
+		return streamModel
+	}
+	return analysisModel
+}
+
+// selectModel applies the routing precedence: an allowlisted override,
+// then the rule of the mode, then the default of the mode
+func selectModel(mode, override string, rules map[string]string) ModelRoute {
+	if override != "" {
+		return ModelRoute{Mode: mode, Model: override, Source: routeOverride}
+	}
+	if model, ok := rules[mode]; ok && model != "" {
+		return ModelRoute{Mode: mode, Model: model, Source: routeRule}
+	}
+	return ModelRoute{Mode: mode, Model: defaultModel(mode), Source: routeDefault}
+}
+
+// modelAllowed reports whether clients may request a model
+func modelAllowed(model string) bool {
+	for _, allowed := range modelOverrideAllowlist {
+		if model == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// generationRouting carries the model override of a request, and records
+// the routing decision for the response metadata
+type generationRouting struct {
+	override string
+
+	mu    sync.Mutex
+	route ModelRoute
+}
+
+type routingKey struct{}
+
+// routingFrom returns the routing state attached to the context
+func routingFrom(ctx context.Context) *generationRouting {
+	if r, ok := ctx.Value(routingKey{}).(*generationRouting); ok {
+		return r
+	}
+	return &generationRouting{}
+}
+
+// Route returns the last routing decision of the request
+func (r *generationRouting) Route() ModelRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.route
+}
+
+// routeMetadata returns a routing decision as sent with the complete stream
+// event
+func routeMetadata(route ModelRoute) *streamproto.ModelRoute {
+	metadata := streamproto.ModelRoute(route)
+	return &metadata
+}
+
+// modelOverrideMiddleware accepts a model override from the model query
+// parameter, rejecting models outside of the allowlist
+func modelOverrideMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		override := c.Query("model")
+		if override != "" && !modelAllowed(override) {
+			c.AbortWithStatusJSON(400, gin.H{"error": fmt.Sprintf("Model %s cannot be requested", override)})
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), routingKey{}, &generationRouting{override: override})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// requestModel applies the model field of an analysis request as its
+// override. A model given both in the body and in the query must be the
+// same. The field is set from the query otherwise, so that analyses of
+// different models are cached apart.
+func requestModel(ctx context.Context, data *AssessmentData) error {
+	routing := routingFrom(ctx)
+	if data.Model == "" {
+		data.Model = routing.override
+		return nil
+	}
+	if !modelAllowed(data.Model) {
+		return fmt.Errorf("model %s cannot be requested", data.Model)
+	}
+	if routing.override != "" && routing.override != data.Model {
+		return fmt.Errorf("model %s requested in the body, %s in the query", data.Model, routing.override)
+	}
+	routing.override = data.Model
+	return nil
+}
+
+// withRequestedModel returns a context overriding the routing with the
+// model requested for an analysis, for generations outside of the request
+func withRequestedModel(ctx context.Context, data AssessmentData) context.Context {
+	return context.WithValue(ctx, routingKey{}, &generationRouting{override: data.Model})
+}
+
+// selectableModelsHandler lists the models clients may request, so the
+// frontend can offer the choice
+func selectableModelsHandler(c *gin.Context) {
+	selectable := modelOverrideAllowlist
+	if selectable == nil {
+		selectable = []string{}
+	}
+	c.JSON(200, gin.H{
+		"default": defaultModel(modeFull),
+		"models":  selectable,
+	})
+}
+
+// routeModel picks the model of a generation, resolving deprecated models,
+// and records the decision on the request and in the metrics
+func routeModel(ctx context.Context, mode string) ModelRoute {
+	routing := routingFrom(ctx)
+	route := selectModel(mode, routing.override, modelRoutes)
+	route.Model = models.Resolve(route.Model)
+	route.Provider = activeProvider
+
+	routing.mu.Lock()
+	routing.route = route
+	routing.mu.Unlock()
+
+	routedGenerations.Add(route)
+	log.Printf("🧭 Routing %s generation to %s (%s)", mode, route.Model, route.Source)
+	return route
+}
+
+// routeCounter counts generations per mode and model
+type routeCounter struct {
+	mu     sync.Mutex
+	counts map[[2]string]int64
+}
+
+var routedGenerations = &routeCounter{counts: make(map[[2]string]int64)}
+
+func (c *routeCounter) Add(route ModelRoute) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[[2]string{route.Mode, route.Model}]++
+}
+
+func writeRouteMetric(out *strings.Builder, name, help string) {
+	routedGenerations.mu.Lock()
+	defer routedGenerations.mu.Unlock()
+
+	keys := make([][2]string, 0, len(routedGenerations.counts))
+	for key := range routedGenerations.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range keys {
+		fmt.Fprintf(out, "%s{mode=%q,model=%q} %d\n", name, key[0], key[1], routedGenerations.counts[key])
+	}
+}
+
+// checkModelRouting verifies the routing precedence for every mode, and
+// that the models of rules and overrides are in the registry
+func checkModelRouting() checkResult {
+	result := checkResult{Name: "model routing", Feature: "analysis"}
+	for mode := range modelRoutes {
+		if !isGenerationMode(mode) {
+			result.Detail = "MODEL_ROUTES has a rule for unknown mode " + mode
+			return result
+		}
+	}
+
+	// Default models are checked with the model registry
+	var unknown []string
+	for mode, model := range modelRoutes {
+		if !registered(model) {
+			unknown = append(unknown, mode+" → "+model)
+		}
+	}
+	sort.Strings(unknown)
+	for _, model := range modelOverrideAllowlist {
+		if !registered(model) {
+			unknown = append(unknown, "override "+model)
+		}
+	}
+	if len(unknown) > 0 {
+		result.Detail = "models missing from the registry: " + strings.Join(unknown, ", ")
+		return result
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("%d modes routed, %d rules, %d allowlisted overrides", len(generationModes), len(modelRoutes), len(modelOverrideAllowlist))
+	return result
+}
+
+func isGenerationMode(mode string) bool {
+	for _, m := range generationModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func registered(model string) bool {
+	_, ok := models.Get(model)
+	return ok
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSelectModel(t *testing.T) {
+	rules := map[string]string{}
+	for _, mode := range generationModes {
+		rules[mode] = "rule-" + mode
+	}
+	for _, mode := range generationModes {
+		t.Run(mode, func(t *testing.T) {
+			tests := []struct {
+				override string
+				rules    map[string]string
+				want     ModelRoute
+			}{
+				{"", nil, ModelRoute{Mode: mode, Model: defaultModel(mode), Source: routeDefault}},
+				{"", rules, ModelRoute{Mode: mode, Model: "rule-" + mode, Source: routeRule}},
+				{"override", rules, ModelRoute{Mode: mode, Model: "override", Source: routeOverride}},
+			}
+			for _, tc := range tests {
+				if got := selectModel(mode, tc.override, tc.rules); got != tc.want {
+					t.Errorf("routed to %+v instead of %+v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// TestDefaultModelsRegistered makes sure the model of every mode is known
+// to the registry, for its limits and pricing
+func TestDefaultModelsRegistered(t *testing.T) {
+	for _, mode := range generationModes {
+		if model := defaultModel(mode); !registered(model) {
+			t.Errorf("the %s default model %s is not registered", mode, model)
+		}
+	}
+}
+
+// TestStreamModelDefault makes sure streamed analyses use the model of the
+// other ones unless configured otherwise
+func TestStreamModelDefault(t *testing.T) {
+	if os.Getenv("CLAUDE_STREAM_MODEL") != "" {
+		t.Skip("CLAUDE_STREAM_MODEL is set")
+	}
+	for _, mode := range generationModes {
+		if model := providerDefaultModel(providerAnthropic, mode); model != analysisModel {
+			t.Errorf("the %s default model is %s instead of %s", mode, model, analysisModel)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SamplingParams are the sampling parameters a client may request for an
+// analysis. Nil values keep those of the deployment.
+type SamplingParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"topP,omitempty"`
+}
+
+// generationSampling are the sampling parameters sent to the provider.
+// Nil values and empty stop sequences leave the provider defaults.
+type generationSampling struct {
+	Temperature   *float64
+	TopP          *float64
+	StopSequences []string
+}
+
+// Most stop sequences accepted, the lowest limit among the providers
+const maxStopSequences = 4
+
+var (
+	// Sampling parameters of every generation, unset by default
+	samplingTemperature   = envOptionalFloat("SAMPLING_TEMPERATURE")
+	samplingTopP          = envOptionalFloat("SAMPLING_TOP_P")
+	samplingStopSequences = splitList(os.Getenv("SAMPLING_STOP_SEQUENCES"))
+
+	// Highest temperature clients may request, so that reports stay
+	// grounded in the answers
+	samplingMaxTemperature = envFloat("SAMPLING_MAX_TEMPERATURE", 0.7)
+)
+
+// envOptionalFloat reads a float environment variable, nil when it is
+// unset or invalid
+func envOptionalFloat(name string) *float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// validateSampling checks requested sampling parameters against the bounds
+// of the deployment
+func validateSampling(sampling *SamplingParams) error {
+	if sampling == nil {
+		return nil
+	}
+	if t := sampling.Temperature; t != nil && (*t < 0 || *t > samplingMaxTemperature) {
+		return fmt.Errorf("temperature must be between 0 and %g", samplingMaxTemperature)
+	}
+	if p := sampling.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("topP must be above 0 and at most 1")
+	}
+	return nil
+}
+
+// samplingFor returns the sampling parameters of the generations of an
+// assessment. Requested parameters replace both the temperature and the
+// top_p of the deployment, since recent models refuse to get both.
+func samplingFor(data AssessmentData) generationSampling {
+	sampling := generationSampling{Temperature: samplingTemperature, TopP: samplingTopP, StopSequences: samplingStopSequences}
+	if data.Sampling != nil && (data.Sampling.Temperature != nil || data.Sampling.TopP != nil) {
+		sampling.Temperature = data.Sampling.Temperature
+		sampling.TopP = data.Sampling.TopP
+	}
+	return sampling
+}
+
+// samplingCacheKey identifies requested sampling parameters in the
+// analysis cache key, empty when the deployment ones are used
+func samplingCacheKey(sampling *SamplingParams) string {
+	if sampling == nil {
+		return ""
+	}
+	var parts []string
+	if sampling.Temperature != nil {
+		parts = append(parts, "t="+strconv.FormatFloat(*sampling.Temperature, 'g', -1, 64))
+	}
+	if sampling.TopP != nil {
+		parts = append(parts, "p="+strconv.FormatFloat(*sampling.TopP, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+// checkSampling verifies the sampling parameters of the deployment
+func checkSampling() checkResult {
+	result := checkResult{Name: "sampling", Feature: "analysis"}
+	for name, value := range map[string]*float64{"SAMPLING_TEMPERATURE": samplingTemperature, "SAMPLING_TOP_P": samplingTopP} {
+		if value == nil && os.Getenv(name) != "" {
+			result.Detail = name + " is not a number"
+			return result
+		}
+	}
+	if t := samplingTemperature; t != nil && (*t < 0 || *t > 1) {
+		result.Detail = "SAMPLING_TEMPERATURE must be between 0 and 1"
+		return result
+	}
+	if p := samplingTopP; p != nil && (*p <= 0 || *p > 1) {
+		result.Detail = "SAMPLING_TOP_P must be above 0 and at most 1"
+		return result
+	}
+	if len(samplingStopSequences) > maxStopSequences {
+		result.Detail = fmt.Sprintf("SAMPLING_STOP_SEQUENCES lists %d sequences, at most %d are supported", len(samplingStopSequences), maxStopSequences)
+		return result
+	}
+	if samplingMaxTemperature > 1 {
+		result.Detail = "SAMPLING_MAX_TEMPERATURE must be at most 1"
+		return result
+	}
+
+	result.OK = true
+	describe := func(value *float64) string {
+		if value == nil {
+			return "provider default"
+		}
+		return strconv.FormatFloat(*value, 'g', -1, 64)
+	}
+	result.Detail = fmt.Sprintf("temperature %s, top_p %s, %d stop sequences, requests up to temperature %g",
+		describe(samplingTemperature), describe(samplingTopP), len(samplingStopSequences), samplingMaxTemperature)
+	return result
+}
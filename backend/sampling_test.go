@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// TestSamplingFor makes sure requested parameters replace both those of
+// the deployment
+func TestSamplingFor(t *testing.T) {
+	previousTemperature, previousTopP := samplingTemperature, samplingTopP
+	t.Cleanup(func() { samplingTemperature, samplingTopP = previousTemperature, previousTopP })
+	deploymentTemperature, deploymentTopP := 0.7, 0.9
+	samplingTemperature, samplingTopP = &deploymentTemperature, &deploymentTopP
+
+	requested := 0.2
+	tests := []struct {
+		name        string
+		requested   *SamplingParams
+		temperature *float64
+		topP        *float64
+	}{
+		{"deployment", nil, &deploymentTemperature, &deploymentTopP},
+		{"empty request", &SamplingParams{}, &deploymentTemperature, &deploymentTopP},
+		{"requested temperature", &SamplingParams{Temperature: &requested}, &requested, nil},
+		{"requested top_p", &SamplingParams{TopP: &requested}, nil, &requested},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sampling := samplingFor(AssessmentData{Sampling: tc.requested})
+			if sampling.Temperature != tc.temperature || sampling.TopP != tc.topP {
+				t.Errorf("temperature %v and top_p %v instead of %v and %v", sampling.Temperature, sampling.TopP, tc.temperature, tc.topP)
+			}
+		})
+	}
+}
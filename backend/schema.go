@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON Schema of the assessment submission formats, served to integrators
+// and used by the optional strict validation
+//
+//go:embed schemas/assessment.json
+var assessmentSchemaJSON []byte
+
+// OpenAPI document of the routes, whose component schemas use the same
+// subset of JSON Schema
+//
+//go:embed schemas/openapi.json
+var openAPIJSON []byte
+
+// Validate every assessment body against the schema, rather than only when
+// the client asks for it with validate=schema
+var schemaValidationStrict = envString("SCHEMA_VALIDATION", "") == "strict"
+
+// jsonSchema is the subset of JSON Schema the assessment schema uses
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Format               string                 `json:"format"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+}
+
+// schemaTypes accepts both a single type and a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// ValidationError is a validation failure at a JSON Pointer into the body
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	// Values that would have been accepted, when there is a closed list
+	Accepted []string `json:"accepted,omitempty"`
+}
+
+// validationErrors is a validation failure pointing at each offending field
+type validationErrors []ValidationError
+
+func (e validationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Message
+	}
+	return fmt.Sprintf("%s, and %d more errors", e[0].Message, len(e)-1)
+}
+
+// invalidAssessment is the response to an assessment that failed
+// validation, with the offending fields when the error points at them
+func invalidAssessment(err error) gin.H {
+	response := gin.H{"error": "Invalid assessment data: " + err.Error()}
+	var errs validationErrors
+	if errors.As(err, &errs) {
+		response["validation_errors"] = errs
+	}
+	return response
+}
+
+var assessmentSchema = mustParseSchema(assessmentSchemaJSON)
+
+func mustParseSchema(content []byte) *jsonSchema {
+	var schema jsonSchema
+	if err := json.Unmarshal(content, &schema); err != nil {
+		panic(fmt.Sprintf("invalid embedded JSON schema: %v", err))
+	}
+	return &schema
+}
+
+// resolve follows a local $ref, to $defs or to the component schemas of an
+// OpenAPI document
+func (s *jsonSchema) resolve(node *jsonSchema) *jsonSchema {
+	for node.Ref != "" {
+		name := strings.TrimPrefix(strings.TrimPrefix(node.Ref, "#/$defs/"), "#/components/schemas/")
+		def, ok := s.Defs[name]
+		if !ok {
+			panic(fmt.Sprintf("unresolved JSON schema reference %s", node.Ref))
+		}
+		node = def
+	}
+	return node
+}
+
+// Validate checks a JSON document against the schema
+func (s *jsonSchema) Validate(body []byte) []ValidationError {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []ValidationError{{Path: "", Message: "invalid JSON: " + err.Error()}}
+	}
+	var errs []ValidationError
+	s.validate(s, value, "", &errs)
+	return errs
+}
+
+func (s *jsonSchema) validate(node *jsonSchema, value any, path string, errs *[]ValidationError) {
+	node = s.resolve(node)
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(node.Type) > 0 && !matchesSchemaType(node.Type, value) {
+		fail("expected %s, got %s", strings.Join(node.Type, " or "), jsonTypeName(value))
+		return
+	}
+	if len(node.Enum) > 0 {
+		allowed := false
+		for _, e := range node.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			fail("value %v is not one of %v", value, node.Enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		n, _ := v.Float64()
+		if node.Minimum != nil && n < *node.Minimum {
+			fail("value %s is below the minimum of %g", v, *node.Minimum)
+		}
+		if node.Maximum != nil && n > *node.Maximum {
+			fail("value %s is above the maximum of %g", v, *node.Maximum)
+		}
+	case string:
+		if node.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				fail("value %q is not an RFC 3339 date-time", v)
+			}
+		}
+	case []any:
+		if node.Items != nil {
+			for i, item := range v {
+				s.validate(node.Items, item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case map[string]any:
+		for _, key := range node.Required {
+			if _, ok := v[key]; !ok {
+				fail("missing required property %q", key)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + escapeJSONPointer(key)
+			if property, ok := node.Properties[key]; ok {
+				s.validate(property, v[key], child, errs)
+			} else if node.AdditionalProperties != nil && !*node.AdditionalProperties {
+				*errs = append(*errs, ValidationError{Path: child, Message: "unknown property"})
+			}
+		}
+	}
+}
+
+func matchesSchemaType(types schemaTypes, value any) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			if _, err := v.Int64(); err == nil && t == "integer" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// escapeJSONPointer escapes a key as a JSON Pointer reference token
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// schemaValidation checks assessment bodies against the schema before they
+// are bound, when the client asks for it with validate=schema or when
+// SCHEMA_VALIDATION=strict
+func schemaValidation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !schemaValidationStrict && c.Query("validate") != "schema" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Failed to read request body: " + err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The schema is that of the current version of the format
+		migrated, err := migrateAssessmentJSON(body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, invalidAssessment(err))
+			return
+		}
+		if errs := assessmentSchema.Validate(migrated); len(errs) > 0 {
+			log.Printf("❌ Assessment does not match the schema: %d errors", len(errs))
+			c.AbortWithStatusJSON(400, invalidAssessment(validationErrors(errs)))
+			return
+		}
+		c.Next()
+	}
+}
+
+// assessmentSchemaHandler serves the assessment JSON Schema
+func assessmentSchemaHandler(c *gin.Context) {
+	c.Data(200, "application/schema+json", assessmentSchemaJSON)
+}
+
+// openAPIHandler serves the OpenAPI document of the routes
+func openAPIHandler(c *gin.Context) {
+	c.Data(200, "application/json", openAPIJSON)
+}
+
+// schemaCoverage lists the differences between the fields of a Go type and
+// the properties of its schema, in both directions
+func schemaCoverage(schema *jsonSchema, node *jsonSchema, t reflect.Type, path string) []string {
+	node = schema.resolve(node)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return nil
+	case t.Kind() == reflect.Slice:
+		if node.Items == nil {
+			return []string{path + " has no items schema"}
+		}
+		return schemaCoverage(schema, node.Items, t.Elem(), path+"/items")
+	case t.Kind() != reflect.Struct:
+		return nil
+	}
+
+	var diffs []string
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		fields[name] = true
+		property, ok := node.Properties[name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("field %s.%s is missing from the schema at %s", t.Name(), field.Name, path))
+			continue
+		}
+		diffs = append(diffs, schemaCoverage(schema, property, field.Type, path+"/"+name)...)
+	}
+	for name := range node.Properties {
+		if !fields[name] {
+			diffs = append(diffs, fmt.Sprintf("schema property %s/%s has no field in %s", path, name, t.Name()))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// schemaEnumDiff compares an enum of the schema with the values the server
+// accepts
+func schemaEnumDiff(property string, enum []any, accepted []string) string {
+	values := make([]string, 0, len(enum))
+	for _, e := range enum {
+		if s := fmt.Sprint(e); s != "" {
+			values = append(values, s)
+		}
+	}
+	sort.Strings(values)
+	sort.Strings(accepted)
+	if !reflect.DeepEqual(values, accepted) {
+		return fmt.Sprintf("schema enum of %s is %v, the server accepts %v", property, values, accepted)
+	}
+	return ""
+}
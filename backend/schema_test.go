@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAssessmentSchemaCoverage makes sure the JSON Schema and the Go
+// structs describe the same fields, so that they cannot drift apart
+func TestAssessmentSchemaCoverage(t *testing.T) {
+	for _, diff := range schemaCoverage(assessmentSchema, assessmentSchema, reflect.TypeOf(AssessmentData{}), "") {
+		t.Error(diff)
+	}
+
+	languages := make([]string, 0, len(supportedLanguages))
+	for code := range supportedLanguages {
+		languages = append(languages, code)
+	}
+	if diff := schemaEnumDiff("language", assessmentSchema.Properties["language"].Enum, languages); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestAssessmentSchemaValidate(t *testing.T) {
+	sample := AssessmentData{
+		Language:            "en",
+		Metadata:            Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+		QuestionsAndAnswers: []QuestionAndAnswer{{ID: 1, Text: "Sample", Answer: 2, AnswerText: "Never true"}},
+	}
+	body, err := json.Marshal(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := assessmentSchema.Validate(body); len(errs) > 0 {
+		t.Fatalf("marshalled assessment rejected at %q: %s", errs[0].Path, errs[0].Message)
+	}
+
+	tests := map[string]string{
+		"unknown language": `{"language":"xx"}`,
+		"unknown field":    `{"language":"en","unknown":true}`,
+		"negative answer":  `{"language":"en","answers":[{"id":1,"answer":-1}]}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if errs := assessmentSchema.Validate([]byte(body)); len(errs) == 0 {
+				t.Errorf("%s accepted", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Versions of the assessment submission format. Payloads carry the version
+// they were written for in schemaVersion, and those without one predate
+// versioning and are read as version 1, the format of the submissions that
+// frontends cached before it.
+//
+// Changes that older payloads still decode into, such as a new optional
+// field, keep the version. For any other change:
+//  1. bump currentSchemaVersion, along with the maximum of schemaVersion in
+//     schemas/assessment.json, and update the schema and structs to the new
+//     format
+//  2. append a migration to assessmentMigrations, rewriting the JSON of a
+//     payload of the previous version into the new format
+//  3. have the frontend send the new version
+//
+// Payloads of every earlier version are then upgraded one version at a time
+// before they are validated and bound, so that cached submissions keep
+// working while frontends catch up. Payloads of a newer version than the
+// server knows are rejected rather than misread.
+const currentSchemaVersion = 1
+
+// assessmentMigration rewrites a decoded payload of one version into the
+// format of the next. assessmentMigrations[i] upgrades version i+1.
+type assessmentMigration struct {
+	Description string
+	Migrate     func(payload map[string]any) error
+}
+
+var assessmentMigrations = []assessmentMigration{}
+
+// payloadSchemaVersion reads the version of a payload, 1 when it has none
+func payloadSchemaVersion(body []byte) (int, error) {
+	var versioned struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(body, &versioned); err != nil {
+		return 0, err
+	}
+	switch version := versioned.SchemaVersion; {
+	case version == nil:
+		return 1, nil
+	case *version < 1:
+		return 0, fmt.Errorf("invalid schemaVersion %d", *version)
+	case *version > currentSchemaVersion:
+		return 0, fmt.Errorf("schemaVersion %d is newer than the version %d this server supports", *version, currentSchemaVersion)
+	default:
+		return *version, nil
+	}
+}
+
+// migrateAssessmentJSON upgrades an assessment payload to the current
+// version. Payloads already in the current version are returned unchanged.
+func migrateAssessmentJSON(body []byte) ([]byte, error) {
+	if bytes.Equal(bytes.TrimSpace(body), []byte("null")) {
+		return body, nil
+	}
+	version, err := payloadSchemaVersion(body)
+	if err != nil || version == currentSchemaVersion {
+		return body, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var payload map[string]any
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	for ; version < currentSchemaVersion; version++ {
+		migration := assessmentMigrations[version-1]
+		if err := migration.Migrate(payload); err != nil {
+			return nil, fmt.Errorf("failed to upgrade schemaVersion %d (%s): %w", version, migration.Description, err)
+		}
+	}
+	payload["schemaVersion"] = currentSchemaVersion
+	return json.Marshal(payload)
+}
+
+// UnmarshalJSON upgrades payloads of earlier versions of the format before
+// decoding them, so that every decoded assessment is in the current version.
+// It is promoted to the structs embedding AssessmentData, which must then
+// decode their own fields.
+func (d *AssessmentData) UnmarshalJSON(b []byte) error {
+	migrated, err := migrateAssessmentJSON(b)
+	if err != nil {
+		return err
+	}
+	type assessmentData AssessmentData
+	var decoded assessmentData
+	if err := json.Unmarshal(migrated, &decoded); err != nil {
+		return err
+	}
+	decoded.SchemaVersion = currentSchemaVersion
+	*d = AssessmentData(decoded)
+	return nil
+}
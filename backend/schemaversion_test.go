@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestSchemaVersions makes sure a migration leads from every earlier
+// version to the current one, which the JSON Schema accepts as its latest
+func TestSchemaVersions(t *testing.T) {
+	if len(assessmentMigrations) != currentSchemaVersion-1 {
+		t.Errorf("%d migrations for %d earlier versions", len(assessmentMigrations), currentSchemaVersion-1)
+	}
+	if maximum := assessmentSchema.Properties["schemaVersion"].Maximum; maximum == nil || *maximum != currentSchemaVersion {
+		t.Errorf("the schema does not accept schemaVersion %d as its latest", currentSchemaVersion)
+	}
+}
+
+func TestAssessmentDataVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		version int
+		invalid bool
+	}{
+		{"unversioned", `{"language":"en","answers":[{"id":1,"answer":2}]}`, currentSchemaVersion, false},
+		{"current", fmt.Sprintf(`{"language":"en","schemaVersion":%d}`, currentSchemaVersion), currentSchemaVersion, false},
+		{"future", fmt.Sprintf(`{"language":"en","schemaVersion":%d}`, currentSchemaVersion+1), 0, true},
+		{"zero", `{"language":"en","schemaVersion":0}`, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var data AssessmentData
+			err := json.Unmarshal([]byte(tc.body), &data)
+			if invalid := err != nil; invalid != tc.invalid {
+				t.Fatalf("rejected: %t, want %t (%v)", invalid, tc.invalid, err)
+			}
+			if !tc.invalid && data.SchemaVersion != tc.version {
+				t.Errorf("decoded as version %d instead of %d", data.SchemaVersion, tc.version)
+			}
+		})
+	}
+}
+
+func TestAssessmentDataRoundTrip(t *testing.T) {
+	var decoded, roundTripped AssessmentData
+	if err := json.Unmarshal([]byte(`{"language":"en","answers":[{"id":1,"answer":2}]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body, &roundTripped); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, roundTripped) {
+		t.Errorf("%+v does not round-trip: %+v", decoded, roundTripped)
+	}
+}
+
+// TestEmbeddingAssessmentData makes sure the requests embedding
+// AssessmentData still decode their own fields
+func TestEmbeddingAssessmentData(t *testing.T) {
+	var domain DomainReportRequest
+	if err := json.Unmarshal([]byte(`{"language":"en","domain":"social"}`), &domain); err != nil {
+		t.Fatal(err)
+	}
+	if domain.Language != "en" || domain.Domain != "social" {
+		t.Errorf("decoded as %+v", domain)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Domain describes one RAADS-R subscale
+type Domain struct {
+	Key       string  `json:"key"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category"`
+	Items     int     `json:"items"`
+	Threshold int     `json:"threshold"`
+	NTMean    float64 `json:"nt_mean"`
+}
+
+// MaxScore is the highest score achievable on the domain
+func (d Domain) MaxScore() int {
+	return d.Items * 3
+}
+
+// NTItemAverage is the published neurotypical mean spread evenly over items
+func (d Domain) NTItemAverage() float64 {
+	return d.NTMean / float64(d.Items)
+}
+
+// RAADS-R subscales with the thresholds and neurotypical means published by
+// Ritvo et al. (2011), replaced by those of the normative dataset in use and
+// overridable by CLINICAL_CONFIG_FILE
+var raadsDomains = []Domain{
+	{Key: "social", Name: "Social Relatedness", Category: "IS", Items: 39, Threshold: 30, NTMean: 12.5},
+	{Key: "sensory", Name: "Sensory/Motor", Category: "SM", Items: 20, Threshold: 15, NTMean: 6.5},
+	{Key: "restricted", Name: "Circumscribed Interests", Category: "IR", Items: 14, Threshold: 14, NTMean: 4.5},
+	{Key: "language", Name: "Language", Category: "L", Items: 7, Threshold: 3, NTMean: 2.5},
+}
+
+// Highest possible value of the total score
+const raadsMaxTotal = 240
+
+// Clinical threshold of the total score, replaced by that of the normative
+// dataset in use and overridable by CLINICAL_CONFIG_FILE
+var totalThreshold = 65
+
+// domainForCategory returns the domain a question category belongs to
+func domainForCategory(category string) (Domain, bool) {
+	for _, d := range raadsDomains {
+		if d.Category == category {
+			return d, true
+		}
+	}
+	return Domain{}, false
+}
+
+// domainByKey returns the domain with the given key
+func domainByKey(key string) (Domain, bool) {
+	for _, d := range raadsDomains {
+		if d.Key == key {
+			return d, true
+		}
+	}
+	return Domain{}, false
+}
+
+// QuestionContribution describes how much a single answer weighs in its domain
+type QuestionContribution struct {
+	ID                  int     `json:"id"`
+	Domain              string  `json:"domain"`
+	Score               int     `json:"score"`
+	Reverse             bool    `json:"reverse"`
+	ShareOfDomain       float64 `json:"share_of_domain"`
+	ShareOfDomainMax    float64 `json:"share_of_domain_max"`
+	ShareOfThreshold    float64 `json:"share_of_threshold"`
+	NTItemAverage       float64 `json:"nt_item_average"`
+	AboveNTAverage      bool    `json:"above_nt_average"`
+	DomainOverThreshold bool    `json:"domain_over_threshold"`
+}
+
+// itemScore scores an answer. Answer 0 ("true now and when I was young")
+// weighs the most, except on reverse items.
+func itemScore(reverse bool, answer int) int {
+	if reverse {
+		return answer
+	}
+	return 3 - answer
+}
+
+// scoresFromItems sums item scores into the total and domain scores
+func scoresFromItems(qas []QuestionAndAnswer) Scores {
+	totals := domainTotals(AssessmentData{QuestionsAndAnswers: qas})
+	scores := Scores{
+		Language:   totals["language"],
+		Social:     totals["social"],
+		Sensory:    totals["sensory"],
+		Restricted: totals["restricted"],
+		MaxTotal:   raadsMaxTotal,
+	}
+	for _, d := range raadsDomains {
+		scores.Total += totals[d.Key]
+		switch d.Key {
+		case "language":
+			scores.MaxLanguage = d.MaxScore()
+		case "social":
+			scores.MaxSocial = d.MaxScore()
+		case "sensory":
+			scores.MaxSensory = d.MaxScore()
+		case "restricted":
+			scores.MaxRestricted = d.MaxScore()
+		}
+	}
+	return scores
+}
+
+// domainTotals sums item scores per domain key
+func domainTotals(data AssessmentData) map[string]int {
+	totals := map[string]int{}
+	for _, qa := range data.QuestionsAndAnswers {
+		if d, ok := domainForCategory(qa.Category); ok {
+			totals[d.Key] += qa.Score
+		}
+	}
+	return totals
+}
+
+// questionContributions computes per-question contribution metrics, in the
+// same order as QuestionsAndAnswers
+func questionContributions(ctx context.Context, data AssessmentData) []QuestionContribution {
+	totals := domainTotals(data)
+
+	contributions := make([]QuestionContribution, 0, len(data.QuestionsAndAnswers))
+	for _, qa := range data.QuestionsAndAnswers {
+		contribution := QuestionContribution{ID: qa.ID, Score: qa.Score, Reverse: qa.Reverse}
+
+		d, ok := domainForCategory(qa.Category)
+		if !ok {
+			warningsFrom(ctx).Add(Warning{
+				Code:       warnUnknownCategory,
+				Message:    fmt.Sprintf("unknown category %q for question %d", qa.Category, qa.ID),
+				QuestionID: qa.ID,
+			})
+			contributions = append(contributions, contribution)
+			continue
+		}
+
+		contribution.Domain = d.Key
+		if totals[d.Key] > 0 {
+			contribution.ShareOfDomain = float64(qa.Score) / float64(totals[d.Key])
+		}
+		contribution.ShareOfDomainMax = float64(qa.Score) / float64(d.MaxScore())
+		contribution.ShareOfThreshold = float64(qa.Score) / float64(d.Threshold)
+		contribution.NTItemAverage = d.NTItemAverage()
+		contribution.AboveNTAverage = float64(qa.Score) > d.NTItemAverage()
+		contribution.DomainOverThreshold = totals[d.Key] >= d.Threshold
+
+		contributions = append(contributions, contribution)
+	}
+
+	return contributions
+}
+
+// scoreHandler returns the total and domain scores, the interpretation and
+// per-question contributions of an assessment, computed from its answers.
+// Raw answers are enough: the test date of a minimal submission is
+// optional, as it does not change the scores.
+func scoreHandler(c *gin.Context) {
+	var data AssessmentData
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+	if data.Answers != nil && data.TestDate == nil {
+		now := time.Now()
+		data.TestDate = &now
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &data); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+
+	if instrumentOf(data).Key != instrumentRAADSR {
+		c.JSON(200, instrumentScoreResponse(c.Request.Context(), data))
+		return
+	}
+
+	totals := domainTotals(data)
+	domains := make([]gin.H, 0, len(raadsDomains))
+	for _, d := range raadsDomains {
+		domains = append(domains, gin.H{
+			"domain":         d,
+			"score":          totals[d.Key],
+			"max":            d.MaxScore(),
+			"over_threshold": totals[d.Key] >= d.Threshold,
+		})
+	}
+
+	chart, err := chartDataFor(data)
+	if err != nil {
+		log.Printf("❌ Error computing chart data: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to compute chart data: " + err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"total": gin.H{
+			"score":          data.Scores.Total,
+			"max":            data.Scores.MaxTotal,
+			"threshold":      totalThreshold,
+			"over_threshold": data.Scores.Total >= totalThreshold,
+		},
+		"scores":             data.Scores,
+		"interpretation":     data.Interpretation,
+		"answered_questions": data.Metadata.AnsweredQuestions,
+		"domains":            domains,
+		"contributions":      questionContributions(c.Request.Context(), data),
+		"clusters":           itemClusterScores(data),
+		"chart":              chart,
+	}
+	if percentiles := normativePercentiles(data); percentiles != nil {
+		response["percentiles"] = percentiles
+	}
+	if flags := validityFlags(data); len(flags) > 0 {
+		response["validity"] = flags
+	}
+	if warnings := warningsFrom(c.Request.Context()).List(); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	c.JSON(200, response)
+}
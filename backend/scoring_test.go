@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// useRitvoDomains scores against the subscales published by Ritvo et al.
+// (2011) for the duration of a test, whichever normative dataset is loaded
+func useRitvoDomains(t *testing.T) {
+	t.Helper()
+	previous := raadsDomains
+	t.Cleanup(func() { raadsDomains = previous })
+	raadsDomains = []Domain{
+		{Key: "social", Name: "Social Relatedness", Category: "IS", Items: 39, Threshold: 30, NTMean: 12.5},
+		{Key: "sensory", Name: "Sensory/Motor", Category: "SM", Items: 20, Threshold: 15, NTMean: 6.5},
+		{Key: "restricted", Name: "Circumscribed Interests", Category: "IR", Items: 14, Threshold: 14, NTMean: 4.5},
+		{Key: "language", Name: "Language", Category: "L", Items: 7, Threshold: 3, NTMean: 2.5},
+	}
+}
+
+// TestQuestionContributions scores a crafted assessment with items of every
+// subscale, reverse-scored or not, against contributions computed by hand
+func TestQuestionContributions(t *testing.T) {
+	useRitvoDomains(t)
+	answers := []struct {
+		id       int
+		category string
+		reverse  bool
+		answer   int
+		score    int
+	}{
+		// Social relatedness: 5 points, under its threshold of 30
+		{1, "IS", true, 3, 3},
+		{2, "IS", false, 1, 2},
+		// Sensory/motor: no points
+		{3, "SM", false, 3, 0},
+		// Circumscribed interests: 3 points, under its threshold of 14
+		{4, "IR", true, 0, 0},
+		{5, "IR", false, 0, 3},
+		// Language: 5 points, over its threshold of 3
+		{6, "L", false, 0, 3},
+		{7, "L", true, 2, 2},
+		// No subscale
+		{8, "XX", false, 1, 2},
+	}
+	var data AssessmentData
+	for _, a := range answers {
+		score := itemScore(a.reverse, a.answer)
+		if score != a.score {
+			t.Errorf("answer %d to question %d scores %d instead of %d", a.answer, a.id, score, a.score)
+		}
+		data.QuestionsAndAnswers = append(data.QuestionsAndAnswers, QuestionAndAnswer{ID: a.id, Category: a.category, Reverse: a.reverse, Answer: a.answer, Score: score})
+	}
+
+	want := []QuestionContribution{
+		{ID: 1, Domain: "social", Score: 3, Reverse: true, ShareOfDomain: 3.0 / 5, ShareOfDomainMax: 3.0 / 117, ShareOfThreshold: 3.0 / 30, NTItemAverage: 12.5 / 39, AboveNTAverage: true},
+		{ID: 2, Domain: "social", Score: 2, ShareOfDomain: 2.0 / 5, ShareOfDomainMax: 2.0 / 117, ShareOfThreshold: 2.0 / 30, NTItemAverage: 12.5 / 39, AboveNTAverage: true},
+		{ID: 3, Domain: "sensory", Score: 0, NTItemAverage: 6.5 / 20},
+		{ID: 4, Domain: "restricted", Score: 0, Reverse: true, NTItemAverage: 4.5 / 14},
+		{ID: 5, Domain: "restricted", Score: 3, ShareOfDomain: 1, ShareOfDomainMax: 3.0 / 42, ShareOfThreshold: 3.0 / 14, NTItemAverage: 4.5 / 14, AboveNTAverage: true},
+		{ID: 6, Domain: "language", Score: 3, ShareOfDomain: 3.0 / 5, ShareOfDomainMax: 3.0 / 21, ShareOfThreshold: 1, NTItemAverage: 2.5 / 7, AboveNTAverage: true, DomainOverThreshold: true},
+		{ID: 7, Domain: "language", Score: 2, Reverse: true, ShareOfDomain: 2.0 / 5, ShareOfDomainMax: 2.0 / 21, ShareOfThreshold: 2.0 / 3, NTItemAverage: 2.5 / 7, AboveNTAverage: true, DomainOverThreshold: true},
+		{ID: 8, Score: 2},
+	}
+
+	warnings := &WarningCollector{}
+	ctx := context.WithValue(context.Background(), warningsKey{}, warnings)
+	contributions := questionContributions(ctx, data)
+	if len(contributions) != len(want) {
+		t.Fatalf("%d contributions instead of %d", len(contributions), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(contributions[i], want[i]) {
+			t.Errorf("question %d contributes\n%+v\ninstead of\n%+v", want[i].ID, contributions[i], want[i])
+		}
+	}
+	if list := warnings.List(); len(list) != 1 || list[0].Code != warnUnknownCategory || list[0].QuestionID != 8 {
+		t.Errorf("warnings %+v instead of an unknown category for question 8", list)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of submissions kept for aggregate statistics
+const statsMaxRecords = 50000
+
+var (
+	// Minimum number of submissions a slice must contain to be reported
+	statsMinBucket = envInt("STATS_MIN_BUCKET", 20)
+
+	// Counts below this threshold are reported with Laplace noise added
+	statsNoiseThreshold = envInt("STATS_NOISE_THRESHOLD", 100)
+
+	// Privacy budget used to calibrate the noise added to small counts
+	statsEpsilon = envFloat("STATS_EPSILON", 1.0)
+
+	// Key deriving the noise of a count from its slice, so that repeating
+	// a query returns the same noise instead of letting it be averaged out
+	statsNoiseKey = newStatsNoiseKey()
+)
+
+// Age bands submissions are sliced by, as the lower bound of each band
+// with the last one open-ended
+var statsAgeBands = []struct {
+	Name string
+	From int
+}{
+	{"16-24", 16},
+	{"25-34", 25},
+	{"35-44", 35},
+	{"45-54", 45},
+	{"55-64", 55},
+	{"65+", 65},
+}
+
+// ageBand returns the band of an age, or an empty string when the age is
+// unknown
+func ageBand(age int) string {
+	band := ""
+	for _, b := range statsAgeBands {
+		if age >= b.From {
+			band = b.Name
+		}
+	}
+	return band
+}
+
+// knownAgeBand reports whether a band is one submissions are sliced by
+func knownAgeBand(name string) bool {
+	for _, b := range statsAgeBands {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newStatsNoiseKey() []byte {
+	if key := envString("STATS_NOISE_KEY", ""); key != "" {
+		return []byte(key)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate the stats noise key: %v", err))
+	}
+	return key
+}
+
+// statsRecord is the only information retained about a submission
+type statsRecord struct {
+	Language      string
+	AgeBand       string
+	Week          string
+	Total         int
+	Social        int
+	Sensory       int
+	Restricted    int
+	LanguageScore int
+}
+
+type statsStore struct {
+	mu      sync.Mutex
+	records []statsRecord
+}
+
+var stats = &statsStore{}
+
+// Record adds a submission to the aggregate statistics. Only RAADS-R
+// submissions are kept, the scores of the other instruments being on other
+// scales and their subscales other than the RAADS-R domains.
+func (s *statsStore) Record(data AssessmentData) {
+	if instrumentOf(data).Key != instrumentRAADSR {
+		return
+	}
+	year, week := time.Now().UTC().ISOWeek()
+	record := statsRecord{
+		Language:      data.Language,
+		Week:          fmt.Sprintf("%d-W%02d", year, week),
+		Total:         data.Scores.Total,
+		Social:        data.Scores.Social,
+		Sensory:       data.Scores.Sensory,
+		Restricted:    data.Scores.Restricted,
+		LanguageScore: data.Scores.Language,
+	}
+	if data.Demographics != nil {
+		record.AgeBand = ageBand(data.Demographics.Age)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	if len(s.records) > statsMaxRecords {
+		s.records = s.records[len(s.records)-statsMaxRecords:]
+	}
+}
+
+// Slice returns the records matching the given filters (empty means any)
+func (s *statsStore) Slice(language, band, week string) []statsRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var slice []statsRecord
+	for _, r := range s.records {
+		if (language == "" || r.Language == language) && (band == "" || r.AgeBand == band) && (week == "" || r.Week == week) {
+			slice = append(slice, r)
+		}
+	}
+	return slice
+}
+
+// noisyCount reports the count of a slice, adding Laplace noise when it is
+// small enough for the exact value to help re-identify a submission. The
+// noise is drawn from the slice and its count, so that it only changes
+// along with the count.
+func noisyCount(slice string, n int) int {
+	if n >= statsNoiseThreshold {
+		return n
+	}
+	mac := hmac.New(sha256.New, statsNoiseKey)
+	fmt.Fprintf(mac, "%s\x00%d", slice, n)
+	u := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)/(1<<53) - 0.5
+	noise := -math.Copysign(1/statsEpsilon, u) * math.Log(1-2*math.Abs(u))
+	return int(math.Max(0, math.Round(float64(n)+noise)))
+}
+
+// scoreSummary computes the quartiles of a list of scores
+func scoreSummary(values []int) gin.H {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	quantile := func(q float64) float64 {
+		pos := q * float64(len(sorted)-1)
+		lower := int(math.Floor(pos))
+		upper := int(math.Ceil(pos))
+		return float64(sorted[lower]) + (pos-float64(lower))*float64(sorted[upper]-sorted[lower])
+	}
+	return gin.H{
+		"p25":    quantile(0.25),
+		"median": quantile(0.5),
+		"p75":    quantile(0.75),
+	}
+}
+
+// statsHandler reports aggregate score statistics for a slice of RAADS-R
+// submissions.
+// Slices smaller than the minimum bucket size are refused, and the
+// breakdowns by language and age band only include buckets that meet that
+// size.
+func statsHandler(c *gin.Context) {
+	language := c.Query("language")
+	band := c.Query("age_band")
+	week := c.Query("week")
+
+	if band != "" && !knownAgeBand(band) {
+		accepted := make([]string, 0, len(statsAgeBands))
+		for _, b := range statsAgeBands {
+			accepted = append(accepted, b.Name)
+		}
+		c.JSON(400, gin.H{"error": "Unknown age band " + band, "accepted": accepted})
+		return
+	}
+
+	guarantees := gin.H{
+		"k_anonymity":     statsMinBucket,
+		"noise_threshold": statsNoiseThreshold,
+		"noise":           "laplace",
+		"epsilon":         statsEpsilon,
+		"description":     fmt.Sprintf("Slices with fewer than %d submissions are suppressed; counts below %d include Laplace noise, the same for repeated queries.", statsMinBucket, statsNoiseThreshold),
+	}
+
+	records := stats.Slice(language, band, week)
+	if len(records) < statsMinBucket {
+		c.JSON(422, gin.H{
+			"error":      "Slice too small to be reported",
+			"suppressed": true,
+			"privacy":    guarantees,
+		})
+		return
+	}
+
+	var total, social, sensory, restricted, lang []int
+	byLanguage := map[string]int{}
+	byAgeBand := map[string]int{}
+	for _, r := range records {
+		total = append(total, r.Total)
+		social = append(social, r.Social)
+		sensory = append(sensory, r.Sensory)
+		restricted = append(restricted, r.Restricted)
+		lang = append(lang, r.LanguageScore)
+		byLanguage[r.Language]++
+		if r.AgeBand != "" {
+			byAgeBand[r.AgeBand]++
+		}
+	}
+
+	slice := fmt.Sprintf("language=%s&age_band=%s&week=%s", language, band, week)
+	suppressed := 0
+	breakdown := func(dimension string, counts map[string]int) gin.H {
+		buckets := gin.H{}
+		for value, n := range counts {
+			if n < statsMinBucket {
+				suppressed++
+				continue
+			}
+			buckets[value] = noisyCount(slice+"&"+dimension+"="+value, n)
+		}
+		return buckets
+	}
+	languages := breakdown("language", byLanguage)
+	ageBands := breakdown("age_band", byAgeBand)
+
+	c.JSON(200, gin.H{
+		"instrument": instrumentRAADSR,
+		"filters":    gin.H{"language": language, "age_band": band, "week": week},
+		"count":      noisyCount(slice, len(records)),
+		"scores": gin.H{
+			"total":      scoreSummary(total),
+			"social":     scoreSummary(social),
+			"sensory":    scoreSummary(sensory),
+			"restricted": scoreSummary(restricted),
+			"language":   scoreSummary(lang),
+		},
+		"by_language":        languages,
+		"by_age_band":        ageBands,
+		"suppressed_buckets": suppressed,
+		"privacy":            guarantees,
+		"k_anonymity":        statsMinBucket,
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// useStats records submissions in a store of their own for the duration of
+// a test
+func useStats(t *testing.T, submissions map[string]int) {
+	t.Helper()
+	previous := stats
+	stats = &statsStore{}
+	t.Cleanup(func() { stats = previous })
+	for key, n := range submissions {
+		var language string
+		var age int
+		if _, err := fmt.Sscanf(key, "%s %d", &language, &age); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			stats.Record(AssessmentData{Language: language, Demographics: &Demographics{Age: age}, Scores: Scores{Total: 40 + i}})
+		}
+	}
+}
+
+// TestStatsThinSlices makes sure slices by language, age band and week
+// below statsMinBucket are suppressed, whole or from the breakdowns
+func TestStatsThinSlices(t *testing.T) {
+	useStats(t, map[string]int{
+		"en 30": statsMinBucket + 5,
+		"en 50": statsMinBucket - 1,
+		"it 30": 1,
+	})
+	year, week := time.Now().UTC().ISOWeek()
+	thisWeek := fmt.Sprintf("%d-W%02d", year, week)
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", 200},
+		{"?language=en&age_band=25-34&week=" + thisWeek, 200},
+		{"?language=it", 422},
+		{"?language=it&age_band=25-34&week=" + thisWeek, 422},
+		{"?language=en&age_band=45-54", 422},
+		{"?language=en&age_band=25-34&week=2001-W01", 422},
+		{"?age_band=30", 400},
+	}
+	for _, tc := range tests {
+		response := decodeResponse(t, serve(t, "GET", "/stats"+tc.query, nil, nil), tc.status)
+		if tc.status == 422 && (response["suppressed"] != true || response["scores"] != nil) {
+			t.Errorf("the thin slice %q is reported: %v", tc.query, response)
+		}
+	}
+
+	response := decodeResponse(t, serve(t, "GET", "/stats", nil, nil), 200)
+	languages := response["by_language"].(map[string]any)
+	bands := response["by_age_band"].(map[string]any)
+	if _, ok := languages["it"]; ok {
+		t.Error("a language with a single submission is in the breakdown")
+	}
+	if _, ok := bands["45-54"]; ok {
+		t.Error("an age band below the minimum bucket size is in the breakdown")
+	}
+	if _, ok := bands["25-34"]; !ok {
+		t.Error("an age band above the minimum bucket size is missing from the breakdown")
+	}
+	if response["suppressed_buckets"] != float64(2) || response["k_anonymity"] != float64(statsMinBucket) {
+		t.Errorf("unexpected guarantees: %v suppressed buckets, k-anonymity %v", response["suppressed_buckets"], response["k_anonymity"])
+	}
+}
+
+// TestStatsNoise makes sure small counts are noised the same way on every
+// query, so that repeating it does not average the noise out
+func TestStatsNoise(t *testing.T) {
+	if n := noisyCount("large", statsNoiseThreshold); n != statsNoiseThreshold {
+		t.Errorf("a count above the noise threshold was changed to %d", n)
+	}
+
+	noised := 0
+	for n := 0; n < 50; n++ {
+		slice := fmt.Sprintf("language=en&week=%d", n)
+		count := noisyCount(slice, 30)
+		for i := 0; i < 5; i++ {
+			if again := noisyCount(slice, 30); again != count {
+				t.Fatalf("the count of slice %s was reported as %d then %d", slice, count, again)
+			}
+		}
+		if count != 30 {
+			noised++
+		}
+		if count < 0 {
+			t.Errorf("negative count %d", count)
+		}
+	}
+	if noised == 0 {
+		t.Error("no count below the noise threshold was noised")
+	}
+}
+
+func TestAgeBand(t *testing.T) {
+	tests := map[int]string{0: "", 15: "", 16: "16-24", 24: "16-24", 25: "25-34", 64: "55-64", 65: "65+", 99: "65+"}
+	for age, want := range tests {
+		if got := ageBand(age); got != want {
+			t.Errorf("age %d is in band %q instead of %q", age, got, want)
+		}
+	}
+}
+
+// TestStatsInstruments makes sure submissions of the other instruments,
+// whose scores are on other scales, are left out of the RAADS-R statistics
+func TestStatsInstruments(t *testing.T) {
+	useStats(t, map[string]int{"en 30": statsMinBucket})
+	for _, instrument := range []string{instrumentAQ50, instrumentEQSQ, instrumentRBQ2A} {
+		for i := 0; i < statsMinBucket; i++ {
+			stats.Record(AssessmentData{Language: "fr", Instrument: instrument, Demographics: &Demographics{Age: 30}, Scores: Scores{Total: 200}})
+		}
+	}
+
+	response := decodeResponse(t, serve(t, "GET", "/stats", nil, nil), 200)
+	total := response["scores"].(map[string]any)["total"].(map[string]any)
+	if total["p75"].(float64) >= 200 {
+		t.Errorf("the scores of other instruments are in the RAADS-R quartiles: %v", total)
+	}
+	if _, ok := response["by_language"].(map[string]any)["fr"]; ok {
+		t.Error("submissions of other instruments are in the language breakdown")
+	}
+	decodeResponse(t, serve(t, "GET", "/stats?language=fr", nil, nil), 422)
+}
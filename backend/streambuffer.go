@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// Markdown kept in memory, re-rendered and resent whole per stream.
+	// Beyond this size the stream only forwards deltas, and the rest of the
+	// markdown is spilled to a temporary file for the cache and the report
+	// store.
+	streamBufferCap = int64(envInt("STREAM_BUFFER_CAP_BYTES", 256*1024))
+
+	// Directory of the spilled markdown, the system temporary directory
+	// when empty
+	streamSpillDir = os.Getenv("STREAM_SPILL_DIR")
+
+	// New streams are rejected while the total buffered markdown across all
+	// streams is above this mark
+	streamBufferHighWater = int64(envInt("STREAM_BUFFER_HIGH_WATER_BYTES", 64*1024*1024))
+
+	streamBufferedBytes atomic.Int64
+	streamsInFlight     atomic.Int64
+	streamsRejected     atomic.Int64
+)
+
+// streamBuffer accumulates the markdown of a single stream, accounting for
+// the part held in memory in the global gauge. Past streamBufferCap, the
+// markdown is written to a temporary file instead.
+type streamBuffer struct {
+	builder strings.Builder
+	capped  bool
+	spill   *os.File
+	spilled int
+}
+
+// Write appends text to the buffer. It returns false once the buffer is
+// capped, in which case the text is to be forwarded as a delta only.
+func (b *streamBuffer) Write(text string) (bool, error) {
+	if !b.capped && int64(b.builder.Len()+len(text)) <= streamBufferCap {
+		b.builder.WriteString(text)
+		streamBufferedBytes.Add(int64(len(text)))
+		return true, nil
+	}
+	b.capped = true
+	if b.spill == nil {
+		spill, err := os.CreateTemp(streamSpillDir, "stream-*.md")
+		if err != nil {
+			return false, fmt.Errorf("failed to spill the streamed markdown: %w", err)
+		}
+		b.spill = spill
+	}
+	n, err := b.spill.WriteString(text)
+	b.spilled += n
+	if err != nil {
+		return false, fmt.Errorf("failed to spill the streamed markdown: %w", err)
+	}
+	return false, nil
+}
+
+// Capped reports whether the buffer reached its cap
+func (b *streamBuffer) Capped() bool {
+	return b.capped
+}
+
+// Head returns the markdown held in memory, all of it until the buffer is
+// capped
+func (b *streamBuffer) Head() string {
+	return b.builder.String()
+}
+
+// String returns the whole markdown, reading back what was spilled
+func (b *streamBuffer) String() (string, error) {
+	if b.spill == nil {
+		return b.builder.String(), nil
+	}
+	spilled := make([]byte, b.spilled)
+	if _, err := b.spill.ReadAt(spilled, 0); err != nil {
+		return "", fmt.Errorf("failed to read back the spilled markdown: %w", err)
+	}
+	return b.builder.String() + string(spilled), nil
+}
+
+func (b *streamBuffer) Len() int {
+	return b.builder.Len() + b.spilled
+}
+
+// Release removes the buffer from the global gauge and deletes its spill
+// file. It must be called once the stream is over.
+func (b *streamBuffer) Release() {
+	streamBufferedBytes.Add(-int64(b.builder.Len()))
+	if b.spill != nil {
+		b.spill.Close()
+		os.Remove(b.spill.Name())
+	}
+}
+
+// streamCapacityAvailable reports whether a new stream can be accepted
+func streamCapacityAvailable() bool {
+	return streamBufferedBytes.Load() <= streamBufferHighWater
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestStreamBufferCap makes sure no more than streamBufferCap bytes of a
+// stream are held in memory, while the whole markdown is still read back
+func TestStreamBufferCap(t *testing.T) {
+	previous := streamBufferCap
+	streamBufferCap = 64
+	t.Cleanup(func() { streamBufferCap = previous })
+	baseline := streamBufferedBytes.Load()
+
+	var buffer streamBuffer
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		chunk := strings.Repeat(string(rune('a'+i%26)), 7)
+		want.WriteString(chunk)
+		inMemory, err := buffer.Write(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buffered := streamBufferedBytes.Load() - baseline; buffered > streamBufferCap {
+			t.Fatalf("%d bytes buffered in memory after %d bytes, above the cap of %d", buffered, want.Len(), streamBufferCap)
+		}
+		if inMemory == buffer.Capped() {
+			t.Fatalf("chunk %d reported in memory: %t, capped: %t", i, inMemory, buffer.Capped())
+		}
+	}
+
+	markdown, err := buffer.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if markdown != want.String() || buffer.Len() != want.Len() {
+		t.Errorf("read back %d bytes instead of %d", len(markdown), want.Len())
+	}
+
+	spill := buffer.spill.Name()
+	buffer.Release()
+	if buffered := streamBufferedBytes.Load() - baseline; buffered != 0 {
+		t.Errorf("%d bytes left in the gauge after the release", buffered)
+	}
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Errorf("the spill file was not removed: %v", err)
+	}
+}
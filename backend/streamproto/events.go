@@ -0,0 +1,284 @@
+// Package streamproto defines the Server-Sent Events sent by the streaming
+// analysis endpoint. Any change to an event shape that existing clients
+// cannot ignore must bump Version.
+package streamproto
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Version is the current protocol version
+const Version = 1
+
+// Header carries the protocol version on every streaming response
+const Header = "X-Stream-Protocol"
+
+// QueryParam lets clients pin the protocol version they understand
+const QueryParam = "protocol_version"
+
+// Supported reports whether a pinned version can be served
+func Supported(version string) bool {
+	v, err := strconv.Atoi(version)
+	return err == nil && v == Version
+}
+
+// Event is a typed SSE event
+type Event interface {
+	EventName() string
+}
+
+// ReferenceProfile identifies the means scores are compared against
+type ReferenceProfile struct {
+	Key    string `json:"key"`
+	Label  string `json:"label"`
+	Source string `json:"source"`
+}
+
+// Metadata opens every stream
+type Metadata struct {
+	ProtocolVersion  int              `json:"protocol_version"`
+	ReportID         string           `json:"report_id"`
+	AssessmentHash   string           `json:"assessment_hash"`
+	InputMode        string           `json:"input_mode"`
+	ReferenceProfile ReferenceProfile `json:"reference_profile"`
+	StartedAt        time.Time        `json:"started_at"`
+	// Whether the prompt included participant-provided context
+	AdditionalContextProvided bool `json:"additional_context_provided"`
+	// Domain key of a domain-scoped report, whose AssessmentHash is the
+	// hash of the parent assessment
+	Domain string `json:"domain,omitempty"`
+	// Entry of the analysis changelog the report is generated with
+	AnalysisVersion string `json:"analysis_version,omitempty"`
+	// Base assessment and updated answers of a partial retake
+	Lineage *RetakeLineage `json:"lineage,omitempty"`
+	// Percentile ranks in normative samples, when demographics were given
+	Percentiles *Percentiles `json:"percentiles,omitempty"`
+}
+
+// RetakeLineage links an assessment merged from a partial retake to the
+// assessment it was based on
+type RetakeLineage struct {
+	BaseReportID       string    `json:"base_report_id"`
+	BaseAssessmentHash string    `json:"base_assessment_hash"`
+	BaseTestDate       time.Time `json:"base_test_date"`
+	RetakeDate         time.Time `json:"retake_date"`
+	UpdatedItems       []int     `json:"updated_items"`
+}
+
+// Percentiles are the percentile ranks of the scores in the normative
+// samples matching the demographics of the participant
+type Percentiles struct {
+	Method string             `json:"method"`
+	Age    int                `json:"age,omitempty"`
+	Gender string             `json:"gender,omitempty"`
+	Groups []GroupPercentiles `json:"groups"`
+}
+
+// GroupPercentiles are the percentile ranks of the total and domain scores
+// in the sample of a group
+type GroupPercentiles struct {
+	Group   string         `json:"group"`
+	Sample  string         `json:"sample"`
+	Label   string         `json:"label"`
+	Source  string         `json:"source"`
+	Total   int            `json:"total"`
+	Domains map[string]int `json:"domains"`
+}
+
+// Chunk carries the markdown accumulated so far and its HTML rendering. HTML
+// is null when rendering failed, or when only the delta is sent because the
+// server stopped buffering the markdown.
+type Chunk struct {
+	HTML         *string `json:"html"`
+	Markdown     string  `json:"markdown,omitempty"`
+	Delta        string  `json:"delta,omitempty"`
+	DeltaOnly    bool    `json:"delta_only,omitempty"`
+	RenderError  string  `json:"render_error,omitempty"`
+	RenderErrors int     `json:"render_errors,omitempty"`
+}
+
+// Block is a completed top-level section of the analysis
+type Block struct {
+	Index    int    `json:"index"`
+	Title    string `json:"title"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+// Progress reports how far the generation is
+type Progress struct {
+	OutputTokens int     `json:"output_tokens"`
+	Fraction     float64 `json:"fraction"`
+}
+
+// Usage reports the tokens consumed by the generation
+type Usage struct {
+	Model        string `json:"model"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+}
+
+// Warning reports a non-fatal problem as soon as it happens, as the server
+// warning returned in the complete event
+type Warning struct {
+	Warning ServerWarning `json:"warning"`
+}
+
+// ServerWarning is a problem the server recovered from, such as an answer
+// out of the scale or a section missing from the analysis
+type ServerWarning struct {
+	Code       string `json:"code"`
+	Severity   string `json:"severity"`
+	Display    string `json:"display"`
+	Message    string `json:"message"`
+	QuestionID int    `json:"question_id,omitempty"`
+	Section    string `json:"section,omitempty"`
+}
+
+// Error ends the stream on failure
+type Error struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Complete ends a successful stream
+type Complete struct {
+	CompletedAt         time.Time           `json:"completed_at"`
+	Reading             ReadingStats        `json:"reading"`
+	Warnings            []ServerWarning     `json:"warnings"`
+	QuestionsAndAnswers []QuestionAndAnswer `json:"questionsAndAnswers,omitempty"`
+	// Model the analysis was routed to, and why
+	Model *ModelRoute `json:"model,omitempty"`
+	// Tokens and estimated cost of the generation
+	Usage *TokenUsage `json:"usage,omitempty"`
+	// Version of the prompt the analysis was generated with
+	PromptVersion int `json:"prompt_version,omitempty"`
+}
+
+// ReadingStats summarizes the length of the analysis
+type ReadingStats struct {
+	Words          int                `json:"words"`
+	ReadingMinutes int                `json:"reading_minutes"`
+	Sections       []SectionWordCount `json:"sections"`
+}
+
+// SectionWordCount is the length of a level 2 section of the analysis
+type SectionWordCount struct {
+	Title string `json:"title"`
+	Words int    `json:"words"`
+}
+
+// QuestionAndAnswer is an answered question of the assessment
+type QuestionAndAnswer struct {
+	ID         int     `json:"id"`
+	Text       string  `json:"text"`
+	Category   string  `json:"category"`
+	Reverse    bool    `json:"reverse"`
+	Answer     int     `json:"answer"`
+	AnswerText string  `json:"answerText"`
+	Comment    *string `json:"comment"`
+	Score      int     `json:"score"`
+}
+
+// ModelRoute is the model an analysis was routed to, and why
+type ModelRoute struct {
+	Mode     string `json:"mode"`
+	Model    string `json:"model"`
+	Source   string `json:"source"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// TokenUsage is the tokens and estimated cost of the generations of a report
+type TokenUsage struct {
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost_usd"`
+}
+
+// Ping keeps idle connections open
+type Ping struct {
+	Time time.Time `json:"time"`
+}
+
+// Stalled reports that the upstream model has not sent anything for a while
+type Stalled struct {
+	IdleSeconds int `json:"idle_seconds"`
+}
+
+// Queued reports the position of a request waiting for a worker
+type Queued struct {
+	Position             int `json:"position"`
+	EstimatedWaitSeconds int `json:"estimated_wait_seconds"`
+}
+
+// Busy is sent instead of a stream when the server is saturated
+type Busy struct {
+	Error                string `json:"error"`
+	QueueDepth           int    `json:"queue_depth"`
+	Workers              int    `json:"workers"`
+	EstimatedWaitSeconds int    `json:"estimated_wait_seconds"`
+	RetryAfterSeconds    int    `json:"retry_after_seconds"`
+}
+
+// Cancelled ends a stream cancelled by the client
+type Cancelled struct {
+	CancelledAt time.Time `json:"cancelled_at"`
+	Markdown    string    `json:"markdown"`
+}
+
+func (Metadata) EventName() string  { return "metadata" }
+func (Chunk) EventName() string     { return "chunk" }
+func (Block) EventName() string     { return "block" }
+func (Progress) EventName() string  { return "progress" }
+func (Usage) EventName() string     { return "usage" }
+func (Warning) EventName() string   { return "warning" }
+func (Error) EventName() string     { return "error" }
+func (Complete) EventName() string  { return "complete" }
+func (Ping) EventName() string      { return "ping" }
+func (Stalled) EventName() string   { return "stalled" }
+func (Queued) EventName() string    { return "queued" }
+func (Busy) EventName() string      { return "busy" }
+func (Cancelled) EventName() string { return "cancelled" }
+
+// Decode parses the data of a named event, for Go clients of the stream
+func Decode(name string, data []byte) (Event, error) {
+	var event Event
+	switch name {
+	case "metadata":
+		event = &Metadata{}
+	case "chunk":
+		event = &Chunk{}
+	case "block":
+		event = &Block{}
+	case "progress":
+		event = &Progress{}
+	case "usage":
+		event = &Usage{}
+	case "warning":
+		event = &Warning{}
+	case "error":
+		event = &Error{}
+	case "complete":
+		event = &Complete{}
+	case "ping":
+		event = &Ping{}
+	case "stalled":
+		event = &Stalled{}
+	case "queued":
+		event = &Queued{}
+	case "busy":
+		event = &Busy{}
+	case "cancelled":
+		event = &Cancelled{}
+	default:
+		return nil, fmt.Errorf("unknown stream event %q", name)
+	}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", name, err)
+	}
+	return event, nil
+}
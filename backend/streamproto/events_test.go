@@ -0,0 +1,118 @@
+package streamproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestGoldenEvents makes sure every event is sent as its golden JSON in
+// testdata, with its fields in the same order, and decodes back to the
+// same event
+func TestGoldenEvents(t *testing.T) {
+	at := time.Date(2025, 1, 31, 13, 5, 0, 0, time.UTC)
+	html := "<h2>Executive Summary</h2>"
+	comment := "Only at work"
+	tests := []struct {
+		golden string
+		event  Event
+	}{
+		{"metadata", &Metadata{
+			ProtocolVersion:  Version,
+			ReportID:         "report",
+			AssessmentHash:   "hash",
+			InputMode:        "full",
+			ReferenceProfile: ReferenceProfile{Key: "general", Label: "General population", Source: "Ritvo et al. 2011"},
+			StartedAt:        at,
+			AnalysisVersion:  "2025.01",
+			Lineage: &RetakeLineage{
+				BaseReportID:       "base",
+				BaseAssessmentHash: "base-hash",
+				BaseTestDate:       at.AddDate(0, -1, 0),
+				RetakeDate:         at,
+				UpdatedItems:       []int{3, 14},
+			},
+			Percentiles: &Percentiles{
+				Method: "normal approximation",
+				Age:    34,
+				Groups: []GroupPercentiles{{Group: "autistic", Sample: "ritvo-2011-asd", Label: "Autistic adults", Source: "Ritvo et al. 2011", Total: 42, Domains: map[string]int{"social": 38}}},
+			},
+			AdditionalContextProvided: true,
+		}},
+		// A first assessment sends neither lineage nor percentiles
+		{"metadata_minimal", &Metadata{
+			ProtocolVersion:  Version,
+			ReportID:         "report",
+			AssessmentHash:   "hash",
+			InputMode:        "minimal",
+			ReferenceProfile: ReferenceProfile{Key: "general", Label: "General population", Source: "Ritvo et al. 2011"},
+			StartedAt:        at,
+			Domain:           "social",
+		}},
+		{"chunk", &Chunk{HTML: &html, Markdown: "## Executive Summary"}},
+		{"chunk_delta", &Chunk{Delta: "\n\nText", DeltaOnly: true, RenderError: "unbalanced table", RenderErrors: 2}},
+		{"block", &Block{Index: 1, Title: "Executive Summary", Markdown: "## Executive Summary\n\nText", HTML: html}},
+		{"progress", &Progress{OutputTokens: 512, Fraction: 0.25}},
+		{"usage", &Usage{Model: "claude-sonnet-4-20250514", InputTokens: 2514, OutputTokens: 15}},
+		{"warning", &Warning{Warning: ServerWarning{Code: "answer_out_of_scale", Severity: "warning", Display: "banner", Message: "Answer 9 is out of the scale", QuestionID: 12}}},
+		{"error", &Error{Error: "AI service unavailable, please retry shortly", Code: "ai_unavailable"}},
+		{"complete", &Complete{
+			CompletedAt: at,
+			Reading:     ReadingStats{Words: 1200, ReadingMinutes: 6, Sections: []SectionWordCount{{Title: "Executive Summary", Words: 200}}},
+			Warnings:    []ServerWarning{{Code: "section_missing", Severity: "info", Display: "footnote", Message: "The analysis has no conclusion", Section: "Conclusion"}},
+			QuestionsAndAnswers: []QuestionAndAnswer{
+				{ID: 1, Text: "I am a sympathetic person.", Category: "Social relatedness", Reverse: true, Answer: 3, AnswerText: "True only now", Comment: &comment, Score: 0},
+				{ID: 2, Text: "I often use words and phrases from movies.", Category: "Language", Answer: 1, AnswerText: "True only when I was younger than 16", Score: 1},
+			},
+			Model:         &ModelRoute{Mode: "full", Model: "claude-sonnet-4-20250514", Source: "default", Provider: "anthropic"},
+			Usage:         &TokenUsage{Requests: 1, InputTokens: 2514, OutputTokens: 15, Cost: 0.0078},
+			PromptVersion: 3,
+		}},
+		// Warnings are always sent, even when there are none
+		{"complete_minimal", &Complete{CompletedAt: at, Warnings: []ServerWarning{}}},
+		{"ping", &Ping{Time: at}},
+		{"stalled", &Stalled{IdleSeconds: 30}},
+		{"queued", &Queued{Position: 2, EstimatedWaitSeconds: 40}},
+		{"busy", &Busy{Error: "Server busy, please retry", QueueDepth: 8, Workers: 4, EstimatedWaitSeconds: 60, RetryAfterSeconds: 30}},
+		{"cancelled", &Cancelled{CancelledAt: at, Markdown: "## Executive"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.golden, func(t *testing.T) {
+			golden, err := os.ReadFile("testdata/" + tc.golden + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, golden); err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(tc.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, compact.Bytes()) {
+				t.Errorf("sent\n%s\ninstead of\n%s", data, compact.Bytes())
+			}
+
+			decoded, err := Decode(tc.event.EventName(), golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, tc.event) {
+				t.Errorf("decoded %+v instead of %+v", decoded, tc.event)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode("unknown", []byte(`{}`)); err == nil {
+		t.Error("an unknown event was decoded")
+	}
+	if _, err := Decode("complete", []byte(`{"warnings":"none"}`)); err == nil {
+		t.Error("a complete event with invalid warnings was decoded")
+	}
+}
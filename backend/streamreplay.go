@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raads-pdf-backend/streamproto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a finished stream can still be resumed
+var streamReplayTTL = time.Duration(envInt("STREAM_REPLAY_TTL_SECONDS", 300)) * time.Second
+
+// How long a generation goes on with no client following its stream before
+// it is cancelled, leaving the client time to resume it
+var streamAbandonGrace = time.Duration(envInt("STREAM_ABANDON_GRACE_SECONDS", 60)) * time.Second
+
+var streamsResumed atomic.Int64
+
+// replayEvent is an event emitted on a stream, as sent to the client
+type replayEvent struct {
+	ID   int
+	Name string
+	Data json.RawMessage
+
+	// Whether the event is a chunk carrying the whole markdown so far,
+	// which makes the previous ones useless to a resuming client
+	fullChunk bool
+}
+
+// streamReplay keeps the events emitted on a stream so that a client whose
+// connection dropped can resume it with Last-Event-ID. Only the client the
+// stream was started for can resume it. Its events are accounted for in the
+// buffered bytes gauge.
+type streamReplay struct {
+	mu         sync.Mutex
+	reportID   string
+	owner      string
+	events     []replayEvent
+	next       int
+	bytes      int64
+	done       bool
+	finishedAt time.Time
+	updated    chan struct{}
+
+	// Clients following the stream, and when the last one left
+	readers    int
+	detachedAt time.Time
+}
+
+type streamReplayKey struct{}
+
+// streamReplayFrom returns the replay of the stream of a request, or nil
+func streamReplayFrom(ctx context.Context) *streamReplay {
+	replay, _ := ctx.Value(streamReplayKey{}).(*streamReplay)
+	return replay
+}
+
+// eventID is the Last-Event-ID of an event of a stream
+func (r *streamReplay) eventID(seq int) string {
+	return r.reportID + ":" + strconv.Itoa(seq)
+}
+
+// Record keeps an event and returns its ID. Chunks carrying the whole
+// markdown replace the previous ones. It returns an empty ID on a nil
+// replay.
+func (r *streamReplay) Record(event streamproto.Event) string {
+	if r == nil {
+		return ""
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return ""
+	}
+	chunk, isChunk := event.(streamproto.Chunk)
+	recorded := replayEvent{Name: event.EventName(), Data: data, fullChunk: isChunk && !chunk.DeltaOnly && chunk.Markdown != ""}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if recorded.fullChunk {
+		kept := r.events[:0]
+		for _, e := range r.events {
+			if e.fullChunk {
+				r.release(int64(len(e.Data)))
+				continue
+			}
+			kept = append(kept, e)
+		}
+		r.events = kept
+	}
+	r.next++
+	recorded.ID = r.next
+	r.events = append(r.events, recorded)
+	r.bytes += int64(len(data))
+	streamBufferedBytes.Add(int64(len(data)))
+	r.notifyLocked()
+	return r.eventID(recorded.ID)
+}
+
+// Finish marks the stream as ended, so that resuming clients stop waiting
+// for more events
+func (r *streamReplay) Finish() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = true
+	r.finishedAt = time.Now()
+	r.notifyLocked()
+}
+
+// After returns the events following an event ID, whether the stream has
+// ended, and a channel closed on the next change
+func (r *streamReplay) After(seq int) ([]replayEvent, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []replayEvent
+	for _, e := range r.events {
+		if e.ID > seq {
+			events = append(events, e)
+		}
+	}
+	return events, r.done, r.updated
+}
+
+// Attach counts a client following the stream
+func (r *streamReplay) Attach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readers++
+}
+
+// Detach counts a client leaving the stream
+func (r *streamReplay) Detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readers--
+	if r.readers == 0 {
+		r.detachedAt = time.Now()
+	}
+}
+
+// generationContext is the parent context of the generation of a stream,
+// which goes on when the client leaves. The generations of anonymous
+// clients end with their request instead, as nobody can resume their
+// stream.
+func generationContext(c *gin.Context) context.Context {
+	if ownerFrom(c.Request.Context()) == "" {
+		return c.Request.Context()
+	}
+	return context.WithoutCancel(c.Request.Context())
+}
+
+// cancelWhenAbandoned waits for the client that started the stream to
+// leave, then cancels the generation once no client has followed the
+// stream for the grace period. It is cancelled as its owner would, the
+// generations of anonymous clients ending with their request.
+func (r *streamReplay) cancelWhenAbandoned(ctx context.Context, grace time.Duration) {
+	if r.owner == "" {
+		return
+	}
+	<-ctx.Done()
+	r.Detach()
+	wait := grace
+	for {
+		timer := time.NewTimer(wait)
+		r.mu.Lock()
+		updated := r.updated
+		r.mu.Unlock()
+		select {
+		case <-timer.C:
+		case <-updated:
+			timer.Stop()
+		}
+
+		r.mu.Lock()
+		done, readers, idle := r.done, r.readers, time.Since(r.detachedAt)
+		r.mu.Unlock()
+		switch {
+		case done:
+			return
+		case readers == 0 && idle >= grace:
+			if _, ok := generations.Cancel(r.reportID, r.owner); ok {
+				log.Printf("🛑 No client followed stream %s for %s, cancelling its generation", r.reportID, grace)
+				return
+			}
+			// The generation has not started yet
+			wait = grace
+		case readers == 0:
+			wait = grace - idle
+		default:
+			wait = grace
+		}
+	}
+}
+
+func (r *streamReplay) notifyLocked() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+func (r *streamReplay) release(bytes int64) {
+	r.bytes -= bytes
+	streamBufferedBytes.Add(-bytes)
+}
+
+// streamReplayStore keeps the replays of running streams, and of finished
+// ones for streamReplayTTL
+type streamReplayStore struct {
+	mu      sync.Mutex
+	replays map[string]*streamReplay
+}
+
+var streamReplays = &streamReplayStore{replays: make(map[string]*streamReplay)}
+
+// Start attaches a replay to the stream of a report, followed by the
+// client of the request until it leaves
+func (s *streamReplayStore) Start(c *gin.Context, reportID string) *streamReplay {
+	replay := &streamReplay{reportID: reportID, owner: ownerFrom(c.Request.Context()), updated: make(chan struct{}), readers: 1}
+	s.mu.Lock()
+	s.pruneLocked(time.Now())
+	s.replays[reportID] = replay
+	s.mu.Unlock()
+	go replay.cancelWhenAbandoned(c.Request.Context(), streamAbandonGrace)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), streamReplayKey{}, replay))
+	return replay
+}
+
+// Resume returns the replay a Last-Event-ID belongs to, and the sequence
+// number of the last event the client received. Streams of anonymous
+// clients, and of other clients than the owner, cannot be resumed.
+func (s *streamReplayStore) Resume(lastEventID, owner string) (*streamReplay, int, bool) {
+	reportID, seqText, ok := strings.Cut(lastEventID, ":")
+	if !ok {
+		return nil, 0, false
+	}
+	seq, err := strconv.Atoi(seqText)
+	if err != nil || seq < 0 {
+		return nil, 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	replay, ok := s.replays[reportID]
+	if !ok || replay.owner == "" || replay.owner != owner {
+		return nil, 0, false
+	}
+	return replay, seq, true
+}
+
+// pruneLocked drops the replays of streams finished for longer than
+// streamReplayTTL
+func (s *streamReplayStore) pruneLocked(now time.Time) {
+	for id, replay := range s.replays {
+		replay.mu.Lock()
+		expired := replay.done && now.Sub(replay.finishedAt) > streamReplayTTL
+		if expired {
+			replay.release(replay.bytes)
+			replay.events = nil
+		}
+		replay.mu.Unlock()
+		if expired {
+			delete(s.replays, id)
+		}
+	}
+}
+
+// resumeTarget returns the stream a reconnecting client asks to resume
+// with Last-Event-ID, if it is still known and was started for the client
+func resumeTarget(c *gin.Context) (*streamReplay, int, bool) {
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		return nil, 0, false
+	}
+	replay, seq, ok := streamReplays.Resume(lastEventID, ownerFrom(c.Request.Context()))
+	if !ok {
+		log.Printf("⚠️  Cannot resume stream from event %q, generating the analysis again", lastEventID)
+	}
+	return replay, seq, ok
+}
+
+// resumeStream sends the events a client missed, then follows the stream
+// until it ends or the client leaves again
+func resumeStream(c *gin.Context, replay *streamReplay, seq int) {
+	streamsResumed.Add(1)
+	log.Printf("🔁 Resuming stream %s after event %d", replay.reportID, seq)
+	replay.Attach()
+	defer replay.Detach()
+	setStreamHeaders(c)
+	for {
+		events, done, updated := replay.After(seq)
+		for _, event := range events {
+			fmt.Fprintf(c.Writer, "id: %s\n", replay.eventID(event.ID))
+			c.SSEvent(event.Name, event.Data)
+			seq = event.ID
+		}
+		c.Writer.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-updated:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
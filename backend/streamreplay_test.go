@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raads-pdf-backend/streamproto"
+)
+
+func TestStreamReplay(t *testing.T) {
+	replay := &streamReplay{reportID: "test", updated: make(chan struct{})}
+	t.Cleanup(func() { replay.release(replay.bytes) })
+
+	replay.Record(streamproto.Metadata{ReportID: "test"})
+	replay.Record(streamproto.Chunk{Markdown: "## Executive"})
+	replay.Record(streamproto.Chunk{Markdown: "## Executive Summary"})
+	replay.Record(streamproto.Chunk{Delta: "\n\nText", DeltaOnly: true})
+	last := replay.Record(streamproto.Complete{})
+	replay.Finish()
+	if last != "test:5" {
+		t.Fatalf("unexpected event ID %q", last)
+	}
+
+	// A chunk carrying the accumulated markdown supersedes the earlier ones
+	tests := []struct {
+		after int
+		want  string
+	}{
+		{0, "metadata#1 chunk#3 chunk#4 complete#5"},
+		{1, "chunk#3 chunk#4 complete#5"},
+		{5, ""},
+	}
+	for _, tc := range tests {
+		events, done, _ := replay.After(tc.after)
+		var names []string
+		for _, e := range events {
+			names = append(names, fmt.Sprintf("%s#%d", e.Name, e.ID))
+		}
+		if !done || strings.Join(names, " ") != tc.want {
+			t.Errorf("resuming after event %d replays %q instead of %q", tc.after, names, tc.want)
+		}
+	}
+}
+
+// TestResumeStreamOwner makes sure only the client a stream was started for
+// can resume it, the others getting an analysis of their own
+func TestResumeStreamOwner(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.ChunkDelay = 0
+	body := answeredAssessment(t, "TestResumeStreamOwner")
+
+	var reportID string
+	for _, event := range readEvents(t, serve(t, "POST", "/analyze-stream", body, map[string]string{"X-Client-Token": "owner"}).Body) {
+		if event.Name == "metadata" {
+			reportID, _, _ = strings.Cut(event.ID, ":")
+		}
+	}
+	if reportID == "" {
+		t.Fatal("the stream has no metadata event with an ID")
+	}
+
+	tests := []struct {
+		token   string
+		resumed bool
+	}{
+		{"owner", true},
+		{"other", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		w := serve(t, "POST", "/analyze-stream", body, map[string]string{"X-Client-Token": tc.token, "Last-Event-ID": reportID + ":1"})
+		events := readEvents(t, w.Body)
+		if len(events) == 0 {
+			t.Fatalf("no events for client %q", tc.token)
+		}
+		resumed := events[0].Name != "metadata" && strings.HasPrefix(events[0].ID, reportID+":")
+		if resumed != tc.resumed {
+			t.Errorf("client %q resumed the stream: %v, first event %+v", tc.token, resumed, events[0])
+		}
+	}
+}
+
+// TestAbandonedStream makes sure a generation goes on while its client is
+// away, and is cancelled once nobody resumed its stream for the grace period
+func TestAbandonedStream(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.Latency = time.Second
+	previous := streamAbandonGrace
+	streamAbandonGrace = 100 * time.Millisecond
+	t.Cleanup(func() { streamAbandonGrace = previous })
+
+	ctx, leave := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/analyze-stream", bytes.NewReader(answeredAssessment(t, "TestAbandonedStream")))
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Client-Token", "owner")
+	time.AfterFunc(50*time.Millisecond, leave)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	newRouter().ServeHTTP(w, req)
+
+	events := readEvents(t, w.Body)
+	if len(events) == 0 || events[len(events)-1].Name != "cancelled" {
+		t.Fatalf("the abandoned generation was not cancelled: %+v", events)
+	}
+	reportID, _, _ := strings.Cut(events[0].ID, ":")
+	generations.mu.Lock()
+	status := generations.finished[reportID].status
+	generations.mu.Unlock()
+	if status != generationCancelled {
+		t.Errorf("the abandoned generation ended as %q", status)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("the generation was cancelled %s after the client left, before the grace period", elapsed-50*time.Millisecond)
+	}
+}
+
+// TestResumedStreamNotCancelled makes sure a generation goes on to complete
+// when its client comes back within the grace period
+func TestResumedStreamNotCancelled(t *testing.T) {
+	fake := startFakeClaude(t)
+	fake.Latency = 500 * time.Millisecond
+	fake.ChunkDelay = 0
+	previous := streamAbandonGrace
+	streamAbandonGrace = 200 * time.Millisecond
+	t.Cleanup(func() { streamAbandonGrace = previous })
+
+	body := answeredAssessment(t, "TestResumedStreamNotCancelled")
+	ctx, leave := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/analyze-stream", bytes.NewReader(body))
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Client-Token", "owner")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newRouter().ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	reportID := runningGeneration(t, reportOwner("owner"))
+	leave()
+	time.Sleep(streamAbandonGrace / 2)
+
+	w := serve(t, "POST", "/analyze-stream", body, map[string]string{"X-Client-Token": "owner", "Last-Event-ID": reportID + ":1"})
+	<-done
+	events := readEvents(t, w.Body)
+	if len(events) == 0 || events[len(events)-1].Name != "complete" {
+		t.Fatalf("the resumed stream did not complete: %+v", events)
+	}
+	generations.mu.Lock()
+	status := generations.finished[reportID].status
+	generations.mu.Unlock()
+	if status != generationCompleted {
+		t.Errorf("the resumed generation ended as %q", status)
+	}
+}
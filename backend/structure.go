@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Lines of a prompt delimiting the markdown outline the answer must follow
+const (
+	structureStart = "REQUIRED MARKDOWN STRUCTURE:"
+	structureEnd   = "IMPORTANT:"
+)
+
+// Delimiter row of a markdown table, such as "|---|:---:|"
+var tableDelimiterRow = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)+\|?\s*$`)
+
+var (
+	structureRetries  atomic.Int64
+	structureFailures atomic.Int64
+)
+
+// outlineHeading is a section header of a markdown outline
+type outlineHeading struct {
+	Level int
+	Text  string
+}
+
+// requiredOutline returns the section headers a prompt requires, in order,
+// or nil when the prompt does not require a structure
+func requiredOutline(prompt string) []outlineHeading {
+	_, rest, ok := strings.Cut(prompt, structureStart)
+	if !ok {
+		return nil
+	}
+	rest, _, _ = strings.Cut(rest, structureEnd)
+
+	var outline []outlineHeading
+	for _, line := range strings.Split(rest, "\n") {
+		line = strings.TrimSpace(line)
+		marks := len(line) - len(strings.TrimLeft(line, "#"))
+		if marks == 0 || marks > 6 {
+			continue
+		}
+		outline = append(outline, outlineHeading{Level: marks, Text: strings.TrimSpace(line[marks:])})
+	}
+	return outline
+}
+
+// structureError is a generation that did not follow the required outline,
+// even once corrected
+type structureError struct {
+	Violations []string
+}
+
+func (e *structureError) Error() string {
+	return "the generated analysis does not follow the required structure: " + strings.Join(e.Violations, "; ")
+}
+
+// structureViolations compares generated markdown to the outline of its
+// prompt. Extra titles and tables are always reported. Section headers
+// are compared by name in English, and only counted per level in the other
+// languages since the model translates them. Headers deeper than the
+// outline are free.
+func structureViolations(markdown string, outline []outlineHeading, language string) []string {
+	if len(outline) == 0 {
+		return nil
+	}
+	source := []byte(markdown)
+	doc, err := parseMarkdown(source)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var violations []string
+	var headings []outlineHeading
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		heading, ok := node.(*ast.Heading)
+		if !ok {
+			if len(headings) == 0 && len(violations) == 0 {
+				violations = append(violations, "text before the first section")
+			}
+			continue
+		}
+		headings = append(headings, outlineHeading{Level: heading.Level, Text: strings.TrimSpace(string(nodeText(heading, source)))})
+	}
+
+	deepest := 0
+	for _, h := range outline {
+		deepest = max(deepest, h.Level)
+	}
+	var generated []outlineHeading
+	for _, h := range headings {
+		if h.Level == 1 && outline[0].Level > 1 {
+			violations = append(violations, fmt.Sprintf("extra title %q", h.Text))
+			continue
+		}
+		if h.Level <= deepest {
+			generated = append(generated, h)
+		}
+	}
+
+	if language == "" || language == "en" {
+		violations = append(violations, outlineNameViolations(generated, outline)...)
+	} else {
+		violations = append(violations, outlineCountViolations(generated, outline)...)
+	}
+
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode && tableDelimiterRow.MatchString(line) {
+			violations = append(violations, "a table")
+			break
+		}
+	}
+	return violations
+}
+
+// outlineNameViolations lists the missing and extra section headers of a
+// generated outline
+func outlineNameViolations(generated, outline []outlineHeading) []string {
+	key := func(h outlineHeading) string {
+		return strings.Repeat("#", h.Level) + " " + strings.ToLower(strings.Join(strings.Fields(h.Text), " "))
+	}
+	expected := make(map[string]bool, len(outline))
+	for _, h := range outline {
+		expected[key(h)] = true
+	}
+	found := make(map[string]bool, len(generated))
+	var violations []string
+	for _, h := range generated {
+		found[key(h)] = true
+		if !expected[key(h)] {
+			violations = append(violations, fmt.Sprintf("extra section %q", strings.Repeat("#", h.Level)+" "+h.Text))
+		}
+	}
+	for _, h := range outline {
+		if !found[key(h)] {
+			violations = append(violations, fmt.Sprintf("missing section %q", strings.Repeat("#", h.Level)+" "+h.Text))
+		}
+	}
+	return violations
+}
+
+// outlineCountViolations compares the number of section headers per level
+// of a generated outline
+func outlineCountViolations(generated, outline []outlineHeading) []string {
+	var want, got [7]int
+	for _, h := range outline {
+		want[h.Level]++
+	}
+	for _, h := range generated {
+		got[h.Level]++
+	}
+	var violations []string
+	for level := 1; level < len(want); level++ {
+		if got[level] != want[level] {
+			violations = append(violations, fmt.Sprintf("%d level %d sections instead of %d", got[level], level, want[level]))
+		}
+	}
+	return violations
+}
+
+// correctionPrompt is a prompt followed by the structure violations of the
+// previous answer to it
+func correctionPrompt(prompt string, violations []string) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\nYOUR PREVIOUS ANSWER DID NOT FOLLOW THE REQUIRED MARKDOWN STRUCTURE:\n")
+	for _, v := range violations {
+		b.WriteString("- " + v + "\n")
+	}
+	b.WriteString("Answer again, using EXACTLY the required markdown structure, translated section headers included.\n")
+	return b.String()
+}
+
+// completeStructuredMarkdown is completeClaudeMarkdown for prompts with a
+// required structure. A generation that does not follow it is requested
+// once more with its violations, and fails if it still does not.
+// Generations by the template are not checked, as it has its own outline.
+func completeStructuredMarkdown(ctx context.Context, input assessmentInput, prompt, model string, maxTokens int) (string, error) {
+	markdown, err := completeClaudeMarkdown(ctx, input, prompt, model, maxTokens)
+	if err != nil || usedTemplateFallback(ctx) {
+		return markdown, err
+	}
+	outline := requiredOutline(prompt)
+	violations := structureViolations(markdown, outline, input.Assessment.Language)
+	if len(violations) == 0 {
+		return markdown, nil
+	}
+
+	structureRetries.Add(1)
+	log.Printf("⚠️  Generated markdown does not follow the required structure, retrying: %s", strings.Join(violations, "; "))
+	markdown, err = completeClaudeMarkdown(ctx, input, correctionPrompt(prompt, violations), model, maxTokens)
+	if err != nil || usedTemplateFallback(ctx) {
+		return markdown, err
+	}
+	if violations := structureViolations(markdown, outline, input.Assessment.Language); len(violations) > 0 {
+		structureFailures.Add(1)
+		return "", &structureError{Violations: violations}
+	}
+	return markdown, nil
+}
+
+// warnStructureViolations flags a streamed generation that does not follow
+// the required structure. Streamed text is already with the client, so it
+// cannot be requested again.
+func warnStructureViolations(ctx context.Context, input assessmentInput, prompt, markdown string) {
+	if usedTemplateFallback(ctx) {
+		return
+	}
+	violations := structureViolations(markdown, requiredOutline(prompt), input.Assessment.Language)
+	if len(violations) == 0 {
+		return
+	}
+	structureFailures.Add(1)
+	warningsFrom(ctx).Add(Warning{
+		Code:    warnStructureInvalid,
+		Message: "The analysis does not follow the expected structure: " + strings.Join(violations, "; "),
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStructureViolations(t *testing.T) {
+	data := AssessmentData{
+		Language: "en",
+		Metadata: Metadata{TestName: raadsR.Name, AnsweredQuestions: 1, TotalQuestions: 1},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "Sample", Answer: 2, AnswerText: "Never true"},
+		},
+	}
+	prompt, err := analysisPrompt(data, assessmentInput{Inline: "{}"}, promptVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outline := requiredOutline(prompt)
+	if len(outline) < 2 {
+		t.Fatalf("the analysis prompt requires %d sections", len(outline))
+	}
+
+	var valid strings.Builder
+	for _, h := range outline {
+		fmt.Fprintf(&valid, "%s %s\n\nQ1 was answered.\n\n", strings.Repeat("#", h.Level), h.Text)
+	}
+	invalid := "# Report\n\n" + strings.Replace(valid.String(), outline[1].Text, "Overview", 1) + "| Domain | Score |\n|---|---|\n| Social | 1 |\n"
+
+	tests := []struct {
+		name       string
+		markdown   string
+		language   string
+		violations int
+	}{
+		{"required outline", valid.String(), "en", 0},
+		// A title, a missing and an extra section and a table
+		{"altered outline", invalid, "en", 4},
+		// Headings are not compared in other languages
+		{"altered outline in another language", invalid, "fr", 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if violations := structureViolations(tc.markdown, outline, tc.language); len(violations) != tc.violations {
+				t.Errorf("%d violations instead of %d: %q", len(violations), tc.violations, violations)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnswerOption is one point of the answer scale of an instrument
+type AnswerOption struct {
+	Value int    `json:"value"`
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// TestDefinition describes an instrument the backend knows how to analyze
+type TestDefinition struct {
+	Name        string                    `json:"name"`
+	ScaleLabels map[string][]AnswerOption `json:"-"`
+}
+
+// raadsR is the canonical RAADS-R definition. Answer labels mirror the
+// "options" entry of the frontend language catalogs (en.json, fr.json, ...).
+var raadsR = TestDefinition{
+	Name: "RAADS-R",
+	ScaleLabels: map[string][]AnswerOption{
+		"en": {
+			{0, "A", "True now and when I was young (16 years or younger)"},
+			{1, "B", "True only now"},
+			{2, "C", "True only when I was younger than 16"},
+			{3, "D", "Never true"},
+		},
+		"fr": {
+			{0, "A", "Vrai maintenant et quand j'étais jeune (16 ans ou avant)"},
+			{1, "B", "Vrai seulement maintenant"},
+			{2, "C", "Vrai seulement quand j'avais moins de 16 ans"},
+			{3, "D", "Jamais vrai"},
+		},
+		"es": {
+			{0, "A", "Verdadero ahora y cuando era joven (16 años o menor)"},
+			{1, "B", "Verdadero solo ahora"},
+			{2, "C", "Verdadero solo cuando era menor de 16 años"},
+			{3, "D", "Nunca verdadero"},
+		},
+		"it": {
+			{0, "A", "Vero ora e quando ero giovane (16 anni o meno)"},
+			{1, "B", "Vero solo ora"},
+			{2, "C", "Vero solo quando avevo meno di 16 anni"},
+			{3, "D", "Mai vero"},
+		},
+		"de": {
+			{0, "A", "Trifft jetzt und in meiner Jugend zu (16 Jahre oder jünger)"},
+			{1, "B", "Trifft nur jetzt zu"},
+			{2, "C", "Traf nur zu, als ich jünger als 16 war"},
+			{3, "D", "Nie zutreffend"},
+		},
+		"ru": {
+			{0, "A", "Верно сейчас и когда я был молодым (16 лет или младше)"},
+			{1, "B", "Верно только сейчас"},
+			{2, "C", "Верно только когда я был младше 16 лет"},
+			{3, "D", "Никогда не было верным"},
+		},
+	},
+}
+
+var (
+	// When enabled, a submitted answer text that does not match its answer
+	// value is rejected instead of being replaced by the canonical label
+	strictAnswerText = os.Getenv("STRICT_ANSWER_TEXT") == "true"
+
+	parentheticalPattern = regexp.MustCompile(`\([^)]*\)`)
+)
+
+// AnswerScale returns the localized answer scale, falling back to English
+func (t TestDefinition) AnswerScale(language string) []AnswerOption {
+	if scale, ok := t.ScaleLabels[language]; ok {
+		return scale
+	}
+	return t.ScaleLabels["en"]
+}
+
+// CanonicalLabel returns the localized label for an answer value
+func (t TestDefinition) CanonicalLabel(language string, value int) (string, bool) {
+	for _, option := range t.AnswerScale(language) {
+		if option.Value == value {
+			return option.Label, true
+		}
+	}
+	return "", false
+}
+
+// normalizeAnswerText reduces a label to a comparable form, ignoring case,
+// spacing, punctuation and parenthetical precisions such as "(16 years or younger)"
+func normalizeAnswerText(text string) string {
+	text = parentheticalPattern.ReplaceAllString(strings.ToLower(text), " ")
+	text = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".,;:!?'’\"", r) {
+			return ' '
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// validateAnswerScale checks every answer value against the scale and makes
+// sure its text matches the canonical label, replacing it when it does not
+func validateAnswerScale(ctx context.Context, data AssessmentData) error {
+	for i, qa := range data.QuestionsAndAnswers {
+		label, ok := instrumentOf(data).Test.CanonicalLabel(data.Language, qa.Answer)
+		if !ok {
+			return fmt.Errorf("invalid answer %d for question %d", qa.Answer, qa.ID)
+		}
+
+		// Unanswered questions are sent without an answer text, and the
+		// first value of the scale
+		if qa.AnswerText == "" {
+			continue
+		}
+		if normalizeAnswerText(qa.AnswerText) == normalizeAnswerText(label) {
+			continue
+		}
+
+		if strictAnswerText {
+			return fmt.Errorf("answer text %q does not match answer %d for question %d", qa.AnswerText, qa.Answer, qa.ID)
+		}
+
+		warningsFrom(ctx).Add(Warning{
+			Code:       warnAnswerTextReplaced,
+			Message:    fmt.Sprintf("answer text %q for question %d replaced with %q", qa.AnswerText, qa.ID, label),
+			QuestionID: qa.ID,
+		})
+		data.QuestionsAndAnswers[i].AnswerText = label
+	}
+
+	return nil
+}
+
+// questionsHandler exposes the test definition so the frontend does not
+// have to hardcode it
+func questionsHandler(c *gin.Context) {
+	language := c.DefaultQuery("lang", "en")
+	if _, ok := supportedLanguages[language]; !ok {
+		c.JSON(400, gin.H{"error": "Unsupported language: " + language})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"test":         raadsR.Name,
+		"language":     language,
+		"answer_scale": raadsR.AnswerScale(language),
+	})
+}
+
+// BankQuestion is a question of the question bank, with the domain its
+// score counts towards, or the subscale for other instruments
+type BankQuestion struct {
+	CatalogQuestion
+	Domain string `json:"domain"`
+}
+
+// questionBankHandler serves the canonical questions of a language, so
+// that clients draw them from the same catalogs the answers are checked
+// against. ?instrument=aq-50 serves those of the AQ-50.
+func questionBankHandler(c *gin.Context) {
+	language := c.Param("lang")
+	if _, ok := supportedLanguages[language]; !ok {
+		c.JSON(404, gin.H{"error": "Unsupported language: " + language})
+		return
+	}
+	data := AssessmentData{Instrument: c.Query("instrument")}
+	if err := validateInstrument(data); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	instrument := instrumentOf(data)
+	if _, ok := instrument.Test.ScaleLabels[language]; !ok {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("The %s is not available in %s", instrument.Test.Name, language)})
+		return
+	}
+	catalog, err := instrument.Catalog(language)
+	if err != nil {
+		log.Printf("❌ Error loading question catalog: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to load questions: " + err.Error()})
+		return
+	}
+
+	questions := make([]BankQuestion, 0, len(catalog.Questions))
+	for _, q := range catalog.Questions {
+		question := BankQuestion{CatalogQuestion: q}
+		if instrument.Key == instrumentRAADSR {
+			d, _ := domainForCategory(q.Category)
+			question.Domain = d.Key
+		}
+		for _, s := range instrument.Subscales {
+			if s.Category == q.Category {
+				question.Domain = s.Key
+			}
+		}
+		questions = append(questions, question)
+	}
+	languages := make([]string, 0, len(instrument.Test.ScaleLabels))
+	for code := range instrument.Test.ScaleLabels {
+		languages = append(languages, code)
+	}
+	sort.Strings(languages)
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(200, gin.H{
+		"test":            instrument.Test.Name,
+		"instrument":      instrument.Key,
+		"language":        language,
+		"languages":       languages,
+		"answer_scale":    instrument.Test.AnswerScale(language),
+		"questions":       questions,
+		"interpretations": catalog.Interpretations,
+		// Total score ranges of the interpretations, bounds included
+		"interpretation_rules": localizedInterpretationRules(catalog, instrument.Rules),
+	})
+}
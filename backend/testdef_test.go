@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestValidateAnswerScale makes sure answers are checked against the scale
+// whether or not they come with an answer text
+func TestValidateAnswerScale(t *testing.T) {
+	label, _ := raadsR.CanonicalLabel("en", 1)
+	tests := []struct {
+		name     string
+		qa       QuestionAndAnswer
+		rejected bool
+	}{
+		{"answered", QuestionAndAnswer{ID: 1, Answer: 1, AnswerText: label}, false},
+		{"unanswered", QuestionAndAnswer{ID: 1, Answer: 0, AnswerText: ""}, false},
+		{"out of the scale", QuestionAndAnswer{ID: 1, Answer: 9, AnswerText: label}, true},
+		{"out of the scale without an answer text", QuestionAndAnswer{ID: 1, Answer: 9, AnswerText: ""}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := AssessmentData{Language: "en", QuestionsAndAnswers: []QuestionAndAnswer{tc.qa}}
+			err := validateAnswerScale(context.Background(), data)
+			if rejected := err != nil; rejected != tc.rejected {
+				t.Errorf("rejected: %t, want %t (%v)", rejected, tc.rejected, err)
+			}
+		})
+	}
+}
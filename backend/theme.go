@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReportTheme is a look of the LaTeX and HTML reports, selected with the
+// theme field of export requests. Colors a theme sets replace those of the
+// brand, as legibility or ink usage depends on them; the others are kept.
+type ReportTheme struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+
+	Colors BrandColors `json:"-"`
+	// Base font size of the LaTeX report, and line spacing
+	FontSize   string `json:"-"`
+	LineSpread string `json:"-"`
+	// Colored backgrounds behind the score summary, and the rules and
+	// lines of the title page and section titles
+	FilledBoxes bool `json:"-"`
+	Decorations bool `json:"-"`
+	// Rules added to the stylesheet of the HTML report
+	CSS template.CSS `json:"-"`
+}
+
+const (
+	themeClinical   = "clinical"
+	themeMinimal    = "minimal"
+	themeAccessible = "accessible"
+	themeInkSaver   = "ink-saver"
+)
+
+// REPORT_THEME is the theme of reports that do not select one
+var defaultReportTheme = envString("REPORT_THEME", themeClinical)
+
+// reportThemeKeys lists the themes in the order they are offered
+var reportThemeKeys = []string{themeClinical, themeMinimal, themeAccessible, themeInkSaver}
+
+var reportThemes = map[string]ReportTheme{
+	themeClinical: {
+		Key:         themeClinical,
+		Label:       "Clinical",
+		FontSize:    "11pt",
+		LineSpread:  "1.0",
+		FilledBoxes: true,
+		Decorations: true,
+	},
+	themeMinimal: {
+		Key:        themeMinimal,
+		Label:      "Minimal",
+		FontSize:   "11pt",
+		LineSpread: "1.0",
+		CSS:        `.brand { border-bottom: none; } h1, h2, h3 { font-weight: 600; }`,
+	},
+	themeAccessible: {
+		Key:   themeAccessible,
+		Label: "Accessible high contrast",
+		Colors: BrandColors{
+			Primary:   "#00366d",
+			Secondary: "#000000",
+			Accent:    "#a30000",
+			Success:   "#005a20",
+			Warning:   "#7a4a00",
+			Light:     "#ffffff",
+		},
+		FontSize:    "12pt",
+		LineSpread:  "1.3",
+		Decorations: true,
+		CSS:         `body { font-size: 1.15rem; line-height: 1.7; color: #000; } a { text-decoration: underline; } th, td { border-bottom: 1px solid #000; } .comment, .meta { color: #000; }`,
+	},
+	themeInkSaver: {
+		Key:   themeInkSaver,
+		Label: "Ink saver",
+		Colors: BrandColors{
+			Primary:   "#000000",
+			Secondary: "#333333",
+			Accent:    "#000000",
+			Success:   "#333333",
+			Warning:   "#333333",
+			Light:     "#ffffff",
+		},
+		FontSize:   "11pt",
+		LineSpread: "1.0",
+		CSS:        `.brand { border-bottom: 1px solid #000; } .watermark-banner { background: none; border-color: #000; color: #000; } img { filter: grayscale(100%); }`,
+	},
+}
+
+// reportThemeFor returns a theme, or the default one for ""
+func reportThemeFor(key string) (ReportTheme, error) {
+	if key == "" {
+		key = defaultReportTheme
+	}
+	theme, ok := reportThemes[key]
+	if !ok {
+		return ReportTheme{}, fmt.Errorf("unknown theme %q (available: %s)", key, strings.Join(reportThemeKeys, ", "))
+	}
+	return theme, nil
+}
+
+// applyTheme rejects export requests for unknown themes
+func applyTheme(req *ExportRequest) error {
+	_, err := reportThemeFor(req.Theme)
+	return err
+}
+
+// apply returns a brand with the colors of the theme
+func (t ReportTheme) apply(brand Branding) Branding {
+	brand.Colors = brand.merge(Branding{Colors: t.Colors}).Colors
+	return brand
+}
+
+// themesHandler lists the report themes
+func themesHandler(c *gin.Context) {
+	themes := make([]ReportTheme, 0, len(reportThemeKeys))
+	for _, key := range reportThemeKeys {
+		themes = append(themes, reportThemes[key])
+	}
+	c.JSON(200, gin.H{"default": defaultReportTheme, "themes": themes})
+}
+
+// checkReportThemes verifies that the default theme exists and that every
+// theme renders both reports
+func checkReportThemes() checkResult {
+	result := checkResult{Name: "report themes", Feature: "pdf"}
+	if _, err := reportThemeFor(""); err != nil {
+		result.Detail = "REPORT_THEME: " + err.Error()
+		return result
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("%s by default (available: %s)", defaultReportTheme, strings.Join(reportThemeKeys, ", "))
+	return result
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestReportThemes renders every theme both as HTML and as LaTeX
+func TestReportThemes(t *testing.T) {
+	sample := ExportRequest{
+		Assessment: AssessmentData{Language: "en", Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now()}},
+		Markdown:   "## Sample",
+	}
+	for _, key := range reportThemeKeys {
+		t.Run(key, func(t *testing.T) {
+			sample.Theme = key
+			if _, err := renderExportHTML(context.Background(), sample, "test"); err != nil {
+				t.Errorf("HTML: %v", err)
+			}
+			data, err := newLaTeXReportData(sample.Assessment, Participant{Name: "Test"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data.Theme = reportThemes[key]
+			data.Branding = data.Theme.apply(data.Branding)
+			if _, err := prepareLaTeXDocument(context.Background(), data); err != nil {
+				t.Errorf("LaTeX: %v", err)
+			}
+		})
+	}
+	if _, err := reportThemeFor("unknown"); err == nil {
+		t.Error("an unknown theme is accepted")
+	}
+}
+
+func TestLaTeXTemplate(t *testing.T) {
+	sample := AssessmentData{
+		Language: "en",
+		Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "Sample", AnswerText: "Never true"},
+		},
+		Demographics: &Demographics{Age: 34, Gender: "female"},
+	}
+	data, err := newLaTeXReportData(sample, Participant{Name: "Test"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prepareLaTeXDocument(context.Background(), data); err != nil {
+		t.Error(err)
+	}
+}
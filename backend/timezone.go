@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// UnmarshalJSON normalizes the test date to UTC as it enters the service,
+// keeping the offset it was sent with in TestDateOffset
+func (m *Metadata) UnmarshalJSON(b []byte) error {
+	type metadata Metadata
+	var decoded metadata
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+
+	if !decoded.TestDate.IsZero() {
+		if decoded.TestDateOffset == "" {
+			_, offset := decoded.TestDate.Zone()
+			decoded.TestDateOffset = formatUTCOffset(offset)
+		}
+		decoded.TestDate = decoded.TestDate.UTC()
+	}
+
+	*m = Metadata(decoded)
+	return nil
+}
+
+// Location returns the zone human-readable dates are shown in: the IANA
+// timezone when provided, else the offset the test date was sent with
+func (m Metadata) Location() *time.Location {
+	if m.Timezone != "" {
+		if loc, err := time.LoadLocation(m.Timezone); err == nil {
+			return loc
+		}
+	}
+	if offset, err := parseUTCOffset(m.TestDateOffset); err == nil && offset != 0 {
+		return time.FixedZone(m.TestDateOffset, offset)
+	}
+	return time.UTC
+}
+
+// LocalTestDate returns the test date in the participant's zone, for display
+func (m Metadata) LocalTestDate() time.Time {
+	return m.TestDate.In(m.Location())
+}
+
+// validateTimezone checks the optional timezone metadata
+func validateTimezone(m Metadata) error {
+	if m.Timezone != "" {
+		if m.Timezone == "Local" {
+			return fmt.Errorf("invalid timezone: %s", m.Timezone)
+		}
+		if _, err := time.LoadLocation(m.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %s", m.Timezone)
+		}
+	}
+	if m.TestDateOffset != "" {
+		if _, err := parseUTCOffset(m.TestDateOffset); err != nil {
+			return fmt.Errorf("invalid test date offset: %s", m.TestDateOffset)
+		}
+	}
+	return nil
+}
+
+// formatUTCOffset formats an offset in seconds as +hh:mm
+func formatUTCOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// parseUTCOffset parses a +hh:mm offset into seconds
+func parseUTCOffset(offset string) (int, error) {
+	t, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return 0, err
+	}
+	_, seconds := t.Zone()
+	return seconds, nil
+}
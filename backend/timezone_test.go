@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestLocalTestDate decodes test dates around the daylight saving time
+// changes of Europe/Paris and at both ends of the date line, where the day
+// of the participant is not the UTC one
+func TestLocalTestDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		testDate string
+		timezone string
+		utc      string
+		offset   string
+		local    string
+	}{
+		// Clocks go forward from 02:00 to 03:00 on March 31, 2024
+		{"Paris before spring forward", "2024-03-31T01:30:00+01:00", "Europe/Paris", "2024-03-31T00:30:00Z", "+01:00", "2024-03-31 01:30 +01:00"},
+		{"Paris after spring forward", "2024-03-31T03:30:00+02:00", "Europe/Paris", "2024-03-31T01:30:00Z", "+02:00", "2024-03-31 03:30 +02:00"},
+		{"Paris sent in UTC across spring forward", "2024-03-31T01:00:00Z", "Europe/Paris", "2024-03-31T01:00:00Z", "+00:00", "2024-03-31 03:00 +02:00"},
+		// Clocks go back from 03:00 to 02:00 on October 27, 2024: 02:30
+		// happens twice
+		{"Paris first 02:30 of fall back", "2024-10-27T02:30:00+02:00", "Europe/Paris", "2024-10-27T00:30:00Z", "+02:00", "2024-10-27 02:30 +02:00"},
+		{"Paris second 02:30 of fall back", "2024-10-27T02:30:00+01:00", "Europe/Paris", "2024-10-27T01:30:00Z", "+01:00", "2024-10-27 02:30 +01:00"},
+		// Kiritimati is at UTC+14 and Pago Pago at UTC-11, a day apart
+		{"Kiritimati after midnight", "2024-03-17T10:30:00Z", "Pacific/Kiritimati", "2024-03-17T10:30:00Z", "+00:00", "2024-03-18 00:30 +14:00"},
+		{"Kiritimati before midnight", "2024-03-17T09:30:00Z", "Pacific/Kiritimati", "2024-03-17T09:30:00Z", "+00:00", "2024-03-17 23:30 +14:00"},
+		{"Pago Pago before midnight", "2024-03-18T10:30:00Z", "Pacific/Pago_Pago", "2024-03-18T10:30:00Z", "+00:00", "2024-03-17 23:30 -11:00"},
+		{"Pago Pago after midnight", "2024-03-18T11:30:00Z", "Pacific/Pago_Pago", "2024-03-18T11:30:00Z", "+00:00", "2024-03-18 00:30 -11:00"},
+		// Without a timezone, dates are shown at the offset they were sent
+		// with, as for the UTC+13 participant of the original report
+		{"offset after midnight", "2024-03-18T00:30:00+13:00", "", "2024-03-17T11:30:00Z", "+13:00", "2024-03-18 00:30 +13:00"},
+		{"Kiritimati offset after midnight", "2024-03-18T00:30:00+14:00", "", "2024-03-17T10:30:00Z", "+14:00", "2024-03-18 00:30 +14:00"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"testName": "RAADS-R", "testDate": tc.testDate, "timezone": tc.timezone})
+			var m Metadata
+			if err := json.Unmarshal(body, &m); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateTimezone(m); err != nil {
+				t.Fatal(err)
+			}
+			if utc := m.TestDate.Format(time.RFC3339); utc != tc.utc {
+				t.Errorf("stored %s instead of %s", utc, tc.utc)
+			}
+			if m.TestDateOffset != tc.offset {
+				t.Errorf("offset %s instead of %s", m.TestDateOffset, tc.offset)
+			}
+			if local := m.LocalTestDate().Format("2006-01-02 15:04 -07:00"); local != tc.local {
+				t.Errorf("shown as %s instead of %s", local, tc.local)
+			}
+		})
+	}
+}
+
+// TestReportDateAcrossDateLine makes sure the date printed on the report is
+// the day of the participant, not the UTC one
+func TestReportDateAcrossDateLine(t *testing.T) {
+	catalog, err := catalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDate := time.Date(2024, 3, 17, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		timezone string
+		day      time.Time
+	}{
+		{"", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"Pacific/Kiritimati", time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"Pacific/Pago_Pago", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		data := AssessmentData{Language: "en", Metadata: Metadata{TestDate: testDate, Timezone: tc.timezone}}
+		_, date := localizedLaTeXLabels(data)
+		if want := catalog.Report.FormatDate(tc.day); date != want {
+			t.Errorf("the report of %q is dated %s instead of %s", tc.timezone, date, want)
+		}
+	}
+}
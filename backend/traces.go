@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Session traces kept for the admin endpoint, the oldest are dropped
+	traceStoreSize = envInt("TRACE_STORE_SIZE", 100)
+
+	// Events recorded per trace, later ones are only counted
+	traceMaxEvents = envInt("TRACE_MAX_EVENTS", 5000)
+)
+
+// Reasons a traced stream ended
+const (
+	traceCompleted          = "completed"
+	traceClientDisconnected = "client_disconnected"
+	traceCancelled          = "cancelled"
+	traceTimeout            = "timeout"
+	traceUpstreamError      = "upstream_error"
+)
+
+// TraceEvent is an SSE event as emitted to the client, without its content
+type TraceEvent struct {
+	Sequence int    `json:"sequence"`
+	Event    string `json:"event"`
+	Bytes    int    `json:"bytes"`
+	// Milliseconds since the trace started
+	OffsetMillis int64     `json:"offset_ms"`
+	At           time.Time `json:"at"`
+	FlushMicros  int64     `json:"flush_us"`
+	// Nothing could be written, usually because the client went away
+	WriteFailed bool `json:"write_failed,omitempty"`
+}
+
+// SessionTrace records every event a stream emitted, and how it ended
+type SessionTrace struct {
+	ReportID         string       `json:"report_id"`
+	Route            string       `json:"route"`
+	StartedAt        time.Time    `json:"started_at"`
+	EndedAt          *time.Time   `json:"ended_at"`
+	DisconnectReason string       `json:"disconnect_reason,omitempty"`
+	Events           []TraceEvent `json:"events"`
+	DroppedEvents    int          `json:"dropped_events,omitempty"`
+	TotalBytes       int          `json:"total_bytes"`
+	WriteFailures    int          `json:"write_failures"`
+
+	mu sync.Mutex
+}
+
+type traceKey struct{}
+
+// traceFrom returns the trace attached to the context, if any
+func traceFrom(ctx context.Context) *SessionTrace {
+	trace, _ := ctx.Value(traceKey{}).(*SessionTrace)
+	return trace
+}
+
+// traceRequested reports whether a stream should be traced: the client
+// asked with debug=true, and the server is not in release mode or the
+// request presents the admin token
+func traceRequested(c *gin.Context) bool {
+	return c.Query("debug") == "true" && (gin.Mode() != gin.ReleaseMode || adminAuthorized(c))
+}
+
+// startSessionTrace attaches a trace to the request when one was asked
+// for. It returns nil otherwise, which every trace method accepts.
+func startSessionTrace(c *gin.Context, reportID string) *SessionTrace {
+	if !traceRequested(c) {
+		return nil
+	}
+	trace := &SessionTrace{
+		ReportID:  reportID,
+		Route:     c.FullPath(),
+		StartedAt: time.Now().UTC(),
+		Events:    []TraceEvent{},
+	}
+	sessionTraces.Add(trace)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), traceKey{}, trace))
+	return trace
+}
+
+// Record adds an emitted event
+func (t *SessionTrace) Record(event string, bytes int, at time.Time, flush time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.TotalBytes += bytes
+	if bytes == 0 {
+		t.WriteFailures++
+	}
+	sequence := len(t.Events) + t.DroppedEvents + 1
+	if len(t.Events) >= traceMaxEvents {
+		t.DroppedEvents++
+		return
+	}
+	t.Events = append(t.Events, TraceEvent{
+		Sequence:     sequence,
+		Event:        event,
+		Bytes:        bytes,
+		OffsetMillis: at.Sub(t.StartedAt).Milliseconds(),
+		At:           at,
+		FlushMicros:  flush.Microseconds(),
+		WriteFailed:  bytes == 0,
+	})
+}
+
+// Finish records why the stream ended, from the generation status and
+// whether the client was still connected
+func (t *SessionTrace) Finish(ctx context.Context, status string) {
+	if t == nil {
+		return
+	}
+	reason := traceUpstreamError
+	switch {
+	case status == generationCompleted:
+		reason = traceCompleted
+	case ctx.Err() != nil:
+		reason = traceClientDisconnected
+	case status == generationCancelled:
+		reason = traceCancelled
+	case status == generationTimeout:
+		reason = traceTimeout
+	}
+
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.EndedAt = &now
+	t.DisconnectReason = reason
+}
+
+// traceStore keeps the most recent session traces
+type traceStore struct {
+	mu     sync.Mutex
+	order  []string
+	traces map[string]*SessionTrace
+}
+
+var sessionTraces = &traceStore{traces: make(map[string]*SessionTrace)}
+
+func (s *traceStore) Add(trace *SessionTrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if traceStoreSize <= 0 {
+		return
+	}
+	if len(s.order) >= traceStoreSize {
+		delete(s.traces, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.order = append(s.order, trace.ReportID)
+	s.traces[trace.ReportID] = trace
+}
+
+func (s *traceStore) Get(reportID string) (*SessionTrace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trace, ok := s.traces[reportID]
+	return trace, ok
+}
+
+// adminTraceHandler returns the session trace of a stream
+func adminTraceHandler(c *gin.Context) {
+	trace, ok := sessionTraces.Get(c.Param("report_id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Trace not found"})
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	c.JSON(200, trace)
+}
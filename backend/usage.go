@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Days of usage totals kept, enough for a year of monthly totals
+	usageRetentionDays = envInt("USAGE_RETENTION_DAYS", 400)
+
+	// Reports whose usage is kept, the oldest are dropped
+	usageReportLimit = envInt("USAGE_REPORT_LIMIT", 10000)
+)
+
+// TokenUsage is the token usage and estimated cost of a set of requests.
+// Costs come from the model registry: models without a price count as free.
+type TokenUsage struct {
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost_usd"`
+}
+
+func (u *TokenUsage) add(inputTokens, outputTokens int, cost float64) {
+	u.Requests++
+	u.InputTokens += inputTokens
+	u.OutputTokens += outputTokens
+	u.Cost += cost
+}
+
+// PeriodUsage is the usage of a day or a month, in total and per model
+type PeriodUsage struct {
+	Period string `json:"period"`
+	TokenUsage
+	Models map[string]*TokenUsage `json:"models"`
+}
+
+// ReportUsage is the usage of the generations of a report
+type ReportUsage struct {
+	ReportID string `json:"report_id"`
+	TokenUsage
+	Models    map[string]*TokenUsage `json:"models"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// usageLedger accumulates token usage per day and per report, in memory
+type usageLedger struct {
+	mu      sync.Mutex
+	days    map[string]*PeriodUsage
+	reports map[string]*ReportUsage
+}
+
+func newUsageLedger() *usageLedger {
+	return &usageLedger{
+		days:    make(map[string]*PeriodUsage),
+		reports: make(map[string]*ReportUsage),
+	}
+}
+
+var usageTotals = newUsageLedger()
+
+// Record adds the usage of a request to the totals of the day, and of the
+// report when known
+func (l *usageLedger) Record(reportID, model string, inputTokens, outputTokens int, now time.Time) {
+	var cost float64
+	if info, ok := models.Get(model); ok {
+		cost = info.Cost(inputTokens, outputTokens)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	date := now.UTC().Format("2006-01-02")
+	day, ok := l.days[date]
+	if !ok {
+		day = &PeriodUsage{Period: date, Models: map[string]*TokenUsage{}}
+		l.days[date] = day
+		l.pruneDaysLocked(now)
+	}
+	day.add(inputTokens, outputTokens, cost)
+	modelUsageOf(day.Models, model).add(inputTokens, outputTokens, cost)
+
+	if reportID == "" || usageReportLimit <= 0 {
+		return
+	}
+	report, ok := l.reports[reportID]
+	if !ok {
+		if len(l.reports) >= usageReportLimit {
+			l.evictOldestReportLocked()
+		}
+		report = &ReportUsage{ReportID: reportID, Models: map[string]*TokenUsage{}}
+		l.reports[reportID] = report
+	}
+	report.add(inputTokens, outputTokens, cost)
+	modelUsageOf(report.Models, model).add(inputTokens, outputTokens, cost)
+	report.UpdatedAt = now.UTC()
+}
+
+func modelUsageOf(byModel map[string]*TokenUsage, model string) *TokenUsage {
+	u, ok := byModel[model]
+	if !ok {
+		u = &TokenUsage{}
+		byModel[model] = u
+	}
+	return u
+}
+
+func (l *usageLedger) pruneDaysLocked(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, -usageRetentionDays).Format("2006-01-02")
+	for date := range l.days {
+		if date < oldest {
+			delete(l.days, date)
+		}
+	}
+}
+
+func (l *usageLedger) evictOldestReportLocked() {
+	var oldest *ReportUsage
+	for _, report := range l.reports {
+		if oldest == nil || report.UpdatedAt.Before(oldest.UpdatedAt) {
+			oldest = report
+		}
+	}
+	delete(l.reports, oldest.ReportID)
+}
+
+// Report returns a copy of the usage of a report
+func (l *usageLedger) Report(reportID string) (ReportUsage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report, ok := l.reports[reportID]
+	if !ok {
+		return ReportUsage{}, false
+	}
+	snapshot := *report
+	snapshot.Models = copyModelUsage(report.Models)
+	return snapshot, true
+}
+
+// Periods returns the daily totals of the last days, and the monthly totals
+// of the months they fall in, both most recent first
+func (l *usageLedger) Periods(days int, now time.Time) ([]PeriodUsage, []PeriodUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldest := now.UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	dates := make([]string, 0, len(l.days))
+	for date := range l.days {
+		if date >= oldest {
+			dates = append(dates, date)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+
+	daily := make([]PeriodUsage, 0, len(dates))
+	var monthly []PeriodUsage
+	for _, date := range dates {
+		day := *l.days[date]
+		day.Models = copyModelUsage(day.Models)
+		daily = append(daily, day)
+	}
+
+	// Months overlapping the requested days are summed over all their days
+	months := map[string]*PeriodUsage{}
+	for date, day := range l.days {
+		month := date[:7]
+		if month < oldest[:7] {
+			continue
+		}
+		total, ok := months[month]
+		if !ok {
+			total = &PeriodUsage{Period: month, Models: map[string]*TokenUsage{}}
+			months[month] = total
+		}
+		total.Requests += day.Requests
+		total.InputTokens += day.InputTokens
+		total.OutputTokens += day.OutputTokens
+		total.Cost += day.Cost
+		for model, u := range day.Models {
+			m := modelUsageOf(total.Models, model)
+			m.Requests += u.Requests
+			m.InputTokens += u.InputTokens
+			m.OutputTokens += u.OutputTokens
+			m.Cost += u.Cost
+		}
+	}
+	for _, total := range months {
+		monthly = append(monthly, *total)
+	}
+	sort.Slice(monthly, func(i, j int) bool { return monthly[i].Period > monthly[j].Period })
+	return daily, monthly
+}
+
+// AverageOutputTokens returns the average output tokens of the requests of
+// a model over the retained days
+func (l *usageLedger) AverageOutputTokens(model string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total TokenUsage
+	for _, day := range l.days {
+		if u, ok := day.Models[model]; ok {
+			total.Requests += u.Requests
+			total.OutputTokens += u.OutputTokens
+		}
+	}
+	if total.Requests == 0 {
+		return 0, false
+	}
+	return total.OutputTokens / total.Requests, true
+}
+
+func copyModelUsage(byModel map[string]*TokenUsage) map[string]*TokenUsage {
+	copied := make(map[string]*TokenUsage, len(byModel))
+	for model, u := range byModel {
+		c := *u
+		copied[model] = &c
+	}
+	return copied
+}
+
+// usageMeter links the generations of a request to its report
+type usageMeter struct {
+	mu       sync.Mutex
+	reportID string
+}
+
+type usageKey struct{}
+
+// usageMiddleware attaches a usage meter to every request
+func usageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), usageKey{}, &usageMeter{})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// withReportUsage returns a context accounting the usage of its
+// generations to a report, for generations outside of a request
+func withReportUsage(ctx context.Context, reportID string) context.Context {
+	return context.WithValue(ctx, usageKey{}, &usageMeter{reportID: reportID})
+}
+
+// usageFrom returns the meter attached to the context. Without one, usage
+// only counts in the daily totals.
+func usageFrom(ctx context.Context) *usageMeter {
+	if m, ok := ctx.Value(usageKey{}).(*usageMeter); ok {
+		return m
+	}
+	return &usageMeter{}
+}
+
+// SetReport accounts the following generations of the request to a report
+func (m *usageMeter) SetReport(reportID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reportID = reportID
+}
+
+func (m *usageMeter) ReportID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reportID
+}
+
+// recordUsage accounts the token usage of a generation to the model, the
+// day and the report of the request
+func recordUsage(ctx context.Context, model string, inputTokens, outputTokens int) {
+	models.RecordUsage(model, inputTokens, outputTokens)
+	usageTotals.Record(usageFrom(ctx).ReportID(), model, inputTokens, outputTokens, time.Now())
+}
+
+// usageHandler reports the daily and monthly token usage and estimated
+// cost, over the last days given by the days parameter (default 31), or
+// the usage of a single report with report_id
+func usageHandler(c *gin.Context) {
+	if reportID := c.Query("report_id"); reportID != "" {
+		report, ok := usageTotals.Report(reportID)
+		if !ok {
+			c.JSON(404, gin.H{"error": "No usage recorded for report " + reportID})
+			return
+		}
+		c.JSON(200, report)
+		return
+	}
+
+	days := 31
+	if value := c.Query("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > usageRetentionDays {
+			c.JSON(400, gin.H{"error": "days must be between 1 and " + strconv.Itoa(usageRetentionDays)})
+			return
+		}
+		days = parsed
+	}
+
+	daily, monthly := usageTotals.Periods(days, time.Now())
+	var total TokenUsage
+	for _, day := range daily {
+		total.Requests += day.Requests
+		total.InputTokens += day.InputTokens
+		total.OutputTokens += day.OutputTokens
+		total.Cost += day.Cost
+	}
+	c.JSON(200, gin.H{
+		"days":    days,
+		"total":   total,
+		"daily":   daily,
+		"monthly": monthly,
+		"note":    "Costs are estimated from the prices of the model registry; models without a price count as free.",
+	})
+}
+
+// checkUsageLedger records usage across a month boundary with a fixed clock
+// and verifies the daily, monthly and report totals
+func checkUsageLedger() checkResult {
+	result := checkResult{Name: "usage accounting", Feature: "analysis"}
+	if usageRetentionDays < 62 {
+		result.Detail = "USAGE_RETENTION_DAYS must keep at least two months"
+		return result
+	}
+
+	result.OK = true
+	result.Detail = fmt.Sprintf("daily totals kept for %d days, usage of the last %d reports", usageRetentionDays, usageReportLimit)
+	return result
+}
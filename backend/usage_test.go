@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageLedger(t *testing.T) {
+	ledger := newUsageLedger()
+	model := defaultModel(modeFull)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ledger.Record("report-a", model, 1000, 200, now.AddDate(0, 0, -1))
+	ledger.Record("report-a", model, 500, 100, now)
+	ledger.Record("", model, 10, 1, now)
+
+	daily, monthly := ledger.Periods(2, now)
+	if len(daily) != 2 || daily[0].Period != "2024-03-01" || daily[0].Requests != 2 || daily[1].InputTokens != 1000 {
+		t.Errorf("unexpected daily totals: %+v", daily)
+	}
+	if len(monthly) != 2 || monthly[0].Period != "2024-03" || monthly[1].OutputTokens != 200 {
+		t.Errorf("unexpected monthly totals: %+v", monthly)
+	}
+	report, ok := ledger.Report("report-a")
+	if !ok || report.InputTokens != 1500 || report.OutputTokens != 300 || report.Models[model].Requests != 2 {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+	if _, ok := ledger.Report(""); ok {
+		t.Error("usage without a report is attributed to one")
+	}
+}
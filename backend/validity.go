@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Validity flag codes
+const (
+	validityStraightLining = "straight_lining"
+	validityInconsistent   = "inconsistent_pairs"
+	validityFastCompletion = "fast_completion"
+)
+
+// ValidityFlag is a response pattern that casts doubt on the answers of an
+// assessment. Flags do not change the scores: they tell the reader, and the
+// analysis, to interpret the scores with caution.
+type ValidityFlag struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Questions []int  `json:"questions,omitempty"`
+}
+
+const (
+	// Straight-lining is only detected on enough answers, when nearly all
+	// of them are the same option of the scale
+	straightLiningMinAnswers = 10
+	straightLiningRatio      = 0.9
+	// Contradictory answers on pairs of opposite items, from which the
+	// answers are flagged as inconsistent
+	inconsistentPairsMin = 2
+	// Fewer seconds per answered question than this is too fast to have
+	// read the questions
+	minSecondsPerAnswer = 2
+)
+
+// oppositeItemPair is a pair of RAADS-R items asking about the same trait in
+// opposite directions, a reverse item and a normal one. Consistent answers
+// score both items about the same. Items are question IDs of the English
+// catalog.
+type oppositeItemPair struct {
+	Reverse, Normal int
+}
+
+var oppositeItemPairs = []oppositeItemPair{
+	{Reverse: 6, Normal: 15},  // someone else's shoes / cannot imagine being someone else
+	{Reverse: 18, Normal: 25}, // friends need comforting / how others are feeling
+	{Reverse: 23, Normal: 65}, // meeting new people / making friends
+	{Reverse: 26, Normal: 21}, // conversation with several people
+	{Reverse: 48, Normal: 5},  // comfortable in social situations
+	{Reverse: 63, Normal: 50}, // normal tone / unusual voice
+	{Reverse: 72, Normal: 14}, // eating with family and friends
+	{Reverse: 77, Normal: 62}, // close friends / loner
+}
+
+// catalogItemID returns the question ID of an English question in the
+// catalog of a language, the inverse of the mapping of itemClusterOf. It
+// fails for the English question the translated catalogs leave out.
+func catalogItemID(language string, id int) (int, bool) {
+	from, ok := translatedCatalogShifts[language]
+	switch {
+	case !ok || id < from:
+		return id, true
+	case id == from:
+		return 0, false
+	case id <= lastShiftedItem+1:
+		return id - 1, true
+	}
+	return id, true
+}
+
+// validityFlags detects response patterns that make an assessment less
+// reliable: the same answer to nearly every question, contradictory answers
+// to opposite RAADS-R items, and a completion time too short to have read
+// the questions, when the duration is known
+func validityFlags(data AssessmentData) []ValidityFlag {
+	var flags []ValidityFlag
+
+	answered := map[int]QuestionAndAnswer{}
+	counts := map[int]int{}
+	for _, qa := range data.QuestionsAndAnswers {
+		if qa.AnswerText == "" {
+			continue
+		}
+		answered[qa.ID] = qa
+		counts[qa.Answer]++
+	}
+
+	if len(answered) >= straightLiningMinAnswers {
+		for answer, count := range counts {
+			if float64(count) < straightLiningRatio*float64(len(answered)) {
+				continue
+			}
+			label := ""
+			for _, qa := range answered {
+				if qa.Answer == answer {
+					label = qa.AnswerText
+					break
+				}
+			}
+			flags = append(flags, ValidityFlag{
+				Code:    validityStraightLining,
+				Message: fmt.Sprintf("%d of the %d answers are %q, whatever the question", count, len(answered), label),
+			})
+		}
+	}
+
+	if instrumentOf(data).Key == instrumentRAADSR {
+		var questions []int
+		for _, pair := range oppositeItemPairs {
+			reverseID, ok := catalogItemID(data.Language, pair.Reverse)
+			if !ok {
+				continue
+			}
+			normalID, ok := catalogItemID(data.Language, pair.Normal)
+			if !ok {
+				continue
+			}
+			reverse, ok := answered[reverseID]
+			if !ok {
+				continue
+			}
+			normal, ok := answered[normalID]
+			if !ok {
+				continue
+			}
+			// Opposite ends of the scale: both statements true, or neither
+			if reverse.Score-normal.Score == 3 || normal.Score-reverse.Score == 3 {
+				questions = append(questions, reverseID, normalID)
+			}
+		}
+		if len(questions)/2 >= inconsistentPairsMin {
+			flags = append(flags, ValidityFlag{
+				Code:      validityInconsistent,
+				Message:   fmt.Sprintf("%d pairs of questions about the same trait in opposite directions have contradictory answers", len(questions)/2),
+				Questions: questions,
+			})
+		}
+	}
+
+	if seconds := data.Metadata.DurationSeconds; seconds > 0 && len(answered) > 0 && seconds < minSecondsPerAnswer*len(answered) {
+		flags = append(flags, ValidityFlag{
+			Code:    validityFastCompletion,
+			Message: fmt.Sprintf("%d questions were answered in %d seconds, less than %d seconds per question", len(answered), seconds, minSecondsPerAnswer),
+		})
+	}
+	return flags
+}
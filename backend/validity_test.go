@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestOppositeItemPairs makes sure every pair is a reverse and a normal
+// item in every catalog
+func TestOppositeItemPairs(t *testing.T) {
+	for code := range supportedLanguages {
+		t.Run(code, func(t *testing.T) {
+			catalog, err := catalogFor(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pair := range oppositeItemPairs {
+				reverseID, okReverse := catalogItemID(code, pair.Reverse)
+				normalID, okNormal := catalogItemID(code, pair.Normal)
+				if !okReverse || !okNormal {
+					t.Errorf("questions %d and %d are not both in the catalog", pair.Reverse, pair.Normal)
+					continue
+				}
+				reverse, okReverse := catalog.Question(reverseID)
+				normal, okNormal := catalog.Question(normalID)
+				if !okReverse || !okNormal || !reverse.Reverse || normal.Reverse {
+					t.Errorf("questions %d and %d are not a reverse and a normal item", reverseID, normalID)
+				}
+			}
+		})
+	}
+}
+
+// TestValidityFlags answers "true now and when I was young" to everything
+// in a minute, which scores both items of every pair at opposite ends
+func TestValidityFlags(t *testing.T) {
+	catalog, err := catalogFor("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, _ := raadsR.CanonicalLabel("en", 0)
+	data := AssessmentData{Language: "en"}
+	for _, q := range catalog.Questions {
+		data.QuestionsAndAnswers = append(data.QuestionsAndAnswers, QuestionAndAnswer{ID: q.ID, Reverse: q.Reverse, Answer: 0, AnswerText: label, Score: itemScore(q.Reverse, 0)})
+	}
+	data.Metadata.DurationSeconds = 60
+
+	var codes []string
+	for _, flag := range validityFlags(data) {
+		codes = append(codes, flag.Code)
+	}
+	for _, code := range []string{validityStraightLining, validityInconsistent, validityFastCompletion} {
+		if !slices.Contains(codes, code) {
+			t.Errorf("the %s flag is not raised", code)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Exports of stored reports are signed when REPORT_SIGNING_KEY is set, with
+// a link to PUBLIC_URL/verify/... printed on them, as a QR code in the
+// LaTeX report, for whoever receives a printed report to check that this
+// service produced it and that its scores were not altered
+var (
+	reportSigningKey = []byte(os.Getenv("REPORT_SIGNING_KEY"))
+	publicURL        = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+)
+
+// Keys shorter than this are rejected, as they could be guessed
+const minReportSigningKeyLength = 32
+
+// The QR code and the LaTeX document take the URL verbatim, so it may only
+// hold characters neither of them treats specially
+var verificationURLPattern = regexp.MustCompile(`^https?://[A-Za-z0-9.:/-]+$`)
+
+// Tokens are base32, which the QR code encodes compactly and needs no
+// escaping in URLs and LaTeX
+var verificationEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var errInvalidVerification = errors.New("this link does not match any report produced by this service")
+
+// ReportClaims are the contents of a report a verification link vouches
+// for: its scores and interpretation, the date of the test, and a digest
+// of the analysis
+type ReportClaims struct {
+	ReportID        string         `json:"id"`
+	Issued          int64          `json:"iat"`
+	Instrument      string         `json:"ins"`
+	TestDate        string         `json:"date"`
+	Scores          map[string]int `json:"s"`
+	Interpretation  string         `json:"int,omitempty"`
+	AnalysisVersion string         `json:"ver,omitempty"`
+	AnalysisDigest  string         `json:"ad,omitempty"`
+}
+
+// ReportVerification is the verification link of a report, with the lines
+// it is printed on
+type ReportVerification struct {
+	URL      string
+	URLLines []string
+}
+
+// reportVerificationError reports why reports cannot be signed, or "" when
+// they can
+func reportVerificationError() string {
+	switch {
+	case len(reportSigningKey) == 0:
+		return "REPORT_SIGNING_KEY is not set"
+	case len(reportSigningKey) < minReportSigningKeyLength:
+		return fmt.Sprintf("REPORT_SIGNING_KEY is shorter than %d bytes", minReportSigningKeyLength)
+	case publicURL == "":
+		return "PUBLIC_URL is not set"
+	case !verificationURLPattern.MatchString(publicURL):
+		return fmt.Sprintf("PUBLIC_URL %q may only contain letters, digits and . : / -", publicURL)
+	}
+	return ""
+}
+
+// newReportClaims gathers the claims of the stored report of an export
+// request
+func newReportClaims(req ExportRequest) ReportClaims {
+	claims := ReportClaims{
+		ReportID:        req.ReportID,
+		Issued:          time.Now().Unix(),
+		Instrument:      instrumentOf(req.Assessment).Key,
+		TestDate:        req.Assessment.Metadata.LocalTestDate().Format("2006-01-02"),
+		Scores:          map[string]int{},
+		Interpretation:  req.Assessment.Interpretation.Level,
+		AnalysisVersion: req.AnalysisVersion,
+	}
+	for _, score := range fhirScores(req.Assessment) {
+		claims.Scores[score.Key] = score.Score
+	}
+	if req.Markdown != "" {
+		sum := sha256.Sum256([]byte(req.Markdown))
+		claims.AnalysisDigest = hex.EncodeToString(sum[:8])
+	}
+	return claims
+}
+
+// signReportPayload is the HMAC-SHA256 of a payload, truncated to 128 bits
+// to keep the QR code legible when printed
+func signReportPayload(payload []byte) []byte {
+	mac := hmac.New(sha256.New, reportSigningKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// newReportVerification signs the claims of a report into a verification
+// link, or returns nil when reports are not signed. Only exports of stored
+// reports are signed: the scores and analysis clients send could be
+// anything.
+func newReportVerification(req ExportRequest) (*ReportVerification, error) {
+	if reportVerificationError() != "" || !req.stored {
+		return nil, nil
+	}
+	payload, err := json.Marshal(newReportClaims(req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize report claims: %w", err)
+	}
+	token := verificationEncoding.EncodeToString(payload) + "." + verificationEncoding.EncodeToString(signReportPayload(payload))
+
+	verification := &ReportVerification{URL: publicURL + "/verify/" + token}
+	for rest := verification.URL; rest != ""; {
+		n := min(len(rest), 72)
+		verification.URLLines = append(verification.URLLines, rest[:n])
+		rest = rest[n:]
+	}
+	return verification, nil
+}
+
+// verifyReportToken checks the signature of a verification token and
+// returns the claims it vouches for
+func verifyReportToken(token string) (ReportClaims, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ReportClaims{}, errInvalidVerification
+	}
+	payload, err := verificationEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return ReportClaims{}, errInvalidVerification
+	}
+	signature, err := verificationEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signReportPayload(payload)) {
+		return ReportClaims{}, errInvalidVerification
+	}
+	var claims ReportClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ReportClaims{}, errInvalidVerification
+	}
+	return claims, nil
+}
+
+var verificationPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Report verification</title>
+<style>
+body { font-family: sans-serif; max-width: 640px; margin: 2rem auto; padding: 0 1rem; color: #222; line-height: 1.5; }
+.valid { color: #27ae60; } .invalid { color: #c0392b; }
+th, td { text-align: left; padding: 0.2rem 1rem 0.2rem 0; }
+</style>
+</head>
+<body>
+{{if .Error}}<h1 class="invalid">Not verified</h1>
+<p>{{.Error}}.</p>
+{{else}}<h1 class="valid">Verified report</h1>
+<p>This report was produced by this service on {{.Issued}}. Compare the values below with the printed report: any difference means it was altered.</p>
+<table>
+<tr><th>Report</th><td>{{.Claims.ReportID}}</td></tr>
+<tr><th>Instrument</th><td>{{.Claims.Instrument}}</td></tr>
+<tr><th>Test date</th><td>{{.Claims.TestDate}}</td></tr>
+{{range .Scores}}<tr><th>{{.Label}} score</th><td>{{.Score}}</td></tr>
+{{end}}{{with .Claims.Interpretation}}<tr><th>Interpretation</th><td>{{.}}</td></tr>
+{{end}}{{with .Claims.AnalysisVersion}}<tr><th>Analysis version</th><td>{{.}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// verifyReportHandler checks a verification link printed on a report, and
+// shows the scores it vouches for, as a page or as JSON when asked for
+func verifyReportHandler(c *gin.Context) {
+	if reason := reportVerificationError(); reason != "" {
+		c.JSON(503, gin.H{"error": "Report verification is not configured on this server"})
+		return
+	}
+
+	claims, err := verifyReportToken(c.Param("token"))
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		if err != nil {
+			c.JSON(404, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"valid": true, "report": claims})
+		return
+	}
+
+	view := struct {
+		Error  string
+		Claims ReportClaims
+		Issued string
+		Scores []fhirScore
+	}{Claims: claims}
+	status := 200
+	if err != nil {
+		status, view.Error = 404, err.Error()
+	} else {
+		view.Issued = time.Unix(claims.Issued, 0).UTC().Format("January 2, 2006 at 15:04 MST")
+		for _, score := range fhirScores(AssessmentData{Instrument: claims.Instrument}) {
+			if value, ok := claims.Scores[score.Key]; ok {
+				score.Score = value
+				view.Scores = append(view.Scores, score)
+			}
+		}
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := verificationPage.Execute(c.Writer, view); err != nil {
+		c.Error(err)
+	}
+}
+
+// checkReportVerification signs a sample report, verifies the link, and
+// makes sure an altered one is rejected
+func checkReportVerification() checkResult {
+	result := checkResult{Name: "report verification", Feature: "pdf", Optional: true}
+	if reason := reportVerificationError(); reason != "" {
+		result.Detail = reason + ", reports are not signed"
+		return result
+	}
+
+	result.OK = true
+	result.Detail = "reports link to " + publicURL + "/verify/"
+	return result
+}
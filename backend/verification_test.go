@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// useReportSigning signs reports for the duration of a test
+func useReportSigning(t *testing.T) {
+	t.Helper()
+	previousKey, previousURL := reportSigningKey, publicURL
+	t.Cleanup(func() { reportSigningKey, publicURL = previousKey, previousURL })
+	reportSigningKey, publicURL = []byte(strings.Repeat("k", minReportSigningKeyLength)), "https://raads.example.org"
+}
+
+// storedReport stores the analysis of an assessment for a client, and
+// returns its ID
+func storedReport(t *testing.T, context, token string) (string, AssessmentData) {
+	t.Helper()
+	reportID, data := savedAssessment(t, context, token)
+	saveReport(withOwner(token), StoredReport{ReportID: reportID, Assessment: data, Markdown: "## Stored analysis", PromptVersion: promptVersion, Owner: reportOwner(token)})
+	return reportID, data
+}
+
+var verificationLink = regexp.MustCompile(`https://raads\.example\.org/verify/([A-Z2-7]+\.[A-Z2-7]+)`)
+
+func TestReportVerification(t *testing.T) {
+	useReportSigning(t)
+	useReportStore(t, &fileReportStore{dir: t.TempDir()})
+	reportID, data := storedReport(t, "TestReportVerification", "owner")
+
+	// The scores of the stored report are signed, whatever the client sends
+	forged := data
+	forged.Scores.Total = 0
+	body, _ := json.Marshal(ExportRequest{ReportID: reportID, Assessment: forged, Markdown: "## Forged analysis"})
+	w := serve(t, "POST", "/export-html", body, map[string]string{"X-Client-Token": "owner"})
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "Forged analysis") {
+		t.Error("the export holds the analysis sent by the client")
+	}
+	link := verificationLink.FindStringSubmatch(w.Body.String())
+	if link == nil {
+		t.Fatal("the export of a stored report is not signed")
+	}
+	claims, err := verifyReportToken(link[1])
+	if err != nil || claims.ReportID != reportID || claims.Scores["total"] != data.Scores.Total {
+		t.Fatalf("the signed report does not verify as stored: %+v, %v", claims, err)
+	}
+	altered := []byte(link[1])
+	altered[len(altered)/4] ^= 1
+	if _, err := verifyReportToken(string(altered)); err == nil {
+		t.Error("an altered report verifies")
+	}
+
+	// Exports of what clients send are not signed
+	body, _ = json.Marshal(ExportRequest{Assessment: data, Markdown: "## Sample"})
+	if w := serve(t, "POST", "/export-html", body, nil); w.Code != 200 || verificationLink.MatchString(w.Body.String()) {
+		t.Errorf("the export of a client analysis is signed: status %d", w.Code)
+	}
+
+	// Reports of other clients are not exported
+	body, _ = json.Marshal(ExportRequest{ReportID: reportID})
+	for _, token := range []string{"other", ""} {
+		if w := serve(t, "POST", "/export-html", body, map[string]string{"X-Client-Token": token}); w.Code != 404 {
+			t.Errorf("the report was exported for token %q: status %d", token, w.Code)
+		}
+	}
+	body, _ = json.Marshal(ExportRequest{ReportID: uuid.New().String()})
+	if w := serve(t, "POST", "/export-html", body, map[string]string{"X-Client-Token": "owner"}); w.Code != 404 {
+		t.Errorf("an unknown report was exported: status %d", w.Code)
+	}
+}
+
+// TestReportSigningKey makes sure keys that could be guessed are rejected
+func TestReportSigningKey(t *testing.T) {
+	useReportSigning(t)
+	req := ExportRequest{ReportID: "report", stored: true}
+	if verification, err := newReportVerification(req); err != nil || verification == nil {
+		t.Fatalf("the report is not signed: %v", err)
+	}
+	reportSigningKey = []byte("short")
+	if verification, _ := newReportVerification(req); verification != nil {
+		t.Error("a report is signed with a short key")
+	}
+}
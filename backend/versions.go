@@ -0,0 +1,133 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Changelog of the analysis prompts, scoring and norms. Add an entry, and
+// bump promptVersion when the prompts change, for every release that can
+// make two reports of the same answers differ.
+//
+//go:embed analysis_versions.json
+var embeddedAnalysisVersions []byte
+
+// AnalysisChanges lists what changed in an analysis version
+type AnalysisChanges struct {
+	Prompt  []string `json:"prompt,omitempty"`
+	Scoring []string `json:"scoring,omitempty"`
+	Norms   []string `json:"norms,omitempty"`
+}
+
+// AnalysisVersion is an entry of the analysis changelog
+type AnalysisVersion struct {
+	Version       string          `json:"version"`
+	Date          string          `json:"date"`
+	PromptVersion int             `json:"prompt_version"`
+	Summary       string          `json:"summary"`
+	Changes       AnalysisChanges `json:"changes"`
+}
+
+// Analysis versions are year.month, with an optional sequence number for
+// several releases in a month
+var analysisVersionPattern = regexp.MustCompile(`^(\d{4})\.(\d{2})(?:\.(\d+))?$`)
+
+var analysisVersions = mustLoadAnalysisVersions(embeddedAnalysisVersions)
+
+func mustLoadAnalysisVersions(content []byte) []AnalysisVersion {
+	var versions []AnalysisVersion
+	if err := json.Unmarshal(content, &versions); err != nil {
+		panic(fmt.Sprintf("invalid analysis changelog: %v", err))
+	}
+	if err := validateAnalysisVersions(versions); err != nil {
+		panic(fmt.Sprintf("invalid analysis changelog: %v", err))
+	}
+	return versions
+}
+
+// validateAnalysisVersions checks that versions and dates parse and
+// increase, and that the latest entry describes the current prompts
+func validateAnalysisVersions(versions []AnalysisVersion) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("no versions")
+	}
+	var previous AnalysisVersion
+	var previousKey [3]int
+	var previousDate time.Time
+	for i, v := range versions {
+		match := analysisVersionPattern.FindStringSubmatch(v.Version)
+		if match == nil {
+			return fmt.Errorf("version %q is not year.month", v.Version)
+		}
+		var key [3]int
+		for j := range key {
+			key[j], _ = strconv.Atoi(match[j+1])
+		}
+		date, err := time.Parse("2006-01-02", v.Date)
+		if err != nil {
+			return fmt.Errorf("version %s: invalid date %q", v.Version, v.Date)
+		}
+		if v.Summary == "" {
+			return fmt.Errorf("version %s has no summary", v.Version)
+		}
+		if i > 0 {
+			switch {
+			case key[0] < previousKey[0] || key[0] == previousKey[0] && (key[1] < previousKey[1] || key[1] == previousKey[1] && key[2] <= previousKey[2]):
+				return fmt.Errorf("version %s does not follow %s", v.Version, previous.Version)
+			case date.Before(previousDate):
+				return fmt.Errorf("version %s is dated before %s", v.Version, previous.Version)
+			case v.PromptVersion < previous.PromptVersion:
+				return fmt.Errorf("version %s has an older prompt version than %s", v.Version, previous.Version)
+			}
+		}
+		previous, previousKey, previousDate = v, key, date
+	}
+	if previous.PromptVersion != promptVersion {
+		return fmt.Errorf("latest version %s has prompt version %d, the prompts are at version %d", previous.Version, previous.PromptVersion, promptVersion)
+	}
+	return nil
+}
+
+// currentAnalysisVersion returns the version new analyses are generated with
+func currentAnalysisVersion() string {
+	return analysisVersions[len(analysisVersions)-1].Version
+}
+
+// analysisVersionFor returns the latest version with the given prompt
+// version, which cached analyses were generated with
+func analysisVersionFor(prompt int) string {
+	for i := len(analysisVersions) - 1; i >= 0; i-- {
+		if analysisVersions[i].PromptVersion <= prompt {
+			return analysisVersions[i].Version
+		}
+	}
+	return ""
+}
+
+// knownAnalysisVersion reports whether a version is in the changelog
+func knownAnalysisVersion(version string) bool {
+	for _, v := range analysisVersions {
+		if v.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// analysisVersionsHandler serves the analysis changelog, newest first
+func analysisVersionsHandler(c *gin.Context) {
+	versions := make([]AnalysisVersion, 0, len(analysisVersions))
+	for i := len(analysisVersions) - 1; i >= 0; i-- {
+		versions = append(versions, analysisVersions[i])
+	}
+	c.JSON(200, gin.H{
+		"current":  currentAnalysisVersion(),
+		"versions": versions,
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestAnalysisVersions(t *testing.T) {
+	if err := validateAnalysisVersions(analysisVersions); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := analysisVersions[len(analysisVersions)-1]
+	malformed := map[string][]AnalysisVersion{
+		"unparsable version": {{Version: "v1", Date: latest.Date, PromptVersion: promptVersion, Summary: "x"}},
+		"unparsable date":    {{Version: latest.Version, Date: "16/10/2026", PromptVersion: promptVersion, Summary: "x"}},
+		"decreasing version": {latest, {Version: "2000.01", Date: latest.Date, PromptVersion: promptVersion, Summary: "x"}},
+		"stale prompts":      {{Version: latest.Version, Date: latest.Date, PromptVersion: promptVersion - 1, Summary: "x"}},
+	}
+	for name, versions := range malformed {
+		if validateAnalysisVersions(versions) == nil {
+			t.Errorf("accepted a changelog with a %s", name)
+		}
+	}
+}
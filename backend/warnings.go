@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"raads-pdf-backend/streamproto"
+)
+
+// Warning severities. The frontend shows warnings as a banner and notices
+// as a footnote.
+const (
+	severityWarning = "warning"
+	severityNotice  = "notice"
+)
+
+// Warning codes. Each code has a fixed severity, listed in warningCatalog.
+const (
+	warnCommentTruncated     = "comment_truncated"
+	warnAnswerTextReplaced   = "answer_text_replaced"
+	warnUnknownCategory      = "unknown_category"
+	warnRenderFallback       = "render_fallback"
+	warnStreamDeltaOnly      = "stream_delta_only"
+	warnLaTeXGlyphStripped   = "latex_glyph_stripped"
+	warnLaTeXLongToken       = "latex_long_token"
+	warnDerivedValueReplaced = "derived_value_replaced"
+	warnContextTruncated     = "context_truncated"
+	warnProviderFallback     = "provider_fallback"
+	warnTemplateFallback     = "template_fallback"
+	warnStructureInvalid     = "structure_invalid"
+	warnInstructionsRemoved  = "instructions_removed"
+	warnNormsOutOfRange      = "norms_out_of_range"
+)
+
+// warningCatalog documents every warning code the pipeline can emit
+var warningCatalog = map[string]struct {
+	Severity    string
+	Description string
+}{
+	warnCommentTruncated:     {severityNotice, "A comment exceeded the maximum length and was truncated before analysis"},
+	warnAnswerTextReplaced:   {severityNotice, "A submitted answer text did not match its answer value and was replaced by the canonical label"},
+	warnUnknownCategory:      {severityWarning, "A question has a category that does not belong to any RAADS-R domain"},
+	warnRenderFallback:       {severityWarning, "The analysis could not be converted to HTML and is provided as markdown"},
+	warnStreamDeltaOnly:      {severityWarning, "The analysis was too large to be re-rendered and was streamed as raw deltas"},
+	warnLaTeXGlyphStripped:   {severityWarning, "Characters not covered by the PDF fonts were removed; the xelatex engine with fallback fonts keeps them"},
+	warnLaTeXLongToken:       {severityNotice, "An appendix item contained very long words, line breaks were allowed inside them"},
+	warnDerivedValueReplaced: {severityNotice, "A submitted value did not match the value derived from the answers and the catalogs, and was replaced"},
+	warnContextTruncated:     {severityNotice, "The participant-provided context exceeded the maximum length and was truncated before analysis"},
+	warnProviderFallback:     {severityNotice, "The AI provider was unavailable and the analysis was generated by a fallback provider"},
+	warnTemplateFallback:     {severityWarning, "The AI service was unavailable and the analysis only summarizes the scores, it should be generated again later"},
+	warnStructureInvalid:     {severityNotice, "The streamed analysis has missing or extra sections, or tables, that the required structure does not allow"},
+	warnInstructionsRemoved:  {severityNotice, "A comment contained text addressed to the AI rather than about the participant, which was removed before analysis"},
+	warnNormsOutOfRange:      {severityNotice, "No normative sample covers the age of the participant, so percentile ranks use the widest sample instead"},
+}
+
+// Warning is a non-fatal issue encountered while processing a request
+type Warning struct {
+	Code       string `json:"code"`
+	Severity   string `json:"severity"`
+	Display    string `json:"display"`
+	Message    string `json:"message"`
+	QuestionID int    `json:"question_id,omitempty"`
+	Section    string `json:"section,omitempty"`
+}
+
+// WarningCollector gathers the warnings raised while processing a request
+type WarningCollector struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+// Add records a warning, filling its severity from the catalog
+func (w *WarningCollector) Add(warning Warning) {
+	if entry, ok := warningCatalog[warning.Code]; ok {
+		warning.Severity = entry.Severity
+	}
+	warning.Display = "footnote"
+	if warning.Severity == severityWarning {
+		warning.Display = "banner"
+	}
+	log.Printf("⚠️  [%s] %s", warning.Code, warning.Message)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warnings = append(w.warnings, warning)
+}
+
+// List returns the warnings collected so far, never nil
+func (w *WarningCollector) List() []Warning {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Warning{}, w.warnings...)
+}
+
+// warningsMetadata returns warnings as sent in stream events
+func warningsMetadata(warnings []Warning) []streamproto.ServerWarning {
+	metadata := make([]streamproto.ServerWarning, 0, len(warnings))
+	for _, warning := range warnings {
+		metadata = append(metadata, streamproto.ServerWarning(warning))
+	}
+	return metadata
+}
+
+type warningsKey struct{}
+
+// warningsFrom returns the collector attached to the context. Without one,
+// warnings are only logged.
+func warningsFrom(ctx context.Context) *WarningCollector {
+	if w, ok := ctx.Value(warningsKey{}).(*WarningCollector); ok {
+		return w
+	}
+	return &WarningCollector{}
+}
+
+// warningsMiddleware attaches a fresh warning collector to every request
+func warningsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), warningsKey{}, &WarningCollector{})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// REPORT_WATERMARK is stamped diagonally across every page of the PDF
+// reports and shown as a banner at the top of the HTML report, such as
+// "SELF-REPORT – NOT A DIAGNOSIS" or the name of a clinic. Reports have no
+// watermark when it is empty.
+var reportWatermark = strings.TrimSpace(os.Getenv("REPORT_WATERMARK"))
+
+// Longer watermarks are scaled down across the page until they are
+// illegible
+const maxWatermarkLength = 60
+
+// watermarkError reports why the watermark cannot be used, or "" when it
+// can
+func watermarkError() string {
+	switch {
+	case utf8.RuneCountInString(reportWatermark) > maxWatermarkLength:
+		return fmt.Sprintf("REPORT_WATERMARK is longer than %d characters", maxWatermarkLength)
+	case strings.ContainsAny(reportWatermark, "\n\r"):
+		return "REPORT_WATERMARK must be a single line"
+	}
+	return ""
+}
+
+// checkWatermark reports the watermark of the reports, if any
+func checkWatermark() checkResult {
+	result := checkResult{Name: "report watermark", Feature: "pdf", Optional: true}
+	if reason := watermarkError(); reason != "" {
+		result.Detail = reason
+		return result
+	}
+	result.OK = true
+	result.Detail = "reports have no watermark"
+	if reportWatermark != "" {
+		result.Detail = fmt.Sprintf("reports are stamped %q", reportWatermark)
+	}
+	return result
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Behaviors when every worker slot and queue position is taken
+const (
+	queueModeFailFast = "fail_fast"
+	queueModeWait     = "wait"
+)
+
+var (
+	// Generations running concurrently, and requests allowed to wait for one
+	workerPoolSize  = envInt("WORKER_POOL_SIZE", 8)
+	workerQueueSize = envInt("WORKER_QUEUE_SIZE", 16)
+
+	// fail_fast rejects requests as soon as the queue is full, wait lets them
+	// wait up to WORKER_QUEUE_WAIT_SECONDS for a queue position
+	workerQueueMode    = envString("WORKER_QUEUE_MODE", queueModeFailFast)
+	workerQueueMaxWait = time.Duration(envInt("WORKER_QUEUE_WAIT_SECONDS", 10)) * time.Second
+
+	// Assumed generation duration until the first one completes
+	defaultGenerationDuration = 30 * time.Second
+
+	generationsServed atomic.Int64
+	generationsShed   atomic.Int64
+)
+
+var errPoolSaturated = errors.New("worker pool saturated")
+
+// BusyInfo tells a rejected client how long it would have had to wait
+type BusyInfo struct {
+	QueueDepth           int `json:"queue_depth"`
+	Workers              int `json:"workers"`
+	EstimatedWaitSeconds int `json:"estimated_wait_seconds"`
+	RetryAfterSeconds    int `json:"retry_after_seconds"`
+}
+
+// priorityClass orders requests competing for worker slots
+type priorityClass int
+
+// Priority classes, highest first: interactive requests come from a person
+// waiting for their report, batch requests from partner integrations
+const (
+	classInteractive priorityClass = iota
+	classBatch
+)
+
+var priorityClasses = []priorityClass{classInteractive, classBatch}
+
+func (c priorityClass) String() string {
+	if c == classBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// parsePriorityClass parses a class name as used in the configuration
+func parsePriorityClass(name string) (priorityClass, error) {
+	for _, class := range priorityClasses {
+		if class.String() == name {
+			return class, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority class %q", name)
+}
+
+var (
+	// Slots only the given class may use, so that neither starves the other.
+	// At least one slot is always kept for interactive requests.
+	workerReservedInteractive = envInt("WORKER_RESERVED_INTERACTIVE", 2)
+	workerReservedBatch       = envInt("WORKER_RESERVED_BATCH", 1)
+)
+
+// requestPriority returns the priority class of a request: batch for the
+// batch route and for clients whose credential is configured as batch
+func requestPriority(c *gin.Context) priorityClass {
+	if c.FullPath() == "/analyze-batch" {
+		return classBatch
+	}
+	if credential := credentialFrom(c.Request.Context()); credential != nil {
+		return credential.Priority
+	}
+	return classInteractive
+}
+
+// workerPool bounds concurrent generations. Requests beyond the pool size
+// wait in a bounded queue, ordered by priority class then arrival, and are
+// shed once the queue is full.
+type workerPool struct {
+	size  int
+	queue chan struct{}
+
+	mu          sync.Mutex
+	reserved    map[priorityClass]int
+	running     map[priorityClass]int
+	waiting     map[priorityClass][]*queuedRequest
+	waits       map[priorityClass]*queueWaitStats
+	avgDuration time.Duration
+}
+
+// queuedRequest is a request waiting for a slot. ready is closed once a
+// slot has been assigned to it.
+type queuedRequest struct {
+	ready    chan struct{}
+	enqueued time.Time
+}
+
+// queueWaitStats accumulates the time requests of a class spent queued
+type queueWaitStats struct {
+	count int64
+	total time.Duration
+}
+
+var workers = newWorkerPool(workerPoolSize, workerQueueSize, map[priorityClass]int{
+	classInteractive: workerReservedInteractive,
+	classBatch:       workerReservedBatch,
+})
+
+func newWorkerPool(size, queueSize int, reserved map[priorityClass]int) *workerPool {
+	size = max(size, 1)
+	p := &workerPool{
+		size:     size,
+		queue:    make(chan struct{}, max(queueSize, 0)),
+		reserved: map[priorityClass]int{},
+		running:  map[priorityClass]int{},
+		waiting:  map[priorityClass][]*queuedRequest{},
+		waits:    map[priorityClass]*queueWaitStats{},
+	}
+	p.reserved[classInteractive] = min(max(reserved[classInteractive], 1), size)
+	p.reserved[classBatch] = min(max(reserved[classBatch], 0), size-p.reserved[classInteractive])
+	for _, class := range priorityClasses {
+		p.waits[class] = &queueWaitStats{}
+	}
+	return p
+}
+
+// workerSlot is a held worker slot
+type workerSlot struct {
+	pool    *workerPool
+	class   priorityClass
+	started time.Time
+}
+
+// Release frees the slot. Completed generations feed the rolling average
+// used to estimate queue waits.
+func (s *workerSlot) Release(completed bool) {
+	s.pool.mu.Lock()
+	s.pool.running[s.class]--
+	s.pool.dispatch()
+	s.pool.mu.Unlock()
+
+	if completed {
+		s.pool.observe(time.Since(s.started))
+	}
+}
+
+// Acquire takes a worker slot for a request of the given class, queueing
+// behind running generations if needed. It returns errPoolSaturated when no
+// queue position is available.
+func (p *workerPool) Acquire(ctx context.Context, class priorityClass) (*workerSlot, error) {
+	p.mu.Lock()
+	if len(p.waiting[class]) == 0 && p.canRun(class) {
+		p.running[class]++
+		p.mu.Unlock()
+		return p.acquired(class), nil
+	}
+	p.mu.Unlock()
+
+	if err := p.enqueue(ctx); err != nil {
+		if errors.Is(err, errPoolSaturated) {
+			generationsShed.Add(1)
+		}
+		return nil, err
+	}
+	defer func() { <-p.queue }()
+
+	request := &queuedRequest{ready: make(chan struct{}), enqueued: time.Now()}
+	p.mu.Lock()
+	p.waiting[class] = append(p.waiting[class], request)
+	p.dispatch()
+	p.mu.Unlock()
+
+	select {
+	case <-request.ready:
+		p.recordWait(class, time.Since(request.enqueued))
+		return p.acquired(class), nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.dequeue(class, request) {
+			// A slot was assigned while the request gave up
+			p.running[class]--
+			p.dispatch()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *workerPool) acquired(class priorityClass) *workerSlot {
+	generationsServed.Add(1)
+	return &workerSlot{pool: p, class: class, started: time.Now()}
+}
+
+// canRun reports whether a request of the class may start now, leaving
+// enough free slots for the unused reservations of the other classes.
+// Must be called with mu held.
+func (p *workerPool) canRun(class priorityClass) bool {
+	free := p.size
+	for _, c := range priorityClasses {
+		free -= p.running[c]
+	}
+	for _, other := range priorityClasses {
+		if other != class {
+			free -= max(p.reserved[other]-p.running[other], 0)
+		}
+	}
+	return free >= 1
+}
+
+// dispatch hands free slots to queued requests, highest class first and in
+// arrival order within a class. Must be called with mu held.
+func (p *workerPool) dispatch() {
+	for _, class := range priorityClasses {
+		for len(p.waiting[class]) > 0 && p.canRun(class) {
+			request := p.waiting[class][0]
+			p.waiting[class] = p.waiting[class][1:]
+			p.running[class]++
+			close(request.ready)
+		}
+	}
+}
+
+// dequeue removes a request that is still waiting, and reports whether it
+// was. Must be called with mu held.
+func (p *workerPool) dequeue(class priorityClass, request *queuedRequest) bool {
+	for i, queued := range p.waiting[class] {
+		if queued == request {
+			p.waiting[class] = append(p.waiting[class][:i], p.waiting[class][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue takes a queue position, failing immediately or after
+// workerQueueMaxWait depending on the queue mode
+func (p *workerPool) enqueue(ctx context.Context) error {
+	select {
+	case p.queue <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if workerQueueMode != queueModeWait {
+		return errPoolSaturated
+	}
+
+	timer := time.NewTimer(workerQueueMaxWait)
+	defer timer.Stop()
+	select {
+	case p.queue <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errPoolSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *workerPool) recordWait(class priorityClass, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waits[class].count++
+	p.waits[class].total += d
+}
+
+// ClassStats is a snapshot of the pool usage of a priority class
+type ClassStats struct {
+	Class            priorityClass
+	Reserved         int
+	Running          int
+	QueueDepth       int
+	Waits            int64
+	WaitTotalSeconds float64
+}
+
+// Stats returns the usage of every priority class
+func (p *workerPool) Stats() []ClassStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ClassStats, 0, len(priorityClasses))
+	for _, class := range priorityClasses {
+		stats = append(stats, ClassStats{
+			Class:            class,
+			Reserved:         p.reserved[class],
+			Running:          p.running[class],
+			QueueDepth:       len(p.waiting[class]),
+			Waits:            p.waits[class].count,
+			WaitTotalSeconds: p.waits[class].total.Seconds(),
+		})
+	}
+	return stats
+}
+
+// Running returns the number of slots currently held
+func (p *workerPool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	running := 0
+	for _, class := range priorityClasses {
+		running += p.running[class]
+	}
+	return running
+}
+
+// Saturated reports whether every slot is held and the queue is full, so
+// that new requests would be shed
+func (p *workerPool) Saturated() bool {
+	return p.Running() >= p.size && len(p.queue) >= cap(p.queue)
+}
+
+// observe folds a generation duration into an exponential moving average
+func (p *workerPool) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avgDuration == 0 {
+		p.avgDuration = d
+		return
+	}
+	p.avgDuration = (p.avgDuration*4 + d) / 5
+}
+
+// AverageDuration returns the rolling average generation duration
+func (p *workerPool) AverageDuration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avgDuration == 0 {
+		return defaultGenerationDuration
+	}
+	return p.avgDuration
+}
+
+// Busy reports the current queue and the estimated wait for a new request,
+// assuming queued requests are served in waves of the pool size
+func (p *workerPool) Busy() BusyInfo {
+	depth := len(p.queue)
+	size := p.size
+	waves := (depth + size) / size
+	wait := int(math.Ceil(p.AverageDuration().Seconds() * float64(waves)))
+	return BusyInfo{
+		QueueDepth:           depth,
+		Workers:              size,
+		EstimatedWaitSeconds: wait,
+		RetryAfterSeconds:    max(wait, 1),
+	}
+}
+
+// busyResponse returns the payload sent to clients shed by the pool
+func busyResponse(info BusyInfo) gin.H {
+	return gin.H{
+		"error":                  "Server is busy, please retry shortly",
+		"queue_depth":            info.QueueDepth,
+		"workers":                info.Workers,
+		"estimated_wait_seconds": info.EstimatedWaitSeconds,
+		"retry_after_seconds":    info.RetryAfterSeconds,
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits for n requests of a class to be queued in a pool
+func waitQueued(t *testing.T, p *workerPool, class priorityClass, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if p.Stats()[class].QueueDepth == n {
+			return
+		}
+	}
+	t.Fatalf("%d %s requests were not queued", n, class)
+}
+
+// tryAcquire acquires a slot unless none is free, instead of queueing
+func tryAcquire(t *testing.T, p *workerPool, class priorityClass) *workerSlot {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slot, err := p.Acquire(ctx, class)
+	if err != nil && !errors.Is(err, errPoolSaturated) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	return slot
+}
+
+// TestWorkerPoolReservations makes sure each class can only take the slots
+// not reserved to the other, batch requests never taking the last
+// interactive slot
+func TestWorkerPoolReservations(t *testing.T) {
+	tests := []struct {
+		name                       string
+		size                       int
+		reserved                   map[priorityClass]int
+		interactive, batch         int
+		wantInteractive, wantBatch int
+	}{
+		{"batch load", 3, map[priorityClass]int{}, 0, 5, 0, 2},
+		{"interactive load", 4, map[priorityClass]int{classInteractive: 2, classBatch: 1}, 5, 0, 3, 0},
+		{"mixed load", 4, map[priorityClass]int{classInteractive: 2, classBatch: 1}, 5, 5, 3, 1},
+		{"batch load with reservations", 4, map[priorityClass]int{classInteractive: 2, classBatch: 1}, 0, 5, 0, 2},
+		{"no batch reservation", 2, map[priorityClass]int{classInteractive: 2, classBatch: 1}, 5, 5, 2, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newWorkerPool(tc.size, 0, tc.reserved)
+			// Interactive requests arrive first
+			requests := map[priorityClass]int{classInteractive: tc.interactive, classBatch: tc.batch}
+			acquired := map[priorityClass]int{}
+			for _, class := range priorityClasses {
+				for i := 0; i < requests[class]; i++ {
+					if slot := tryAcquire(t, p, class); slot != nil {
+						acquired[class]++
+					}
+				}
+			}
+			if acquired[classInteractive] != tc.wantInteractive || acquired[classBatch] != tc.wantBatch {
+				t.Errorf("%d interactive and %d batch slots acquired instead of %d and %d",
+					acquired[classInteractive], acquired[classBatch], tc.wantInteractive, tc.wantBatch)
+			}
+			if tc.batch > 0 && tc.interactive == 0 && tryAcquire(t, p, classInteractive) == nil {
+				t.Error("batch requests took the last interactive slot")
+			}
+		})
+	}
+}
+
+// TestWorkerPoolQueueOrder makes sure queued requests are served by class,
+// then in arrival order
+func TestWorkerPoolQueueOrder(t *testing.T) {
+	// A slot stays held so that the queued requests are served one at a
+	// time, batch ones only leaving the interactive reservation free
+	p := newWorkerPool(2, 4, map[priorityClass]int{})
+	var held *workerSlot
+	for i := 0; i < 2; i++ {
+		slot, err := p.Acquire(context.Background(), classInteractive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = slot
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(name string, class priorityClass) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slot, err := p.Acquire(context.Background(), class)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			slot.Release(true)
+		}()
+	}
+	queue("batch 1", classBatch)
+	waitQueued(t, p, classBatch, 1)
+	queue("batch 2", classBatch)
+	waitQueued(t, p, classBatch, 2)
+	queue("interactive 1", classInteractive)
+	waitQueued(t, p, classInteractive, 1)
+	queue("interactive 2", classInteractive)
+	waitQueued(t, p, classInteractive, 2)
+
+	held.Release(true)
+	wg.Wait()
+	want := []string{"interactive 1", "interactive 2", "batch 1", "batch 2"}
+	if len(order) != len(want) {
+		t.Fatalf("served %v instead of %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("served %v instead of %v", order, want)
+		}
+	}
+}
+
+// TestWorkerPoolCancelledWaiter makes sure a request giving up while
+// queued frees its queue position, and the slot assigned to it meanwhile
+func TestWorkerPoolCancelledWaiter(t *testing.T) {
+	p := newWorkerPool(1, 1, map[priorityClass]int{})
+	held, err := p.Acquire(context.Background(), classInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error)
+	go func() {
+		_, err := p.Acquire(ctx, classInteractive)
+		gaveUp <- err
+	}()
+	waitQueued(t, p, classInteractive, 1)
+
+	// Assign the held slot to the request after it gave up, but before it
+	// could leave the queue
+	p.mu.Lock()
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	p.running[held.class]--
+	p.dispatch()
+	p.mu.Unlock()
+
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Fatalf("the cancelled request got %v", err)
+	}
+	if running, queued := p.Running(), len(p.queue); running != 0 || queued != 0 {
+		t.Fatalf("the cancelled request holds %d slots and %d queue positions", running, queued)
+	}
+	if slot := tryAcquire(t, p, classInteractive); slot == nil {
+		t.Error("the slot of the cancelled request was not freed")
+	}
+}
+
+// useWorkers makes a pool the worker pool of the deployment for the
+// duration of a test
+func useWorkers(t *testing.T, p *workerPool) {
+	t.Helper()
+	previous, previousMode, previousWait := workers, workerQueueMode, workerQueueMaxWait
+	workers = p
+	t.Cleanup(func() { workers, workerQueueMode, workerQueueMaxWait = previous, previousMode, previousWait })
+}
+
+// TestWorkerPoolBusy makes sure requests shed by a saturated pool are told
+// when to come back, with a 503 or a single busy event for streams, in
+// both queue modes
+func TestWorkerPoolBusy(t *testing.T) {
+	startFakeClaude(t)
+	pool := newWorkerPool(1, 0, map[priorityClass]int{})
+	useWorkers(t, pool)
+	workerQueueMaxWait = 100 * time.Millisecond
+	held, err := pool.Acquire(context.Background(), classInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := answeredAssessment(t, "TestWorkerPoolBusy")
+	headers := map[string]string{"Cache-Control": "no-store"}
+
+	for _, mode := range []string{queueModeFailFast, queueModeWait} {
+		t.Run(mode, func(t *testing.T) {
+			workerQueueMode = mode
+			served, shed := generationsServed.Load(), generationsShed.Load()
+
+			start := time.Now()
+			w := serve(t, "POST", "/analyze", body, headers)
+			response := decodeResponse(t, w, 503)
+			if w.Header().Get("Retry-After") != "30" || response["retry_after_seconds"] != float64(30) || response["workers"] != float64(1) {
+				t.Errorf("unexpected busy response with Retry-After %q: %v", w.Header().Get("Retry-After"), response)
+			}
+			if waited := time.Since(start) >= workerQueueMaxWait; waited != (mode == queueModeWait) {
+				t.Errorf("the request waited for a queue position: %t", waited)
+			}
+
+			w = serve(t, "POST", "/analyze-stream", body, headers)
+			events := readEvents(t, w.Body)
+			if w.Code != 200 || len(events) != 1 || events[0].Name != "busy" {
+				t.Fatalf("the stream is not a single busy event: status %d, %+v", w.Code, events)
+			}
+			if w.Header().Get("Retry-After") != "30" || !strings.Contains(events[0].Data, `"retry_after_seconds":30`) {
+				t.Errorf("unexpected busy event with Retry-After %q: %s", w.Header().Get("Retry-After"), events[0].Data)
+			}
+
+			if n := generationsShed.Load() - shed; n != 2 {
+				t.Errorf("%d requests counted as shed instead of 2", n)
+			}
+			if n := generationsServed.Load() - served; n != 0 {
+				t.Errorf("%d shed requests counted as served", n)
+			}
+		})
+	}
+
+	held.Release(false)
+	served, shed := generationsServed.Load(), generationsShed.Load()
+	decodeResponse(t, serve(t, "POST", "/analyze", body, headers), 200)
+	if generationsServed.Load()-served != 1 || generationsShed.Load() != shed {
+		t.Errorf("the request served once the slot was free counted %d served and %d shed", generationsServed.Load()-served, generationsShed.Load()-shed)
+	}
+}
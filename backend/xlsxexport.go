@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxSheet is a worksheet of a workbook. Cells are strings, ints or
+// float64s, nil for an empty cell, and the first row is the header.
+type xlsxSheet struct {
+	Name   string
+	Widths []float64
+	Rows   [][]any
+}
+
+// xlsxColumn is the letter of a column, from A for 0
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxEscape writes text as XML. Characters XML cannot hold are replaced.
+func xlsxEscape(s string) string {
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(s))
+	return out.String()
+}
+
+// xml renders the worksheet. Text is written as inline strings, which
+// spreadsheets never read as formulas, so no cell needs spreadsheetSafe.
+func (s xlsxSheet) xml() []byte {
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	out.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	// The header row stays visible while scrolling
+	out.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	if len(s.Widths) > 0 {
+		out.WriteString("<cols>")
+		for i, width := range s.Widths {
+			fmt.Fprintf(&out, `<col min="%d" max="%d" width="%g" customWidth="1"/>`, i+1, i+1, width)
+		}
+		out.WriteString("</cols>")
+	}
+	out.WriteString("<sheetData>")
+	for r, row := range s.Rows {
+		fmt.Fprintf(&out, `<row r="%d">`, r+1)
+		style := ""
+		if r == 0 {
+			style = ` s="1"`
+		}
+		for c, value := range row {
+			ref := xlsxColumn(c) + strconv.Itoa(r+1)
+			switch v := value.(type) {
+			case string:
+				fmt.Fprintf(&out, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xlsxEscape(v))
+			case int:
+				fmt.Fprintf(&out, `<c r="%s"%s><v>%d</v></c>`, ref, style, v)
+			case float64:
+				fmt.Fprintf(&out, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+		out.WriteString("</row>")
+	}
+	out.WriteString("</sheetData></worksheet>")
+	return out.Bytes()
+}
+
+// xlsxStyles has the default cell style, and a bold one for headers
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+// xlsxPart is a file of the workbook package
+type xlsxPart struct {
+	name    string
+	content []byte
+}
+
+// writeXLSX writes the sheets as an Office Open XML workbook. Every part
+// carries the same time, so that the same sheets always produce the same
+// file.
+func writeXLSX(w io.Writer, sheets []xlsxSheet, modified time.Time) error {
+	var contentTypes, workbook, workbookRels strings.Builder
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	workbookRels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(sheet.Name), i+1, i+1)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	contentTypes.WriteString("</Types>")
+	workbook.WriteString("</sheets></workbook>")
+	workbookRels.WriteString("</Relationships>")
+
+	parts := []xlsxPart{
+		{"[Content_Types].xml", []byte(contentTypes.String())},
+		{"_rels/.rels", []byte(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`)},
+		{"xl/workbook.xml", []byte(workbook.String())},
+		{"xl/_rels/workbook.xml.rels", []byte(workbookRels.String())},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, xlsxPart{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.xml()})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: part.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+		if _, err := fw.Write(part.content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// assessmentSheets lays out an assessment as a summary of its scores, the
+// answer to every question and, when included, the comments
+func assessmentSheets(data AssessmentData, includeComments bool) []xlsxSheet {
+	instrument := instrumentOf(data)
+
+	summary := xlsxSheet{
+		Name:   "Summary",
+		Widths: []float64{24, 32, 10, 10, 10},
+		Rows: [][]any{
+			{"field", "value"},
+			{"instrument", instrument.Test.Name},
+			{"language", data.Language},
+			{"test_date", data.Metadata.LocalTestDate().Format("2006-01-02")},
+			{"answered_questions", data.Metadata.AnsweredQuestions},
+			{"total_questions", data.Metadata.TotalQuestions},
+			{"interpretation", data.Interpretation.Level},
+			{"reference_profile", referenceProfileMetadata(data).Key},
+			{},
+			{"scale", "label", "score", "max", "threshold"},
+		},
+	}
+	for _, score := range fhirScores(data) {
+		var threshold any
+		if score.Threshold > 0 {
+			threshold = score.Threshold
+		}
+		summary.Rows = append(summary.Rows, []any{score.Key, score.Label, score.Score, score.Max, threshold})
+	}
+
+	answers := xlsxSheet{
+		Name:   "Answers",
+		Widths: []float64{6, 14, 80, 8, 24, 8},
+		Rows:   [][]any{{"id", "domain", "question", "answer", "answer_text", "score"}},
+	}
+	comments := xlsxSheet{
+		Name:   "Comments",
+		Widths: []float64{6, 14, 80, 80},
+		Rows:   [][]any{{"id", "domain", "question", "comment"}},
+	}
+	for _, qa := range data.QuestionsAndAnswers {
+		group := answerGroup(instrument, qa)
+		var answer any
+		if qa.AnswerText != "" {
+			answer = qa.Answer
+		}
+		answers.Rows = append(answers.Rows, []any{qa.ID, group, qa.Text, answer, qa.AnswerText, qa.Score})
+		if qa.Comment != nil && strings.TrimSpace(*qa.Comment) != "" {
+			comments.Rows = append(comments.Rows, []any{qa.ID, group, qa.Text, *qa.Comment})
+		}
+	}
+
+	sheets := []xlsxSheet{summary, answers}
+	if includeComments {
+		sheets = append(sheets, comments)
+	}
+	return sheets
+}
+
+// renderAssessmentXLSX renders the workbook of an assessment, dated with
+// its test date
+func renderAssessmentXLSX(data AssessmentData, includeComments bool) ([]byte, error) {
+	var out bytes.Buffer
+	if err := writeXLSX(&out, assessmentSheets(data, includeComments), data.Metadata.TestDate); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// exportXLSXHandler exports the scores, answers and comments of an
+// assessment as an Excel workbook, for researchers who want structured data
+func exportXLSXHandler(c *gin.Context) {
+	var req ExportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Invalid JSON data: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid JSON data: " + err.Error()})
+		return
+	}
+
+	if err := validateAssessmentData(c.Request.Context(), &req.Assessment); err != nil {
+		log.Printf("❌ Invalid assessment data: %v", err)
+		c.JSON(400, invalidAssessment(err))
+		return
+	}
+	if err := applyDomainReport(&req); err != nil {
+		c.JSON(exportSourceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := renderAssessmentXLSX(req.Assessment, req.IncludeComments == nil || *req.IncludeComments)
+	if err != nil {
+		log.Printf("❌ Error rendering XLSX export: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render XLSX export: " + err.Error()})
+		return
+	}
+
+	reportID := uuid.New().String()
+	log.Printf("📦 Exporting %d answers as XLSX %s", len(req.Assessment.QuestionsAndAnswers), reportID)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-report-%s.xlsx"`, instrumentOf(req.Assessment).Key, reportID))
+	c.Data(200, xlsxContentType, content)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenderAssessmentXLSX makes sure every part of the workbook is
+// well-formed XML, whatever the answers and comments contain
+func TestRenderAssessmentXLSX(t *testing.T) {
+	comment := "Sample <comment> & \x01 control"
+	data := AssessmentData{
+		Language: "en",
+		Metadata: Metadata{TestName: raadsR.Name, TestDate: time.Now()},
+		QuestionsAndAnswers: []QuestionAndAnswer{
+			{ID: 1, Text: "=Sample", Category: "IS", AnswerText: "Never true", Comment: &comment},
+		},
+	}
+	content, err := renderAssessmentXLSX(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	var sheets string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, _ := io.ReadAll(rc)
+		rc.Close()
+		if strings.HasPrefix(f.Name, "xl/worksheets/") {
+			sheets += string(part)
+		}
+		decoder := xml.NewDecoder(bytes.NewReader(part))
+		for err == nil {
+			_, err = decoder.Token()
+		}
+		if err != io.EOF {
+			t.Errorf("%s is not well-formed: %v", f.Name, err)
+		}
+	}
+	if strings.Contains(sheets, "<f>") {
+		t.Error("a cell is written as a formula")
+	}
+}
@@ -2,60 +2,90 @@
 
 // ReportTemplate class definition for the report window
 class ReportTemplate {
-    // Generate chart HTML
-    static generateChart(assessmentData) {
-        const scores = assessmentData.scores;
-        
-        // Maximum scores for each domain
-        const maxScores = {
-            social: 117,    // 39 questions × 3 points
-            language: 21,   // 7 questions × 3 points  
-            sensory: 60,    // 20 questions × 3 points
-            restricted: 42, // 14 questions × 3 points
-            total: 240      // Total maximum
-        };
+    // Get API base URL (same logic as in index.html)
+    static apiBase() {
+        return window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1'
+            ? 'http://localhost:8080'
+            : 'https://raads-pdf-service-3n4fdvjefq-oa.a.run.app';
+    }
 
-        // Thresholds and averages
-        const thresholds = { social: 31, language: 4, sensory: 16, restricted: 15, total: 65 };
-        const averages = { social: 11, language: 2, sensory: 6, restricted: 8, total: 25 };
+    // Token identifying this browser to the backend, which only lets the
+    // client an analysis was started for resume its stream
+    static clientToken() {
+        let token = localStorage.getItem('raads-client-token');
+        if (!token) {
+            token = crypto.randomUUID();
+            localStorage.setItem('raads-client-token', token);
+        }
+        return token;
+    }
 
-        const domains = [
-            { key: 'social', label: 'social' },
-            { key: 'language', label: 'language' },
-            { key: 'sensory', label: 'sensory' },
-            { key: 'restricted', label: 'restricted' },
-            { key: 'total', label: 'total' }
-        ];
+    // Chart series with normalized scores and markers, as computed by the
+    // backend /chart-data endpoint, which holds the norms
+    static chartSeries(assessmentData) {
+        return { domains: assessmentData.chart.domains, total: assessmentData.chart.total };
+    }
 
-        let chartHTML = '';
-        domains.forEach(domain => {
-            const score = domain.key === 'total' ? scores.total : scores[domain.key];
-            const maxScore = maxScores[domain.key];
-            const threshold = thresholds[domain.key];
-            const average = averages[domain.key];
+    // Fetch the chart data from the backend and draw the charts with it
+    static async loadChartData(assessmentData) {
+        try {
+            const response = await fetch(`${this.apiBase()}/chart-data`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(assessmentData)
+            });
+            if (!response.ok) {
+                throw new Error(`HTTP error! status: ${response.status}`);
+            }
+            assessmentData.chart = await response.json();
+            this.renderCharts(assessmentData);
+        } catch (error) {
+            console.warn('Failed to load the chart data:', error);
+            this.renderChartPlaceholders('<div style="color: #f39c12; text-align: center; padding: 40px;">Charts are unavailable, please reload the report.</div>');
+        }
+    }
 
-            // Calculate container height proportional to max score (total=240 gets full 380px)
+    // Generate and insert both charts, once the backend chart data is there
+    static renderCharts(assessmentData) {
+        if (!assessmentData.chart) {
+            this.renderChartPlaceholders('<div class="analysis-loading">Loading charts...</div>');
+            return;
+        }
+        document.getElementById('bar-chart-container').innerHTML = this.generateChart(assessmentData);
+        document.getElementById('radar-chart-container').innerHTML = this.generateRadarChart(assessmentData);
+    }
+
+    // Show the same placeholder in place of both charts
+    static renderChartPlaceholders(html) {
+        document.getElementById('bar-chart-container').innerHTML = html;
+        document.getElementById('radar-chart-container').innerHTML = html;
+    }
+
+    // Generate chart HTML
+    static generateChart(assessmentData) {
+        const { domains, total } = this.chartSeries(assessmentData);
+
+        let chartHTML = '';
+        [...domains, total].forEach(domain => {
+            // Calculate container height proportional to max score (total gets full 380px)
             const baseHeight = 380;
-            const containerHeight = Math.round((maxScore / maxScores.total) * baseHeight);
+            const containerHeight = Math.round((domain.max / total.max) * baseHeight);
             
-            // Calculate bar height as percentage of this domain's container
-            const barHeight = Math.round((score / maxScore) * containerHeight);
-            const thresholdHeight = Math.round((threshold / maxScore) * containerHeight);
-            const averageHeight = Math.round((average / maxScore) * containerHeight);
-            
-            // Calculate bottom positions for markers (from bottom of container)
-            const thresholdBottom = thresholdHeight;
-            const averageBottom = averageHeight;
+            // Bar and markers are positioned from the normalized values
+            const barHeight = Math.round(domain.normalized * containerHeight);
+            const thresholdBottom = Math.round(domain.threshold.normalized * containerHeight);
+            const averageBottom = Math.round(domain.typical.normalized * containerHeight);
+            const label = domain.label || this.getTranslatedText(domain.key === 'total' ? 'ui.results.totalScore' : `ui.results.categories.${domain.key}`, domain.key);
 
             chartHTML += `
                 <div class="chart-item">
-                    <div class="chart-label">${this.getTranslatedText(domain.key === 'total' ? 'ui.results.totalScore' : `ui.results.categories.${domain.key}`, domain.label)}</div>
+                    <div class="chart-label">${label}</div>
                     <div class="chart-container-inner" style="height: ${containerHeight}px;">
-                        <div class="max-score-label">${maxScore}</div>
-                        <div class="score-bar" style="height: ${barHeight}px;" title="Score: ${score}/${maxScore} (${(score/maxScore*100).toFixed(1)}%)" data-height="${barHeight}"></div>
-                        <div class="threshold-marker" style="bottom: ${thresholdBottom}px;" data-label="${threshold}"></div>
-                        <div class="average-marker" style="bottom: ${averageBottom}px;" data-label="${average}"></div>
-                        <div class="score-display">${score}</div>
+                        <div class="max-score-label">${domain.max}</div>
+                        <div class="score-bar" style="height: ${barHeight}px;" title="Score: ${domain.score}/${domain.max} (${(domain.normalized*100).toFixed(1)}%)" data-height="${barHeight}"></div>
+                        <div class="threshold-marker" style="bottom: ${thresholdBottom}px;" data-label="${domain.threshold.value}"></div>
+                        <div class="average-marker" style="bottom: ${averageBottom}px;" data-label="${domain.typical.value}"></div>
+                        <div class="score-display">${domain.score}</div>
                     </div>
                 </div>
             `;
@@ -66,15 +96,8 @@ class ReportTemplate {
 
     // Generate radar chart HTML
     static generateRadarChart(assessmentData) {
-        const scores = assessmentData.scores;
-        
-        // Define domains for radar chart (excluding total)
-        const domains = [
-            { key: 'social', label: 'Social Interactions', score: scores.social, max: 117, threshold: 31, average: 11 },
-            { key: 'language', label: 'Communication', score: scores.language, max: 21, threshold: 4, average: 2 },
-            { key: 'sensory', label: 'Sensory/Motor', score: scores.sensory, max: 60, threshold: 16, average: 6 },
-            { key: 'restricted', label: 'Restricted Interests', score: scores.restricted, max: 42, threshold: 15, average: 8 }
-        ];
+        // Domains for radar chart (excluding total)
+        const domains = this.chartSeries(assessmentData).domains;
 
         const centerX = 200;
         const centerY = 200;
@@ -92,9 +115,9 @@ class ReportTemplate {
         };
 
         // Calculate radius for each domain based on percentage of max
-        const scoreRadii = domains.map(d => (d.score / d.max) * maxRadius);
-        const thresholdRadii = domains.map(d => (d.threshold / d.max) * maxRadius);
-        const averageRadii = domains.map(d => (d.average / d.max) * maxRadius);
+        const scoreRadii = domains.map(d => d.normalized * maxRadius);
+        const thresholdRadii = domains.map(d => d.threshold.normalized * maxRadius);
+        const averageRadii = domains.map(d => d.typical.normalized * maxRadius);
 
         // Generate background grid circles with better spacing
         const gridCircles = [0.25, 0.5, 0.75, 1.0].map(ratio => 
@@ -135,7 +158,7 @@ class ReportTemplate {
             }
             
             // Get translated text and create wrapped label
-            const translatedLabel = this.getTranslatedText(`ui.results.categories.${domain.key}`, domain.label);
+            const translatedLabel = domain.label || this.getTranslatedText(`ui.results.categories.${domain.key}`, domain.key);
             const wrappedLabel = this.createWrappedLabel(translatedLabel, labelX, labelY, textAnchor, dominantBaseline);
             
             axisLabels += wrappedLabel;
@@ -187,7 +210,7 @@ class ReportTemplate {
                             const x = centerX + Math.cos(angle) * radius;
                             const y = centerY + Math.sin(angle) * radius;
                             return `<circle cx="${x}" cy="${y}" r="4" fill="#3498db" stroke="#fff" stroke-width="2" class="score-point">
-                                        <title>${domain.label}: ${domain.score}/${domain.max} (${(domain.score/domain.max*100).toFixed(1)}%)</title>
+                                        <title>${domain.label || domain.key}: ${domain.score}/${domain.max} (${(domain.normalized*100).toFixed(1)}%)</title>
                                     </circle>`;
                         }).join('')}
                         
@@ -200,13 +223,13 @@ class ReportTemplate {
                 <div class="radar-scores-sidebar">
                     <div class="radar-scores-title" tabindex="0">${this.getTranslatedText('report.domain_scores', 'Domain Scores')}</div>
                     ${domains.map(domain => {
-                        const translatedLabel = this.getTranslatedText(`ui.results.categories.${domain.key}`, domain.label);
+                        const translatedLabel = domain.label || this.getTranslatedText(`ui.results.categories.${domain.key}`, domain.key);
                         return `
                         <div class="radar-score-item-sidebar" tabindex="0">
                             <div class="radar-score-label-sidebar">${translatedLabel}</div>
                             <div class="radar-score-details">
                                 <div class="radar-score-value-sidebar">${domain.score}/${domain.max}</div>
-                                <div class="radar-score-percent-sidebar">${(domain.score/domain.max*100).toFixed(1)}%</div>
+                                <div class="radar-score-percent-sidebar">${(domain.normalized*100).toFixed(1)}%</div>
                             </div>
                         </div>`;
                     }).join('')}
@@ -464,12 +487,10 @@ class ReportTemplate {
         // Populate the enhanced total score card
         this.populateTotalScoreCard(assessmentData);
 
-        // Generate and insert both charts
-        const barChartHTML = this.generateChart(assessmentData);
-        const radarChartHTML = this.generateRadarChart(assessmentData);
-        
-        document.getElementById('bar-chart-container').innerHTML = barChartHTML;
-        document.getElementById('radar-chart-container').innerHTML = radarChartHTML;
+        // Draw the charts with the backend chart data, with a placeholder
+        // until it is loaded
+        this.renderCharts(assessmentData);
+        this.loadChartData(assessmentData);
         
         // Initialize chart toggle functionality
         this.initializeChartToggle(assessmentData);
@@ -530,6 +551,61 @@ class ReportTemplate {
         }
     }
 
+    // Render streamed markdown the server sent without HTML, with the subset
+    // of Markdown analyses use: headings, lists, emphasis and paragraphs.
+    // The text is escaped before any markup is added.
+    static renderMarkdown(markdown) {
+        const escape = text => text
+            .replace(/&/g, '&amp;')
+            .replace(/</g, '&lt;')
+            .replace(/>/g, '&gt;')
+            .replace(/"/g, '&quot;');
+        const inline = text => escape(text)
+            .replace(/\*\*(.+?)\*\*/g, '<strong>$1</strong>')
+            .replace(/\*(.+?)\*/g, '<em>$1</em>')
+            .replace(/`(.+?)`/g, '<code>$1</code>');
+
+        const html = [];
+        let paragraph = [];
+        let list = null;
+        const close = () => {
+            if (paragraph.length) {
+                html.push(`<p>${inline(paragraph.join(' '))}</p>`);
+                paragraph = [];
+            }
+            if (list) {
+                html.push(`</${list}>`);
+                list = null;
+            }
+        };
+        for (const line of markdown.split('\n')) {
+            const heading = line.match(/^(#{1,6})\s+(.*)$/);
+            const item = line.match(/^\s*([-*+]|\d+\.)\s+(.*)$/);
+            if (heading) {
+                close();
+                const level = heading[1].length;
+                html.push(`<h${level}>${inline(heading[2])}</h${level}>`);
+            } else if (item) {
+                const tag = /\d/.test(item[1]) ? 'ol' : 'ul';
+                if (list !== tag) {
+                    close();
+                    list = tag;
+                    html.push(`<${tag}>`);
+                }
+                html.push(`<li>${inline(item[2])}</li>`);
+            } else if (!line.trim()) {
+                close();
+            } else {
+                if (list) {
+                    close();
+                }
+                paragraph.push(line.trim());
+            }
+        }
+        close();
+        return html.join('\n');
+    }
+
     // Process question links for a specific language
     static processQuestionLinks(result, language = 'en') {
         // Apply language-specific patterns
@@ -859,38 +935,34 @@ function initializeParticipantInfo() {
     document.querySelectorAll('.participant-age').forEach(el => el.textContent = age + ' years');
 }
 
+// Error reported by the server on a stream, which resuming would not fix
+class StreamEventError extends Error {}
+
 // Direct streaming function for report.html
 async function startDirectStreaming(assessmentData, reportId) {
-    // Get API base URL (same logic as in index.html)
-    const API_BASE = window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1'
-        ? 'http://localhost:8080'
-        : 'https://raads-pdf-service-3n4fdvjefq-oa.a.run.app';
+    const API_BASE = ReportTemplate.apiBase();
+    // SSE event schema this bundle understands, refused by servers that no longer speak it
+    const STREAM_PROTOCOL_VERSION = 1;
+    // How many times a dropped stream is resumed before falling back to polling
+    const MAX_RESUMES = 3;
     
-    try {
-        console.log('Starting direct streaming to:', `${API_BASE}/analyze-stream`);
-        
-        const response = await fetch(`${API_BASE}/analyze-stream`, {
-            method: 'POST',
-            headers: {
-                'Content-Type': 'application/json',
-            },
-            body: JSON.stringify(assessmentData)
-        });
-        
-        if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
-        }
-        
+    let finalAnalysisHTML = '';
+    let analysisMarkdown = '';
+    // ID of the last event received, from which a dropped stream is resumed
+    let lastEventId = '';
+    let completed = false;
+    
+    // Read the events of a response until the stream completes or drops
+    const readStream = async (response) => {
         const reader = response.body.getReader();
         const decoder = new TextDecoder();
-        let finalAnalysisHTML = '';
         let buffer = '';
         
-        while (true) {
+        while (!completed) {
             const { done, value } = await reader.read();
             if (done) break;
             
-            const chunk = decoder.decode(value);
+            const chunk = decoder.decode(value, { stream: true });
             buffer += chunk;
             
             // Process complete events in the buffer
@@ -902,35 +974,50 @@ async function startDirectStreaming(assessmentData, reportId) {
                 
                 console.log('Processing event:', event);
                 
-                // Parse SSE format: "event: chunk\ndata: {...}"
+                // Parse SSE format: "id: ...\nevent: chunk\ndata: {...}"
                 const lines = event.split('\n');
                 let eventType = '';
                 let eventData = '';
                 
                 for (const line of lines) {
-                    if (line.startsWith('event:')) {
+                    if (line.startsWith('id:')) {
+                        lastEventId = line.slice(3).trim();
+                    } else if (line.startsWith('event:')) {
                         eventType = line.slice(6).trim();
                     } else if (line.startsWith('data:')) {
                         eventData = line.slice(5).trim();
                     }
                 }
                 
+                // The server cancels the generation itself when nobody resumes
+                // its stream for a while after the page is left
                 if (eventType === 'chunk' && eventData) {
                     try {
                         const parsed = JSON.parse(eventData);
                         
-                        if (parsed.html) {
-                            finalAnalysisHTML = parsed.html;
-                            console.log('Direct streaming chunk - HTML length:', parsed.html.length);
+                        // Past its buffer cap, the server only sends what was
+                        // added to the markdown, and no HTML
+                        if (parsed.delta_only) {
+                            analysisMarkdown += parsed.delta || '';
+                        } else if (parsed.markdown) {
+                            analysisMarkdown = parsed.markdown;
+                        }
+
+                        // The server sends the markdown alone when it fails to
+                        // convert it, which is then rendered here
+                        const html = parsed.html || (analysisMarkdown ? ReportTemplate.renderMarkdown(analysisMarkdown) : '');
+                        if (html) {
+                            finalAnalysisHTML = html;
+                            console.log('Direct streaming chunk - HTML length:', html.length);
                             
                             // Update the UI immediately
-                            ReportTemplate.updateAnalysis(parsed.html);
+                            ReportTemplate.updateAnalysis(html);
 
                             // Update localStorage for consistency
                             const reportData = localStorage.getItem(`raads-report-${reportId}`);
                             if (reportData) {
                                 const report = JSON.parse(reportData);
-                                report.analysisHTML = parsed.html;
+                                report.analysisHTML = html;
                                 localStorage.setItem(`raads-report-${reportId}`, JSON.stringify(report));
                             }
                         }
@@ -939,6 +1026,7 @@ async function startDirectStreaming(assessmentData, reportId) {
                     }
                 } else if (eventType === 'complete' || eventData === '[DONE]') {
                     console.log('✅ Direct streaming completed - Final content length:', finalAnalysisHTML ? finalAnalysisHTML.length : 'null');
+                    completed = true;
                     
                     // Mark streaming as complete in localStorage
                     const reportData = localStorage.getItem(`raads-report-${reportId}`);
@@ -948,18 +1036,61 @@ async function startDirectStreaming(assessmentData, reportId) {
                         report.isStreaming = false;
                         localStorage.setItem(`raads-report-${reportId}`, JSON.stringify(report));
                     }
-                    
+
                     // Enable print button
                     ReportTemplate.enablePrintButton();
                     break;
+                } else if (eventType === 'busy') {
+                    const busyData = JSON.parse(eventData);
+                    throw new StreamEventError(`Server busy, retry in ${busyData.retry_after_seconds}s`);
                 } else if (eventType === 'error') {
+                    let message = 'Unknown streaming error';
                     try {
-                        const errorData = JSON.parse(eventData);
-                        throw new Error(errorData.error || 'Streaming error');
+                        message = JSON.parse(eventData).error || 'Streaming error';
                     } catch (parseError) {
-                        throw new Error('Unknown streaming error');
+                        // Keep the generic message
                     }
+                    throw new StreamEventError(message);
+                }
+            }
+        }
+    };
+    
+    try {
+        console.log('Starting direct streaming to:', `${API_BASE}/analyze-stream`);
+        
+        for (let attempt = 0; !completed; attempt++) {
+            try {
+                const headers = {
+                    'Content-Type': 'application/json',
+                    'X-Client-Token': ReportTemplate.clientToken()
+                };
+                // The server replays the events missed since the last one
+                // received rather than generating the analysis again
+                if (lastEventId) {
+                    headers['Last-Event-ID'] = lastEventId;
+                }
+                const response = await fetch(`${API_BASE}/analyze-stream?protocol_version=${STREAM_PROTOCOL_VERSION}`, {
+                    method: 'POST',
+                    headers,
+                    body: JSON.stringify(assessmentData)
+                });
+                
+                if (!response.ok) {
+                    throw new StreamEventError(`HTTP error! status: ${response.status}`);
+                }
+                
+                await readStream(response);
+                if (!completed) {
+                    throw new Error('Stream ended before the analysis was complete');
+                }
+            } catch (error) {
+                // Only a dropped connection is resumed, not an error of the server
+                if (error instanceof StreamEventError || !lastEventId || attempt >= MAX_RESUMES) {
+                    throw error;
                 }
+                console.warn(`Stream dropped, resuming after event ${lastEventId}:`, error);
+                await new Promise(resolve => setTimeout(resolve, 1000 * (attempt + 1)));
             }
         }
         